@@ -3,15 +3,37 @@ const bodyParser  = require('body-parser');
 const fileUpload  = require('express-fileupload');
 const compression = require('compression');
 const config      = require('./lib/config');
+const error       = require('./lib/error');
+const errorReport = require('./lib/error-report');
+const denialStats = require('./lib/denial-stats');
+const helpers     = require('./lib/helpers');
+const i18n        = require('./lib/i18n');
 const log         = require('./logger').express;
 
 /**
  * App
  */
 const app = express();
+
+app.use(require('./lib/express/request-id')());
+
+// /v1 and /v2 are taken off the path here, everything after only sees the route
+app.use(require('./lib/express/api-version')());
+
+// Everything done for a request is cancelled when it times out or the client goes away
+app.use(require('./lib/express/request-timeout')());
+
+// Everything done for a request is traced under it, when there's a collector
+app.use(require('./lib/express/tracing')());
+
+// The limits for each route are checked from the headers first, the parsers' own limits are only a backstop
+app.use(require('./lib/express/body-limits')());
 app.use(fileUpload());
-app.use(bodyParser.json());
-app.use(bodyParser.urlencoded({extended: true}));
+app.use(bodyParser.json({limit: '10mb'}));
+app.use(bodyParser.urlencoded({extended: true, limit: '10mb'}));
+
+// The parsers carry on outside of the request's abort signal and span, so it's entered again
+app.use(require('./lib/express/resume-context')());
 
 // Gzip
 app.use(compression());
@@ -21,7 +43,9 @@ app.use(compression());
  */
 
 app.disable('x-powered-by');
-app.enable('trust proxy', ['loopback', 'linklocal', 'uniquelocal']);
+// Only our own nginx, which sets X-Forwarded-For to the address of the client. Anything else connecting to the
+// backend could say it's any address, so req.ip is the peer's.
+app.set('trust proxy', 'loopback');
 app.enable('strict routing');
 
 // pretty print JSON when not live
@@ -52,6 +76,8 @@ app.use(function (req, res, next) {
 });
 
 app.use(require('./lib/express/jwt')());
+app.use(require('./lib/express/read-only'));
+app.use(require('./lib/express/schema-validator')());
 app.use('/', require('./routes/main'));
 
 // production error handler
@@ -59,14 +85,56 @@ app.use('/', require('./routes/main'));
 // eslint-disable-next-line
 app.use(function (err, req, res, next) {
 
+	// The request timed out or part of the response was sent, there's no answering it again
+	if (res.headersSent) {
+		log.debug('Error after the response was sent: ' + err.message);
+		if (!res.writableEnded) {
+			res.destroy();
+		}
+		return;
+	}
+
+	// A bug, ie: a TypeError, rather than an error the code raised or a command that failed
+	const unexpected = !err.public && (typeof err.status === 'undefined' || err.status >= 500) && !(err instanceof error.CommandError);
+
 	let payload = {
 		error: {
-			code:    err.status,
-			message: err.public ? err.message : 'Internal Error'
+			code:       err.status || 500,
+			message:    i18n.translate(err.public ? err.message : 'Internal Error', res.locals.access ? res.locals.access.getLocale() : null),
+			request_id: res.locals.request_id
 		}
 	};
 
-	if (config.debug() || (req.baseUrl + req.path).includes('nginx/certificates')) {
+	if (err.public && err.fields) {
+		payload.error.fields = err.fields;
+	}
+
+	if (err.public && err.conflict) {
+		payload.error.conflict = err.conflict;
+	}
+
+	// What the CA or the DNS provider said was wrong with a certificate request
+	if (err.public && err.problem) {
+		payload.error.problem = err.problem;
+	}
+
+	// Tells apart errors with the same status, ie: a 403 for read only mode from one for permissions
+	if (err.public && err.reason) {
+		payload.error.reason = err.reason;
+	}
+
+	// The permission a user was denied and what they'd need for it, so it can be put right from the UI
+	if (err.public && err.denied) {
+		payload.error.denied = err.denied;
+		denialStats.record({
+			user_id:    res.locals.access ? res.locals.access.token.getUserId(0) : 0,
+			route:      req.method + ' ' + helpers.getRoutePath(req),
+			permission: err.denied.permission,
+			missing:    err.denied.missing
+		});
+	}
+
+	if (config.debug() || ((req.baseUrl + req.path).includes('nginx/certificates') && !unexpected)) {
 		payload.debug = {
 			stack:    typeof err.stack !== 'undefined' && err.stack ? err.stack.split('\n') : null,
 			previous: err.previous
@@ -74,7 +142,9 @@ app.use(function (err, req, res, next) {
 	}
 
 	// Not every error is worth logging - but this is good for now until it gets annoying.
-	if (typeof err.stack !== 'undefined' && err.stack) {
+	if (unexpected) {
+		errorReport.unhandled(err, {request_id: res.locals.request_id, req: req});
+	} else if (typeof err.stack !== 'undefined' && err.stack) {
 		if (config.debug()) {
 			log.debug(err.stack);
 		} else if (typeof err.public == 'undefined' || !err.public) {
@@ -1,9 +1,51 @@
-const config = require('./lib/config');
+const config         = require('./lib/config');
+const error          = require('./lib/error');
+const logger         = require('./logger').database;
+const queryStats     = require('./lib/query-stats');
+const readCache      = require('./lib/read-cache');
+const requestContext = require('./lib/request-context');
+const tracing        = require('./lib/tracing');
+
+// What knex reports when it drops a connection that reached its lifetime
+const LIFETIME_REACHED = 'Lifetime reached';
 
 if (!config.has('database')) {
 	throw new Error('Database config does not exist! Please read the instructions: https://nginxproxymanager.com/setup/');
 }
 
+/**
+ * Pool sizes and timeouts from the backend settings, when they're set. Sqlite always uses a single connection.
+ *
+ * @returns {Object|undefined}
+ */
+function generatePoolConfig() {
+	const pool = config.getSetting('database_pool');
+	if (config.isSqlite() || Object.keys(pool).every((key) => pool[key] === null)) {
+		return undefined;
+	}
+
+	let result = {};
+	if (pool.min !== null) {
+		result.min = pool.min;
+	}
+	if (pool.max !== null) {
+		result.max = pool.max;
+	}
+	if (pool.idle_timeout !== null) {
+		result.idleTimeoutMillis = pool.idle_timeout * 1000;
+	}
+	if (pool.lifetime !== null) {
+		// Connections knex has marked as disposed are closed instead of being used again
+		result.afterCreate = (connection, done) => {
+			setTimeout(() => {
+				connection.__knex__disposed = LIFETIME_REACHED;
+			}, pool.lifetime * 1000).unref();
+			done(null, connection);
+		};
+	}
+	return result;
+}
+
 function generateDbConfig() {
 	const cfg = config.get('database');
 	if (cfg.engine === 'knex-native') {
@@ -18,10 +60,55 @@ function generateDbConfig() {
 			database: cfg.name,
 			port:     cfg.port
 		},
+		pool:       generatePoolConfig(),
 		migrations: {
 			tableName: 'migrations'
+		},
+		log:        {
+			warn:      (message) => {
+				if (message.indexOf(LIFETIME_REACHED) === -1) {
+					logger.warn(message);
+				}
+			},
+			error:     (message) => logger.error(message),
+			deprecate: (message) => logger.warn(message),
+			debug:     (message) => logger.debug(message)
 		}
 	};
 }
 
-module.exports = require('knex')(generateDbConfig());
+const db = require('knex')(generateDbConfig());
+queryStats.attach(db);
+readCache.attach(db);
+tracing.attachDb(db);
+
+// The queries of a request that was cancelled don't get a connection, so the rest of its work stops there
+const acquireConnection     = db.client.acquireConnection;
+db.client.acquireConnection = function () {
+	const signal = requestContext.signal();
+	if (signal && signal.aborted) {
+		return Promise.reject(new error.CancelledError(signal.reason));
+	}
+	return acquireConnection.apply(this, arguments);
+};
+
+/**
+ * Replaces the connection pool, to pick up new sizes from the backend settings. Running queries
+ * are let finish, but any waiting for a connection at that moment fail.
+ *
+ * @returns {Promise}
+ */
+db.reloadPool = () => {
+	const pool = generatePoolConfig();
+	if (typeof pool === 'undefined') {
+		return Promise.resolve(false);
+	}
+
+	return db.destroy()
+		.then(() => {
+			db.initialize(Object.assign({}, db.client.config, {pool: pool}));
+			return true;
+		});
+};
+
+module.exports = db;
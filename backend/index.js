@@ -1,33 +1,117 @@
 #!/usr/bin/env node
 
-const schema = require('./schema');
-const logger = require('./logger').global;
+const schema      = require('./schema');
+const loggers     = require('./logger');
+const config      = require('./lib/config');
+const errorReport = require('./lib/error-report');
+const logger      = loggers.global;
+
+loggers.setLevel(config.getSetting('log_level'));
+
+// A promise nothing waited on is a bug, but not one that leaves the process in a bad state
+process.on('unhandledRejection', (reason) => {
+	errorReport.unhandled(reason instanceof Error ? reason : new Error('Unhandled rejection: ' + reason));
+});
+
+// After an exception nothing caught, there's no knowing what state things are in, so it stops once it's reported.
+// A capture callback, as node runs with --abort_on_uncaught_exception, which skips uncaughtException listeners.
+process.setUncaughtExceptionCaptureCallback((err) => {
+	errorReport.unhandled(err);
+	setTimeout(() => {
+		process.exit(1);
+	}, 2000).unref();
+});
 
 async function appStart () {
-	const migrate             = require('./migrate');
-	const setup               = require('./setup');
-	const app                 = require('./app');
-	const internalCertificate = require('./internal/certificate');
-	const internalIpRanges    = require('./internal/ip_ranges');
+	const migrate              = require('./migrate');
+	const setup                = require('./setup');
+	const app                  = require('./app');
+	const internalCertificate  = require('./internal/certificate');
+	const internalIpRanges     = require('./internal/ip_ranges');
+	const internalCtMonitor    = require('./internal/ct-monitor');
+	const internalAcmeCleanup  = require('./internal/acme-challenge-cleanup');
+	const internalSystem       = require('./internal/system');
+	const internalLogRotation  = require('./internal/log-rotation');
+	const internalLogShipping  = require('./internal/log-shipping');
+	const internalAnalytics    = require('./internal/analytics');
+	const internalHostUsage    = require('./internal/host-usage');
+	const internalDomainExpiry = require('./internal/domain-expiry');
+	const internalAcmeDns      = require('./internal/acme-dns');
+	const internalScheduled    = require('./internal/scheduled-change');
+	const internalReports      = require('./internal/scheduled-report');
+	const internalAccessDns    = require('./internal/access-list-dns');
+	const internalAccessReqs   = require('./internal/access-request');
+	const internalGuestLinks   = require('./internal/guest-link');
+	const internalDrain        = require('./internal/drain');
+	const internalCertStorage  = require('./internal/certificate-storage');
+	const internalLeader       = require('./internal/leader');
+	const internalOutbound     = require('./internal/outbound-proxy');
+	const internalDnsResolvers = require('./internal/dns-resolvers');
+	const internalDocker       = require('./internal/docker');
+	const internalSshTunnels   = require('./internal/ssh-tunnel');
+	const internalWireguard    = require('./internal/wireguard');
+	const internalTelemetry    = require('./internal/telemetry');
+	const internalAutomation   = require('./internal/automation');
+	const internalTestMode     = require('./internal/test-mode');
+	const internalNotify       = require('./internal/notifications');
+	const requestContext       = require('./lib/request-context');
 
 	return migrate.latest()
 		.then(setup)
 		.then(schema.getCompiledSchema)
+		.then(internalLogShipping.init)
+		.then(internalOutbound.init)
+		.then(internalDnsResolvers.init)
+		.then(internalDocker.init)
+		.then(internalAcmeDns.init)
+		.then(internalTestMode.init)
+		.then(internalDrain.init)
 		.then(internalIpRanges.fetch)
 		.then(() => {
-			internalCertificate.initTimer();
+			// Every replica has its own nginx, logs and certificate files to look after
 			internalIpRanges.initTimer();
+			internalLogRotation.initTimer();
+			internalAnalytics.initTimer();
+			internalHostUsage.initTimer();
+			internalAccessDns.initTimer();
+			internalAccessReqs.initTimer();
+			internalGuestLinks.initTimer();
+			internalCertStorage.initTimer();
+			internalSshTunnels.initTimer();
+			internalWireguard.initTimer();
 
-			const server = app.listen(3000, () => {
-				logger.info('Backend PID ' + process.pid + ' listening on port 3000 ...');
+			// Work on what the replicas share is only done by the leader
+			return internalLeader.init([internalCertificate, internalCtMonitor, internalAcmeCleanup, internalDomainExpiry, internalScheduled, internalReports, internalTelemetry, internalAutomation, internalNotify].map((worker) => {
+				return {
+					start: worker.initTimer,
+					stop:  () => {
+						clearInterval(worker.interval);
+					}
+				};
+			}));
+		})
+		.then(() => {
+			return internalSystem.listen(app);
+		})
+		.then(() => {
+			process.on('SIGHUP', () => {
+				logger.info('PID ' + process.pid + ' received SIGHUP');
+				internalSystem.applySettings()
+					.catch((err) => {
+						logger.error('Could not reload backend settings: ' + err.message);
+					});
+			});
 
-				process.on('SIGTERM', () => {
-					logger.info('PID ' + process.pid + ' received SIGTERM');
-					server.close(() => {
-						logger.info('Stopping.');
-						process.exit(0);
+			process.on('SIGTERM', () => {
+				logger.info('PID ' + process.pid + ' received SIGTERM');
+				requestContext.abortAll('The server is stopping');
+				internalLeader.release()
+					.then(() => {
+						internalSystem.close(() => {
+							logger.info('Stopping.');
+							process.exit(0);
+						});
 					});
-				});
 			});
 		})
 		.catch((err) => {
@@ -0,0 +1,53 @@
+const error = require('../lib/error');
+
+const internalAccessExemptions = {
+
+	/**
+	 * nginx doesn't allow two locations for the same path, so an exemption can't use
+	 * the path of another one or of a custom location
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing host
+	 * @returns {Promise}
+	 */
+	validate: (data, row) => {
+		const exemptions = typeof data.access_exemptions !== 'undefined' ? data.access_exemptions : (row ? row.access_exemptions : null);
+		const locations  = typeof data.locations !== 'undefined' ? data.locations : (row ? row.locations : null);
+
+		let seen = [];
+
+		for (let i = 0; i < (exemptions || []).length; i++) {
+			const exemption = exemptions[i];
+			const match     = exemption.match || 'exact';
+			const key       = match + ' ' + exemption.path;
+
+			if (seen.indexOf(key) !== -1) {
+				return Promise.reject(new error.ValidationError('Access exemption ' + (i + 1) + ': there\'s already an exemption for ' + key));
+			}
+			seen.push(key);
+
+			if (match === 'prefix' && (exemption.path === '/' || (locations || []).map((location) => location.path).indexOf(exemption.path) !== -1)) {
+				return Promise.reject(new error.ValidationError('Access exemption ' + (i + 1) + ': ' + exemption.path + ' is already a location of the host'));
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What to write into the config of a proxy host
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}   ie: [{location: '= /healthz', clients: ['10.0.0.0/8']}]
+	 */
+	getOptions: (host) => {
+		return (host.access_exemptions || []).map((exemption) => {
+			return {
+				location: (exemption.match === 'prefix' ? '^~ ' : '= ') + exemption.path,
+				clients:  exemption.clients || []
+			};
+		});
+	}
+};
+
+module.exports = internalAccessExemptions;
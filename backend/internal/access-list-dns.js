@@ -0,0 +1,165 @@
+const _                     = require('lodash');
+const logger                = require('../logger').access;
+const accessListClientModel = require('../models/access_list_client');
+const proxyHostModel        = require('../models/proxy_host');
+const streamModel           = require('../models/stream');
+const internalNginx         = require('./nginx');
+const internalDnsResolvers  = require('./dns-resolvers');
+
+// Same as in the nginxAccessRule filter, IP addresses and "all" never match it
+const HOSTNAME = /^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}$/;
+
+const internalAccessListDns = {
+
+	intervalTimeout:    1000 * 60 * 5, // 5 minutes
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Access List DNS Timer initialized');
+		internalAccessListDns.interval = setInterval(internalAccessListDns.processClients, internalAccessListDns.intervalTimeout);
+	},
+
+	/**
+	 * @param   {String}  address
+	 * @returns {Boolean}
+	 */
+	isHostname: (address) => {
+		return HOSTNAME.test(address || '');
+	},
+
+	/**
+	 * @param   {String}  hostname
+	 * @returns {Promise} resolves with the sorted IPv4 and IPv6 addresses
+	 */
+	lookup: (hostname) => {
+		return internalDnsResolvers.lookup(hostname)
+			.then((results) => {
+				return _.uniq(results.map((result) => result.address)).sort();
+			});
+	},
+
+	/**
+	 * Resolves the hostnames of the given clients and saves the addresses in their meta.
+	 * A hostname that doesn't resolve keeps the addresses it had, so an outage of the DNS
+	 * doesn't lock everyone out.
+	 *
+	 * @param   {Array}  clients  rows
+	 * @returns {Promise} resolves with the ids of the access lists that changed
+	 */
+	resolveClients: (clients) => {
+		let changed  = [];
+		let sequence = Promise.resolve();
+
+		clients.filter((client) => internalAccessListDns.isHostname(client.address)).forEach((client) => {
+			sequence = sequence.then(() => {
+				return internalAccessListDns.lookup(client.address)
+					.catch((err) => {
+						logger.warn('Access list client ' + client.address + ' could not be resolved: ' + err.message);
+						return null;
+					})
+					.then((addresses) => {
+						const meta = client.meta || {};
+						if (addresses === null || _.isEqual(addresses, meta.resolved)) {
+							return;
+						}
+
+						logger.info('Access list client ' + client.address + ' resolved to ' + (addresses.join(', ') || 'nothing'));
+						changed.push(client.access_list_id);
+
+						return accessListClientModel
+							.query()
+							.where('id', client.id)
+							.patch({
+								meta: _.assign({}, meta, {
+									resolved:    addresses,
+									resolved_on: new Date().toISOString()
+								})
+							});
+					});
+			});
+		});
+
+		return sequence.then(() => _.uniq(changed));
+	},
+
+	/**
+	 * @param   {Integer}  access_list_id
+	 * @returns {Promise}
+	 */
+	resolveAccessList: (access_list_id) => {
+		return accessListClientModel
+			.query()
+			.where('access_list_id', access_list_id)
+			.then(internalAccessListDns.resolveClients);
+	},
+
+	/**
+	 * Writes the hosts and streams using the lists again, ie: after their clients changed
+	 *
+	 * @param   {Array}  access_list_ids
+	 * @returns {Promise}  resolves with whether anything was written
+	 */
+	regenerate: (access_list_ids) => {
+		if (!access_list_ids.length) {
+			return Promise.resolve(false);
+		}
+
+		return Promise.all([
+			proxyHostModel
+				.query()
+				.where('is_deleted', 0)
+				.whereIn('access_list_id', access_list_ids)
+				.withGraphFetched('[certificate, access_list.[clients, items]]'),
+			streamModel
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('enabled', 1)
+				.whereIn('access_list_id', access_list_ids)
+		])
+			.then(([hosts, streams]) => {
+				if (!hosts.length && !streams.length) {
+					return false;
+				}
+
+				return internalNginx.bulkGenerateConfigs('proxy_host', hosts)
+					.then(() => {
+						return internalNginx.bulkGenerateConfigs('stream', streams);
+					})
+					.then(internalNginx.reloadIfChanged)
+					.then(() => true);
+			});
+	},
+
+	/**
+	 * Triggered by a timer, this resolves every hostname used in an access list again and
+	 * regenerates the hosts using a list when the addresses changed.
+	 *
+	 * @returns {Promise}
+	 */
+	processClients: () => {
+		if (internalAccessListDns.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalAccessListDns.intervalProcessing = true;
+
+		return accessListClientModel
+			.query()
+			.joinRelated('access_list')
+			.where('access_list.is_deleted', 0)
+			.select('access_list_client.*')
+			.then(internalAccessListDns.resolveClients)
+			.then(internalAccessListDns.regenerate)
+			.then((result) => {
+				internalAccessListDns.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalAccessListDns.intervalProcessing = false;
+			});
+	}
+};
+
+module.exports = internalAccessListDns;
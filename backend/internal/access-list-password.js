@@ -0,0 +1,234 @@
+const _                = require('lodash');
+const crypto           = require('crypto');
+const logger           = require('../logger').access;
+const config           = require('../lib/config');
+const error            = require('../lib/error');
+const accessListModel  = require('../models/access_list');
+const authModel        = require('../models/access_list_auth');
+const proxyHostModel   = require('../models/proxy_host');
+const settingModel     = require('../models/setting');
+const internalAuditLog = require('./audit-log');
+
+// Where hosts with users in their access list take password changes
+const PATH = '/.npm/password';
+
+// Wrong passwords an address can try in the window before it has to wait
+const MAX_FAILURES   = 5;
+const FAILURE_WINDOW = 15 * 60 * 1000;
+
+// The most addresses kept, the ones that failed first go when there are more
+const MAX_ADDRESSES = 10000;
+
+// Wrong passwords by address, {count, since}
+let failures = {};
+
+/**
+ * @param   {String}  a
+ * @param   {String}  b
+ * @returns {Boolean}
+ */
+const isEqual = (a, b) => {
+	// Hashed first so the lengths are the same, timingSafeEqual throws otherwise
+	const hash = (value) => crypto.createHash('sha256').update(String(value)).digest();
+	return crypto.timingSafeEqual(hash(a), hash(b));
+};
+
+const internalAccessListPassword = {
+
+	PATH: PATH,
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'access-list-passwords')
+			.first();
+	},
+
+	/**
+	 * What to write into a host's config, for the hosts with users in their access list while they can change their passwords
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (setting, host) => {
+		if (!setting || setting.value !== 'on' || !host.access_list_id || !host.access_list || !(host.access_list.items || []).length) {
+			return null;
+		}
+
+		return {
+			path: PATH,
+			port: config.getSetting('port')
+		};
+	},
+
+	/**
+	 * Writes the config of every host with an access list again, after changing passwords was turned on or off
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return require('./host').regenerateConfigs((host, host_type) => host_type === 'proxy_host' && host.access_list_id > 0);
+	},
+
+	/**
+	 * @param   {String}  [header]  ie: "Basic dXNlcjpwYXNz"
+	 * @returns {Object|null}  {username, password}
+	 */
+	parseAuthorization: (header) => {
+		const match = /^Basic\s+([A-Za-z0-9+/=]+)$/i.exec((header || '').trim());
+		if (!match) {
+			return null;
+		}
+
+		const decoded = Buffer.from(match[1], 'base64').toString('utf8');
+		const index   = decoded.indexOf(':');
+		if (index < 1) {
+			return null;
+		}
+
+		return {
+			username: decoded.substring(0, index),
+			password: decoded.substring(index + 1)
+		};
+	},
+
+	/**
+	 * @param   {String}  address
+	 */
+	assertAttempts: (address) => {
+		const entry = failures[address];
+		if (entry && Date.now() - entry.since > FAILURE_WINDOW) {
+			delete failures[address];
+		} else if (entry && entry.count >= MAX_FAILURES) {
+			throw new error.TooManyAttemptsError('Too many wrong passwords from ' + address + ', try again in ' + Math.ceil((entry.since + FAILURE_WINDOW - Date.now()) / 60000) + ' minutes');
+		}
+	},
+
+	/**
+	 * @param   {String}  address
+	 */
+	addFailure: (address) => {
+		if (!failures[address]) {
+			// Kept in the order they first failed, so the oldest come first
+			const addresses = Object.keys(failures);
+			addresses.forEach((key, index) => {
+				if (Date.now() - failures[key].since > FAILURE_WINDOW || index <= addresses.length - MAX_ADDRESSES) {
+					delete failures[key];
+				}
+			});
+		}
+
+		const entry = failures[address] || {count: 0, since: Date.now()};
+		entry.count++;
+		failures[address] = entry;
+	},
+
+	/**
+	 * Lets a user of the access list of a host change their own password, with the one they have now.
+	 * The htpasswd file of the list is written again and nginx reloaded.
+	 *
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @param   {String}  data.address
+	 * @param   {String}  data.authorization  the Authorization header, with the current password
+	 * @param   {String}  data.password       the new one
+	 * @returns {Promise}
+	 */
+	change: (data) => {
+		// Required here, as the access lists are written to nginx, which requires this module
+		const internalAccessList = require('./access-list');
+		const internalNginx      = require('./nginx');
+
+		let setting     = null;
+		let list        = null;
+		let item        = null;
+		let credentials = null;
+
+		return Promise.resolve()
+			.then(() => {
+				internalAccessListPassword.assertAttempts(data.address);
+
+				return Promise.all([
+					internalAccessListPassword.getSetting(),
+					proxyHostModel
+						.query()
+						.where('id', data.host_id)
+						.andWhere('is_deleted', 0)
+						.andWhere('enabled', 1)
+						.andWhere('access_list_id', '>', 0)
+						.first()
+				]);
+			})
+			.then(([found_setting, host]) => {
+				setting = found_setting;
+
+				if (!setting || setting.value !== 'on' || !host) {
+					throw new error.ItemNotFoundError(data.host_id);
+				}
+
+				return accessListModel
+					.query()
+					.where('id', host.access_list_id)
+					.andWhere('is_deleted', 0)
+					.withGraphFetched('[items]')
+					.first();
+			})
+			.then((found) => {
+				list        = found;
+				credentials = internalAccessListPassword.parseAuthorization(data.authorization);
+
+				if (!list || !credentials) {
+					throw new error.AuthError('The username and current password are needed');
+				}
+
+				item = _.find(list.items, {username: credentials.username});
+
+				// Compared even without the user, so it takes as long either way
+				if (!isEqual(item ? item.password : '', credentials.password) || !item) {
+					internalAccessListPassword.addFailure(data.address);
+					logger.warn('Wrong password changing the password of ' + credentials.username + ' in Access List #' + list.id + ' from ' + data.address);
+					throw new error.AuthError('Wrong username or password');
+				}
+
+				const min_length = (setting.meta && setting.meta.min_length) || 8;
+				if (data.password.length < min_length) {
+					throw new error.ValidationError('The new password needs at least ' + min_length + ' characters');
+				}
+				if (data.password === credentials.password) {
+					throw new error.ValidationError('The new password is the same as the current one');
+				}
+
+				return authModel
+					.query()
+					.where('id', item.id)
+					.patch({password: data.password});
+			})
+			.then(() => {
+				item.password = data.password;
+				return internalAccessList.build(list);
+			})
+			.then(internalNginx.reload)
+			.then(() => {
+				delete failures[data.address];
+				logger.info('Password of ' + item.username + ' in Access List #' + list.id + ' changed from ' + data.address);
+
+				// Add to audit log, as the owner of the list since the user isn't one of ours
+				return internalAuditLog.add(null, {
+					user_id:     list.owner_user_id,
+					action:      'password-changed',
+					object_type: 'access-list',
+					object_id:   list.id,
+					meta:        {username: item.username, address: data.address}
+				});
+			})
+			.then(() => {
+				return true;
+			});
+	}
+};
+
+module.exports = internalAccessListPassword;
@@ -1,6 +1,7 @@
 const _                     = require('lodash');
 const fs                    = require('fs');
 const batchflow             = require('batchflow');
+const config                = require('../lib/config');
 const logger                = require('../logger').access;
 const error                 = require('../lib/error');
 const utils                 = require('../lib/utils');
@@ -8,8 +9,12 @@ const accessListModel       = require('../models/access_list');
 const accessListAuthModel   = require('../models/access_list_auth');
 const accessListClientModel = require('../models/access_list_client');
 const proxyHostModel        = require('../models/proxy_host');
+const streamModel           = require('../models/stream');
 const internalAuditLog      = require('./audit-log');
+const internalAccessListDns = require('./access-list-dns');
 const internalNginx         = require('./nginx');
+const internalProject       = require('./project');
+const internalQuota         = require('./quota');
 
 function omissions () {
 	return ['is_deleted'];
@@ -24,6 +29,9 @@ const internalAccessList = {
 	 */
 	create: (access, data) => {
 		return access.can('access_lists:create', data)
+			.then(() => {
+				return internalQuota.check(access, 'access_lists');
+			})
 			.then((/*access_data*/) => {
 				return accessListModel
 					.query()
@@ -31,6 +39,8 @@ const internalAccessList = {
 						name:          data.name,
 						satisfy_any:   data.satisfy_any,
 						pass_auth:     data.pass_auth,
+						project_id:    data.project_id || 0,
+						notes:         data.notes || '',
 						owner_user_id: access.token.getUserId(1)
 					})
 					.then(utils.omitRow(omissions()));
@@ -60,7 +70,8 @@ const internalAccessList = {
 							.insert({
 								access_list_id: row.id,
 								address:        client.address,
-								directive:      client.directive
+								directive:      client.directive,
+								meta:           internalAccessList.getClientMeta(client, [])
 							})
 						);
 					});
@@ -68,6 +79,9 @@ const internalAccessList = {
 
 				return Promise.all(promises);
 			})
+			.then(() => {
+				return internalAccessListDns.resolveAccessList(data.id);
+			})
 			.then(() => {
 				// re-fetch with expansions
 				return internalAccessList.get(access, {
@@ -118,10 +132,19 @@ const internalAccessList = {
 					// Sanity check that something crazy hasn't happened
 					throw new error.InternalValidationError('Access List could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
+
+				if (typeof data.clients !== 'undefined' && data.clients && !data.clients.filter((client) => client.address).length) {
+					return internalAccessList.getStreams(data.id)
+						.then((streams) => {
+							if (streams.length) {
+								throw new error.ValidationError('This access list is used by ' + streams.length + ' stream(s), which can only use its client rules. Keep at least one.');
+							}
+						});
+				}
 			})
 			.then(() => {
-				// patch name if specified
-				if (typeof data.name !== 'undefined' && data.name) {
+				// patch name, notes and project if specified
+				if ((typeof data.name !== 'undefined' && data.name) || typeof data.notes !== 'undefined' || typeof data.project_id !== 'undefined') {
 					return accessListModel
 						.query()
 						.where({id: data.id})
@@ -129,6 +152,8 @@ const internalAccessList = {
 							name:        data.name,
 							satisfy_any: data.satisfy_any,
 							pass_auth:   data.pass_auth,
+							project_id:  data.project_id,
+							notes:       data.notes,
 						});
 				}
 			})
@@ -169,6 +194,9 @@ const internalAccessList = {
 							if (promises.length) {
 								return Promise.all(promises);
 							}
+						})
+						.then(() => {
+							return internalAccessListDns.resolveAccessList(data.id);
 						});
 				}
 			})
@@ -177,30 +205,37 @@ const internalAccessList = {
 				if (typeof data.clients !== 'undefined' && data.clients) {
 					let promises = [];
 
-					data.clients.map(function (client) {
-						if (client.address) {
-							promises.push(accessListClientModel
-								.query()
-								.insert({
-									access_list_id: data.id,
-									address:        client.address,
-									directive:      client.directive
-								})
-							);
-						}
-					});
-
-					let query = accessListClientModel
+					return accessListClientModel
 						.query()
-						.delete()
-						.where('access_list_id', data.id);
+						.where('access_list_id', data.id)
+						.then((existing) => {
+							data.clients.map(function (client) {
+								if (client.address) {
+									promises.push(accessListClientModel
+										.query()
+										.insert({
+											access_list_id: data.id,
+											address:        client.address,
+											directive:      client.directive,
+											meta:           internalAccessList.getClientMeta(client, existing)
+										})
+									);
+								}
+							});
 
-					return query
+							return accessListClientModel
+								.query()
+								.delete()
+								.where('access_list_id', data.id);
+						})
 						.then(() => {
 							// Add new items
 							if (promises.length) {
 								return Promise.all(promises);
 							}
+						})
+						.then(() => {
+							return internalAccessListDns.resolveAccessList(data.id);
 						});
 				}
 			})
@@ -226,7 +261,11 @@ const internalAccessList = {
 						if (parseInt(row.proxy_host_count, 10)) {
 							return internalNginx.bulkGenerateConfigs('proxy_host', row.proxy_hosts);
 						}
-					}).then(internalNginx.reload)
+					})
+					.then(() => {
+						return internalAccessList.configureStreams(row.id);
+					})
+					.then(internalNginx.reload)
 					.then(() => {
 						return internalAccessList.maskItems(row);
 					});
@@ -249,6 +288,9 @@ const internalAccessList = {
 
 		return access.can('access_lists:get', data.id)
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data, 'access_list');
+			})
+			.then((visibility) => {
 				let query = accessListModel
 					.query()
 					.select('access_list.*', accessListModel.raw('COUNT(proxy_host.id) as proxy_host_count'))
@@ -261,8 +303,8 @@ const internalAccessList = {
 					.allowGraph('[owner,items,clients,proxy_hosts.[certificate,access_list.[clients,items]]]')
 					.first();
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('access_list.owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
@@ -331,12 +373,27 @@ const internalAccessList = {
 									});
 
 									return internalNginx.bulkGenerateConfigs('proxy_host', row.proxy_hosts);
-								})
-								.then(() => {
-									return internalNginx.reload();
 								});
 						}
 					})
+					.then(() => {
+						// and the streams, which are open to anyone again
+						return internalAccessList.getStreams(row.id)
+							.then((streams) => {
+								return streamModel
+									.query()
+									.where('access_list_id', '=', row.id)
+									.patch({access_list_id: 0})
+									.then(() => {
+										if (streams.length) {
+											return internalNginx.bulkGenerateConfigs('stream', streams.map((stream) => _.assign(stream, {access_list_id: 0})));
+										}
+									});
+							});
+					})
+					.then(() => {
+						return internalNginx.reloadIfChanged();
+					})
 					.then(() => {
 						// delete the htpasswd file
 						let htpasswd_file = internalAccessList.getFilename(row);
@@ -368,11 +425,16 @@ const internalAccessList = {
 	 * @param   {Access}  access
 	 * @param   {Array}   [expand]
 	 * @param   {String}  [search_query]
+	 * @param   {Object}  [filter]
+	 * @param   {Number}  [filter.project_id]
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, filter) => {
 		return access.can('access_lists:list')
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data, 'access_list');
+			})
+			.then((visibility) => {
 				let query = accessListModel
 					.query()
 					.select('access_list.*', accessListModel.raw('COUNT(proxy_host.id) as proxy_host_count'))
@@ -383,16 +445,22 @@ const internalAccessList = {
 					.where('access_list.is_deleted', 0)
 					.groupBy('access_list.id')
 					.allowGraph('[owner,items,clients]')
-					.orderBy('access_list.name', 'ASC');
+					.orderBy('access_list.name', 'ASC')
+					.orderBy('access_list.id', 'ASC');
+
+				if (visibility) {
+					query.andWhere(visibility);
+				}
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('access_list.owner_user_id', access.token.getUserId(1));
+				if (filter && typeof filter.project_id === 'number') {
+					query.andWhere('access_list.project_id', filter.project_id);
 				}
 
 				// Query is used for searching
 				if (typeof search_query === 'string') {
 					query.where(function () {
 						this.where('name', 'like', '%' + search_query + '%');
+						this.orWhere('access_list.notes', 'like', '%' + search_query + '%');
 					});
 				}
 
@@ -461,13 +529,61 @@ const internalAccessList = {
 		return list;
 	},
 
+	/**
+	 * The meta a client is saved with. One that's left without an expiry keeps the one it had,
+	 * so saving the list doesn't let in for good an address that was only let in for a while.
+	 *
+	 * @param   {Object}  client    {address, directive, [expires_on]}
+	 * @param   {Array}   existing  client rows of the list before it's saved
+	 * @returns {Object}
+	 */
+	getClientMeta: (client, existing) => {
+		let expires_on = client.expires_on;
+
+		if (typeof expires_on === 'undefined') {
+			const match = _.find(existing, {address: client.address, directive: client.directive});
+			expires_on  = match && match.meta ? match.meta.expires_on : null;
+		}
+
+		return expires_on ? {expires_on: expires_on} : {};
+	},
+
+	/**
+	 * Enabled streams using the list
+	 *
+	 * @param   {Integer}  access_list_id
+	 * @returns {Promise}
+	 */
+	getStreams: (access_list_id) => {
+		return streamModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('enabled', 1)
+			.andWhere('access_list_id', access_list_id);
+	},
+
+	/**
+	 * Writes the streams using the list again, nginx still has to be reloaded
+	 *
+	 * @param   {Integer}  access_list_id
+	 * @returns {Promise}
+	 */
+	configureStreams: (access_list_id) => {
+		return internalAccessList.getStreams(access_list_id)
+			.then((streams) => {
+				if (streams.length) {
+					return internalNginx.bulkGenerateConfigs('stream', streams);
+				}
+			});
+	},
+
 	/**
 	 * @param   {Object}  list
 	 * @param   {Integer} list.id
 	 * @returns {String}
 	 */
 	getFilename: (list) => {
-		return '/data/access/' + list.id;
+		return config.getPath('access') + '/' + list.id;
 	},
 
 	/**
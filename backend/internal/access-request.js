@@ -0,0 +1,534 @@
+const _                     = require('lodash');
+const fs                    = require('fs');
+const https                 = require('https');
+const moment                = require('moment');
+const logger                = require('../logger').access;
+const config                = require('../lib/config');
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const proxyAgent            = require('../lib/proxy-agent');
+const accessRequestModel    = require('../models/access_request');
+const accessListClientModel = require('../models/access_list_client');
+const proxyHostModel        = require('../models/proxy_host');
+const settingModel          = require('../models/setting');
+const internalAuditLog      = require('./audit-log');
+const internalNotifications = require('./notifications');
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+// Where the hosts with an access list take requests for access
+const PORTAL_PATH = '/.npm/access-request';
+
+// How long an approved address is let in, when the setting doesn't say
+const DEFAULT_HOURS = 24;
+
+// Requests an address can have waiting at once
+const MAX_PENDING = 5;
+
+// Expired clients are kept this long before they're removed, so every replica has written its configs without them
+const REMOVE_AFTER_HOURS = 24;
+
+const VERIFY_TIMEOUT = 10000;
+
+// What the page loads for each captcha, the field the answer is posted in and where it's checked
+const CAPTCHAS = {
+	turnstile: {
+		script: 'https://challenges.cloudflare.com/turnstile/v0/api.js',
+		widget: 'cf-turnstile',
+		field:  'cf-turnstile-response',
+		verify: 'https://challenges.cloudflare.com/turnstile/v0/siteverify'
+	},
+	hcaptcha: {
+		script: 'https://js.hcaptcha.com/1/api.js',
+		widget: 'h-captcha',
+		field:  'h-captcha-response',
+		verify: 'https://api.hcaptcha.com/siteverify'
+	},
+	// recaptcha.net, as www.google.com can't be reached from everywhere
+	recaptcha: {
+		script: 'https://www.recaptcha.net/recaptcha/api.js',
+		widget: 'g-recaptcha',
+		field:  'g-recaptcha-response',
+		verify: 'https://www.recaptcha.net/recaptcha/api/siteverify'
+	}
+};
+
+/**
+ * @param   {Object}  client  access list client row
+ * @param   {Object}  [now]   moment
+ * @returns {Boolean}
+ */
+const isExpired = (client, now) => {
+	return !!(client.meta && client.meta.expires_on) && !moment(client.meta.expires_on).isAfter(now || moment());
+};
+
+const internalAccessRequest = {
+
+	CAPTCHAS:    CAPTCHAS,
+	PORTAL_PATH: PORTAL_PATH,
+
+	intervalTimeout:    1000 * 60, // 1 minute
+	interval:           null,
+	intervalProcessing: false,
+	lastChecked:        null,
+
+	isExpired: isExpired,
+
+	initTimer: () => {
+		logger.info('Access Request Timer initialized');
+		internalAccessRequest.interval = setInterval(internalAccessRequest.processExpired, internalAccessRequest.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'access-requests')
+			.first();
+	},
+
+	/**
+	 * What to write into a host's config, for the hosts with an access list while requests are taken
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (setting, host) => {
+		if (!setting || setting.value !== 'on' || !host.access_list_id) {
+			return null;
+		}
+
+		return {
+			path: PORTAL_PATH,
+			port: config.getSetting('port')
+		};
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (!CAPTCHAS[meta.provider]) {
+			return Promise.reject(new error.ValidationError('Access requests need a captcha, one of ' + Object.keys(CAPTCHAS).join(', ')));
+		}
+		if (!meta.site_key || !meta.secret) {
+			return Promise.reject(new error.ValidationError('Access requests need the site key and secret of the captcha'));
+		}
+		return Promise.resolve();
+	},
+
+	/**
+	 * Writes the config of every host with an access list again, after requests were turned on or off
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return require('./host').regenerateConfigs((host, host_type) => host_type === 'proxy_host' && host.access_list_id > 0);
+	},
+
+	/**
+	 * The answer to the captcha, in whichever field the page or a client posted it
+	 *
+	 * @param   {Object}  body
+	 * @returns {String}
+	 */
+	getCaptchaResponse: (body) => {
+		return _.find([body.captcha].concat(_.map(CAPTCHAS, (captcha) => body[captcha.field])), (value) => typeof value === 'string' && value !== '') || '';
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @param   {String}  response  what the widget gave the page
+	 * @param   {String}  address
+	 * @returns {Promise}  resolves with whether the captcha was solved
+	 */
+	verifyCaptcha: (setting, response, address) => {
+		if (!response) {
+			return Promise.resolve(false);
+		}
+
+		const captcha = CAPTCHAS[setting.meta.provider];
+		const body    = new URLSearchParams({secret: setting.meta.secret, response: response, remoteip: address}).toString();
+
+		return new Promise((resolve, reject) => {
+			const req = https.request(captcha.verify, {
+				method:  'POST',
+				timeout: VERIFY_TIMEOUT,
+				agent:   proxyAgent.forUrl(captcha.verify),
+				headers: {
+					'Content-Type':   'application/x-www-form-urlencoded',
+					'Content-Length': Buffer.byteLength(body)
+				}
+			}, (res) => {
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					try {
+						resolve(JSON.parse(raw_data).success === true);
+					} catch (err) {
+						reject(new Error('The captcha couldn\'t be checked, ' + setting.meta.provider + ' answered ' + res.statusCode));
+					}
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('The captcha couldn\'t be checked, ' + setting.meta.provider + ' didn\'t answer'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	/**
+	 * A host that takes requests for access
+	 *
+	 * @param   {Number}  host_id
+	 * @returns {Promise}  resolves with [setting, host]
+	 */
+	getPortal: (host_id) => {
+		return Promise.all([
+			internalAccessRequest.getSetting(),
+			proxyHostModel
+				.query()
+				.where('id', host_id)
+				.andWhere('is_deleted', 0)
+				.andWhere('enabled', 1)
+				.andWhere('access_list_id', '>', 0)
+				.first()
+		])
+			.then(([setting, host]) => {
+				if (!setting || setting.value !== 'on' || !host) {
+					throw new error.ItemNotFoundError(host_id);
+				}
+				return [setting, host];
+			});
+	},
+
+	/**
+	 * The page someone kept out by an access list is shown, to ask for access
+	 *
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @param   {String}  data.address
+	 * @param   {Object}  [result]  what happened to what they sent, {request} or {error}
+	 * @returns {Promise}  resolves with the html
+	 */
+	renderPortal: (data, result) => {
+		return internalAccessRequest.getPortal(data.host_id)
+			.then(([setting, host]) => {
+				const template = fs.readFileSync(__dirname + '/../templates/access_request.html', {encoding: 'utf8'});
+				const captcha  = CAPTCHAS[setting.meta.provider];
+
+				return utils.getRenderEngine().parseAndRender(template, {
+					domain:   host.domain_names[0],
+					address:  data.address,
+					path:     PORTAL_PATH,
+					script:   captcha.script,
+					widget:   captcha.widget,
+					site_key: setting.meta.site_key,
+					request:  result && result.request ? result.request : null,
+					error:    result && result.error ? result.error : null
+				});
+			});
+	},
+
+	/**
+	 * Files a request for access, once the captcha is solved
+	 *
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @param   {String}  data.address
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.reason]
+	 * @param   {String}  data.captcha
+	 * @param   {String}  [data.user_agent]
+	 * @returns {Promise}
+	 */
+	submit: (data) => {
+		let host = null;
+
+		return internalAccessRequest.getPortal(data.host_id)
+			.then(([setting, found]) => {
+				host = found;
+				return internalAccessRequest.verifyCaptcha(setting, data.captcha, data.address)
+					.catch((err) => {
+						logger.warn(err.message);
+						throw new error.ValidationError('The captcha couldn\'t be checked, try again later');
+					});
+			})
+			.then((solved) => {
+				if (!solved) {
+					throw new error.ValidationError('The captcha wasn\'t solved');
+				}
+
+				return accessRequestModel
+					.query()
+					.where('address', data.address)
+					.andWhere('status', 'pending');
+			})
+			.then((pending) => {
+				// Asking again only gets the request that's already waiting
+				const existing = _.find(pending, {access_list_id: host.access_list_id});
+				if (existing) {
+					return existing;
+				}
+
+				if (pending.length >= MAX_PENDING) {
+					throw new error.ValidationError('There are already ' + pending.length + ' requests from ' + data.address + ' waiting');
+				}
+
+				return accessRequestModel
+					.query()
+					.insertAndFetch({
+						proxy_host_id:  host.id,
+						access_list_id: host.access_list_id,
+						address:        data.address,
+						name:           data.name || '',
+						reason:         data.reason || '',
+						meta:           {user_agent: (data.user_agent || '').slice(0, 255)}
+					})
+					.then((row) => {
+						logger.info('Access request #' + row.id + ' from ' + row.address + ' for ' + host.domain_names[0]);
+						internalNotifications.send('access-request', 'info', 'Access request #' + row.id + ' from ' + row.address + (row.name ? ' (' + row.name + ')' : '') + ' for ' + host.domain_names.join(', ') + (row.reason ? ': ' + row.reason : ''));
+						return row;
+					});
+			})
+			.then((row) => {
+				return _.pick(row, ['id', 'created_on', 'address', 'status']);
+			});
+	},
+
+	/**
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Number}   data.id
+	 * @param   {Array}    [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('access_requests:get', data.id)
+			.then(() => {
+				let query = accessRequestModel
+					.query()
+					.where('id', data.id)
+					.allowGraph('[proxy_host,access_list,reviewer]')
+					.first();
+
+				if (typeof data.expand !== 'undefined' && data.expand !== null) {
+					query.withGraphFetched('[' + data.expand.join(', ') + ']');
+				}
+
+				return query;
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  [filters]
+	 * @param   {String}  [filters.status]
+	 * @param   {Array}   [expand]
+	 * @returns {Promise}
+	 */
+	getAll: (access, filters, expand) => {
+		filters = filters || {};
+
+		return access.can('access_requests:list')
+			.then(() => {
+				let query = accessRequestModel
+					.query()
+					.allowGraph('[proxy_host,access_list,reviewer]')
+					.orderBy('created_on', 'DESC')
+					.orderBy('id', 'DESC');
+
+				if (filters.status) {
+					query.andWhere('status', filters.status);
+				}
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Number}  id
+	 * @returns {Promise}
+	 */
+	getPending: (access, id) => {
+		return internalAccessRequest.get(access, {id: id})
+			.then((row) => {
+				if (row.status !== 'pending') {
+					throw new error.ValidationError('Access request has already been ' + row.status);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * Lets the address in through the access list, first so a deny rule of the list doesn't keep it out,
+	 * until it expires
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.hours]  0 for good, the setting's when it's left out
+	 * @param   {String}  [data.comment]
+	 * @returns {Promise}
+	 */
+	approve: (access, data) => {
+		// Required here, as the access lists are written to nginx, which requires this module
+		const internalAccessList = require('./access-list');
+
+		let row        = null;
+		let expires_on = null;
+
+		return access.can('access_requests:approve', data.id)
+			.then(() => {
+				return Promise.all([
+					internalAccessRequest.getPending(access, data.id),
+					internalAccessRequest.getSetting()
+				]);
+			})
+			.then(([pending, setting]) => {
+				row = pending;
+
+				let hours = typeof data.hours === 'number' ? data.hours : DEFAULT_HOURS;
+				if (typeof data.hours !== 'number' && setting && typeof setting.meta.hours === 'number') {
+					hours = setting.meta.hours;
+				}
+
+				expires_on = hours ? moment().add(hours, 'hours').format(DATE_FORMAT) : null;
+
+				return internalAccessList.get(access, {id: row.access_list_id, expand: ['clients']});
+			})
+			.then((list) => {
+				const clients = list.clients
+					.filter((client) => !(client.address === row.address && client.directive === 'allow'))
+					.map((client) => {
+						return {
+							address:    client.address,
+							directive:  client.directive,
+							expires_on: client.meta && client.meta.expires_on ? client.meta.expires_on : null
+						};
+					});
+
+				return internalAccessList.update(access, {
+					id:      list.id,
+					clients: [{address: row.address, directive: 'allow', expires_on: expires_on}].concat(clients)
+				});
+			})
+			.then(() => {
+				return internalAccessRequest.review(access, row, 'approved', {expires_on: expires_on, comment: data.comment || ''});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.comment]
+	 * @returns {Promise}
+	 */
+	reject: (access, data) => {
+		return access.can('access_requests:approve', data.id)
+			.then(() => {
+				return internalAccessRequest.getPending(access, data.id);
+			})
+			.then((row) => {
+				return internalAccessRequest.review(access, row, 'rejected', {comment: data.comment || ''});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  row
+	 * @param   {String}  status
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	review: (access, row, status, data) => {
+		return accessRequestModel
+			.query()
+			.patchAndFetchById(row.id, {
+				status:           status,
+				reviewer_user_id: access.token.getUserId(1),
+				expires_on:       data.expires_on || null,
+				meta:             _.assign({}, row.meta, {comment: data.comment})
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      status,
+					object_type: 'access-request',
+					object_id:   saved_row.id,
+					meta:        saved_row
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * Triggered by a timer, this writes the hosts of the access lists with clients that expired since the last run
+	 * without them, and removes the clients that expired a while ago
+	 *
+	 * @returns {Promise}
+	 */
+	processExpired: () => {
+		if (internalAccessRequest.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalAccessRequest.intervalProcessing = true;
+
+		const now   = moment();
+		const since = internalAccessRequest.lastChecked;
+
+		return accessListClientModel
+			.query()
+			.then((clients) => {
+				const expired = clients.filter((client) => isExpired(client, now));
+				const ids     = _.uniq(_.map(expired.filter((client) => !since || moment(client.meta.expires_on).isAfter(since)), 'access_list_id'));
+				const old     = _.map(expired.filter((client) => moment(client.meta.expires_on).isBefore(now.clone().subtract(REMOVE_AFTER_HOURS, 'hours'))), 'id');
+
+				return (ids.length ? require('./access-list-dns').regenerate(ids) : Promise.resolve())
+					.then(() => {
+						if (old.length) {
+							return accessListClientModel
+								.query()
+								.delete()
+								.whereIn('id', old);
+						}
+					});
+			})
+			.then(() => {
+				internalAccessRequest.lastChecked        = now;
+				internalAccessRequest.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalAccessRequest.intervalProcessing = false;
+			});
+	}
+};
+
+module.exports = internalAccessRequest;
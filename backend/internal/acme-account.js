@@ -0,0 +1,727 @@
+const _                     = require('lodash');
+const fs                    = require('fs');
+const os                    = require('os');
+const path                  = require('path');
+const crypto                = require('crypto');
+const moment                = require('moment');
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const acme                  = require('../lib/acme');
+const config                = require('../lib/config');
+const logger                = require('../logger').certbot;
+const acmeAccountModel      = require('../models/acme_account');
+const certificateModel      = require('../models/certificate');
+const internalAuditLog      = require('./audit-log');
+const internalNotifications = require('./notifications');
+const internalAcmeEab       = require('./acme-eab-credential');
+const {castJsonIfNeed}      = require('../lib/helpers');
+
+const LE_PRODUCTION = 'https://acme-v02.api.letsencrypt.org/directory';
+const LE_STAGING    = 'https://acme-staging-v02.api.letsencrypt.org/directory';
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalAcmeAccount = {
+
+	/**
+	 * The directory certbot uses when no account is selected
+	 *
+	 * @returns {String}
+	 */
+	getDefaultServer: () => {
+		if (config.useLetsencryptServer() !== null) {
+			return config.useLetsencryptServer();
+		}
+		return config.useLetsencryptStaging() ? LE_STAGING : LE_PRODUCTION;
+	},
+
+	/**
+	 * Where certbot keeps accounts for a directory URL
+	 *
+	 * @param   {String}  server
+	 * @returns {String}
+	 */
+	getAccountsDir: (server) => {
+		const url = new URL(server);
+		return path.join(config.getPath('letsencrypt'), 'accounts', url.host + url.pathname);
+	},
+
+	/**
+	 * Certbot names account folders after the md5 of the PEM encoded public key
+	 *
+	 * @param   {KeyObject}  key
+	 * @returns {String}
+	 */
+	getCertbotId: (key) => {
+		const pem = crypto.createPublicKey(key).export({type: 'spki', format: 'pem'});
+		return crypto.createHash('md5').update(pem).digest('hex');
+	},
+
+	/**
+	 * @param   {Object}  account  acme_account row
+	 * @returns {KeyObject}
+	 */
+	loadKey: (account) => {
+		const file = path.join(internalAcmeAccount.getAccountsDir(account.server), account.account_id, 'private_key.json');
+		if (!fs.existsSync(file)) {
+			throw new error.ConfigurationError('Key for ACME account #' + account.id + ' is missing from ' + file);
+		}
+
+		return crypto.createPrivateKey({key: JSON.parse(fs.readFileSync(file, {encoding: 'utf8'})), format: 'jwk'});
+	},
+
+	/**
+	 * Writes the account in certbot's own format so that certbot can use it with --account
+	 *
+	 * @param   {String}     server
+	 * @param   {String}     account_url
+	 * @param   {KeyObject}  key
+	 * @returns {String}     the certbot account id
+	 */
+	writeCertbotAccount: (server, account_url, key) => {
+		const account_id = internalAcmeAccount.getCertbotId(key);
+		const dir        = path.join(internalAcmeAccount.getAccountsDir(server), account_id);
+
+		fs.mkdirSync(dir, {recursive: true, mode: 0o700});
+		fs.writeFileSync(path.join(dir, 'private_key.json'), JSON.stringify(key.export({format: 'jwk'})), {encoding: 'utf8', mode: 0o400});
+		fs.writeFileSync(path.join(dir, 'regr.json'), JSON.stringify({body: {}, uri: account_url}), {encoding: 'utf8', mode: 0o644});
+		fs.writeFileSync(path.join(dir, 'meta.json'), JSON.stringify({
+			creation_dt:   moment.utc().format('YYYY-MM-DDTHH:mm:ss') + 'Z',
+			creation_host: os.hostname()
+		}), {encoding: 'utf8', mode: 0o644});
+
+		return account_id;
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  data.email
+	 * @param   {String}  [data.server]
+	 * @param   {String}  [data.eab_kid]
+	 * @param   {String}  [data.eab_hmac_key]
+	 * @param   {Number}  [data.eab_credential_id]  stored EAB credentials to bind it with instead
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('acme_accounts:create', data)
+			.then(() => {
+				return internalAcmeAccount.register(access, data);
+			});
+	},
+
+	/**
+	 * Registers an account with the CA, for create and for the certificates on stored EAB credentials
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	register: (access, data) => {
+		const key = crypto.generateKeyPairSync('rsa', {modulusLength: 2048}).privateKey;
+
+		let server  = data.server || internalAcmeAccount.getDefaultServer();
+		let binding = null;
+
+		return Promise.resolve()
+			.then(() => {
+				if (!data.eab_credential_id) {
+					return;
+				}
+
+				if (data.eab_kid || data.eab_hmac_key) {
+					throw new error.ValidationError('Give either the stored EAB credentials or the eab_kid and eab_hmac_key, not both');
+				}
+
+				return internalAcmeEab.getBinding(access, data.eab_credential_id, data.server)
+					.then((result) => {
+						binding = result;
+						server  = binding.server;
+					});
+			})
+			.then(() => {
+				logger.info('Registering ACME account for ' + data.email + ' with ' + server);
+				return acme.newAccount(server, key, binding ? _.assign({}, data, binding) : data);
+			})
+			.then((result) => {
+				const account_id = internalAcmeAccount.writeCertbotAccount(server, result.url, key);
+
+				return acmeAccountModel
+					.query()
+					.insertAndFetch({
+						name:        data.name,
+						email:       data.email,
+						server:      server,
+						account_id:  account_id,
+						account_url: result.url,
+						status:      (result.body && result.body.status) || 'valid',
+						tenant_id:   access.getTenantId(),
+						meta:        {
+							eab_kid:           binding ? binding.eab_kid : (data.eab_kid || null),
+							eab_credential_id: binding ? binding.id : null
+						}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				return (binding ? internalAcmeEab.markUsed(binding.id, row.id) : Promise.resolve())
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'created',
+							object_type: 'acme-account',
+							object_id:   row.id,
+							meta:        row
+						});
+					})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * The account a certificate on stored EAB credentials is requested with. An account already
+	 * registered with them for the same email is used again, otherwise one is registered.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Number}  eab_credential_id
+	 * @param   {String}  email
+	 * @returns {Promise}  resolves with the account
+	 */
+	getForEabCredential: (access, eab_credential_id, email) => {
+		return acmeAccountModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('status', 'valid')
+			.andWhere('email', email)
+			.andWhere(castJsonIfNeed('meta'), 'like', '%"eab_credential_id":' + eab_credential_id + '%')
+			.whereIn('tenant_id', _.uniq([0, access.getTenantId()]))
+			.then((rows) => {
+				const account = rows.find((row) => row.meta.eab_credential_id === eab_credential_id);
+				if (account) {
+					return account;
+				}
+
+				return internalAcmeEab.getBinding(access, eab_credential_id)
+					.then((binding) => {
+						return internalAcmeAccount.register(access, {
+							name:              binding.name + ' (' + email + ')',
+							email:             email,
+							eab_credential_id: eab_credential_id
+						});
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.email]
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		return access.can('acme_accounts:update', data.id)
+			.then(() => {
+				return internalAcmeAccount.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				if (typeof data.email !== 'undefined' && data.email !== row.email) {
+					if (row.status !== 'valid') {
+						throw new error.ValidationError('Cannot change the email of a ' + row.status + ' account');
+					}
+
+					return acme.updateAccount(row.server, row.account_url, internalAcmeAccount.loadKey(row), {contact: ['mailto:' + data.email]})
+						.then(() => {
+							return row;
+						});
+				}
+				return row;
+			})
+			.then((row) => {
+				return acmeAccountModel
+					.query()
+					.patchAndFetchById(row.id, _.pick(data, ['name', 'email']))
+					.then(utils.omitRow(omissions()))
+					.then((saved_row) => {
+						if (saved_row.email === row.email) {
+							return saved_row;
+						}
+
+						return internalAcmeAccount.syncEmail([row.email], saved_row.email, (certificate) => certificate.meta.acme_account_id === row.id)
+							.then(() => {
+								return saved_row;
+							});
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'acme-account',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * Gives the certificates the new address, so lego and certbot register with it where they still have to,
+	 * and the email notification channels sending to the old address send to it instead
+	 *
+	 * @param   {Array}     old_emails
+	 * @param   {String}    email
+	 * @param   {Function}  filter      which letsencrypt certificates to change
+	 * @returns {Promise}  resolves with {certificates, channels}
+	 */
+	syncEmail: (old_emails, email, filter) => {
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('provider', 'letsencrypt')
+			.then((certificates) => {
+				const changed = certificates.filter((certificate) => certificate.meta && filter(certificate) && certificate.meta.letsencrypt_email !== email);
+
+				return Promise.all(changed.map((certificate) => {
+					return certificateModel
+						.query()
+						.where('id', certificate.id)
+						.patch({meta: _.assign({}, certificate.meta, {letsencrypt_email: email})});
+				}))
+					.then(() => {
+						return internalNotifications.replaceRecipients(_.uniq(_.compact(old_emails)), email);
+					})
+					.then((channels) => {
+						return {
+							certificates: changed.map((certificate) => certificate.id),
+							channels:     channels
+						};
+					});
+			});
+	},
+
+	/**
+	 * The accounts certbot registered by itself, for the certificates without an ACME account, by their
+	 * folders in the accounts directory, ie: accounts/acme-v02.api.letsencrypt.org/directory/<id>
+	 *
+	 * @returns {Promise}  resolves with [{server, account_id, account_url}]
+	 */
+	getCertbotAccounts: () => {
+		const root = path.join(config.getPath('letsencrypt'), 'accounts');
+
+		const walk = (dir) => {
+			if (fs.existsSync(path.join(dir, 'regr.json'))) {
+				return [dir];
+			}
+			return _.flatten(fs.readdirSync(dir, {withFileTypes: true})
+				.filter((entry) => entry.isDirectory())
+				.map((entry) => walk(path.join(dir, entry.name))));
+		};
+
+		return acmeAccountModel
+			.query()
+			.then((rows) => {
+				const managed = _.map(rows, 'account_id');
+
+				return (fs.existsSync(root) ? walk(root) : [])
+					.filter((dir) => managed.indexOf(path.basename(dir)) === -1)
+					.map((dir) => {
+						let account_url = null;
+						try {
+							account_url = JSON.parse(fs.readFileSync(path.join(dir, 'regr.json'), {encoding: 'utf8'})).uri;
+						} catch (err) {
+							// Left out below, it can't be changed without its url
+						}
+
+						return {
+							server:      'https://' + path.relative(root, path.dirname(dir)),
+							account_id:  path.basename(dir),
+							account_url: account_url
+						};
+					})
+					.filter((account) => !!account.account_url);
+			});
+	},
+
+	/**
+	 * Changes the contact of the accounts certbot registered by itself with each CA, which is where the CA sends
+	 * its warnings about expiring certificates. The certificates without an ACME account and the email
+	 * notification channels follow.
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {String}   data.email
+	 * @returns {Promise}
+	 */
+	updateContact: (access, data) => {
+		let accounts = [];
+
+		return access.can('acme_accounts:contact')
+			.then(() => {
+				return internalAcmeAccount.getCertbotAccounts();
+			})
+			.then((found) => {
+				// One at a time, a CA failing shouldn't keep the others from being changed
+				return found.reduce((sequence, account) => {
+					return sequence
+						.then(() => {
+							return acme.updateAccount(account.server, account.account_url, internalAcmeAccount.loadKey(account), {contact: ['mailto:' + data.email]});
+						})
+						.then(() => {
+							logger.info('Changed the contact of certbot account ' + account.account_id + ' to ' + data.email);
+							accounts.push({server: account.server, account_id: account.account_id, success: true, error: null});
+						}, (err) => {
+							logger.warn('Could not change the contact of certbot account ' + account.account_id + ': ' + err.message);
+							accounts.push({server: account.server, account_id: account.account_id, success: false, error: err.message});
+						});
+				}, Promise.resolve());
+			})
+			.then(() => {
+				return certificateModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('provider', 'letsencrypt');
+			})
+			.then((certificates) => {
+				const unmanaged  = (certificate) => !!certificate.meta && !certificate.meta.acme_account_id;
+				const old_emails = certificates.filter(unmanaged).map((certificate) => certificate.meta.letsencrypt_email);
+
+				return internalAcmeAccount.syncEmail(old_emails, data.email, unmanaged);
+			})
+			.then((synced) => {
+				const result = {
+					email:        data.email,
+					accounts:     accounts,
+					certificates: synced.certificates,
+					channels:     synced.channels
+				};
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'acme-account',
+					object_id:   0,
+					meta:        result
+				})
+					.then(() => {
+						return result;
+					});
+			});
+	},
+
+	/**
+	 * Replaces the account key using ACME key rollover. Certbot's copy of the account
+	 * and the renewal configs that refer to it are moved to the new account id.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	rotateKey: (access, data) => {
+		const new_key = crypto.generateKeyPairSync('rsa', {modulusLength: 2048}).privateKey;
+
+		return access.can('acme_accounts:update', data.id)
+			.then(() => {
+				return internalAcmeAccount.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.status !== 'valid') {
+					throw new error.ValidationError('Cannot rotate the key of a ' + row.status + ' account');
+				}
+
+				return acme.keyChange(row.server, row.account_url, internalAcmeAccount.loadKey(row), new_key)
+					.then(() => {
+						const old_id = row.account_id;
+						const new_id = internalAcmeAccount.writeCertbotAccount(row.server, row.account_url, new_key);
+
+						fs.rmSync(path.join(internalAcmeAccount.getAccountsDir(row.server), old_id), {recursive: true, force: true});
+						internalAcmeAccount.replaceRenewalAccount(old_id, new_id);
+
+						logger.info('Rotated key for ACME account #' + row.id + ', certbot account ' + old_id + ' is now ' + new_id);
+
+						return acmeAccountModel
+							.query()
+							.patchAndFetchById(row.id, {account_id: new_id})
+							.then(utils.omitRow(omissions()));
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'rotated',
+					object_type: 'acme-account',
+					object_id:   saved_row.id,
+					meta:        saved_row
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {String}  old_id
+	 * @param   {String}  new_id
+	 */
+	replaceRenewalAccount: (old_id, new_id) => {
+		const dir = path.join(config.getPath('letsencrypt'), 'renewal');
+		if (!fs.existsSync(dir)) {
+			return;
+		}
+
+		fs.readdirSync(dir)
+			.filter((file) => file.endsWith('.conf'))
+			.map((file) => {
+				const filename = path.join(dir, file);
+				const content  = fs.readFileSync(filename, {encoding: 'utf8'});
+				const updated  = content.replace(new RegExp('^account = ' + old_id + '$', 'm'), 'account = ' + new_id);
+
+				if (updated !== content) {
+					fs.writeFileSync(filename, updated, {encoding: 'utf8'});
+				}
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	deactivate: (access, data) => {
+		return access.can('acme_accounts:update', data.id)
+			.then(() => {
+				return internalAcmeAccount.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.status !== 'valid') {
+					throw new error.ValidationError('Account is already ' + row.status);
+				}
+
+				return internalAcmeAccount.assertNotInUse(row)
+					.then(() => {
+						return acme.updateAccount(row.server, row.account_url, internalAcmeAccount.loadKey(row), {status: 'deactivated'});
+					})
+					.then(() => {
+						return acmeAccountModel
+							.query()
+							.patchAndFetchById(row.id, {status: 'deactivated'})
+							.then(utils.omitRow(omissions()));
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'deactivated',
+					object_type: 'acme-account',
+					object_id:   saved_row.id,
+					meta:        saved_row
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('acme_accounts:delete', data.id)
+			.then(() => {
+				return internalAcmeAccount.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalAcmeAccount.assertNotInUse(row)
+					.then(() => {
+						return acmeAccountModel
+							.query()
+							.where('id', row.id)
+							.patch({
+								is_deleted: 1
+							});
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'acme-account',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {Promise}
+	 */
+	assertNotInUse: (row) => {
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere(castJsonIfNeed('meta'), 'like', '%"acme_account_id":' + row.id + '%')
+			.then((certificates) => {
+				const used_by = certificates.filter((certificate) => certificate.meta.acme_account_id === row.id);
+				if (used_by.length) {
+					throw new error.ValidationError('ACME account is used by ' + used_by.length + ' certificate(s): ' + used_by.map((certificate) => certificate.nice_name).join(', '));
+				}
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('acme_accounts:get', data.id)
+			.then((access_data) => {
+				let query = acmeAccountModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first();
+
+				if (access_data.tenant_id) {
+					// Accounts shared by admins outside of any tenant, and their own
+					query.whereIn('tenant_id', [0, access_data.tenant_id]);
+				}
+
+				return query.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * Like get, but tenants can only change their own accounts and not the shared ones
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	getWritable: (access, data) => {
+		return internalAcmeAccount.get(access, data)
+			.then((row) => {
+				const tenant_id = access.getTenantId();
+				if (tenant_id && row.tenant_id !== tenant_id) {
+					throw new error.PermissionError('This ACME account is shared with every tenant and can only be changed by an administrator');
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('acme_accounts:list')
+			.then((access_data) => {
+				let query = acmeAccountModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (access_data.tenant_id) {
+					query.whereIn('tenant_id', [0, access_data.tenant_id]);
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * The directory URL and account a certificate will be requested with
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Promise}  resolves with {server, account}
+	 */
+	getCertificateServer: (certificate) => {
+		const account_id = certificate.meta ? certificate.meta.acme_account_id : null;
+
+		if (!account_id) {
+			return Promise.resolve({
+				server:  certificate.meta && certificate.meta.use_staging ? LE_STAGING : internalAcmeAccount.getDefaultServer(),
+				account: 'default'
+			});
+		}
+
+		return acmeAccountModel
+			.query()
+			.where('id', account_id)
+			.first()
+			.then((account) => {
+				return {
+					server:  account ? account.server : internalAcmeAccount.getDefaultServer(),
+					account: String(account_id)
+				};
+			});
+	},
+
+	/**
+	 * The certbot arguments that select the CA, and the account when the certificate has one
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Promise}
+	 */
+	getCertbotArgs: (certificate) => {
+		const account_id = certificate.meta ? certificate.meta.acme_account_id : null;
+
+		if (!account_id) {
+			const server  = config.useLetsencryptServer();
+			const staging = config.useLetsencryptStaging();
+
+			if (certificate.meta && certificate.meta.use_staging) {
+				return Promise.resolve(`--server '${LE_STAGING}' `);
+			}
+
+			return Promise.resolve(
+				(server !== null ? `--server '${server}' ` : '') +
+				(staging && server === null ? '--staging ' : '')
+			);
+		}
+
+		return acmeAccountModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('id', account_id)
+			.first()
+			.then((account) => {
+				if (!account) {
+					throw new error.ValidationError('ACME account #' + account_id + ' does not exist');
+				}
+				if (account.status !== 'valid') {
+					throw new error.ValidationError('ACME account ' + account.name + ' is ' + account.status);
+				}
+
+				return `--server '${account.server}' --account '${account.account_id}' `;
+			});
+	}
+};
+
+module.exports = internalAcmeAccount;
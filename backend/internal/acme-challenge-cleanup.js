@@ -0,0 +1,162 @@
+const _                = require('lodash');
+const crypto           = require('crypto');
+const logger           = require('../logger').ssl;
+const helpers          = require('../lib/helpers');
+const dnsPacket        = require('../lib/dns-packet');
+const certificateModel = require('../models/certificate');
+const internalActivity = require('./activity');
+const internalDnsCheck = require('./dns-check');
+const internalCertTlsa = require('./certificate-tlsa');
+
+// The challenge records found on the last run, by name, {values, reported}
+let found = {};
+
+const internalAcmeChallengeCleanup = {
+
+	intervalTimeout:    1000 * 60 * 60, // 1 hour
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('ACME Challenge Cleanup Timer initialized');
+		internalAcmeChallengeCleanup.interval = setInterval(internalAcmeChallengeCleanup.processRecords, internalAcmeChallengeCleanup.intervalTimeout);
+	},
+
+	/**
+	 * The challenge records of a certificate, one for each name, a wildcard sharing it with its base domain
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Array}   ie: ['_acme-challenge.example.com']
+	 */
+	getNames: (certificate) => {
+		return _.uniq(certificate.domain_names.map((domain) => '_acme-challenge.' + helpers.toAsciiDomain(domain.replace(/^\*\./, '')).toLowerCase()));
+	},
+
+	/**
+	 * The TXT values the nameservers of its zone have for a name. A name delegated elsewhere with a CNAME,
+	 * ie: to acme-dns, has none of its own.
+	 *
+	 * @param   {String}  name
+	 * @returns {Promise}
+	 */
+	lookup: (name) => {
+		return internalDnsCheck.getNameservers(name)
+			.then((nameservers) => {
+				return Promise.all(nameservers.map((nameserver) => internalDnsCheck.query(nameserver.address, name, 'TXT').catch(() => [])));
+			})
+			.then((values) => _.uniq(_.flatten(values)).sort())
+			.catch(() => []);
+	},
+
+	/**
+	 * Removes TXT values from a name, with the RFC 2136 credentials of the certificate
+	 *
+	 * @param   {Object}  certificate
+	 * @param   {String}  name
+	 * @param   {Array}   values
+	 * @returns {Promise}
+	 */
+	remove: (certificate, name, values) => {
+		const server = internalCertTlsa.getServer(certificate);
+
+		return internalCertTlsa.getZone(server, name)
+			.then((zone) => {
+				return internalCertTlsa.send(server, dnsPacket.encodeUpdate({
+					id:      crypto.randomInt(0x10000),
+					zone:    zone,
+					key:     server.key,
+					// Only those values, so a challenge started since is left alone
+					records: values.map((value) => {
+						return {name: name, type: 'TXT', class: 'NONE', ttl: 0, data: value};
+					})
+				}));
+			})
+			.then((response) => {
+				const rcode = dnsPacket.getRcode(response);
+				if (rcode !== 'NOERROR') {
+					throw new Error(server.server + ' refused to remove the records of ' + name + ': ' + rcode);
+				}
+			});
+	},
+
+	/**
+	 * Triggered by a timer, this looks for challenge records left at the DNS providers of the certificates by
+	 * issuances that failed. A challenge is over in minutes, so a value still there an hour later is stale.
+	 * They're removed from RFC 2136 servers, and recorded in the activity of the certificate for the other providers,
+	 * which certbot and lego can only clean up after their own runs.
+	 *
+	 * @returns {Promise}
+	 */
+	processRecords: () => {
+		if (internalAcmeChallengeCleanup.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalAcmeChallengeCleanup.intervalProcessing = true;
+
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('provider', 'letsencrypt')
+			.then((certificates) => {
+				const last = found;
+				found      = {};
+
+				let checked = [];
+
+				return certificates.filter((certificate) => certificate.meta && certificate.meta.dns_challenge).reduce((sequence, certificate) => {
+					// Certificates for the same names share their records
+					const names = _.difference(internalAcmeChallengeCleanup.getNames(certificate), checked);
+					checked     = checked.concat(names);
+
+					return names.reduce((names_sequence, name) => {
+						return names_sequence
+							.then(() => internalAcmeChallengeCleanup.lookup(name))
+							.then((values) => {
+								if (!values.length) {
+									return;
+								}
+
+								const before = last[name] || {values: [], reported: []};
+								const stale  = _.intersection(values, before.values);
+								const fresh  = _.difference(stale, before.reported);
+
+								found[name] = {values: values, reported: _.intersection(before.reported, stale)};
+
+								if (!fresh.length) {
+									return;
+								}
+
+								if (!internalCertTlsa.canPublish(certificate)) {
+									logger.warn('Stale challenge records at ' + name + ', ' + certificate.meta.dns_provider + ' can only be cleaned up by hand');
+									found[name].reported = found[name].reported.concat(fresh);
+									return internalActivity.record('certificate', certificate.id, 'acme', 'stale-challenge', false, fresh.length + ' stale TXT record(s) at ' + name + ' need removing at ' + certificate.meta.dns_provider);
+								}
+
+								return Promise.resolve()
+									.then(() => internalAcmeChallengeCleanup.remove(certificate, name, fresh))
+									.then(() => {
+										logger.success('Removed ' + fresh.length + ' stale challenge record(s) at ' + name);
+										found[name].values = _.difference(values, fresh);
+										return internalActivity.record('certificate', certificate.id, 'acme', 'stale-challenge', true, 'Removed ' + fresh.length + ' stale TXT record(s) at ' + name);
+									}, (err) => {
+										// Tried again on the next run
+										logger.error('Removing the stale challenge records at ' + name + ' failed: ' + err.message);
+										return internalActivity.record('certificate', certificate.id, 'acme', 'stale-challenge', false, err.message);
+									});
+							});
+					}, sequence);
+				}, Promise.resolve());
+			})
+			.then(() => {
+				internalAcmeChallengeCleanup.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalAcmeChallengeCleanup.intervalProcessing = false;
+			});
+	}
+};
+
+module.exports = internalAcmeChallengeCleanup;
@@ -0,0 +1,470 @@
+const _                = require('lodash');
+const net              = require('net');
+const dgram            = require('dgram');
+const crypto           = require('crypto');
+const bcrypt           = require('bcrypt');
+const moment           = require('moment');
+const logger           = require('../logger').certbot;
+const error            = require('../lib/error');
+const dnsPacket        = require('../lib/dns-packet');
+const acmeDnsModel     = require('../models/acme_dns_account');
+const settingModel     = require('../models/setting');
+const internalAuditLog = require('./audit-log');
+
+// Challenges are only looked up once, so they shouldn't be cached
+const TTL = 1;
+
+// Let's Encrypt challenge tokens are base64url encoded SHA-256 digests
+const TXT_VALUE = /^[A-Za-z0-9_-]{43}$/;
+
+function omissions () {
+	return ['password'];
+}
+
+const internalAcmeDns = {
+
+	udp: null,
+	tcp: null,
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'acme-dns')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  meta  of the setting, when it's turned on
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (!meta || !meta.domain) {
+			return Promise.reject(new error.ValidationError('The ACME DNS server needs the domain delegated to it'));
+		}
+
+		if (meta.address && !net.isIP(meta.address)) {
+			return Promise.reject(new error.ValidationError(meta.address + ' is not an IP address'));
+		}
+
+		const nameserver = (meta.nameserver || meta.domain).toLowerCase();
+		if (!meta.address && (nameserver === meta.domain.toLowerCase() || _.endsWith(nameserver, '.' + meta.domain.toLowerCase()))) {
+			return Promise.reject(new error.ValidationError('The address of the nameserver is needed when it\'s inside the delegated domain'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Starts the DNS server when the setting is on
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return internalAcmeDns.configure()
+			.catch((err) => {
+				// Something else on the port shouldn't stop the backend from starting
+				logger.error('Could not start the ACME DNS server: ' + err.message);
+			});
+	},
+
+	/**
+	 * Stops the DNS server and starts it again with the current setting
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return internalAcmeDns.stop()
+			.then(internalAcmeDns.getSetting)
+			.then((setting) => {
+				if (!setting || setting.value !== 'on' || !setting.meta || !setting.meta.domain) {
+					return;
+				}
+
+				return internalAcmeDns.start(setting.meta.port || 53);
+			});
+	},
+
+	/**
+	 * @param   {Number}  port
+	 * @returns {Promise}
+	 */
+	start: (port) => {
+		const udp = dgram.createSocket('udp4');
+		const tcp = net.createServer((socket) => {
+			let buffer = Buffer.alloc(0);
+
+			socket.setTimeout(10000, () => socket.destroy());
+			socket.on('error', () => {});
+			socket.on('data', (chunk) => {
+				buffer = Buffer.concat([buffer, chunk]);
+
+				// Each message has its length in front
+				while (buffer.length >= 2 && buffer.length >= buffer.readUInt16BE(0) + 2) {
+					const message = buffer.subarray(2, buffer.readUInt16BE(0) + 2);
+					buffer        = buffer.subarray(message.length + 2);
+
+					internalAcmeDns.handle(message)
+						.then((response) => {
+							const length = Buffer.alloc(2);
+							length.writeUInt16BE(response.length, 0);
+							socket.write(Buffer.concat([length, response]));
+						})
+						.catch(() => socket.destroy());
+				}
+			});
+		});
+
+		udp.on('message', (message, remote) => {
+			internalAcmeDns.handle(message)
+				.then((response) => {
+					udp.send(response, remote.port, remote.address);
+				})
+				.catch(() => {});
+		});
+
+		return Promise.all([
+			new Promise((resolve, reject) => {
+				udp.once('error', reject);
+				udp.bind(port, () => {
+					udp.removeListener('error', reject);
+					udp.on('error', (err) => logger.error('ACME DNS server: ' + err.message));
+					resolve();
+				});
+			}),
+			new Promise((resolve, reject) => {
+				tcp.once('error', reject);
+				tcp.listen(port, () => {
+					tcp.removeListener('error', reject);
+					resolve();
+				});
+			})
+		])
+			.then(() => {
+				internalAcmeDns.udp = udp;
+				internalAcmeDns.tcp = tcp;
+				logger.info('ACME DNS server listening on port ' + port);
+			})
+			.catch((err) => {
+				try {
+					udp.close();
+				} catch (close_err) {
+					// wasn't bound
+				}
+				tcp.close();
+				throw err;
+			});
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	stop: () => {
+		const udp = internalAcmeDns.udp;
+		const tcp = internalAcmeDns.tcp;
+
+		internalAcmeDns.udp = null;
+		internalAcmeDns.tcp = null;
+
+		if (udp) {
+			udp.close();
+		}
+
+		if (!tcp) {
+			return Promise.resolve();
+		}
+
+		return new Promise((resolve) => {
+			tcp.close(() => resolve());
+		});
+	},
+
+	/**
+	 * @param   {Buffer}  message
+	 * @returns {Promise}  resolves with the response
+	 */
+	handle: (message) => {
+		let query = null;
+
+		try {
+			query = dnsPacket.parse(message);
+		} catch (err) {
+			return Promise.reject(err);
+		}
+
+		return internalAcmeDns.getSetting()
+			.then((setting) => {
+				if (query.questions.length !== 1 || query.questions[0].class !== 1) {
+					return {rcode: 'NOTIMP'};
+				}
+
+				return internalAcmeDns.resolve(setting.meta, query.questions[0]);
+			})
+			.catch((err) => {
+				logger.error('ACME DNS server: ' + err.message);
+				return {rcode: 'SERVFAIL'};
+			})
+			.then((response) => {
+				return dnsPacket.encode(query, response);
+			});
+	},
+
+	/**
+	 * The records for a question about the zone, which has the nameserver and a name for each account
+	 *
+	 * @param   {Object}  meta      of the setting
+	 * @param   {Object}  question  {name, type}
+	 * @returns {Promise}  resolves with the response for dnsPacket.encode()
+	 */
+	resolve: (meta, question) => {
+		const zone       = meta.domain.toLowerCase();
+		const nameserver = (meta.nameserver || zone).toLowerCase();
+		const name       = question.name;
+
+		const soa = {
+			name: zone,
+			type: 'SOA',
+			ttl:  TTL,
+			data: {
+				mname:   nameserver,
+				rname:   (meta.email || 'hostmaster@' + zone).replace('@', '.'),
+				serial:  parseInt(moment.utc().format('YYYYMMDD'), 10) * 100,
+				refresh: 28800,
+				retry:   7200,
+				expire:  604800,
+				minimum: TTL
+			}
+		};
+
+		const wants = (type) => question.type === type || question.type === 'ANY';
+
+		let response = {authoritative: true, answers: []};
+
+		if (name !== zone && !_.endsWith(name, '.' + zone)) {
+			return Promise.resolve({rcode: 'REFUSED'});
+		}
+
+		if (name === zone || name === nameserver) {
+			if (name === zone && wants('SOA')) {
+				response.answers.push(soa);
+			}
+			if (name === zone && wants('NS')) {
+				response.answers.push({name: zone, type: 'NS', ttl: 3600, data: nameserver});
+			}
+			if (name === nameserver && meta.address && wants(net.isIPv6(meta.address) ? 'AAAA' : 'A')) {
+				response.answers.push({name: nameserver, type: net.isIPv6(meta.address) ? 'AAAA' : 'A', ttl: 3600, data: meta.address});
+			}
+			if (!response.answers.length) {
+				response.authorities = [soa];
+			}
+			return Promise.resolve(response);
+		}
+
+		// The subdomain of an account, ie: 8e5700ea-a4bf-41c7-8a77-e990661dcc6a.acme.example.com
+		const subdomain = name.substring(0, name.length - zone.length - 1);
+
+		return acmeDnsModel
+			.query()
+			.where('subdomain', subdomain)
+			.first()
+			.then((row) => {
+				if (!row) {
+					return {rcode: 'NXDOMAIN', authoritative: true, authorities: [soa]};
+				}
+
+				if (wants('TXT')) {
+					response.answers = (row.txt || []).map((item) => {
+						return {name: name, type: 'TXT', ttl: TTL, data: item.value};
+					});
+				}
+				if (!response.answers.length) {
+					response.authorities = [soa];
+				}
+				return response;
+			});
+	},
+
+	/**
+	 * Creates credentials for one certificate, in the format acme-dns clients expect
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Array}   [data.allowfrom]  networks allowed to update the record, ie: ['192.168.0.0/16']
+	 * @returns {Promise}
+	 */
+	register: (access, data) => {
+		const password = crypto.randomBytes(30).toString('base64url');
+
+		return access.can('acme_dns:create')
+			.then(internalAcmeDns.getSetting)
+			.then((setting) => {
+				if (!setting || setting.value !== 'on' || !setting.meta || !setting.meta.domain) {
+					throw new error.ValidationError('The ACME DNS server is turned off');
+				}
+
+				return bcrypt.hash(password, 10)
+					.then((hash) => {
+						return acmeDnsModel
+							.query()
+							.insertAndFetch({
+								owner_user_id: access.token.getUserId(1),
+								username:      crypto.randomUUID(),
+								password:      hash,
+								subdomain:     crypto.randomUUID(),
+								allow_from:    data.allowfrom || [],
+								txt:           []
+							});
+					})
+					.then((row) => {
+						return internalAuditLog.add(access, {
+							action:      'created',
+							object_type: 'acme-dns-account',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						})
+							.then(() => {
+								return {
+									id:         row.id,
+									username:   row.username,
+									password:   password,
+									fulldomain: row.subdomain + '.' + setting.meta.domain.toLowerCase(),
+									subdomain:  row.subdomain,
+									allowfrom:  row.allow_from
+								};
+							});
+					});
+			});
+	},
+
+	/**
+	 * @param   {Array}   allow_from  networks in CIDR notation
+	 * @param   {String}  address
+	 * @returns {Boolean}
+	 */
+	isAllowed: (allow_from, address) => {
+		if (!allow_from || !allow_from.length) {
+			return true;
+		}
+
+		address          = (address || '').replace(/^::ffff:(\d+\.\d+\.\d+\.\d+)$/, '$1');
+		const type       = net.isIPv6(address) ? 'ipv6' : 'ipv4';
+		const block_list = new net.BlockList();
+
+		allow_from.forEach((network) => {
+			const [ip, prefix] = network.split('/');
+			const ip_type      = net.isIPv6(ip) ? 'ipv6' : 'ipv4';
+			block_list.addSubnet(ip, typeof prefix === 'undefined' ? (ip_type === 'ipv6' ? 128 : 32) : parseInt(prefix, 10), ip_type);
+		});
+
+		return net.isIP(address) !== 0 && block_list.check(address, type);
+	},
+
+	/**
+	 * Sets the challenge of an account, the acme-dns /update call.
+	 * The last two are kept, so a certificate for a domain and its wildcard can be validated together.
+	 *
+	 * @param   {Object}  credentials
+	 * @param   {String}  credentials.username
+	 * @param   {String}  credentials.password
+	 * @param   {String}  credentials.address    the client's
+	 * @param   {Object}  data
+	 * @param   {String}  data.subdomain
+	 * @param   {String}  data.txt
+	 * @returns {Promise}
+	 */
+	update: (credentials, data) => {
+		if (!credentials.username || !credentials.password) {
+			return Promise.reject(new error.AuthError('X-Api-User and X-Api-Key are required'));
+		}
+
+		return acmeDnsModel
+			.query()
+			.where('username', credentials.username)
+			.first()
+			.then((row) => {
+				if (!row) {
+					throw new error.AuthError('Invalid credentials');
+				}
+
+				return bcrypt.compare(credentials.password, row.password)
+					.then((valid) => {
+						if (!valid || row.subdomain !== data.subdomain) {
+							throw new error.AuthError('Invalid credentials');
+						}
+
+						if (!internalAcmeDns.isAllowed(row.allow_from, credentials.address)) {
+							throw new error.AuthError('Updates aren\'t allowed from ' + credentials.address);
+						}
+
+						if (!TXT_VALUE.test(data.txt)) {
+							throw new error.ValidationError('txt must be 43 characters of base64url');
+						}
+
+						return acmeDnsModel
+							.query()
+							.patchAndFetchById(row.id, {
+								txt: (row.txt || []).concat([{value: data.txt, updated_on: moment().toISOString()}]).slice(-2)
+							});
+					});
+			})
+			.then(() => {
+				return {txt: data.txt};
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('acme_dns:list')
+			.then(() => {
+				return acmeDnsModel
+					.query()
+					.orderBy('id', 'ASC');
+			})
+			.then((rows) => {
+				return rows.map((row) => _.omit(row, omissions()));
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('acme_dns:delete', data.id)
+			.then(() => {
+				return acmeDnsModel
+					.query()
+					.where('id', data.id)
+					.first();
+			})
+			.then((row) => {
+				if (!row) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+
+				return acmeDnsModel
+					.query()
+					.deleteById(row.id)
+					.then(() => {
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'acme-dns-account',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	}
+};
+
+module.exports = internalAcmeDns;
@@ -0,0 +1,351 @@
+const _                = require('lodash');
+const moment           = require('moment');
+const error            = require('../lib/error');
+const acmeEabModel     = require('../models/acme_eab_credential');
+const internalAuditLog = require('./audit-log');
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+// Credentials expiring within this many days are shown as expiring, so they can be replaced in time
+const EXPIRING_DAYS = 14;
+
+// HS256 keys shorter than this are refused by the CAs that hand them out
+const MIN_KEY_BYTES = 16;
+
+function omissions () {
+	return ['is_deleted', 'hmac_key'];
+}
+
+const internalAcmeEab = {
+
+	/**
+	 * CAs hand out the HMAC key base64 or base64url encoded, sometimes with padding or split over lines.
+	 * It's kept base64url encoded without padding, which is what the binding is signed with.
+	 *
+	 * @param   {String}  hmac_key
+	 * @returns {String}
+	 */
+	normaliseKey: (hmac_key) => {
+		const key = hmac_key.replace(/\s+/g, '').replace(/=+$/, '').replace(/\+/g, '-').replace(/\//g, '_');
+
+		if (!/^[A-Za-z0-9_-]+$/.test(key)) {
+			throw new error.ValidationError('The HMAC key isn\'t base64url encoded, copy it as the CA gives it');
+		}
+		if (Buffer.from(key, 'base64url').length < MIN_KEY_BYTES) {
+			throw new error.ValidationError('The HMAC key is too short, it has to be at least ' + MIN_KEY_BYTES + ' bytes');
+		}
+
+		return key;
+	},
+
+	/**
+	 * @param   {Object}  data  payload, its hmac_key and expires_on are normalised in place
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		return Promise.resolve()
+			.then(() => {
+				if (typeof data.server !== 'undefined') {
+					let url;
+					try {
+						url = new URL(data.server);
+					} catch (err) {
+						throw new error.ValidationError('The server has to be the directory URL of the CA, ie: https://acme.sectigo.com/v2/OV');
+					}
+					if (url.protocol !== 'https:') {
+						throw new error.ValidationError('The directory URL of the CA has to be https://');
+					}
+				}
+
+				if (typeof data.kid !== 'undefined' && /\s/.test(data.kid)) {
+					throw new error.ValidationError('The key ID can\'t have spaces in it');
+				}
+
+				if (typeof data.hmac_key !== 'undefined') {
+					data.hmac_key = internalAcmeEab.normaliseKey(data.hmac_key);
+				}
+
+				if (data.expires_on) {
+					const time = moment(data.expires_on, moment.ISO_8601, true);
+					if (!time.isValid()) {
+						throw new error.ValidationError('expires_on must be a date and time, like 2026-12-31T00:00:00Z');
+					}
+					if (!time.isAfter(moment())) {
+						throw new error.ValidationError('expires_on is in the past, these credentials can\'t be used anymore');
+					}
+					data.expires_on = time.local().format(DATE_FORMAT);
+				}
+			});
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {String}  valid, expiring or expired
+	 */
+	getStatus: (row) => {
+		if (!row.expires_on) {
+			return 'valid';
+		}
+
+		const expires_on = moment(row.expires_on);
+		if (!expires_on.isAfter(moment())) {
+			return 'expired';
+		}
+		return expires_on.isBefore(moment().add(EXPIRING_DAYS, 'days')) ? 'expiring' : 'valid';
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {Object}  without the HMAC key, with whether it can still be used
+	 */
+	format: (row) => {
+		row.status = internalAcmeEab.getStatus(row);
+		return _.omit(row, omissions());
+	},
+
+	/**
+	 * The binding to register an ACME account with, for the CA it's for
+	 *
+	 * @param   {Access}  access
+	 * @param   {Number}  id
+	 * @param   {String}  [server]  the directory URL the account is registered with
+	 * @returns {Promise}  resolves with {id, name, server, eab_kid, eab_hmac_key}
+	 */
+	getBinding: (access, id, server) => {
+		return internalAcmeEab.get(access, {id: id})
+			.then(() => {
+				return acmeEabModel
+					.query()
+					.where('id', id)
+					.first();
+			})
+			.then((row) => {
+				if (internalAcmeEab.getStatus(row) === 'expired') {
+					throw new error.ValidationError('The EAB credentials ' + row.name + ' expired on ' + moment(row.expires_on).format('YYYY-MM-DD') + ', add the new ones the CA gives you');
+				}
+				if (server && server !== row.server) {
+					throw new error.ValidationError('The EAB credentials ' + row.name + ' are for ' + row.server + ', not ' + server);
+				}
+
+				return {
+					id:           row.id,
+					name:         row.name,
+					server:       row.server,
+					eab_kid:      row.kid,
+					eab_hmac_key: row.hmac_key
+				};
+			});
+	},
+
+	/**
+	 * Records the ACME account registered with the credentials, some CAs only take them once
+	 *
+	 * @param   {Number}  id
+	 * @param   {Number}  account_id
+	 * @returns {Promise}
+	 */
+	markUsed: (id, account_id) => {
+		return acmeEabModel
+			.query()
+			.where('id', id)
+			.first()
+			.then((row) => {
+				return acmeEabModel
+					.query()
+					.where('id', id)
+					.patch({
+						meta: _.assign({}, row.meta, {
+							used_on:          moment().toISOString(),
+							acme_account_ids: _.uniq((row.meta.acme_account_ids || []).concat([account_id]))
+						})
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  data.server
+	 * @param   {String}  data.kid
+	 * @param   {String}  data.hmac_key
+	 * @param   {String}  [data.expires_on]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('acme_eab_credentials:create', data)
+			.then(() => {
+				return internalAcmeEab.validate(data);
+			})
+			.then(() => {
+				return acmeEabModel
+					.query()
+					.insertAndFetch({
+						tenant_id:  access.getTenantId(),
+						name:       data.name,
+						server:     data.server,
+						kid:        data.kid,
+						hmac_key:   data.hmac_key,
+						expires_on: data.expires_on || null,
+						meta:       {}
+					});
+			})
+			.then((row) => {
+				row = internalAcmeEab.format(row);
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'acme-eab-credential',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		return access.can('acme_eab_credentials:update', data.id)
+			.then(() => {
+				return internalAcmeEab.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalAcmeEab.validate(data)
+					.then(() => {
+						return acmeEabModel
+							.query()
+							.patchAndFetchById(row.id, _.pick(data, ['name', 'server', 'kid', 'hmac_key', 'expires_on']));
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'acme-eab-credential',
+					object_id:   saved_row.id,
+					meta:        _.omit(data, ['hmac_key'])
+				})
+					.then(() => {
+						return internalAcmeEab.format(saved_row);
+					});
+			});
+	},
+
+	/**
+	 * The accounts registered with them stay, they don't need the credentials again
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('acme_eab_credentials:delete', data.id)
+			.then(() => {
+				return internalAcmeEab.getWritable(access, {id: data.id});
+			})
+			.then((row) => {
+				return acmeEabModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'acme-eab-credential',
+							object_id:   row.id,
+							meta:        row
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('acme_eab_credentials:get', data.id)
+			.then((access_data) => {
+				let query = acmeEabModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first();
+
+				if (access_data.tenant_id) {
+					// Credentials shared by admins outside of any tenant, and their own
+					query.whereIn('tenant_id', [0, access_data.tenant_id]);
+				}
+
+				return query;
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return internalAcmeEab.format(row);
+			});
+	},
+
+	/**
+	 * Like get, but tenants can only change their own credentials and not the shared ones
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	getWritable: (access, data) => {
+		return internalAcmeEab.get(access, data)
+			.then((row) => {
+				const tenant_id = access.getTenantId();
+				if (tenant_id && row.tenant_id !== tenant_id) {
+					throw new error.PermissionError('These EAB credentials are shared with every tenant and can only be changed by an administrator');
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('acme_eab_credentials:list')
+			.then((access_data) => {
+				let query = acmeEabModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (access_data.tenant_id) {
+					query.whereIn('tenant_id', [0, access_data.tenant_id]);
+				}
+
+				return query;
+			})
+			.then((rows) => {
+				return rows.map(internalAcmeEab.format);
+			});
+	}
+};
+
+module.exports = internalAcmeEab;
@@ -0,0 +1,277 @@
+const _                   = require('lodash');
+const crypto              = require('crypto');
+const moment              = require('moment');
+const logger              = require('../logger').certbot;
+const error               = require('../lib/error');
+const helpers             = require('../lib/helpers');
+const acmeOrderModel      = require('../models/acme_order');
+const certificateModel    = require('../models/certificate');
+const internalAcmeAccount = require('./acme-account');
+
+const LE_PRODUCTION = 'https://acme-v02.api.letsencrypt.org/directory';
+
+/**
+ * The Let's Encrypt production limits we can track locally.
+ * @see https://letsencrypt.org/docs/rate-limits/
+ */
+const LIMITS = {
+	certificates_per_domain: {limit: 50, window: [7, 'days']},
+	duplicate_certificates:  {limit: 5, window: [7, 'days']},
+	failed_validations:      {limit: 5, window: [1, 'hour']},
+	new_orders:              {limit: 300, window: [3, 'hours']}
+};
+
+const internalAcmeRateLimit = {
+
+	limits: LIMITS,
+
+	/**
+	 * @param   {Array}  domain_names
+	 * @returns {String}
+	 */
+	getNamesHash: (domain_names) => {
+		const names = _.uniq(domain_names.map((name) => name.toLowerCase())).sort();
+		return crypto.createHash('sha1').update(names.join(',')).digest('hex');
+	},
+
+	/**
+	 * @param   {Array}  domain_names
+	 * @returns {Array}
+	 */
+	getRegisteredDomains: (domain_names) => {
+		return _.uniq(domain_names.map(helpers.getRegisteredDomain)).sort();
+	},
+
+	/**
+	 * @param   {String}  name  key of LIMITS
+	 * @returns {String}
+	 */
+	getWindowStart: (name) => {
+		return moment().subtract(LIMITS[name].window[0], LIMITS[name].window[1]).format('YYYY-MM-DD HH:mm:ss');
+	},
+
+	/**
+	 * When the oldest counted order drops out of the window
+	 *
+	 * @param   {String}  name  key of LIMITS
+	 * @param   {Array}   rows
+	 * @returns {String|null}
+	 */
+	getResetsOn: (name, rows) => {
+		if (!rows.length) {
+			return null;
+		}
+
+		const oldest = _.minBy(rows, (row) => moment(row.created_on).valueOf());
+		return moment(oldest.created_on).add(LIMITS[name].window[0], LIMITS[name].window[1]).toISOString();
+	},
+
+	/**
+	 * @param   {String}  server
+	 * @returns {Promise}
+	 */
+	getRecentOrders: (server) => {
+		return acmeOrderModel
+			.query()
+			.where('server', server)
+			.andWhere('created_on', '>', internalAcmeRateLimit.getWindowStart('certificates_per_domain'));
+	},
+
+	/**
+	 * Works out whether an order for this certificate would breach a known limit
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Promise}  resolves with null, or the reason and when to retry
+	 */
+	evaluate: (certificate) => {
+		return internalAcmeAccount.getCertificateServer(certificate)
+			.then(({server, account}) => {
+				if (server !== LE_PRODUCTION) {
+					return null;
+				}
+
+				return internalAcmeRateLimit.getRecentOrders(server)
+					.then((orders) => {
+						const names_hash = internalAcmeRateLimit.getNamesHash(certificate.domain_names);
+						const since      = (name) => orders.filter((row) => moment(row.created_on).isAfter(moment(internalAcmeRateLimit.getWindowStart(name))));
+						const exceeded   = (name, rows, reason) => {
+							return rows.length >= LIMITS[name].limit ? {limit: name, reason: reason, retry_on: internalAcmeRateLimit.getResetsOn(name, rows)} : null;
+						};
+
+						const account_orders = since('new_orders').filter((row) => row.account === account);
+						let result           = exceeded('new_orders', account_orders, 'Too many new orders for this ACME account');
+						if (result) {
+							return result;
+						}
+
+						const failed = since('failed_validations').filter((row) => row.account === account && !row.is_success);
+						for (const name of certificate.domain_names) {
+							const rows = failed.filter((row) => (row.meta.domain_names || []).indexOf(name.toLowerCase()) !== -1);
+							if ((result = exceeded('failed_validations', rows, 'Too many failed validations for ' + name))) {
+								return result;
+							}
+						}
+
+						const issued = since('certificates_per_domain').filter((row) => row.is_success);
+						result       = exceeded('duplicate_certificates', issued.filter((row) => row.names_hash === names_hash), 'Too many duplicate certificates for this set of domains');
+						if (result) {
+							return result;
+						}
+
+						// Renewals of an existing set of names aren't held back by the registered domain limit
+						if (issued.some((row) => row.names_hash === names_hash)) {
+							return null;
+						}
+
+						for (const domain of internalAcmeRateLimit.getRegisteredDomains(certificate.domain_names)) {
+							const rows = issued.filter((row) => (row.meta.registered_domains || []).indexOf(domain) !== -1);
+							if ((result = exceeded('certificates_per_domain', rows, 'Too many certificates for ' + domain))) {
+								return result;
+							}
+						}
+
+						return null;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Object}  certificate
+	 * @returns {Promise}
+	 */
+	check: (certificate) => {
+		return internalAcmeRateLimit.evaluate(certificate)
+			.then((result) => {
+				if (result) {
+					const message = result.reason + ', the Let\'s Encrypt limit is ' + LIMITS[result.limit].limit +
+						' per ' + LIMITS[result.limit].window.join(' ') + '. Try again after ' + result.retry_on;
+
+					logger.warn('Refusing to request Cert #' + certificate.id + ': ' + message);
+					throw new error.ValidationError(message);
+				}
+			});
+	},
+
+	/**
+	 * @param   {Object}   certificate
+	 * @param   {String}   action      issue or renew
+	 * @param   {Boolean}  is_success
+	 * @returns {Promise}
+	 */
+	record: (certificate, action, is_success) => {
+		return internalAcmeAccount.getCertificateServer(certificate)
+			.then(({server, account}) => {
+				return acmeOrderModel
+					.query()
+					.insert({
+						certificate_id: certificate.id,
+						server:         server,
+						account:        account,
+						action:         action,
+						names_hash:     internalAcmeRateLimit.getNamesHash(certificate.domain_names),
+						is_success:     is_success,
+						meta:           {
+							domain_names:       certificate.domain_names.map((name) => name.toLowerCase()),
+							registered_domains: internalAcmeRateLimit.getRegisteredDomains(certificate.domain_names)
+						}
+					});
+			})
+			.catch((err) => {
+				// Tracking should never get in the way of the certificate itself
+				logger.warn('Could not record ACME order for Cert #' + certificate.id + ': ' + err.message);
+			});
+	},
+
+	/**
+	 * Checks the limits, runs the certbot command and records the outcome
+	 *
+	 * @param   {Object}    certificate
+	 * @param   {String}    action  issue or renew
+	 * @param   {Function}  fn      returns a Promise
+	 * @returns {Promise}
+	 */
+	track: (certificate, action, fn) => {
+		return internalAcmeRateLimit.check(certificate)
+			.then(() => {
+				return fn()
+					.then((result) => {
+						return internalAcmeRateLimit.record(certificate, action, true)
+							.then(() => {
+								return result;
+							});
+					}, (err) => {
+						return internalAcmeRateLimit.record(certificate, action, false)
+							.then(() => {
+								throw err;
+							});
+					});
+			});
+	},
+
+	/**
+	 * The remaining budget for each registered domain and account in use against Let's Encrypt production
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getStatus: (access) => {
+		return access.can('certificates:list')
+			.then(() => {
+				return certificateModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('provider', 'letsencrypt');
+			})
+			.then((certificates) => {
+				return internalAcmeRateLimit.getRecentOrders(LE_PRODUCTION)
+					.then((orders) => {
+						const since  = (name) => orders.filter((row) => moment(row.created_on).isAfter(moment(internalAcmeRateLimit.getWindowStart(name))));
+						const budget = (name, rows) => {
+							return {
+								used:      rows.length,
+								limit:     LIMITS[name].limit,
+								remaining: Math.max(0, LIMITS[name].limit - rows.length),
+								resets_on: internalAcmeRateLimit.getResetsOn(name, rows)
+							};
+						};
+
+						let domains = [];
+						certificates.forEach((certificate) => {
+							domains = domains.concat(internalAcmeRateLimit.getRegisteredDomains(certificate.domain_names));
+						});
+						orders.forEach((row) => {
+							domains = domains.concat(row.meta.registered_domains || []);
+						});
+
+						const issued = since('certificates_per_domain').filter((row) => row.is_success);
+						const failed = since('failed_validations').filter((row) => !row.is_success);
+
+						let failed_names = [];
+						failed.forEach((row) => {
+							(row.meta.domain_names || []).forEach((name) => {
+								failed_names.push(row.account + ' ' + name);
+							});
+						});
+
+						return {
+							server:             LE_PRODUCTION,
+							limits:             LIMITS,
+							registered_domains: _.uniq(domains).sort().map((domain) => {
+								return Object.assign({domain: domain}, budget('certificates_per_domain', issued.filter((row) => (row.meta.registered_domains || []).indexOf(domain) !== -1)));
+							}),
+							accounts: _.uniq(orders.map((row) => row.account)).sort().map((account) => {
+								return Object.assign({account: account}, budget('new_orders', since('new_orders').filter((row) => row.account === account)));
+							}),
+							failed_validations: _.uniq(failed_names).sort().map((key) => {
+								const [account, hostname] = key.split(' ');
+								return Object.assign({account: account, hostname: hostname}, budget('failed_validations', failed.filter((row) => {
+									return row.account === account && (row.meta.domain_names || []).indexOf(hostname) !== -1;
+								})));
+							})
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalAcmeRateLimit;
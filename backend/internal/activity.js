@@ -0,0 +1,189 @@
+const _                     = require('lodash');
+const moment                = require('moment');
+const logger                = require('../logger').global;
+const error                 = require('../lib/error');
+const activityEventModel    = require('../models/activity_event');
+const auditLogModel         = require('../models/audit-log');
+const acmeOrderModel        = require('../models/acme_order');
+const internalNotifications = require('./notifications');
+
+/**
+ * The resources with a feed. Required when used, as these modules require this one.
+ */
+const MODULES = {
+	'proxy-host':       './proxy-host',
+	'redirection-host': './redirection-host',
+	'dead-host':        './dead-host',
+	'stream':           './stream',
+	'certificate':      './certificate',
+	'acme-account':     './acme-account'
+};
+
+const DEFAULT_LIMIT = 50;
+
+const internalActivity = {
+
+	/**
+	 * Records something that happened to a resource outside of the API, ie: its config failing the nginx test.
+	 * This should never get in the way of what it's recording, so it doesn't reject.
+	 *
+	 * @param   {String}   object_type  ie: proxy-host
+	 * @param   {Number}   object_id
+	 * @param   {String}   source       nginx, health or deploy
+	 * @param   {String}   event
+	 * @param   {Boolean}  is_success
+	 * @param   {String}   [message]
+	 * @returns {Promise}
+	 */
+	record: (object_type, object_id, source, event, is_success, message) => {
+		return activityEventModel
+			.query()
+			.insert({
+				object_type: object_type,
+				object_id:   object_id,
+				source:      source,
+				event:       event,
+				is_success:  is_success,
+				meta:        message ? {message: message} : {}
+			})
+			.then(() => {
+				// Failures are sent to the notification channels without waiting for them, and a host coming back online after one
+				if (internalNotifications.EVENTS.indexOf(source) !== -1 && (!is_success || source === 'health')) {
+					internalNotifications.send(source, internalActivity.getSeverity(source, event, is_success), _.upperFirst(object_type.replace('-', ' ')) + ' #' + object_id + ': ' + source + ' ' + event + (message ? ' - ' + message : ''));
+				}
+			})
+			.catch((err) => {
+				logger.warn('Could not record ' + source + ' ' + event + ' of ' + object_type + ' #' + object_id + ': ' + err.message);
+			});
+	},
+
+	/**
+	 * How bad an event is, for the notification routes
+	 *
+	 * @param   {String}   source
+	 * @param   {String}   event
+	 * @param   {Boolean}  is_success
+	 * @returns {String}   critical, warning or info
+	 */
+	getSeverity: (source, event, is_success) => {
+		if (is_success) {
+			return 'info';
+		}
+		return source === 'health' || source === 'renewal' ? 'critical' : 'warning';
+	},
+
+	/**
+	 * Records the result of writing the config of a host, and whether it went on or offline because of it
+	 *
+	 * @param   {String}   host_type  ie: proxy_host
+	 * @param   {Object}   host       as it was before
+	 * @param   {Object}   meta       after
+	 * @returns {Promise}
+	 */
+	recordConfigure: (host_type, host, meta) => {
+		const object_type = host_type.replace('_', '-');
+		const was_online  = host.meta ? host.meta.nginx_online : undefined;
+
+		return internalActivity.record(object_type, host.id, 'nginx', 'config', meta.nginx_online, meta.nginx_err)
+			.then(() => {
+				if (typeof was_online === 'boolean' && was_online !== meta.nginx_online) {
+					return internalActivity.record(object_type, host.id, 'health', meta.nginx_online ? 'online' : 'offline', meta.nginx_online);
+				}
+			});
+	},
+
+	/**
+	 * The audit log, ACME orders and recorded events of a resource in one timeline, newest first.
+	 * Audit log entries are only included for users who can see the audit log.
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.limit]
+	 * @returns {Promise}
+	 */
+	getFeed: (access, object_type, data) => {
+		const limit = data.limit || DEFAULT_LIMIT;
+
+		// Whoever can see the resource can see its activity
+		return require(MODULES[object_type]).get(access, {id: data.id})
+			.then(() => {
+				const audit = access.can('auditlog:list')
+					.then(() => {
+						return auditLogModel
+							.query()
+							.where('object_type', object_type)
+							.andWhere('object_id', data.id)
+							.orderBy('created_on', 'DESC')
+							.orderBy('id', 'DESC')
+							.limit(limit)
+							.withGraphFetched('user');
+					})
+					.catch((err) => {
+						if (!(err instanceof error.PermissionError)) {
+							throw err;
+						}
+						return [];
+					});
+
+				let orders = Promise.resolve([]);
+				if (object_type === 'certificate' || object_type === 'acme-account') {
+					orders = acmeOrderModel
+						.query()
+						.where(object_type === 'certificate' ? 'certificate_id' : 'account', object_type === 'certificate' ? data.id : String(data.id))
+						.orderBy('created_on', 'DESC')
+						.orderBy('id', 'DESC')
+						.limit(limit);
+				}
+
+				const events = activityEventModel
+					.query()
+					.where('object_type', object_type)
+					.andWhere('object_id', data.id)
+					.orderBy('created_on', 'DESC')
+					.orderBy('id', 'DESC')
+					.limit(limit);
+
+				return Promise.all([audit, orders, events]);
+			})
+			.then(([audit, orders, events]) => {
+				const items = [].concat(
+					audit.map((row) => {
+						return {
+							created_on: row.created_on,
+							source:     'audit',
+							event:      row.action,
+							success:    null,
+							message:    null,
+							user:       row.user ? {id: row.user.id, name: row.user.name} : null
+						};
+					}),
+					orders.map((row) => {
+						return {
+							created_on: row.created_on,
+							source:     'acme',
+							event:      row.action,
+							success:    row.is_success,
+							message:    object_type === 'acme-account' ? 'Cert #' + row.certificate_id : null,
+							user:       null
+						};
+					}),
+					events.map((row) => {
+						return {
+							created_on: row.created_on,
+							source:     row.source,
+							event:      row.event,
+							success:    row.is_success,
+							message:    row.meta.message || null,
+							user:       null
+						};
+					})
+				);
+
+				return _.take(_.orderBy(items, [(item) => moment(item.created_on).valueOf()], ['desc']), limit);
+			});
+	}
+};
+
+module.exports = internalActivity;
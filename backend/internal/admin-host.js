@@ -0,0 +1,196 @@
+const error          = require('../lib/error');
+const logger         = require('../logger').setup;
+const settingModel   = require('../models/setting');
+const proxyHostModel = require('../models/proxy_host');
+const internalNginx  = require('./nginx');
+
+const adminForwardHosts = ['127.0.0.1', 'localhost', '::1', '[::1]'];
+const adminForwardPort  = 81;
+
+const internalAdminHost = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'admin-host')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @returns {Number}  The proxy host id serving the admin interface, or 0 when not in use
+	 */
+	getAdminHostId: (setting) => {
+		if (setting && setting.value === 'on' && setting.meta && setting.meta.proxy_host_id) {
+			return setting.meta.proxy_host_id;
+		}
+		return 0;
+	},
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Boolean}
+	 */
+	forwardsToAdmin: (host) => {
+		return adminForwardHosts.indexOf(host.forward_host) !== -1 && parseInt(host.forward_port, 10) === adminForwardPort;
+	},
+
+	/**
+	 * Rejects when the proxy host is currently serving the admin interface, so that the
+	 * panel can't be taken offline by deleting or disabling the host it's reached through.
+	 *
+	 * @param   {Number}  host_id
+	 * @param   {String}  action   ie: 'deleted', 'disabled'
+	 * @returns {Promise}
+	 */
+	assertNotAdminHost: (host_id, action) => {
+		return internalAdminHost.getSetting()
+			.then((setting) => {
+				if (internalAdminHost.getAdminHostId(setting) === host_id) {
+					throw new error.ValidationError('This host serves the admin interface and cannot be ' + action + '. Turn off the Admin Host setting first.');
+				}
+			});
+	},
+
+	/**
+	 * Makes sure that changes to a proxy host don't point the admin host somewhere else
+	 *
+	 * @param   {Object}  host     existing row
+	 * @param   {Object}  changes  update payload
+	 * @returns {Promise}
+	 */
+	assertUpdateAllowed: (host, changes) => {
+		return internalAdminHost.getSetting()
+			.then((setting) => {
+				if (internalAdminHost.getAdminHostId(setting) !== host.id) {
+					return;
+				}
+
+				const combined = Object.assign({}, host, changes);
+				if (!internalAdminHost.forwardsToAdmin(combined) || combined.forward_scheme !== 'http') {
+					throw new error.ValidationError('This host serves the admin interface and must forward to http://127.0.0.1:' + adminForwardPort);
+				}
+			});
+	},
+
+	/**
+	 * Checks the setting is safe to apply before it's saved
+	 *
+	 * @param   {Object}  setting  the combined setting row that is about to be saved
+	 * @returns {Promise}
+	 */
+	validate: (setting) => {
+		if (setting.value !== 'on') {
+			return Promise.resolve();
+		}
+
+		if (!setting.meta || !setting.meta.proxy_host_id) {
+			return Promise.reject(new error.ValidationError('A Proxy Host must be selected to serve the admin interface'));
+		}
+
+		return settingModel
+			.query()
+			.where('id', 'admin-listen')
+			.first()
+			.then((admin_listen) => {
+				if (admin_listen && admin_listen.value === 'https') {
+					throw new error.ValidationError('The admin port is served with https, set the Admin Listener setting back to http first');
+				}
+
+				return proxyHostModel
+					.query()
+					.where('id', setting.meta.proxy_host_id)
+					.andWhere('is_deleted', 0)
+					.first();
+			})
+			.then((host) => {
+				if (!host) {
+					throw new error.ValidationError('Proxy Host #' + setting.meta.proxy_host_id + ' does not exist');
+				}
+
+				if (!host.enabled) {
+					throw new error.ValidationError('Proxy Host #' + host.id + ' is disabled');
+				}
+
+				if (!internalAdminHost.forwardsToAdmin(host) || host.forward_scheme !== 'http') {
+					throw new error.ValidationError('Proxy Host #' + host.id + ' must forward to http://127.0.0.1:' + adminForwardPort);
+				}
+
+				// Locking the admin port down while the host is broken would lock everyone out
+				if (setting.meta.restrict_port && host.meta && host.meta.nginx_online === false) {
+					throw new error.ValidationError('Proxy Host #' + host.id + ' is offline, the admin port cannot be restricted');
+				}
+			});
+	},
+
+	/**
+	 * Writes the admin port access config and reloads nginx. If nginx doesn't accept it
+	 * the config is removed again so the admin port stays open.
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		return internalNginx.deleteConfig('admin_host')
+			.then(() => {
+				return internalNginx.generateConfig('admin_host', setting);
+			})
+			.then(() => {
+				return internalNginx.reload();
+			})
+			.catch((err) => {
+				logger.error('Could not configure admin host:', err.message);
+
+				return internalNginx.deleteConfig('admin_host')
+					.then(internalNginx.reload)
+					.then(() => {
+						throw new error.ValidationError('Could not reconfigure Nginx for the admin host. Please check logs.');
+					});
+			});
+	},
+
+	/**
+	 * Run at startup. If the admin host has gone away, or the ADMIN_PORT_UNRESTRICTED
+	 * environment variable is set, the admin port is opened back up.
+	 *
+	 * @returns {Promise}
+	 */
+	bootstrap: () => {
+		return internalAdminHost.getSetting()
+			.then((setting) => {
+				if (!setting || !setting.meta.restrict_port) {
+					return internalNginx.deleteConfig('admin_host');
+				}
+
+				if (internalAdminHost.unrestrictedOverride()) {
+					logger.warn('ADMIN_PORT_UNRESTRICTED is set, the admin port will not be restricted');
+					return internalNginx.deleteConfig('admin_host');
+				}
+
+				return internalAdminHost.validate(setting)
+					.then(() => {
+						return internalNginx.generateConfig('admin_host', setting);
+					})
+					.catch((err) => {
+						logger.warn('Admin host is not usable, the admin port will not be restricted: ' + err.message);
+						return internalNginx.deleteConfig('admin_host');
+					});
+			});
+	},
+
+	/**
+	 * @returns {Boolean}
+	 */
+	unrestrictedOverride: () => {
+		if (typeof process.env.ADMIN_PORT_UNRESTRICTED !== 'undefined') {
+			const val = process.env.ADMIN_PORT_UNRESTRICTED.toLowerCase();
+			return val === 'on' || val === 'true' || val === '1' || val === 'yes';
+		}
+		return false;
+	}
+};
+
+module.exports = internalAdminHost;
@@ -0,0 +1,132 @@
+const error            = require('../lib/error');
+const logger           = require('../logger').setup;
+const settingModel     = require('../models/setting');
+const certificateModel = require('../models/certificate');
+const internalNginx    = require('./nginx');
+
+const internalAdminListen = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'admin-listen')
+			.first();
+	},
+
+	/**
+	 * Checks the certificate can serve the admin interface before the setting is saved
+	 *
+	 * @param   {Object}  setting  the combined setting row that is about to be saved
+	 * @returns {Promise}
+	 */
+	validate: (setting) => {
+		if (setting.value !== 'https') {
+			return Promise.resolve();
+		}
+
+		if (!setting.meta || !setting.meta.certificate_id) {
+			return Promise.reject(new error.ValidationError('A certificate must be selected to serve the admin interface with https'));
+		}
+
+		return certificateModel
+			.query()
+			.where('id', setting.meta.certificate_id)
+			.andWhere('is_deleted', 0)
+			.first()
+			.then((certificate) => {
+				if (!certificate) {
+					throw new error.ValidationError('Certificate #' + setting.meta.certificate_id + ' does not exist');
+				}
+
+				return settingModel
+					.query()
+					.where('id', 'admin-host')
+					.first();
+			})
+			.then((admin_host) => {
+				// The admin host forwards to the port with plain http
+				if (admin_host && admin_host.value === 'on') {
+					throw new error.ValidationError('The admin interface is served through a Proxy Host, turn off the Admin Host setting first');
+				}
+			});
+	},
+
+	/**
+	 * Rejects when the admin interface is served with the certificate, as nginx wouldn't start without it
+	 *
+	 * @param   {Number}  certificate_id
+	 * @returns {Promise}
+	 */
+	assertCertificateUnused: (certificate_id) => {
+		return internalAdminListen.getSetting()
+			.then((setting) => {
+				if (setting && setting.value === 'https' && setting.meta.certificate_id === certificate_id) {
+					throw new error.ValidationError('This certificate serves the admin interface and cannot be deleted. Change the Admin Listener setting first.');
+				}
+			});
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	writeConfig: (setting) => {
+		return certificateModel
+			.query()
+			.where('id', setting.value === 'https' ? setting.meta.certificate_id : 0)
+			.andWhere('is_deleted', 0)
+			.first()
+			.then((certificate) => {
+				return internalNginx.generateConfig('admin_listen', Object.assign({}, setting, {certificate: certificate || null}));
+			});
+	},
+
+	/**
+	 * Writes how the admin port listens and reloads nginx. If nginx doesn't accept it,
+	 * the admin port goes back to plain http so the interface stays reachable.
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		return internalAdminListen.writeConfig(setting)
+			.then(() => {
+				return internalNginx.reload();
+			})
+			.catch((err) => {
+				logger.error('Could not configure the admin listener:', err.message);
+
+				return internalNginx.generateConfig('admin_listen', {value: 'http', meta: {}})
+					.then(internalNginx.reload)
+					.then(() => {
+						throw new error.ValidationError('Could not reconfigure Nginx for the admin listener. Please check logs.');
+					});
+			});
+	},
+
+	/**
+	 * Called on startup, before nginx is reloaded with the hosts
+	 *
+	 * @returns {Promise}
+	 */
+	bootstrap: () => {
+		return internalAdminListen.getSetting()
+			.then((setting) => {
+				setting = setting || {value: 'http', meta: {}};
+
+				return internalAdminListen.validate(setting)
+					.catch((err) => {
+						logger.warn('The admin interface can\'t be served with https, using http: ' + err.message);
+						setting = {value: 'http', meta: {}};
+					})
+					.then(() => {
+						return internalAdminListen.writeConfig(setting);
+					});
+			});
+	}
+};
+
+module.exports = internalAdminListen;
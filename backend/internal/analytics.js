@@ -0,0 +1,522 @@
+const _              = require('lodash');
+const fs             = require('fs');
+const net            = require('net');
+const path           = require('path');
+const crypto         = require('crypto');
+const moment         = require('moment');
+const maxmind        = require('maxmind');
+const config         = require('../lib/config');
+const logger         = require('../logger').global;
+const settingModel   = require('../models/setting');
+const analyticsModel = require('../models/host_analytics');
+const botAgents      = require('../global/bot-user-agents.json');
+
+const logDir      = config.getPath('logs');
+const stateDir    = config.getPath('analytics');
+const offsetsFile = stateDir + '/offsets.json';
+const saltFile    = stateDir + '/salt';
+
+/**
+ * Used for anything not given in the setting
+ */
+const DEFAULTS = {
+	anonymize:      'truncate',
+	retention:      30,
+	geoip_database: '/data/geoip/GeoLite2-Country.mmdb',
+	asn_database:   '/data/geoip/GeoLite2-ASN.mmdb'
+};
+
+// Don't read more than this of a log in one go, the rest is picked up next time
+const MAX_READ = 50 * 1024 * 1024;
+
+// ie: proxy-host-1_access.log
+const ACCESS_LOG = /^(proxy-host|redirection-host|dead-host)-(\d+)_access\.log$/;
+
+// Both the proxy and standard log formats
+const LOG_LINE = /^\[([^\]]+)\] (?:.*? )?(\d{3}) - (\S+) (\S+) (\S+) "([^"]*)" \[Client ([^\]]+)\] \[Length (\d+)\] \[Gzip [^\]]*\] (?:\[Sent-to [^\]]*\] )?"([^"]*)" "([^"]*)"(?: \[Time [^\]]*\] \[Upstream-time [^\]]*\])?$/;
+
+// The categories of bot-user-agents.json with what's looked for in user agents, in order
+const BOTS = Object.keys(botAgents).map((category) => {
+	return {
+		category: category,
+		agents:   botAgents[category].agents.map((agent) => {
+			return {name: agent, search: agent.toLowerCase()};
+		})
+	};
+});
+
+// The GeoIP databases opened, by file
+let geoip = {};
+
+const internalAnalytics = {
+
+	intervalTimeout:    1000 * 60 * 5, // 5 minutes
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Analytics Timer initialized');
+		internalAnalytics.interval = setInterval(internalAnalytics.processLogs, internalAnalytics.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'analytics')
+			.first();
+	},
+
+	/**
+	 * What's stored in place of a client's address
+	 *
+	 * @param   {String}  ip
+	 * @param   {String}  mode  'none', 'truncate' or 'hash'
+	 * @returns {String}
+	 */
+	anonymize: (ip, mode) => {
+		if (mode === 'hash') {
+			return crypto.createHmac('sha256', internalAnalytics.getSalt()).update(ip).digest('hex').substring(0, 16);
+		}
+
+		if (mode === 'truncate') {
+			if (net.isIPv4(ip)) {
+				return ip.split('.').slice(0, 3).concat('0').join('.');
+			}
+			if (net.isIPv6(ip)) {
+				// Keep the /48, which is usually the site
+				const [head, tail] = ip.split('::');
+				let groups         = head ? head.split(':') : [];
+
+				if (typeof tail !== 'undefined') {
+					const rest = tail ? tail.split(':') : [];
+					groups     = groups.concat(_.fill(Array(8 - groups.length - rest.length), '0'), rest);
+				}
+
+				return groups.slice(0, 3).join(':') + '::';
+			}
+		}
+
+		return ip;
+	},
+
+	/**
+	 * The key client addresses are hashed with, made on first use so the hashes can't be reversed
+	 * by hashing every address
+	 *
+	 * @returns {String}
+	 */
+	getSalt: () => {
+		if (!fs.existsSync(saltFile)) {
+			if (!fs.existsSync(stateDir)) {
+				fs.mkdirSync(stateDir);
+			}
+			fs.writeFileSync(saltFile, crypto.randomBytes(32).toString('hex'), {encoding: 'utf8', mode: 0o600});
+		}
+		return fs.readFileSync(saltFile, {encoding: 'utf8'});
+	},
+
+	/**
+	 * A GeoIP database, or null when there isn't one
+	 *
+	 * @param   {String}  file
+	 * @returns {Promise}
+	 */
+	getGeoip: (file) => {
+		if (typeof geoip[file] !== 'undefined') {
+			return Promise.resolve(geoip[file]);
+		}
+
+		// Checked every time, as the database might be added later
+		if (!fs.existsSync(file)) {
+			return Promise.resolve(null);
+		}
+
+		return maxmind.open(file)
+			.then((reader) => {
+				geoip[file] = reader;
+				return reader;
+			})
+			.catch((err) => {
+				logger.warn('Could not open the GeoIP database ' + file + ': ' + err.message);
+				geoip[file] = null;
+				return null;
+			});
+	},
+
+	/**
+	 * @param   {Object}  reader
+	 * @param   {String}  ip
+	 * @returns {String}  ISO country code, or 'unknown'
+	 */
+	getCountry: (reader, ip) => {
+		try {
+			const result = reader.get(ip);
+			if (result && result.country && result.country.iso_code) {
+				return result.country.iso_code;
+			}
+		} catch (err) {
+			// Not an address the database knows about
+		}
+		return 'unknown';
+	},
+
+	/**
+	 * The network an address is in, from a GeoLite2 ASN or GeoIP2 ISP database
+	 *
+	 * @param   {Object}  reader
+	 * @param   {String}  ip
+	 * @returns {String}  ie: 'AS13335 Cloudflare, Inc.', or 'unknown'
+	 */
+	getAsn: (reader, ip) => {
+		try {
+			const result = reader.get(ip);
+			if (result && result.autonomous_system_number) {
+				const name = result.isp || result.autonomous_system_organization || result.organization || '';
+				return ('AS' + result.autonomous_system_number + ' ' + name).trim().substring(0, 255);
+			}
+		} catch (err) {
+			// Not an address the database knows about
+		}
+		return 'unknown';
+	},
+
+	/**
+	 * What kind of client made a request, from its user agent
+	 *
+	 * @param   {String}  user_agent
+	 * @returns {Object}  ie: {category: 'ai', name: 'GPTBot'}, the category is human for browsers
+	 */
+	classify: (user_agent) => {
+		if (!user_agent || user_agent === '-') {
+			return {category: 'unknown', name: null};
+		}
+
+		const search = user_agent.toLowerCase();
+		for (const bot of BOTS) {
+			const agent = bot.agents.find((item) => search.indexOf(item.search) !== -1);
+			if (agent) {
+				return {category: bot.category, name: agent.name};
+			}
+		}
+
+		return {category: 'human', name: null};
+	},
+
+	/**
+	 * @returns {Object}  file name => {inode, offset}
+	 */
+	getOffsets: () => {
+		try {
+			return JSON.parse(fs.readFileSync(offsetsFile, {encoding: 'utf8'}));
+		} catch (err) {
+			return {};
+		}
+	},
+
+	/**
+	 * @param {Object}  offsets
+	 */
+	saveOffsets: (offsets) => {
+		if (!fs.existsSync(stateDir)) {
+			fs.mkdirSync(stateDir);
+		}
+		fs.writeFileSync(offsetsFile, JSON.stringify(offsets), {encoding: 'utf8'});
+	},
+
+	/**
+	 * The lines written to a log since it was last read. A log that's been rotated is read from the start.
+	 *
+	 * @param   {String}  name
+	 * @param   {Object}  offsets  updated with where reading stopped
+	 * @returns {Array}
+	 */
+	readNewLines: (name, offsets) => {
+		const file = path.join(logDir, name);
+		const stat = fs.statSync(file);
+		let start  = 0;
+
+		if (offsets[name] && offsets[name].inode === stat.ino && offsets[name].offset <= stat.size) {
+			start = offsets[name].offset;
+		}
+
+		const length = Math.min(stat.size - start, MAX_READ);
+		if (length <= 0) {
+			offsets[name] = {inode: stat.ino, offset: start};
+			return [];
+		}
+
+		const buffer = Buffer.alloc(length);
+		const fd     = fs.openSync(file, 'r');
+		fs.readSync(fd, buffer, 0, length, start);
+		fs.closeSync(fd);
+
+		// Leave a line that's still being written for next time
+		const end     = buffer.lastIndexOf('\n');
+		offsets[name] = {inode: stat.ino, offset: start + end + 1};
+
+		return end === -1 ? [] : buffer.toString('utf8', 0, end).split('\n');
+	},
+
+	/**
+	 * @param   {String}  line
+	 * @returns {Object|null}
+	 */
+	parseLine: (line) => {
+		const match = line.match(LOG_LINE);
+		if (!match) {
+			return null;
+		}
+
+		const time = moment(match[1], 'DD/MMM/YYYY:HH:mm:ss Z');
+		if (!time.isValid()) {
+			return null;
+		}
+
+		let referrer = null;
+		if (match[10] && match[10] !== '-') {
+			try {
+				referrer = new URL(match[10]).hostname.toLowerCase();
+			} catch (err) {
+				// Not a url
+			}
+		}
+
+		return {
+			time:       time,
+			day:        time.utc().format('YYYY-MM-DD'),
+			host:       match[5].toLowerCase(),
+			client:     match[7],
+			bytes:      parseInt(match[8], 10),
+			user_agent: match[9],
+			referrer:   referrer
+		};
+	},
+
+	/**
+	 * Triggered by a timer, this adds up what's been written to the access logs
+	 * of each host since the last time.
+	 *
+	 * @returns {Promise}
+	 */
+	processLogs: () => {
+		if (internalAnalytics.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalAnalytics.intervalProcessing = true;
+
+		return internalAnalytics.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return false;
+				}
+
+				const options = _.assign({}, DEFAULTS, setting.meta);
+
+				return Promise.all([
+					internalAnalytics.getGeoip(options.geoip_database),
+					internalAnalytics.getGeoip(options.asn_database)
+				])
+					.then(([reader, asnReader]) => {
+						const offsets = internalAnalytics.getOffsets();
+						let totals    = {};
+
+						const count = (key, bytes) => {
+							if (typeof totals[key] === 'undefined') {
+								totals[key] = {requests: 0, bytes: 0};
+							}
+							totals[key].requests += 1;
+							totals[key].bytes += bytes;
+						};
+
+						fs.readdirSync(logDir).forEach((name) => {
+							const file = name.match(ACCESS_LOG);
+							if (!file) {
+								return;
+							}
+
+							internalAnalytics.readNewLines(name, offsets).forEach((line) => {
+								const item = internalAnalytics.parseLine(line);
+								if (!item) {
+									return;
+								}
+
+								const prefix = [file[1], file[2], item.day].join('|') + '|';
+
+								count(prefix + 'total|', item.bytes);
+								count(prefix + 'client|' + internalAnalytics.anonymize(item.client, options.anonymize), item.bytes);
+
+								if (reader) {
+									count(prefix + 'country|' + internalAnalytics.getCountry(reader, item.client), item.bytes);
+								}
+
+								// Looked up before the address is anonymized, it's the network that's kept
+								if (asnReader) {
+									count(prefix + 'asn|' + internalAnalytics.getAsn(asnReader, item.client), item.bytes);
+								}
+
+								const bot = internalAnalytics.classify(item.user_agent);
+								count(prefix + 'bot|' + bot.category, item.bytes);
+								if (bot.name) {
+									count(prefix + 'crawler|' + bot.name, item.bytes);
+								}
+
+								// Links within the site aren't referrals
+								if (item.referrer && item.referrer !== item.host) {
+									count(prefix + 'referrer|' + item.referrer.substring(0, 255), item.bytes);
+								}
+							});
+						});
+
+						return internalAnalytics.save(totals)
+							.then(() => {
+								internalAnalytics.saveOffsets(offsets);
+								return internalAnalytics.removeExpired(options.retention);
+							});
+					})
+					.then(() => {
+						return true;
+					});
+			})
+			.then((result) => {
+				internalAnalytics.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error('Analytics processing failed: ' + err.message);
+				internalAnalytics.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * Adds the new counts to the stored ones
+	 *
+	 * @param   {Object}  totals  'object_type|object_id|day|kind|value' => {requests, bytes}
+	 * @returns {Promise}
+	 */
+	save: (totals) => {
+		let sequence = Promise.resolve();
+
+		_.forEach(totals, (total, key) => {
+			const [object_type, object_id, day, kind, value] = key.split('|');
+			const where = {
+				object_type: object_type,
+				object_id:   parseInt(object_id, 10),
+				day:         day,
+				kind:        kind,
+				value:       value
+			};
+
+			sequence = sequence
+				.then(() => {
+					return analyticsModel
+						.query()
+						.where(where)
+						.first();
+				})
+				.then((row) => {
+					if (row) {
+						return analyticsModel
+							.query()
+							.patchAndFetchById(row.id, {
+								requests: row.requests + total.requests,
+								bytes:    parseInt(row.bytes, 10) + total.bytes
+							});
+					}
+
+					return analyticsModel
+						.query()
+						.insert(_.assign({}, where, total));
+				});
+		});
+
+		return sequence;
+	},
+
+	/**
+	 * @param   {Number}  retention  in days
+	 * @returns {Promise}
+	 */
+	removeExpired: (retention) => {
+		return analyticsModel
+			.query()
+			.delete()
+			.where('day', '<', moment.utc().subtract(retention, 'days').format('YYYY-MM-DD'));
+	},
+
+	/**
+	 * Top clients, countries, networks, bots and referrers of a host
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  ie: 'proxy-host'
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.days]
+	 * @param   {Number}  [data.limit]
+	 * @returns {Promise}
+	 */
+	getReport: (access, object_type, data) => {
+		const modules = {
+			'proxy-host':       './proxy-host',
+			'redirection-host': './redirection-host',
+			'dead-host':        './dead-host'
+		};
+
+		const days  = data.days || 7;
+		const limit = data.limit || 10;
+		const since = moment.utc().subtract(days - 1, 'days').format('YYYY-MM-DD');
+
+		// Whoever can see the host can see its analytics
+		return require(modules[object_type]).get(access, {id: data.id})
+			.then(() => {
+				return Promise.all([
+					internalAnalytics.getSetting(),
+					analyticsModel
+						.query()
+						.where('object_type', object_type)
+						.andWhere('object_id', data.id)
+						.andWhere('day', '>=', since)
+				]);
+			})
+			.then(([setting, rows]) => {
+				const totals   = rows.filter((row) => row.kind === 'total');
+				const requests = _.sumBy(totals, 'requests');
+
+				const top = (kind) => {
+					const items = _.map(_.groupBy(rows.filter((row) => row.kind === kind), 'value'), (group, value) => {
+						const count = _.sumBy(group, 'requests');
+						return {
+							value:    value,
+							requests: count,
+							bytes:    _.sumBy(group, (row) => parseInt(row.bytes, 10)),
+							// Percent of the requests to the host
+							share:    requests ? Math.round(count * 1000 / requests) / 10 : 0
+						};
+					});
+
+					return _.take(_.orderBy(items, ['requests', 'value'], ['desc', 'asc']), limit);
+				};
+
+				return {
+					object_type: object_type,
+					object_id:   data.id,
+					since:       since,
+					enabled:     !!setting && setting.value === 'on',
+					anonymize:   _.assign({}, DEFAULTS, setting ? setting.meta : {}).anonymize,
+					requests:    requests,
+					bytes:       _.sumBy(totals, (row) => parseInt(row.bytes, 10)),
+					clients:     top('client'),
+					countries:   top('country'),
+					asns:        top('asn'),
+					bots:        top('bot'),
+					crawlers:    top('crawler'),
+					referrers:   top('referrer')
+				};
+			});
+	}
+};
+
+module.exports = internalAnalytics;
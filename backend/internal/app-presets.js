@@ -0,0 +1,170 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+/**
+ * Settings self-hosted apps need behind a proxy, from their own docs. The keys are what hosts are created with.
+ */
+const PRESETS = {
+	'nextcloud': {
+		name:        'Nextcloud',
+		description: 'Large uploads, and the redirects for CalDAV and CardDAV clients',
+		port:        80,
+		host:        {
+			allow_websocket_upgrade: true,
+			block_exploits:          false,
+			limits:                  {
+				client_max_body_size: '10g',
+				proxy_read_timeout:   3600,
+				proxy_send_timeout:   3600
+			},
+			advanced_config: 'proxy_request_buffering off;\n' +
+				'location = /.well-known/carddav { return 301 $scheme://$host/remote.php/dav; }\n' +
+				'location = /.well-known/caldav { return 301 $scheme://$host/remote.php/dav; }'
+		}
+	},
+	'home-assistant': {
+		name:        'Home Assistant',
+		description: 'Websockets kept open for the frontend. Home Assistant also needs the proxy in trusted_proxies.',
+		port:        8123,
+		host:        {
+			allow_websocket_upgrade: true,
+			block_exploits:          false,
+			limits:                  {
+				proxy_read_timeout: 3600
+			}
+		}
+	},
+	'jellyfin': {
+		name:        'Jellyfin',
+		description: 'Websockets, and streams sent without buffering',
+		port:        8096,
+		host:        {
+			allow_websocket_upgrade: true,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '20m'
+			},
+			advanced_config: 'proxy_buffering off;'
+		}
+	},
+	'gitea': {
+		name:        'Gitea',
+		description: 'Pushes and LFS uploads of large files',
+		port:        3000,
+		host:        {
+			allow_websocket_upgrade: false,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '512m',
+				proxy_read_timeout:   600
+			}
+		}
+	},
+	'qinglong': {
+		name:        '青龙面板',
+		description: 'Websockets for the live logs of tasks',
+		port:        5700,
+		host:        {
+			allow_websocket_upgrade: true,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '50m'
+			}
+		}
+	},
+	'vaultwarden': {
+		name:        'Vaultwarden',
+		description: 'Websockets for live sync, and attachments up to 525 MB',
+		port:        80,
+		host:        {
+			allow_websocket_upgrade: true,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '525m'
+			}
+		}
+	},
+	'portainer': {
+		name:        'Portainer',
+		description: 'The https port, and websockets for the console of containers',
+		port:        9443,
+		host:        {
+			forward_scheme:          'https',
+			allow_websocket_upgrade: true,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '1g'
+			}
+		}
+	},
+	'alist': {
+		name:        'AList',
+		description: 'Large uploads and downloads, sent without buffering',
+		port:        5244,
+		host:        {
+			allow_websocket_upgrade: false,
+			block_exploits:          true,
+			limits:                  {
+				client_max_body_size: '10g',
+				proxy_read_timeout:   3600,
+				proxy_send_timeout:   3600
+			},
+			advanced_config: 'proxy_buffering off;\nproxy_request_buffering off;'
+		}
+	}
+};
+
+const internalAppPresets = {
+
+	PRESETS: PRESETS,
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}  resolves with ie: [{id: 'jellyfin', name: 'Jellyfin', description, port, host: {}}]
+	 */
+	getAll: (access) => {
+		return access.can('presets:list')
+			.then(() => {
+				return _.map(PRESETS, (preset, id) => {
+					return _.assign({id: id}, _.cloneDeep(preset));
+				});
+			});
+	},
+
+	/**
+	 * Fills in what's missing from the payload of a new proxy host with the preset it's created with.
+	 * What the payload has wins, the limits being merged.
+	 *
+	 * @param   {Object}  data
+	 * @param   {String}  [data.app_preset]
+	 * @returns {Promise}
+	 */
+	apply: (data) => {
+		const id = data.app_preset;
+		delete data.app_preset;
+
+		if (typeof id === 'undefined' || id === null) {
+			return Promise.resolve();
+		}
+
+		if (typeof PRESETS[id] === 'undefined') {
+			return Promise.reject(new error.ValidationError('There\'s no preset for ' + id));
+		}
+
+		_.forEach(PRESETS[id].host, (value, field) => {
+			if (field === 'limits') {
+				if (typeof data.limits === 'undefined' || (data.limits && typeof data.limits === 'object')) {
+					data.limits = _.assign({}, value, data.limits);
+				}
+			} else if (typeof data[field] === 'undefined') {
+				data[field] = value;
+			}
+		});
+
+		data.meta = _.assign({}, data.meta, {app_preset: id});
+
+		return Promise.resolve();
+	}
+};
+
+module.exports = internalAppPresets;
@@ -1,25 +1,26 @@
 const error            = require('../lib/error');
 const auditLogModel    = require('../models/audit-log');
+const utils            = require('../lib/utils');
 const {castJsonIfNeed} = require('../lib/helpers');
 
 const internalAuditLog = {
 
 	/**
-	 * All logs
+	 * The last 100 logs, or all of them a batch at a time
 	 *
-	 * @param   {Access}  access
-	 * @param   {Array}   [expand]
-	 * @param   {String}  [search_query]
+	 * @param   {Access}    access
+	 * @param   {Array}     [expand]
+	 * @param   {String}    [search_query]
+	 * @param   {Function}  [each]  given the rows a batch at a time instead of resolving with the last 100
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, each) => {
 		return access.can('auditlog:list')
 			.then(() => {
 				let query = auditLogModel
 					.query()
 					.orderBy('created_on', 'DESC')
 					.orderBy('id', 'DESC')
-					.limit(100)
 					.allowGraph('[user]');
 
 				// Query is used for searching
@@ -33,7 +34,11 @@ const internalAuditLog = {
 					query.withGraphFetched('[' + expand.join(', ') + ']');
 				}
 
-				return query;
+				if (each) {
+					return utils.eachBatch(query, each);
+				}
+
+				return query.limit(100);
 			});
 	},
 
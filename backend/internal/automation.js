@@ -0,0 +1,543 @@
+const _                     = require('lodash');
+const http                  = require('http');
+const https                 = require('https');
+const moment                = require('moment');
+const logger                = require('../logger').global;
+const error                 = require('../lib/error');
+const Access                = require('../lib/access');
+const proxyAgent            = require('../lib/proxy-agent');
+const automationRuleModel   = require('../models/automation_rule');
+const activityEventModel    = require('../models/activity_event');
+const userModel             = require('../models/user');
+const internalAuditLog      = require('./audit-log');
+const internalActivity      = require('./activity');
+const internalNotifications = require('./notifications');
+const internalToken         = require('./token');
+
+/**
+ * The hosts actions can turn on and off. Required when used, as these modules require the activity one.
+ */
+const MODULES = {
+	'proxy-host':       './proxy-host',
+	'redirection-host': './redirection-host',
+	'dead-host':        './dead-host',
+	'stream':           './stream'
+};
+
+// The most new events looked at on each run
+const BATCH_SIZE = 500;
+
+const HTTP_TIMEOUT = 10000;
+
+// Where the last run got up to in the activity, on this replica
+let cursor = null;
+
+function omissions () {
+	return ['is_deleted', 'owner.is_deleted'];
+}
+
+const internalAutomation = {
+
+	intervalTimeout:    1000 * 30, // 30 seconds
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Automation Rules Timer initialized');
+		internalAutomation.interval = setInterval(internalAutomation.processRules, internalAutomation.intervalTimeout);
+	},
+
+	/**
+	 * Actions that don't say which host they're for act on the one the event is about, so the trigger has to say what kind it is
+	 *
+	 * @param   {Object}  trigger
+	 * @param   {Array}   actions
+	 * @returns {Promise}
+	 */
+	validate: (trigger, actions) => {
+		if (trigger.for_minutes && !trigger.event) {
+			return Promise.reject(new error.ValidationError('A rule that waits for_minutes needs the event it waits on, ie: offline'));
+		}
+
+		for (const action of actions) {
+			if (!action.object_type !== !action.object_id) {
+				return Promise.reject(new error.ValidationError('The ' + action.type + ' action needs both the object_type and object_id of the host it acts on, or neither'));
+			}
+
+			const object_type = action.object_type || trigger.object_type;
+
+			if (['enable_host', 'disable_host', 'switch_upstream'].indexOf(action.type) !== -1) {
+				if (!object_type || typeof MODULES[object_type] === 'undefined') {
+					return Promise.reject(new error.ValidationError('The ' + action.type + ' action needs the host it acts on, give it an object_id or the trigger an object_type of a host'));
+				}
+				if (action.type === 'switch_upstream' && object_type !== 'proxy-host') {
+					return Promise.reject(new error.ValidationError('Only proxy hosts have forward hosts to switch between'));
+				}
+			}
+
+			if (action.type === 'webhook') {
+				let url;
+				try {
+					url = new URL(action.url || '');
+				} catch (err) {
+					return Promise.reject(new error.ValidationError('The webhook action needs a url, ie: https://hooks.example.com/npm'));
+				}
+				if (['http:', 'https:'].indexOf(url.protocol) === -1) {
+					return Promise.reject(new error.ValidationError('The url of the webhook action has to be http:// or https://'));
+				}
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * @param   {Object}  trigger
+	 * @param   {Object}  row  of activity_event
+	 * @returns {Boolean}
+	 */
+	matches: (trigger, row) => {
+		return row.source === trigger.source &&
+			(!trigger.event || row.event === trigger.event) &&
+			(typeof trigger.success !== 'boolean' || row.is_success === trigger.success) &&
+			(!trigger.object_type || row.object_type === trigger.object_type) &&
+			(!trigger.object_id || row.object_id === trigger.object_id);
+	},
+
+	/**
+	 * @param   {Object}  rule
+	 * @param   {Object}  row  of activity_event
+	 * @returns {String}  ie: Rule Failover - Proxy host #3: health offline
+	 */
+	describe: (rule, row) => {
+		return 'Rule ' + rule.name + ' - ' + _.upperFirst(row.object_type.replace('-', ' ')) + ' #' + row.object_id + ': ' + row.source + ' ' + row.event + (row.meta && row.meta.message ? ' - ' + row.meta.message : '');
+	},
+
+	/**
+	 * POSTs what set off a rule to a url as JSON
+	 *
+	 * @param   {String}  url
+	 * @param   {Object}  payload
+	 * @returns {Promise}
+	 */
+	post: (url, payload) => {
+		return new Promise((resolve, reject) => {
+			const body   = JSON.stringify(payload);
+			const secure = url.startsWith('https:');
+			const req    = (secure ? https : http).request(url, {
+				method:  'POST',
+				timeout: HTTP_TIMEOUT,
+				agent:   secure ? proxyAgent.forUrl(url) : undefined,
+				headers: {
+					'Content-Type':   'application/json; charset=utf-8',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager',
+					'X-NPM-Hook':     'automation.rule'
+				}
+			}, (res) => {
+				res.resume();
+				if (res.statusCode < 200 || res.statusCode >= 300) {
+					reject(new Error(url.replace(/\?.*$/, '') + ' answered ' + res.statusCode));
+					return;
+				}
+				resolve();
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('There was no answer within ' + (HTTP_TIMEOUT / 1000) + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	/**
+	 * @param   {Access}  access  of the owner of the rule
+	 * @param   {Object}  rule
+	 * @param   {Object}  action
+	 * @param   {Object}  row     of activity_event
+	 * @returns {Promise} resolves with what was done, ie: disabled proxy-host #3
+	 */
+	runAction: (access, rule, action, row) => {
+		const object_type = action.object_type || row.object_type;
+		const object_id   = action.object_id || row.object_id;
+
+		switch (action.type) {
+		case 'enable_host':
+		case 'disable_host':
+			if (typeof MODULES[object_type] === 'undefined') {
+				return Promise.reject(new Error('A ' + object_type + ' can\'t be turned on or off'));
+			}
+
+			return require(MODULES[object_type])[action.type === 'enable_host' ? 'enable' : 'disable'](access, {id: object_id})
+				.then(() => (action.type === 'enable_host' ? 'enabled ' : 'disabled ') + object_type + ' #' + object_id)
+				.catch((err) => {
+					// Already the way it was asked for
+					if (err instanceof error.ValidationError && /already/i.test(err.message)) {
+						return object_type + ' #' + object_id + ' was already ' + (action.type === 'enable_host' ? 'enabled' : 'disabled');
+					}
+					throw err;
+				});
+
+		case 'switch_upstream':
+			return require('./upstream-switch').switch(access, {id: object_id, to: action.to, check: action.check})
+				.then((result) => 'switched proxy-host #' + object_id + ' from ' + result.from + ' to ' + result.to);
+
+		case 'notify':
+			return internalNotifications.send('automation', action.severity || 'warning', action.message ? 'Rule ' + rule.name + ': ' + action.message : internalAutomation.describe(rule, row))
+				.then(() => 'notified');
+
+		case 'webhook':
+			return internalAutomation.post(action.url, {
+				rule: {
+					id:   rule.id,
+					name: rule.name
+				},
+				event: {
+					object_type: row.object_type,
+					object_id:   row.object_id,
+					source:      row.source,
+					event:       row.event,
+					success:     row.is_success,
+					message:     row.meta && row.meta.message ? row.meta.message : null,
+					created_on:  row.created_on
+				}
+			})
+				.then(() => 'called ' + action.url.replace(/\?.*$/, ''));
+		}
+
+		return Promise.reject(new Error('There\'s no ' + action.type + ' action'));
+	},
+
+	/**
+	 * Runs the actions of a rule one after the other, as its owner, stopping at the first that fails.
+	 * What happened is recorded in the activity of what set it off, and on the rule.
+	 *
+	 * @param   {Object}  rule
+	 * @param   {Object}  row   of activity_event
+	 * @param   {Object}  [meta]  changes to the meta of the rule, saved with the run
+	 * @returns {Promise}
+	 */
+	run: (rule, row, meta) => {
+		let done = [];
+
+		return userModel
+			.query()
+			.where('id', rule.owner_user_id)
+			.andWhere('is_deleted', 0)
+			.andWhere('is_disabled', 0)
+			.first()
+			.then((user) => {
+				if (!user) {
+					throw new Error('The user who made it no longer exists or is disabled');
+				}
+				return internalToken.getTokenFromUser(user);
+			})
+			.then((token) => {
+				const access = new Access(token.token);
+
+				return rule.actions.reduce((sequence, action) => {
+					return sequence
+						.then(() => internalAutomation.runAction(access, rule, action, row))
+						.then((result) => {
+							done.push(result);
+						});
+				}, Promise.resolve());
+			})
+			.then(() => null, (err) => err.message)
+			.then((err) => {
+				const message = 'Rule ' + rule.name + (done.length ? ' ' + done.join(', ') : '') + (err ? (done.length ? ', then failed: ' : ' failed: ') + err : '');
+
+				if (err) {
+					logger.warn(message);
+				} else {
+					logger.info(message);
+				}
+
+				return Promise.all([
+					internalActivity.record(row.object_type, row.object_id, 'automation', 'rule', !err, message),
+					automationRuleModel
+						.query()
+						.where('id', rule.id)
+						.patch({
+							meta: _.assign({}, rule.meta, meta || {}, {
+								last_run: {
+									ran_on:      new Date().toISOString(),
+									object_type: row.object_type,
+									object_id:   row.object_id,
+									error:       err
+								}
+							})
+						})
+				]);
+			});
+	},
+
+	/**
+	 * The rules that wait for a state to last, ie: a host offline for 10 minutes. The last event from the source
+	 * of each object is what state it's in, until another one comes along. A rule runs once for each time it's set off.
+	 *
+	 * @param   {Object}  rule
+	 * @returns {Promise}
+	 */
+	processLasting: (rule) => {
+		const trigger = rule.trigger;
+
+		return activityEventModel
+			.query()
+			.whereIn('id', activityEventModel
+				.query()
+				.max('id')
+				.where('source', trigger.source)
+				.modify((qb) => {
+					if (trigger.object_type) {
+						qb.andWhere('object_type', trigger.object_type);
+					}
+					if (trigger.object_id) {
+						qb.andWhere('object_id', trigger.object_id);
+					}
+				})
+				.groupBy('object_type', 'object_id'))
+			.then((rows) => {
+				const before = moment().subtract(trigger.for_minutes, 'minutes');
+				const fired  = (rule.meta && rule.meta.fired) || {};
+
+				return rows
+					.filter((row) => internalAutomation.matches(trigger, row) && moment(row.created_on).isSameOrBefore(before) && fired[row.object_type + '#' + row.object_id] !== row.id)
+					.reduce((sequence, row) => {
+						return sequence.then(() => {
+							fired[row.object_type + '#' + row.object_id] = row.id;
+							return internalAutomation.run(rule, row, {fired: fired});
+						});
+					}, Promise.resolve());
+			});
+	},
+
+	/**
+	 * Triggered by a timer on the leader, this runs the rules the activity since the last run sets off.
+	 * The first run starts from now, so what happened before the backend started doesn't set off a rule,
+	 * apart from a state that has lasted long enough.
+	 *
+	 * @returns {Promise}
+	 */
+	processRules: () => {
+		if (internalAutomation.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalAutomation.intervalProcessing = true;
+
+		let rules = [];
+
+		return automationRuleModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('enabled', 1)
+			.orderBy('id', 'ASC')
+			.then((rows) => {
+				rules = rows;
+
+				if (cursor === null) {
+					return activityEventModel
+						.query()
+						.max('id as id')
+						.first()
+						.then((row) => {
+							cursor = row && row.id ? parseInt(row.id, 10) : 0;
+							return [];
+						});
+				}
+
+				return activityEventModel
+					.query()
+					.where('id', '>', cursor)
+					.orderBy('id', 'ASC')
+					.limit(BATCH_SIZE);
+			})
+			.then((events) => {
+				if (events.length) {
+					cursor = events[events.length - 1].id;
+				}
+
+				const instant = rules.filter((rule) => !rule.trigger.for_minutes);
+
+				return events.reduce((sequence, row) => {
+					return instant.filter((rule) => internalAutomation.matches(rule.trigger, row)).reduce((rule_sequence, rule) => {
+						return rule_sequence.then(() => internalAutomation.run(rule, row));
+					}, sequence);
+				}, Promise.resolve());
+			})
+			.then(() => {
+				return rules.filter((rule) => rule.trigger.for_minutes).reduce((sequence, rule) => {
+					return sequence.then(() => internalAutomation.processLasting(rule));
+				}, Promise.resolve());
+			})
+			.then(() => {
+				internalAutomation.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error('Automation rules failed: ' + err.message);
+				internalAutomation.intervalProcessing = false;
+				return false;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {Object}  data.trigger
+	 * @param   {Array}   data.actions
+	 * @param   {Boolean} [data.enabled]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('automation_rules:create', data)
+			.then(() => {
+				return internalAutomation.validate(data.trigger, data.actions);
+			})
+			.then(() => {
+				return automationRuleModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						enabled:       data.enabled !== false,
+						name:          data.name,
+						trigger:       data.trigger,
+						actions:       data.actions,
+						meta:          {}
+					});
+			})
+			.then((row) => {
+				row = _.omit(row, omissions());
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'automation-rule',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		let patch = _.pick(data, ['enabled', 'name', 'trigger', 'actions']);
+
+		return access.can('automation_rules:update', data.id)
+			.then(() => {
+				return internalAutomation.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalAutomation.validate(patch.trigger || row.trigger, patch.actions || row.actions)
+					.then(() => {
+						// A new trigger starts again, so the states it already ran for can set it off
+						if (patch.trigger) {
+							patch.meta = _.omit(row.meta, ['fired']);
+						}
+
+						return automationRuleModel
+							.query()
+							.patchAndFetchById(row.id, patch);
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'automation-rule',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return _.omit(saved_row, omissions());
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('automation_rules:delete', data.id)
+			.then(() => {
+				return internalAutomation.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return automationRuleModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'automation-rule',
+							object_id:   row.id,
+							meta:        row
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('automation_rules:get', data.id)
+			.then(() => {
+				return automationRuleModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first();
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return _.omit(row, omissions());
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('automation_rules:list')
+			.then(() => {
+				return automationRuleModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+			})
+			.then((rows) => {
+				return rows.map((row) => _.omit(row, omissions()));
+			});
+	}
+};
+
+module.exports = internalAutomation;
@@ -0,0 +1,98 @@
+const fs           = require('fs');
+const net          = require('net');
+const error        = require('../lib/error');
+const config       = require('../lib/config');
+const logger       = require('../logger').global;
+const settingModel = require('../models/setting');
+
+/**
+ * @returns {String}  the file nginx.conf includes in its geo block, see 20-paths.sh
+ */
+const getConfigName = () => {
+	return config.getPath('nginx') + '/blocked_clients.conf';
+};
+
+const internalBlockedClients = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'blocked-clients')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @returns {Array}   the addresses nginx refuses, none when the setting is off
+	 */
+	getAddresses: (setting) => {
+		if (!setting || setting.value !== 'on' || !setting.meta || !Array.isArray(setting.meta.clients)) {
+			return [];
+		}
+		return setting.meta.clients.map((client) => client.address);
+	},
+
+	/**
+	 * @param   {String}   address  ie: 203.0.113.7 or 203.0.113.0/24
+	 * @returns {Boolean}
+	 */
+	isValidAddress: (address) => {
+		const [ip, bits, extra] = String(address).split('/');
+		const version           = net.isIP(ip);
+
+		if (!version || typeof extra !== 'undefined') {
+			return false;
+		}
+		if (typeof bits === 'undefined') {
+			return true;
+		}
+		return /^\d{1,3}$/.test(bits) && parseInt(bits, 10) <= (version === 6 ? 128 : 32);
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		const invalid = ((meta && meta.clients) || []).filter((client) => !internalBlockedClients.isValidAddress(client.address));
+		if (invalid.length) {
+			return Promise.reject(new error.ValidationError('Not an IP address or range: ' + invalid.map((client) => client.address).join(', ')));
+		}
+		return Promise.resolve();
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 */
+	writeConfig: (setting) => {
+		const lines = internalBlockedClients.getAddresses(setting).map((address) => address + ' 1;\n');
+		fs.writeFileSync(getConfigName(), '# Written by the blocked-clients setting\n' + lines.join(''), {encoding: 'utf8'});
+	},
+
+	/**
+	 * Writes the addresses and reloads nginx. Hosts written before they checked for blocked
+	 * clients are written again first, every host does since.
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		const internalNginx = require('./nginx');
+
+		internalBlockedClients.writeConfig(setting);
+		logger.info('Blocking ' + internalBlockedClients.getAddresses(setting).length + ' clients');
+
+		return require('./host').regenerateConfigs((host, host_type) => {
+			const file = internalNginx.getConfigName(host_type, host.id);
+			return !fs.existsSync(file) || fs.readFileSync(file, {encoding: 'utf8'}).indexOf('$npm_blocked_client') === -1;
+		})
+			.then(() => {
+				return internalNginx.reload();
+			});
+	}
+};
+
+module.exports = internalBlockedClients;
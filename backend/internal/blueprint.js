@@ -0,0 +1,249 @@
+const _                = require('lodash');
+const error            = require('../lib/error');
+const utils            = require('../lib/utils');
+const blueprintModel   = require('../models/blueprint');
+const internalAuditLog = require('./audit-log');
+const internalTenant   = require('./tenant');
+
+// Replaced in the strings of a blueprint when a host is made from it, ie: proxy_set_header X-Site {{domain_name}};
+const PLACEHOLDER = /\{\{\s*(domain_name|forward_scheme|forward_host|forward_port)\s*\}\}/g;
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalBlueprint = {
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  [data.description]
+	 * @param   {Object}  [data.host]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('blueprints:create', data)
+			.then(() => {
+				return blueprintModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						name:          data.name,
+						description:   data.description || '',
+						host:          data.host || {},
+						meta:          data.meta || {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'blueprint',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.description]
+	 * @param   {Object}  [data.host]  replaces the one it has
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		return access.can('blueprints:update', data.id)
+			.then(() => {
+				return internalBlueprint.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.id !== data.id) {
+					// Sanity check that something crazy hasn't happened
+					throw new error.InternalValidationError('Blueprint could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
+				}
+
+				return blueprintModel
+					.query()
+					.patchAndFetchById(row.id, _.pick(data, ['name', 'description', 'host', 'meta']))
+					.then(utils.omitRow(omissions()));
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'blueprint',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * Hosts made from the blueprint are kept
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('blueprints:delete', data.id)
+			.then(() => {
+				return internalBlueprint.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return blueprintModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'blueprint',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('blueprints:get', data.id)
+			.then((access_data) => {
+				let query = blueprintModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.allowGraph('[owner]')
+					.first();
+
+				const tenant = internalTenant.getFilter(access_data);
+				if (tenant) {
+					query.andWhere(tenant);
+				}
+
+				if (typeof data.expand !== 'undefined' && data.expand !== null) {
+					query.withGraphFetched('[' + data.expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Array}   [expand]
+	 * @param   {String}  [search_query]
+	 * @returns {Promise}
+	 */
+	getAll: (access, expand, search_query) => {
+		return access.can('blueprints:list')
+			.then((access_data) => {
+				let query = blueprintModel
+					.query()
+					.where('is_deleted', 0)
+					.allowGraph('[owner]')
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				const tenant = internalTenant.getFilter(access_data);
+				if (tenant) {
+					query.andWhere(tenant);
+				}
+
+				// Query is used for searching
+				if (typeof search_query === 'string' && search_query.length > 0) {
+					query.where(function () {
+						this.where('name', 'like', '%' + search_query + '%');
+					});
+				}
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * The payload of a new proxy host from a blueprint, with its placeholders filled in. What's given wins over the blueprint.
+	 *
+	 * @param   {Object}  blueprint
+	 * @param   {Object}  data
+	 * @param   {Array}   data.domain_names
+	 * @param   {String}  data.forward_host
+	 * @param   {Number}  data.forward_port
+	 * @returns {Object}
+	 */
+	fillHost: (blueprint, data) => {
+		let host = _.assign({forward_scheme: 'http'}, _.cloneDeep(blueprint.host), data);
+
+		const values = {
+			domain_name:    host.domain_names[0],
+			forward_scheme: host.forward_scheme,
+			forward_host:   host.forward_host,
+			forward_port:   String(host.forward_port)
+		};
+
+		host = _.cloneDeepWith(host, (value) => {
+			if (typeof value === 'string') {
+				return value.replace(PLACEHOLDER, (match, name) => values[name]);
+			}
+		});
+
+		host.meta = _.assign({}, host.meta, {blueprint_id: blueprint.id});
+
+		return host;
+	},
+
+	/**
+	 * The payload of a new proxy host from a blueprint, to create like any other
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id  of the blueprint
+	 * @param   {Array}   data.domain_names
+	 * @param   {String}  data.forward_host
+	 * @param   {Number}  data.forward_port
+	 * @returns {Promise}
+	 */
+	getHost: (access, data) => {
+		return internalBlueprint.get(access, {id: data.id})
+			.then((blueprint) => {
+				return internalBlueprint.fillHost(blueprint, _.omit(data, ['id']));
+			});
+	}
+};
+
+module.exports = internalBlueprint;
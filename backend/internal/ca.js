@@ -0,0 +1,383 @@
+const fs               = require('fs');
+const crypto           = require('crypto');
+const moment           = require('moment');
+const net              = require('net');
+const config           = require('../lib/config');
+const logger           = require('../logger').ssl;
+const error            = require('../lib/error');
+const utils            = require('../lib/utils');
+const helpers          = require('../lib/helpers');
+const certificateModel = require('../models/certificate');
+const internalAuditLog = require('./audit-log');
+const internalStorage  = require('./certificate-storage');
+
+const CA_DIR = config.getPath('internal_ca');
+
+const ROOT_VALIDITY_DAYS   = 3650;
+const ISSUER_VALIDITY_DAYS = 1825;
+const LEAF_VALIDITY_DAYS   = 365;
+
+const internalCa = {
+
+	/**
+	 * @param   {String}  name
+	 * @returns {String}
+	 */
+	caFile: (name) => {
+		return CA_DIR + '/' + name;
+	},
+
+	/**
+	 * @returns {Boolean}
+	 */
+	exists: () => {
+		return fs.existsSync(internalCa.caFile('issuer.crt')) && fs.existsSync(internalCa.caFile('issuer.key'));
+	},
+
+	/**
+	 * @returns {String}
+	 */
+	serial: () => {
+		// Positive 128 bit serial, RFC 5280 allows up to 20 octets
+		const bytes = crypto.randomBytes(16);
+		bytes[0]    = bytes[0] & 0x7f;
+		return '0x' + bytes.toString('hex');
+	},
+
+	/**
+	 * Writes an openssl extensions file to a temp location and returns the path
+	 *
+	 * @param   {Array}  lines
+	 * @returns {String}
+	 */
+	writeExtFile: (lines) => {
+		const filename = '/tmp/npm-ca-ext-' + crypto.randomBytes(8).toString('hex') + '.cnf';
+		fs.writeFileSync(filename, lines.join('\n') + '\n', {encoding: 'utf8'});
+		return filename;
+	},
+
+	/**
+	 * Creates an EC key and a signing request for it
+	 *
+	 * @param   {String}  key_file
+	 * @param   {String}  csr_file
+	 * @param   {String}  subject
+	 * @returns {Promise}
+	 */
+	createKeyAndCsr: (key_file, csr_file, subject) => {
+		return utils.execFile('openssl', ['ecparam', '-name', 'prime256v1', '-genkey', '-noout', '-out', key_file])
+			.then(() => {
+				fs.chmodSync(key_file, 0o600);
+				return utils.execFile('openssl', ['req', '-new', '-sha256', '-key', key_file, '-subj', subject, '-out', csr_file]);
+			});
+	},
+
+	/**
+	 * @param   {String}  csr_file
+	 * @param   {String}  out_file
+	 * @param   {Number}  days
+	 * @param   {Array}   extensions
+	 * @param   {String}  [ca_cert]   Self-signed when omitted
+	 * @param   {String}  [ca_key]
+	 * @param   {String}  [sign_key]  Key for self-signing
+	 * @returns {Promise}
+	 */
+	sign: (csr_file, out_file, days, extensions, ca_cert, ca_key, sign_key) => {
+		const ext_file = internalCa.writeExtFile(extensions);
+		const args     = ['x509', '-req', '-sha256', '-in', csr_file, '-out', out_file, '-days', String(days), '-set_serial', internalCa.serial(), '-extfile', ext_file];
+
+		if (ca_cert) {
+			args.push('-CA', ca_cert, '-CAkey', ca_key);
+		} else {
+			args.push('-signkey', sign_key);
+		}
+
+		return utils.execFile('openssl', args)
+			.then(() => {
+				fs.unlinkSync(ext_file);
+			})
+			.catch((err) => {
+				fs.unlinkSync(ext_file);
+				throw err;
+			});
+	},
+
+	/**
+	 * @param   {String}  file
+	 * @returns {Promise}
+	 */
+	getCertInfo: (file) => {
+		return utils.execFile('openssl', ['x509', '-in', file, '-noout', '-subject', '-issuer', '-enddate', '-fingerprint', '-sha256'])
+			.then((result) => {
+				const info = {};
+				result.split('\n').map((line) => {
+					const idx = line.indexOf('=');
+					if (idx === -1) {
+						return;
+					}
+
+					const key   = line.substring(0, idx).trim().toLowerCase();
+					const value = line.substring(idx + 1).trim();
+
+					if (key === 'subject' || key === 'issuer') {
+						info[key] = value;
+					} else if (key === 'notafter') {
+						info.expires_on = moment(value, 'MMM DD HH:mm:ss YYYY z').toISOString();
+					} else if (key.indexOf('fingerprint') !== -1) {
+						info.fingerprint = value;
+					}
+				});
+				return info;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getInfo: (access) => {
+		return access.can('certificates:list')
+			.then(() => {
+				if (!internalCa.exists()) {
+					return {
+						enabled: false
+					};
+				}
+
+				return Promise.all([
+					internalCa.getCertInfo(internalCa.caFile('root.crt')),
+					internalCa.getCertInfo(internalCa.caFile('issuer.crt'))
+				])
+					.then(([root, issuer]) => {
+						return {
+							enabled:  true,
+							imported: fs.existsSync(internalCa.caFile('imported')),
+							root:     root,
+							issuer:   issuer
+						};
+					});
+			});
+	},
+
+	/**
+	 * Creates a new root CA and an intermediate to sign certificates with, or imports an existing CA.
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {String}   [data.common_name]
+	 * @param   {String}   [data.certificate]
+	 * @param   {String}   [data.certificate_key]
+	 * @param   {String}   [data.intermediate_certificate]
+	 * @param   {Boolean}  [data.replace]
+	 * @returns {Promise}
+	 */
+	setup: (access, data) => {
+		return access.can('certificates:ca')
+			.then(() => {
+				if (internalCa.exists() && !data.replace) {
+					throw new error.ValidationError('An internal CA already exists, set replace to overwrite it');
+				}
+
+				if (!fs.existsSync(CA_DIR)) {
+					fs.mkdirSync(CA_DIR, {mode: 0o700});
+				}
+
+				if (typeof data.certificate !== 'undefined') {
+					return internalCa.importCa(data);
+				}
+
+				return internalCa.generateCa(data.common_name || 'Nginx Proxy Manager');
+			})
+			.then(() => {
+				return internalCa.getInfo(access);
+			})
+			.then((info) => {
+				return internalAuditLog.add(access, {
+					action:      typeof data.certificate !== 'undefined' ? 'imported' : 'created',
+					object_type: 'internal-ca',
+					meta:        info
+				})
+					.then(() => {
+						return info;
+					});
+			});
+	},
+
+	/**
+	 * @param   {String}  name
+	 * @returns {Promise}
+	 */
+	generateCa: (name) => {
+		const subject = (cn) => '/O=' + name.replace(/[/=]/g, ' ') + '/CN=' + cn;
+
+		logger.info('Generating internal CA: ' + name);
+
+		return internalCa.createKeyAndCsr(internalCa.caFile('root.key'), internalCa.caFile('root.csr'), subject(name + ' Root CA'))
+			.then(() => {
+				return internalCa.sign(internalCa.caFile('root.csr'), internalCa.caFile('root.crt'), ROOT_VALIDITY_DAYS, [
+					'basicConstraints=critical,CA:TRUE',
+					'keyUsage=critical,keyCertSign,cRLSign',
+					'subjectKeyIdentifier=hash'
+				], null, null, internalCa.caFile('root.key'));
+			})
+			.then(() => {
+				return internalCa.createKeyAndCsr(internalCa.caFile('issuer.key'), internalCa.caFile('issuer.csr'), subject(name + ' Intermediate CA'));
+			})
+			.then(() => {
+				return internalCa.sign(internalCa.caFile('issuer.csr'), internalCa.caFile('issuer.crt'), ISSUER_VALIDITY_DAYS, [
+					'basicConstraints=critical,CA:TRUE,pathlen:0',
+					'keyUsage=critical,keyCertSign,cRLSign',
+					'subjectKeyIdentifier=hash',
+					'authorityKeyIdentifier=keyid'
+				], internalCa.caFile('root.crt'), internalCa.caFile('root.key'));
+			})
+			.then(() => {
+				fs.copyFileSync(internalCa.caFile('issuer.crt'), internalCa.caFile('chain.pem'));
+				['root.csr', 'issuer.csr', 'imported'].map((file) => {
+					if (fs.existsSync(internalCa.caFile(file))) {
+						fs.unlinkSync(internalCa.caFile(file));
+					}
+				});
+			});
+	},
+
+	/**
+	 * The imported certificate signs new certificates directly. When it isn't a root itself,
+	 * the rest of its chain should be given as the intermediate certificate.
+	 *
+	 * @param   {Object}  data
+	 * @param   {String}  data.certificate
+	 * @param   {String}  data.certificate_key
+	 * @param   {String}  [data.intermediate_certificate]
+	 * @returns {Promise}
+	 */
+	importCa: (data) => {
+		const cert_file = '/tmp/npm-ca-import-' + crypto.randomBytes(8).toString('hex');
+		const key_file  = cert_file + '.key';
+
+		fs.writeFileSync(cert_file, data.certificate, {encoding: 'utf8'});
+		fs.writeFileSync(key_file, data.certificate_key, {encoding: 'utf8', mode: 0o600});
+
+		const cleanup = () => {
+			[cert_file, key_file].map((file) => {
+				if (fs.existsSync(file)) {
+					fs.unlinkSync(file);
+				}
+			});
+		};
+
+		return utils.execFile('openssl', ['x509', '-in', cert_file, '-noout', '-text'])
+			.then((result) => {
+				if (!/CA:TRUE/.test(result)) {
+					throw new error.ValidationError('Certificate is not a CA certificate');
+				}
+
+				return Promise.all([
+					utils.execFile('openssl', ['x509', '-in', cert_file, '-noout', '-pubkey']),
+					utils.execFile('openssl', ['pkey', '-in', key_file, '-pubout'])
+				]);
+			})
+			.then(([cert_pubkey, key_pubkey]) => {
+				if (cert_pubkey !== key_pubkey) {
+					throw new error.ValidationError('Certificate Key does not match the Certificate');
+				}
+
+				const chain = (data.intermediate_certificate || '').trim();
+				const pems  = chain.match(/-----BEGIN CERTIFICATE-----[\s\S]+?-----END CERTIFICATE-----/g) || [];
+
+				fs.writeFileSync(internalCa.caFile('issuer.crt'), data.certificate.trim() + '\n', {encoding: 'utf8'});
+				fs.writeFileSync(internalCa.caFile('issuer.key'), data.certificate_key.trim() + '\n', {encoding: 'utf8', mode: 0o600});
+				fs.writeFileSync(internalCa.caFile('chain.pem'), [data.certificate.trim()].concat(pems).join('\n') + '\n', {encoding: 'utf8'});
+				fs.writeFileSync(internalCa.caFile('root.crt'), (pems.length ? pems[pems.length - 1] : data.certificate.trim()) + '\n', {encoding: 'utf8'});
+				fs.writeFileSync(internalCa.caFile('imported'), moment().toISOString(), {encoding: 'utf8'});
+
+				if (fs.existsSync(internalCa.caFile('root.key'))) {
+					fs.unlinkSync(internalCa.caFile('root.key'));
+				}
+
+				cleanup();
+			})
+			.catch((err) => {
+				cleanup();
+				if (err instanceof error.ValidationError) {
+					throw err;
+				}
+				throw new error.ValidationError('Could not import CA (' + err.message + ')', err);
+			});
+	},
+
+	/**
+	 * Signs a certificate for the row's domains and writes it where nginx expects custom certificates
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}
+	 */
+	issue: (certificate) => {
+		if (!internalCa.exists()) {
+			return Promise.reject(new error.ValidationError('The internal CA has not been set up'));
+		}
+
+		const dir   = internalStorage.getDirectory(certificate);
+		const days  = (certificate.meta && certificate.meta.validity_days) || LEAF_VALIDITY_DAYS;
+		const names = certificate.domain_names.map((name) => helpers.toAsciiDomain(name) || name);
+		const san   = names.map((name) => {
+			return (net.isIP(name) ? 'IP:' : 'DNS:') + name;
+		});
+
+		logger.info('Issuing internal certificate for Cert #' + certificate.id + ': ' + certificate.domain_names.join(', '));
+
+		if (!fs.existsSync(dir)) {
+			fs.mkdirSync(dir, {recursive: true});
+		}
+
+		return internalCa.createKeyAndCsr(dir + '/privkey.pem.new', dir + '/cert.csr', '/CN=' + names[0].replace(/[/=]/g, ''))
+			.then(() => {
+				return internalCa.sign(dir + '/cert.csr', dir + '/cert.pem.new', days, [
+					'basicConstraints=critical,CA:FALSE',
+					'keyUsage=critical,digitalSignature,keyEncipherment',
+					'extendedKeyUsage=serverAuth',
+					'subjectKeyIdentifier=hash',
+					'authorityKeyIdentifier=keyid',
+					'subjectAltName=' + san.join(',')
+				], internalCa.caFile('issuer.crt'), internalCa.caFile('issuer.key'));
+			})
+			.then(() => {
+				const leaf  = fs.readFileSync(dir + '/cert.pem.new', {encoding: 'utf8'}).trim();
+				const chain = fs.readFileSync(internalCa.caFile('chain.pem'), {encoding: 'utf8'}).trim();
+
+				fs.writeFileSync(dir + '/fullchain.pem', leaf + '\n' + chain + '\n', {encoding: 'utf8'});
+				fs.renameSync(dir + '/privkey.pem.new', dir + '/privkey.pem');
+				fs.unlinkSync(dir + '/cert.pem.new');
+				fs.unlinkSync(dir + '/cert.csr');
+
+				return internalStorage.push(certificate);
+			})
+			.then(() => {
+				return internalCa.getCertInfo(dir + '/fullchain.pem');
+			})
+			.then((info) => {
+				return certificateModel
+					.query()
+					.patchAndFetchById(certificate.id, {
+						expires_on: moment(info.expires_on).format('YYYY-MM-DD HH:mm:ss')
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getRootFile: (access) => {
+		return access.can('certificates:list')
+			.then(() => {
+				if (!internalCa.exists()) {
+					throw new error.ItemNotFoundError('internal-ca');
+				}
+
+				return internalCa.caFile('root.crt');
+			});
+	}
+};
+
+module.exports = internalCa;
@@ -0,0 +1,416 @@
+const _     = require('lodash');
+const net   = require('net');
+const error = require('../lib/error');
+
+const UPSTREAM = /^(?:(https?|h2c):\/\/)?(\[[0-9a-f:.]+\]|[^/:{}]*)(?::(\d+))?$/i;
+
+// Sizes of request_body, ie: 100MB
+const SIZE = /^(\d+)\s*(k|m|g)i?b?$/i;
+
+const internalCaddyImport = {
+
+	/**
+	 * Splits a Caddyfile into lines of words, with the lines of a block as its children
+	 *
+	 * @param   {String}  text
+	 * @param   {String}  file
+	 * @returns {Array}   ie: [{words: ['example.com'], line: 1, file: 'Caddyfile', block: [...]}]
+	 */
+	parse: (text, file) => {
+		let stack   = [[]];
+		let pending = null;
+
+		text.split('\n').forEach((raw, index) => {
+			const words = (raw.match(/"(?:[^"\\]|\\.)*"|`[^`]*`|[^\s"`]+/g) || [])
+				.filter((word, position, all) => {
+					// Comments start at a word
+					return !_.some(all.slice(0, position + 1), (item) => item.indexOf('#') === 0);
+				})
+				.map((word) => /^["`]/.test(word) ? word.slice(1, -1).replace(/\\"/g, '"') : word);
+
+			words.forEach((word) => {
+				if (word === '{') {
+					const entry = pending || {words: [], line: index + 1, file: file};
+					entry.block = [];
+					if (!pending) {
+						_.last(stack).push(entry);
+					}
+					stack.push(entry.block);
+					pending = null;
+				} else if (word === '}') {
+					if (stack.length === 1) {
+						throw new error.ValidationError(file + ':' + (index + 1) + ' has a } without a {');
+					}
+					stack.pop();
+					pending = null;
+				} else {
+					if (!pending) {
+						pending = {words: [], line: index + 1, file: file};
+						_.last(stack).push(pending);
+					}
+					pending.words.push(word);
+				}
+			});
+
+			pending = null;
+		});
+
+		if (stack.length > 1) {
+			throw new error.ValidationError(file + ' ends before its last block does');
+		}
+
+		return stack[0];
+	},
+
+	/**
+	 * Replaces the imports of snippets with their lines
+	 *
+	 * @param   {Array}   entries
+	 * @param   {Object}  snippets
+	 * @param   {Number}  [depth]
+	 * @returns {Array}
+	 */
+	expand: (entries, snippets, depth = 0) => {
+		return _.flatMap(entries, (entry) => {
+			if (entry.words[0] === 'import' && snippets[entry.words[1]] && depth < 10) {
+				return internalCaddyImport.expand(snippets[entry.words[1]], snippets, depth + 1);
+			}
+			if (entry.block) {
+				return [_.assign({}, entry, {block: internalCaddyImport.expand(entry.block, snippets, depth)})];
+			}
+			return [entry];
+		});
+	},
+
+	/**
+	 * @param   {Array}  entries  of the whole file
+	 * @returns {Object} {options, snippets, sites}
+	 */
+	collect: (entries) => {
+		let found = {options: [], snippets: {}, sites: []};
+
+		entries.forEach((entry, index) => {
+			if (!entry.words.length && entry.block && index === 0) {
+				found.options = entry.block;
+			} else if (entry.block && /^\(.+\)$/.test(entry.words[0])) {
+				found.snippets[entry.words[0].slice(1, -1)] = entry.block;
+			} else if (entry.block) {
+				found.sites.push(entry);
+			}
+		});
+
+		// A Caddyfile with one site doesn't need braces around it
+		const loose = entries.filter((entry, index) => !entry.block && !(index === 0 && !entry.words.length));
+		if (!found.sites.length && loose.length) {
+			found.sites.push(_.assign({}, loose[0], {block: loose.slice(1)}));
+		}
+
+		return found;
+	},
+
+	/**
+	 * @param   {String}  address  ie: https://example.com:443
+	 * @returns {Object}  {scheme, host, port}
+	 */
+	parseAddress: (address) => {
+		const match = address.replace(/,$/, '').match(/^(?:(https?):\/\/)?([^:/]*)(?::(\d+))?(\/.*)?$/i);
+		if (!match) {
+			return null;
+		}
+
+		return {
+			scheme: match[1] ? match[1].toLowerCase() : null,
+			host:   match[2].toLowerCase(),
+			port:   match[3] ? parseInt(match[3], 10) : null,
+			path:   match[4] || null
+		};
+	},
+
+	/**
+	 * @param   {String}  upstream  ie: localhost:8080, https://10.0.0.5
+	 * @returns {Object}  {forward_scheme, forward_host, forward_port}, or null when it isn't a plain address
+	 */
+	parseUpstream: (upstream) => {
+		const match = upstream.match(UPSTREAM);
+		if (!match || upstream.indexOf('{') !== -1) {
+			return null;
+		}
+
+		const scheme = match[1] && match[1].toLowerCase() === 'https' ? 'https' : 'http';
+		const port   = match[3] ? parseInt(match[3], 10) : (scheme === 'https' ? 443 : 80);
+
+		if (port < 1 || port > 65535) {
+			return null;
+		}
+
+		return {
+			forward_scheme: scheme,
+			forward_host:   (match[2] || 'localhost').replace(/^\[|\]$/g, ''),
+			forward_port:   port
+		};
+	},
+
+	/**
+	 * @param   {String}  matcher  ie: /api/*
+	 * @returns {String}  the nginx location, null for all paths and undefined when it's more than a path prefix
+	 */
+	getPath: (matcher) => {
+		if (!matcher || matcher === '*' || matcher === '/*' || matcher === '/') {
+			return null;
+		}
+		if (/^\/[^*@{}]*\*?$/.test(matcher)) {
+			return matcher.replace(/\*$/, '');
+		}
+		return undefined;
+	},
+
+	/**
+	 * @param   {Object}  entry  a reverse_proxy line
+	 * @returns {Object}  {path, forward, upstreams, unsupported}
+	 */
+	parseReverseProxy: (entry) => {
+		let args    = entry.words.slice(1);
+		let matcher = null;
+
+		if (args.length && /^[/*@]/.test(args[0])) {
+			matcher = args.shift();
+		}
+
+		let upstreams   = args.slice();
+		let unsupported = [];
+		let tls         = false;
+
+		(entry.block || []).forEach((child) => {
+			if (child.words[0] === 'to') {
+				upstreams = upstreams.concat(child.words.slice(1));
+			} else if (child.words[0] === 'transport' && child.block && _.some(child.block, (item) => item.words[0] === 'tls')) {
+				tls = true;
+			} else {
+				unsupported.push(child);
+			}
+		});
+
+		let forward = upstreams.length ? internalCaddyImport.parseUpstream(upstreams[0]) : null;
+		if (forward && tls && forward.forward_scheme === 'http') {
+			forward.forward_scheme = 'https';
+			if (!/:\d+$/.test(upstreams[0])) {
+				forward.forward_port = 443;
+			}
+		}
+
+		return {
+			path:        internalCaddyImport.getPath(matcher),
+			forward:     forward,
+			upstreams:   upstreams,
+			unsupported: unsupported
+		};
+	},
+
+	/**
+	 * Translates the header directive into nginx, where it can be
+	 *
+	 * @param   {Array}   args
+	 * @param   {Object}  hsts   set when it's the HSTS header
+	 * @returns {String}  null when it can't be translated
+	 */
+	translateHeader: (args, hsts) => {
+		if (!args.length || /^[/*@]/.test(args[0])) {
+			return null;
+		}
+
+		const name = args[0].replace(/^[+>]/, '');
+
+		if (name.indexOf('-') === 0 && args.length === 1) {
+			return 'proxy_hide_header ' + name.substring(1) + ';';
+		}
+
+		if (args.length !== 2 || args[1].indexOf('{') !== -1) {
+			return null;
+		}
+
+		if (name.toLowerCase() === 'strict-transport-security') {
+			hsts.enabled    = true;
+			hsts.subdomains = /includesubdomains/i.test(args[1]);
+			return '';
+		}
+
+		return 'add_header ' + name + ' "' + args[1].replace(/"/g, '\\"') + '" always;';
+	},
+
+	/**
+	 * Converts a site block into a proxy host, with what couldn't be converted reported
+	 *
+	 * @param   {Object}  site
+	 * @param   {Object}  options  global options
+	 * @returns {Object}
+	 */
+	convertSite: (site, options) => {
+		const addresses    = _.compact(_.flatMap(site.words, (word) => word.split(',')).map(internalCaddyImport.parseAddress));
+		const domain_names = _.uniq(addresses.filter((address) => address.host && !net.isIP(address.host)).map((address) => address.host));
+		const auto_https   = !_.some(options, (option) => option.words[0] === 'auto_https' && option.words[1] === 'off');
+
+		let result = {
+			file:         site.file,
+			line:         site.line,
+			domain_names: domain_names,
+			https:        false,
+			host:         null,
+			skipped:      null,
+			unconverted:  [],
+			notes:        [],
+			created_id:   null,
+			error:        null
+		};
+
+		if (!domain_names.length) {
+			result.skipped = 'It has no domain name a host can use';
+			return result;
+		}
+
+		let main      = null;
+		let locations = [];
+		let advanced  = [];
+		let hsts      = {enabled: false, subdomains: false};
+
+		const report = (entry, placed_in) => {
+			result.unconverted.push({
+				directive: entry.words.join(' ') + (entry.block ? ' { ... }' : ''),
+				line:      entry.line,
+				placed_in: placed_in
+			});
+		};
+
+		const addProxy = (entry, path, strip) => {
+			const proxy = internalCaddyImport.parseReverseProxy(entry);
+			proxy.unsupported.forEach((child) => report(child, null));
+
+			if (!proxy.forward) {
+				report(entry, null);
+				return;
+			}
+			if (proxy.upstreams.length > 1) {
+				result.notes.push('Only the first upstream of the reverse_proxy at line ' + entry.line + ' is used');
+			}
+
+			const location_path = typeof path !== 'undefined' && path !== null ? path : proxy.path;
+
+			if (typeof location_path === 'undefined' || (path && proxy.path)) {
+				report(entry, null);
+			} else if (location_path === null) {
+				main = main || proxy.forward;
+			} else {
+				locations.push({
+					path:           location_path,
+					forward_scheme: proxy.forward.forward_scheme,
+					forward_host:   proxy.forward.forward_host,
+					forward_port:   proxy.forward.forward_port,
+					forward_path:   strip ? '/' : ''
+				});
+			}
+		};
+
+		site.block.forEach((entry) => {
+			const name = entry.words[0];
+
+			if (name === 'reverse_proxy') {
+				addProxy(entry);
+			} else if ((name === 'handle' || name === 'handle_path' || name === 'route') && entry.block) {
+				const path    = internalCaddyImport.getPath(entry.words[1]);
+				const proxies = entry.block.filter((child) => child.words[0] === 'reverse_proxy');
+
+				if (typeof path === 'undefined' || proxies.length !== 1 || entry.block.length !== 1) {
+					report(entry, null);
+				} else {
+					addProxy(proxies[0], path, name === 'handle_path');
+				}
+			} else if (name === 'encode' && (entry.words.length === 1 || entry.words.indexOf('gzip') !== -1)) {
+				advanced.push('gzip on;');
+				report(entry, 'advanced_config');
+			} else if (name === 'header') {
+				const lines = entry.block ? entry.block.map((child) => internalCaddyImport.translateHeader(child.words, hsts)) : [internalCaddyImport.translateHeader(entry.words.slice(1), hsts)];
+
+				if (_.some(lines, (line) => line === null) || (entry.block && entry.words.length > 1)) {
+					report(entry, null);
+				} else {
+					advanced = advanced.concat(_.compact(lines));
+					if (_.compact(lines).length) {
+						report(entry, 'advanced_config');
+					}
+				}
+			} else if (name === 'request_body' && entry.block && entry.block.length === 1 && entry.block[0].words[0] === 'max_size' && SIZE.test(entry.block[0].words[1] || '')) {
+				const size = entry.block[0].words[1].match(SIZE);
+				advanced.push('client_max_body_size ' + size[1] + size[2].toLowerCase() + ';');
+				report(entry, 'advanced_config');
+			} else if (name === 'tls') {
+				if (entry.words[1] === 'internal') {
+					result.notes.push('It used a certificate of the internal CA of Caddy, choose one from the internal CA of NPM');
+				}
+				report(entry, null);
+			} else if (name !== 'log') {
+				report(entry, null);
+			}
+		});
+
+		if (!main) {
+			result.skipped = locations.length ? 'It has no reverse_proxy for all paths' : 'It has no reverse_proxy';
+			return result;
+		}
+
+		if (main.forward_host === 'localhost' || main.forward_host === '127.0.0.1') {
+			result.notes.push('It forwards to ' + main.forward_host + ', which is NPM itself now. Change it to where the app can be reached from NPM');
+		}
+
+		result.host = {
+			domain_names:            domain_names,
+			forward_scheme:          main.forward_scheme,
+			forward_host:            main.forward_host,
+			forward_port:            main.forward_port,
+			allow_websocket_upgrade: true,
+			block_exploits:          false,
+			locations:               locations,
+			advanced_config:         advanced.join('\n'),
+			meta:                    {imported_from: site.file + ':' + site.line}
+		};
+
+		// Caddy gets certificates for every name and redirects plain http to them, unless it's told not to
+		const https = _.some(addresses, (address) => address.host && address.scheme !== 'http' && (address.port === null || address.port === 443));
+		if (auto_https && https) {
+			result.https                = true;
+			result.host.ssl_forced      = !_.some(addresses, (address) => address.scheme === 'http');
+			result.host.hsts_enabled    = hsts.enabled;
+			result.host.hsts_subdomains = hsts.subdomains;
+		}
+
+		if (_.some(addresses, (address) => address.port && [80, 443].indexOf(address.port) === -1)) {
+			result.notes.push('It listened on a port other than 80 and 443, give the host custom ports to do so again');
+		}
+
+		return result;
+	},
+
+	/**
+	 * Converts the sites of Caddyfiles into proxy hosts
+	 *
+	 * @param   {Array}  files  [{file, text}]
+	 * @returns {Array}
+	 */
+	convert: (files) => {
+		return _.flatMap(files, (item) => {
+			const found   = internalCaddyImport.collect(internalCaddyImport.parse(item.text, item.file));
+			const email   = _.find(found.options, (option) => option.words[0] === 'email');
+
+			return found.sites.map((site) => {
+				const result = internalCaddyImport.convertSite(_.assign({}, site, {block: internalCaddyImport.expand(site.block, found.snippets)}), found.options);
+
+				// What Caddy requested its certificates with
+				if (email && email.words[1]) {
+					result.letsencrypt_email = email.words[1];
+				}
+
+				return result;
+			});
+		});
+	}
+};
+
+module.exports = internalCaddyImport;
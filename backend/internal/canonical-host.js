@@ -0,0 +1,77 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+const internalCanonicalHost = {
+
+	/**
+	 * Domains listed both with and without www, ie: ['example.com']
+	 *
+	 * @param   {Array}  domain_names
+	 * @returns {Array}
+	 */
+	getApexDomains: (domain_names) => {
+		return domain_names.filter((domain_name) => {
+			return domain_name.indexOf('*') === -1 && domain_name.indexOf('www.') !== 0 && domain_names.indexOf('www.' + domain_name) !== -1;
+		});
+	},
+
+	/**
+	 * A host can only redirect between www and apex when it lists both
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing host
+	 * @returns {Promise}
+	 */
+	validate: (data, row) => {
+		const host = _.assign({}, row || {}, data);
+
+		if (!host.canonical_host || !host.domain_names) {
+			return Promise.resolve();
+		}
+
+		if (!internalCanonicalHost.getApexDomains(host.domain_names).length) {
+			return Promise.reject(new error.ValidationError('Redirecting between www and apex needs a domain name listed both with and without www, ie: example.com and www.example.com'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Splits the domain names of a host into the ones it serves and the ones redirected to them.
+	 * Needs the ports of the host to be set first.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}  ie: {domain_names: ['example.com'], redirects: [{from: ['www.example.com'], to: 'https://example.com'}]}
+	 */
+	getRedirects: (host) => {
+		if (!host.canonical_host || !host.domain_names) {
+			return null;
+		}
+
+		const apex_domains = internalCanonicalHost.getApexDomains(host.domain_names);
+		if (!apex_domains.length) {
+			return null;
+		}
+
+		let base = '';
+		if (host.certificate && host.certificate_id > 0) {
+			base = 'https://%s' + (host.https_redirect_port ? ':' + host.https_redirect_port : '');
+		} else {
+			base = 'http://%s' + (host.http_ports.indexOf(80) === -1 ? ':' + host.http_ports[0] : '');
+		}
+
+		let redirects = apex_domains.map((domain_name) => {
+			const from = host.canonical_host === 'www' ? domain_name : 'www.' + domain_name;
+			const to   = host.canonical_host === 'www' ? 'www.' + domain_name : domain_name;
+
+			return {from: [from], to: base.replace('%s', to)};
+		});
+
+		return {
+			domain_names: _.difference(host.domain_names, _.flatMap(redirects, 'from')),
+			redirects:    redirects
+		};
+	}
+};
+
+module.exports = internalCanonicalHost;
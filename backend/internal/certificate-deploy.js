@@ -0,0 +1,339 @@
+const _                = require('lodash');
+const fs               = require('fs');
+const https            = require('https');
+const path             = require('path');
+const config           = require('../lib/config');
+const logger           = require('../logger').ssl;
+const error            = require('../lib/error');
+const utils            = require('../lib/utils');
+const validator        = require('../lib/validator');
+const targets          = require('../global/certificate-deploy-targets.json');
+const internalActivity = require('./activity');
+const internalStorage  = require('./certificate-storage');
+
+// Scripts have to be put here by someone with access to the data volume, they can't be sent over the API
+const SCRIPTS_DIR = config.getPath('deploy_hooks');
+
+// Key and known hosts used to copy certificates with scp
+const SSH_DIR = config.getPath('ssh');
+
+// Mounted into the container to write certificates to another service
+const MOUNT_DIRS = ['/mnt/', '/media/'];
+
+const HTTP_TIMEOUT = 30000;
+
+const internalCertificateDeploy = {
+
+	/**
+	 * @param   {Object}  certificate
+	 * @returns {Object}  ie: {fullchain: '/etc/letsencrypt/live/npm-1/fullchain.pem', privkey: '...'}
+	 */
+	getFiles: (certificate) => {
+		const dir = internalStorage.getDirectory(certificate);
+
+		return {
+			fullchain: dir + '/fullchain.pem',
+			privkey:   dir + '/privkey.pem'
+		};
+	},
+
+	/**
+	 * The targets of the registry without how they're deployed to, for the API
+	 *
+	 * @returns {Object}
+	 */
+	getTargets: () => {
+		return _.mapValues(targets, (target) => {
+			return _.pick(target, ['name', 'description', 'options', 'secrets']);
+		});
+	},
+
+	/**
+	 * Scripts have to exist, paths have to be on a mount and the options of a target have to
+	 * match its schema in the registry
+	 *
+	 * @param   {Array}  hooks  payload
+	 * @returns {Promise}
+	 */
+	validate: (hooks) => {
+		let sequence = Promise.resolve();
+
+		(hooks || []).forEach((hook, index) => {
+			const prefix = 'Deploy hook ' + (index + 1) + ': ';
+
+			sequence = sequence.then(() => {
+				if (hook.type === 'script' && !fs.existsSync(SCRIPTS_DIR + '/' + hook.script)) {
+					throw new error.ValidationError(prefix + 'there\'s no script called ' + hook.script + ' in ' + SCRIPTS_DIR);
+				}
+
+				if (hook.type === 'path') {
+					const target = path.posix.normalize(hook.path).replace(/\/$/, '');
+					if (target !== hook.path.replace(/\/$/, '') || !MOUNT_DIRS.some((dir) => target.indexOf(dir) === 0)) {
+						throw new error.ValidationError(prefix + 'the path has to be a directory below ' + MOUNT_DIRS.join(' or '));
+					}
+				}
+
+				if (hook.type === 'target') {
+					if (typeof targets[hook.target] === 'undefined') {
+						throw new error.ValidationError(prefix + 'there\'s no target called ' + hook.target + ', it can be one of ' + Object.keys(targets).join(', '));
+					}
+
+					return validator(targets[hook.target].options, hook.options || {})
+						.catch((err) => {
+							throw new error.ValidationError(prefix + 'the options for ' + targets[hook.target].name + ' are invalid: ' + err.message);
+						});
+				}
+			});
+		});
+
+		return sequence;
+	},
+
+	/**
+	 * @param   {Object}  hook
+	 * @returns {String}  what the hook deploys to, for the logs
+	 */
+	getTarget: (hook) => {
+		switch (hook.type) {
+		case 'script':
+			return SCRIPTS_DIR + '/' + hook.script;
+		case 'scp':
+			return hook.user + '@' + hook.host + ':' + hook.path;
+		case 'webhook':
+			return hook.url.replace(/\?.*$/, '');
+		case 'target':
+			return (targets[hook.target] ? targets[hook.target].name : hook.target) + ' ' + ((hook.options && hook.options.host) || '');
+		}
+		return hook.path;
+	},
+
+	/**
+	 * Fills in the {{name}} placeholders of a registry template. In a command the values are
+	 * quoted for the shell, unless the placeholder is {{{name}}}.
+	 *
+	 * @param   {*}        template  string, or an object or array of them
+	 * @param   {Object}   values
+	 * @param   {Boolean}  [shell]
+	 * @returns {*}
+	 */
+	render: (template, values, shell) => {
+		if (_.isArray(template) || _.isPlainObject(template)) {
+			return (_.isArray(template) ? _.map : _.mapValues)(template, (item) => internalCertificateDeploy.render(item, values, shell));
+		}
+
+		if (typeof template !== 'string') {
+			return template;
+		}
+
+		return template.replace(/\{\{\{(\w+)\}\}\}|\{\{(\w+)\}\}/g, (match, raw, name) => {
+			const value = values[raw || name];
+			if (typeof value === 'undefined' || value === null) {
+				return '';
+			}
+			return shell && !raw ? '\'' + String(value).replace(/'/g, '\'\\\'\'') + '\'' : String(value);
+		});
+	},
+
+	/**
+	 * @param   {String}  flag  -P for scp, -p for ssh
+	 * @param   {Number}  port
+	 * @returns {Array}
+	 */
+	getSshArgs: (flag, port) => {
+		return [
+			'-o', 'BatchMode=yes',
+			'-o', 'StrictHostKeyChecking=accept-new',
+			'-o', 'UserKnownHostsFile=' + SSH_DIR + '/known_hosts',
+			'-i', SSH_DIR + '/id_ed25519',
+			flag, String(port || 22)
+		];
+	},
+
+	/**
+	 * Copies files to a host with scp, then runs a command there
+	 *
+	 * @param   {Object}  server   ie: {host: 'nas.lan', port: 22, user: 'certs'}
+	 * @param   {Array}   copies   ie: [['/etc/letsencrypt/live/npm-1/fullchain.pem', '/volume1/certs/fullchain.pem']]
+	 * @param   {String}  [command]
+	 * @returns {Promise}
+	 */
+	runSsh: (server, copies, command) => {
+		const destination = server.user + '@' + server.host;
+
+		let sequence = Promise.resolve();
+		copies.forEach(([local, remote]) => {
+			sequence = sequence.then(() => {
+				return utils.execFile('scp', internalCertificateDeploy.getSshArgs('-P', server.port).concat([local, destination + ':' + remote]));
+			});
+		});
+
+		if (command) {
+			sequence = sequence.then(() => {
+				return utils.execFile('ssh', internalCertificateDeploy.getSshArgs('-p', server.port).concat([destination, command]));
+			});
+		}
+
+		return sequence;
+	},
+
+	/**
+	 * @param   {String}  url
+	 * @param   {Object}  request
+	 * @param   {String}  [request.method]
+	 * @param   {Object}  [request.headers]
+	 * @param   {Array}   [request.basic_auth]  user and password
+	 * @param   {Object}  [request.body]        sent as JSON
+	 * @param   {Boolean} [request.verify_tls]
+	 * @returns {Promise}
+	 */
+	runHttp: (url, request) => {
+		return new Promise((resolve, reject) => {
+			const body = JSON.stringify(request.body || {});
+			const req  = https.request(url, {
+				method:             request.method || 'POST',
+				timeout:            HTTP_TIMEOUT,
+				auth:               request.basic_auth ? request.basic_auth.join(':') : undefined,
+				rejectUnauthorized: request.verify_tls !== false,
+				headers:            _.assign({
+					'Content-Type':   'application/json',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager'
+				}, request.headers || {})
+			}, (res) => {
+				res.resume();
+				if (res.statusCode < 200 || res.statusCode >= 300) {
+					reject(new Error(url.replace(/\?.*$/, '') + ' answered ' + res.statusCode));
+					return;
+				}
+				resolve();
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('There was no answer within ' + (HTTP_TIMEOUT / 1000) + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	/**
+	 * Deploys to a target of the registry
+	 *
+	 * @param   {Object}  certificate
+	 * @param   {Object}  hook
+	 * @param   {Object}  files
+	 * @returns {Promise}
+	 */
+	runTarget: (certificate, hook, files) => {
+		const target = targets[hook.target];
+		if (typeof target === 'undefined') {
+			return Promise.reject(new Error('There\'s no target called ' + hook.target));
+		}
+
+		const action = target.action;
+		let values   = _.assign(_.mapValues(target.options.properties, 'default'), hook.options || {}, {
+			certificate_id: certificate.id,
+			domain:         certificate.domain_names[0]
+		});
+
+		if (action.type === 'ssh') {
+			const copies = ['fullchain', 'privkey'].map((file) => {
+				return [files[file], internalCertificateDeploy.render(action.files[file], values)];
+			});
+
+			return internalCertificateDeploy.runSsh(values, copies, internalCertificateDeploy.render(action.command, values, true));
+		}
+
+		values.fullchain = fs.readFileSync(files.fullchain, {encoding: 'utf8'});
+		values.privkey   = fs.readFileSync(files.privkey, {encoding: 'utf8'});
+
+		return internalCertificateDeploy.runHttp(internalCertificateDeploy.render(action.url, values), {
+			method:     action.method,
+			headers:    internalCertificateDeploy.render(action.headers, values),
+			basic_auth: internalCertificateDeploy.render(action.basic_auth, values),
+			body:       internalCertificateDeploy.render(action.body, values),
+			verify_tls: values.verify_tls
+		});
+	},
+
+	/**
+	 * @param   {Object}  certificate
+	 * @param   {Object}  hook
+	 * @param   {Object}  files
+	 * @returns {Promise}
+	 */
+	runHook: (certificate, hook, files) => {
+		switch (hook.type) {
+		case 'script':
+			return utils.execFile(SCRIPTS_DIR + '/' + hook.script, [String(certificate.id), files.fullchain, files.privkey, certificate.domain_names.join(',')]);
+
+		case 'scp':
+			return internalCertificateDeploy.runSsh(hook, [
+				[files.fullchain, path.posix.join(hook.path, 'fullchain.pem')],
+				[files.privkey, path.posix.join(hook.path, 'privkey.pem')]
+			]);
+
+		case 'webhook':
+			return internalCertificateDeploy.runHttp(hook.url, {
+				body: {
+					certificate_id: certificate.id,
+					domain_names:   certificate.domain_names,
+					expires_on:     certificate.expires_on,
+					fullchain:      fs.readFileSync(files.fullchain, {encoding: 'utf8'}),
+					privkey:        fs.readFileSync(files.privkey, {encoding: 'utf8'})
+				}
+			});
+
+		case 'target':
+			return internalCertificateDeploy.runTarget(certificate, hook, files);
+		}
+
+		// Copied like this so a service reading the files never sees half of one
+		fs.mkdirSync(hook.path, {recursive: true});
+		[['fullchain.pem', files.fullchain, 0o644], ['privkey.pem', files.privkey, 0o600]].forEach(([name, source, mode]) => {
+			const target = path.posix.join(hook.path, name);
+			fs.copyFileSync(source, target + '.tmp');
+			fs.chmodSync(target + '.tmp', mode);
+			fs.renameSync(target + '.tmp', target);
+		});
+		return Promise.resolve();
+	},
+
+	/**
+	 * Runs the deploy hooks of a certificate one after the other. One failing doesn't stop the others.
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Promise} resolves with the result of each hook, ie: [{type: 'scp', target: '...', success: false, error: '...'}]
+	 */
+	run: (certificate) => {
+		const files = internalCertificateDeploy.getFiles(certificate);
+
+		let results  = [];
+		let sequence = Promise.resolve();
+
+		(certificate.deploy_hooks || []).forEach((hook) => {
+			sequence = sequence.then(() => {
+				const target = internalCertificateDeploy.getTarget(hook);
+
+				return Promise.resolve()
+					.then(() => {
+						return internalCertificateDeploy.runHook(certificate, hook, files);
+					})
+					.then(() => {
+						logger.success('Deployed Cert #' + certificate.id + ' with ' + hook.type + ' to ' + target);
+						results.push({type: hook.type, target: target, success: true});
+						return internalActivity.record('certificate', certificate.id, 'deploy', hook.type, true, target);
+					})
+					.catch((err) => {
+						logger.error('Deploying Cert #' + certificate.id + ' with ' + hook.type + ' to ' + target + ' failed: ' + err.message);
+						results.push({type: hook.type, target: target, success: false, error: err.message});
+						return internalActivity.record('certificate', certificate.id, 'deploy', hook.type, false, target + ': ' + err.message);
+					});
+			});
+		});
+
+		return sequence.then(() => results);
+	}
+};
+
+module.exports = internalCertificateDeploy;
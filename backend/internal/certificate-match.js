@@ -0,0 +1,114 @@
+const _                   = require('lodash');
+const moment              = require('moment');
+const error               = require('../lib/error');
+const internalCertificate = require('./certificate');
+
+const internalCertificateMatch = {
+
+	/**
+	 * @param   {String}  name         of the certificate, ie: *.example.com
+	 * @param   {String}  domain_name  of the host
+	 * @returns {String|null}  exact or wildcard, null when the name doesn't cover the domain
+	 */
+	getMatch: (name, domain_name) => {
+		name        = name.toLowerCase();
+		domain_name = domain_name.toLowerCase();
+
+		if (name === domain_name) {
+			return 'exact';
+		}
+
+		// A wildcard covers one label, not the domain itself or anything deeper
+		if (name.indexOf('*.') === 0 && domain_name.indexOf('*.') !== 0) {
+			const dot = domain_name.indexOf('.');
+			if (dot > 0 && domain_name.substring(dot + 1) === name.substring(2)) {
+				return 'wildcard';
+			}
+		}
+
+		return null;
+	},
+
+	/**
+	 * @param   {Object}  certificate
+	 * @param   {Array}   domain_names
+	 * @returns {String|null}  exact when it has every domain name, wildcard when some are only covered by a wildcard
+	 */
+	getCoverage: (certificate, domain_names) => {
+		const matches = domain_names.map((domain_name) => {
+			const found = _.compact(certificate.domain_names.map((name) => internalCertificateMatch.getMatch(name, domain_name)));
+			return found.indexOf('exact') !== -1 ? 'exact' : (found[0] || null);
+		});
+
+		if (matches.indexOf(null) !== -1) {
+			return null;
+		}
+		return matches.indexOf('wildcard') === -1 ? 'exact' : 'wildcard';
+	},
+
+	/**
+	 * The certificate the user can see that covers all the domain names and hasn't expired.
+	 * One with the names themselves goes before a wildcard, then the one that expires last.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Array}   domain_names
+	 * @returns {Promise}  resolves with {certificate, match}, or null when none covers them
+	 */
+	find: (access, domain_names) => {
+		return internalCertificate.getAll(access)
+			.then((certificates) => {
+				const now   = moment();
+				const found = certificates
+					.filter((certificate) => certificate.expires_on && moment(certificate.expires_on).isAfter(now))
+					.map((certificate) => {
+						return {
+							certificate: certificate,
+							match:       internalCertificateMatch.getCoverage(certificate, domain_names)
+						};
+					})
+					.filter((item) => item.match !== null);
+
+				if (!found.length) {
+					return null;
+				}
+
+				return _.orderBy(found, [(item) => item.match === 'exact' ? 0 : 1, (item) => moment(item.certificate.expires_on).valueOf()], ['asc', 'desc'])[0];
+			});
+	},
+
+	/**
+	 * Replaces a certificate_id of auto in the payload of a host with the certificate that covers its
+	 * domain names, or with new to request one, and keeps which it was in the meta of the host
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data          payload of the host
+	 * @param   {Array}   domain_names
+	 * @param   {Object}  [meta]        of the host, when it's updated
+	 * @returns {Promise}
+	 */
+	apply: (access, data, domain_names, meta) => {
+		if (data.certificate_id !== 'auto') {
+			return Promise.resolve();
+		}
+
+		return internalCertificateMatch.find(access, domain_names)
+			.then((found) => {
+				const combined = _.assign({}, meta, data.meta);
+
+				if (!found && !combined.letsencrypt_email) {
+					throw new error.ValidationError('No certificate covers ' + domain_names.join(', ') + ', and requesting one needs a letsencrypt_email in the meta');
+				}
+
+				if (!found) {
+					combined.letsencrypt_agree = true;
+				}
+
+				combined.certificate_match = found ? found.match : 'new';
+
+				data.certificate_id = found ? found.certificate.id : 'new';
+				data.meta           = combined;
+			});
+	}
+};
+
+module.exports = internalCertificateMatch;
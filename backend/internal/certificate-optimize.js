@@ -0,0 +1,218 @@
+const _                    = require('lodash');
+const error                = require('../lib/error');
+const helpers              = require('../lib/helpers');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const internalCertificate  = require('./certificate');
+
+/**
+ * The hosts that can use a certificate. Required when used, as these modules require the certificate one.
+ */
+const HOST_TYPES = {
+	'proxy-host':       {internal: () => require('./proxy-host'), model: proxyHostModel},
+	'redirection-host': {internal: () => require('./redirection-host'), model: redirectionHostModel},
+	'dead-host':        {internal: () => require('./dead-host'), model: deadHostModel}
+};
+
+// Fewer certificates than this aren't worth a new wildcard for
+const MIN_CERTIFICATES = 3;
+
+// What the new wildcard certificate takes from the one it got its DNS challenge from
+const DNS_META = ['dns_challenge', 'dns_provider', 'dns_provider_credentials', 'propagation_seconds', 'letsencrypt_email', 'letsencrypt_agree', 'acme_account_id', 'preferred_chain'];
+
+const internalCertificateOptimize = {
+
+	/**
+	 * The domain a wildcard of the certificate would be for, ie: example.com for [a.example.com, b.example.com].
+	 * A wildcard only covers one label, and is never for the public suffix itself.
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {String}  null when no wildcard covers all of its names
+	 */
+	getParent: (certificate) => {
+		const names = certificate.domain_names.map((name) => name.toLowerCase());
+
+		if (!names.length || _.some(names, (name) => name.indexOf('*') !== -1)) {
+			return null;
+		}
+
+		const candidates = _.uniq(_.flatMap(names, (name) => [name, name.split('.').slice(1).join('.')]))
+			.filter((candidate) => {
+				return _.every(names, (name) => {
+					const registered = helpers.getRegisteredDomain(name);
+					return (candidate === registered || _.endsWith(candidate, '.' + registered)) &&
+						(name === candidate || name.split('.').slice(1).join('.') === candidate);
+				});
+			});
+
+		return _.minBy(candidates, (candidate) => candidate.split('.').length) || null;
+	},
+
+	/**
+	 * Groups the Let's Encrypt certificates that one wildcard could replace, and finds the wildcards that already do
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getSuggestions: (access) => {
+		return Promise.all([
+			internalCertificate.getAll(access),
+			Promise.all(_.map(HOST_TYPES, (type, object_type) => {
+				return type.internal().getAll(access)
+					.then((rows) => rows.map((row) => _.assign({object_type: object_type}, row)))
+					.catch((err) => {
+						if (!(err instanceof error.PermissionError)) {
+							throw err;
+						}
+						return [];
+					});
+			}))
+				.then(_.flatten)
+		])
+			.then(([certificates, hosts]) => {
+				const issued    = certificates.filter((certificate) => certificate.provider === 'letsencrypt' && !certificate.meta.use_staging);
+				const wildcards = issued.filter((certificate) => _.some(certificate.domain_names, (name) => name.indexOf('*.') === 0));
+
+				const groups = _.groupBy(issued.filter((certificate) => internalCertificateOptimize.getParent(certificate)), internalCertificateOptimize.getParent);
+
+				return _.orderBy(_.compact(_.map(groups, (group, parent) => {
+					const with_apex    = _.some(group, (certificate) => certificate.domain_names.indexOf(parent) !== -1);
+					const domain_names = with_apex ? ['*.' + parent, parent] : ['*.' + parent];
+					const existing     = _.find(wildcards, (wildcard) => _.difference(domain_names, wildcard.domain_names).length === 0);
+
+					if (!existing && group.length < MIN_CERTIFICATES) {
+						return null;
+					}
+
+					const dns_certificate = _.find(group, (certificate) => certificate.meta.dns_challenge);
+					const replaces        = _.map(group, 'id');
+
+					return {
+						domain:             parent,
+						action:             existing ? 'reuse' : 'replace',
+						domain_names:       existing ? existing.domain_names : domain_names,
+						certificate_id:     existing ? existing.id : null,
+						dns_certificate_id: !existing && dns_certificate ? dns_certificate.id : null,
+						executable:         !!existing || !!dns_certificate,
+						renewals_saved:     existing ? group.length : group.length - 1,
+						replaces:           group.map((certificate) => _.pick(certificate, ['id', 'nice_name', 'domain_names'])),
+						hosts:              hosts.filter((host) => replaces.indexOf(host.certificate_id) !== -1).map((host) => {
+							return {
+								object_type:  host.object_type,
+								object_id:    host.id,
+								domain_names: host.domain_names
+							};
+						})
+					};
+				})), ['renewals_saved', 'domain'], ['desc', 'asc']);
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {String}  domain
+	 * @returns {Promise}  resolves with the suggestion for the domain, if it can be carried out
+	 */
+	getSuggestion: (access, domain) => {
+		return internalCertificateOptimize.getSuggestions(access)
+			.then((suggestions) => {
+				const suggestion = _.find(suggestions, {domain: domain.toLowerCase()});
+
+				if (!suggestion) {
+					throw new error.ItemNotFoundError(domain);
+				}
+				if (!suggestion.executable) {
+					throw new error.ValidationError('A wildcard certificate needs the DNS challenge, and none of the certificates for ' + domain + ' use it');
+				}
+
+				return suggestion;
+			});
+	},
+
+	/**
+	 * Carries out a suggestion: requests the wildcard when there isn't one yet, moves the hosts
+	 * over to it and deletes the certificates nothing uses any more, if asked to.
+	 *
+	 * @param   {Access}    access
+	 * @param   {Object}    suggestion
+	 * @param   {Object}    data
+	 * @param   {Boolean}   [data.delete_unused]
+	 * @param   {Function}  [progress]  told the phase of issuing the certificate, see internal/jobs
+	 * @returns {Promise}   resolves with the certificate the hosts use now
+	 */
+	apply: (access, suggestion, data, progress = () => {}) => {
+		let certificate;
+
+		if (suggestion.certificate_id) {
+			certificate = internalCertificate.get(access, {id: suggestion.certificate_id});
+		} else {
+			certificate = internalCertificate.get(access, {id: suggestion.dns_certificate_id})
+				.then((source) => {
+					return internalCertificate.create(access, {
+						provider:     'letsencrypt',
+						nice_name:    suggestion.domain_names[0],
+						domain_names: suggestion.domain_names,
+						meta:         _.pick(source.meta, DNS_META)
+					}, progress);
+				});
+		}
+
+		return certificate
+			.then((certificate) => {
+				// One after the other, each one reloads nginx
+				let sequence = Promise.resolve();
+
+				suggestion.hosts.forEach((host) => {
+					sequence = sequence.then(() => {
+						return HOST_TYPES[host.object_type].internal().update(access, {
+							id:             host.object_id,
+							certificate_id: certificate.id
+						});
+					});
+				});
+
+				return sequence
+					.then(() => {
+						if (data.delete_unused) {
+							return internalCertificateOptimize.deleteUnused(access, _.map(suggestion.replaces, 'id'));
+						}
+					})
+					.then(() => {
+						return certificate;
+					});
+			});
+	},
+
+	/**
+	 * Deletes the certificates no host uses, including hosts this user can't see
+	 *
+	 * @param   {Access}  access
+	 * @param   {Array}   ids
+	 * @returns {Promise}
+	 */
+	deleteUnused: (access, ids) => {
+		let sequence = Promise.resolve();
+
+		ids.forEach((id) => {
+			sequence = sequence.then(() => {
+				return Promise.all(_.map(HOST_TYPES, (type) => {
+					return type.model
+						.query()
+						.where('certificate_id', id)
+						.andWhere('is_deleted', 0)
+						.first();
+				}))
+					.then((used) => {
+						if (!_.some(used)) {
+							return internalCertificate.delete(access, {id: id});
+						}
+					});
+			});
+		});
+
+		return sequence;
+	}
+};
+
+module.exports = internalCertificateOptimize;
@@ -0,0 +1,154 @@
+const fs                  = require('fs');
+const path                = require('path');
+const crypto              = require('crypto');
+const logger              = require('../logger').ssl;
+const config              = require('../lib/config');
+const error               = require('../lib/error');
+const lego                = require('../lib/lego');
+const internalCertStorage = require('./certificate-storage');
+
+const CERTIFICATE = /-----BEGIN CERTIFICATE-----[^-]+-----END CERTIFICATE-----/g;
+
+/**
+ * @param   {KeyObject}  key  public or private
+ * @returns {String}  base64 sha256 of the SubjectPublicKeyInfo, as pinned by apps
+ */
+const getPin = (key) => {
+	const spki = crypto.createPublicKey(key).export({type: 'spki', format: 'der'});
+	return crypto.createHash('sha256').update(spki).digest('base64');
+};
+
+const internalCertificatePins = {
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {String}  the key the next renewal is given, ie: /etc/letsencrypt/next-keys/npm-1.key
+	 */
+	getNextKeyFile: (certificate) => {
+		return config.getPath('letsencrypt') + '/next-keys/npm-' + certificate.id + '.key';
+	},
+
+	/**
+	 * Only lego can be told which key to renew with, certbot always makes a new one
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Boolean}
+	 */
+	canStage: (certificate) => {
+		return certificate.provider === 'letsencrypt' && !!certificate.meta && certificate.meta.acme_client === 'lego';
+	},
+
+	/**
+	 * Makes the key of the next renewal, unless there is one, with the key type lego uses
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {String}  the key file
+	 */
+	stage: (certificate) => {
+		const file = internalCertificatePins.getNextKeyFile(certificate);
+		if (fs.existsSync(file)) {
+			return file;
+		}
+
+		const keys = crypto.generateKeyPairSync('ec', {
+			namedCurve:         'secp384r1',
+			publicKeyEncoding:  {type: 'spki', format: 'pem'},
+			privateKeyEncoding: {type: 'sec1', format: 'pem'}
+		});
+
+		fs.mkdirSync(path.dirname(file), {recursive: true});
+		fs.writeFileSync(file, keys.privateKey, {mode: 0o600});
+		logger.info('Staged the next key of Certificate #' + certificate.id);
+		return file;
+	},
+
+	/**
+	 * Before a renewal, gives lego the staged key in place of the one it has, so the certificate it gets
+	 * matches the pin that was published
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Boolean}  whether lego has to be told to keep the key it has
+	 */
+	useNextKey: (certificate) => {
+		const file = internalCertificatePins.getNextKeyFile(certificate);
+		if (!internalCertificatePins.canStage(certificate) || !fs.existsSync(file)) {
+			return false;
+		}
+
+		fs.copyFileSync(file, lego.getCertificateFile(certificate) + '.key');
+		fs.chmodSync(lego.getCertificateFile(certificate) + '.key', 0o600);
+		return true;
+	},
+
+	/**
+	 * After a renewal with the staged key, stages the one after it straight away so its pin can be
+	 * published a whole certificate lifetime ahead
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 */
+	rotate: (certificate) => {
+		fs.rmSync(internalCertificatePins.getNextKeyFile(certificate), {force: true});
+		internalCertificatePins.stage(certificate);
+	},
+
+	/**
+	 * SPKI pins of the certificate, the certificates of its chain, and the key its next renewal will
+	 * have. Asking for them is what stages a next key, unless renewals keep the key.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	getPins: (access, data) => {
+		// Required here, as certificates require this module to renew
+		const internalCertificate = require('./certificate');
+
+		return internalCertificate.get(access, {id: data.id})
+			.then((certificate) => {
+				let pems = [];
+				try {
+					pems = fs.readFileSync(internalCertStorage.getDirectory(certificate) + '/fullchain.pem', {encoding: 'utf8'}).match(CERTIFICATE) || [];
+				} catch (err) {
+					// Without its files it has no pins to give
+				}
+
+				if (!pems.length) {
+					throw new error.ValidationError('Certificate #' + certificate.id + ' has no certificate files yet');
+				}
+
+				const chain = pems.map((pem) => {
+					const x509 = new crypto.X509Certificate(pem);
+					return {
+						subject:    x509.subject.replace(/\n/g, ', '),
+						expires_on: new Date(x509.validTo).toISOString(),
+						sha256:     getPin(x509.publicKey)
+					};
+				});
+
+				let next = null;
+				if (certificate.provider === 'letsencrypt' && certificate.meta && certificate.meta.reuse_key) {
+					// Renewals keep the key, so the next pin is the one there is now
+					next = {
+						sha256:    chain[0].sha256,
+						staged_on: null
+					};
+				} else if (internalCertificatePins.canStage(certificate)) {
+					const file = internalCertificatePins.stage(certificate);
+					next       = {
+						sha256:    getPin(fs.readFileSync(file, {encoding: 'utf8'})),
+						staged_on: fs.statSync(file).mtime.toISOString()
+					};
+				}
+
+				return {
+					certificate_id: certificate.id,
+					current:        chain[0],
+					chain:          chain.slice(1),
+					next:           next
+				};
+			});
+	}
+};
+
+module.exports = internalCertificatePins;
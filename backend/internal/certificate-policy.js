@@ -0,0 +1,85 @@
+const error        = require('../lib/error');
+const settingModel = require('../models/setting');
+
+// The key types whose size is checked, as openssl names them
+const RSA = ['rsaEncryption', 'rsassaPss'];
+const EC  = ['id-ecPublicKey'];
+
+const internalCertificatePolicy = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'certificate-policy')
+			.first();
+	},
+
+	/**
+	 * What's wrong with a certificate by the policy
+	 *
+	 * @param   {Object}  meta  of the setting
+	 * @param   {Object}  info  from getCertificateInfo
+	 * @param   {String}  name  ie: Certificate
+	 * @param   {Boolean} leaf  whether it's the certificate itself, rather than an intermediate
+	 * @returns {Array}
+	 */
+	getProblems: (meta, info, name, leaf) => {
+		let problems = [];
+
+		const algorithms = meta.signature_algorithms || [];
+		if (algorithms.length && algorithms.indexOf(info.signature_algorithm) === -1) {
+			problems.push(name + ' is signed with ' + (info.signature_algorithm || 'an unknown algorithm') + ', allowed are ' + algorithms.join(', '));
+		}
+
+		if (!leaf) {
+			return problems;
+		}
+
+		const key = info.public_key || {};
+		if (RSA.indexOf(key.algorithm) !== -1 && meta.min_rsa_bits && key.bits < meta.min_rsa_bits) {
+			problems.push(name + ' has a ' + key.bits + ' bit RSA key, at least ' + meta.min_rsa_bits + ' bits are needed');
+		}
+		if (EC.indexOf(key.algorithm) !== -1 && meta.min_ec_bits && key.bits < meta.min_ec_bits) {
+			problems.push(name + ' has a ' + key.bits + ' bit EC key, at least ' + meta.min_ec_bits + ' bits are needed');
+		}
+
+		const days = Math.round((info.dates.to - info.dates.from) / 86400);
+		if (meta.max_validity_days && days > meta.max_validity_days) {
+			problems.push(name + ' is valid for ' + days + ' days, at most ' + meta.max_validity_days + ' are allowed');
+		}
+
+		return problems;
+	},
+
+	/**
+	 * Rejects uploaded certificates the certificate-policy setting doesn't allow, with everything that's wrong with them
+	 *
+	 * @param   {Object}  files  what was validated, {certificate, certificate_key, intermediate_certificate}
+	 * @returns {Promise}
+	 */
+	check: (files) => {
+		return internalCertificatePolicy.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return;
+				}
+
+				let problems = [];
+				if (files.certificate) {
+					problems = problems.concat(internalCertificatePolicy.getProblems(setting.meta, files.certificate, 'The certificate', true));
+				}
+				if (files.intermediate_certificate) {
+					problems = problems.concat(internalCertificatePolicy.getProblems(setting.meta, files.intermediate_certificate, 'The intermediate certificate', false));
+				}
+
+				if (problems.length) {
+					throw new error.ValidationError('The certificate policy doesn\'t allow this certificate: ' + problems.join('; '));
+				}
+			});
+	}
+};
+
+module.exports = internalCertificatePolicy;
@@ -0,0 +1,201 @@
+const fs               = require('fs');
+const path             = require('path');
+const crypto           = require('crypto');
+const logger           = require('../logger').ssl;
+const config           = require('../lib/config');
+const s3               = require('../lib/s3');
+const certificateModel = require('../models/certificate');
+
+// What's kept of each certificate, the ones that aren't there are skipped
+const FILES = ['fullchain.pem', 'privkey.pem', 'cert.pem', 'chain.pem'];
+
+let client = null;
+
+/**
+ * @returns {Object|null}  the bucket, null when certificates are only kept on disk
+ */
+const getClient = () => {
+	const storage = config.getCertStorage();
+	if (storage === null) {
+		return null;
+	}
+	if (client === null) {
+		client = s3.createClient(storage);
+	}
+	return client;
+};
+
+/**
+ * @param   {Number}  certificate_id
+ * @returns {String}  ie: 'npm/certificates/npm-1/'
+ */
+const getPrefix = (certificate_id) => {
+	const prefix = config.getCertStorage().prefix;
+	return (prefix ? prefix + '/' : '') + 'certificates/' + (certificate_id ? 'npm-' + certificate_id + '/' : '');
+};
+
+/**
+ * @param   {Buffer}  data
+ * @returns {String}  the md5, which is the ETag S3 gives objects that weren't uploaded in parts
+ */
+const md5 = (data) => {
+	return crypto.createHash('md5').update(data).digest('hex');
+};
+
+const internalCertificateStorage = {
+
+	interval:           null,
+	intervalProcessing: false,
+
+	/**
+	 * Where the files of a certificate are, the one place every module should get it from
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {String}  ie: '/etc/letsencrypt/live/npm-1'
+	 */
+	getDirectory: (certificate) => {
+		return certificate.provider === 'letsencrypt'
+			? config.getPath('letsencrypt') + '/live/npm-' + certificate.id
+			: config.getPath('custom_ssl') + '/npm-' + certificate.id;
+	},
+
+	/**
+	 * Fetches certificates another node changed every CERT_STORAGE_SYNC_INTERVAL seconds
+	 */
+	initTimer: () => {
+		const storage = config.getCertStorage();
+		if (storage === null || !storage.sync_interval) {
+			return;
+		}
+
+		logger.info('Certificate Storage Timer initialized');
+		internalCertificateStorage.interval = setInterval(() => {
+			internalCertificateStorage.pull(true)
+				.catch((err) => {
+					logger.warn('Could not fetch certificates from the bucket: ' + err.message);
+				});
+		}, storage.sync_interval * 1000);
+	},
+
+	/**
+	 * Copies the files of a certificate to the bucket, after it was issued, renewed or uploaded.
+	 * It's still in use here when that fails, so that's only logged.
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}
+	 */
+	push: (certificate) => {
+		const bucket = getClient();
+		if (bucket === null) {
+			return Promise.resolve();
+		}
+
+		const dir = internalCertificateStorage.getDirectory(certificate);
+
+		return Promise.all(FILES.filter((file) => fs.existsSync(path.join(dir, file))).map((file) => {
+			return bucket.put(getPrefix(certificate.id) + file, fs.readFileSync(path.join(dir, file)));
+		}))
+			.then((written) => {
+				logger.info('Certificate #' + certificate.id + ' copied to the bucket, ' + written.length + ' files');
+			})
+			.catch((err) => {
+				logger.error('Could not copy certificate #' + certificate.id + ' to the bucket: ' + err.message);
+			});
+	},
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}
+	 */
+	remove: (certificate) => {
+		const bucket = getClient();
+		if (bucket === null) {
+			return Promise.resolve();
+		}
+
+		return bucket.list(getPrefix(certificate.id))
+			.then((objects) => {
+				return Promise.all(objects.map((object) => bucket.delete(object.key)));
+			})
+			.catch((err) => {
+				logger.error('Could not remove certificate #' + certificate.id + ' from the bucket: ' + err.message);
+			});
+	},
+
+	/**
+	 * Writes the certificates in the bucket that are missing or different here, ie: ones another node renewed
+	 *
+	 * @param   {Boolean}  [reload]  reload nginx when any changed
+	 * @returns {Promise}  resolves with the ids of the certificates that changed
+	 */
+	pull: (reload) => {
+		const bucket = getClient();
+		if (bucket === null || internalCertificateStorage.intervalProcessing) {
+			return Promise.resolve([]);
+		}
+
+		internalCertificateStorage.intervalProcessing = true;
+
+		let changed = [];
+
+		return Promise.all([
+			bucket.list(getPrefix()),
+			certificateModel
+				.query()
+				.where('is_deleted', 0)
+		])
+			.then(([objects, certificates]) => {
+				return certificates.reduce((promise, certificate) => {
+					const prefix  = getPrefix(certificate.id);
+					const dir     = internalCertificateStorage.getDirectory(certificate);
+					const waiting = objects.filter((object) => object.key.indexOf(prefix) === 0 && FILES.indexOf(object.key.substring(prefix.length)) !== -1)
+						.filter((object) => {
+							const file = path.join(dir, object.key.substring(prefix.length));
+							return !fs.existsSync(file) || md5(fs.readFileSync(file)) !== object.etag;
+						});
+
+					if (!waiting.length) {
+						return promise;
+					}
+
+					return promise
+						.then(() => {
+							return Promise.all(waiting.map((object) => bucket.get(object.key)));
+						})
+						.then((contents) => {
+							fs.mkdirSync(dir, {recursive: true});
+
+							let different = false;
+							waiting.forEach((object, index) => {
+								const file = path.join(dir, object.key.substring(prefix.length));
+								if (contents[index] === null || (fs.existsSync(file) && fs.readFileSync(file).equals(contents[index]))) {
+									return;
+								}
+								fs.writeFileSync(file, contents[index], {mode: file.endsWith('privkey.pem') ? 0o600 : 0o644});
+								different = true;
+							});
+
+							if (different) {
+								changed.push(certificate.id);
+								logger.info('Certificate #' + certificate.id + ' fetched from the bucket');
+							}
+						});
+				}, Promise.resolve());
+			})
+			.then(() => {
+				if (reload && changed.length) {
+					// Required here, nginx needs this module for the certificate paths
+					return require('./nginx').reload();
+				}
+			})
+			.then(() => {
+				internalCertificateStorage.intervalProcessing = false;
+				return changed;
+			}, (err) => {
+				internalCertificateStorage.intervalProcessing = false;
+				throw err;
+			});
+	}
+};
+
+module.exports = internalCertificateStorage;
@@ -0,0 +1,253 @@
+const _                = require('lodash');
+const net              = require('net');
+const dns              = require('dns');
+const crypto           = require('crypto');
+const logger           = require('../logger').ssl;
+const error            = require('../lib/error');
+const helpers          = require('../lib/helpers');
+const dnsPacket        = require('../lib/dns-packet');
+const internalActivity = require('./activity');
+const internalCertPins = require('./certificate-pins');
+
+const TIMEOUT = 10000;
+
+// Short, so a key that changed isn't trusted from caches for long
+const TTL = 300;
+
+/**
+ * The TLSA records of the certificate at a port, 3 1 1 for its key and the key of its next renewal,
+ * 2 1 1 for the key of its issuer, so they hold through a renewal either way
+ *
+ * @param   {Object}  certificate  the certificate row
+ * @param   {Object}  pins         from getPins
+ * @param   {Object}  service      {port, protocol}
+ * @returns {Array}
+ */
+const getServiceRecords = (certificate, pins, service) => {
+	const hex = (sha256) => Buffer.from(sha256, 'base64').toString('hex');
+
+	let keys = [{usage: 3, data: hex(pins.current.sha256)}];
+	if (pins.next && pins.next.sha256 !== pins.current.sha256) {
+		keys.push({usage: 3, data: hex(pins.next.sha256)});
+	}
+	if (pins.chain.length) {
+		keys.push({usage: 2, data: hex(pins.chain[0].sha256)});
+	}
+
+	let records = [];
+	// A wildcard can't be given records for the names it covers
+	_.filter(certificate.domain_names, (domain) => domain.indexOf('*') === -1).forEach((domain) => {
+		const name = '_' + service.port + '._' + service.protocol + '.' + helpers.toAsciiDomain(domain);
+
+		keys.forEach((key) => {
+			records.push({
+				name:          name,
+				usage:         key.usage,
+				selector:      1,
+				matching_type: 1,
+				data:          key.data,
+				text:          name + '. ' + TTL + ' IN TLSA ' + key.usage + ' 1 1 ' + key.data
+			});
+		});
+	});
+
+	return records;
+};
+
+const internalCertificateTlsa = {
+
+	/**
+	 * Only RFC 2136 servers can be given records other than the challenge, with the key certbot would use
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Boolean}
+	 */
+	canPublish: (certificate) => {
+		return certificate.provider === 'letsencrypt' && !!certificate.meta && !!certificate.meta.dns_challenge && certificate.meta.dns_provider === 'rfc2136';
+	},
+
+	/**
+	 * Reads the server and key from the credentials of certbot's rfc2136 plugin, ie: "dns_rfc2136_server = 192.0.2.1"
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Object}  {server, port, key}
+	 */
+	getServer: (certificate) => {
+		let values = {};
+		(certificate.meta.dns_provider_credentials || '').split('\n').forEach((line) => {
+			const found = line.trim().match(/^dns_rfc2136_([a-z]+)\s*=\s*(.*)$/);
+			if (found) {
+				values[found[1]] = found[2].replace(/^(["'])(.*)\1$/, '$2');
+			}
+		});
+
+		if (!values.server || !net.isIP(values.server)) {
+			throw new error.ValidationError('The RFC 2136 credentials need the address of the DNS server');
+		}
+
+		return {
+			server: values.server,
+			port:   parseInt(values.port, 10) || 53,
+			key:    values.name && values.secret ? {name: values.name, secret: values.secret, algorithm: values.algorithm || 'HMAC-MD5'} : null
+		};
+	},
+
+	/**
+	 * The zone a name is in, from the SOA records of the server, going up a label at a time
+	 *
+	 * @param   {Object}  server  from getServer
+	 * @param   {String}  name
+	 * @returns {Promise}  resolves with the zone, ie: example.com
+	 */
+	getZone: (server, name) => {
+		const resolver = new dns.promises.Resolver({timeout: TIMEOUT, tries: 1});
+		resolver.setServers([net.isIPv6(server.server) ? '[' + server.server + ']:' + server.port : server.server + ':' + server.port]);
+
+		const lookup = (labels) => {
+			if (labels.length < 2) {
+				return Promise.reject(new Error(server.server + ' has no zone for ' + name));
+			}
+
+			return resolver.resolveSoa(labels.join('.'))
+				.then(() => labels.join('.'), () => lookup(labels.slice(1)));
+		};
+
+		return lookup(name.split('.'));
+	},
+
+	/**
+	 * @param   {Object}  server   from getServer
+	 * @param   {Buffer}  message
+	 * @returns {Promise}  resolves with the response
+	 */
+	send: (server, message) => {
+		return new Promise((resolve, reject) => {
+			const length = Buffer.alloc(2);
+			length.writeUInt16BE(message.length, 0);
+
+			let response = Buffer.alloc(0);
+			const socket = net.connect({host: server.server, port: server.port});
+
+			socket.setTimeout(TIMEOUT, () => {
+				socket.destroy(new Error(server.server + ' timed out'));
+			});
+			socket.on('connect', () => {
+				// Over TCP, a message has its length before it
+				socket.write(Buffer.concat([length, message]));
+			});
+			socket.on('data', (chunk) => {
+				response = Buffer.concat([response, chunk]);
+				if (response.length >= 2 && response.length >= response.readUInt16BE(0) + 2) {
+					socket.end();
+					resolve(response.subarray(2, response.readUInt16BE(0) + 2));
+				}
+			});
+			socket.on('error', reject);
+			socket.on('close', () => {
+				reject(new Error(server.server + ' closed the connection without answering'));
+			});
+		});
+	},
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @param   {Object}  pins         from getPins
+	 * @param   {Array}   services     [{port, protocol}]
+	 * @returns {Array}
+	 */
+	getRecords: (certificate, pins, services) => {
+		return _.flatten(services.map((service) => getServiceRecords(certificate, pins, service)));
+	},
+
+	/**
+	 * The TLSA records to publish for a certificate, for a service at a port
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.port]      443 by default
+	 * @param   {String}  [data.protocol]  tcp by default
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		// Required here, as certificates require this module to renew
+		const internalCertificate = require('./certificate');
+
+		return Promise.all([
+			internalCertificate.get(access, {id: data.id}),
+			internalCertPins.getPins(access, {id: data.id})
+		])
+			.then(([certificate, pins]) => {
+				return {
+					certificate_id: certificate.id,
+					records:        internalCertificateTlsa.getRecords(certificate, pins, [{
+						port:     data.port || 443,
+						protocol: data.protocol || 'tcp'
+					}])
+				};
+			});
+	},
+
+	/**
+	 * Replaces the TLSA records of the services of a certificate on its RFC 2136 server, a zone at a time
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}
+	 */
+	publish: (access, certificate) => {
+		const services = (certificate.meta && certificate.meta.tlsa) || [];
+		if (!services.length) {
+			return Promise.resolve();
+		}
+
+		let server = null;
+
+		return Promise.resolve()
+			.then(() => {
+				server = internalCertificateTlsa.getServer(certificate);
+				return internalCertPins.getPins(access, {id: certificate.id});
+			})
+			.then((pins) => {
+				const names = _.groupBy(internalCertificateTlsa.getRecords(certificate, pins, services), 'name');
+
+				return Object.keys(names).reduce((sequence, name) => {
+					return sequence
+						.then(() => internalCertificateTlsa.getZone(server, name))
+						.then((zone) => {
+							const message = dnsPacket.encodeUpdate({
+								id:      crypto.randomInt(0x10000),
+								zone:    zone,
+								key:     server.key,
+								records: [{name: name, type: 'TLSA', class: 'ANY', ttl: 0}].concat(names[name].map((record) => {
+									return {
+										name: name,
+										type: 'TLSA',
+										ttl:  TTL,
+										data: _.pick(record, ['usage', 'selector', 'matching_type', 'data'])
+									};
+								}))
+							});
+
+							return internalCertificateTlsa.send(server, message);
+						})
+						.then((response) => {
+							const rcode = dnsPacket.getRcode(response);
+							if (rcode !== 'NOERROR') {
+								throw new Error(server.server + ' refused the TLSA records of ' + name + ': ' + rcode);
+							}
+						});
+				}, Promise.resolve());
+			})
+			.then(() => {
+				logger.success('Published the TLSA records of Certificate #' + certificate.id);
+				return internalActivity.record('certificate', certificate.id, 'deploy', 'tlsa', true, server.server);
+			})
+			.catch((err) => {
+				logger.error('Publishing the TLSA records of Certificate #' + certificate.id + ' failed: ' + err.message);
+				return internalActivity.record('certificate', certificate.id, 'deploy', 'tlsa', false, err.message);
+			});
+	}
+};
+
+module.exports = internalCertificateTlsa;
@@ -1,28 +1,46 @@
-const _                = require('lodash');
-const fs               = require('fs');
-const https            = require('https');
-const tempWrite        = require('temp-write');
-const moment           = require('moment');
-const archiver         = require('archiver');
-const path             = require('path');
-const { isArray }      = require('lodash');
-const logger           = require('../logger').ssl;
-const config           = require('../lib/config');
-const error            = require('../lib/error');
-const utils            = require('../lib/utils');
-const certbot          = require('../lib/certbot');
-const certificateModel = require('../models/certificate');
-const tokenModel       = require('../models/token');
-const dnsPlugins       = require('../global/certbot-dns-plugins.json');
-const internalAuditLog = require('./audit-log');
-const internalNginx    = require('./nginx');
-const internalHost     = require('./host');
-
-
-const letsencryptStaging = config.useLetsencryptStaging();
-const letsencryptServer  = config.useLetsencryptServer();
-const letsencryptConfig  = '/etc/letsencrypt.ini';
-const certbotCommand     = 'certbot';
+const _                     = require('lodash');
+const fs                    = require('fs');
+const https                 = require('https');
+const tempWrite             = require('temp-write');
+const moment                = require('moment');
+const archiver              = require('archiver');
+const path                  = require('path');
+const { isArray }           = require('lodash');
+const logger                = require('../logger').ssl;
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const helpers               = require('../lib/helpers');
+const certbot               = require('../lib/certbot');
+const lego                  = require('../lib/lego');
+const config                = require('../lib/config');
+const certificateModel      = require('../models/certificate');
+const settingModel          = require('../models/setting');
+const tokenModel            = require('../models/token');
+const dnsPlugins            = require('../global/certbot-dns-plugins.json');
+const internalAuditLog      = require('./audit-log');
+const internalNginx         = require('./nginx');
+const internalHost          = require('./host');
+const internalCa            = require('./ca');
+const internalAcmeAccount   = require('./acme-account');
+const internalAcmeRateLimit = require('./acme-rate-limit');
+const internalProject       = require('./project');
+const internalQuota         = require('./quota');
+const internalTag           = require('./tag');
+const internalLock          = require('./lock');
+const internalAdminListen   = require('./admin-listen');
+const internalCertDeploy    = require('./certificate-deploy');
+const internalPlugin        = require('./plugin');
+const internalRenewalRetry  = require('./renewal-retry');
+const internalDnsThrottle   = require('./dns-throttle');
+const internalCertStorage   = require('./certificate-storage');
+const internalCertPins      = require('./certificate-pins');
+const internalCertTlsa      = require('./certificate-tlsa');
+const internalCertPolicy    = require('./certificate-policy');
+const internalView          = require('./view');
+
+
+const letsencryptConfig = '/etc/letsencrypt.ini';
+const certbotCommand    = 'certbot';
 
 function omissions() {
 	return ['is_deleted', 'owner.is_deleted'];
@@ -37,6 +55,12 @@ const internalCertificate = {
 	renewBeforeExpirationBy: [30, 'days'],
 
 	initTimer: () => {
+		const storage = config.getCertStorage();
+		if (storage !== null && !storage.renew) {
+			logger.info('Let\'s Encrypt Renewal Timer left to another node, CERT_STORAGE_RENEW is false');
+			return;
+		}
+
 		logger.info('Let\'s Encrypt Renewal Timer initialized');
 		internalCertificate.interval = setInterval(internalCertificate.processExpiringHosts, internalCertificate.intervalTimeout);
 		// And do this now as well
@@ -54,12 +78,15 @@ const internalCertificate = {
 			const expirationThreshold = moment().add(internalCertificate.renewBeforeExpirationBy[0], internalCertificate.renewBeforeExpirationBy[1]).format('YYYY-MM-DD HH:mm:ss');
 
 			// Fetch all the letsencrypt certs from the db that will expire within the configured threshold
-			certificateModel
-				.query()
-				.where('is_deleted', 0)
-				.andWhere('provider', 'letsencrypt')
-				.andWhere('expires_on', '<', expirationThreshold)
-				.then((certificates) => {
+			Promise.all([
+				certificateModel
+					.query()
+					.where('is_deleted', 0)
+					.whereIn('provider', ['letsencrypt', 'internal'])
+					.andWhere('expires_on', '<', expirationThreshold),
+				internalRenewalRetry.getSetting()
+			])
+				.then(([certificates, setting]) => {
 					if (!certificates || !certificates.length) {
 						return null;
 					}
@@ -71,20 +98,50 @@ const internalCertificate = {
 					let sequence = Promise.resolve();
 
 					certificates.forEach(function (certificate) {
+						const policy = internalRenewalRetry.getPolicy(setting, certificate);
+
+						if (!internalRenewalRetry.isDue(policy, certificate)) {
+							return;
+						}
+
 						sequence = sequence.then(() =>
-							internalCertificate
-								.renew(
-									{
-										can: () =>
-											Promise.resolve({
-												permission_visibility: 'all',
-											}),
-										token: new tokenModel(),
-									},
-									{ id: certificate.id },
-								)
+							(certificate.provider === 'letsencrypt' ? internalAcmeRateLimit.evaluate(certificate) : Promise.resolve(null))
+								.then((limited) => {
+									if (limited || !certificate.meta.dns_challenge) {
+										return limited;
+									}
+
+									// The DNS provider has been called as often as it may this hour
+									return internalDnsThrottle.getDelay(certificate.meta.dns_provider)
+										.then((retry_on) => {
+											return retry_on ? {retry_on: retry_on, reason: 'limit of ' + certificate.meta.dns_provider + ' reached'} : null;
+										});
+								})
+								.then((limited) => {
+									if (limited) {
+										// Try again on a later run once the window has moved on
+										logger.warn('Delaying renewal of Cert #' + certificate.id + ' until ' + limited.retry_on + ': ' + limited.reason);
+										return;
+									}
+
+									return internalCertificate
+										.renew(
+											{
+												can: () =>
+													Promise.resolve({
+														permission_visibility: 'all',
+													}),
+												token: new tokenModel(),
+											},
+											{ id: certificate.id },
+										);
+								})
+								.catch((err) => {
+									// Don't want to stop the train here, just log the error and back off
+									logger.error(err.message);
+									return internalRenewalRetry.recordFailure(policy, certificate, err);
+								})
 								.catch((err) => {
-									// Don't want to stop the train here, just log the error
 									logger.error(err.message);
 								}),
 						);
@@ -104,16 +161,54 @@ const internalCertificate = {
 	},
 
 	/**
-	 * @param   {Access}  access
-	 * @param   {Object}  data
+	 * @param   {Access}    access
+	 * @param   {Object}    data
+	 * @param   {Function}  [progress]  told the phase of issuing the certificate, see internal/jobs
 	 * @returns {Promise}
 	 */
-	create: (access, data) => {
+	create: (access, data, progress = () => {}) => {
 		return access.can('certificates:create', data)
+			.then(() => {
+				return internalQuota.check(access, 'certificates');
+			})
+			.then(() => {
+				if (data.domain_names) {
+					data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+				}
+
+				if (data.provider === 'letsencrypt' && data.meta && data.meta.use_staging && data.meta.acme_account_id) {
+					throw new error.ValidationError('Staging can\'t be used with an ACME account, select a staging account instead');
+				}
+
+				if (data.provider === 'letsencrypt' && data.meta && data.meta.acme_eab_credential_id && !data.meta.acme_account_id) {
+					if (data.meta.use_staging) {
+						throw new error.ValidationError('Staging can\'t be used with EAB credentials, they\'re for the CA they were given by');
+					}
+					if (!data.meta.letsencrypt_email) {
+						throw new error.ValidationError('The certificate needs an email to register with the CA of its EAB credentials');
+					}
+
+					// Requested with the account registered with them, as certificates on an ACME account are
+					return internalAcmeAccount.getForEabCredential(access, data.meta.acme_eab_credential_id, data.meta.letsencrypt_email)
+						.then((account) => {
+							data.meta.acme_account_id = account.id;
+						});
+				}
+
+				if (data.provider === 'letsencrypt' && data.meta && data.meta.acme_account_id) {
+					return internalAcmeAccount.get(access, {id: data.meta.acme_account_id})
+						.then((account) => {
+							if (account.status !== 'valid') {
+								throw new error.ValidationError('ACME account ' + account.name + ' is ' + account.status);
+							}
+							data.meta.letsencrypt_email = data.meta.letsencrypt_email || account.email;
+						});
+				}
+			})
 			.then(() => {
 				data.owner_user_id = access.token.getUserId(1);
 
-				if (data.provider === 'letsencrypt') {
+				if (data.provider === 'letsencrypt' || (data.provider === 'internal' && !data.nice_name)) {
 					data.nice_name = data.domain_names.join(', ');
 				}
 
@@ -123,86 +218,16 @@ const internalCertificate = {
 					.then(utils.omitRow(omissions()));
 			})
 			.then((certificate) => {
-				if (certificate.provider === 'letsencrypt') {
-					// Request a new Cert from LE. Let the fun begin.
+				progress('preparing', {object_id: certificate.id});
 
-					// 1. Find out any hosts that are using any of the hostnames in this cert
-					// 2. Disable them in nginx temporarily
-					// 3. Generate the LE config
-					// 4. Request cert
-					// 5. Remove LE config
-					// 6. Re-instate previously disabled hosts
-
-					// 1. Find out any hosts that are using any of the hostnames in this cert
-					return internalHost.getHostsWithDomains(certificate.domain_names)
-						.then((in_use_result) => {
-							// 2. Disable them in nginx temporarily
-							return internalCertificate.disableInUseHosts(in_use_result)
-								.then(() => {
-									return in_use_result;
-								});
-						})
-						.then((in_use_result) => {
-							// With DNS challenge no config is needed, so skip 3 and 5.
-							if (certificate.meta.dns_challenge) {
-								return internalNginx.reload().then(() => {
-									// 4. Request cert
-									return internalCertificate.requestLetsEncryptSslWithDnsChallenge(certificate);
-								})
-									.then(internalNginx.reload)
-									.then(() => {
-										// 6. Re-instate previously disabled hosts
-										return internalCertificate.enableInUseHosts(in_use_result);
-									})
-									.then(() => {
-										return certificate;
-									})
-									.catch((err) => {
-										// In the event of failure, revert things and throw err back
-										return internalCertificate.enableInUseHosts(in_use_result)
-											.then(internalNginx.reload)
-											.then(() => {
-												throw err;
-											});
-									});
-							} else {
-								// 3. Generate the LE config
-								return internalNginx.generateLetsEncryptRequestConfig(certificate)
-									.then(internalNginx.reload)
-									.then(async() => await new Promise((r) => setTimeout(r, 5000)))
-									.then(() => {
-										// 4. Request cert
-										return internalCertificate.requestLetsEncryptSsl(certificate);
-									})
-									.then(() => {
-										// 5. Remove LE config
-										return internalNginx.deleteLetsEncryptRequestConfig(certificate);
-									})
-									.then(internalNginx.reload)
-									.then(() => {
-										// 6. Re-instate previously disabled hosts
-										return internalCertificate.enableInUseHosts(in_use_result);
-									})
-									.then(() => {
-										return certificate;
-									})
-									.catch((err) => {
-										// In the event of failure, revert things and throw err back
-										return internalNginx.deleteLetsEncryptRequestConfig(certificate)
-											.then(() => {
-												return internalCertificate.enableInUseHosts(in_use_result);
-											})
-											.then(internalNginx.reload)
-											.then(() => {
-												throw err;
-											});
-									});
-							}
-						})
+				if (certificate.provider === 'letsencrypt') {
+					return internalCertificate.issueLetsEncryptSsl(certificate, false, progress)
 						.then(() => {
+							progress('download');
+
 							// At this point, the letsencrypt cert should exist on disk.
 							// Lets get the expiry date from the file and update the row silently
-							return internalCertificate.getCertificateInfoFromFile('/etc/letsencrypt/live/npm-' + certificate.id + '/fullchain.pem')
+							return internalCertificate.getCertificateInfoFromFile(internalCertStorage.getDirectory(certificate) + '/fullchain.pem')
 								.then((cert_info) => {
 									return certificateModel
 										.query()
@@ -225,12 +250,35 @@ const internalCertificate = {
 								.query()
 								.deleteById(certificate.id);
 
+							throw error;
+						});
+				} else if (certificate.provider === 'internal') {
+					progress('download');
+
+					return internalCa.issue(certificate)
+						.then(utils.omitRow(omissions()))
+						.catch(async (error) => {
+							// Delete the certificate from the database if it was not created successfully
+							await certificateModel
+								.query()
+								.deleteById(certificate.id);
+
 							throw error;
 						});
 				} else {
 					return certificate;
 				}
 			}).then((certificate) => {
+				if (certificate.provider === 'letsencrypt' && certificate.meta.use_staging && certificate.meta.auto_promote) {
+					// Staging worked, so the challenge setup is good enough for production
+					return internalCertificate.promote(access, {id: certificate.id})
+						.catch((err) => {
+							logger.error('Automatic promotion of Cert #' + certificate.id + ' failed: ' + err.message);
+							return certificate;
+						});
+				}
+				return certificate;
+			}).then((certificate) => {
 
 				data.meta = _.assign({}, data.meta || {}, certificate.meta);
 
@@ -242,11 +290,124 @@ const internalCertificate = {
 					meta:        data
 				})
 					.then(() => {
+						if (certificate.provider !== 'other') {
+							internalPlugin.notify('certificate.issued', {certificate: internalCertificate.getPluginPayload(certificate), renewal: false});
+						}
 						return certificate;
 					});
 			});
 	},
 
+	/**
+	 * A certificate as it's sent to plugins, without its files or credentials
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Object}
+	 */
+	getPluginPayload: (certificate) => {
+		return {
+			id:           certificate.id,
+			provider:     certificate.provider,
+			nice_name:    certificate.nice_name,
+			domain_names: certificate.domain_names,
+			expires_on:   certificate.expires_on
+		};
+	},
+
+	/**
+	 * Requests the certificate from the CA, taking hosts using its domains offline while the challenge runs.
+	 *
+	 * @param   {Object}    certificate  the certificate row
+	 * @param   {Boolean}   [force]      Replace the certificate even when it isn't due for renewal
+	 * @param   {Function}  [progress]
+	 * @returns {Promise}
+	 */
+	issueLetsEncryptSsl: (certificate, force, progress) => {
+		// Request a new Cert from LE. Let the fun begin.
+
+		// 1. Find out any hosts that are using any of the hostnames in this cert
+		// 2. Disable them in nginx temporarily
+		// 3. Generate the LE config
+		// 4. Request cert
+		// 5. Remove LE config
+		// 6. Re-instate previously disabled hosts
+
+		// 1. Find out any hosts that are using any of the hostnames in this cert
+		return internalHost.getHostsWithDomains(certificate.domain_names)
+			.then((in_use_result) => {
+				// 2. Disable them in nginx temporarily
+				return internalCertificate.disableInUseHosts(in_use_result)
+					.then(() => {
+						return in_use_result;
+					});
+			})
+			.then((in_use_result) => {
+				// With DNS challenge no config is needed, so skip 3 and 5.
+				if (certificate.meta.dns_challenge) {
+					return internalNginx.reload().then(() => {
+						// 4. Request cert
+						return internalCertificate.requestLetsEncryptSslWithDnsChallenge(certificate, force, progress);
+					})
+						.then(() => {
+							// For the other nodes to fetch, when certificates are kept in a bucket
+							return internalCertStorage.push(certificate);
+						})
+						.then(internalNginx.reload)
+						.then(() => {
+							// 6. Re-instate previously disabled hosts
+							return internalCertificate.enableInUseHosts(in_use_result);
+						})
+						.then(() => {
+							return certificate;
+						})
+						.catch((err) => {
+							// In the event of failure, revert things and throw err back
+							return internalCertificate.enableInUseHosts(in_use_result)
+								.then(internalNginx.reload)
+								.then(() => {
+									throw err;
+								});
+						});
+				} else {
+					// 3. Generate the LE config
+					return internalNginx.generateLetsEncryptRequestConfig(certificate)
+						.then(internalNginx.reload)
+						.then(async() => await new Promise((r) => setTimeout(r, 5000)))
+						.then(() => {
+							// 4. Request cert
+							return internalCertificate.requestLetsEncryptSsl(certificate, force, progress);
+						})
+						.then(() => {
+							// For the other nodes to fetch, when certificates are kept in a bucket
+							return internalCertStorage.push(certificate);
+						})
+						.then(() => {
+							// 5. Remove LE config
+							return internalNginx.deleteLetsEncryptRequestConfig(certificate);
+						})
+						.then(internalNginx.reload)
+						.then(() => {
+							// 6. Re-instate previously disabled hosts
+							return internalCertificate.enableInUseHosts(in_use_result);
+						})
+						.then(() => {
+							return certificate;
+						})
+						.catch((err) => {
+							// In the event of failure, revert things and throw err back
+							return internalNginx.deleteLetsEncryptRequestConfig(certificate)
+								.then(() => {
+									return internalCertificate.enableInUseHosts(in_use_result);
+								})
+								.then(internalNginx.reload)
+								.then(() => {
+									throw err;
+								});
+						});
+				}
+			});
+	},
+
 	/**
 	 * @param  {Access}  access
 	 * @param  {Object}  data
@@ -266,6 +427,8 @@ const internalCertificate = {
 					throw new error.InternalValidationError('Certificate could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'updated');
+
 				return certificateModel
 					.query()
 					.patchAndFetchById(row.id, data)
@@ -308,6 +471,9 @@ const internalCertificate = {
 
 		return access.can('certificates:get', data.id)
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = certificateModel
 					.query()
 					.where('is_deleted', 0)
@@ -315,8 +481,8 @@ const internalCertificate = {
 					.allowGraph('[owner]')
 					.first();
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
@@ -351,7 +517,7 @@ const internalCertificate = {
 				})
 				.then((certificate) => {
 					if (certificate.provider === 'letsencrypt') {
-						const zipDirectory = '/etc/letsencrypt/live/npm-' + data.id;
+						const zipDirectory = internalCertStorage.getDirectory(certificate);
 
 						if (!fs.existsSync(zipDirectory)) {
 							throw new error.ItemNotFoundError('Certificate ' + certificate.nice_name + ' does not exists');
@@ -419,11 +585,16 @@ const internalCertificate = {
 					throw new error.ItemNotFoundError(data.id);
 				}
 
-				return certificateModel
-					.query()
-					.where('id', row.id)
-					.patch({
-						is_deleted: 1
+				internalLock.assertUnlocked(row, 'deleted');
+
+				return internalAdminListen.assertCertificateUnused(row.id)
+					.then(() => {
+						return certificateModel
+							.query()
+							.where('id', row.id)
+							.patch({
+								is_deleted: 1
+							});
 					})
 					.then(() => {
 						// Add to audit log
@@ -441,6 +612,10 @@ const internalCertificate = {
 							// Revoke the cert
 							return internalCertificate.revokeLetsEncryptSsl(row);
 						}
+					})
+					.then(() => {
+						fs.rmSync(internalCertPins.getNextKeyFile(row), {force: true});
+						return internalCertStorage.remove(row);
 					});
 			})
 			.then(() => {
@@ -454,26 +629,46 @@ const internalCertificate = {
 	 * @param   {Access}  access
 	 * @param   {Array}   [expand]
 	 * @param   {String}  [search_query]
+	 * @param   {Object}  [filter]
+	 * @param   {Number}  [filter.project_id]
+	 * @param   {Array}   [filter.tags]
+	 * @param   {Number}  [filter.expires_within_days]
+	 * @param   {Object}  [filter.sort]  {field, direction}
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, filter) => {
 		return access.can('certificates:list')
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = certificateModel
 					.query()
 					.where('is_deleted', 0)
 					.groupBy('id')
 					.allowGraph('[owner]')
-					.orderBy('nice_name', 'ASC');
+					.orderBy('nice_name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (visibility) {
+					query.andWhere(visibility);
+				}
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (filter && typeof filter.project_id === 'number') {
+					query.andWhere('project_id', filter.project_id);
 				}
 
+				if (filter && filter.tags && filter.tags.length) {
+					internalTag.applyFilter(query, filter.tags);
+				}
+
+				internalView.applyFilter(query, 'certificate', filter);
+
 				// Query is used for searching
 				if (typeof search_query === 'string') {
 					query.where(function () {
 						this.where('nice_name', 'like', '%' + search_query + '%');
+						this.orWhere('notes', 'like', '%' + search_query + '%');
 					});
 				}
 
@@ -515,7 +710,7 @@ const internalCertificate = {
 	writeCustomCert: (certificate) => {
 		logger.info('Writing Custom Certificate:', certificate);
 
-		const dir = '/data/custom_ssl/npm-' + certificate.id;
+		const dir = internalCertStorage.getDirectory(certificate);
 
 		return new Promise((resolve, reject) => {
 			if (certificate.provider === 'letsencrypt') {
@@ -530,7 +725,7 @@ const internalCertificate = {
 
 			try {
 				if (!fs.existsSync(dir)) {
-					fs.mkdirSync(dir);
+					fs.mkdirSync(dir, {recursive: true});
 				}
 			} catch (err) {
 				reject(err);
@@ -619,7 +814,10 @@ const internalCertificate = {
 							data = _.assign({}, data, file);
 						});
 
-						return data;
+						return internalCertPolicy.check(data)
+							.then(() => {
+								return data;
+							});
 					});
 			});
 	},
@@ -654,12 +852,15 @@ const internalCertificate = {
 						return internalCertificate.update(access, {
 							id:           data.id,
 							expires_on:   moment(validations.certificate.dates.to, 'X').format('YYYY-MM-DD HH:mm:ss'),
-							domain_names: [validations.certificate.cn],
+							domain_names: [helpers.toUnicodeDomain(validations.certificate.cn) || validations.certificate.cn],
 							meta:         _.clone(row.meta) // Prevent the update method from changing this value that we'll use later
 						})
 							.then((certificate) => {
 								certificate.meta = row.meta;
-								return internalCertificate.writeCustomCert(certificate);
+								return internalCertificate.writeCustomCert(certificate)
+									.then(() => {
+										return internalCertStorage.push(certificate);
+									});
 							});
 					})
 					.then(() => {
@@ -757,6 +958,24 @@ const internalCertificate = {
 					certData['issuer'] = match[1];
 				}
 			})
+			.then(() => {
+				return utils.exec('openssl x509 -in ' + certificate_file + ' -text -noout');
+			})
+			.then((result) => {
+				// Examples:
+				// Signature Algorithm: sha256WithRSAEncryption
+				// Public Key Algorithm: id-ecPublicKey
+				// Public-Key: (256 bit)
+				const signature = /Signature Algorithm:\s*(\S+)/.exec(result);
+				const algorithm = /Public Key Algorithm:\s*(\S+)/.exec(result);
+				const bits      = /Public-Key:\s*\((\d+) bit\)/.exec(result);
+
+				certData['signature_algorithm'] = signature ? signature[1] : null;
+				certData['public_key']          = {
+					algorithm: algorithm ? algorithm[1] : null,
+					bits:      bits ? parseInt(bits[1], 10) : null
+				};
+			})
 			.then(() => {
 				return utils.exec('openssl x509 -in ' + certificate_file + ' -dates -noout');
 			})
@@ -822,16 +1041,147 @@ const internalCertificate = {
 		return meta;
 	},
 
+	/**
+	 * Certbot argument for selecting an alternate chain, when the CA offers one
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {String}
+	 */
+	getPreferredChainArg: (certificate) => {
+		if (!certificate.meta || !certificate.meta.preferred_chain) {
+			return '';
+		}
+		return `--preferred-chain '${certificate.meta.preferred_chain}' `;
+	},
+
+	/**
+	 * Certbot argument for keeping the private key across renewals. Both ways are given, as certbot
+	 * remembers it in the renewal config of the certificate.
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {String}
+	 */
+	getReuseKeyArg: (certificate) => {
+		return certificate.meta && certificate.meta.reuse_key ? '--reuse-key ' : '--no-reuse-key ';
+	},
+
+	/**
+	 * Tells the progress of a job what certbot is doing, from what it prints
+	 *
+	 * @param   {Function}  [progress]
+	 * @param   {Boolean}   dns_challenge
+	 * @param   {Object}    [client]       lib/lego when it's lego printing it
+	 * @returns {Function}  for the onOutput of utils.exec
+	 */
+	watchCertbot: (progress, dns_challenge, client) => {
+		const getPhase = (client || certbot).getPhase;
+
+		return (output) => {
+			if (!progress) {
+				return;
+			}
+
+			output.split('\n').forEach((line) => {
+				const found = getPhase(line);
+				if (!found) {
+					return;
+				}
+
+				progress(found.phase, found);
+
+				// The records are set as soon as the order is, certbot doesn't say so
+				if (found.phase === 'order' && dns_challenge) {
+					progress('dns');
+				}
+			});
+		};
+	},
+
+	/**
+	 * Which ACME client requests a certificate. One that was issued by lego stays with it, others follow
+	 * the acme-client setting, except the ones on an ACME account or a DNS provider that only certbot can use.
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}  resolves with certbot or lego
+	 */
+	getAcmeClient: (certificate) => {
+		// Only lego has the mock DNS provider of test mode
+		if (certificate.meta.acme_client === 'lego' || (certificate.meta.dns_challenge && certificate.meta.dns_provider === lego.MOCK_DNS_PROVIDER)) {
+			return Promise.resolve('lego');
+		}
+		if (certificate.meta.acme_account_id || (certificate.meta.dns_challenge && !lego.hasDnsProvider(certificate.meta.dns_provider))) {
+			return Promise.resolve('certbot');
+		}
+
+		return settingModel
+			.query()
+			.where('id', 'acme-client')
+			.first()
+			.then((setting) => {
+				return setting && setting.value === 'lego' ? 'lego' : 'certbot';
+			});
+	},
+
+	/**
+	 * @param   {String}  dns_provider  the key of the plugin in certbot-dns-plugins.json
+	 * @returns {Object|undefined}  the plugin, and for the mock DNS provider of test mode one with just its name
+	 */
+	getDnsPlugin: (dns_provider) => {
+		if (dns_provider === lego.MOCK_DNS_PROVIDER) {
+			return {name: 'Mock DNS'};
+		}
+		return dnsPlugins[dns_provider];
+	},
+
+	/**
+	 * Requests a certificate with lego, with either challenge, and keeps that it was so it's renewed
+	 * and revoked with lego too
+	 *
+	 * @param   {Object}    certificate  the certificate row
+	 * @param   {Function}  [progress]
+	 * @returns {Promise}
+	 */
+	requestWithLego: (certificate, progress) => {
+		return internalAcmeRateLimit.track(certificate, 'issue', () => lego.request(certificate, internalCertificate.watchCertbot(progress, certificate.meta.dns_challenge, lego)))
+			.then((result) => {
+				if (certificate.meta.acme_client === 'lego') {
+					return result;
+				}
+
+				certificate.meta = _.assign({}, certificate.meta, {acme_client: 'lego'});
+				return certificateModel
+					.query()
+					.patch({meta: certificate.meta})
+					.where('id', certificate.id)
+					.then(() => {
+						return result;
+					});
+			});
+	},
+
 	/**
 	 * Request a certificate using the http challenge
-	 * @param   {Object}  certificate   the certificate row
+	 * @param   {Object}    certificate   the certificate row
+	 * @param   {Boolean}   [force]       replace an existing certificate
+	 * @param   {Function}  [progress]
 	 * @returns {Promise}
 	 */
-	requestLetsEncryptSsl: (certificate) => {
+	requestLetsEncryptSsl: async (certificate, force, progress) => {
 		logger.info('Requesting Let\'sEncrypt certificates for Cert #' + certificate.id + ': ' + certificate.domain_names.join(', '));
 
+		if (await internalCertificate.getAcmeClient(certificate) === 'lego') {
+			return internalCertificate.requestWithLego(certificate, progress)
+				.then((result) => {
+					logger.success(result);
+					return result;
+				});
+		}
+
+		const serverArgs = await internalAcmeAccount.getCertbotArgs(certificate);
+
 		const cmd = `${certbotCommand} certonly ` +
 			`--config '${letsencryptConfig}' ` +
+			`--config-dir '${config.getPath('letsencrypt')}' ` +
 			'--work-dir "/tmp/letsencrypt-lib" ' +
 			'--logs-dir "/tmp/letsencrypt-log" ' +
 			`--cert-name "npm-${certificate.id}" ` +
@@ -839,13 +1189,14 @@ const internalCertificate = {
 			'--authenticator webroot ' +
 			`--email '${certificate.meta.letsencrypt_email}' ` +
 			'--preferred-challenges "dns,http" ' +
-			`--domains "${certificate.domain_names.join(',')}" ` +
-			(letsencryptServer !== null ? `--server '${letsencryptServer}' ` : '') +
-			(letsencryptStaging && letsencryptServer === null ? '--staging ' : '');
+			`--domains "${certificate.domain_names.map(helpers.toAsciiDomain).join(',')}" ` +
+			(force ? '--force-renewal ' : '') +
+			internalCertificate.getPreferredChainArg(certificate) +
+			serverArgs;
 
 		logger.info('Command:', cmd);
 
-		return utils.exec(cmd)
+		return internalAcmeRateLimit.track(certificate, 'issue', () => utils.exec(cmd, {onOutput: internalCertificate.watchCertbot(progress, false)}))
 			.then((result) => {
 				logger.success(result);
 				return result;
@@ -857,28 +1208,43 @@ const internalCertificate = {
 	 * @param   {String}         dns_provider         the dns provider name (key used in `certbot-dns-plugins.json`)
 	 * @param   {String | null}  credentials          the content of this providers credentials file
 	 * @param   {String}         propagation_seconds
+	 * @param   {Boolean}        [force]              replace an existing certificate
+	 * @param   {Function}       [progress]
 	 * @returns {Promise}
 	 */
-	requestLetsEncryptSslWithDnsChallenge: async (certificate) => {
+	requestLetsEncryptSslWithDnsChallenge: async (certificate, force, progress) => {
+		const dnsPlugin = internalCertificate.getDnsPlugin(certificate.meta.dns_provider);
+
+		if (await internalCertificate.getAcmeClient(certificate) === 'lego') {
+			// No plugin to install or credentials file to write, lego has the provider and is given them
+			logger.info(`Requesting Let'sEncrypt certificates with lego via ${dnsPlugin.name} for Cert #${certificate.id}: ${certificate.domain_names.join(', ')}`);
+
+			const result = await internalDnsThrottle.run(certificate.meta.dns_provider, () => internalCertificate.requestWithLego(certificate, progress));
+			logger.info(result);
+			return result;
+		}
+
 		await certbot.installPlugin(certificate.meta.dns_provider);
-		const dnsPlugin = dnsPlugins[certificate.meta.dns_provider];
 		logger.info(`Requesting Let'sEncrypt certificates via ${dnsPlugin.name} for Cert #${certificate.id}: ${certificate.domain_names.join(', ')}`);
 
-		const credentialsLocation = '/etc/letsencrypt/credentials/credentials-' + certificate.id;
-		fs.mkdirSync('/etc/letsencrypt/credentials', { recursive: true });
+		const credentialsLocation = config.getPath('letsencrypt') + '/credentials/credentials-' + certificate.id;
+		fs.mkdirSync(config.getPath('letsencrypt') + '/credentials', { recursive: true });
 		fs.writeFileSync(credentialsLocation, certificate.meta.dns_provider_credentials, {mode: 0o600});
 
 		// Whether the plugin has a --<name>-credentials argument
 		const hasConfigArg = certificate.meta.dns_provider !== 'route53';
 
+		const serverArgs = await internalAcmeAccount.getCertbotArgs(certificate);
+
 		let mainCmd = certbotCommand + ' certonly ' +
 			`--config '${letsencryptConfig}' ` +
+			`--config-dir '${config.getPath('letsencrypt')}' ` +
 			'--work-dir "/tmp/letsencrypt-lib" ' +
 			'--logs-dir "/tmp/letsencrypt-log" ' +
 			`--cert-name 'npm-${certificate.id}' ` +
 			'--agree-tos ' +
 			`--email '${certificate.meta.letsencrypt_email}' ` +
-			`--domains '${certificate.domain_names.join(',')}' ` +
+			`--domains '${certificate.domain_names.map(helpers.toAsciiDomain).join(',')}' ` +
 			`--authenticator '${dnsPlugin.full_plugin_name}' ` +
 			(
 				hasConfigArg
@@ -890,8 +1256,9 @@ const internalCertificate = {
 					? `--${dnsPlugin.full_plugin_name}-propagation-seconds '${certificate.meta.propagation_seconds}' `
 					: ''
 			) +
-			(letsencryptServer !== null ? `--server '${letsencryptServer}' ` : '') +
-			(letsencryptStaging && letsencryptServer === null ? '--staging ' : '');
+			(force ? '--force-renewal ' : '') +
+			internalCertificate.getPreferredChainArg(certificate) +
+			serverArgs;
 
 		// Prepend the path to the credentials file as an environment variable
 		if (certificate.meta.dns_provider === 'route53') {
@@ -905,7 +1272,7 @@ const internalCertificate = {
 		logger.info('Command:', mainCmd);
 
 		try {
-			const result = await utils.exec(mainCmd);
+			const result = await internalDnsThrottle.run(certificate.meta.dns_provider, () => internalAcmeRateLimit.track(certificate, 'issue', () => utils.exec(mainCmd, {onOutput: internalCertificate.watchCertbot(progress, true)})));
 			logger.info(result);
 			return result;
 		} catch (err) {
@@ -917,23 +1284,30 @@ const internalCertificate = {
 
 
 	/**
-	 * @param   {Access}  access
-	 * @param   {Object}  data
-	 * @param   {Number}  data.id
+	 * @param   {Access}    access
+	 * @param   {Object}    data
+	 * @param   {Number}    data.id
+	 * @param   {Function}  [progress]  told the phase of renewing the certificate, see internal/jobs
 	 * @returns {Promise}
 	 */
-	renew: (access, data) => {
+	renew: (access, data, progress = () => {}) => {
 		return access.can('certificates:update', data)
 			.then(() => {
 				return internalCertificate.get(access, data);
 			})
 			.then((certificate) => {
+				progress('preparing', {object_id: certificate.id});
+
 				if (certificate.provider === 'letsencrypt') {
 					const renewMethod = certificate.meta.dns_challenge ? internalCertificate.renewLetsEncryptSslWithDnsChallenge : internalCertificate.renewLetsEncryptSsl;
 
-					return renewMethod(certificate)
+					return renewMethod(certificate, progress)
 						.then(() => {
-							return internalCertificate.getCertificateInfoFromFile('/etc/letsencrypt/live/npm-' + certificate.id + '/fullchain.pem');
+							return internalCertStorage.push(certificate);
+						})
+						.then(() => {
+							progress('download');
+							return internalCertificate.getCertificateInfoFromFile(internalCertStorage.getDirectory(certificate) + '/fullchain.pem');
 						})
 						.then((cert_info) => {
 							return certificateModel
@@ -954,33 +1328,406 @@ const internalCertificate = {
 									return updated_certificate;
 								});
 						});
+				} else if (certificate.provider === 'internal') {
+					progress('download');
+
+					return internalCa.issue(certificate)
+						.then(internalNginx.reload)
+						.then(() => {
+							return internalCertificate.get(access, {id: certificate.id});
+						})
+						.then((updated_certificate) => {
+							// Add to audit log
+							return internalAuditLog.add(access, {
+								action:      'renewed',
+								object_type: 'certificate',
+								object_id:   updated_certificate.id,
+								meta:        updated_certificate
+							})
+								.then(() => {
+									return updated_certificate;
+								});
+						});
 				} else {
-					throw new error.ValidationError('Only Let\'sEncrypt certificates can be renewed');
+					throw new error.ValidationError('Only Let\'sEncrypt and internal certificates can be renewed');
+				}
+			})
+			.then((updated_certificate) => {
+				// A renewal that worked starts the retries over
+				return internalRenewalRetry.reset(updated_certificate)
+					.then(() => {
+						updated_certificate.renewal_status = null;
+
+						// Push the new certificate to wherever it's used besides nginx
+						return internalCertDeploy.run(updated_certificate);
+					})
+					.then(() => {
+						// The key may have changed, so the records saying which it is go with it
+						return internalCertTlsa.publish(access, updated_certificate);
+					})
+					.then(() => {
+						internalPlugin.notify('certificate.issued', {certificate: internalCertificate.getPluginPayload(updated_certificate), renewal: true});
+						return updated_certificate;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}       access
+	 * @param   {Object}       data
+	 * @param   {Number}       data.id
+	 * @param   {Object|null}  data.renewal_retry
+	 * @returns {Promise}
+	 */
+	setRenewalRetry: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				if (row.provider === 'other') {
+					throw new error.ValidationError('Custom certificates aren\'t renewed');
+				}
+
+				// The failed attempts so far are forgotten, so a renewal that was given up on is tried again
+				return certificateModel
+					.query()
+					.patch({
+						renewal_retry:  data.renewal_retry,
+						renewal_status: null
+					})
+					.where('id', row.id);
+			})
+			.then(() => {
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'certificate',
+					object_id:   data.id,
+					meta:        {renewal_retry: data.renewal_retry}
+				});
+			})
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			});
+	},
+
+	/**
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Number}   data.id
+	 * @param   {Boolean}  data.reuse_key
+	 * @returns {Promise}
+	 */
+	setReuseKey: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				if (row.provider !== 'letsencrypt') {
+					throw new error.ValidationError('Only Let\'s Encrypt certificates are renewed with a key');
+				}
+
+				return certificateModel
+					.query()
+					.patch({
+						meta: _.assign({}, row.meta, {reuse_key: data.reuse_key})
+					})
+					.where('id', row.id);
+			})
+			.then(() => {
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'certificate',
+					object_id:   data.id,
+					meta:        {reuse_key: data.reuse_key}
+				});
+			})
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			});
+	},
+
+	/**
+	 * Replaces the credentials of the DNS provider a certificate is renewed with, ie: after the API token
+	 * was rotated. Certbot reads them from the file it was issued with, so that's written again too.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  data.credentials  the content of the provider's credentials file
+	 * @returns {Promise}
+	 */
+	setDnsCredentials: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				if (row.provider !== 'letsencrypt' || !row.meta.dns_challenge) {
+					throw new error.ValidationError('Only certificates issued with a DNS challenge have DNS provider credentials');
+				}
+
+				return certificateModel
+					.query()
+					.patch({
+						meta: _.assign({}, row.meta, {dns_provider_credentials: data.credentials})
+					})
+					.where('id', row.id);
+			})
+			.then(() => {
+				const credentialsLocation = config.getPath('letsencrypt') + '/credentials/credentials-' + data.id;
+				if (fs.existsSync(credentialsLocation)) {
+					fs.writeFileSync(credentialsLocation, data.credentials, {mode: 0o600});
 				}
+
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'certificate',
+					object_id:   data.id,
+					meta:        {dns_provider_credentials: true}
+				});
 			});
 	},
 
 	/**
-	 * @param   {Object}  certificate   the certificate row
+	 * Sets the services whose TLSA records are published for the certificate, and publishes them
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   data.tlsa  [{port, protocol}]
 	 * @returns {Promise}
 	 */
-	renewLetsEncryptSsl: (certificate) => {
+	setTlsa: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				if (data.tlsa.length) {
+					if (!internalCertTlsa.canPublish(row)) {
+						throw new error.ValidationError('TLSA records can only be published for Let\'s Encrypt certificates with the RFC 2136 DNS provider');
+					}
+					// Throws when the credentials don't say where to
+					internalCertTlsa.getServer(row);
+				}
+
+				return certificateModel
+					.query()
+					.patch({
+						meta: _.assign({}, row.meta, {tlsa: _.uniqWith(data.tlsa, _.isEqual)})
+					})
+					.where('id', row.id);
+			})
+			.then(() => {
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'certificate',
+					object_id:   data.id,
+					meta:        {tlsa: data.tlsa}
+				});
+			})
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((certificate) => {
+				return internalCertTlsa.publish(access, certificate)
+					.then(() => {
+						return certificate;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   data.deploy_hooks
+	 * @returns {Promise}
+	 */
+	setDeployHooks: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				return internalCertDeploy.validate(data.deploy_hooks)
+					.then(() => {
+						return certificateModel
+							.query()
+							.patchAndFetchById(row.id, {deploy_hooks: data.deploy_hooks});
+					});
+			})
+			.then(() => {
+				// Webhook urls can have a token in their query, so only where they go is logged
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'certificate',
+					object_id:   data.id,
+					meta:        {
+						deploy_hooks: data.deploy_hooks.map((hook) => {
+							return {type: hook.type, target: internalCertDeploy.getTarget(hook)};
+						})
+					}
+				});
+			})
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise} resolves with the targets certificates can be deployed to
+	 */
+	getDeployTargets: (access) => {
+		return access.can('certificates:list')
+			.then(() => {
+				return internalCertDeploy.getTargets();
+			});
+	},
+
+	/**
+	 * Runs the deploy hooks of a certificate now, instead of waiting for its next renewal
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise} resolves with the result of each hook
+	 */
+	deploy: (access, data) => {
+		return access.can('certificates:update', data.id)
+			.then(() => {
+				return internalCertificate.get(access, {id: data.id});
+			})
+			.then((certificate) => {
+				if (!certificate.deploy_hooks || !certificate.deploy_hooks.length) {
+					throw new error.ValidationError('Certificate #' + certificate.id + ' has no deploy hooks');
+				}
+
+				return internalCertDeploy.run(certificate)
+					.then((results) => {
+						return internalAuditLog.add(access, {
+							action:      'deployed',
+							object_type: 'certificate',
+							object_id:   certificate.id,
+							meta:        {results: results}
+						})
+							.then(() => {
+								return results;
+							});
+					});
+			});
+	},
+
+	/**
+	 * Reissues a certificate that was requested from the staging CA against production
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	promote: (access, data) => {
+		return access.can('certificates:update', data)
+			.then(() => {
+				return internalCertificate.get(access, data);
+			})
+			.then((certificate) => {
+				internalLock.assertUnlocked(certificate, 'promoted');
+
+				if (certificate.provider !== 'letsencrypt' || !certificate.meta.use_staging) {
+					throw new error.ValidationError('Only certificates issued by the staging CA can be promoted');
+				}
+
+				if (!internalCertificate.hasLetsEncryptSslCerts(certificate)) {
+					throw new error.ValidationError('The staging certificate has not been issued yet');
+				}
+
+				certificate.meta = _.assign({}, certificate.meta, {use_staging: false});
+				delete certificate.meta.auto_promote;
+
+				logger.info('Promoting Cert #' + certificate.id + ' from staging to production');
+
+				return internalCertificate.issueLetsEncryptSsl(certificate, true)
+					.then(() => {
+						return internalCertificate.getCertificateInfoFromFile(internalCertStorage.getDirectory(certificate) + '/fullchain.pem');
+					})
+					.then((cert_info) => {
+						return certificateModel
+							.query()
+							.patchAndFetchById(certificate.id, {
+								expires_on: moment(cert_info.dates.to, 'X').format('YYYY-MM-DD HH:mm:ss'),
+								meta:       certificate.meta
+							})
+							.then(utils.omitRow(omissions()));
+					});
+			})
+			.then((updated_certificate) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'promoted',
+					object_type: 'certificate',
+					object_id:   updated_certificate.id,
+					meta:        updated_certificate
+				})
+					.then(() => {
+						return updated_certificate;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Object}    certificate   the certificate row
+	 * @param   {Function}  [progress]
+	 * @returns {Promise}
+	 */
+	renewLetsEncryptSsl: async (certificate, progress) => {
 		logger.info('Renewing Let\'sEncrypt certificates for Cert #' + certificate.id + ': ' + certificate.domain_names.join(', '));
 
+		if (certificate.meta.acme_client === 'lego') {
+			// A key that's kept isn't swapped for the staged one
+			const staged = !certificate.meta.reuse_key && internalCertPins.useNextKey(certificate);
+
+			return internalAcmeRateLimit.track(certificate, 'renew', () => lego.renew(certificate, internalCertificate.watchCertbot(progress, false, lego), staged || !!certificate.meta.reuse_key))
+				.then((result) => {
+					logger.info(result);
+					if (staged) {
+						internalCertPins.rotate(certificate);
+					}
+					return result;
+				});
+		}
+
+		const serverArgs = await internalAcmeAccount.getCertbotArgs(certificate);
+
 		const cmd = certbotCommand + ' renew --force-renewal ' +
 			`--config '${letsencryptConfig}' ` +
+			`--config-dir '${config.getPath('letsencrypt')}' ` +
 			'--work-dir "/tmp/letsencrypt-lib" ' +
 			'--logs-dir "/tmp/letsencrypt-log" ' +
 			`--cert-name 'npm-${certificate.id}' ` +
 			'--preferred-challenges "dns,http" ' +
 			'--no-random-sleep-on-renew ' +
 			'--disable-hook-validation ' +
-			(letsencryptServer !== null ? `--server '${letsencryptServer}' ` : '') +
-			(letsencryptStaging && letsencryptServer === null ? '--staging ' : '');
+			internalCertificate.getPreferredChainArg(certificate) +
+			internalCertificate.getReuseKeyArg(certificate) +
+			serverArgs;
 
 		logger.info('Command:', cmd);
 
-		return utils.exec(cmd)
+		return internalAcmeRateLimit.track(certificate, 'renew', () => utils.exec(cmd, {onOutput: internalCertificate.watchCertbot(progress, false)}))
 			.then((result) => {
 				logger.info(result);
 				return result;
@@ -988,11 +1735,12 @@ const internalCertificate = {
 	},
 
 	/**
-	 * @param   {Object}  certificate   the certificate row
+	 * @param   {Object}    certificate   the certificate row
+	 * @param   {Function}  [progress]
 	 * @returns {Promise}
 	 */
-	renewLetsEncryptSslWithDnsChallenge: (certificate) => {
-		const dnsPlugin = dnsPlugins[certificate.meta.dns_provider];
+	renewLetsEncryptSslWithDnsChallenge: async (certificate, progress) => {
+		const dnsPlugin = internalCertificate.getDnsPlugin(certificate.meta.dns_provider);
 
 		if (!dnsPlugin) {
 			throw Error(`Unknown DNS provider '${certificate.meta.dns_provider}'`);
@@ -1000,25 +1748,43 @@ const internalCertificate = {
 
 		logger.info(`Renewing Let'sEncrypt certificates via ${dnsPlugin.name} for Cert #${certificate.id}: ${certificate.domain_names.join(', ')}`);
 
+		if (certificate.meta.acme_client === 'lego') {
+			// A key that's kept isn't swapped for the staged one
+			const staged = !certificate.meta.reuse_key && internalCertPins.useNextKey(certificate);
+
+			return internalDnsThrottle.run(certificate.meta.dns_provider, () => internalAcmeRateLimit.track(certificate, 'renew', () => lego.renew(certificate, internalCertificate.watchCertbot(progress, true, lego), staged || !!certificate.meta.reuse_key)))
+				.then((result) => {
+					logger.info(result);
+					if (staged) {
+						internalCertPins.rotate(certificate);
+					}
+					return result;
+				});
+		}
+
+		const serverArgs = await internalAcmeAccount.getCertbotArgs(certificate);
+
 		let mainCmd = certbotCommand + ' renew --force-renewal ' +
 			`--config "${letsencryptConfig}" ` +
+			`--config-dir '${config.getPath('letsencrypt')}' ` +
 			'--work-dir "/tmp/letsencrypt-lib" ' +
 			'--logs-dir "/tmp/letsencrypt-log" ' +
 			`--cert-name 'npm-${certificate.id}' ` +
 			'--disable-hook-validation ' +
 			'--no-random-sleep-on-renew ' +
-			(letsencryptServer !== null ? `--server '${letsencryptServer}' ` : '') +
-			(letsencryptStaging && letsencryptServer === null ? '--staging ' : '');
+			internalCertificate.getPreferredChainArg(certificate) +
+			internalCertificate.getReuseKeyArg(certificate) +
+			serverArgs;
 
 		// Prepend the path to the credentials file as an environment variable
 		if (certificate.meta.dns_provider === 'route53') {
-			const credentialsLocation = '/etc/letsencrypt/credentials/credentials-' + certificate.id;
+			const credentialsLocation = config.getPath('letsencrypt') + '/credentials/credentials-' + certificate.id;
 			mainCmd                   = 'AWS_CONFIG_FILE=\'' + credentialsLocation + '\' ' + mainCmd;
 		}
 
 		logger.info('Command:', mainCmd);
 
-		return utils.exec(mainCmd)
+		return internalDnsThrottle.run(certificate.meta.dns_provider, () => internalAcmeRateLimit.track(certificate, 'renew', () => utils.exec(mainCmd, {onOutput: internalCertificate.watchCertbot(progress, true)})))
 			.then(async (result) => {
 				logger.info(result);
 				return result;
@@ -1030,20 +1796,46 @@ const internalCertificate = {
 	 * @param   {Boolean} [throw_errors]
 	 * @returns {Promise}
 	 */
-	revokeLetsEncryptSsl: (certificate, throw_errors) => {
+	revokeLetsEncryptSsl: async (certificate, throw_errors) => {
 		logger.info('Revoking Let\'sEncrypt certificates for Cert #' + certificate.id + ': ' + certificate.domain_names.join(', '));
 
+		if (certificate.meta.acme_client === 'lego') {
+			return lego.revoke(certificate)
+				.then((result) => {
+					logger.info(result);
+					return result;
+				})
+				.catch((err) => {
+					logger.error(err.message);
+
+					if (throw_errors) {
+						throw err;
+					}
+				});
+		}
+
+		let serverArgs;
+		try {
+			serverArgs = await internalAcmeAccount.getCertbotArgs(certificate);
+		} catch (err) {
+			logger.error(err.message);
+			if (throw_errors) {
+				throw err;
+			}
+			return;
+		}
+
 		const mainCmd = certbotCommand + ' revoke ' +
 			`--config '${letsencryptConfig}' ` +
+			`--config-dir '${config.getPath('letsencrypt')}' ` +
 			'--work-dir "/tmp/letsencrypt-lib" ' +
 			'--logs-dir "/tmp/letsencrypt-log" ' +
-			`--cert-path '/etc/letsencrypt/live/npm-${certificate.id}/fullchain.pem' ` +
+			`--cert-path '${internalCertStorage.getDirectory(certificate)}/fullchain.pem' ` +
 			'--delete-after-revoke ' +
-			(letsencryptServer !== null ? `--server '${letsencryptServer}' ` : '') +
-			(letsencryptStaging && letsencryptServer === null ? '--staging ' : '');
+			serverArgs;
 
 		// Don't fail command if file does not exist
-		const delete_credentialsCmd = `rm -f '/etc/letsencrypt/credentials/credentials-${certificate.id}' || true`;
+		const delete_credentialsCmd = `rm -f '${config.getPath('letsencrypt')}/credentials/credentials-${certificate.id}' || true`;
 
 		logger.info('Command:', mainCmd + '; ' + delete_credentialsCmd);
 
@@ -1067,7 +1859,7 @@ const internalCertificate = {
 	 * @returns {Boolean}
 	 */
 	hasLetsEncryptSslCerts: (certificate) => {
-		const letsencryptPath = '/etc/letsencrypt/live/npm-' + certificate.id;
+		const letsencryptPath = internalCertStorage.getDirectory(certificate);
 
 		return fs.existsSync(letsencryptPath + '/fullchain.pem') && fs.existsSync(letsencryptPath + '/privkey.pem');
 	},
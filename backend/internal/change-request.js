@@ -0,0 +1,497 @@
+const _                       = require('lodash');
+const error                   = require('../lib/error');
+const utils                   = require('../lib/utils');
+const apiValidator            = require('../lib/validator/api');
+const schema                  = require('../schema');
+const changeRequestModel      = require('../models/change_request');
+const settingModel            = require('../models/setting');
+const internalAuditLog        = require('./audit-log');
+const internalTenant          = require('./tenant');
+const internalProxyHost       = require('./proxy-host');
+const internalRedirectionHost = require('./redirection-host');
+const internalDeadHost        = require('./dead-host');
+const internalCertificate     = require('./certificate');
+
+/**
+ * What changes can be proposed or scheduled, the module that makes them, and the API paths their
+ * payloads are validated against beforehand
+ */
+const TYPES = {
+	'proxy-host': {
+		module:     internalProxyHost,
+		path:       '/nginx/proxy-hosts',
+		permission: 'proxy_hosts',
+		param:      'host_id',
+		actions:    ['create', 'update', 'delete']
+	},
+	'redirection-host': {
+		module:     internalRedirectionHost,
+		path:       '/nginx/redirection-hosts',
+		permission: 'redirection_hosts',
+		param:      'host_id',
+		actions:    ['create', 'update', 'delete']
+	},
+	'dead-host': {
+		module:     internalDeadHost,
+		path:       '/nginx/dead-hosts',
+		permission: 'dead_hosts',
+		param:      'host_id',
+		actions:    ['create', 'update', 'delete']
+	},
+	'certificate': {
+		module:     internalCertificate,
+		path:       '/nginx/certificates',
+		permission: 'certificates',
+		param:      'certificate_id',
+		actions:    ['create', 'delete']
+	}
+};
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalChangeRequest = {
+
+	types: TYPES,
+
+	/**
+	 * Changes need approval when the change-requests setting is on, except from approvers
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}  resolves with a boolean
+	 */
+	isRequired: (access) => {
+		return settingModel
+			.query()
+			.where('id', 'change-requests')
+			.first()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return false;
+				}
+
+				return internalChangeRequest.canApprove(access)
+					.then((can_approve) => {
+						return !can_approve;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}  resolves with a boolean
+	 */
+	canApprove: (access) => {
+		return access.can('change_requests:approve')
+			.then(() => {
+				return true;
+			})
+			.catch((err) => {
+				if (err instanceof error.PermissionError) {
+					return false;
+				}
+				throw err;
+			});
+	},
+
+	/**
+	 * Proposes the change instead of making it, when the user's changes need approval
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  key of TYPES
+	 * @param   {String}  action       'create', 'update' or 'delete'
+	 * @param   {Object}  params       of the request, with the id of the object for updates and deletes
+	 * @param   {Object}  body         of the request
+	 * @returns {Promise}  resolves with the change request, or null when the change can be made now
+	 */
+	submit: (access, object_type, action, params, body) => {
+		return internalChangeRequest.isRequired(access)
+			.then((required) => {
+				if (!required) {
+					return null;
+				}
+
+				return access.can('change_requests:create')
+					.catch((err) => {
+						if (err instanceof error.PermissionError) {
+							throw new error.PermissionError('Changes need to be approved, and you can\'t propose them');
+						}
+						throw err;
+					})
+					.then(() => {
+						return internalChangeRequest.prepare(access, object_type, action, params, body);
+					})
+					.then((change) => {
+						return internalChangeRequest.create(access, _.assign({
+							object_type: object_type,
+							action:      action
+						}, change));
+					});
+			});
+	},
+
+	/**
+	 * Validates a change like the API would when it's made, to find problems before it's reviewed or scheduled
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  key of TYPES
+	 * @param   {String}  action       'create', 'update' or 'delete'
+	 * @param   {Object}  params       of the request, with the id of the object for updates and deletes
+	 * @param   {Object}  body         of the request
+	 * @returns {Promise}  resolves with {object_id, payload, meta}
+	 */
+	prepare: (access, object_type, action, params, body) => {
+		const type = TYPES[object_type];
+
+		if (type.actions.indexOf(action) === -1) {
+			return Promise.reject(new error.ValidationError('Only ' + type.actions.join(', ') + ' of a ' + object_type.replace('-', ' ') + ' can be proposed or scheduled'));
+		}
+
+		if (action === 'create') {
+			return apiValidator(schema.getValidationSchema(type.path, 'post'), body)
+				.then((payload) => {
+					return {object_id: 0, payload: payload, meta: {}};
+				});
+		}
+
+		const object_id = parseInt(params[type.param], 10);
+
+		// They have to be able to see what they're changing, and when it was changed is kept
+		// to notice changes made after it was proposed
+		return type.module.get(access, {id: object_id})
+			.then((current) => {
+				const change = {
+					object_id: object_id,
+					payload:   {},
+					meta:      {modified_on: current.modified_on}
+				};
+
+				if (action === 'delete') {
+					return change;
+				}
+
+				return apiValidator(schema.getValidationSchema(type.path + '/{hostID}', 'put'), body)
+					.then((payload) => {
+						change.payload = payload;
+						return change;
+					});
+			});
+	},
+
+	/**
+	 * Makes a change that was proposed or scheduled
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  row
+	 * @param   {String}  row.object_type
+	 * @param   {String}  row.action
+	 * @param   {Number}  row.object_id
+	 * @param   {Object}  row.payload
+	 * @returns {Promise}  resolves with what the module returned
+	 */
+	apply: (access, row) => {
+		const type = TYPES[row.object_type];
+
+		switch (row.action) {
+		case 'create':
+			return type.module.create(access, row.payload);
+		case 'update':
+			return type.module.update(access, _.assign({id: row.object_id}, row.payload));
+		case 'delete':
+			return type.module.delete(access, {id: row.object_id});
+		}
+
+		return Promise.reject(new error.ValidationError('Unknown action: ' + row.action));
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.object_type
+	 * @param   {String}  data.action
+	 * @param   {Number}  [data.object_id]  for updates and deletes
+	 * @param   {Object}  data.payload
+	 * @param   {Object}  [data.meta]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('change_requests:create', data)
+			.then(() => {
+				return changeRequestModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						object_type:   data.object_type,
+						object_id:     data.object_id || 0,
+						action:        data.action,
+						status:        'pending',
+						payload:       data.payload,
+						meta:          data.meta || {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'change-request',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * Makes the change, as the approver
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.comment]
+	 * @returns {Promise}
+	 */
+	approve: (access, data) => {
+		return access.can('change_requests:approve', data.id)
+			.then(() => {
+				return internalChangeRequest.getPending(access, data.id);
+			})
+			.then((row) => {
+				return internalChangeRequest.assertUnchanged(access, row)
+					.then(() => {
+						return internalChangeRequest.apply(access, row);
+					})
+					.then((result) => {
+						let meta = _.assign({}, row.meta, {comment: data.comment || ''});
+						if (row.action === 'create') {
+							meta.result_id = result.id;
+						}
+
+						return internalChangeRequest.review(access, row, 'approved', meta);
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.comment]
+	 * @returns {Promise}
+	 */
+	reject: (access, data) => {
+		return access.can('change_requests:approve', data.id)
+			.then(() => {
+				return internalChangeRequest.getPending(access, data.id);
+			})
+			.then((row) => {
+				return internalChangeRequest.review(access, row, 'rejected', _.assign({}, row.meta, {comment: data.comment || ''}));
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  row
+	 * @param   {String}  status
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	review: (access, row, status, meta) => {
+		return changeRequestModel
+			.query()
+			.patchAndFetchById(row.id, {
+				status:           status,
+				reviewer_user_id: access.token.getUserId(1),
+				meta:             meta
+			})
+			.then(utils.omitRow(omissions()))
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      status,
+					object_type: 'change-request',
+					object_id:   saved_row.id,
+					meta:        saved_row
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * A change request that can be reviewed, by someone other than who proposed it
+	 *
+	 * @param   {Access}  access
+	 * @param   {Number}  id
+	 * @returns {Promise}
+	 */
+	getPending: (access, id) => {
+		return internalChangeRequest.get(access, {id: id})
+			.then((row) => {
+				if (row.status !== 'pending') {
+					throw new error.ValidationError('Change request has already been ' + row.status);
+				}
+				if (row.owner_user_id === access.token.getUserId(0)) {
+					throw new error.PermissionError('Change requests have to be reviewed by someone else');
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * Refuses to apply an update or delete when the object has changed since it was proposed,
+	 * because what was reviewed isn't what would happen
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  row
+	 * @returns {Promise}
+	 */
+	assertUnchanged: (access, row) => {
+		if (row.action === 'create') {
+			return Promise.resolve();
+		}
+
+		return TYPES[row.object_type].module.get(access, {id: row.object_id})
+			.then((current) => {
+				if (new Date(current.modified_on).getTime() !== new Date(row.meta.modified_on).getTime()) {
+					throw new error.ValidationError('The ' + row.object_type.replace('-', ' ') + ' has changed since this was proposed, reject it and propose it again');
+				}
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('change_requests:get', data.id)
+			.then((access_data) => {
+				return internalChangeRequest.getVisibilityFilter(access, access_data);
+			})
+			.then((filter) => {
+				let query = changeRequestModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.allowGraph('[owner,reviewer]')
+					.first();
+
+				if (filter) {
+					query.andWhere(filter);
+				}
+
+				if (typeof data.expand !== 'undefined' && data.expand !== null) {
+					query.withGraphFetched('[' + data.expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * What the change would do, field by field, compared to the object as it is now
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  row
+	 * @returns {Promise}  resolves with [{field, from, to}]
+	 */
+	getDiff: (access, row) => {
+		const current = row.action === 'create' || row.status !== 'pending'
+			? Promise.resolve({})
+			: TYPES[row.object_type].module.get(access, {id: row.object_id}).catch(() => ({}));
+
+		return current.then((current) => {
+			if (row.action === 'delete') {
+				return _.map(_.pick(current, ['domain_names', 'nice_name', 'forward_host', 'forward_port', 'forward_domain_name']), (value, field) => {
+					return {field: field, from: value, to: null};
+				});
+			}
+
+			return _.keys(row.payload)
+				.filter((field) => !_.isEqual(current[field], row.payload[field]))
+				.map((field) => {
+					return {
+						field: field,
+						from:  typeof current[field] === 'undefined' ? null : current[field],
+						to:    row.payload[field]
+					};
+				});
+		});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  [filters]
+	 * @param   {String}  [filters.status]
+	 * @param   {Array}   [expand]
+	 * @returns {Promise}
+	 */
+	getAll: (access, filters, expand) => {
+		filters = filters || {};
+
+		return access.can('change_requests:list')
+			.then((access_data) => {
+				return internalChangeRequest.getVisibilityFilter(access, access_data);
+			})
+			.then((filter) => {
+				let query = changeRequestModel
+					.query()
+					.where('is_deleted', 0)
+					.allowGraph('[owner,reviewer]')
+					.orderBy('created_on', 'DESC')
+					.orderBy('id', 'DESC');
+
+				if (filter) {
+					query.andWhere(filter);
+				}
+
+				if (filters.status) {
+					query.andWhere('status', filters.status);
+				}
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * Approvers see every change request in their tenant, everyone else only their own
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  access_data
+	 * @returns {Promise}  resolves with a where clause, or null for everything
+	 */
+	getVisibilityFilter: (access, access_data) => {
+		const tenant = internalTenant.getFilter(access_data);
+
+		return internalChangeRequest.canApprove(access)
+			.then((can_approve) => {
+				if (can_approve) {
+					return tenant;
+				}
+
+				const user_id = access.token.getUserId(1);
+				return function () {
+					this.where('owner_user_id', user_id);
+				};
+			});
+	}
+};
+
+module.exports = internalChangeRequest;
@@ -0,0 +1,107 @@
+const _            = require('lodash');
+const fs           = require('fs');
+const path         = require('path');
+const logger       = require('../logger').nginx;
+const settingModel = require('../models/setting');
+
+const modulesDir = '/etc/nginx/modules';
+
+/**
+ * Used for anything not given in the setting or on the host
+ */
+const DEFAULTS = {
+	gzip:       true,
+	brotli:     false,
+	level:      5,
+	min_length: 1024,
+	types:      [
+		'text/plain',
+		'text/css',
+		'text/xml',
+		'text/javascript',
+		'application/javascript',
+		'application/json',
+		'application/xml',
+		'application/rss+xml',
+		'application/atom+xml',
+		'image/svg+xml'
+	]
+};
+
+let brotli_available = null;
+
+const internalCompression = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'compression')
+			.first();
+	},
+
+	/**
+	 * The brotli module isn't built into nginx, it has to be loaded from the modules dir
+	 *
+	 * @returns {Boolean}
+	 */
+	brotliAvailable: () => {
+		if (brotli_available === null) {
+			try {
+				brotli_available = fs.readdirSync(modulesDir)
+					.filter((name) => name.endsWith('.conf'))
+					.some((name) => fs.readFileSync(path.join(modulesDir, name), {encoding: 'utf8'}).indexOf('brotli') !== -1);
+			} catch (err) {
+				brotli_available = false;
+			}
+		}
+		return brotli_available;
+	},
+
+	/**
+	 * What to write into a host's config. The host's own settings win over the global ones,
+	 * and with neither, nothing is written and the nginx.conf defaults apply.
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (setting, host) => {
+		let options = null;
+
+		if (setting && setting.value === 'custom') {
+			options = _.assign({}, DEFAULTS, setting.meta);
+		}
+
+		if (host.compression && !_.isEmpty(host.compression)) {
+			options = _.assign({}, options || DEFAULTS, host.compression);
+		}
+
+		if (options === null) {
+			return null;
+		}
+
+		// nginx always compresses text/html and warns when it's listed
+		options.types = _.without(options.types, 'text/html');
+
+		if (options.brotli && !internalCompression.brotliAvailable()) {
+			logger.warn('Brotli compression is turned on but nginx doesn\'t have the module, using gzip only');
+			options.brotli = false;
+		}
+
+		return options;
+	},
+
+	/**
+	 * Writes the config for every enabled host without its own settings again, after the global setting has changed
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return require('./host').regenerateConfigs((host) => _.isEmpty(host.compression));
+	}
+};
+
+module.exports = internalCompression;
@@ -0,0 +1,226 @@
+const _                      = require('lodash');
+const fs                     = require('fs');
+const http                   = require('http');
+const moment                 = require('moment');
+const config                 = require('../lib/config');
+const error                  = require('../lib/error');
+const logger                 = require('../logger').global;
+const proxyHostModel         = require('../models/proxy_host');
+const redirectionHostModel   = require('../models/redirection_host');
+const deadHostModel          = require('../models/dead_host');
+const internalAnalytics      = require('./analytics');
+const internalLatency        = require('./latency');
+const internalSetting        = require('./setting');
+const internalBlockedClients = require('./blocked-clients');
+
+const logDir = config.getPath('logs');
+
+// nginx's stub_status, served only on this socket by conf.d/status.conf
+const STATUS_SOCKET = '/run/nginx/status.sock';
+
+// Only the end of each log is read, what's older than a few minutes isn't current anyway
+const MAX_READ = 2 * 1024 * 1024;
+
+// ie: proxy-host-1_access.log
+const ACCESS_LOG = /^(proxy-host|redirection-host|dead-host)-(\d+)_access\.log$/;
+
+const HOST_MODELS = {
+	'proxy-host':       proxyHostModel,
+	'redirection-host': redirectionHostModel,
+	'dead-host':        deadHostModel
+};
+
+const internalConnections = {
+
+	/**
+	 * @param   {String}  text
+	 * @returns {Object|null}
+	 */
+	parseStatus: (text) => {
+		const active   = text.match(/Active connections:\s*(\d+)/);
+		const counters = text.match(/\n\s*(\d+)\s+(\d+)\s+(\d+)\s*\n/);
+		const states   = text.match(/Reading:\s*(\d+)\s+Writing:\s*(\d+)\s+Waiting:\s*(\d+)/);
+
+		if (!active || !counters || !states) {
+			return null;
+		}
+
+		return {
+			active:   parseInt(active[1], 10),
+			reading:  parseInt(states[1], 10),
+			writing:  parseInt(states[2], 10),
+			waiting:  parseInt(states[3], 10),
+			accepts:  parseInt(counters[1], 10),
+			handled:  parseInt(counters[2], 10),
+			requests: parseInt(counters[3], 10)
+		};
+	},
+
+	/**
+	 * @returns {Promise}  resolves with the connection counts of nginx, or null when they can't be read
+	 */
+	getStatus: () => {
+		return new Promise((resolve) => {
+			const req = http.get({socketPath: STATUS_SOCKET, path: '/status', timeout: 2000}, (res) => {
+				let body = '';
+				res.setEncoding('utf8');
+				res.on('data', (chunk) => body += chunk);
+				res.on('end', () => {
+					resolve(res.statusCode === 200 ? internalConnections.parseStatus(body) : null);
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('timed out'));
+			});
+			req.on('error', (err) => {
+				logger.warn('Could not read the nginx status: ' + err.message);
+				resolve(null);
+			});
+		});
+	},
+
+	/**
+	 * Requests each host had in the last seconds, from the end of its access log
+	 *
+	 * @param   {moment}  since
+	 * @returns {Array}   ie: [{object_type, object_id, items: [parsed lines]}]
+	 */
+	getRecent: (since) => {
+		if (!fs.existsSync(logDir)) {
+			return [];
+		}
+
+		return fs.readdirSync(logDir)
+			.map((name) => {
+				const file = name.match(ACCESS_LOG);
+				if (!file) {
+					return null;
+				}
+
+				const items = internalLatency.readTail(logDir + '/' + name, MAX_READ)
+					.map(internalAnalytics.parseLine)
+					.filter((item) => item && item.time.isSameOrAfter(since));
+
+				return items.length ? {object_type: file[1], object_id: parseInt(file[2], 10), items: items} : null;
+			})
+			.filter((host) => host !== null);
+	},
+
+	/**
+	 * What nginx is doing right now: its open connections, and which hosts and clients
+	 * the requests of the last seconds were for
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  [data]
+	 * @param   {Number}  [data.seconds]  how far back requests are counted, 60 by default
+	 * @param   {Number}  [data.clients]  the busiest clients listed for each host, 5 by default
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		data = data || {};
+
+		const seconds = data.seconds || 60;
+		const since   = moment().subtract(seconds, 'seconds');
+
+		return access.can('nginx:connections')
+			.then(() => {
+				return Promise.all([
+					internalConnections.getStatus(),
+					internalBlockedClients.getSetting()
+				]);
+			})
+			.then(([status, setting]) => {
+				const blocked = internalBlockedClients.getAddresses(setting);
+				const recent  = internalConnections.getRecent(since);
+
+				return Promise.all(recent.map((host) => {
+					return HOST_MODELS[host.object_type]
+						.query()
+						.where('id', host.object_id)
+						.andWhere('is_deleted', 0)
+						.first();
+				}))
+					.then((rows) => {
+						const hosts = recent
+							.map((host, index) => {
+								if (!rows[index]) {
+									return null;
+								}
+
+								const clients = _.map(_.groupBy(host.items, 'client'), (items, address) => {
+									return {
+										address:  address,
+										requests: items.length,
+										bytes:    _.sumBy(items, 'bytes'),
+										blocked:  blocked.indexOf(address) !== -1
+									};
+								});
+
+								return {
+									object_type:  host.object_type,
+									object_id:    host.object_id,
+									domain_names: rows[index].domain_names,
+									requests:     host.items.length,
+									rate:         _.round(host.items.length / seconds, 2),
+									bytes:        _.sumBy(host.items, 'bytes'),
+									clients:      clients.length,
+									top_clients:  _.orderBy(clients, ['requests', 'bytes'], ['desc', 'desc']).slice(0, data.clients || 5)
+								};
+							})
+							.filter((host) => host !== null);
+
+						return {
+							since:   since.toISOString(),
+							seconds: seconds,
+							nginx:   status,
+							hosts:   _.orderBy(hosts, ['requests'], ['desc']),
+							blocked: blocked
+						};
+					});
+			});
+	},
+
+	/**
+	 * Refuses a client on every host from now on, by adding it to the blocked-clients setting
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.address  ie: 203.0.113.7 or 203.0.113.0/24
+	 * @param   {String}  [data.note]
+	 * @returns {Promise}  resolves with the setting
+	 */
+	block: (access, data) => {
+		if (!internalBlockedClients.isValidAddress(data.address)) {
+			return Promise.reject(new error.ValidationError('Not an IP address or range: ' + data.address));
+		}
+
+		return access.can('nginx:connections')
+			.then(() => {
+				return internalBlockedClients.getSetting();
+			})
+			.then((setting) => {
+				let clients = (setting && setting.meta && setting.meta.clients) || [];
+
+				if (setting && setting.value === 'on' && _.find(clients, {address: data.address})) {
+					return setting;
+				}
+
+				clients = _.reject(clients, {address: data.address}).concat([{
+					address:    data.address,
+					note:       data.note || '',
+					blocked_on: moment().toISOString()
+				}]);
+
+				logger.warn('Blocking ' + data.address + (data.note ? ': ' + data.note : ''));
+
+				return internalSetting.update(access, {
+					id:    'blocked-clients',
+					value: 'on',
+					meta:  {clients: clients}
+				});
+			});
+	}
+};
+
+module.exports = internalConnections;
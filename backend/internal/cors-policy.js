@@ -0,0 +1,87 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+const DEFAULT_METHODS = ['GET', 'POST', 'PUT', 'PATCH', 'DELETE', 'OPTIONS'];
+const DEFAULT_MAX_AGE = 600;
+
+/**
+ * The regex nginx matches the Origin header with, ie: https://*.example.com matches any subdomain
+ *
+ * @param   {String}  origin
+ * @returns {String}
+ */
+const originRegex = (origin) => {
+	if (origin === '*') {
+		return '~.';
+	}
+
+	return '~*^' + _.escapeRegExp(origin.replace(/\/+$/, '')).replace('\\*\\.', '[a-z0-9-]+(\\.[a-z0-9-]+)*\\.') + '$';
+};
+
+const internalCorsPolicy = {
+
+	/**
+	 * Browsers don't send cookies to an API that allows every origin, and reflecting any origin
+	 * with credentials would let every site use them
+	 *
+	 * @param   {Object}  data  payload
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		const policies = [data.cors].concat(_.map(data.locations || [], 'cors'));
+
+		if (policies.some((cors) => cors && cors.credentials && (cors.origins || []).indexOf('*') !== -1)) {
+			return Promise.reject(new error.ValidationError('CORS credentials can only be allowed for a list of origins, not *'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What to write into the config of a proxy host for the CORS policies of the host and its custom locations.
+	 * Custom locations use the policy of the host unless they have their own, one without origins has none.
+	 * The Origin header is matched in a map, so a request from another origin doesn't get the headers at all.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object}  {maps: [policy], server: policy|null, locations: [policy|null]}
+	 */
+	getOptions: (host) => {
+		let maps = [];
+
+		const build = (cors, variable) => {
+			if (!cors || !cors.origins || !cors.origins.length) {
+				return null;
+			}
+
+			const policy = {
+				variable:       variable,
+				origins:        _.uniq(cors.origins).map(originRegex),
+				methods:        (cors.methods && cors.methods.length ? cors.methods : DEFAULT_METHODS).join(', '),
+				// The headers the browser asks for are allowed when there isn't a list
+				headers:        cors.headers && cors.headers.length ? cors.headers.join(', ') : null,
+				expose_headers: cors.expose_headers && cors.expose_headers.length ? cors.expose_headers.join(', ') : null,
+				max_age:        typeof cors.max_age === 'number' ? cors.max_age : DEFAULT_MAX_AGE,
+				credentials:    !!cors.credentials,
+				preflight:      cors.preflight !== false
+			};
+
+			maps.push(policy);
+			return policy;
+		};
+
+		const server = build(host.cors, 'proxy_host_' + host.id + '_cors');
+
+		return {
+			maps:      maps,
+			server:    server,
+			locations: (host.locations || []).map((location, index) => {
+				if (typeof location.cors === 'undefined' || location.cors === null) {
+					return server;
+				}
+				return build(location.cors, 'proxy_host_' + host.id + '_cors_' + index);
+			})
+		};
+	}
+};
+
+module.exports = internalCorsPolicy;
@@ -0,0 +1,336 @@
+const _                     = require('lodash');
+const fs                    = require('fs');
+const https                 = require('https');
+const moment                = require('moment');
+const logger                = require('../logger').ct_monitor;
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const ctLogEntryModel       = require('../models/ct_log_entry');
+const certificateModel      = require('../models/certificate');
+const proxyHostModel        = require('../models/proxy_host');
+const redirectionHostModel  = require('../models/redirection_host');
+const deadHostModel         = require('../models/dead_host');
+const settingModel          = require('../models/setting');
+const tokenModel            = require('../models/token');
+const internalAuditLog      = require('./audit-log');
+const internalCertStorage   = require('./certificate-storage');
+const internalNotifications = require('./notifications');
+
+const DEFAULT_API_URL = 'https://crt.sh';
+
+const internalCtMonitor = {
+
+	intervalTimeout:    1000 * 60 * 60 * 6, // 6 hours
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Certificate Transparency Timer initialized');
+		internalCtMonitor.interval = setInterval(internalCtMonitor.processLogs, internalCtMonitor.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'ct-monitor')
+			.first();
+	},
+
+	/**
+	 * Triggered by a timer, this will look up every managed domain in the CT logs
+	 * and raise an alert for any certificate that wasn't issued by us.
+	 *
+	 * @param   {Boolean}  [force]  Run even when the setting is off
+	 * @returns {Promise}
+	 */
+	processLogs: (force) => {
+		if (internalCtMonitor.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalCtMonitor.intervalProcessing = true;
+
+		return internalCtMonitor.getSetting()
+			.then((setting) => {
+				if (!setting || (setting.value !== 'on' && force !== true)) {
+					return false;
+				}
+
+				const api_url = (setting.meta && setting.meta.api_url) || DEFAULT_API_URL;
+				logger.info('Checking Certificate Transparency logs at ' + api_url + ' ...');
+
+				return Promise.all([
+					internalCtMonitor.getManagedDomains(),
+					internalCtMonitor.getLocalSerials()
+				])
+					.then(([domains, serials]) => {
+						let sequence = Promise.resolve();
+
+						domains.forEach((domain) => {
+							sequence = sequence.then(() => {
+								return internalCtMonitor.checkDomain(api_url, domain, serials)
+									.catch((err) => {
+										// Don't want to stop the train here, just log the error
+										logger.error('CT lookup for ' + domain + ' failed: ' + err.message);
+									});
+							});
+						});
+
+						return sequence;
+					})
+					.then(() => {
+						logger.info('Completed Certificate Transparency check');
+						return true;
+					});
+			})
+			.then((result) => {
+				internalCtMonitor.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalCtMonitor.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * All the domain names used by hosts and certificates, without wildcard prefixes
+	 *
+	 * @returns {Promise}
+	 */
+	getManagedDomains: () => {
+		return Promise.all([
+			proxyHostModel.query().where('is_deleted', 0),
+			redirectionHostModel.query().where('is_deleted', 0),
+			deadHostModel.query().where('is_deleted', 0),
+			certificateModel.query().where('is_deleted', 0)
+		])
+			.then((results) => {
+				let domains = [];
+				results.map((rows) => {
+					rows.map((row) => {
+						domains = domains.concat(row.domain_names || []);
+					});
+				});
+
+				return _.uniq(domains.map((domain) => {
+					return domain.toLowerCase().replace(/^\*\./, '');
+				})).sort();
+			});
+	},
+
+	/**
+	 * Serial numbers of every certificate we currently have on disk, in lower case hex without leading zeros
+	 *
+	 * @returns {Promise}
+	 */
+	getLocalSerials: () => {
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.then((certificates) => {
+				let serials  = [];
+				let sequence = Promise.resolve();
+
+				certificates.forEach((certificate) => {
+					const file = internalCertStorage.getDirectory(certificate) + '/fullchain.pem';
+
+					if (!fs.existsSync(file)) {
+						return;
+					}
+
+					sequence = sequence.then(() => {
+						return utils.exec('openssl x509 -in ' + file + ' -serial -noout')
+							.then((result) => {
+								// serial=03A1B2C3D4...
+								const match = /serial=([0-9A-F]+)/i.exec(result);
+								if (match) {
+									serials.push(internalCtMonitor.normaliseSerial(match[1]));
+								}
+							})
+							.catch((err) => {
+								logger.warn('Could not read serial of ' + file + ': ' + err.message);
+							});
+					});
+				});
+
+				return sequence.then(() => {
+					return serials;
+				});
+			});
+	},
+
+	/**
+	 * @param   {String}  serial
+	 * @returns {String}
+	 */
+	normaliseSerial: (serial) => {
+		return serial.toLowerCase().replace(/[^0-9a-f]/g, '').replace(/^0+/, '');
+	},
+
+	/**
+	 * @param   {String}  api_url
+	 * @param   {String}  domain
+	 * @returns {Promise}
+	 */
+	fetchEntries: (api_url, domain) => {
+		return new Promise((resolve, reject) => {
+			const url = api_url.replace(/\/+$/, '') + '/?q=' + encodeURIComponent(domain) + '&output=json&exclude=expired';
+
+			https.get(url, {timeout: 60000}, (res) => {
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					if (res.statusCode !== 200) {
+						reject(new Error('CT log API returned ' + res.statusCode));
+						return;
+					}
+
+					try {
+						const entries = JSON.parse(raw_data || '[]');
+						resolve(Array.isArray(entries) ? entries : []);
+					} catch (err) {
+						reject(new Error('CT log API returned invalid JSON'));
+					}
+				});
+			})
+				.on('timeout', function () {
+					this.destroy(new Error('CT log API timed out'));
+				})
+				.on('error', (err) => {
+					reject(err);
+				});
+		});
+	},
+
+	/**
+	 * Stores any new log entries for the domain. The first time a domain is seen the entries
+	 * become the baseline, after that an unknown certificate raises an alert.
+	 *
+	 * @param   {String}  api_url
+	 * @param   {String}  domain
+	 * @param   {Array}   serials
+	 * @returns {Promise}
+	 */
+	checkDomain: (api_url, domain, serials) => {
+		return Promise.all([
+			internalCtMonitor.fetchEntries(api_url, domain),
+			ctLogEntryModel
+				.query()
+				.select('ct_id')
+				.where('domain', domain)
+		])
+			.then(([entries, existing]) => {
+				// Domains we haven't seen before accept everything already in the logs,
+				// except for anything issued in the last day
+				const first_run = !existing.length;
+				const cutoff    = moment().subtract(1, 'day');
+				const seen      = existing.map((row) => row.ct_id);
+
+				let sequence = Promise.resolve();
+
+				entries.forEach((entry) => {
+					const ct_id = String(entry.id);
+					if (seen.indexOf(ct_id) !== -1) {
+						return;
+					}
+					seen.push(ct_id);
+
+					const is_known = serials.indexOf(internalCtMonitor.normaliseSerial(String(entry.serial_number || ''))) !== -1;
+					const baseline = first_run && moment.utc(entry.not_before).isBefore(cutoff);
+
+					sequence = sequence.then(() => {
+						return ctLogEntryModel
+							.query()
+							.insertAndFetch({
+								domain:        domain,
+								ct_id:         ct_id,
+								serial_number: String(entry.serial_number || ''),
+								issuer_name:   String(entry.issuer_name || '').substring(0, 255),
+								common_name:   String(entry.common_name || '').substring(0, 255),
+								not_before:    moment.utc(entry.not_before).format('YYYY-MM-DD HH:mm:ss'),
+								not_after:     moment.utc(entry.not_after).format('YYYY-MM-DD HH:mm:ss'),
+								is_known:      is_known,
+								meta:          {
+									names:    String(entry.name_value || '').split('\n'),
+									baseline: baseline
+								}
+							})
+							.then((row) => {
+								if (!is_known && !baseline) {
+									return internalCtMonitor.alert(row);
+								}
+							});
+					});
+				});
+
+				return sequence;
+			});
+	},
+
+	/**
+	 * @param   {Object}  row  ct_log_entry
+	 * @returns {Promise}
+	 */
+	alert: (row) => {
+		const message = 'Unknown certificate for ' + row.domain + ' found in CT logs, issued by ' + row.issuer_name + ' (serial ' + row.serial_number + ')';
+		logger.warn(message);
+		internalNotifications.send('ct-monitor', 'critical', message);
+
+		return internalAuditLog.add({token: new tokenModel()}, {
+			action:      'alerted',
+			object_type: 'ct-log-entry',
+			object_id:   row.id,
+			meta:        row
+		});
+	},
+
+	/**
+	 * @param   {Access}   access
+	 * @param   {Object}   [data]
+	 * @param   {Boolean}  [data.unknown]  Only return certificates that weren't issued by us
+	 * @returns {Promise}
+	 */
+	getAll: (access, data) => {
+		return access.can('certificates:transparency')
+			.then(() => {
+				let query = ctLogEntryModel
+					.query()
+					.orderBy('not_before', 'DESC')
+					.orderBy('id', 'DESC')
+					.limit(500);
+
+				if (data && data.unknown) {
+					query.where('is_known', 0);
+				}
+
+				return query;
+			});
+	},
+
+	/**
+	 * Runs the check now, whether or not the timer is enabled
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	check: (access) => {
+		return access.can('certificates:transparency')
+			.then(() => {
+				if (internalCtMonitor.intervalProcessing) {
+					throw new error.ValidationError('A Certificate Transparency check is already running');
+				}
+
+				return internalCtMonitor.processLogs(true);
+			});
+	}
+};
+
+module.exports = internalCtMonitor;
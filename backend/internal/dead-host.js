@@ -1,12 +1,28 @@
-const _                   = require('lodash');
-const error               = require('../lib/error');
-const utils               = require('../lib/utils');
-const deadHostModel       = require('../models/dead_host');
-const internalHost        = require('./host');
-const internalNginx       = require('./nginx');
-const internalAuditLog    = require('./audit-log');
-const internalCertificate = require('./certificate');
-const {castJsonIfNeed}    = require('../lib/helpers');
+const _                     = require('lodash');
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const readCache             = require('../lib/read-cache');
+const deadHostModel         = require('../models/dead_host');
+const internalHost          = require('./host');
+const internalHostDefaults  = require('./host-defaults');
+const internalNginx         = require('./nginx');
+const internalDrain         = require('./drain');
+const internalAuditLog      = require('./audit-log');
+const internalPlugin        = require('./plugin');
+const internalCertificate   = require('./certificate');
+const internalCertMatch     = require('./certificate-match');
+const internalProject       = require('./project');
+const internalQuota         = require('./quota');
+const internalTag           = require('./tag');
+const internalLock          = require('./lock');
+const internalProxyProtocol = require('./proxy-protocol');
+const internalListen        = require('./listen');
+const internalRedirectRules = require('./redirect-rules');
+const internalView          = require('./view');
+const {castJsonIfNeed}      = require('../lib/helpers');
+
+// What lists are read from, a cached list is dropped when one of them is written to
+const LIST_TABLES = ['dead_host', 'user', 'certificate', 'project', 'project_permission', 'user_permission'];
 
 function omissions () {
 	return ['is_deleted'];
@@ -20,29 +36,41 @@ const internalDeadHost = {
 	 * @returns {Promise}
 	 */
 	create: (access, data) => {
-		let create_certificate = data.certificate_id === 'new';
-
-		if (create_certificate) {
-			delete data.certificate_id;
-		}
+		let create_certificate = false;
 
 		return access.can('dead_hosts:create', data)
-			.then((/*access_data*/) => {
-				// Get a list of the domain names and check each of them against existing records
-				let domain_name_check_promises = [];
+			.then(() => {
+				return internalQuota.check(access, 'dead_hosts');
+			})
+			.then(() => {
+				return internalHostDefaults.apply('dead-host', data);
+			})
+			.then(() => {
+				// A certificate_id of auto becomes the certificate that covers the domain names, or new to request one
+				return internalCertMatch.apply(access, data, internalHost.normaliseDomainNames(data.domain_names));
+			})
+			.then(() => {
+				create_certificate = data.certificate_id === 'new';
 
-				data.domain_names.map(function (domain_name) {
-					domain_name_check_promises.push(internalHost.isHostnameTaken(domain_name));
-				});
+				if (create_certificate) {
+					delete data.certificate_id;
+				}
 
-				return Promise.all(domain_name_check_promises)
-					.then((check_results) => {
-						check_results.map(function (result) {
-							if (result.is_taken) {
-								throw new error.ValidationError(result.hostname + ' is already in use');
-							}
-						});
-					});
+				// Check each of the domain names against the existing records
+				data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+				return internalHost.assertDomainNamesAvailable(data.domain_names);
+			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalRedirectRules.validate(data);
+			})
+			.then(() => {
+				return internalProxyProtocol.prepareCreate('dead-host', data);
+			})
+			.then(() => {
+				return internalPlugin.check('host.saving', {object_type: 'dead-host', action: 'created', host: internalPlugin.getHost(data)});
 			})
 			.then(() => {
 				// At this point the domains should have been checked
@@ -104,6 +132,10 @@ const internalDeadHost = {
 					.then(() => {
 						return row;
 					});
+			})
+			.then((row) => {
+				internalPlugin.notify('host.saved', {object_type: 'dead-host', action: 'created', host: internalPlugin.getHost(row)});
+				return row;
 			});
 	},
 
@@ -122,33 +154,39 @@ const internalDeadHost = {
 
 		return access.can('dead_hosts:update', data.id)
 			.then((/*access_data*/) => {
-				// Get a list of the domain names and check each of them against existing records
-				let domain_name_check_promises = [];
-
+				// Check each of the domain names against the existing records
 				if (typeof data.domain_names !== 'undefined') {
-					data.domain_names.map(function (domain_name) {
-						domain_name_check_promises.push(internalHost.isHostnameTaken(domain_name, 'dead', data.id));
-					});
-
-					return Promise.all(domain_name_check_promises)
-						.then((check_results) => {
-							check_results.map(function (result) {
-								if (result.is_taken) {
-									throw new error.ValidationError(result.hostname + ' is already in use');
-								}
-							});
-						});
+					data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+					return internalHost.assertDomainNamesAvailable(data.domain_names, 'dead', data.id);
 				}
 			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalRedirectRules.validate(data);
+			})
 			.then(() => {
 				return internalDeadHost.get(access, {id: data.id});
 			})
+			.then((row) => {
+				return internalCertMatch.apply(access, data, data.domain_names || row.domain_names, row.meta)
+					.then(() => {
+						if (data.certificate_id === 'new') {
+							create_certificate = true;
+							delete data.certificate_id;
+						}
+						return row;
+					});
+			})
 			.then((row) => {
 				if (row.id !== data.id) {
 					// Sanity check that something crazy hasn't happened
 					throw new error.InternalValidationError('404 Host could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'updated');
+
 				if (create_certificate) {
 					return internalCertificate.createQuickCertificate(access, {
 						domain_names: data.domain_names || row.domain_names,
@@ -165,6 +203,18 @@ const internalDeadHost = {
 					return row;
 				}
 			})
+			.then((row) => {
+				return internalProxyProtocol.prepareUpdate('dead-host', row, data)
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				return internalPlugin.check('host.saving', {object_type: 'dead-host', action: 'updated', host: internalPlugin.getHost(_.assign({}, row, data))})
+					.then(() => {
+						return row;
+					});
+			})
 			.then((row) => {
 				// Add domain_names to the data in case it isn't there, so that the audit log renders correctly. The order is important here.
 				data = _.assign({}, {
@@ -204,6 +254,16 @@ const internalDeadHost = {
 								return _.omit(row, omissions());
 							});
 					});
+			})
+			.then((row) => {
+				return internalProxyProtocol.sync(access, 'dead-host', row, data)
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				internalPlugin.notify('host.saved', {object_type: 'dead-host', action: 'updated', host: internalPlugin.getHost(row)});
+				return row;
 			});
 	},
 
@@ -222,6 +282,9 @@ const internalDeadHost = {
 
 		return access.can('dead_hosts:get', data.id)
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = deadHostModel
 					.query()
 					.where('is_deleted', 0)
@@ -229,8 +292,8 @@ const internalDeadHost = {
 					.allowGraph('[owner,certificate]')
 					.first();
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
@@ -255,6 +318,7 @@ const internalDeadHost = {
 	 * @param {Access}  access
 	 * @param {Object}  data
 	 * @param {Number}  data.id
+	 * @param {Number}  [data.drain]  seconds to answer with a 503 before the config is removed
 	 * @param {String}  [data.reason]
 	 * @returns {Promise}
 	 */
@@ -268,6 +332,8 @@ const internalDeadHost = {
 					throw new error.ItemNotFoundError(data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'deleted');
+
 				return deadHostModel
 					.query()
 					.where('id', row.id)
@@ -275,11 +341,8 @@ const internalDeadHost = {
 						is_deleted: 1
 					})
 					.then(() => {
-						// Delete Nginx Config
-						return internalNginx.deleteConfig('dead_host', row)
-							.then(() => {
-								return internalNginx.reload();
-							});
+						// Delete Nginx Config, after the drain period when there is one
+						return internalDrain.removeConfig('dead_host', row, data.drain, 'deleted');
 					})
 					.then(() => {
 						// Add to audit log
@@ -318,14 +381,14 @@ const internalDeadHost = {
 					throw new error.ValidationError('Host is already enabled');
 				}
 
-				row.enabled = 1;
+				internalLock.assertUnlocked(row, 'enabled');
+
+				_.assign(row, {enabled: 1}, internalHost.getEnabledFields());
 
 				return deadHostModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 1
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
 						// Configure nginx
 						return internalNginx.configure(deadHostModel, 'dead_host', row);
@@ -336,7 +399,7 @@ const internalDeadHost = {
 							action:      'enabled',
 							object_type: 'dead-host',
 							object_id:   row.id,
-							meta:        _.omit(row, omissions())
+							meta:        _.assign(_.omit(row, omissions()), {reason: String(data.reason || '').trim()})
 						});
 					});
 			})
@@ -349,6 +412,7 @@ const internalDeadHost = {
 	 * @param {Access}  access
 	 * @param {Object}  data
 	 * @param {Number}  data.id
+	 * @param {Number}  [data.drain]  seconds to answer with a 503 before the config is removed
 	 * @param {String}  [data.reason]
 	 * @returns {Promise}
 	 */
@@ -364,20 +428,17 @@ const internalDeadHost = {
 					throw new error.ValidationError('Host is already disabled');
 				}
 
-				row.enabled = 0;
+				internalLock.assertUnlocked(row, 'disabled');
+
+				_.assign(row, {enabled: 0}, internalHost.getDisabledFields(access, data.reason));
 
 				return deadHostModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 0
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
-						// Delete Nginx Config
-						return internalNginx.deleteConfig('dead_host', row)
-							.then(() => {
-								return internalNginx.reload();
-							});
+						// Delete Nginx Config, after the drain period when there is one
+						return internalDrain.removeConfig('dead_host', row, data.drain, 'disabled');
 					})
 					.then(() => {
 						// Add to audit log
@@ -397,29 +458,51 @@ const internalDeadHost = {
 	/**
 	 * All Hosts
 	 *
-	 * @param   {Access}  access
-	 * @param   {Array}   [expand]
-	 * @param   {String}  [search_query]
+	 * @param   {Access}    access
+	 * @param   {Array}     [expand]
+	 * @param   {String}    [search_query]
+	 * @param   {Object}    [filter]
+	 * @param   {Number}    [filter.project_id]
+	 * @param   {Array}     [filter.tags]
+	 * @param   {Boolean}   [filter.enabled]
+	 * @param   {Number}    [filter.expires_within_days]
+	 * @param   {Object}    [filter.sort]  {field, direction}
+	 * @param   {Function}  [each]  given the rows a batch at a time instead of resolving with them all
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, filter, each) => {
 		return access.can('dead_hosts:list')
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = deadHostModel
 					.query()
 					.where('is_deleted', 0)
 					.groupBy('id')
 					.allowGraph('[owner,certificate]')
-					.orderBy(castJsonIfNeed('domain_names'), 'ASC');
+					.orderBy(castJsonIfNeed('domain_names'), 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (visibility) {
+					query.andWhere(visibility);
+				}
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (filter && typeof filter.project_id === 'number') {
+					query.andWhere('project_id', filter.project_id);
 				}
 
+				if (filter && filter.tags && filter.tags.length) {
+					internalTag.applyFilter(query, filter.tags);
+				}
+
+				internalView.applyFilter(query, 'dead-host', filter);
+
 				// Query is used for searching
 				if (typeof search_query === 'string' && search_query.length > 0) {
 					query.where(function () {
 						this.where(castJsonIfNeed('domain_names'), 'like', '%' + search_query + '%');
+						this.orWhere('notes', 'like', '%' + search_query + '%');
 					});
 				}
 
@@ -427,14 +510,23 @@ const internalDeadHost = {
 					query.withGraphFetched('[' + expand.join(', ') + ']');
 				}
 
-				return query.then(utils.omitRows(omissions()));
-			})
-			.then((rows) => {
-				if (typeof expand !== 'undefined' && expand !== null && expand.indexOf('certificate') !== -1) {
-					return internalHost.cleanAllRowsCertificateMeta(rows);
+				const clean = (rows) => {
+					rows = utils.omitRows(omissions())(rows);
+
+					if (typeof expand !== 'undefined' && expand !== null && expand.indexOf('certificate') !== -1) {
+						return internalHost.cleanAllRowsCertificateMeta(rows);
+					}
+
+					return rows;
+				};
+
+				if (each) {
+					return utils.eachBatch(query, (rows) => each(clean(rows)));
 				}
 
-				return rows;
+				return readCache.get(JSON.stringify(['dead-host', access.token.getUserId(0), expand, search_query, filter]), LIST_TABLES, () => {
+					return query.then(clean);
+				});
 			});
 	},
 
@@ -455,10 +547,12 @@ const internalDeadHost = {
 			query.andWhere('owner_user_id', user_id);
 		}
 
-		return query.first()
-			.then((row) => {
-				return parseInt(row.count, 10);
-			});
+		return readCache.get(JSON.stringify(['dead-host-count', user_id, visibility]), ['dead_host'], () => {
+			return query.first()
+				.then((row) => {
+					return parseInt(row.count, 10);
+				});
+		});
 	}
 };
 
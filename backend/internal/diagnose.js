@@ -0,0 +1,367 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const net                  = require('net');
+const tls                  = require('tls');
+const http                 = require('http');
+const https                = require('https');
+const helpers              = require('../lib/helpers');
+const internalProxyHost    = require('./proxy-host');
+const internalLatency      = require('./latency');
+const internalUpstreamTls  = require('./upstream-tls');
+const internalDnsResolvers = require('./dns-resolvers');
+const internalUnixSocket   = require('./unix-socket');
+const internalDocker       = require('./docker');
+
+// nginx's own proxy_connect_timeout is 60s, but nobody waits that long for a troubleshooting page
+const TIMEOUT = 10000;
+
+// What the errors of each step usually mean
+const HINTS = {
+	ENOTFOUND:    'The name doesn\'t resolve from inside the container. Use a name on the same Docker network, or an IP address.',
+	EAI_AGAIN:    'DNS lookups are failing from inside the container, check its resolvers.',
+	ECONNREFUSED: 'Nothing is listening on this port. Check the service is running and the port is right.',
+	ETIMEDOUT:    'No answer at all, usually a firewall dropping the traffic or the wrong address.',
+	EHOSTUNREACH: 'There\'s no route to this address from inside the container.',
+	ECONNRESET:   'The connection was closed straight away, often plain http sent to an https port or the other way round.',
+	EPROTO:       'The TLS handshake failed, often an https scheme for a port that only speaks http.',
+	ENOENT:       'The unix socket isn\'t there, check the app is running and its directory is mounted into the container.',
+	EACCES:       'The unix socket can\'t be opened, give the user nginx runs as permission to write to it.'
+};
+
+/**
+ * Runs a step and times it
+ *
+ * @param   {String}    name
+ * @param   {Function}  fn  resolves with the details
+ * @returns {Promise}
+ */
+const step = (name, fn) => {
+	const started = Date.now();
+
+	return fn()
+		.then((detail) => {
+			return {step: name, ok: true, time_ms: Date.now() - started, detail: detail, error: null, hint: null};
+		})
+		.catch((err) => {
+			const code = err.code || null;
+			return {
+				step:    name,
+				ok:      false,
+				time_ms: Date.now() - started,
+				detail:  err.detail || null,
+				error:   err.message,
+				hint:    err.hint || HINTS[code] || null
+			};
+		});
+};
+
+const internalDiagnose = {
+
+	/**
+	 * Where nginx sends a request for the path, the host's forward host or one of its custom locations
+	 *
+	 * @param   {Object}  host
+	 * @param   {String}  path
+	 * @returns {Object}  {location, scheme, host, port, path}
+	 */
+	getUpstream: (host, path) => {
+		const location_path = internalLatency.getLocation(host.locations, path);
+		const location      = _.find(host.locations || [], {path: location_path});
+
+		if (!location) {
+			return {location: null, scheme: host.forward_scheme, host: host.forward_host, port: host.forward_port, path: path};
+		}
+
+		let forward_host = location.forward_host;
+		let forward_path = null;
+		if (!internalUnixSocket.isSocket(forward_host) && forward_host.indexOf('/') !== -1) {
+			const parts  = forward_host.split('/');
+			forward_host = parts.shift();
+			forward_path = '/' + parts.join('/');
+		}
+
+		return {
+			location: location.path,
+			scheme:   location.forward_scheme,
+			host:     forward_host,
+			port:     location.forward_port,
+			// With a path in proxy_pass, nginx swaps it for the location's
+			path:     forward_path === null ? path : forward_path + path.substring(location.path.length)
+		};
+	},
+
+	/**
+	 * Headers nginx sends upstream, with the fixed ones from proxy_set_header in the advanced config
+	 *
+	 * @param   {Object}  host
+	 * @param   {String}  scheme  the client's
+	 * @returns {Object}
+	 */
+	getHeaders: (host, scheme) => {
+		let headers = {
+			'Host':               helpers.toAsciiDomain(host.domain_names[0]),
+			'X-Forwarded-Scheme': scheme,
+			'X-Forwarded-Proto':  scheme,
+			'X-Forwarded-For':    '127.0.0.1',
+			'X-Real-IP':          '127.0.0.1',
+			'User-Agent':         'nginx-proxy-manager-diagnose'
+		};
+
+		(host.advanced_config || '').split('\n').forEach((line) => {
+			// Anything with a variable in it can't be worked out here
+			const match = line.match(/^\s*proxy_set_header\s+(\S+)\s+("([^"$]*)"|([^\s;$]+))\s*;/);
+			if (match) {
+				headers[match[1]] = typeof match[3] !== 'undefined' ? match[3] : match[4];
+			}
+		});
+
+		return headers;
+	},
+
+	/**
+	 * @param   {String}  name
+	 * @returns {Promise}
+	 */
+	resolve: (name) => {
+		if (internalDocker.isContainer(name)) {
+			return internalDiagnose.resolve(internalDocker.resolve(name));
+		}
+
+		// A unix socket is its own address
+		if (net.isIP(name) || internalUnixSocket.isSocket(name)) {
+			return Promise.resolve({addresses: [name]});
+		}
+
+		return internalDnsResolvers.lookup(name)
+			.then((results) => {
+				return {addresses: _.map(results, 'address')};
+			});
+	},
+
+	/**
+	 * Where to connect to, the path of a unix socket instead of the address and port
+	 *
+	 * @param   {String}  address
+	 * @param   {Number}  port
+	 * @returns {Object}
+	 */
+	getTarget: (address, port) => {
+		if (internalUnixSocket.isSocket(address)) {
+			return {path: internalUnixSocket.getPath(address)};
+		}
+		return {host: address, port: port};
+	},
+
+	/**
+	 * @param   {String}  address
+	 * @param   {Number}  port
+	 * @returns {Promise}
+	 */
+	connect: (address, port) => {
+		return new Promise((resolve, reject) => {
+			const socket = net.connect(_.assign(internalDiagnose.getTarget(address, port), {timeout: TIMEOUT}), () => {
+				socket.destroy();
+				resolve({address: address, port: port});
+			});
+
+			socket.on('timeout', () => {
+				const err = new Error('Connecting to ' + address + ':' + port + ' timed out');
+				err.code  = 'ETIMEDOUT';
+				socket.destroy();
+				reject(err);
+			});
+			socket.on('error', reject);
+		});
+	},
+
+	/**
+	 * A handshake checked the way nginx would with the host's upstream TLS options
+	 *
+	 * @param   {Object}  host
+	 * @param   {Object}  upstream
+	 * @param   {String}  address
+	 * @returns {Promise}
+	 */
+	handshake: (host, upstream, address) => {
+		const options    = host.upstream_tls || {};
+		const servername = options.server_name || upstream.host;
+
+		return new Promise((resolve, reject) => {
+			const ca = options.verify && options.ca_bundle ? fs.readFileSync(internalUpstreamTls.getCaFile(host.id)) : undefined;
+
+			const socket = tls.connect(_.assign(internalDiagnose.getTarget(address, upstream.port), {
+				servername:         net.isIP(servername) || internalUnixSocket.isSocket(servername) ? undefined : servername,
+				ca:                 ca,
+				rejectUnauthorized: false,
+				timeout:            TIMEOUT
+			}), () => {
+				const certificate = socket.getPeerCertificate();
+				const detail      = {
+					protocol:     socket.getProtocol(),
+					cipher:       socket.getCipher().standardName || socket.getCipher().name,
+					verified:     socket.authorized,
+					subject:      certificate.subject ? certificate.subject.CN || null : null,
+					issuer:       certificate.issuer ? certificate.issuer.CN || null : null,
+					valid_to:     certificate.valid_to ? new Date(certificate.valid_to).toISOString() : null,
+					verify_error: socket.authorizationError ? String(socket.authorizationError.code || socket.authorizationError) : null
+				};
+				socket.destroy();
+
+				// Only a problem when nginx is told to check it
+				if (options.verify && !socket.authorized) {
+					const err  = new Error('The certificate isn\'t trusted: ' + detail.verify_error);
+					err.detail = detail;
+					err.hint   = 'Upload the CA that signed the forward host\'s certificate, or turn off verifying it.';
+					reject(err);
+					return;
+				}
+
+				resolve(detail);
+			});
+
+			socket.on('timeout', () => {
+				const err = new Error('The TLS handshake timed out');
+				err.code  = 'ETIMEDOUT';
+				socket.destroy();
+				reject(err);
+			});
+			socket.on('error', (err) => {
+				if (!err.code || err.code.indexOf('ERR_SSL') === 0) {
+					err.code = 'EPROTO';
+				}
+				reject(err);
+			});
+		});
+	},
+
+	/**
+	 * @param   {Object}  host
+	 * @param   {Object}  upstream
+	 * @param   {String}  address
+	 * @param   {Object}  headers
+	 * @returns {Promise}
+	 */
+	request: (host, upstream, address, headers) => {
+		const client  = upstream.scheme === 'https' ? https : http;
+		const options = host.upstream_tls || {};
+
+		return new Promise((resolve, reject) => {
+			const target = internalDiagnose.getTarget(address, upstream.port);
+			const name   = options.server_name || upstream.host;
+
+			const req = client.request({
+				host:               target.host,
+				port:               target.port,
+				socketPath:         target.path,
+				path:               upstream.path,
+				method:             'GET',
+				headers:            headers,
+				servername:         upstream.scheme === 'https' && !net.isIP(name) && !internalUnixSocket.isSocket(name) ? name : undefined,
+				rejectUnauthorized: false,
+				timeout:            TIMEOUT
+			}, (res) => {
+				res.resume();
+
+				const detail = {
+					status:       res.statusCode,
+					location:     res.headers.location || null,
+					server:       res.headers.server || null,
+					content_type: res.headers['content-type'] || null
+				};
+
+				if (res.statusCode >= 500) {
+					const err  = new Error('The forward host answered ' + res.statusCode);
+					err.detail = detail;
+					err.hint   = 'The service itself is failing, check its logs.';
+					reject(err);
+					return;
+				}
+
+				// An app behind the proxy that doesn't trust X-Forwarded-Proto sends browsers round in circles
+				if (detail.location && detail.location.indexOf('https://' + headers.Host) === 0 && headers['X-Forwarded-Proto'] === 'https') {
+					const err  = new Error('The forward host redirects https requests to https');
+					err.detail = detail;
+					err.hint   = 'The service doesn\'t see that the request came in over https, so it redirects in a loop. Tell it to trust X-Forwarded-Proto.';
+					reject(err);
+					return;
+				}
+
+				resolve(detail);
+			});
+
+			req.on('timeout', () => {
+				const err = new Error('No response within ' + (TIMEOUT / 1000) + ' seconds');
+				err.code  = 'ETIMEDOUT';
+				req.destroy();
+				reject(err);
+			});
+			req.on('error', reject);
+			req.end();
+		});
+	},
+
+	/**
+	 * Goes through what nginx does to reach the forward host of a proxy host, one step at a time,
+	 * and stops at the first that fails. Meant for working out where a 502 comes from.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.path]  of the request, which picks the custom location
+	 * @returns {Promise}
+	 */
+	diagnose: (access, data) => {
+		const path = data.path || '/';
+
+		return internalProxyHost.get(access, {id: data.id})
+			.then((host) => {
+				const upstream = internalDiagnose.getUpstream(host, path);
+				const headers  = internalDiagnose.getHeaders(host, host.certificate_id ? 'https' : 'http');
+				let steps      = [];
+				let address    = null;
+
+				const run = (name, fn) => {
+					return (previous) => {
+						if (previous === false) {
+							steps.push({step: name, ok: null, time_ms: 0, detail: null, error: null, hint: null});
+							return false;
+						}
+
+						return step(name, fn)
+							.then((result) => {
+								steps.push(result);
+								return result.ok;
+							});
+					};
+				};
+
+				return Promise.resolve(true)
+					.then(run('dns', () => {
+						return internalDiagnose.resolve(upstream.host)
+							.then((detail) => {
+								address = detail.addresses[0];
+								return detail;
+							});
+					}))
+					.then(run('tcp', () => internalDiagnose.connect(address, upstream.port)))
+					.then((ok) => {
+						if (upstream.scheme !== 'https') {
+							return ok;
+						}
+						return run('tls', () => internalDiagnose.handshake(host, upstream, address))(ok);
+					})
+					.then(run('http', () => internalDiagnose.request(host, upstream, address, headers)))
+					.then(() => {
+						return {
+							object_id: host.id,
+							upstream:  _.assign({}, upstream, {url: upstream.scheme + '://' + upstream.host + (internalUnixSocket.isSocket(upstream.host) ? ':' : ':' + upstream.port) + upstream.path}),
+							headers:   headers,
+							ok:        _.every(steps, 'ok'),
+							steps:     steps
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalDiagnose;
@@ -0,0 +1,195 @@
+const _     = require('lodash');
+const dns   = require('dns').promises;
+const error = require('../lib/error');
+
+const TIMEOUT = 3000;
+
+/**
+ * Public resolvers asked for the record. The ones in China cache separately
+ * from the rest, which is often why a DNS-01 challenge passes in one place and not the other.
+ */
+const RESOLVERS = [
+	{name: 'Cloudflare', address: '1.1.1.1'},
+	{name: 'Google', address: '8.8.8.8'},
+	{name: 'Quad9', address: '9.9.9.9'},
+	{name: 'OpenDNS', address: '208.67.222.222'},
+	{name: '114DNS', address: '114.114.114.114'},
+	{name: 'AliDNS', address: '223.5.5.5'},
+	{name: 'DNSPod', address: '119.29.29.29'}
+];
+
+// How many of the zone's nameservers are asked
+const MAX_NAMESERVERS = 4;
+
+const internalDnsCheck = {
+
+	resolvers: RESOLVERS,
+
+	/**
+	 * @param   {String}  address  of the resolver
+	 * @param   {String}  name
+	 * @param   {String}  type     ie: 'TXT'
+	 * @returns {Promise}  resolves with the values as strings
+	 */
+	query: (address, name, type) => {
+		const resolver = new dns.Resolver({timeout: TIMEOUT, tries: 1});
+		resolver.setServers([address]);
+
+		return resolver.resolve(name, type)
+			.then((records) => {
+				return records.map((record) => {
+					if (type === 'TXT') {
+						// Long values come back in chunks of 255 characters
+						return record.join('');
+					}
+					return String(record);
+				});
+			});
+	},
+
+	/**
+	 * The nameservers of the zone the name is in, found by walking up its labels
+	 *
+	 * @param   {String}  name
+	 * @returns {Promise}  resolves with [{name, address}]
+	 */
+	getNameservers: (name) => {
+		const labels = name.split('.');
+
+		const lookup = (index) => {
+			if (index >= labels.length - 1) {
+				return Promise.resolve([]);
+			}
+
+			return dns.resolveNs(labels.slice(index).join('.'))
+				.then((hosts) => {
+					return Promise.all(_.take(hosts.sort(), MAX_NAMESERVERS).map((host) => {
+						return dns.resolve4(host)
+							.then((addresses) => {
+								return {name: host, address: addresses[0]};
+							})
+							.catch(() => null);
+					}));
+				})
+				.then((nameservers) => _.compact(nameservers))
+				.catch((err) => {
+					// A name without its own NS records, so the zone is further up
+					if (err.code === 'ENODATA' || err.code === 'ENOTFOUND') {
+						return lookup(index + 1);
+					}
+					throw err;
+				});
+		};
+
+		return lookup(0);
+	},
+
+	/**
+	 * @param   {Object}  resolver  {name, address}
+	 * @param   {String}  kind      'authoritative' or 'public'
+	 * @param   {String}  name
+	 * @param   {String}  type
+	 * @returns {Promise}
+	 */
+	check: (resolver, kind, name, type) => {
+		const started = Date.now();
+
+		return internalDnsCheck.query(resolver.address, name, type)
+			.then((values) => {
+				return {values: values, error: null};
+			})
+			.catch((err) => {
+				return {values: [], error: err.code || err.message};
+			})
+			.then((result) => {
+				const item = {
+					resolver: resolver.name,
+					address:  resolver.address,
+					kind:     kind,
+					values:   result.values,
+					error:    ['ENODATA', 'ENOTFOUND'].indexOf(result.error) === -1 ? result.error : null,
+					time_ms:  Date.now() - started,
+					cname:    null
+				};
+
+				// A nameserver won't follow a CNAME out of its zone, ie: _acme-challenge delegated to acme-dns
+				if (kind === 'authoritative' && !item.values.length && type !== 'CNAME') {
+					return internalDnsCheck.query(resolver.address, name, 'CNAME')
+						.then((cnames) => {
+							item.cname = cnames[0] || null;
+							return item;
+						})
+						.catch(() => item);
+				}
+
+				return item;
+			});
+	},
+
+	/**
+	 * Asks the zone's nameservers and a set of public resolvers for a record, and reports
+	 * which of them already have the value. DNS-01 validation fails while the CA's resolvers don't.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name        ie: '_acme-challenge.example.com'
+	 * @param   {String}  [data.type]      'TXT' by default
+	 * @param   {String}  [data.expected]  value to look for, otherwise whatever the nameservers answer
+	 * @returns {Promise}
+	 */
+	run: (access, data) => {
+		const name = data.name.toLowerCase().replace(/\.$/, '');
+		const type = data.type || 'TXT';
+
+		if (name.indexOf('*') !== -1 || name.split('.').length < 2) {
+			return Promise.reject(new error.ValidationError('A full domain name is required, ie: _acme-challenge.example.com'));
+		}
+
+		return access.can('tools:dns')
+			.then(() => {
+				return internalDnsCheck.getNameservers(name)
+					.catch(() => []);
+			})
+			.then((nameservers) => {
+				return Promise.all([
+					Promise.all(nameservers.map((nameserver) => internalDnsCheck.check(nameserver, 'authoritative', name, type))),
+					Promise.all(RESOLVERS.map((resolver) => internalDnsCheck.check(resolver, 'public', name, type)))
+				]);
+			})
+			.then(([authoritative, resolvers]) => {
+				const expected = data.expected ? [data.expected] : _.uniq(_.flatMap(authoritative, 'values')).sort();
+
+				const items = authoritative.concat(resolvers).map((item) => {
+					let status = 'ok';
+					if (item.error) {
+						status = 'error';
+					} else if (!item.values.length) {
+						status = item.cname ? 'ok' : 'missing';
+					} else if (expected.length && _.difference(expected, item.values).length) {
+						status = 'mismatch';
+					}
+					return _.assign({status: status}, item);
+				});
+
+				const answered = items.filter((item) => item.kind === 'public' && item.status !== 'error');
+				const ok       = answered.filter((item) => item.status === 'ok' && item.values.length);
+
+				let status = 'partial';
+				if (!answered.length || !ok.length) {
+					status = 'missing';
+				} else if (ok.length === answered.length) {
+					status = 'propagated';
+				}
+
+				return {
+					name:      name,
+					type:      type,
+					expected:  expected,
+					status:    status,
+					resolvers: items
+				};
+			});
+	}
+};
+
+module.exports = internalDnsCheck;
@@ -0,0 +1,106 @@
+const error               = require('../lib/error');
+const logger              = require('../logger').global;
+const internalCertificate = require('./certificate');
+const internalJobs        = require('./jobs');
+const internalAuditLog    = require('./audit-log');
+
+const internalDnsProvider = {
+
+	/**
+	 * The certificates this user can see that are issued with a DNS challenge through the provider
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  provider  ie: cloudflare, the key of the plugin in certbot-dns-plugins.json
+	 * @returns {Promise}
+	 */
+	getCertificates: (access, provider) => {
+		return internalCertificate.getAll(access)
+			.then((rows) => {
+				return rows.filter((row) => {
+					return row.provider === 'letsencrypt' && row.meta.dns_challenge && row.meta.dns_provider === provider;
+				});
+			});
+	},
+
+	/**
+	 * Renews every certificate using the DNS provider, ie: after its API token was rotated or the NS records
+	 * changed. Each is a job of its own, run one after the other as certbot can't run twice at once, so the
+	 * ones still waiting are queued.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.provider
+	 * @param   {String}  [data.credentials]  new credentials to renew them with
+	 * @returns {Promise}  resolves with {provider, jobs}
+	 */
+	reissueAll: (access, data) => {
+		let certificates = [];
+
+		return access.can('certificates:list')
+			.then(() => {
+				if (typeof internalCertificate.getDnsPlugin(data.provider) === 'undefined') {
+					throw new error.ItemNotFoundError(data.provider);
+				}
+
+				return internalDnsProvider.getCertificates(access, data.provider);
+			})
+			.then((rows) => {
+				if (!rows.length) {
+					throw new error.ValidationError('No certificates are issued with ' + internalCertificate.getDnsPlugin(data.provider).name);
+				}
+
+				certificates = rows;
+
+				if (typeof data.credentials !== 'string') {
+					return;
+				}
+
+				// All of them or none, so a certificate left out can't be renewed with the old credentials
+				return certificates.reduce((sequence, certificate) => {
+					return sequence.then(() => access.can('certificates:update', certificate.id));
+				}, Promise.resolve())
+					.then(() => {
+						return certificates.reduce((sequence, certificate) => {
+							return sequence.then(() => internalCertificate.setDnsCredentials(access, {id: certificate.id, credentials: data.credentials}));
+						}, Promise.resolve());
+					});
+			})
+			.then(() => {
+				return internalAuditLog.add(access, {
+					action:      'reissued',
+					object_type: 'certificate',
+					object_id:   0,
+					meta:        {
+						dns_provider:        data.provider,
+						certificate_ids:     certificates.map((certificate) => certificate.id),
+						credentials_changed: typeof data.credentials === 'string'
+					}
+				});
+			})
+			.then(() => {
+				let queue = Promise.resolve();
+
+				logger.info('Renewing ' + certificates.length + ' certificate(s) issued with ' + internalCertificate.getDnsPlugin(data.provider).name);
+
+				return {
+					provider: data.provider,
+					jobs:     certificates.map((certificate) => {
+						const job = internalJobs.start(access, 'certificate-renew', (progress) => {
+							progress('queued', {object_id: certificate.id});
+
+							const renewal = queue.then(() => internalCertificate.renew(access, {id: certificate.id}, progress));
+							// The next one goes whether or not this one worked
+							queue = renewal.catch(() => {});
+							return renewal;
+						});
+
+						// The job only knows its certificate once it runs
+						job.object_id = certificate.id;
+						return job;
+					})
+				};
+			});
+	}
+};
+
+module.exports = internalDnsProvider;
@@ -0,0 +1,196 @@
+const _             = require('lodash');
+const fs            = require('fs');
+const dns           = require('dns');
+const net           = require('net');
+const error         = require('../lib/error');
+const logger        = require('../logger').global;
+const settingModel  = require('../models/setting');
+const internalNginx = require('./nginx');
+
+// Included by nginx.conf, written from /etc/resolv.conf on start until the setting says otherwise
+const RESOLVERS_FILE = '/etc/nginx/conf.d/include/resolvers.conf';
+const RESOLV_CONF    = '/etc/resolv.conf';
+
+// ie: 10.0.0.53, 10.0.0.53:5353, fd00::53 or [fd00::53]:5353
+const SERVER = /^(?:\[([0-9a-fA-F:.]+)\]|([0-9.]+)|([0-9a-fA-F:.]+))(?::(\d{1,5}))?$/;
+
+// What the backend resolved with when it started
+const SYSTEM_SERVERS = dns.getServers();
+
+let custom = false;
+
+const internalDnsResolvers = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'dns-resolvers')
+			.first();
+	},
+
+	/**
+	 * @param   {String}  server
+	 * @returns {Object|null}  {address, port}, null when it isn't an address
+	 */
+	parseServer: (server) => {
+		const match = String(server).match(SERVER);
+		if (!match) {
+			return null;
+		}
+
+		// An IPv6 address without brackets can't have a port
+		const address = match[1] || match[2] || (match[4] ? match[3] + ':' + match[4] : match[3]);
+		const port    = match[1] || match[2] ? match[4] : undefined;
+
+		if (!net.isIP(address) || (port && (parseInt(port, 10) < 1 || parseInt(port, 10) > 65535))) {
+			return null;
+		}
+		return {address: address, port: port ? parseInt(port, 10) : 53};
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (!meta.servers || !meta.servers.length) {
+			return Promise.reject(new error.ValidationError('Give at least one DNS server'));
+		}
+
+		const invalid = meta.servers.filter((server) => internalDnsResolvers.parseServer(server) === null);
+		if (invalid.length) {
+			return Promise.reject(new error.ValidationError('Not the address of a DNS server: ' + invalid.join(', ') + '. DoH and DoT need a forwarder on an address here.'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * @returns {Array}  the nameservers of the container
+	 */
+	getSystemServers: () => {
+		try {
+			return fs.readFileSync(RESOLV_CONF, {encoding: 'utf8'})
+				.split('\n')
+				.map((line) => line.trim().split(/\s+/))
+				.filter((fields) => fields[0] === 'nameserver' && fields[1])
+				.map((fields) => fields[1].replace(/%.*$/, ''));
+		} catch (err) {
+			return [];
+		}
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @returns {String}  the resolver directive for nginx
+	 */
+	render: (setting) => {
+		const is_custom = setting && setting.value === 'custom';
+		const servers   = is_custom ? setting.meta.servers : internalDnsResolvers.getSystemServers();
+		const valid     = (is_custom && setting.meta.valid) || 10;
+
+		const addresses = servers.map((server) => {
+			const parsed  = internalDnsResolvers.parseServer(server);
+			const address = net.isIPv6(parsed.address) ? '[' + parsed.address + ']' : parsed.address;
+			return address + (parsed.port !== 53 ? ':' + parsed.port : '');
+		});
+
+		if (!addresses.length) {
+			return '# No DNS servers in ' + RESOLV_CONF + '\n';
+		}
+
+		return 'resolver ' + addresses.join(' ') + (internalNginx.ipv6Enabled() ? '' : ' ipv6=off') + ' valid=' + valid + 's;\n';
+	},
+
+	/**
+	 * Points the backend's own lookups at the servers of the setting, or back at the container's
+	 *
+	 * @param {Object}  setting
+	 */
+	apply: (setting) => {
+		custom = !!(setting && setting.value === 'custom' && setting.meta && setting.meta.servers && setting.meta.servers.length);
+
+		dns.setServers(custom ? setting.meta.servers.map((server) => {
+			const parsed = internalDnsResolvers.parseServer(server);
+			return (net.isIPv6(parsed.address) ? '[' + parsed.address + ']' : parsed.address) + ':' + parsed.port;
+		}) : SYSTEM_SERVERS);
+	},
+
+	/**
+	 * The addresses of a host name, from the servers of the setting when there are some
+	 *
+	 * @param   {String}  hostname
+	 * @returns {Promise}  resolves with [{address, family}], like dns.lookup with all
+	 */
+	lookup: (hostname) => {
+		if (!custom) {
+			return dns.promises.lookup(hostname, {all: true});
+		}
+
+		return Promise.all([
+			dns.promises.resolve4(hostname).catch(() => []),
+			dns.promises.resolve6(hostname).catch(() => [])
+		])
+			.then(([ipv4, ipv6]) => {
+				const results = ipv4.map((address) => ({address: address, family: 4}))
+					.concat(ipv6.map((address) => ({address: address, family: 6})));
+
+				if (!results.length) {
+					const err = new Error('getaddrinfo ENOTFOUND ' + hostname);
+					err.code  = 'ENOTFOUND';
+					throw err;
+				}
+				return results;
+			});
+	},
+
+	/**
+	 * Called on startup. nginx is left with the container's resolvers the startup script wrote,
+	 * unless the setting has its own.
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return internalDnsResolvers.getSetting()
+			.then((setting) => {
+				internalDnsResolvers.apply(setting);
+
+				if (custom) {
+					logger.info('Resolving with ' + setting.meta.servers.join(', '));
+					fs.writeFileSync(RESOLVERS_FILE, internalDnsResolvers.render(setting), {encoding: 'utf8'});
+					return internalNginx.reload();
+				}
+			});
+	},
+
+	/**
+	 * Applies the setting after it's changed. When nginx doesn't accept the resolvers, it goes back
+	 * to the container's.
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		internalDnsResolvers.apply(setting);
+		fs.writeFileSync(RESOLVERS_FILE, internalDnsResolvers.render(setting), {encoding: 'utf8'});
+
+		return internalNginx.test()
+			.then(internalNginx.reload)
+			.catch((err) => {
+				logger.error('Could not configure the DNS resolvers:', err.message);
+
+				internalDnsResolvers.apply(null);
+				fs.writeFileSync(RESOLVERS_FILE, internalDnsResolvers.render(null), {encoding: 'utf8'});
+
+				return internalNginx.reload()
+					.then(() => {
+						throw new error.ValidationError('Nginx didn\'t accept the DNS resolvers, the container\'s are used again: ' + _.trim(err.message));
+					});
+			});
+	}
+};
+
+module.exports = internalDnsResolvers;
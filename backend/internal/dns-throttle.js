@@ -0,0 +1,178 @@
+const _            = require('lodash');
+const moment       = require('moment');
+const logger       = require('../logger').ssl;
+const error        = require('../lib/error');
+const settingModel = require('../models/setting');
+const dnsPlugins   = require('../global/certbot-dns-plugins.json');
+
+const HOUR = 1000 * 60 * 60;
+
+const internalDnsThrottle = {
+
+	// By provider, the number of certbot runs going on and when the ones in the last hour started
+	running: {},
+	started: {},
+	waiters: {},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'dns-provider-limits')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		const limits  = (meta && meta.limits) || [];
+		const unknown = _.find(limits, (limit) => !dnsPlugins[limit.provider]);
+
+		if (unknown) {
+			return Promise.reject(new error.ValidationError('Unknown DNS provider ' + unknown.provider));
+		}
+
+		if (_.uniqBy(limits, 'provider').length !== limits.length) {
+			return Promise.reject(new error.ValidationError('Each DNS provider can only have one limit'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * @param   {String}  provider
+	 * @returns {Promise}  resolves with the limit of the provider, or null when it doesn't have one
+	 */
+	getLimit: (provider) => {
+		return internalDnsThrottle.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return null;
+				}
+				return _.find((setting.meta && setting.meta.limits) || [], {provider: provider}) || null;
+			});
+	},
+
+	/**
+	 * @param   {String}  provider
+	 * @returns {Array}   when the runs in the last hour started
+	 */
+	getStarted: (provider) => {
+		const since = Date.now() - HOUR;
+
+		internalDnsThrottle.started[provider] = (internalDnsThrottle.started[provider] || []).filter((time) => time > since);
+		return internalDnsThrottle.started[provider];
+	},
+
+	/**
+	 * @param   {String}  provider
+	 * @param   {Object}  limit
+	 * @returns {Number}  ms until the hourly budget has room again, 0 when it has now
+	 */
+	getHourlyWait: (provider, limit) => {
+		const started = internalDnsThrottle.getStarted(provider);
+
+		if (!limit.per_hour || started.length < limit.per_hour) {
+			return 0;
+		}
+		return started[started.length - limit.per_hour] + HOUR - Date.now();
+	},
+
+	/**
+	 * Whether the provider has been called as often as it may this hour. The renewal timer leaves
+	 * its certificates until a later run then, instead of waiting.
+	 *
+	 * @param   {String}  provider
+	 * @returns {Promise}  resolves with when there's room again, or null when there is now
+	 */
+	getDelay: (provider) => {
+		return internalDnsThrottle.getLimit(provider)
+			.then((limit) => {
+				const wait = limit ? internalDnsThrottle.getHourlyWait(provider, limit) : 0;
+				return wait > 0 ? moment().add(wait, 'ms').toISOString() : null;
+			});
+	},
+
+	/**
+	 * Waits for a free slot and the interval since the last run. Doesn't wait for the hourly budget, as that can take a while.
+	 *
+	 * @param   {String}  provider
+	 * @param   {Object}  limit
+	 * @returns {Promise}
+	 */
+	acquire: (provider, limit) => {
+		return new Promise((resolve, reject) => {
+			const attempt = () => {
+				const hourly = internalDnsThrottle.getHourlyWait(provider, limit);
+				if (hourly > 0) {
+					const name = dnsPlugins[provider] ? dnsPlugins[provider].name : provider;
+					reject(new error.ValidationError(name + ' has been called ' + limit.per_hour + ' times in the last hour, try again at ' + moment().add(hourly, 'ms').toISOString()));
+					return;
+				}
+
+				if ((internalDnsThrottle.running[provider] || 0) >= (limit.concurrency || 1)) {
+					internalDnsThrottle.waiters[provider] = (internalDnsThrottle.waiters[provider] || []).concat([attempt]);
+					return;
+				}
+
+				const started = internalDnsThrottle.getStarted(provider);
+				const wait    = started.length && limit.interval ? started[started.length - 1] + (limit.interval * 1000) - Date.now() : 0;
+				if (wait > 0) {
+					setTimeout(attempt, wait);
+					return;
+				}
+
+				internalDnsThrottle.running[provider] = (internalDnsThrottle.running[provider] || 0) + 1;
+				started.push(Date.now());
+				resolve();
+			};
+
+			attempt();
+		});
+	},
+
+	/**
+	 * @param   {String}  provider
+	 */
+	release: (provider) => {
+		internalDnsThrottle.running[provider] = Math.max(0, (internalDnsThrottle.running[provider] || 0) - 1);
+
+		const waiters = internalDnsThrottle.waiters[provider] || [];
+		internalDnsThrottle.waiters[provider] = [];
+		waiters.forEach((attempt) => attempt());
+	},
+
+	/**
+	 * Runs a certbot command against a DNS provider within its limit
+	 *
+	 * @param   {String}    provider  key of certbot-dns-plugins.json
+	 * @param   {Function}  fn        returns a Promise
+	 * @returns {Promise}
+	 */
+	run: (provider, fn) => {
+		return internalDnsThrottle.getLimit(provider)
+			.then((limit) => {
+				if (!limit) {
+					return fn();
+				}
+
+				if ((internalDnsThrottle.running[provider] || 0) >= (limit.concurrency || 1)) {
+					logger.info('Waiting for a free slot of ' + provider + ', ' + limit.concurrency + ' at a time');
+				}
+
+				return internalDnsThrottle.acquire(provider, limit)
+					.then(() => {
+						return fn()
+							.finally(() => {
+								internalDnsThrottle.release(provider);
+							});
+					});
+			});
+	}
+};
+
+module.exports = internalDnsThrottle;
@@ -0,0 +1,320 @@
+const _            = require('lodash');
+const os           = require('os');
+const http         = require('http');
+const logger       = require('../logger').nginx;
+const error        = require('../lib/error');
+const settingModel = require('../models/setting');
+
+const PREFIX = 'docker:';
+
+// A container name as Docker allows it, with the rest of the forward host after it, ie: a path of a custom location
+const NAME = /^docker:([A-Za-z0-9][A-Za-z0-9_.-]*)(.*)$/;
+
+const TIMEOUT = 10000;
+
+// The address of each running container by name, as last seen through the socket
+let addresses = {};
+
+const internalDocker = {
+
+	intervalTimeout:    1000 * 30, // 30 seconds
+	interval:           null,
+	intervalProcessing: false,
+
+	/**
+	 * Looks the containers up before anything is written, so hosts aren't written with their names first
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return internalDocker.refresh()
+			.then(() => {
+				logger.info('Docker Timer initialized');
+				internalDocker.interval = setInterval(internalDocker.processContainers, internalDocker.intervalTimeout);
+			});
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'docker')
+			.first();
+	},
+
+	/**
+	 * @param   {String}  forward_host
+	 * @returns {Boolean}
+	 */
+	isContainer: (forward_host) => {
+		return typeof forward_host === 'string' && forward_host.indexOf(PREFIX) === 0;
+	},
+
+	/**
+	 * @param   {String}  forward_host  ie: docker:app/api
+	 * @returns {String|null}  ie: app
+	 */
+	getName: (forward_host) => {
+		const match = NAME.exec(forward_host || '');
+		return match ? match[1] : null;
+	},
+
+	/**
+	 * Every forward host of a proxy host, where a container can be named
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}
+	 */
+	getForwardHosts: (host) => {
+		let forward_hosts = [host.forward_host];
+
+		(host.locations || []).forEach((location) => {
+			forward_hosts.push(location.forward_host);
+			if (location.mirror) {
+				forward_hosts.push(location.mirror.forward_host);
+			}
+		});
+		(host.load_balancing && host.load_balancing.servers ? host.load_balancing.servers : []).forEach((server) => {
+			forward_hosts.push(server.forward_host);
+		});
+		if (host.fallback && host.fallback.upstream) {
+			forward_hosts.push(host.fallback.upstream.forward_host);
+		}
+		if (host.traffic_split && host.traffic_split.canary) {
+			forward_hosts.push(host.traffic_split.canary.forward_host);
+		}
+
+		return _.filter(forward_hosts, internalDocker.isContainer);
+	},
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Array}   the names of the containers the host forwards to
+	 */
+	getNames: (host) => {
+		return _.uniq(_.compact(internalDocker.getForwardHosts(host).map(internalDocker.getName)));
+	},
+
+	/**
+	 * The address a container was last seen with, or its name when it wasn't, which nginx then has to resolve itself
+	 *
+	 * @param   {String}  forward_host  ie: docker:app
+	 * @returns {String}  ie: 172.18.0.5
+	 */
+	resolve: (forward_host) => {
+		const match = NAME.exec(forward_host);
+		if (!match) {
+			return forward_host;
+		}
+
+		return (addresses[match[1]] || match[1]) + match[2];
+	},
+
+	/**
+	 * Swaps the containers a proxy host forwards to for their addresses, before its config is written
+	 *
+	 * @param   {Object}  host
+	 */
+	apply: (host) => {
+		const swap = (object) => {
+			if (object && internalDocker.isContainer(object.forward_host)) {
+				object.forward_host = internalDocker.resolve(object.forward_host);
+			}
+		};
+
+		swap(host);
+		(host.locations || []).forEach((location) => {
+			swap(location);
+			swap(location.mirror);
+		});
+		(host.load_balancing && host.load_balancing.servers ? host.load_balancing.servers : []).forEach(swap);
+		swap(host.fallback ? host.fallback.upstream : null);
+		swap(host.traffic_split ? host.traffic_split.canary : null);
+	},
+
+	/**
+	 * Containers can only be named with the Docker integration turned on
+	 *
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		const forward_hosts = internalDocker.getForwardHosts(data);
+		if (!forward_hosts.length) {
+			return Promise.resolve();
+		}
+
+		const invalid = _.find(forward_hosts, (forward_host) => !internalDocker.getName(forward_host));
+		if (invalid) {
+			return Promise.reject(new error.ValidationError(invalid + ' isn\'t the name of a container'));
+		}
+
+		return internalDocker.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					throw new error.ValidationError('The Docker integration needs turning on to forward to containers by name');
+				}
+			});
+	},
+
+	/**
+	 * A call to the Docker Engine API through its socket
+	 *
+	 * @param   {String}  socket
+	 * @param   {String}  path
+	 * @returns {Promise}  resolves with the parsed body
+	 */
+	request: (socket, path) => {
+		return new Promise((resolve, reject) => {
+			const req = http.get({socketPath: socket, path: path, timeout: TIMEOUT}, (res) => {
+				let body = '';
+				res.setEncoding('utf8');
+				res.on('data', (chunk) => {
+					body += chunk;
+				});
+				res.on('end', () => {
+					if (res.statusCode !== 200) {
+						reject(new Error('Docker answered ' + res.statusCode + ': ' + body.trim()));
+						return;
+					}
+
+					try {
+						resolve(JSON.parse(body));
+					} catch (err) {
+						reject(new Error('Docker answered with invalid JSON'));
+					}
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('Docker timed out'));
+			});
+			req.on('error', reject);
+		});
+	},
+
+	/**
+	 * Which of the networks of a container to reach it on, the one in the setting, else one this container
+	 * is on too, else any it has an address on
+	 *
+	 * @param   {Object}  container  from the container list
+	 * @param   {String}  [network]  from the setting
+	 * @param   {Array}   own        names of the networks this container is on
+	 * @returns {String|null}
+	 */
+	getAddress: (container, network, own) => {
+		const networks = _.pickBy(container.NetworkSettings && container.NetworkSettings.Networks ? container.NetworkSettings.Networks : {}, (details) => !!details.IPAddress);
+		const names    = Object.keys(networks);
+
+		const name = (network && networks[network] ? network : null) ||
+			_.find(names, (name) => own.indexOf(name) !== -1) ||
+			names[0];
+
+		return name ? networks[name].IPAddress : null;
+	},
+
+	/**
+	 * Reads the running containers and their addresses
+	 *
+	 * @returns {Promise}  resolves with the names of the containers whose address changed
+	 */
+	refresh: () => {
+		return internalDocker.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return {};
+				}
+
+				return internalDocker.request(setting.meta.socket || '/var/run/docker.sock', '/containers/json')
+					.then((containers) => {
+						// The container ids start with the hostname Docker gives a container
+						const self = _.find(containers, (container) => container.Id.indexOf(os.hostname()) === 0);
+						const own  = self && self.NetworkSettings ? Object.keys(self.NetworkSettings.Networks || {}) : [];
+
+						let found = {};
+						containers.forEach((container) => {
+							const address = internalDocker.getAddress(container, setting.meta.network, own);
+							if (address) {
+								(container.Names || []).forEach((name) => {
+									found[name.replace(/^\//, '')] = address;
+								});
+							}
+						});
+
+						return found;
+					})
+					.catch((err) => {
+						// The addresses last seen are kept while Docker can't be asked
+						logger.warn('Containers could not be listed: ' + err.message);
+						return addresses;
+					});
+			})
+			.then((found) => {
+				const changed = _.filter(_.union(Object.keys(addresses), Object.keys(found)), (name) => addresses[name] !== found[name]);
+				addresses     = found;
+				return changed;
+			});
+	},
+
+	/**
+	 * Writes the proxy hosts forwarding to the given containers again
+	 *
+	 * @param   {Array}  names
+	 * @returns {Promise}
+	 */
+	regenerate: (names) => {
+		if (!names.length) {
+			return Promise.resolve();
+		}
+
+		return require('./host').regenerateConfigs((host, host_type) => {
+			return host_type === 'proxy_host' && _.intersection(internalDocker.getNames(host), names).length > 0;
+		});
+	},
+
+	/**
+	 * Triggered by a timer, this writes the hosts forwarding to a container whose address changed
+	 *
+	 * @returns {Promise}
+	 */
+	processContainers: () => {
+		if (internalDocker.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalDocker.intervalProcessing = true;
+
+		return internalDocker.refresh()
+			.then((changed) => {
+				changed.forEach((name) => {
+					logger.info('Container ' + name + ' is now at ' + (addresses[name] || 'no address'));
+				});
+
+				return internalDocker.regenerate(changed);
+			})
+			.then(() => {
+				internalDocker.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalDocker.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * Looks the containers up again after the setting changed, and writes every host forwarding to one
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return internalDocker.refresh()
+			.then(() => {
+				return require('./host').regenerateConfigs((host, host_type) => host_type === 'proxy_host' && internalDocker.getNames(host).length > 0);
+			});
+	}
+};
+
+module.exports = internalDocker;
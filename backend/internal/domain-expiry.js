@@ -0,0 +1,446 @@
+const _                     = require('lodash');
+const net                   = require('net');
+const https                 = require('https');
+const moment                = require('moment');
+const logger                = require('../logger').domains;
+const error                 = require('../lib/error');
+const helpers               = require('../lib/helpers');
+const domainExpiryModel     = require('../models/domain_expiry');
+const proxyHostModel        = require('../models/proxy_host');
+const redirectionHostModel  = require('../models/redirection_host');
+const deadHostModel         = require('../models/dead_host');
+const settingModel          = require('../models/setting');
+const tokenModel            = require('../models/token');
+const internalAuditLog      = require('./audit-log');
+const internalNotifications = require('./notifications');
+
+const RDAP_BOOTSTRAP = 'https://data.iana.org/rdap/dns.json';
+const WHOIS_IANA     = 'whois.iana.org';
+const TIMEOUT        = 15000;
+const DEFAULT_DAYS   = 30;
+
+// Names that aren't registered anywhere
+const PRIVATE_SUFFIXES = ['local', 'localhost', 'lan', 'home', 'internal', 'test', 'invalid', 'example', 'arpa'];
+
+// The lines registries put the expiry date on, ie: "Expiration Time: 2027-03-01 12:00:00" from CNNIC
+const WHOIS_EXPIRY    = /^\s*(?:Registry Expiry Date|Registrar Registration Expiration Date|Expiration Time|Expiration Date|Expiry Date|Expires On|Expires|paid-till|expire)\s*:\s*(.+?)\s*$/im;
+const WHOIS_REGISTRAR = /^\s*(?:Registrar|Sponsoring Registrar|Registrar Name)\s*:\s*(.+?)\s*$/im;
+
+const internalDomainExpiry = {
+
+	intervalTimeout:    1000 * 60 * 60 * 24, // 1 day
+	interval:           null,
+	intervalProcessing: false,
+
+	// RDAP base urls by TLD, and the WHOIS servers found for them
+	rdapServers:  null,
+	whoisServers: {},
+
+	initTimer: () => {
+		logger.info('Domain Expiry Timer initialized');
+		internalDomainExpiry.interval = setInterval(internalDomainExpiry.processDomains, internalDomainExpiry.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'domain-expiry')
+			.first();
+	},
+
+	/**
+	 * Triggered by a timer, this will look up when each registered domain used by a host expires
+	 * and raise an alert when one is about to.
+	 *
+	 * @param   {Boolean}  [force]  Run even when the setting is off
+	 * @returns {Promise}
+	 */
+	processDomains: (force) => {
+		if (internalDomainExpiry.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalDomainExpiry.intervalProcessing = true;
+
+		return internalDomainExpiry.getSetting()
+			.then((setting) => {
+				if (!setting || (setting.value !== 'on' && force !== true)) {
+					return false;
+				}
+
+				const days = (setting.meta && setting.meta.days) || DEFAULT_DAYS;
+				logger.info('Checking domain expiry dates ...');
+
+				return internalDomainExpiry.getRegisteredDomains()
+					.then((domains) => {
+						// Domains no longer used by any host
+						return domainExpiryModel
+							.query()
+							.delete()
+							.whereNotIn('domain', domains.length ? domains : [''])
+							.then(() => domains);
+					})
+					.then((domains) => {
+						let sequence = Promise.resolve();
+
+						domains.forEach((domain) => {
+							sequence = sequence.then(() => {
+								return internalDomainExpiry.checkDomain(domain, days)
+									.catch((err) => {
+										// Don't want to stop the train here, just log the error
+										logger.error('Expiry lookup for ' + domain + ' failed: ' + err.message);
+									});
+							});
+						});
+
+						return sequence;
+					})
+					.then(() => {
+						logger.info('Completed domain expiry check');
+						return true;
+					});
+			})
+			.then((result) => {
+				internalDomainExpiry.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalDomainExpiry.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * The registered domains of every host, ie: example.com for www.example.com
+	 *
+	 * @returns {Promise}
+	 */
+	getRegisteredDomains: () => {
+		return Promise.all([
+			proxyHostModel.query().where('is_deleted', 0),
+			redirectionHostModel.query().where('is_deleted', 0),
+			deadHostModel.query().where('is_deleted', 0)
+		])
+			.then((results) => {
+				let domains = [];
+				results.map((rows) => {
+					rows.map((row) => {
+						domains = domains.concat(row.domain_names || []);
+					});
+				});
+
+				return _.uniq(domains
+					.map(helpers.getRegisteredDomain)
+					.filter((domain) => {
+						const labels = domain.split('.');
+						return labels.length > 1 && !/^[\d.]+$/.test(domain) && PRIVATE_SUFFIXES.indexOf(labels[labels.length - 1]) === -1;
+					})).sort();
+			});
+	},
+
+	/**
+	 * @param   {String}  url
+	 * @param   {Number}  [redirects]
+	 * @returns {Promise}
+	 */
+	fetchJson: (url, redirects) => {
+		return new Promise((resolve, reject) => {
+			https.get(url, {timeout: TIMEOUT, headers: {Accept: 'application/rdap+json, application/json'}}, (res) => {
+				// Some registries hand the lookup over to the registrar's server
+				if ([301, 302, 303, 307, 308].indexOf(res.statusCode) !== -1 && res.headers.location && (redirects || 0) < 3) {
+					res.resume();
+					resolve(internalDomainExpiry.fetchJson(new URL(res.headers.location, url).toString(), (redirects || 0) + 1));
+					return;
+				}
+
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					if (res.statusCode !== 200) {
+						reject(new Error(url + ' returned ' + res.statusCode));
+						return;
+					}
+
+					try {
+						resolve(JSON.parse(raw_data));
+					} catch (err) {
+						reject(new Error(url + ' returned invalid JSON'));
+					}
+				});
+			})
+				.on('timeout', function () {
+					this.destroy(new Error(url + ' timed out'));
+				})
+				.on('error', (err) => {
+					reject(err);
+				});
+		});
+	},
+
+	/**
+	 * @param   {String}  server
+	 * @param   {String}  query
+	 * @returns {Promise}
+	 */
+	whois: (server, query) => {
+		return new Promise((resolve, reject) => {
+			let raw_data = '';
+
+			const socket = net.connect(43, server, () => {
+				socket.write(query + '\r\n');
+			});
+
+			socket.setEncoding('utf8');
+			socket.setTimeout(TIMEOUT);
+			socket.on('data', (chunk) => {
+				raw_data += chunk;
+			});
+			socket.on('end', () => {
+				resolve(raw_data);
+			});
+			socket.on('timeout', () => {
+				socket.destroy(new Error('WHOIS server ' + server + ' timed out'));
+			});
+			socket.on('error', reject);
+		});
+	},
+
+	/**
+	 * @param   {String}  tld
+	 * @returns {Promise}  resolves with the RDAP base url, or null when the registry has none
+	 */
+	getRdapServer: (tld) => {
+		let sequence = Promise.resolve(internalDomainExpiry.rdapServers);
+
+		if (!internalDomainExpiry.rdapServers) {
+			sequence = internalDomainExpiry.fetchJson(RDAP_BOOTSTRAP)
+				.then((bootstrap) => {
+					let servers = {};
+					(bootstrap.services || []).forEach(([tlds, urls]) => {
+						const url = _.find(urls, (item) => item.indexOf('https://') === 0) || urls[0];
+						tlds.forEach((item) => {
+							servers[item.toLowerCase()] = url;
+						});
+					});
+
+					internalDomainExpiry.rdapServers = servers;
+					return servers;
+				});
+		}
+
+		return sequence.then((servers) => servers[tld] || null);
+	},
+
+	/**
+	 * @param   {String}  tld
+	 * @returns {Promise}  resolves with the WHOIS server IANA refers to
+	 */
+	getWhoisServer: (tld) => {
+		if (internalDomainExpiry.whoisServers[tld]) {
+			return Promise.resolve(internalDomainExpiry.whoisServers[tld]);
+		}
+
+		return internalDomainExpiry.whois(WHOIS_IANA, tld)
+			.then((result) => {
+				const match = /^\s*(?:whois|refer)\s*:\s*(\S+)/im.exec(result);
+				if (!match) {
+					throw new Error('No WHOIS server for .' + tld);
+				}
+
+				internalDomainExpiry.whoisServers[tld] = match[1];
+				return match[1];
+			});
+	},
+
+	/**
+	 * @param   {Object}  result  RDAP domain object
+	 * @returns {Object}  {expires_on, registrar}
+	 */
+	parseRdap: (result) => {
+		const event     = _.find(result.events || [], {eventAction: 'expiration'});
+		const registrar = _.find(result.entities || [], (entity) => (entity.roles || []).indexOf('registrar') !== -1);
+		let name        = null;
+
+		if (registrar && registrar.vcardArray && registrar.vcardArray[1]) {
+			const fn = _.find(registrar.vcardArray[1], (item) => item[0] === 'fn');
+			name     = fn ? String(fn[3]) : null;
+		}
+
+		return {
+			expires_on: event ? moment.utc(event.eventDate) : null,
+			registrar:  name
+		};
+	},
+
+	/**
+	 * @param   {String}  result  WHOIS response
+	 * @returns {Object}  {expires_on, registrar}
+	 */
+	parseWhois: (result) => {
+		const expiry    = WHOIS_EXPIRY.exec(result);
+		const registrar = WHOIS_REGISTRAR.exec(result);
+
+		let expires_on = null;
+		if (expiry) {
+			expires_on = moment.utc(expiry[1], [moment.ISO_8601, 'YYYY-MM-DD HH:mm:ss', 'YYYY-MM-DD', 'YYYY.MM.DD', 'DD-MMM-YYYY', 'DD.MM.YYYY']);
+		}
+
+		return {
+			expires_on: expires_on && expires_on.isValid() ? expires_on : null,
+			registrar:  registrar ? registrar[1] : null
+		};
+	},
+
+	/**
+	 * RDAP where the registry has it, otherwise WHOIS, which is still the only way for some, ie: .cn
+	 *
+	 * @param   {String}  domain
+	 * @returns {Promise}  resolves with {expires_on, registrar, source}
+	 */
+	lookup: (domain) => {
+		const tld = domain.split('.').pop();
+
+		return internalDomainExpiry.getRdapServer(tld)
+			.catch(() => null)
+			.then((server) => {
+				if (!server) {
+					return null;
+				}
+
+				return internalDomainExpiry.fetchJson(server.replace(/\/+$/, '') + '/domain/' + encodeURIComponent(domain))
+					.then((result) => _.assign({source: 'rdap'}, internalDomainExpiry.parseRdap(result)))
+					.catch((err) => {
+						logger.warn('RDAP lookup for ' + domain + ' failed, trying WHOIS: ' + err.message);
+						return null;
+					});
+			})
+			.then((result) => {
+				if (result && result.expires_on) {
+					return result;
+				}
+
+				return internalDomainExpiry.getWhoisServer(tld)
+					.then((server) => internalDomainExpiry.whois(server, domain))
+					.then((response) => _.assign({source: 'whois'}, internalDomainExpiry.parseWhois(response)));
+			});
+	},
+
+	/**
+	 * Stores the expiry date of the domain and raises an alert once for it, when it's within the warning period
+	 *
+	 * @param   {String}  domain
+	 * @param   {Number}  days
+	 * @returns {Promise}
+	 */
+	checkDomain: (domain, days) => {
+		return domainExpiryModel
+			.query()
+			.where('domain', domain)
+			.first()
+			.then((row) => {
+				return internalDomainExpiry.lookup(domain)
+					.then((result) => {
+						return {
+							expires_on: result.expires_on ? result.expires_on.format('YYYY-MM-DD HH:mm:ss') : null,
+							registrar:  result.registrar ? result.registrar.substring(0, 255) : null,
+							source:     result.source,
+							error:      result.expires_on ? null : 'No expiry date in the ' + result.source.toUpperCase() + ' response'
+						};
+					})
+					.catch((err) => {
+						// Keep what was found before
+						return {error: err.message.substring(0, 255)};
+					})
+					.then((data) => {
+						data.checked_on = moment().utc().format('YYYY-MM-DD HH:mm:ss');
+
+						if (row) {
+							return domainExpiryModel
+								.query()
+								.patchAndFetchById(row.id, data);
+						}
+
+						return domainExpiryModel
+							.query()
+							.insertAndFetch(_.assign({domain: domain, meta: {}}, data));
+					});
+			})
+			.then((row) => {
+				if (!row.expires_on) {
+					return row;
+				}
+
+				const expires_on = moment.utc(row.expires_on).toISOString();
+				const days_left  = moment.utc(row.expires_on).diff(moment.utc(), 'days');
+
+				if (days_left > days || (row.meta && row.meta.alerted_for === expires_on)) {
+					return row;
+				}
+
+				return internalDomainExpiry.alert(row, days_left)
+					.then(() => {
+						return domainExpiryModel
+							.query()
+							.patchAndFetchById(row.id, {meta: _.assign({}, row.meta, {alerted_for: expires_on})});
+					});
+			});
+	},
+
+	/**
+	 * @param   {Object}  row        domain_expiry
+	 * @param   {Number}  days_left
+	 * @returns {Promise}
+	 */
+	alert: (row, days_left) => {
+		const message = days_left < 0 ? 'The registration of ' + row.domain + ' has expired' : 'The registration of ' + row.domain + ' expires in ' + days_left + ' days';
+		logger.warn(message);
+		internalNotifications.send('domain-expiry', days_left < 0 ? 'critical' : 'warning', message);
+
+		return internalAuditLog.add({token: new tokenModel()}, {
+			action:      'alerted',
+			object_type: 'domain-expiry',
+			object_id:   row.id,
+			meta:        _.assign({days_left: days_left}, row)
+		});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('reports:domains')
+			.then(() => {
+				return domainExpiryModel
+					.query()
+					.orderBy('expires_on', 'ASC')
+					.orderBy('domain', 'ASC');
+			});
+	},
+
+	/**
+	 * Runs the check now, whether or not the timer is enabled
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	check: (access) => {
+		return access.can('reports:domains')
+			.then(() => {
+				if (internalDomainExpiry.intervalProcessing) {
+					throw new error.ValidationError('A domain expiry check is already running');
+				}
+
+				return internalDomainExpiry.processDomains(true);
+			});
+	}
+};
+
+module.exports = internalDomainExpiry;
@@ -0,0 +1,147 @@
+const _                    = require('lodash');
+const logger               = require('../logger').nginx;
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const internalNginx        = require('./nginx');
+
+const HOST_TYPES = {
+	proxy_host:       {model: proxyHostModel, graph: '[certificate, access_list.[clients, items]]'},
+	redirection_host: {model: redirectionHostModel, graph: '[certificate]'},
+	dead_host:        {model: deadHostModel, graph: '[certificate]'}
+};
+
+const internalDrain = {
+
+	/**
+	 * Hosts still draining when the backend stopped finish when it's back
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return Promise.all(Object.keys(HOST_TYPES).map((host_type) => {
+			return HOST_TYPES[host_type].model
+				.query()
+				.then((rows) => {
+					rows.filter((row) => row.meta && row.meta.draining_until).forEach((row) => {
+						internalDrain.schedule(host_type, row.id, row.meta.draining_until);
+					});
+				});
+		}));
+	},
+
+	/**
+	 * Removes the config of a host that's been disabled or deleted. With a drain period, new requests
+	 * are first answered with a 503 and Retry-After for that long, while the requests nginx is already
+	 * handling finish.
+	 *
+	 * @param   {String}  host_type  ie: 'proxy_host'
+	 * @param   {Object}  row
+	 * @param   {Number}  [seconds]
+	 * @param   {String}  action     'disabled' or 'deleted'
+	 * @returns {Promise}
+	 */
+	removeConfig: (host_type, row, seconds, action) => {
+		if (!seconds) {
+			return internalNginx.deleteConfig(host_type, row)
+				.then(() => {
+					return internalNginx.reload();
+				});
+		}
+
+		const type           = HOST_TYPES[host_type];
+		const draining_until = new Date(Date.now() + seconds * 1000).toISOString();
+
+		return type.model
+			.query()
+			.where('id', row.id)
+			.withGraphFetched(type.graph)
+			.first()
+			.then((host) => {
+				host.draining = {
+					action:      action,
+					retry_after: seconds
+				};
+
+				return internalNginx.generateConfig(host_type, host)
+					.then(() => {
+						return internalNginx.reload();
+					})
+					.then(() => {
+						logger.info('Draining ' + host_type + ' #' + row.id + ' for ' + seconds + ' seconds');
+
+						return type.model
+							.query()
+							.where('id', row.id)
+							.patch({
+								meta: _.assign({}, host.meta, {draining_until: draining_until})
+							});
+					})
+					.then(() => {
+						internalDrain.schedule(host_type, row.id, draining_until);
+					})
+					.catch((err) => {
+						logger.warn('Could not drain ' + host_type + ' #' + row.id + ', removing its config now: ' + err.message);
+						return internalNginx.deleteConfig(host_type, row)
+							.then(() => {
+								return internalNginx.reload();
+							});
+					});
+			});
+	},
+
+	/**
+	 * @param   {String}  host_type
+	 * @param   {Number}  host_id
+	 * @param   {String}  draining_until
+	 */
+	schedule: (host_type, host_id, draining_until) => {
+		setTimeout(() => {
+			internalDrain.finish(host_type, host_id)
+				.catch((err) => {
+					logger.error('Could not finish draining ' + host_type + ' #' + host_id + ': ' + err.message);
+				});
+		}, Math.max(0, new Date(draining_until).getTime() - Date.now()));
+	},
+
+	/**
+	 * Removes the config at the end of the drain period, unless the host has been enabled again since
+	 *
+	 * @param   {String}  host_type
+	 * @param   {Number}  host_id
+	 * @returns {Promise}
+	 */
+	finish: (host_type, host_id) => {
+		const model = HOST_TYPES[host_type].model;
+
+		return model
+			.query()
+			.where('id', host_id)
+			.first()
+			.then((row) => {
+				if (!row || !row.meta || !row.meta.draining_until) {
+					return;
+				}
+
+				return model
+					.query()
+					.where('id', host_id)
+					.patch({
+						meta: _.omit(row.meta, ['draining_until'])
+					})
+					.then(() => {
+						if (row.enabled && !row.is_deleted) {
+							return;
+						}
+
+						logger.info('Finished draining ' + host_type + ' #' + host_id);
+						return internalNginx.deleteConfig(host_type, row)
+							.then(() => {
+								return internalNginx.reload();
+							});
+					});
+			});
+	}
+};
+
+module.exports = internalDrain;
@@ -0,0 +1,306 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const error                = require('../lib/error');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const streamModel          = require('../models/stream');
+const internalNginx        = require('./nginx');
+const internalAuditLog     = require('./audit-log');
+const internalLock         = require('./lock');
+
+/**
+ * The resources that have a config file, with what's needed to render it
+ */
+const TYPES = {
+	'proxy-host': {
+		host_type: 'proxy_host',
+		expand:    '[certificate,access_list.[clients,items]]',
+		model:     proxyHostModel
+	},
+	'redirection-host': {
+		host_type: 'redirection_host',
+		expand:    '[certificate]',
+		model:     redirectionHostModel
+	},
+	'dead-host': {
+		host_type: 'dead_host',
+		expand:    '[certificate]',
+		model:     deadHostModel
+	},
+	'stream': {
+		host_type: 'stream',
+		expand:    null,
+		model:     streamModel
+	}
+};
+
+const internalDrift = {
+
+	/**
+	 * A line diff of the config NPM would write against the file on disk
+	 *
+	 * @param   {String}  expected
+	 * @param   {String}  actual
+	 * @returns {Object}  the lines only in actual, and the lines only in expected
+	 */
+	diffLines: (expected, actual) => {
+		const a = expected.split('\n');
+		const b = actual.split('\n');
+
+		// Longest common subsequence, from the end so the walk below goes forwards
+		let lcs = [];
+		for (let i = a.length; i >= 0; i--) {
+			lcs[i] = [];
+			for (let j = b.length; j >= 0; j--) {
+				if (i === a.length || j === b.length) {
+					lcs[i][j] = 0;
+				} else if (a[i] === b[j]) {
+					lcs[i][j] = lcs[i + 1][j + 1] + 1;
+				} else {
+					lcs[i][j] = Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+				}
+			}
+		}
+
+		let added   = [];
+		let removed = [];
+		let i       = 0;
+		let j       = 0;
+		while (i < a.length || j < b.length) {
+			if (i < a.length && j < b.length && a[i] === b[j]) {
+				i++;
+				j++;
+			} else if (j < b.length && (i === a.length || lcs[i][j + 1] >= lcs[i + 1][j])) {
+				added.push(b[j++]);
+			} else {
+				removed.push(a[i++]);
+			}
+		}
+
+		return {added: added, removed: removed};
+	},
+
+	/**
+	 * @param   {String}  object_type
+	 * @param   {Object}  row
+	 * @returns {Promise}  resolves with null when the file is as expected
+	 */
+	check: (object_type, row) => {
+		const type     = TYPES[object_type];
+		const filename = internalNginx.getConfigName(type.host_type, row.id);
+		const result   = (status, diff) => {
+			return _.assign({
+				object_type: object_type,
+				object_id:   row.id,
+				file:        filename,
+				status:      status
+			}, diff || {added: [], removed: []});
+		};
+
+		const exists = fs.existsSync(filename);
+
+		if (!row.enabled) {
+			return Promise.resolve(exists ? result('unexpected') : null);
+		}
+
+		// Configs that failed nginx's test are left as .err files on purpose
+		if (row.meta && row.meta.nginx_online === false) {
+			return Promise.resolve(null);
+		}
+
+		if (!exists) {
+			return Promise.resolve(result('missing'));
+		}
+
+		return internalNginx.renderConfig(type.host_type, row)
+			.then((expected) => {
+				const actual = fs.readFileSync(filename, {encoding: 'utf8'});
+				if (actual === expected) {
+					return null;
+				}
+
+				return result('modified', internalDrift.diffLines(expected, actual));
+			});
+	},
+
+	/**
+	 * @param   {String}  object_type
+	 * @param   {Number}  id
+	 * @returns {Promise}
+	 */
+	getRow: (object_type, id) => {
+		const type = TYPES[object_type];
+		if (!type) {
+			return Promise.reject(new error.ValidationError('Items of type ' + object_type + ' don\'t have a config file'));
+		}
+
+		let query = type.model
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('id', id)
+			.first();
+
+		if (type.expand) {
+			query.withGraphFetched(type.expand);
+		}
+
+		return query.then((row) => {
+			if (!row) {
+				throw new error.ItemNotFoundError(id);
+			}
+			return row;
+		});
+	},
+
+	/**
+	 * Every config file that isn't what NPM would write for it, usually from a manual edit inside the container
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		let drifted  = [];
+		let sequence = access.can('nginx:drift');
+
+		_.forEach(TYPES, (type, object_type) => {
+			sequence = sequence
+				.then(() => {
+					let query = type.model
+						.query()
+						.where('is_deleted', 0)
+						.orderBy('id', 'ASC');
+
+					if (type.expand) {
+						query.withGraphFetched(type.expand);
+					}
+
+					return query;
+				})
+				.then((rows) => {
+					return rows.reduce((promise, row) => {
+						return promise
+							.then(() => {
+								return internalDrift.check(object_type, row);
+							})
+							.then((result) => {
+								if (result) {
+									drifted.push(result);
+								}
+							});
+					}, Promise.resolve());
+				});
+		});
+
+		return sequence.then(() => {
+			return drifted;
+		});
+	},
+
+	/**
+	 * Overwrites the file on disk with what NPM would write
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.object_type
+	 * @param   {Number}  data.object_id
+	 * @returns {Promise}
+	 */
+	rerender: (access, data) => {
+		return access.can('nginx:drift')
+			.then(() => {
+				return internalDrift.getRow(data.object_type, data.object_id);
+			})
+			.then((row) => {
+				const type = TYPES[data.object_type];
+
+				if (!row.enabled) {
+					return internalNginx.deleteConfig(type.host_type, row, false)
+						.then(() => {
+							return internalNginx.reload();
+						});
+				}
+
+				return internalNginx.configure(type.model, type.host_type, row);
+			})
+			.then(() => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'rerendered',
+					object_type: data.object_type,
+					object_id:   data.object_id,
+					meta:        data
+				});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * Keeps lines added to the file by hand, by moving them into the advanced config of the host
+	 * and rendering it again. Changed or removed lines can't be kept this way.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.object_type
+	 * @param   {Number}  data.object_id
+	 * @returns {Promise}
+	 */
+	adopt: (access, data) => {
+		const type = TYPES[data.object_type];
+
+		return access.can('nginx:drift')
+			.then(() => {
+				if (data.object_type === 'stream') {
+					throw new error.ValidationError('Streams don\'t have an advanced config to adopt changes into');
+				}
+
+				return internalDrift.getRow(data.object_type, data.object_id);
+			})
+			.then((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+
+				return internalDrift.check(data.object_type, row)
+					.then((result) => {
+						if (!result) {
+							throw new error.ValidationError('The config file has no changes to adopt');
+						} else if (result.status !== 'modified') {
+							throw new error.ValidationError('Only changes inside a config file can be adopted, this one is ' + result.status);
+						} else if (result.removed.length) {
+							throw new error.ValidationError('Only added lines can be adopted, ' + result.removed.length + ' line(s) were changed or removed');
+						}
+
+						const advanced_config = _.trimEnd([row.advanced_config || '', result.added.join('\n')].join('\n').replace(/^\n+/, ''));
+
+						return type.model
+							.query()
+							.patchAndFetchById(row.id, {
+								advanced_config: advanced_config
+							})
+							.then(() => {
+								return internalDrift.getRow(data.object_type, row.id);
+							})
+							.then((saved_row) => {
+								return internalNginx.configure(type.model, type.host_type, saved_row);
+							})
+							.then(() => {
+								// Add to audit log
+								return internalAuditLog.add(access, {
+									action:      'updated',
+									object_type: data.object_type,
+									object_id:   row.id,
+									meta:        {
+										advanced_config: advanced_config
+									}
+								});
+							});
+					});
+			})
+			.then(() => {
+				return internalDrift.getRow(data.object_type, data.object_id);
+			});
+	}
+};
+
+module.exports = internalDrift;
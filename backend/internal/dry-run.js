@@ -0,0 +1,510 @@
+const _                       = require('lodash');
+const fs                      = require('fs');
+const config                  = require('../lib/config');
+const error                   = require('../lib/error');
+const apiValidator            = require('../lib/validator/api');
+const schema                  = require('../schema');
+const certificateModel        = require('../models/certificate');
+const accessListModel         = require('../models/access_list');
+const internalProxyHost       = require('./proxy-host');
+const internalRedirectionHost = require('./redirection-host');
+const internalDeadHost        = require('./dead-host');
+const internalStream          = require('./stream');
+const internalCertificate     = require('./certificate');
+const internalAccessList      = require('./access-list');
+const internalAcmeAccount     = require('./acme-account');
+const internalAcmeEab         = require('./acme-eab-credential');
+const internalAdminListen     = require('./admin-listen');
+const internalAppPresets      = require('./app-presets');
+const internalHostDefaults    = require('./host-defaults');
+const internalCertMatch       = require('./certificate-match');
+const internalHost            = require('./host');
+const internalQuota           = require('./quota');
+const internalLock            = require('./lock');
+const internalNginx           = require('./nginx');
+const internalListen          = require('./listen');
+const internalRedirectRules   = require('./redirect-rules');
+const internalUpstreamTls     = require('./upstream-tls');
+const internalUpstreamAuth    = require('./upstream-auth');
+const internalCorsPolicy      = require('./cors-policy');
+const internalHotlink         = require('./hotlink');
+const internalUploads         = require('./uploads');
+const internalTrafficSplit    = require('./traffic-split');
+const internalLoadBalancing   = require('./load-balancing');
+const internalFallback        = require('./fallback');
+const internalExemptions      = require('./access-exemptions');
+const internalServedFiles     = require('./served-files');
+const internalHostPorts       = require('./host-ports');
+const internalCanonicalHost   = require('./canonical-host');
+const internalProxyProtocol   = require('./proxy-protocol');
+const internalUpstreamSets    = require('./upstream-sets');
+
+/**
+ * What can be tried out, the module that has it, where its payloads are validated and what it's written to
+ */
+const TYPES = {
+	'proxy-host': {
+		module:     internalProxyHost,
+		path:       '/nginx/proxy-hosts',
+		item_path:  '/nginx/proxy-hosts/{hostID}',
+		param:      'host_id',
+		permission: 'proxy_hosts',
+		host_type:  'proxy_host',
+		expand:     ['owner', 'certificate', 'access_list.[clients,items]']
+	},
+	'redirection-host': {
+		module:     internalRedirectionHost,
+		path:       '/nginx/redirection-hosts',
+		item_path:  '/nginx/redirection-hosts/{hostID}',
+		param:      'host_id',
+		permission: 'redirection_hosts',
+		host_type:  'redirection_host',
+		expand:     ['owner', 'certificate']
+	},
+	'dead-host': {
+		module:     internalDeadHost,
+		path:       '/nginx/dead-hosts',
+		item_path:  '/nginx/dead-hosts/{hostID}',
+		param:      'host_id',
+		permission: 'dead_hosts',
+		host_type:  'dead_host',
+		expand:     ['owner', 'certificate']
+	},
+	'stream': {
+		module:     internalStream,
+		path:       '/nginx/streams',
+		item_path:  '/nginx/streams/{streamID}',
+		param:      'stream_id',
+		permission: 'streams',
+		host_type:  'stream',
+		expand:     ['owner']
+	},
+	'certificate': {
+		module:     internalCertificate,
+		path:       '/nginx/certificates',
+		item_path:  null,
+		param:      'certificate_id',
+		permission: 'certificates',
+		host_type:  null,
+		expand:     null
+	},
+	'access-list': {
+		module:     internalAccessList,
+		path:       '/nginx/access-lists',
+		item_path:  '/nginx/access-lists/{listID}',
+		param:      'list_id',
+		permission: 'access_lists',
+		host_type:  null,
+		expand:     ['owner', 'items', 'clients', 'proxy_hosts.[certificate,access_list.[clients,items]]']
+	}
+};
+
+// The host types ignored when checking the domain names of a host against the others
+const DOMAIN_TYPES = {
+	'proxy-host':       'proxy',
+	'redirection-host': 'redirection',
+	'dead-host':        'dead'
+};
+
+const internalDryRun = {
+
+	types: TYPES,
+
+	/**
+	 * The checks a host goes through before it's saved
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  data     payload
+	 * @param   {Object}  [row]    the host, when it's updated
+	 * @returns {Promise}  resolves with whether a certificate would be requested for it
+	 */
+	checkHost: (access, object_type, data, row) => {
+		let create_certificate = false;
+
+		return Promise.resolve()
+			.then(() => {
+				if (row) {
+					return;
+				}
+
+				return (object_type === 'proxy-host' ? internalAppPresets.apply(data) : Promise.resolve())
+					.then(() => {
+						return internalHostDefaults.apply(object_type, data);
+					});
+			})
+			.then(() => {
+				const domain_names = data.domain_names || row.domain_names;
+				return internalCertMatch.apply(access, data, internalHost.normaliseDomainNames(domain_names), row ? row.meta : undefined);
+			})
+			.then(() => {
+				create_certificate = data.certificate_id === 'new';
+				if (create_certificate) {
+					delete data.certificate_id;
+				}
+
+				if (typeof data.domain_names !== 'undefined') {
+					data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+					return internalHost.assertDomainNamesAvailable(data.domain_names, row ? DOMAIN_TYPES[object_type] : undefined, row ? row.id : undefined);
+				}
+			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalRedirectRules.validate(data);
+			})
+			.then(() => {
+				if (object_type !== 'proxy-host') {
+					return;
+				}
+
+				return internalUpstreamTls.validate(data.upstream_tls)
+					.then(() => {
+						return internalUpstreamAuth.validate(data.upstream_auth);
+					})
+					.then(() => {
+						return internalCorsPolicy.validate(data);
+					})
+					.then(() => {
+						return internalHotlink.validate(data);
+					})
+					.then(() => {
+						return internalUploads.validate(data);
+					})
+					.then(() => {
+						return internalTrafficSplit.validate(data.traffic_split);
+					})
+					.then(() => {
+						return internalLoadBalancing.validate(data.load_balancing);
+					})
+					.then(() => {
+						return internalHostPorts.validate(data, row || null, create_certificate);
+					})
+					.then(() => {
+						return internalCanonicalHost.validate(data, row);
+					})
+					.then(() => {
+						return internalFallback.validate(data, row);
+					})
+					.then(() => {
+						return internalExemptions.validate(data, row);
+					})
+					.then(() => {
+						return internalServedFiles.validate(data, row);
+					})
+					.then(() => {
+						return row ? internalProxyProtocol.prepareUpdate(object_type, row, data) : internalProxyProtocol.prepareCreate(object_type, data);
+					})
+					.then(() => {
+						internalUpstreamSets.prepare(data, row);
+					});
+			})
+			.then(() => {
+				return create_certificate;
+			});
+	},
+
+	/**
+	 * The host as it would be saved, with the certificate and access list its config is rendered with
+	 *
+	 * @param   {Object}   data
+	 * @param   {Object}   [row]
+	 * @param   {Boolean}  create_certificate
+	 * @returns {Promise}
+	 */
+	getHost: (data, row, create_certificate) => {
+		let host = internalHost.cleanSslHstsData(data, row ? _.omit(row, ['certificate', 'access_list']) : {id: 0, meta: {}, locations: []});
+
+		if (create_certificate) {
+			// Requested once it's saved, until then it's served over http
+			host.certificate_id = 0;
+		}
+
+		return Promise.all([
+			host.certificate_id ? certificateModel.query().where('id', host.certificate_id).andWhere('is_deleted', 0).first() : null,
+			host.access_list_id ? accessListModel.query().where('id', host.access_list_id).andWhere('is_deleted', 0).withGraphFetched('[clients,items]').first() : null
+		])
+			.then(([certificate, access_list]) => {
+				if (host.certificate_id && !certificate) {
+					throw new error.ValidationError('There\'s no certificate #' + host.certificate_id);
+				}
+				if (host.access_list_id && !access_list) {
+					throw new error.ValidationError('There\'s no access list #' + host.access_list_id);
+				}
+
+				host.certificate = certificate || null;
+				host.access_list = access_list || null;
+				return host;
+			});
+	},
+
+	/**
+	 * What the change does, and the config files it writes or deletes
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {String}  action
+	 * @param   {Object}  data     validated payload, null when deleting
+	 * @param   {Object}  [row]    what's changed, for updates and deletes
+	 * @returns {Promise}  resolves with {result, configs: [{host_type, host}], meta}
+	 */
+	prepare: (access, object_type, action, data, row) => {
+		const type = TYPES[object_type];
+
+		if (type.host_type && type.host_type !== 'stream') {
+			if (action === 'delete') {
+				return Promise.resolve({result: row, configs: [{host_type: type.host_type, host: row, deleted: true}], meta: {}});
+			}
+
+			return internalDryRun.checkHost(access, object_type, data, row)
+				.then((create_certificate) => {
+					return internalDryRun.getHost(data, row, create_certificate)
+						.then((host) => {
+							return {
+								result:  host,
+								configs: [{host_type: type.host_type, host: host, deleted: !host.enabled && typeof host.enabled !== 'undefined'}],
+								meta:    {create_certificate: create_certificate}
+							};
+						});
+				});
+		}
+
+		if (object_type === 'stream') {
+			if (action === 'delete') {
+				return Promise.resolve({result: row, configs: [{host_type: 'stream', host: row, deleted: true}], meta: {}});
+			}
+
+			return internalProxyProtocol.validateStream(data, row)
+				.then(() => {
+					return internalListen.validate(data.listen);
+				})
+				.then(() => {
+					return internalHostPorts.validateStream(data, row);
+				})
+				.then(() => {
+					return internalStream.validateAccessList(data);
+				})
+				.then(() => {
+					const host = _.assign({id: 0, meta: {}}, row ? _.omit(row, ['owner', 'access_list']) : {}, data);
+					return {
+						result:  host,
+						configs: [{host_type: 'stream', host: host, deleted: !host.enabled && typeof host.enabled !== 'undefined'}],
+						meta:    {}
+					};
+				});
+		}
+
+		if (object_type === 'certificate') {
+			// Certificates aren't part of any config until a host uses them
+			if (action === 'delete') {
+				return internalAdminListen.assertCertificateUnused(row.id)
+					.then(() => {
+						return {result: row, configs: [], meta: {}};
+					});
+			}
+
+			if (data.domain_names) {
+				data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+			}
+
+			return Promise.resolve()
+				.then(() => {
+					if (data.provider === 'letsencrypt' && data.meta && data.meta.use_staging && data.meta.acme_account_id) {
+						throw new error.ValidationError('Staging can\'t be used with an ACME account, select a staging account instead');
+					}
+
+					if (data.provider === 'letsencrypt' && data.meta && data.meta.acme_account_id) {
+						return internalAcmeAccount.get(access, {id: data.meta.acme_account_id})
+							.then((account) => {
+								if (account.status !== 'valid') {
+									throw new error.ValidationError('ACME account ' + account.name + ' is ' + account.status);
+								}
+							});
+					}
+
+					// The account would be registered when it's saved, so only the credentials are checked
+					if (data.provider === 'letsencrypt' && data.meta && data.meta.acme_eab_credential_id) {
+						return internalAcmeEab.getBinding(access, data.meta.acme_eab_credential_id)
+							.then(() => {});
+					}
+				})
+				.then(() => {
+					if (data.provider === 'letsencrypt' || (data.provider === 'internal' && !data.nice_name)) {
+						data.nice_name = data.domain_names.join(', ');
+					}
+
+					return {
+						result:  _.assign({id: 0, expires_on: null}, data, {meta: internalCertificate.cleanMeta(data.meta || {})}),
+						configs: [],
+						meta:    {}
+					};
+				});
+		}
+
+		// Access lists are written into the configs of the proxy hosts and streams that use them
+		if (action === 'create') {
+			return Promise.resolve({result: _.assign({id: 0}, internalAccessList.maskItems(_.cloneDeep(data))), configs: [], meta: {}});
+		}
+
+		return internalAccessList.getStreams(row.id)
+			.then((streams) => {
+				if (action === 'update' && typeof data.clients !== 'undefined' && data.clients && !data.clients.filter((client) => client.address).length && streams.length) {
+					throw new error.ValidationError('This access list is used by ' + streams.length + ' stream(s), which can only use its client rules. Keep at least one.');
+				}
+
+				const access_list = action === 'delete' ? null : _.assign(_.omit(row, ['proxy_hosts', 'owner']), internalAccessList.maskItems(_.cloneDeep(data)));
+				const list_id     = access_list ? row.id : 0;
+
+				const configs = (row.proxy_hosts || []).map((host) => {
+					return {host_type: 'proxy_host', host: _.assign({}, host, {access_list_id: list_id, access_list: access_list})};
+				}).concat(streams.map((stream) => {
+					return {host_type: 'stream', host: _.assign({}, stream, {access_list_id: list_id, access_list: access_list})};
+				}));
+
+				return {
+					result:  action === 'delete' ? _.omit(row, ['proxy_hosts']) : _.omit(access_list, ['proxy_hosts']),
+					configs: configs.filter((config) => config.host.enabled),
+					meta:    {}
+				};
+			});
+	},
+
+	/**
+	 * Renders the configs the change would write and tests nginx with them
+	 *
+	 * @param   {Array}   configs  [{host_type, host, deleted}]
+	 * @returns {Promise}  resolves with {files, nginx}
+	 */
+	test: (configs) => {
+		let files = {};
+
+		return configs.reduce((promise, item) => {
+			return promise
+				.then(() => {
+					return item.deleted ? null : internalNginx.renderConfig(item.host_type, item.host);
+				})
+				.then((text) => {
+					const file   = internalNginx.getConfigName(item.host_type, item.host.id);
+					const exists = fs.existsSync(file);
+					let status   = 'created';
+
+					if (text === null) {
+						status = exists ? 'deleted' : 'unchanged';
+					} else if (exists) {
+						status = fs.readFileSync(file, {encoding: 'utf8'}) === text ? 'unchanged' : 'changed';
+					}
+
+					files[file] = {
+						file:      file,
+						host_type: item.host_type,
+						object_id: item.host.id,
+						status:    status,
+						config:    text
+					};
+				});
+		}, Promise.resolve())
+			.then(() => {
+				const changes = _.pickBy(files, (file) => file.status !== 'unchanged');
+				if (_.isEmpty(changes)) {
+					return null;
+				}
+
+				return internalNginx.testWith(_.mapValues(changes, 'config'));
+			})
+			.then((nginx_err) => {
+				// nginx opens the logs of a config when testing it, new hosts would leave empty ones behind with an id of 0
+				_.forEach(files, (file) => {
+					if (file.object_id === 0 && file.host_type !== 'stream') {
+						['access', 'error'].forEach((log) => {
+							const name = config.getPath('logs') + '/' + file.host_type.replace('_', '-') + '-0_' + log + '.log';
+							if (fs.existsSync(name) && !fs.statSync(name).size) {
+								fs.unlinkSync(name);
+							}
+						});
+					}
+				});
+
+				return {
+					files: _.values(files),
+					nginx: {
+						tested: _.some(files, (file) => file.status !== 'unchanged'),
+						online: !nginx_err,
+						error:  nginx_err || null
+					}
+				};
+			});
+	},
+
+	/**
+	 * Goes through a create, update or delete like the API does, rendering the configs and testing nginx
+	 * with them, but without saving anything, writing a file, requesting a certificate or reloading nginx
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  key of TYPES
+	 * @param   {String}  action       'create', 'update' or 'delete'
+	 * @param   {Object}  params       of the request, with the id of the object for updates and deletes
+	 * @param   {Object}  body         of the request
+	 * @returns {Promise}
+	 */
+	run: (access, object_type, action, params, body) => {
+		const type = TYPES[object_type];
+		const id   = action === 'create' ? 0 : parseInt(params[type.param], 10);
+
+		return access.can(type.permission + ':' + action, action === 'create' ? body : id)
+			.then(() => {
+				if (action === 'create') {
+					return internalQuota.check(access, type.permission)
+						.then(() => {
+							return null;
+						});
+				}
+
+				return type.module.get(access, {id: id, expand: type.expand})
+					.then((row) => {
+						internalLock.assertUnlocked(row, action === 'update' ? 'updated' : 'deleted');
+						return row;
+					});
+			})
+			.then((row) => {
+				if (action === 'delete') {
+					return internalDryRun.prepare(access, object_type, action, null, row);
+				}
+
+				return apiValidator(schema.getValidationSchema(action === 'create' ? type.path : type.item_path, action === 'create' ? 'post' : 'put'), body)
+					.then((data) => {
+						if (row) {
+							data.id = row.id;
+						}
+						return internalDryRun.prepare(access, object_type, action, data, row);
+					});
+			})
+			.then((prepared) => {
+				return internalDryRun.test(prepared.configs)
+					.then((tested) => {
+						let result = prepared.result;
+
+						if (type.host_type && action !== 'delete') {
+							result = _.assign({}, result, {
+								meta: _.assign({}, result.meta, {
+									nginx_online: tested.nginx.online,
+									nginx_err:    tested.nginx.error
+								})
+							});
+						}
+
+						return {
+							dry_run:            true,
+							object_type:        object_type,
+							action:             action,
+							result:             result,
+							create_certificate: !!prepared.meta.create_certificate,
+							files:              tested.files,
+							nginx:              tested.nginx
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalDryRun;
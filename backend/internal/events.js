@@ -0,0 +1,181 @@
+const _                  = require('lodash');
+const logger             = require('../logger').global;
+const auditLogModel      = require('../models/audit-log');
+const activityEventModel = require('../models/activity_event');
+
+// How often the tables are checked for new rows
+const POLL_INTERVAL = 2000;
+
+// The most rows of each table sent at once, when a client catches up after being away
+const BATCH_SIZE = 500;
+
+const internalEvents = {
+
+	/**
+	 * The id of an event is where the stream is up to in both tables, so a client
+	 * sending it back in Last-Event-ID gets everything after it.
+	 *
+	 * @param   {String}  id  ie: 120.45
+	 * @returns {Object}  {audit, activity}, or null when it isn't one
+	 */
+	parseId: (id) => {
+		const match = typeof id === 'string' ? id.trim().match(/^(\d+)\.(\d+)$/) : null;
+		if (!match) {
+			return null;
+		}
+		return {
+			audit:    parseInt(match[1], 10),
+			activity: parseInt(match[2], 10)
+		};
+	},
+
+	/**
+	 * @param   {Object}  cursor
+	 * @returns {String}
+	 */
+	formatId: (cursor) => {
+		return cursor.audit + '.' + cursor.activity;
+	},
+
+	/**
+	 * Where both tables are up to now
+	 *
+	 * @returns {Promise}
+	 */
+	getCursor: () => {
+		return Promise.all([
+			auditLogModel.query().max('id as id').first(),
+			activityEventModel.query().max('id as id').first()
+		])
+			.then(([audit, activity]) => {
+				return {
+					audit:    audit && audit.id ? parseInt(audit.id, 10) : 0,
+					activity: activity && activity.id ? parseInt(activity.id, 10) : 0
+				};
+			});
+	},
+
+	/**
+	 * The events after the cursor, oldest first, each with the cursor after it as its id
+	 *
+	 * @param   {Object}  cursor
+	 * @returns {Promise}  resolves with ie: [{id: '121.45', event: 'audit', data: {}}]
+	 */
+	getSince: (cursor) => {
+		return Promise.all([
+			auditLogModel
+				.query()
+				.where('id', '>', cursor.audit)
+				.orderBy('id', 'ASC')
+				.limit(BATCH_SIZE),
+			activityEventModel
+				.query()
+				.where('id', '>', cursor.activity)
+				.orderBy('id', 'ASC')
+				.limit(BATCH_SIZE)
+		])
+			.then(([audit, activity]) => {
+				const items = [].concat(
+					audit.map((row) => {
+						return {
+							table: 'audit',
+							row:   row,
+							data:  {
+								object_type: row.object_type,
+								object_id:   row.object_id,
+								action:      row.action,
+								user_id:     row.user_id,
+								created_on:  row.created_on
+							}
+						};
+					}),
+					activity.map((row) => {
+						return {
+							table: 'activity',
+							row:   row,
+							data:  {
+								object_type: row.object_type,
+								object_id:   row.object_id,
+								source:      row.source,
+								event:       row.event,
+								success:     row.is_success,
+								message:     row.meta.message || null,
+								created_on:  row.created_on
+							}
+						};
+					})
+				);
+
+				let position = _.clone(cursor);
+
+				return _.sortBy(items, [(item) => new Date(item.row.created_on).getTime(), 'row.id']).map((item) => {
+					position[item.table] = Math.max(position[item.table], item.row.id);
+
+					return {
+						id:    internalEvents.formatId(position),
+						event: item.table,
+						data:  item.data
+					};
+				});
+			});
+	},
+
+	/**
+	 * Sends the audit log and activity of hosts and certificates as it happens, starting after
+	 * the last event the client had, or from now.
+	 *
+	 * @param   {Access}    access
+	 * @param   {String}    [last_event_id]
+	 * @param   {Function}  send  called with each event
+	 * @returns {Promise}   resolves with a function to stop
+	 */
+	subscribe: (access, last_event_id, send) => {
+		return access.can('events:stream')
+			.then(() => {
+				const cursor = internalEvents.parseId(last_event_id);
+				return cursor ? cursor : internalEvents.getCursor();
+			})
+			.then((cursor) => {
+				let stopped = false;
+				let polling = false;
+				let timer   = null;
+
+				const poll = () => {
+					if (polling || stopped) {
+						return;
+					}
+					polling = true;
+
+					internalEvents.getSince(cursor)
+						.then((events) => {
+							if (stopped) {
+								return;
+							}
+							events.forEach((event) => {
+								send(event);
+							});
+							if (events.length) {
+								cursor = internalEvents.parseId(_.last(events).id);
+							}
+						})
+						.catch((err) => {
+							logger.warn('Could not get events: ' + err.message);
+						})
+						.then(() => {
+							polling = false;
+						});
+				};
+
+				// After the caller has had the chance to start its answer
+				setImmediate(poll);
+				timer = setInterval(poll, POLL_INTERVAL);
+
+				return () => {
+					stopped = true;
+					clearInterval(timer);
+				};
+			});
+	}
+};
+
+module.exports = internalEvents;
@@ -0,0 +1,231 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const path                 = require('path');
+const archiver             = require('archiver');
+const pjson                = require('../package.json');
+const logger               = require('../logger').nginx;
+const config               = require('../lib/config');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const streamModel          = require('../models/stream');
+const accessListModel      = require('../models/access_list');
+const certificateModel     = require('../models/certificate');
+const internalNginx        = require('./nginx');
+const internalAuditLog     = require('./audit-log');
+const internalCertStorage  = require('./certificate-storage');
+
+const REDACTED = '# Redacted on export\n';
+
+/**
+ * The host types that get a config file, and the model for each
+ */
+const HOST_TYPES = {
+	proxy_host:       proxyHostModel,
+	redirection_host: redirectionHostModel,
+	dead_host:        deadHostModel,
+	stream:           streamModel
+};
+
+const internalExport = {
+
+	/**
+	 * Everything that isn't deleted, for the manifest and to work out the files to include
+	 *
+	 * @returns {Promise}
+	 */
+	getRows: () => {
+		const types = Object.keys(HOST_TYPES);
+
+		return Promise.all(types.map((type) => {
+			return HOST_TYPES[type]
+				.query()
+				.where('is_deleted', 0)
+				.orderBy('id', 'ASC');
+		}))
+			.then((results) => {
+				let rows = _.zipObject(types, results);
+
+				return accessListModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('id', 'ASC')
+					.then((access_lists) => {
+						rows.access_list = access_lists;
+
+						let certificate_ids = [];
+						['proxy_host', 'redirection_host', 'dead_host'].forEach((type) => {
+							certificate_ids = certificate_ids.concat(rows[type].map((row) => row.certificate_id));
+						});
+
+						return certificateModel
+							.query()
+							.where('is_deleted', 0)
+							.whereIn('id', _.uniq(certificate_ids).filter((id) => id > 0).concat([0]))
+							.orderBy('id', 'ASC');
+					})
+					.then((certificates) => {
+						rows.certificate = certificates;
+						return rows;
+					});
+			});
+	},
+
+	/**
+	 * The files a certificate is served from, mapped to the name they're given in the bundle
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Array}
+	 */
+	getCertificateFiles: (certificate) => {
+		const dir = internalCertStorage.getDirectory(certificate);
+
+		if (!fs.existsSync(dir)) {
+			return [];
+		}
+
+		return fs.readdirSync(dir)
+			.filter((name) => name.endsWith('.pem'))
+			.map((name) => {
+				return {
+					// Let's Encrypt live files are links into the archive, which isn't included
+					file:   fs.realpathSync(path.join(dir, name)),
+					name:   path.join(dir, name).substring(1),
+					secret: name.startsWith('privkey')
+				};
+			});
+	},
+
+	/**
+	 * @param   {Object}   rows
+	 * @param   {Boolean}  redact_keys
+	 * @returns {Object}
+	 */
+	getManifest: (rows, redact_keys) => {
+		let manifest = {
+			exported_on: new Date().toISOString(),
+			version:     pjson.version,
+			redact_keys: redact_keys
+		};
+
+		Object.keys(HOST_TYPES).forEach((type) => {
+			manifest[type + 's'] = rows[type].map((row) => {
+				return _.assign(_.pick(row, ['id', 'domain_names', 'incoming_port', 'enabled', 'certificate_id', 'access_list_id', 'tags', 'notes']), {
+					config: 'data/nginx' + internalNginx.getConfigName(type, row.id).substring(config.getPath('nginx').length)
+				});
+			});
+		});
+
+		manifest.access_lists = rows.access_list.map((row) => {
+			return _.assign(_.pick(row, ['id', 'name', 'notes']), {
+				htpasswd: 'data/access/' + row.id
+			});
+		});
+
+		manifest.certificates = rows.certificate.map((row) => {
+			return _.pick(row, ['id', 'provider', 'nice_name', 'domain_names', 'expires_on', 'tags', 'notes']);
+		});
+
+		return manifest;
+	},
+
+	/**
+	 * A gzipped tarball of every generated config file, the nginx configuration they're included from,
+	 * and the certificates and access lists they refer to. Paths in the bundle are the paths on disk,
+	 * without the leading slash. It's written out as it's made, so it's never all in memory or on disk.
+	 *
+	 * @param   {Access}    access
+	 * @param   {Object}    data
+	 * @param   {Boolean}   [data.redact_keys]  leave out private keys and access list passwords
+	 * @param   {Function}  open                called when the bundle is about to start, returns the stream to write it to
+	 * @returns {Promise}   resolves when it's been written, or the stream was closed
+	 */
+	getNginxBundle: (access, data, open) => {
+		const redact_keys = !!data.redact_keys;
+
+		return access.can('nginx:export')
+			.then(() => {
+				return internalExport.getRows();
+			})
+			.then((rows) => {
+				const archive = archiver('tar', {gzip: true, gzipOptions: {level: 9}});
+				const stream  = open();
+
+				return new Promise((resolve, reject) => {
+					archive
+						.on('warning', (err) => logger.warn('Export: ' + err.message))
+						.on('error', reject)
+						.pipe(stream);
+
+					stream.on('finish', resolve);
+					stream.on('close', () => {
+						// The download was cancelled
+						if (!stream.writableFinished) {
+							archive.abort();
+						}
+						resolve();
+					});
+
+					archive.append(JSON.stringify(internalExport.getManifest(rows, redact_keys), null, 2) + '\n', {name: 'manifest.json'});
+
+					['/etc/nginx/nginx.conf', '/etc/nginx/mime.types'].forEach((file) => {
+						if (fs.existsSync(file)) {
+							archive.file(file, {name: file.substring(1)});
+						}
+					});
+
+					archive.directory('/etc/nginx/conf.d', 'etc/nginx/conf.d');
+					archive.directory(config.getPath('nginx'), 'data/nginx', (entry) => {
+						// Left over from certificate requests, not part of the running config
+						return entry.name.startsWith('data/nginx/temp/') ? false : entry;
+					});
+
+					rows.certificate.forEach((certificate) => {
+						internalExport.getCertificateFiles(certificate).forEach((item) => {
+							if (item.secret && redact_keys) {
+								archive.append(REDACTED, {name: item.name});
+							} else {
+								archive.file(item.file, {name: item.name});
+							}
+						});
+					});
+
+					if (fs.existsSync(config.getPath('upstream_ca'))) {
+						archive.directory(config.getPath('upstream_ca'), 'data/upstream_ca');
+					}
+
+					if (fs.existsSync(config.getPath('fallback'))) {
+						archive.directory(config.getPath('fallback'), 'data/fallback');
+					}
+
+					if (fs.existsSync(config.getPath('served_files'))) {
+						archive.directory(config.getPath('served_files'), 'data/served-files');
+					}
+
+					rows.access_list.forEach((list) => {
+						const file = config.getPath('access') + '/' + list.id;
+						if (redact_keys) {
+							archive.append(REDACTED, {name: 'data/access/' + list.id});
+						} else if (fs.existsSync(file)) {
+							archive.file(file, {name: 'data/access/' + list.id});
+						}
+					});
+
+					archive.finalize();
+				});
+			})
+			.then(() => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'exported',
+					object_type: 'nginx',
+					object_id:   0,
+					meta:        {
+						redact_keys: redact_keys
+					}
+				});
+			});
+	}
+};
+
+module.exports = internalExport;
@@ -0,0 +1,71 @@
+const fs     = require('fs');
+const error  = require('../lib/error');
+const config = require('../lib/config');
+
+const pageDir = config.getPath('fallback');
+
+const internalFallback = {
+
+	/**
+	 * @param   {Number}  host_id
+	 * @returns {String}
+	 */
+	getPageFile: (host_id) => {
+		return pageDir + '/proxy-host-' + host_id + '.html';
+	},
+
+	/**
+	 * nginx doesn't allow backup servers when the server is picked by a hash
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing host
+	 * @returns {Promise}
+	 */
+	validate: (data, row) => {
+		const fallback       = typeof data.fallback !== 'undefined' ? data.fallback : (row ? row.fallback : null);
+		const load_balancing = typeof data.load_balancing !== 'undefined' ? data.load_balancing : (row ? row.load_balancing : null);
+
+		if (fallback && fallback.upstream && load_balancing && load_balancing.session_affinity) {
+			return Promise.reject(new error.ValidationError('A fallback forward host can\'t be used with session affinity, use a fallback page instead'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Writes the fallback page of a host for nginx to serve, or removes it when there isn't one
+	 *
+	 * @param {Object}  host
+	 */
+	writePage: (host) => {
+		const file = internalFallback.getPageFile(host.id);
+
+		if (host.fallback && host.fallback.page) {
+			if (!fs.existsSync(pageDir)) {
+				fs.mkdirSync(pageDir);
+			}
+			fs.writeFileSync(file, host.fallback.page, {encoding: 'utf8'});
+		} else if (fs.existsSync(file)) {
+			fs.unlinkSync(file);
+		}
+	},
+
+	/**
+	 * What to write into the config of a proxy host for the fallback page, or null when there isn't one.
+	 * The fallback forward host is a backup server of the load balancing.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (host) => {
+		if (!host.fallback || !host.fallback.page) {
+			return null;
+		}
+
+		return {
+			page_file: internalFallback.getPageFile(host.id)
+		};
+	}
+};
+
+module.exports = internalFallback;
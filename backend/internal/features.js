@@ -0,0 +1,118 @@
+const _                   = require('lodash');
+const utils               = require('../lib/utils');
+const logger              = require('../logger').global;
+const settingModel        = require('../models/setting');
+const userPermissionModel = require('../models/user_permission');
+
+/**
+ * Experimental features. Each is off unless it's turned on for everyone in the features setting, and
+ * a user can have it turned on or off for them alone in their permissions. Ones this build of nginx
+ * can't do are never on, whatever the setting says.
+ */
+const FLAGS = {
+	'http3': {
+		name:        'HTTP/3',
+		description: 'Serve hosts with certificates over QUIC as well, on the https port',
+		supported:   (build) => build.indexOf('--with-http_v3_module') !== -1
+	},
+	'waf': {
+		name:        'Web Application Firewall',
+		description: 'Check requests to proxy hosts against the OWASP core rules with ModSecurity',
+		supported:   (build) => /modsecurity/i.test(build)
+	},
+	'clustering': {
+		name:        'Clustering',
+		description: 'Share hosts and certificates between several instances',
+		supported:   () => false
+	}
+};
+
+// The output of nginx -V, which doesn't change while the backend runs
+let build = null;
+
+/**
+ * @returns {Promise}  resolves with the configure arguments of nginx, empty when they can't be read
+ */
+const getBuild = () => {
+	if (build === null) {
+		// Not cancelled with the request that asked first, the answer is kept for every other one
+		build = utils.exec('/usr/sbin/nginx -V 2>&1', {signal: null})
+			.catch((err) => {
+				logger.warn('Could not read the nginx build, experimental features it has to support are off: ' + err.message);
+				return '';
+			});
+	}
+	return build;
+};
+
+const internalFeatures = {
+
+	FLAGS: FLAGS,
+
+	/**
+	 * Every feature, with whether it's on for the user
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}  resolves with ie: [{id: 'http3', name, description, supported: true, enabled: true, source: 'user'}]
+	 */
+	getAll: (access) => {
+		return access.can('features:list')
+			.then(() => {
+				return Promise.all([
+					getBuild(),
+					settingModel
+						.query()
+						.where('id', 'features')
+						.first(),
+					userPermissionModel
+						.query()
+						.where('user_id', access.token.getUserId(0))
+						.first()
+				]);
+			})
+			.then(([nginx_build, setting, permissions]) => {
+				const instance = setting && setting.value === 'custom' ? (setting.meta || {}).flags || {} : {};
+				const user     = (permissions && permissions.features) || {};
+
+				return _.map(FLAGS, (flag, id) => {
+					let item = {
+						id:          id,
+						name:        flag.name,
+						description: flag.description,
+						supported:   flag.supported(nginx_build),
+						enabled:     false,
+						source:      'default'
+					};
+
+					if (!item.supported) {
+						item.source = 'unsupported';
+					} else if (typeof user[id] === 'boolean') {
+						item.enabled = user[id];
+						item.source  = 'user';
+					} else if (typeof instance[id] === 'boolean') {
+						item.enabled = instance[id];
+						item.source  = 'instance';
+					}
+
+					return item;
+				});
+			});
+	},
+
+	/**
+	 * For code behind a feature to check it's on for whoever it's running for
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  id      ie: 'http3'
+	 * @returns {Promise}  resolves with a boolean
+	 */
+	isEnabled: (access, id) => {
+		return internalFeatures.getAll(access)
+			.then((items) => {
+				const item = items.find((feature) => feature.id === id);
+				return !!item && item.enabled;
+			});
+	}
+};
+
+module.exports = internalFeatures;
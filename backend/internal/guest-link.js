@@ -0,0 +1,301 @@
+const _                = require('lodash');
+const crypto           = require('crypto');
+const moment           = require('moment');
+const logger           = require('../logger').access;
+const config           = require('../lib/config');
+const error            = require('../lib/error');
+const guestLinkModel   = require('../models/guest_link');
+const internalAuditLog = require('./audit-log');
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+// How long a link works when it's made without hours, and the longest it can
+const DEFAULT_HOURS = 24;
+const MAX_HOURS     = 24 * 30;
+
+// The query parameter a link has its token in, the cookie is named after the host
+const PARAM = 'npm_guest';
+
+// Expired links are kept this long before they're removed, so every replica has written its configs without them
+const REMOVE_AFTER_HOURS = 24;
+
+/**
+ * @param   {Object}  row
+ * @returns {String}  ie: 12.1792300000.5f1c...
+ */
+const sign = (row) => {
+	const payload = row.id + '.' + moment(row.expires_on).unix();
+	const mac     = crypto.createHmac('sha256', config.getPrivateKey()).update('guest-link:' + row.proxy_host_id + ':' + payload).digest('hex');
+	return payload + '.' + mac.substring(0, 32);
+};
+
+/**
+ * @param   {Object}  row
+ * @param   {Object}  [now]  moment
+ * @returns {Boolean}
+ */
+const isExpired = (row, now) => {
+	return !moment(row.expires_on).isAfter(now || moment());
+};
+
+const internalGuestLink = {
+
+	PARAM: PARAM,
+
+	intervalTimeout:    1000 * 60, // 1 minute
+	interval:           null,
+	intervalProcessing: false,
+	lastChecked:        null,
+
+	initTimer: () => {
+		logger.info('Guest Link Timer initialized');
+		internalGuestLink.interval = setInterval(internalGuestLink.processExpired, internalGuestLink.intervalTimeout);
+	},
+
+	/**
+	 * The link to give a guest, to the first domain name of the host
+	 *
+	 * @param   {Object}  host
+	 * @param   {Object}  row
+	 * @returns {String}
+	 */
+	getUrl: (host, row) => {
+		return (host.certificate_id ? 'https' : 'http') + '://' + host.domain_names[0] + '/?' + PARAM + '=' + row.token;
+	},
+
+	/**
+	 * What to write into the config of a proxy host, the links that still work
+	 *
+	 * @param   {Object}  host
+	 * @returns {Promise}  resolves with {cookie, links: [{token, set_cookie}]}, or null without any
+	 */
+	getOptions: (host) => {
+		if (!host.access_list_id || !host.id) {
+			return Promise.resolve(null);
+		}
+
+		return guestLinkModel
+			.query()
+			.where('proxy_host_id', host.id)
+			.orderBy('id')
+			.then((rows) => {
+				const links = rows.filter((row) => row.token && !isExpired(row));
+				if (!links.length) {
+					return null;
+				}
+
+				const cookie = PARAM + '_' + host.id;
+				const secure = host.certificate_id && host.ssl_forced ? '; Secure' : '';
+
+				return {
+					param:  PARAM,
+					cookie: cookie,
+					links:  links.map((row) => {
+						return {
+							token:      row.token,
+							set_cookie: cookie + '=' + row.token + '; Path=/; Expires=' + moment(row.expires_on).utc().format('ddd, DD MMM YYYY HH:mm:ss') + ' GMT; HttpOnly; SameSite=Lax' + secure
+						};
+					})
+				};
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Number}  host_id
+	 * @param   {String}  permission
+	 * @returns {Promise}  resolves with the host
+	 */
+	getHost: (access, host_id, permission) => {
+		// Required here, as proxy hosts are written to nginx, which requires this module
+		const internalProxyHost = require('./proxy-host');
+
+		return access.can(permission, host_id)
+			.then(() => {
+				return internalProxyHost.get(access, {id: host_id});
+			});
+	},
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Promise}
+	 */
+	configure: (host) => {
+		return require('./host').regenerateConfigs((row, host_type) => host_type === 'proxy_host' && row.id === host.id);
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @returns {Promise}
+	 */
+	getAll: (access, data) => {
+		return internalGuestLink.getHost(access, data.host_id, 'proxy_hosts:get')
+			.then((host) => {
+				return guestLinkModel
+					.query()
+					.where('proxy_host_id', host.id)
+					.orderBy('expires_on', 'DESC')
+					.then((rows) => {
+						return rows.map((row) => {
+							return _.assign(row, {url: internalGuestLink.getUrl(host, row), expired: isExpired(row)});
+						});
+					});
+			});
+	},
+
+	/**
+	 * Makes a link that lets whoever has it past the access list of the host until it expires
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @param   {String}  [data.name]   who it's for
+	 * @param   {Number}  [data.hours]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		let host = null;
+
+		return internalGuestLink.getHost(access, data.host_id, 'proxy_hosts:update')
+			.then((row) => {
+				host = row;
+
+				if (!host.access_list_id) {
+					throw new error.ValidationError('Proxy Host #' + host.id + ' has no access list for a guest link to get past');
+				}
+
+				const hours = typeof data.hours === 'number' ? data.hours : DEFAULT_HOURS;
+				if (hours < 1 || hours > MAX_HOURS) {
+					throw new error.ValidationError('A guest link can work for 1 to ' + MAX_HOURS + ' hours');
+				}
+
+				return guestLinkModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						proxy_host_id: host.id,
+						name:          data.name || '',
+						expires_on:    moment().add(hours, 'hours').format(DATE_FORMAT)
+					});
+			})
+			.then((row) => {
+				// The id is part of what's signed, so it's only known once the row is there
+				return guestLinkModel
+					.query()
+					.patchAndFetchById(row.id, {token: sign(row)});
+			})
+			.then((row) => {
+				return internalGuestLink.configure(host)
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'created',
+							object_type: 'guest-link',
+							object_id:   row.id,
+							meta:        _.omit(row, ['token'])
+						});
+					})
+					.then(() => {
+						return _.assign(row, {url: internalGuestLink.getUrl(host, row), expired: false});
+					});
+			});
+	},
+
+	/**
+	 * Stops a link from working before it expires
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.host_id
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		let host = null;
+		let row  = null;
+
+		return internalGuestLink.getHost(access, data.host_id, 'proxy_hosts:update')
+			.then((found) => {
+				host = found;
+
+				return guestLinkModel
+					.query()
+					.where('id', data.id)
+					.andWhere('proxy_host_id', host.id)
+					.first();
+			})
+			.then((found) => {
+				if (!found) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				row = found;
+
+				return guestLinkModel
+					.query()
+					.deleteById(row.id);
+			})
+			.then(() => {
+				return internalGuestLink.configure(host);
+			})
+			.then(() => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'deleted',
+					object_type: 'guest-link',
+					object_id:   row.id,
+					meta:        _.omit(row, ['token'])
+				});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * Triggered by a timer, this writes the hosts with links that expired since the last run
+	 * without them, and removes the links that expired a while ago
+	 *
+	 * @returns {Promise}
+	 */
+	processExpired: () => {
+		if (internalGuestLink.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalGuestLink.intervalProcessing = true;
+
+		const now   = moment();
+		const since = internalGuestLink.lastChecked;
+
+		return guestLinkModel
+			.query()
+			.where('expires_on', '<=', now.format(DATE_FORMAT))
+			.then((rows) => {
+				const ids = _.uniq(_.map(rows.filter((row) => !since || moment(row.expires_on).isAfter(since)), 'proxy_host_id'));
+				const old = _.map(rows.filter((row) => moment(row.expires_on).isBefore(now.clone().subtract(REMOVE_AFTER_HOURS, 'hours'))), 'id');
+
+				return (ids.length ? require('./host').regenerateConfigs((host, host_type) => host_type === 'proxy_host' && ids.indexOf(host.id) !== -1) : Promise.resolve())
+					.then(() => {
+						if (old.length) {
+							return guestLinkModel
+								.query()
+								.delete()
+								.whereIn('id', old);
+						}
+					});
+			})
+			.then(() => {
+				internalGuestLink.lastChecked        = now;
+				internalGuestLink.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalGuestLink.intervalProcessing = false;
+			});
+	}
+};
+
+module.exports = internalGuestLink;
@@ -0,0 +1,136 @@
+const _                = require('lodash');
+const error            = require('../lib/error');
+const settingModel     = require('../models/setting');
+const certificateModel = require('../models/certificate');
+const accessListModel  = require('../models/access_list');
+
+/**
+ * The fields each type of host takes a default for
+ */
+const FIELDS = {
+	'proxy-host':       ['certificate_id', 'ssl_forced', 'http2_support', 'hsts_enabled', 'hsts_subdomains', 'block_exploits', 'access_list_id'],
+	'redirection-host': ['certificate_id', 'ssl_forced', 'http2_support', 'hsts_enabled', 'hsts_subdomains', 'block_exploits'],
+	'dead-host':        ['certificate_id', 'ssl_forced', 'http2_support', 'hsts_enabled', 'hsts_subdomains']
+};
+
+// Go into the limits of proxy hosts
+const TIMEOUTS = ['proxy_connect_timeout', 'proxy_read_timeout', 'proxy_send_timeout'];
+
+const internalHostDefaults = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'host-defaults')
+			.first();
+	},
+
+	/**
+	 * The certificate and access list have to exist, and a new certificate needs an email for Let's Encrypt
+	 *
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (meta.certificate === 'existing' && !meta.certificate_id) {
+			return Promise.reject(new error.ValidationError('Choose the certificate new hosts use'));
+		}
+
+		if ((meta.certificate === 'new' || meta.certificate === 'auto') && !meta.letsencrypt_email) {
+			return Promise.reject(new error.ValidationError('An email address is needed to request certificates for new hosts'));
+		}
+
+		return Promise.all([
+			meta.certificate === 'existing' ? certificateModel.query().where('id', meta.certificate_id).andWhere('is_deleted', 0).first() : true,
+			meta.access_list_id ? accessListModel.query().where('id', meta.access_list_id).andWhere('is_deleted', 0).first() : true
+		])
+			.then(([certificate, access_list]) => {
+				if (!certificate) {
+					throw new error.ValidationError('There\'s no certificate #' + meta.certificate_id);
+				}
+				if (!access_list) {
+					throw new error.ValidationError('There\'s no access list #' + meta.access_list_id);
+				}
+			});
+	},
+
+	/**
+	 * @param   {Object}  setting
+	 * @param   {String}  object_type  proxy-host, redirection-host or dead-host
+	 * @returns {Object}  the fields new hosts of the type get, ie: {certificate_id: 'new', ssl_forced: true}
+	 */
+	getDefaults: (setting, object_type) => {
+		if (!setting || setting.value !== 'on') {
+			return {};
+		}
+
+		const meta   = setting.meta || {};
+		let defaults = _.pick(meta, FIELDS[object_type]);
+
+		if (meta.certificate === 'new' || meta.certificate === 'auto') {
+			defaults.certificate_id = meta.certificate;
+		} else if (meta.certificate === 'existing' && meta.certificate_id) {
+			defaults.certificate_id = meta.certificate_id;
+		}
+
+		if (object_type === 'proxy-host' && _.some(TIMEOUTS, (name) => meta[name])) {
+			defaults.limits = _.pick(meta, TIMEOUTS);
+		}
+
+		return _.pick(defaults, FIELDS[object_type].concat(['limits']));
+	},
+
+	/**
+	 * Fills in what's missing from the payload of a new host. What the payload has wins.
+	 *
+	 * @param   {String}  object_type
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	apply: (object_type, data) => {
+		return internalHostDefaults.getSetting()
+			.then((setting) => {
+				const defaults = internalHostDefaults.getDefaults(setting, object_type);
+
+				_.forEach(defaults, (value, field) => {
+					if (field === 'limits') {
+						if (typeof data.limits === 'undefined' || (data.limits && typeof data.limits === 'object')) {
+							data.limits = _.assign({}, value, data.limits);
+						}
+					} else if (typeof data[field] === 'undefined') {
+						data[field] = value;
+					}
+				});
+
+				if (['new', 'auto'].indexOf(data.certificate_id) !== -1 && data.certificate_id === defaults.certificate_id) {
+					data.meta = _.assign({
+						letsencrypt_email: setting.meta.letsencrypt_email,
+						letsencrypt_agree: true
+					}, data.meta);
+				}
+			});
+	},
+
+	/**
+	 * The defaults of each type of host, for forms to start with
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('host_defaults:get')
+			.then(() => {
+				return internalHostDefaults.getSetting();
+			})
+			.then((setting) => {
+				return _.mapValues(FIELDS, (fields, object_type) => {
+					return internalHostDefaults.getDefaults(setting, object_type);
+				});
+			});
+	}
+};
+
+module.exports = internalHostDefaults;
@@ -0,0 +1,190 @@
+const _                       = require('lodash');
+const error                   = require('../lib/error');
+const logger                  = require('../logger').global;
+const apiValidator            = require('../lib/validator/api');
+const schema                  = require('../schema');
+const internalProxyHost       = require('./proxy-host');
+const internalRedirectionHost = require('./redirection-host');
+const internalDeadHost        = require('./dead-host');
+const internalCertificate     = require('./certificate');
+const internalAccessList      = require('./access-list');
+const internalChangeRequest   = require('./change-request');
+const internalDryRun          = require('./dry-run');
+
+const HOST_MODULES = {
+	'proxy-host':       internalProxyHost,
+	'redirection-host': internalRedirectionHost,
+	'dead-host':        internalDeadHost
+};
+
+// Certificates that can be made without uploading files afterwards
+const PROVIDERS = ['letsencrypt', 'internal'];
+
+const internalHostFull = {
+
+	/**
+	 * Validates each part against the endpoint that would create it on its own, and tries the host out
+	 * as it would be without the certificate and access list, so nothing is made when any part is wrong
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @returns {Promise}  resolves with the validated {host, certificate, access_list}
+	 */
+	check: (access, data) => {
+		const object_type = data.object_type;
+		let parts         = {host: null, certificate: null, access_list: null};
+
+		if (data.access_list && object_type !== 'proxy-host') {
+			return Promise.reject(new error.ValidationError('Only proxy hosts have an access list'));
+		}
+		if (data.certificate && typeof data.host.certificate_id !== 'undefined') {
+			return Promise.reject(new error.ValidationError('Give either a certificate to create or the certificate_id of the host, not both'));
+		}
+		if (data.access_list && typeof data.host.access_list_id !== 'undefined') {
+			return Promise.reject(new error.ValidationError('Give either an access list to create or the access_list_id of the host, not both'));
+		}
+
+		return internalChangeRequest.isRequired(access)
+			.then((required) => {
+				if (required) {
+					throw new error.PermissionError('Your changes need to be approved, create the host, certificate and access list one at a time instead');
+				}
+
+				if (!data.access_list) {
+					return;
+				}
+
+				return apiValidator(schema.getValidationSchema('/nginx/access-lists', 'post'), _.assign({items: [], clients: []}, data.access_list))
+					.then((access_list) => {
+						parts.access_list = access_list;
+						return access.can('access_lists:create', access_list);
+					});
+			})
+			.then(() => {
+				if (!data.certificate) {
+					return;
+				}
+
+				return apiValidator(schema.getValidationSchema('/nginx/certificates', 'post'), _.assign({
+					provider:     'letsencrypt',
+					domain_names: data.host.domain_names
+				}, data.certificate))
+					.then((certificate) => {
+						if (PROVIDERS.indexOf(certificate.provider) === -1) {
+							throw new error.ValidationError('Only ' + PROVIDERS.join(' and ') + ' certificates can be created along with a host, others need their files uploaded');
+						}
+
+						parts.certificate = certificate;
+						return access.can('certificates:create', certificate);
+					});
+			})
+			.then(() => {
+				// Validated, checked for conflicts and tested with nginx as it would be before the others exist
+				const host = _.assign({}, data.host, parts.certificate ? {certificate_id: 0} : {}, parts.access_list ? {access_list_id: 0} : {});
+
+				return internalDryRun.run(access, object_type, 'create', {}, _.cloneDeep(host))
+					.then((result) => {
+						if (!result.nginx.online) {
+							throw new error.ValidationError('nginx doesn\'t accept the config of the host: ' + result.nginx.error);
+						}
+
+						parts.host = data.host;
+						return parts;
+					});
+			});
+	},
+
+	/**
+	 * Creates a host along with a new certificate and access list for it. When any of them fails,
+	 * what was made before it is deleted again. The config of the host is written once, when the
+	 * others are there.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.object_type    proxy-host, redirection-host or dead-host
+	 * @param   {Object}  data.host           as for creating the host on its own
+	 * @param   {Object}  [data.certificate]  as for creating a certificate, for the domain names of the host by default
+	 * @param   {Object}  [data.access_list]  as for creating an access list, for proxy hosts
+	 * @returns {Promise}  resolves with {host, certificate, access_list}
+	 */
+	create: (access, data) => {
+		data = _.assign({object_type: 'proxy-host'}, data);
+
+		const host_module = HOST_MODULES[data.object_type];
+		let created       = {host: null, certificate: null, access_list: null};
+
+		return internalHostFull.check(access, data)
+			.then((parts) => {
+				return Promise.resolve()
+					.then(() => {
+						if (parts.access_list) {
+							return internalAccessList.create(access, parts.access_list)
+								.then((access_list) => {
+									created.access_list = access_list;
+								});
+						}
+					})
+					.then(() => {
+						if (parts.certificate) {
+							return internalCertificate.create(access, parts.certificate)
+								.then((certificate) => {
+									created.certificate = certificate;
+								});
+						}
+					})
+					.then(() => {
+						let host = _.cloneDeep(parts.host);
+						if (created.certificate) {
+							host.certificate_id = created.certificate.id;
+						}
+						if (created.access_list) {
+							host.access_list_id = created.access_list.id;
+						}
+
+						return host_module.create(access, host);
+					})
+					.then((host) => {
+						created.host = host;
+
+						if (host.meta && host.meta.nginx_online === false) {
+							throw new error.ConfigurationError(host.meta.nginx_err || 'nginx doesn\'t accept the config of the host');
+						}
+
+						return created;
+					})
+					.catch((err) => {
+						return internalHostFull.rollback(access, data.object_type, created)
+							.then(() => {
+								throw err;
+							});
+					});
+			});
+	},
+
+	/**
+	 * Deletes what was created, the host first as it uses the others
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  created  {host, certificate, access_list}
+	 * @returns {Promise}
+	 */
+	rollback: (access, object_type, created) => {
+		const steps = [
+			created.host ? () => HOST_MODULES[object_type].delete(access, {id: created.host.id}) : null,
+			created.certificate ? () => internalCertificate.delete(access, {id: created.certificate.id}) : null,
+			created.access_list ? () => internalAccessList.delete(access, {id: created.access_list.id}) : null
+		];
+
+		return _.compact(steps).reduce((promise, step) => {
+			return promise
+				.then(step)
+				.catch((err) => {
+					// Carry on with the rest, what's left is in the log
+					logger.error('Could not undo part of creating a host: ' + err.message);
+				});
+		}, Promise.resolve());
+	}
+};
+
+module.exports = internalHostFull;
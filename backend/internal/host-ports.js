@@ -0,0 +1,149 @@
+const _              = require('lodash');
+const error          = require('../lib/error');
+const config         = require('../lib/config');
+const proxyHostModel = require('../models/proxy_host');
+const streamModel    = require('../models/stream');
+
+/**
+ * Used when a host doesn't have its own ports, and by every redirection and 404 host
+ */
+const DEFAULTS = {
+	http:  [80],
+	https: [443]
+};
+
+const internalHostPorts = {
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Object}  ie: {http: [80, 8080], https: [8443]}
+	 */
+	getPorts: (host) => {
+		if (!host.ports || _.isEmpty(host.ports)) {
+			return DEFAULTS;
+		}
+
+		return {
+			http:  _.uniq(host.ports.http || []),
+			https: _.uniq(host.ports.https || [])
+		};
+	},
+
+	/**
+	 * Ports nginx can't listen on for hosts, as something else already is
+	 *
+	 * @returns {Object}  port => what's using it
+	 */
+	getReserved: () => {
+		let reserved = {81: 'the admin interface'};
+
+		reserved[config.getSetting('port')] = 'the backend';
+		return reserved;
+	},
+
+	/**
+	 * Checks the ports of a proxy host are free to use. A port can't be http for one host
+	 * and https for another, as nginx turns on ssl for every server on a port when any of them asks.
+	 *
+	 * @param   {Object}   data                  payload
+	 * @param   {Object}   [row]                 existing host
+	 * @param   {Boolean}  [create_certificate]  whether a certificate is about to be requested for it
+	 * @returns {Promise}
+	 */
+	validate: (data, row, create_certificate) => {
+		if (typeof data.ports === 'undefined' || !data.ports) {
+			return Promise.resolve();
+		}
+
+		const host  = _.assign({}, row || {}, data);
+		const ports = internalHostPorts.getPorts(host);
+
+		if (!ports.http.length && !ports.https.length) {
+			return Promise.reject(new error.ValidationError('At least one port to listen on is required'));
+		}
+
+		if (!ports.http.length && !host.certificate_id && !create_certificate) {
+			return Promise.reject(new error.ValidationError('A host without http ports needs a certificate to listen on its https ports'));
+		}
+
+		const both = _.intersection(ports.http, ports.https);
+		if (both.length) {
+			return Promise.reject(new error.ValidationError('Port ' + both[0] + ' can\'t be both http and https'));
+		}
+
+		const reserved = internalHostPorts.getReserved();
+		const taken    = _.find(ports.http.concat(ports.https), (port) => typeof reserved[port] !== 'undefined');
+		if (taken) {
+			return Promise.reject(new error.ValidationError('Port ' + taken + ' is used by ' + reserved[taken]));
+		}
+
+		return Promise.all([
+			proxyHostModel
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('id', '!=', row ? row.id : 0)
+				.whereNotNull('ports'),
+			streamModel
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('tcp_forwarding', 1)
+				.whereIn('incoming_port', ports.http.concat(ports.https))
+		])
+			.then(([hosts, streams]) => {
+				if (streams.length) {
+					throw new error.ValidationError('Port ' + streams[0].incoming_port + ' is used by stream #' + streams[0].id);
+				}
+
+				let http  = DEFAULTS.http.slice();
+				let https = DEFAULTS.https.slice();
+
+				hosts.forEach((other) => {
+					const other_ports = internalHostPorts.getPorts(other);
+					http              = http.concat(other_ports.http);
+					https             = https.concat(other_ports.https);
+				});
+
+				const http_clash = _.intersection(ports.https, http);
+				if (http_clash.length) {
+					throw new error.ValidationError('Port ' + http_clash[0] + ' is already used for http');
+				}
+
+				const https_clash = _.intersection(ports.http, https);
+				if (https_clash.length) {
+					throw new error.ValidationError('Port ' + https_clash[0] + ' is already used for https');
+				}
+			});
+	},
+
+	/**
+	 * Checks a stream isn't given a port that a proxy host listens on
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing stream
+	 * @returns {Promise}
+	 */
+	validateStream: (data, row) => {
+		const stream = _.assign({}, row || {}, data);
+
+		if (!stream.tcp_forwarding) {
+			return Promise.resolve();
+		}
+
+		return proxyHostModel
+			.query()
+			.where('is_deleted', 0)
+			.whereNotNull('ports')
+			.then((hosts) => {
+				const host = _.find(hosts, (item) => {
+					const ports = internalHostPorts.getPorts(item);
+					return ports.http.concat(ports.https).indexOf(stream.incoming_port) !== -1;
+				});
+
+				if (host) {
+					throw new error.ValidationError('Port ' + stream.incoming_port + ' is used by proxy host #' + host.id);
+				}
+			});
+	}
+};
+
+module.exports = internalHostPorts;
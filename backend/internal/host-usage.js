@@ -0,0 +1,306 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const moment               = require('moment');
+const config               = require('../lib/config');
+const logger               = require('../logger').global;
+const usageModel           = require('../models/host_usage');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const tokenModel           = require('../models/token');
+const internalAnalytics    = require('./analytics');
+const internalAuditLog     = require('./audit-log');
+const internalActivity     = require('./activity');
+
+const logDir      = config.getPath('logs');
+const stateDir    = config.getPath('analytics');
+const offsetsFile = stateDir + '/usage-offsets.json';
+
+// ie: proxy-host-1_access.log
+const ACCESS_LOG = /^(proxy-host|redirection-host|dead-host)-(\d+)_access\.log$/;
+
+const HOST_MODELS = {
+	'proxy-host':       proxyHostModel,
+	'redirection-host': redirectionHostModel,
+	'dead-host':        deadHostModel
+};
+
+/**
+ * Counts what each host served a month from its access log, for billing whoever it's hosted for.
+ * Unlike analytics this is always on, as it's only a couple of numbers per host.
+ */
+const internalHostUsage = {
+
+	intervalTimeout:    1000 * 60 * 5, // 5 minutes
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Host Usage Timer initialized');
+		internalHostUsage.interval = setInterval(internalHostUsage.processLogs, internalHostUsage.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Object}  file name => {inode, offset}, kept apart from the analytics ones so either can be off
+	 */
+	getOffsets: () => {
+		try {
+			return JSON.parse(fs.readFileSync(offsetsFile, {encoding: 'utf8'}));
+		} catch (err) {
+			return {};
+		}
+	},
+
+	/**
+	 * @param {Object}  offsets
+	 */
+	saveOffsets: (offsets) => {
+		if (!fs.existsSync(stateDir)) {
+			fs.mkdirSync(stateDir);
+		}
+		fs.writeFileSync(offsetsFile, JSON.stringify(offsets), {encoding: 'utf8'});
+	},
+
+	/**
+	 * Triggered by a timer, this adds what's been written to the access logs since the last time
+	 * to the month it was served in, then checks the hosts that have limits
+	 *
+	 * @returns {Promise}
+	 */
+	processLogs: () => {
+		if (internalHostUsage.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalHostUsage.intervalProcessing = true;
+
+		return Promise.resolve()
+			.then(() => {
+				const offsets = internalHostUsage.getOffsets();
+				let totals    = {};
+
+				if (fs.existsSync(logDir)) {
+					fs.readdirSync(logDir).forEach((name) => {
+						const file = name.match(ACCESS_LOG);
+						if (!file) {
+							return;
+						}
+
+						internalAnalytics.readNewLines(name, offsets).forEach((line) => {
+							const item = internalAnalytics.parseLine(line);
+							if (!item) {
+								return;
+							}
+
+							const key = [file[1], file[2], item.day.substring(0, 7)].join('|');
+							if (typeof totals[key] === 'undefined') {
+								totals[key] = {requests: 0, bytes: 0};
+							}
+							totals[key].requests += 1;
+							totals[key].bytes += item.bytes;
+						});
+					});
+				}
+
+				return internalHostUsage.save(totals)
+					.then(() => {
+						internalHostUsage.saveOffsets(offsets);
+						return internalHostUsage.checkLimits();
+					});
+			})
+			.then(() => {
+				internalHostUsage.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error('Host usage processing failed: ' + err.message);
+				internalHostUsage.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * Adds the new counts to the stored ones
+	 *
+	 * @param   {Object}  totals  'object_type|object_id|month' => {requests, bytes}
+	 * @returns {Promise}
+	 */
+	save: (totals) => {
+		let sequence = Promise.resolve();
+
+		_.forEach(totals, (total, key) => {
+			const [object_type, object_id, month] = key.split('|');
+			const where = {
+				object_type: object_type,
+				object_id:   parseInt(object_id, 10),
+				month:       month
+			};
+
+			sequence = sequence
+				.then(() => {
+					return usageModel
+						.query()
+						.where(where)
+						.first();
+				})
+				.then((row) => {
+					if (row) {
+						return usageModel
+							.query()
+							.patchAndFetchById(row.id, {
+								requests: row.requests + total.requests,
+								bytes:    parseInt(row.bytes, 10) + total.bytes
+							});
+					}
+
+					return usageModel
+						.query()
+						.insert(_.assign({}, where, total));
+				});
+		});
+
+		return sequence;
+	},
+
+	/**
+	 * @param   {Object}  usage   {requests, bytes}
+	 * @param   {Object}  limits  the usage_limits of the host
+	 * @returns {Array}   the limits that have been reached, ie: ['bytes']
+	 */
+	getExceeded: (usage, limits) => {
+		return ['requests', 'bytes'].filter((name) => {
+			return limits && limits[name] && parseInt(usage[name], 10) >= limits[name];
+		});
+	},
+
+	/**
+	 * Raises an alert for each limit a host reached this month, once a month
+	 *
+	 * @returns {Promise}
+	 */
+	checkLimits: () => {
+		const month = moment.utc().format('YYYY-MM');
+
+		return usageModel
+			.query()
+			.where('month', month)
+			.then((rows) => {
+				let sequence = Promise.resolve();
+
+				rows.forEach((row) => {
+					sequence = sequence
+						.then(() => {
+							return HOST_MODELS[row.object_type]
+								.query()
+								.where('id', row.object_id)
+								.andWhere('is_deleted', 0)
+								.first();
+						})
+						.then((host) => {
+							if (!host || !host.usage_limits) {
+								return;
+							}
+
+							const alerted  = row.alerted || [];
+							const exceeded = _.difference(internalHostUsage.getExceeded(row, host.usage_limits), alerted);
+							if (!exceeded.length) {
+								return;
+							}
+
+							return internalHostUsage.alert(row, host, exceeded)
+								.then(() => {
+									return usageModel
+										.query()
+										.patchAndFetchById(row.id, {alerted: alerted.concat(exceeded)});
+								});
+						});
+				});
+
+				return sequence;
+			});
+	},
+
+	/**
+	 * @param   {Object}  row       host_usage
+	 * @param   {Object}  host
+	 * @param   {Array}   exceeded  ie: ['requests']
+	 * @returns {Promise}
+	 */
+	alert: (row, host, exceeded) => {
+		const message = exceeded.map((name) => {
+			return name + ' ' + row[name] + ' of ' + host.usage_limits[name];
+		}).join(', ');
+
+		logger.warn('The ' + row.object_type + ' #' + host.id + ' ' + host.domain_names.join(', ') + ' is over its usage limits for ' + row.month + ': ' + message);
+
+		return internalActivity.record(row.object_type, host.id, 'usage', 'over-limit', false, message)
+			.then(() => {
+				return internalAuditLog.add({token: new tokenModel()}, {
+					action:      'alerted',
+					object_type: row.object_type,
+					object_id:   host.id,
+					meta:        {
+						domain_names: host.domain_names,
+						month:        row.month,
+						requests:     row.requests,
+						bytes:        parseInt(row.bytes, 10),
+						usage_limits: host.usage_limits,
+						exceeded:     exceeded
+					}
+				});
+			});
+	},
+
+	/**
+	 * What a host served each month, the current one first
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  ie: 'proxy-host'
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.months]
+	 * @returns {Promise}
+	 */
+	getUsage: (access, object_type, data) => {
+		const modules = {
+			'proxy-host':       './proxy-host',
+			'redirection-host': './redirection-host',
+			'dead-host':        './dead-host'
+		};
+
+		const months = data.months || 12;
+		const list   = _.range(months).map((index) => moment.utc().subtract(index, 'months').format('YYYY-MM'));
+
+		// Whoever can see the host can see its usage
+		return require(modules[object_type]).get(access, {id: data.id})
+			.then((host) => {
+				return usageModel
+					.query()
+					.where('object_type', object_type)
+					.andWhere('object_id', data.id)
+					.whereIn('month', list)
+					.then((rows) => {
+						const history = list.map((month) => {
+							const row = _.find(rows, {month: month});
+							return {
+								month:    month,
+								requests: row ? row.requests : 0,
+								bytes:    row ? parseInt(row.bytes, 10) : 0
+							};
+						});
+
+						return {
+							object_type:  object_type,
+							object_id:    data.id,
+							month:        list[0],
+							requests:     history[0].requests,
+							bytes:        history[0].bytes,
+							usage_limits: host.usage_limits || null,
+							exceeded:     internalHostUsage.getExceeded(history[0], host.usage_limits),
+							months:       history
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalHostUsage;
@@ -1,11 +1,42 @@
 const _                    = require('lodash');
+const net                  = require('net');
+const moment               = require('moment');
+const error                = require('../lib/error');
 const proxyHostModel       = require('../models/proxy_host');
 const redirectionHostModel = require('../models/redirection_host');
 const deadHostModel        = require('../models/dead_host');
-const {castJsonIfNeed}     = require('../lib/helpers');
+const statusPageModel      = require('../models/status_page');
+const helpers              = require('../lib/helpers');
+const logger               = require('../logger').nginx;
 
 const internalHost = {
 
+	/**
+	 * Kept on a host or stream while it's disabled, so it's clear later why it was and by whom
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  [reason]
+	 * @returns {Object}
+	 */
+	getDisabledFields: (access, reason) => {
+		return {
+			disabled_reason:     String(reason || '').trim().substring(0, 255),
+			disabled_by_user_id: access.token.getUserId(0),
+			disabled_on:         moment().format('YYYY-MM-DD HH:mm:ss')
+		};
+	},
+
+	/**
+	 * @returns {Object}  the fields of getDisabledFields cleared, for when it's enabled again
+	 */
+	getEnabledFields: () => {
+		return {
+			disabled_reason:     '',
+			disabled_by_user_id: 0,
+			disabled_on:         null
+		};
+	},
+
 	/**
 	 * Makes sure that the ssl_* and hsts_* fields play nicely together.
 	 * ie: if there is no cert, then force_ssl is off.
@@ -66,6 +97,67 @@ const internalHost = {
 		return row;
 	},
 
+	/**
+	 * Writes the config for every enabled host again, after a setting they all use has changed.
+	 * Hosts that failed nginx's test are left as they are, and one that can't be written doesn't stop the others.
+	 *
+	 * @param   {Function}  [filter]  only the hosts it returns true for, given the host and its type
+	 * @returns {Promise}  resolves with {written, unchanged, failed: [{host_type, id, error}]}
+	 */
+	regenerateConfigs: function (filter) {
+		const internalNginx = require('./nginx');
+
+		const types = {
+			proxy_host:       [proxyHostModel, '[certificate,access_list.[clients,items]]'],
+			redirection_host: [redirectionHostModel, '[certificate]'],
+			dead_host:        [deadHostModel, '[certificate]']
+		};
+
+		let items    = [];
+		let sequence = Promise.resolve();
+
+		_.forEach(types, ([model, expand], host_type) => {
+			sequence = sequence
+				.then(() => {
+					return model
+						.query()
+						.where('is_deleted', 0)
+						.andWhere('enabled', 1)
+						.withGraphFetched(expand);
+				})
+				.then((hosts) => {
+					hosts.forEach((host) => {
+						if (!(host.meta && host.meta.nginx_online === false) && (!filter || filter(host, host_type))) {
+							items.push({host_type: host_type, host: host});
+						}
+					});
+				});
+		});
+
+		let results = [];
+
+		return sequence
+			.then(() => {
+				return internalNginx.generateConfigs(items);
+			})
+			.then((generated) => {
+				results = generated;
+
+				const failed = results.filter((result) => result.error);
+				logger.info('Regenerated ' + results.length + ' host configs, ' + results.filter((result) => result.written).length + ' changed' + (failed.length ? ', ' + failed.length + ' failed' : ''));
+
+				// Only the hosts that use the setting have a different config
+				return internalNginx.reloadIfChanged();
+			})
+			.then(() => {
+				return {
+					written:   results.filter((result) => result.written).length,
+					unchanged: results.filter((result) => !result.written && !result.error).length,
+					failed:    results.filter((result) => result.error)
+				};
+			});
+	},
+
 	/**
 	 * This returns all the host types with any domain listed in the provided domain_names array.
 	 * This is used by the certificates to temporarily disable any host that is using the domain
@@ -121,83 +213,112 @@ const internalHost = {
 	 * Internal use only, checks to see if the domain is already taken by any other record
 	 *
 	 * @param   {String}   hostname
-	 * @param   {String}   [ignore_type]   'proxy', 'redirection', 'dead'
+	 * @param   {String}   [ignore_type]   'proxy', 'redirection', 'dead', 'status'
 	 * @param   {Integer}  [ignore_id]     Must be supplied if type was also supplied
 	 * @returns {Promise}
 	 */
 	isHostnameTaken: function (hostname, ignore_type, ignore_id) {
-		const promises = [
-			proxyHostModel
-				.query()
-				.where('is_deleted', 0)
-				.andWhere(castJsonIfNeed('domain_names'), 'like', '%' + hostname + '%'),
-			redirectionHostModel
-				.query()
-				.where('is_deleted', 0)
-				.andWhere(castJsonIfNeed('domain_names'), 'like', '%' + hostname + '%'),
-			deadHostModel
-				.query()
-				.where('is_deleted', 0)
-				.andWhere(castJsonIfNeed('domain_names'), 'like', '%' + hostname + '%')
+		const types = [
+			{type: 'proxy', object_type: 'proxy-host', model: proxyHostModel},
+			{type: 'redirection', object_type: 'redirection-host', model: redirectionHostModel},
+			{type: 'dead', object_type: 'dead-host', model: deadHostModel},
+			{type: 'status', object_type: 'status-page', model: statusPageModel}
 		];
 
-		return Promise.all(promises)
+		return Promise.all(types.map((item) => {
+			return item.model
+				.query()
+				.where('is_deleted', 0)
+				.andWhere(helpers.castJsonIfNeed('domain_names'), 'like', '%' + hostname + '%');
+		}))
 			.then((promises_results) => {
-				let is_taken = false;
-
-				if (promises_results[0]) {
-					// Proxy Hosts
-					if (internalHost._checkHostnameRecordsTaken(hostname, promises_results[0], ignore_type === 'proxy' && ignore_id ? ignore_id : 0)) {
-						is_taken = true;
-					}
-				}
-
-				if (promises_results[1]) {
-					// Redirection Hosts
-					if (internalHost._checkHostnameRecordsTaken(hostname, promises_results[1], ignore_type === 'redirection' && ignore_id ? ignore_id : 0)) {
-						is_taken = true;
-					}
-				}
-
-				if (promises_results[2]) {
-					// Dead Hosts
-					if (internalHost._checkHostnameRecordsTaken(hostname, promises_results[2], ignore_type === 'dead' && ignore_id ? ignore_id : 0)) {
-						is_taken = true;
+				let conflict = null;
+
+				promises_results.forEach((rows, index) => {
+					const item = types[index];
+					const row  = internalHost._getHostnameRecordTaken(hostname, rows, ignore_type === item.type && ignore_id ? ignore_id : 0);
+
+					if (row && !conflict) {
+						conflict = {
+							domain_name: hostname,
+							object_type: item.object_type,
+							object_id:   row.id
+						};
 					}
-				}
+				});
 
 				return {
 					hostname: hostname,
-					is_taken: is_taken
+					is_taken: conflict !== null,
+					conflict: conflict
 				};
 			});
 	},
 
+	/**
+	 * Domain names are stored in their unicode form and written to nginx in their punycode form,
+	 * so both of them have to be valid. Either can be given.
+	 *
+	 * @param   {Array}  domain_names
+	 * @returns {Array}  in the unicode form, ie: ['例子.中国']
+	 */
+	normaliseDomainNames: function (domain_names) {
+		return domain_names.map((domain_name) => {
+			const ascii  = helpers.toAsciiDomain(domain_name.trim());
+			const labels = ascii.split('.');
+			const valid  = ascii && ascii.length <= 253 && labels.every((label, index) => {
+				// nginx allows a wildcard as the first or the last label
+				return (label === '*' && (index === 0 || index === labels.length - 1)) || /^(?!-)[a-z0-9_-]{1,63}$/.test(label) && !/-$/.test(label);
+			});
+
+			if (!valid && !net.isIP(ascii)) {
+				throw new error.ValidationError(domain_name + ' isn\'t a valid domain name');
+			}
+
+			return helpers.toUnicodeDomain(ascii);
+		});
+	},
+
+	/**
+	 * Checks the domain names of a host aren't given twice and aren't used by any other host
+	 *
+	 * @param   {Array}    domain_names
+	 * @param   {String}   [ignore_type]  'proxy', 'redirection', 'dead', 'status'
+	 * @param   {Integer}  [ignore_id]    Must be supplied if type was also supplied
+	 * @returns {Promise}  rejects with a DomainConflictError naming the host that has it
+	 */
+	assertDomainNamesAvailable: function (domain_names, ignore_type, ignore_id) {
+		const lowered   = domain_names.map((domain_name) => domain_name.toLowerCase());
+		const duplicate = _.find(lowered, (domain_name, index) => lowered.indexOf(domain_name) !== index);
+
+		if (duplicate) {
+			return Promise.reject(new error.ValidationError(duplicate + ' is in the domain names more than once'));
+		}
+
+		return Promise.all(domain_names.map((domain_name) => internalHost.isHostnameTaken(domain_name, ignore_type, ignore_id)))
+			.then((check_results) => {
+				const result = _.find(check_results, 'is_taken');
+
+				if (result) {
+					throw new error.DomainConflictError(result.hostname + ' is already in use by ' + result.conflict.object_type.replace('-', ' ') + ' #' + result.conflict.object_id, result.conflict);
+				}
+			});
+	},
+
 	/**
 	 * Private call only
 	 *
 	 * @param   {String}  hostname
 	 * @param   {Array}   existing_rows
 	 * @param   {Integer} [ignore_id]
-	 * @returns {Boolean}
+	 * @returns {Object|null}  the row that has the hostname
 	 */
-	_checkHostnameRecordsTaken: function (hostname, existing_rows, ignore_id) {
-		let is_taken = false;
-
-		if (existing_rows && existing_rows.length) {
-			existing_rows.map(function (existing_row) {
-				existing_row.domain_names.map(function (existing_hostname) {
-					// Does this domain match?
-					if (existing_hostname.toLowerCase() === hostname.toLowerCase()) {
-						if (!ignore_id || ignore_id !== existing_row.id) {
-							is_taken = true;
-						}
-					}
-				});
+	_getHostnameRecordTaken: function (hostname, existing_rows, ignore_id) {
+		return _.find(existing_rows || [], (existing_row) => {
+			return (!ignore_id || ignore_id !== existing_row.id) && existing_row.domain_names.some((existing_hostname) => {
+				return existing_hostname.toLowerCase() === hostname.toLowerCase();
 			});
-		}
-
-		return is_taken;
+		}) || null;
 	},
 
 	/**
@@ -0,0 +1,52 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+const DEFAULT_STATUS = 403;
+
+const internalHotlink = {
+
+	/**
+	 * A blocked request sent to the host itself would be blocked again, with the same referrer
+	 *
+	 * @param   {Object}  data  payload
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		if (!data.hotlink || !data.hotlink.redirect_url) {
+			return Promise.resolve();
+		}
+
+		const hostname = new URL(data.hotlink.redirect_url).hostname.toLowerCase();
+		if ((data.domain_names || []).some((domain) => domain.toLowerCase() === hostname)) {
+			return Promise.reject(new error.ValidationError('Blocked requests can\'t be redirected to the host itself, it would block them again'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What to write into the config of a proxy host for its hotlink protection. The host's own names are always
+	 * allowed, and the ACME challenges are never blocked, as the CA doesn't send a referrer.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}  {variable, referers, allow_empty, extensions, status, redirect_url}
+	 */
+	getOptions: (host) => {
+		const hotlink = host.hotlink;
+		if (!hotlink) {
+			return null;
+		}
+
+		return {
+			variable:     'proxy_host_' + host.id + '_hotlink',
+			referers:     _.uniq(hotlink.referers || []),
+			allow_empty:  hotlink.allow_empty !== false,
+			// Every request is protected without a list
+			extensions:   hotlink.extensions && hotlink.extensions.length ? _.uniq(hotlink.extensions.map((extension) => extension.toLowerCase())).join('|') : null,
+			status:       hotlink.status || DEFAULT_STATUS,
+			redirect_url: hotlink.redirect_url || null
+		};
+	}
+};
+
+module.exports = internalHotlink;
@@ -0,0 +1,145 @@
+const _                 = require('lodash');
+const fs                = require('fs');
+const path              = require('path');
+const error             = require('../lib/error');
+const internalProxyHost = require('./proxy-host');
+const internalAuditLog  = require('./audit-log');
+
+/**
+ * The config files hosts can be imported from, and the files read from a directory of them
+ */
+const IMPORTERS = {
+	nginx:   {internal: './nginx-import', files: /\.conf$/},
+	caddy:   {internal: './caddy-import', files: /(^Caddyfile|\.caddy(file)?)$/i},
+	traefik: {internal: './traefik-import', files: /\.(ya?ml|json)$/i}
+};
+
+// Where config files can be read from, instead of uploading them
+const IMPORT_DIRS = ['/mnt/', '/media/', '/data/import/'];
+
+const internalImport = {
+
+	/**
+	 * @param   {String}  target  file or directory
+	 * @param   {RegExp}  files   the names of the files to read from a directory
+	 * @returns {Array}   [{file, text}]
+	 */
+	readPath: (target, files) => {
+		const resolved = path.resolve(target);
+
+		if (!IMPORT_DIRS.some((dir) => (resolved + '/').indexOf(dir) === 0)) {
+			throw new error.ValidationError('Config files can only be read from below ' + IMPORT_DIRS.join(', '));
+		}
+
+		let stat;
+		try {
+			stat = fs.statSync(resolved);
+		} catch (err) {
+			throw new error.ValidationError('There\'s no ' + resolved);
+		}
+
+		const names = stat.isDirectory() ? fs.readdirSync(resolved).filter((name) => files.test(name)).sort().map((name) => path.join(resolved, name)) : [resolved];
+
+		return names.map((file) => {
+			return {file: file, text: fs.readFileSync(file, {encoding: 'utf8'})};
+		});
+	},
+
+	/**
+	 * Hosts that served https get a new Let's Encrypt certificate when there's an email address to request it with
+	 *
+	 * @param   {Object}  result
+	 * @param   {String}  [letsencrypt_email]
+	 */
+	addCertificate: (result, letsencrypt_email) => {
+		if (!result.host || !result.https) {
+			return;
+		}
+
+		if (!letsencrypt_email) {
+			result.notes.push('It served https, choose a certificate for the host to do so again');
+			return;
+		}
+
+		result.host.certificate_id = 'new';
+		result.host.meta           = _.assign({}, result.host.meta, {
+			letsencrypt_email: letsencrypt_email,
+			letsencrypt_agree: true
+		});
+	},
+
+	/**
+	 * Converts the sites of another proxy's config files into proxy hosts, and creates them if asked to.
+	 * What couldn't be converted is reported, and kept in the advanced config where it can be.
+	 *
+	 * @param   {Access}   access
+	 * @param   {String}   format         nginx, caddy or traefik
+	 * @param   {Object}   data
+	 * @param   {Array}    [data.files]   [{file, text}], from an upload
+	 * @param   {String}   [data.config]
+	 * @param   {String}   [data.path]    of a file or directory to read
+	 * @param   {String}   [data.letsencrypt_email]
+	 * @param   {Boolean}  [data.create]
+	 * @returns {Promise}
+	 */
+	import: (access, format, data) => {
+		return access.can('system:import')
+			.then(() => {
+				let files = data.files || [];
+				if (typeof data.config === 'string') {
+					files.push({file: 'config', text: data.config});
+				}
+				if (data.path) {
+					files = files.concat(internalImport.readPath(data.path, IMPORTERS[format].files));
+				}
+				if (!files.length) {
+					throw new error.ValidationError('Upload a config file, or give its contents or path');
+				}
+
+				// The email address from the config, ie: of the Caddy global options, is used when none is given
+				return require(IMPORTERS[format].internal).convert(files).map((result) => {
+					internalImport.addCertificate(result, data.letsencrypt_email || result.letsencrypt_email);
+					return _.omit(result, ['letsencrypt_email']);
+				});
+			})
+			.then((results) => {
+				if (!data.create) {
+					return results;
+				}
+
+				// One after the other, each one reloads nginx
+				let sequence = Promise.resolve();
+
+				results.filter((result) => result.host).forEach((result) => {
+					sequence = sequence.then(() => {
+						return internalProxyHost.create(access, _.cloneDeep(result.host))
+							.then((row) => {
+								result.created_id = row.id;
+							})
+							.catch((err) => {
+								result.error = err.message;
+							});
+					});
+				});
+
+				return sequence
+					.then(() => {
+						return internalAuditLog.add(access, {
+							action:      'imported',
+							object_type: 'proxy-host',
+							object_id:   0,
+							meta:        {
+								format:  format,
+								created: _.compact(_.map(results, 'created_id')),
+								failed:  results.filter((result) => result.error).length
+							}
+						});
+					})
+					.then(() => {
+						return results;
+					});
+			});
+	}
+};
+
+module.exports = internalImport;
@@ -0,0 +1,180 @@
+const _                       = require('lodash');
+const error                   = require('../lib/error');
+const spreadsheet             = require('../lib/spreadsheet');
+const internalProxyHost       = require('./proxy-host');
+const internalRedirectionHost = require('./redirection-host');
+const internalDeadHost        = require('./dead-host');
+const internalStream          = require('./stream');
+const internalCertificate     = require('./certificate');
+const internalAuditLog        = require('./audit-log');
+
+const FORMATS = {
+	csv:  'text/csv; charset=utf-8',
+	xlsx: 'application/vnd.openxmlformats-officedocument.spreadsheetml.sheet'
+};
+
+const HOST_COLUMNS = [
+	{name: 'type', title: 'Type'},
+	{name: 'id', title: 'ID'},
+	{name: 'domain_names', title: 'Domains'},
+	{name: 'upstream', title: 'Upstream'},
+	{name: 'enabled', title: 'Enabled'},
+	{name: 'certificate', title: 'Certificate'},
+	{name: 'certificate_expires_on', title: 'Certificate Expires'},
+	{name: 'owner', title: 'Owner'},
+	{name: 'tags', title: 'Tags'},
+	{name: 'created_on', title: 'Created'},
+	{name: 'modified_on', title: 'Modified'}
+];
+
+const CERTIFICATE_COLUMNS = [
+	{name: 'id', title: 'ID'},
+	{name: 'nice_name', title: 'Name'},
+	{name: 'provider', title: 'Provider'},
+	{name: 'domain_names', title: 'Domains'},
+	{name: 'expires_on', title: 'Expires'},
+	{name: 'owner', title: 'Owner'},
+	{name: 'tags', title: 'Tags'},
+	{name: 'created_on', title: 'Created'}
+];
+
+/**
+ * @param   {Object}  row
+ * @returns {String}  ie: Jamie <jamie@example.com>
+ */
+const getOwner = (row) => {
+	return row.owner ? row.owner.name + ' <' + row.owner.email + '>' : '';
+};
+
+/**
+ * Each type of host the user can list, leaving out the ones they can't
+ *
+ * @param   {Function}  list
+ * @returns {Promise}   resolves with the rows, null when they can't be listed
+ */
+const listIfAllowed = (list) => {
+	return list()
+		.catch((err) => {
+			if (err instanceof error.PermissionError) {
+				return null;
+			}
+			throw err;
+		});
+};
+
+const internalInventory = {
+
+	FORMATS: FORMATS,
+
+	/**
+	 * One row for each host and stream the user can see, with where it sends requests and its certificate
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getHostRows: (access) => {
+		return Promise.all([
+			listIfAllowed(() => internalProxyHost.getAll(access, ['owner', 'certificate'])),
+			listIfAllowed(() => internalRedirectionHost.getAll(access, ['owner', 'certificate'])),
+			listIfAllowed(() => internalDeadHost.getAll(access, ['owner', 'certificate'])),
+			listIfAllowed(() => internalStream.getAll(access, ['owner']))
+		])
+			.then(([proxy_hosts, redirection_hosts, dead_hosts, streams]) => {
+				if (!proxy_hosts && !redirection_hosts && !dead_hosts && !streams) {
+					throw new error.PermissionError('Permission Denied');
+				}
+
+				const host = (type, row, upstream) => {
+					return {
+						type:                   type,
+						id:                     row.id,
+						domain_names:           row.domain_names,
+						upstream:               upstream,
+						enabled:                row.enabled ? 'yes' : 'no',
+						certificate:            row.certificate ? row.certificate.nice_name : '',
+						certificate_expires_on: row.certificate ? row.certificate.expires_on : '',
+						owner:                  getOwner(row),
+						tags:                   row.tags,
+						created_on:             row.created_on,
+						modified_on:            row.modified_on
+					};
+				};
+
+				return [].concat(
+					(proxy_hosts || []).map((row) => host('proxy-host', row, row.forward_scheme + '://' + row.forward_host + ':' + row.forward_port)),
+					(redirection_hosts || []).map((row) => host('redirection-host', row, row.forward_http_code + ' ' + (row.forward_scheme === '$scheme' ? '' : row.forward_scheme + '://') + row.forward_domain_name)),
+					(dead_hosts || []).map((row) => host('dead-host', row, '')),
+					(streams || []).map((row) => {
+						return _.assign(host('stream', row, row.forwarding_host + ':' + row.forwarding_port), {
+							domain_names: _.compact([row.tcp_forwarding ? 'tcp' : null, row.udp_forwarding ? 'udp' : null]).join('+') + ' :' + row.incoming_port
+						});
+					})
+				);
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getCertificateRows: (access) => {
+		return internalCertificate.getAll(access, ['owner'])
+			.then((rows) => {
+				return rows.map((row) => {
+					return {
+						id:           row.id,
+						nice_name:    row.nice_name,
+						provider:     row.provider,
+						domain_names: row.domain_names,
+						expires_on:   row.expires_on,
+						owner:        getOwner(row),
+						tags:         row.tags,
+						created_on:   row.created_on
+					};
+				});
+			});
+	},
+
+	/**
+	 * A spreadsheet of the hosts or certificates the user can see, for inventory audits
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.of      hosts or certificates
+	 * @param   {String}  [data.format]  csv or xlsx
+	 * @returns {Promise}  resolves with {filename, content_type, body}
+	 */
+	export: (access, data) => {
+		const format   = data.format || 'csv';
+		const columns  = data.of === 'certificates' ? CERTIFICATE_COLUMNS : HOST_COLUMNS;
+		const filename = 'npm-' + data.of + '-' + new Date().toISOString().substring(0, 10) + '.' + format;
+		let count      = 0;
+
+		return (data.of === 'certificates' ? internalInventory.getCertificateRows(access) : internalInventory.getHostRows(access))
+			.then((rows) => {
+				count = rows.length;
+				return format === 'xlsx' ? spreadsheet.toXlsx(_.upperFirst(data.of), columns, rows) : spreadsheet.toCsv(columns, rows);
+			})
+			.then((body) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'exported',
+					object_type: data.of === 'certificates' ? 'certificate' : 'host',
+					object_id:   0,
+					meta:        {
+						format: format,
+						count:  count
+					}
+				})
+					.then(() => {
+						return {
+							filename:     filename,
+							content_type: FORMATS[format],
+							body:         body
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalInventory;
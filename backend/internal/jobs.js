@@ -0,0 +1,211 @@
+const _              = require('lodash');
+const crypto         = require('crypto');
+const error          = require('../lib/error');
+const requestContext = require('../lib/request-context');
+const tracing        = require('../lib/tracing');
+const logger         = require('../logger').global;
+
+/**
+ * The phases of issuing a certificate in order, with how far along it is once each one starts.
+ * Propagation goes from its progress to the one of validation as the wait passes.
+ */
+const PHASES = {
+	queued:      0,
+	preparing:   5,
+	order:       20,
+	dns:         35,
+	propagation: 40,
+	validation:  85,
+	download:    95,
+	done:        100
+};
+
+// Finished jobs are kept this long for clients to pick up the result
+const KEEP_FOR = 1000 * 60 * 60;
+
+// Longest a request for a job can wait for it to change
+const MAX_WAIT = 60;
+
+let jobs = {};
+
+const internalJobs = {
+
+	PHASES: PHASES,
+
+	/**
+	 * Runs the work in the background and returns the job tracking it straight away
+	 *
+	 * @param   {Access}    access
+	 * @param   {String}    type  ie: certificate-create
+	 * @param   {Function}  fn    called with a function to report the phase with, ie: progress('dns'), resolving with the result
+	 * @returns {Object}
+	 */
+	start: (access, type, fn) => {
+		const job = {
+			id:          crypto.randomBytes(16).toString('hex'),
+			type:        type,
+			user_id:     access.token.getUserId(1),
+			status:      'running',
+			phase:       'queued',
+			phases:      [{phase: 'queued', started_on: new Date().toISOString()}],
+			seconds:     null,
+			object_type: type.split('-').shift(),
+			object_id:   null,
+			result:      null,
+			error:       null,
+			created_on:  new Date().toISOString(),
+			modified_on: new Date().toISOString(),
+			waiters:     []
+		};
+
+		jobs[job.id] = job;
+
+		// Each phase is an event of the job's span, so a trace shows how long each one took
+		const span = tracing.startSpan('job ' + type, {'job.id': job.id});
+
+		const progress = (phase, detail) => {
+			if (job.status !== 'running' || typeof PHASES[phase] === 'undefined') {
+				return;
+			}
+			if (detail && typeof detail.object_id !== 'undefined') {
+				job.object_id = detail.object_id;
+			}
+			if (phase !== job.phase) {
+				job.phase   = phase;
+				job.seconds = detail && detail.seconds ? detail.seconds : null;
+				job.phases.push({phase: phase, started_on: new Date().toISOString()});
+				span.addEvent(phase);
+			}
+			internalJobs.changed(job);
+		};
+
+		const finish = () => {
+			setTimeout(() => {
+				delete jobs[job.id];
+			}, KEEP_FOR).unref();
+			internalJobs.changed(job);
+		};
+
+		// After the caller has answered with the job, and not cancelled with its request
+		requestContext.detach(() => setImmediate(() => tracing.run(span, () => {
+			fn(progress)
+				.then((result) => {
+					job.status    = 'succeeded';
+					job.phase     = 'done';
+					job.result    = result;
+					job.object_id = result && result.id ? result.id : job.object_id;
+					job.phases.push({phase: 'done', started_on: new Date().toISOString()});
+					span.setAttributes({'job.object_id': job.object_id});
+					span.end();
+					finish();
+				})
+				.catch((err) => {
+					logger.warn('Job ' + job.id + ' (' + type + ') failed: ' + err.message);
+					job.status = 'failed';
+					job.error  = {
+						code:    err.status || 500,
+						message: err.public || err instanceof error.CommandError ? err.message : 'Internal Error'
+					};
+					if (err.public && err.problem) {
+						job.error.problem = err.problem;
+					}
+					span.end(err);
+					finish();
+				});
+		})));
+
+		return internalJobs.format(job);
+	},
+
+	/**
+	 * Wakes up the requests waiting for the job to change
+	 *
+	 * @param   {Object}  job
+	 */
+	changed: (job) => {
+		job.modified_on = new Date().toISOString();
+
+		const waiters = job.waiters;
+		job.waiters   = [];
+		waiters.forEach((resolve) => resolve());
+	},
+
+	/**
+	 * @param   {Object}  job
+	 * @returns {Number}  percentage
+	 */
+	getProgress: (job) => {
+		if (job.phase === 'propagation' && job.seconds) {
+			const started = new Date(_.last(job.phases).started_on).getTime();
+			const elapsed = (Date.now() - started) / 1000;
+			return Math.round(PHASES.propagation + (PHASES.validation - PHASES.propagation) * Math.min(1, elapsed / job.seconds));
+		}
+		return PHASES[job.phase];
+	},
+
+	/**
+	 * @param   {Object}  job
+	 * @returns {Object}
+	 */
+	format: (job) => {
+		let phase = job.phase;
+
+		// Certbot doesn't say when it's done waiting and asks for the validation
+		if (phase === 'propagation' && job.seconds && internalJobs.getProgress(job) >= PHASES.validation) {
+			phase = 'validation';
+		}
+
+		return {
+			id:          job.id,
+			type:        job.type,
+			status:      job.status,
+			phase:       phase,
+			progress:    internalJobs.getProgress(job),
+			phases:      job.phases,
+			object_type: job.object_type,
+			object_id:   job.object_id,
+			result:      job.result,
+			error:       job.error,
+			created_on:  job.created_on,
+			modified_on: job.modified_on
+		};
+	},
+
+	/**
+	 * A job of the user, or of anyone for admins. With wait, the request is held until
+	 * the job changes or the seconds have passed, so clients don't have to poll quickly.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.id
+	 * @param   {Number}  [data.wait]  seconds
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('jobs:get')
+			.then((access_data) => {
+				const job = jobs[data.id];
+
+				if (!job || (job.user_id !== access.token.getUserId(1) && access_data.roles.indexOf('admin') === -1)) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+
+				if (!data.wait || job.status !== 'running') {
+					return internalJobs.format(job);
+				}
+
+				return new Promise((resolve) => {
+					const timeout = setTimeout(resolve, Math.min(data.wait, MAX_WAIT) * 1000);
+					job.waiters.push(() => {
+						clearTimeout(timeout);
+						resolve();
+					});
+				})
+					.then(() => {
+						return internalJobs.format(job);
+					});
+			});
+	}
+};
+
+module.exports = internalJobs;
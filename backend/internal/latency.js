@@ -0,0 +1,161 @@
+const _                 = require('lodash');
+const fs                = require('fs');
+const moment            = require('moment');
+const config            = require('../lib/config');
+const internalProxyHost = require('./proxy-host');
+
+const logDir = config.getPath('logs');
+
+// Only the end of the logs is read, which covers the recent past of all but the busiest hosts
+const MAX_READ = 20 * 1024 * 1024;
+
+// The proxy log format, with the times at the end
+const LOG_LINE = /^\[([^\]]+)\] .*? - \S+ \S+ \S+ "([^"]*)" \[Client .*\[Time ([\d.]+)\] \[Upstream-time ([^\]]*)\]$/;
+
+const internalLatency = {
+
+	/**
+	 * Up to max bytes from the end of a file, starting at a whole line
+	 *
+	 * @param   {String}  file
+	 * @param   {Number}  max
+	 * @returns {Array}
+	 */
+	readTail: (file, max) => {
+		if (max <= 0 || !fs.existsSync(file)) {
+			return [];
+		}
+
+		const size   = fs.statSync(file).size;
+		const length = Math.min(size, max);
+		const buffer = Buffer.alloc(length);
+		const fd     = fs.openSync(file, 'r');
+		fs.readSync(fd, buffer, 0, length, size - length);
+		fs.closeSync(fd);
+
+		let lines = buffer.toString('utf8').split('\n');
+		if (length < size) {
+			lines.shift();
+		}
+		return lines;
+	},
+
+	/**
+	 * @param   {String}  line
+	 * @returns {Object|null}  times in seconds, upstream is null when nothing was proxied
+	 */
+	parseLine: (line) => {
+		const match = line.match(LOG_LINE);
+		if (!match) {
+			return null;
+		}
+
+		// Several times when nginx tried more than one upstream, ie: "0.012, 0.034"
+		let upstream = null;
+		const times  = match[4].split(/[\s,:]+/).filter((time) => /^[\d.]+$/.test(time));
+		if (times.length) {
+			upstream = _.sum(times.map(parseFloat));
+		}
+
+		return {
+			time:     moment(match[1], 'DD/MMM/YYYY:HH:mm:ss Z'),
+			path:     match[2].split('?')[0],
+			request:  parseFloat(match[3]),
+			upstream: upstream
+		};
+	},
+
+	/**
+	 * The custom location nginx would use for a path, which for NPM's prefix locations is the longest match
+	 *
+	 * @param   {Array}   locations
+	 * @param   {String}  path
+	 * @returns {String}
+	 */
+	getLocation: (locations, path) => {
+		const matches = (locations || []).filter((location) => location.path && path.indexOf(location.path) === 0);
+		if (!matches.length) {
+			return '/';
+		}
+		return _.maxBy(matches, (location) => location.path.length).path;
+	},
+
+	/**
+	 * Nearest rank percentiles
+	 *
+	 * @param   {Array}  values
+	 * @returns {Object|null}
+	 */
+	getPercentiles: (values) => {
+		if (!values.length) {
+			return null;
+		}
+
+		const sorted = _.sortBy(values);
+		const rank   = (p) => sorted[Math.max(0, Math.ceil(p / 100 * sorted.length) - 1)];
+
+		return {
+			p50: _.round(rank(50), 3),
+			p95: _.round(rank(95), 3),
+			p99: _.round(rank(99), 3),
+			max: _.round(sorted[sorted.length - 1], 3)
+		};
+	},
+
+	/**
+	 * @param   {Array}  items  parsed lines
+	 * @returns {Object}
+	 */
+	summarise: (items) => {
+		const proxied = items.filter((item) => item.upstream !== null);
+
+		return {
+			requests: items.length,
+			request:  internalLatency.getPercentiles(_.map(items, 'request')),
+			upstream: internalLatency.getPercentiles(_.map(proxied, 'upstream')),
+			proxy:    internalLatency.getPercentiles(proxied.map((item) => Math.max(0, item.request - item.upstream)))
+		};
+	},
+
+	/**
+	 * How long requests to a proxy host took in nginx overall, at the forward host, and the difference,
+	 * which is the time spent in the proxy and sending the response to the client.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Number}  [data.minutes]
+	 * @returns {Promise}
+	 */
+	getReport: (access, data) => {
+		const minutes = data.minutes || 60;
+		const since   = moment().subtract(minutes, 'minutes');
+
+		return internalProxyHost.get(access, {id: data.id})
+			.then((host) => {
+				const file    = logDir + '/proxy-host-' + host.id + '_access.log';
+				const current = internalLatency.readTail(file, MAX_READ);
+				const size    = _.sumBy(current, (line) => line.length + 1);
+
+				// Requests from before the log was last rotated
+				const lines = internalLatency.readTail(file + '.1', MAX_READ - size).concat(current);
+
+				const items = lines
+					.map(internalLatency.parseLine)
+					.filter((item) => item && item.time.isValid() && item.time.isSameOrAfter(since));
+
+				const locations = _.map(_.groupBy(items, (item) => internalLatency.getLocation(host.locations, item.path)), (group, path) => {
+					return _.assign({path: path}, internalLatency.summarise(group));
+				});
+
+				return _.assign({
+					object_id: host.id,
+					since:     since.toISOString()
+				}, internalLatency.summarise(items), {
+					locations: _.sortBy(locations, 'path')
+				});
+			});
+	}
+};
+
+module.exports = internalLatency;
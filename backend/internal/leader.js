@@ -0,0 +1,165 @@
+const os               = require('os');
+const crypto           = require('crypto');
+const moment           = require('moment');
+const config           = require('../lib/config');
+const logger           = require('../logger').global;
+const leaderLeaseModel = require('../models/leader_lease');
+
+// The lease the background work is run under
+const LEASE = 'workers';
+
+// In seconds, how long a lease lasts without being renewed, and how often the holder renews it
+const LEASE_TTL      = 30;
+const RENEW_INTERVAL = 10;
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+// Tells this replica apart from the others, and from itself before a restart
+const INSTANCE = os.hostname() + ':' + process.pid + ':' + crypto.randomBytes(4).toString('hex');
+
+let leader     = false;
+let expires_at = 0;
+let workers    = [];
+
+const internalLeader = {
+
+	interval: null,
+
+	/**
+	 * @returns {Boolean}  whether this replica runs the background work
+	 */
+	isLeader: () => {
+		return leader;
+	},
+
+	/**
+	 * @returns {String}
+	 */
+	getInstance: () => {
+		return INSTANCE;
+	},
+
+	/**
+	 * Takes the lease when it's free or has run out, or renews it when this replica holds it
+	 *
+	 * @returns {Promise}  resolves with whether this replica holds it now
+	 */
+	acquire: () => {
+		const now     = moment();
+		const expires = now.clone().add(LEASE_TTL, 'seconds');
+
+		return leaderLeaseModel
+			.query()
+			.patch({
+				holder:     INSTANCE,
+				expires_on: expires.format(DATE_FORMAT)
+			})
+			.where('name', LEASE)
+			.andWhere(function () {
+				this.where('holder', INSTANCE);
+				this.orWhere('expires_on', '<', now.format(DATE_FORMAT));
+			})
+			.then((count) => {
+				if (count) {
+					return true;
+				}
+
+				return leaderLeaseModel
+					.query()
+					.where('name', LEASE)
+					.first()
+					.then((row) => {
+						if (row) {
+							return false;
+						}
+
+						// The first replica to start, another one may be inserting it as well
+						return leaderLeaseModel
+							.query()
+							.insert({
+								name:       LEASE,
+								holder:     INSTANCE,
+								expires_on: expires.format(DATE_FORMAT)
+							})
+							.then(() => true)
+							.catch(() => false);
+					});
+			})
+			.then((held) => {
+				if (held) {
+					expires_at = expires.valueOf();
+				}
+				return held;
+			});
+	},
+
+	/**
+	 * Starts or stops the workers when this replica became the leader or stopped being it
+	 *
+	 * @returns {Promise}
+	 */
+	check: () => {
+		return internalLeader.acquire()
+			.catch((err) => {
+				logger.warn('Could not renew the leader lease: ' + err.message);
+				// Carries on until the lease runs out, as no other replica can take it before then
+				return leader && Date.now() < expires_at;
+			})
+			.then((held) => {
+				if (held && !leader) {
+					leader = true;
+					logger.info('This replica (' + INSTANCE + ') is the leader, starting the background work');
+					workers.forEach((worker) => worker.start());
+				} else if (!held && leader) {
+					leader = false;
+					logger.warn('This replica (' + INSTANCE + ') is no longer the leader, stopping the background work');
+					workers.forEach((worker) => worker.stop());
+				}
+			});
+	},
+
+	/**
+	 * Runs the workers on the replica that holds the lease. Without leader election they're started straight away.
+	 *
+	 * @param   {Array}  list  of {start, stop}
+	 * @returns {Promise}
+	 */
+	init: (list) => {
+		workers = list;
+
+		if (!config.useLeaderElection()) {
+			leader = true;
+			workers.forEach((worker) => worker.start());
+			return Promise.resolve();
+		}
+
+		logger.info('Leader election initialized for ' + INSTANCE);
+		internalLeader.interval = setInterval(internalLeader.check, RENEW_INTERVAL * 1000);
+		return internalLeader.check();
+	},
+
+	/**
+	 * Gives the lease up when stopping, so another replica takes over without waiting for it to run out
+	 *
+	 * @returns {Promise}
+	 */
+	release: () => {
+		if (!leader || !config.useLeaderElection()) {
+			return Promise.resolve();
+		}
+
+		clearInterval(internalLeader.interval);
+		leader = false;
+
+		return leaderLeaseModel
+			.query()
+			.patch({expires_on: moment().subtract(1, 'seconds').format(DATE_FORMAT)})
+			.where('name', LEASE)
+			.andWhere('holder', INSTANCE)
+			.catch((err) => {
+				logger.warn('Could not release the leader lease: ' + err.message);
+			});
+	}
+};
+
+module.exports = internalLeader;
@@ -0,0 +1,233 @@
+const _      = require('lodash');
+const fs     = require('fs');
+const path   = require('path');
+const config = require('../lib/config');
+
+/**
+ * The generated configs nginx loads into its http block, and the files that come with the image
+ */
+const CONFIG_DIRS = [
+	'/etc/nginx/conf.d',
+	config.getPath('nginx') + '/default_host',
+	config.getPath('nginx') + '/proxy_host',
+	config.getPath('nginx') + '/redirection_host',
+	config.getPath('nginx') + '/dead_host'
+];
+
+const RESOLVERS_FILE = '/etc/nginx/conf.d/include/resolvers.conf';
+
+// Listen options that apply to every server on the address once one of them has it
+const SOCKET_OPTIONS = ['ssl', 'proxy_protocol', 'http2'];
+
+// Bigger than this is more likely a typo than a real upload
+const MAX_BODY_SIZE = 10 * 1024 * 1024 * 1024;
+
+const internalLint = {
+
+	/**
+	 * @param   {String}  size  ie: 100m
+	 * @returns {Number}  in bytes
+	 */
+	parseSize: (size) => {
+		const match = String(size).match(/^(\d+)([kmg]?)$/i);
+		if (!match) {
+			return null;
+		}
+		return parseInt(match[1], 10) * Math.pow(1024, ['', 'k', 'm', 'g'].indexOf(match[2].toLowerCase()));
+	},
+
+	/**
+	 * @param   {String}  address  of a listen directive, ie: 80, [::]:443 or 10.0.0.1:80
+	 * @returns {String}  ie: *:80
+	 */
+	normaliseAddress: (address) => {
+		return /^\d+$/.test(address) ? '*:' + address : address;
+	},
+
+	/**
+	 * Splits a config into its server blocks, with the directives each one has at its own level.
+	 * Good enough for the files NPM writes, it isn't a full nginx parser.
+	 *
+	 * @param   {String}  file
+	 * @param   {String}  text
+	 * @returns {Array}   ie: [{file, line, listen: [{address, options, line}], server_names: [], directives: [{name, args, line}]}]
+	 */
+	parse: (file, text) => {
+		let servers = [];
+		let server  = null;
+		let depth   = 0;
+
+		text.split('\n').forEach((raw, index) => {
+			const line = raw.replace(/(^|\s)#.*$/, '').trim();
+			const num  = index + 1;
+
+			if (!line) {
+				return;
+			}
+
+			if (depth === 0 && /^server\s*\{/.test(line)) {
+				server = {file: file, line: num, listen: [], server_names: [], directives: []};
+				servers.push(server);
+			}
+
+			if (server) {
+				line.split(';').map((part) => part.trim()).filter((part) => part && !/[{}]/.test(part)).forEach((statement) => {
+					const args = statement.split(/\s+/);
+					const name = args.shift();
+
+					server.directives.push({name: name, args: args, line: num, depth: depth});
+
+					if (depth === 1 && name === 'listen') {
+						server.listen.push({
+							address: internalLint.normaliseAddress(args[0]),
+							options: args.slice(1).map((option) => option === 'default' ? 'default_server' : option),
+							line:    num
+						});
+					}
+
+					if (depth === 1 && name === 'server_name') {
+						server.server_names = server.server_names.concat(args);
+					}
+				});
+			}
+
+			depth += (line.match(/\{/g) || []).length - (line.match(/\}/g) || []).length;
+			if (depth <= 0) {
+				depth  = 0;
+				server = null;
+			}
+		});
+
+		return servers;
+	},
+
+	/**
+	 * @returns {Array}  the config files with their server blocks
+	 */
+	readConfigs: () => {
+		let servers = [];
+
+		CONFIG_DIRS.forEach((dir) => {
+			let names = [];
+			try {
+				names = fs.readdirSync(dir).filter((name) => name.endsWith('.conf')).sort();
+			} catch (err) {
+				return;
+			}
+
+			names.forEach((name) => {
+				const file = path.join(dir, name);
+				servers    = servers.concat(internalLint.parse(file, fs.readFileSync(file, {encoding: 'utf8'})));
+			});
+		});
+
+		return servers;
+	},
+
+	/**
+	 * @returns {Boolean}
+	 */
+	hasResolver: () => {
+		try {
+			return /^\s*resolver\s+\S+/m.test(fs.readFileSync(RESOLVERS_FILE, {encoding: 'utf8'}));
+		} catch (err) {
+			return false;
+		}
+	},
+
+	/**
+	 * Runs the checks over the server blocks
+	 *
+	 * @param   {Array}    servers
+	 * @param   {Boolean}  has_resolver
+	 * @returns {Array}
+	 */
+	check: (servers, has_resolver) => {
+		let problems = [];
+
+		const add = (level, rule, message, file, line) => {
+			problems.push({level: level, rule: rule, message: message, file: file, line: line});
+		};
+
+		// The same name on the same address in two files, nginx ignores the second one
+		let names = {};
+		servers.forEach((server) => {
+			server.listen.forEach((listen) => {
+				server.server_names.filter((name) => name !== '_' && name !== '""').forEach((name) => {
+					const key = name.toLowerCase() + ' ' + listen.address;
+					names[key] = names[key] || [];
+					if (!_.find(names[key], {file: server.file})) {
+						names[key].push({file: server.file, line: server.line});
+					}
+				});
+			});
+		});
+		_.forEach(names, (found, key) => {
+			if (found.length > 1) {
+				const [name, address] = key.split(' ');
+				found.slice(1).forEach((item) => {
+					add('warning', 'duplicate_server_name', name + ' on ' + address + ' is also in ' + found[0].file + ', nginx only uses the first one', item.file, item.line);
+				});
+			}
+		});
+
+		// Only one default server for each address, and options that apply to all of them
+		_.forEach(_.groupBy(_.flatMap(servers, (server) => server.listen.map((listen) => _.assign({file: server.file}, listen))), 'address'), (listens, address) => {
+			const defaults = listens.filter((listen) => listen.options.indexOf('default_server') !== -1);
+			defaults.slice(1).forEach((listen) => {
+				add('error', 'duplicate_default_server', address + ' already has a default server in ' + defaults[0].file, listen.file, listen.line);
+			});
+
+			SOCKET_OPTIONS.forEach((option) => {
+				const with_option    = listens.filter((listen) => listen.options.indexOf(option) !== -1);
+				const without_option = listens.filter((listen) => listen.options.indexOf(option) === -1);
+				if (with_option.length && without_option.length) {
+					without_option.forEach((listen) => {
+						add('warning', 'conflicting_listen', address + ' has ' + option + ' in ' + with_option[0].file + ', which applies to this server too', listen.file, listen.line);
+					});
+				}
+			});
+		});
+
+		servers.forEach((server) => {
+			server.directives.forEach((directive) => {
+				// Without a resolver nginx can't look up names in a proxy_pass with variables
+				if (directive.name === 'proxy_pass' && /\$/.test(directive.args[0] || '') && !has_resolver && !_.find(server.directives, {name: 'resolver'})) {
+					add('warning', 'missing_resolver', 'proxy_pass uses variables but there\'s no resolver, names can\'t be looked up', server.file, directive.line);
+				}
+
+				if (directive.name === 'client_max_body_size') {
+					const size = internalLint.parseSize(directive.args[0]);
+					if (size === 0) {
+						add('warning', 'oversized_body', 'client_max_body_size 0 turns the limit off, any client can send as much as it likes', server.file, directive.line);
+					} else if (size > MAX_BODY_SIZE) {
+						add('warning', 'oversized_body', 'client_max_body_size ' + directive.args[0] + ' is bigger than 10g', server.file, directive.line);
+					}
+				}
+			});
+		});
+
+		return _.sortBy(problems, [(problem) => problem.level === 'error' ? 0 : 1, 'file', 'line']);
+	},
+
+	/**
+	 * Checks the generated config files for mistakes nginx -t doesn't complain about
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	lint: (access) => {
+		return access.can('nginx:lint')
+			.then(() => {
+				const servers = internalLint.readConfigs();
+
+				return {
+					files:    _.uniq(_.map(servers, 'file')).length,
+					servers:  servers.length,
+					problems: internalLint.check(servers, internalLint.hasResolver())
+				};
+			});
+	}
+};
+
+module.exports = internalLint;
@@ -0,0 +1,118 @@
+const _            = require('lodash');
+const net          = require('net');
+const logger       = require('../logger').nginx;
+const error        = require('../lib/error');
+const settingModel = require('../models/setting');
+const streamModel  = require('../models/stream');
+
+const IPV4_ANY = '0.0.0.0';
+const IPV6_ANY = '[::]';
+
+const internalListen = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'listen')
+			.first();
+	},
+
+	/**
+	 * Checks the options of a host or stream, or of the setting, make sense on this server
+	 *
+	 * @param   {Object}  listen  payload
+	 * @returns {Promise}
+	 */
+	validate: (listen) => {
+		if (!listen || !listen.mode) {
+			return Promise.resolve();
+		}
+
+		const ipv6 = require('./nginx').ipv6Enabled();
+
+		if (listen.mode === 'addresses') {
+			const addresses = listen.addresses || [];
+			if (!addresses.length) {
+				return Promise.reject(new error.ValidationError('At least one address to listen on is required'));
+			}
+			if (!ipv6 && addresses.every((address) => net.isIPv6(address))) {
+				return Promise.reject(new error.ValidationError('IPv6 is disabled, so at least one IPv4 address is required'));
+			}
+		} else if (listen.mode === 'ipv6' && !ipv6) {
+			return Promise.reject(new error.ValidationError('IPv6 is disabled, so hosts can\'t listen on IPv6 only'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * The addresses a host or stream listens on, without the port, ie: '0.0.0.0', '[::]', '192.168.1.10'.
+	 * Its own options win over the setting, and with neither, or for dual-stack,
+	 * null is returned and the template writes the usual listen lines.
+	 *
+	 * @param   {Object}   setting
+	 * @param   {Object}   item     host or stream
+	 * @param   {Boolean}  ipv6     whether IPv6 is enabled
+	 * @returns {Array|null}
+	 */
+	getAddresses: (setting, item, ipv6) => {
+		let listen = null;
+
+		if (item.listen && !_.isEmpty(item.listen)) {
+			listen = item.listen;
+		} else if (setting && setting.value === 'custom') {
+			listen = setting.meta;
+		}
+
+		if (!listen || !listen.mode || listen.mode === 'dual') {
+			return null;
+		}
+
+		let addresses = [];
+
+		if (listen.mode === 'ipv4') {
+			addresses = [IPV4_ANY];
+		} else if (listen.mode === 'ipv6') {
+			addresses = ipv6 ? [IPV6_ANY] : [];
+		} else {
+			addresses = _.uniq(listen.addresses || [])
+				.filter((address) => ipv6 || !net.isIPv6(address))
+				.map((address) => net.isIPv6(address) ? '[' + address + ']' : address);
+		}
+
+		// With IPv6 disabled there might be nothing left, and nginx would pick for us
+		if (!addresses.length) {
+			logger.warn('Nothing left to listen on after leaving out IPv6, listening on all IPv4 addresses instead');
+			addresses = [IPV4_ANY];
+		}
+
+		return addresses;
+	},
+
+	/**
+	 * Applies the setting after it's changed, to every host and stream without their own options
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		const internalNginx = require('./nginx');
+
+		return streamModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('enabled', 1)
+			.then((streams) => {
+				return internalNginx.bulkGenerateConfigs('stream', streams.filter((stream) => {
+					return !(stream.meta && stream.meta.nginx_online === false) && _.isEmpty(stream.listen);
+				}));
+			})
+			.then(() => {
+				return require('./host').regenerateConfigs((host) => _.isEmpty(host.listen));
+			});
+	}
+};
+
+module.exports = internalListen;
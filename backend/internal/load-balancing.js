@@ -0,0 +1,136 @@
+const _                  = require('lodash');
+const net                = require('net');
+const error              = require('../lib/error');
+const internalUnixSocket = require('./unix-socket');
+
+const DEFAULT_COOKIE_NAME = 'npm_affinity';
+
+/**
+ * Connections to the forward hosts kept open, for anything not given on the host
+ */
+const KEEPALIVE_DEFAULTS = {
+	connections: 16,
+	timeout:     60,
+	requests:    1000
+};
+
+const internalLoadBalancing = {
+
+	/**
+	 * nginx doesn't allow backup servers when the server is picked by a hash
+	 *
+	 * @param   {Object}  load_balancing  payload
+	 * @returns {Promise}
+	 */
+	validate: (load_balancing) => {
+		if (!load_balancing || !load_balancing.session_affinity) {
+			return Promise.resolve();
+		}
+
+		if (_.find(load_balancing.servers, {backup: true})) {
+			return Promise.reject(new error.ValidationError('Backup servers can\'t be used with session affinity'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * A unix socket doesn't have a port
+	 *
+	 * @param   {String}  host
+	 * @param   {Number}  port
+	 * @returns {String}
+	 */
+	getAddress: (host, port) => {
+		if (internalUnixSocket.isSocket(host)) {
+			return host;
+		}
+		return (net.isIPv6(host) ? '[' + host + ']' : host) + ':' + port;
+	},
+
+	/**
+	 * Connections kept open to the servers of the upstream. Hosts with an upstream keep them by default,
+	 * one with only the forward host has to turn it on, as it's then resolved when nginx loads its config
+	 * instead of for each request.
+	 *
+	 * @param   {Object}   host
+	 * @param   {Boolean}  has_upstream  whether the host has more than the forward host
+	 * @returns {Object|null}
+	 */
+	getKeepalive: (host, has_upstream) => {
+		const keepalive = host.keepalive || null;
+
+		if (keepalive ? keepalive.enabled === false : !has_upstream) {
+			return null;
+		}
+
+		return _.assign({}, KEEPALIVE_DEFAULTS, _.pick(keepalive || {}, Object.keys(KEEPALIVE_DEFAULTS)));
+	},
+
+	/**
+	 * What to write into the config of a proxy host, or null when it only has the forward host
+	 * without keepalive. A fallback forward host is a backup server, tried when the others fail
+	 * or answer 502, 503 or 504. A forward host that's a unix socket always gets an upstream,
+	 * as the address nginx proxies to otherwise is put together with a port.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (host) => {
+		const load_balancing = host.load_balancing || {};
+		const fallback       = host.fallback && host.fallback.upstream ? host.fallback.upstream : null;
+		const socket         = internalUnixSocket.isSocket(host.forward_host);
+		const keepalive      = internalLoadBalancing.getKeepalive(host, (load_balancing.servers && load_balancing.servers.length > 0) || !!fallback);
+		if ((!load_balancing.servers || !load_balancing.servers.length) && !fallback && !keepalive && !socket) {
+			return null;
+		}
+
+		const name = 'proxy_host_' + host.id;
+
+		let servers = [{address: internalLoadBalancing.getAddress(host.forward_host, host.forward_port), weight: null, backup: false}];
+		(load_balancing.servers || []).forEach((server) => {
+			servers.push({
+				address: internalLoadBalancing.getAddress(server.forward_host, server.forward_port),
+				weight:  server.weight || null,
+				backup:  !!server.backup
+			});
+		});
+
+		if (fallback) {
+			servers.push({
+				address: internalLoadBalancing.getAddress(fallback.forward_host, fallback.forward_port),
+				weight:  null,
+				backup:  true
+			});
+		}
+
+		let affinity = null;
+		if (load_balancing.session_affinity) {
+			affinity = {type: load_balancing.session_affinity.type};
+
+			if (affinity.type === 'cookie') {
+				affinity.variable        = name + '_affinity';
+				affinity.cookie_variable = name + '_affinity_cookie';
+				affinity.cookie_name     = load_balancing.session_affinity.cookie_name || DEFAULT_COOKIE_NAME;
+				affinity.cookie_max_age  = load_balancing.session_affinity.cookie_max_age || 0;
+				affinity.secure          = !!host.certificate_id;
+			}
+		}
+
+		// The name of the upstream is sent as SNI unless it's told otherwise
+		const upstream_tls = host.upstream_tls && !_.isEmpty(host.upstream_tls) ? host.upstream_tls : null;
+
+		return {
+			name:            name,
+			method:          load_balancing.method || 'round_robin',
+			servers:         servers,
+			affinity:        affinity,
+			next_upstream:   !!fallback,
+			keepalive:       keepalive,
+			ssl_server_name: host.forward_scheme === 'https' && !upstream_tls,
+			ssl_name:        host.forward_scheme === 'https' && !(upstream_tls && upstream_tls.server_name) ? (socket ? host.tunnelled_host || null : host.forward_host) : null
+		};
+	}
+};
+
+module.exports = internalLoadBalancing;
@@ -0,0 +1,115 @@
+const error            = require('../lib/error');
+const internalAuditLog = require('./audit-log');
+
+/**
+ * The resources that can be locked. Required when used, as these modules require this one.
+ */
+const TYPES = {
+	'proxy-host': {
+		permission: 'proxy_hosts',
+		internal:   () => require('./proxy-host'),
+		model:      () => require('../models/proxy_host')
+	},
+	'redirection-host': {
+		permission: 'redirection_hosts',
+		internal:   () => require('./redirection-host'),
+		model:      () => require('../models/redirection_host')
+	},
+	'dead-host': {
+		permission: 'dead_hosts',
+		internal:   () => require('./dead-host'),
+		model:      () => require('../models/dead_host')
+	},
+	'stream': {
+		permission: 'streams',
+		internal:   () => require('./stream'),
+		model:      () => require('../models/stream')
+	},
+	'certificate': {
+		permission: 'certificates',
+		internal:   () => require('./certificate'),
+		model:      () => require('../models/certificate')
+	}
+};
+
+const internalLock = {
+
+	/**
+	 * Locked items can't be changed by anyone, including admins, until they're unlocked
+	 *
+	 * @param   {Object}  row
+	 * @param   {String}  action  ie: 'updated', 'deleted'
+	 */
+	assertUnlocked: (row, action) => {
+		if (row.locked) {
+			throw new error.ValidationError('This item is locked and cannot be ' + action + '. Unlock it first.');
+		}
+	},
+
+	/**
+	 * @param   {Access}   access
+	 * @param   {String}   object_type
+	 * @param   {Object}   data
+	 * @param   {Number}   data.id
+	 * @param   {Boolean}  locked
+	 * @returns {Promise}
+	 */
+	setLocked: (access, object_type, data, locked) => {
+		const type = TYPES[object_type];
+
+		return access.can(type.permission + ':update', data.id)
+			.then(() => {
+				return type.internal().get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				} else if (!!row.locked === locked) {
+					throw new error.ValidationError('This item is already ' + (locked ? 'locked' : 'unlocked'));
+				}
+
+				return type.model()
+					.query()
+					.where('id', row.id)
+					.patch({
+						locked: locked ? 1 : 0
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      locked ? 'locked' : 'unlocked',
+							object_type: object_type,
+							object_id:   row.id,
+							meta:        data
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	lock: (access, object_type, data) => {
+		return internalLock.setLocked(access, object_type, data, true);
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	unlock: (access, object_type, data) => {
+		return internalLock.setLocked(access, object_type, data, false);
+	}
+};
+
+module.exports = internalLock;
@@ -0,0 +1,271 @@
+const _            = require('lodash');
+const fs           = require('fs');
+const path         = require('path');
+const zlib         = require('zlib');
+const {pipeline}   = require('stream');
+const config       = require('../lib/config');
+const logger       = require('../logger').nginx;
+const utils        = require('../lib/utils');
+const syslog       = require('../lib/syslog');
+const settingModel = require('../models/setting');
+
+const logDir = config.getPath('logs');
+
+/**
+ * Used for anything not given in the setting
+ */
+const DEFAULTS = {
+	max_size: 10 * 1024 * 1024,
+	max_age:  28,
+	keep:     4,
+	compress: true
+};
+
+// ie: proxy-host-1_access.log, proxy-host-1_access.log.2.gz
+const LOG_FILE = /^(.+)_(access|error)\.log(?:\.(\d+))?(\.gz)?$/;
+
+// Log files that belong to a host, ie: proxy-host-1
+const HOST_NAME = /^(proxy-host|redirection-host|dead-host|stream)-(\d+)$/;
+
+const internalLogRotation = {
+
+	intervalTimeout:    1000 * 60 * 60, // 1 hour
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Log Rotation Timer initialized');
+		internalLogRotation.interval = setInterval(internalLogRotation.processLogs, internalLogRotation.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'log-rotation')
+			.first();
+	},
+
+	/**
+	 * Every access and error log in the logs dir, with the rotated copies of each
+	 *
+	 * @returns {Array}
+	 */
+	getFiles: () => {
+		let files = [];
+
+		fs.readdirSync(logDir).forEach((name) => {
+			const match = name.match(LOG_FILE);
+			if (!match) {
+				return;
+			}
+
+			const stat = fs.statSync(path.join(logDir, name));
+			if (!stat.isFile()) {
+				return;
+			}
+
+			files.push({
+				file:     path.join(logDir, name),
+				name:     match[1],
+				type:     match[2],
+				rotation: match[3] ? parseInt(match[3], 10) : 0,
+				size:     stat.size,
+				modified: stat.mtime
+			});
+		});
+
+		return files;
+	},
+
+	/**
+	 * Triggered by a timer, this rotates the logs that have grown over the size limit
+	 * and removes rotated logs that are too old or too many.
+	 *
+	 * @returns {Promise}
+	 */
+	processLogs: () => {
+		if (internalLogRotation.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalLogRotation.intervalProcessing = true;
+
+		return internalLogRotation.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return false;
+				}
+
+				const options = _.assign({}, DEFAULTS, setting.meta);
+				const files   = internalLogRotation.getFiles();
+				const groups  = _.groupBy(files, (item) => item.name + '_' + item.type);
+				let rotated   = [];
+
+				_.forEach(groups, (group) => {
+					const current = _.find(group, {rotation: 0});
+					if (current && current.size >= options.max_size) {
+						internalLogRotation.shift(current, group, options.keep);
+						rotated.push(current.file + '.1');
+					}
+				});
+
+				internalLogRotation.removeExpired(options.max_age);
+
+				if (!rotated.length) {
+					return false;
+				}
+
+				logger.info('Rotated ' + rotated.length + ' log file(s)');
+
+				// nginx keeps writing to the renamed files until it's told to open them again
+				return utils.exec('/usr/sbin/nginx -s reopen')
+					.then(() => {
+						let sequence = Promise.resolve();
+
+						rotated.forEach((file) => {
+							sequence = sequence
+								.then(() => {
+									if (options.syslog && options.syslog.host) {
+										return internalLogRotation.ship(file, options.syslog)
+											.catch((err) => {
+												logger.warn('Could not send ' + file + ' to syslog: ' + err.message);
+											});
+									}
+								})
+								.then(() => {
+									if (options.compress) {
+										return internalLogRotation.compress(file);
+									}
+								});
+						});
+
+						return sequence;
+					})
+					.then(() => {
+						return true;
+					});
+			})
+			.then((result) => {
+				internalLogRotation.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error('Log rotation failed: ' + err.message);
+				internalLogRotation.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * Moves each rotated copy up by one, the same way logrotate names them, and the current log to .1
+	 *
+	 * @param {Object}  current
+	 * @param {Array}   group    the current log and its rotated copies
+	 * @param {Number}  keep     how many rotated copies to keep
+	 */
+	shift: (current, group, keep) => {
+		_.sortBy(group.filter((item) => item.rotation > 0), 'rotation')
+			.reverse()
+			.forEach((item) => {
+				if (item.rotation >= keep) {
+					fs.unlinkSync(item.file);
+				} else {
+					fs.renameSync(item.file, current.file + '.' + (item.rotation + 1) + (item.file.endsWith('.gz') ? '.gz' : ''));
+				}
+			});
+
+		fs.renameSync(current.file, current.file + '.1');
+	},
+
+	/**
+	 * @param {Number}  max_age  in days
+	 */
+	removeExpired: (max_age) => {
+		const cutoff = Date.now() - max_age * 24 * 60 * 60 * 1000;
+
+		internalLogRotation.getFiles().forEach((item) => {
+			if (item.rotation > 0 && item.modified.getTime() < cutoff) {
+				fs.unlinkSync(item.file);
+			}
+		});
+	},
+
+	/**
+	 * @param   {String}  file
+	 * @returns {Promise}
+	 */
+	compress: (file) => {
+		return new Promise((resolve, reject) => {
+			pipeline(fs.createReadStream(file), zlib.createGzip(), fs.createWriteStream(file + '.gz'), (err) => {
+				if (err) {
+					reject(err);
+				} else {
+					fs.unlinkSync(file);
+					resolve();
+				}
+			});
+		});
+	},
+
+	/**
+	 * Sends each line of a rotated log to a syslog server
+	 *
+	 * @param   {String}  file
+	 * @param   {Object}  server
+	 * @returns {Promise}
+	 */
+	ship: (file, server) => {
+		const match    = path.basename(file).match(LOG_FILE);
+		const severity = match[2] === 'error' ? 'error' : 'info';
+		const lines    = fs.readFileSync(file, {encoding: 'utf8'}).split('\n').filter((line) => line.length);
+
+		return syslog.send(server, lines.map((line) => syslog.format(match[1] + '_' + match[2], severity, line)));
+	},
+
+	/**
+	 * Disk used by the logs of each host, and of the other logs nginx writes
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getUsage: (access) => {
+		return access.can('system:logs')
+			.then(() => {
+				let items = {};
+
+				internalLogRotation.getFiles().forEach((item) => {
+					if (typeof items[item.name] === 'undefined') {
+						const host = item.name.match(HOST_NAME);
+
+						items[item.name] = {
+							name:        item.name,
+							object_type: host ? host[1] : null,
+							object_id:   host ? parseInt(host[2], 10) : null,
+							access:      0,
+							error:       0,
+							rotated:     0,
+							total:       0
+						};
+					}
+
+					if (item.rotation > 0) {
+						items[item.name].rotated += item.size;
+					} else {
+						items[item.name][item.type] += item.size;
+					}
+					items[item.name].total += item.size;
+				});
+
+				const list = _.orderBy(_.values(items), ['total', 'name'], ['desc', 'asc']);
+
+				return {
+					total: _.sumBy(list, 'total'),
+					items: list
+				};
+			});
+	}
+};
+
+module.exports = internalLogRotation;
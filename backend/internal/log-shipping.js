@@ -0,0 +1,85 @@
+const net          = require('net');
+const loggers      = require('../logger');
+const logger       = loggers.global;
+const syslog       = require('../lib/syslog');
+const settingModel = require('../models/setting');
+
+let stream = null;
+
+const internalLogShipping = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'log-shipping')
+			.first();
+	},
+
+	/**
+	 * The syslog server nginx sends host logs to, or null when it shouldn't
+	 *
+	 * @param   {Object}  setting
+	 * @returns {String|null}  ie: 'logs.example.com:514'
+	 */
+	getNginxServer: (setting) => {
+		if (!setting || setting.value !== 'on' || !setting.meta || !setting.meta.host || setting.meta.nginx === false) {
+			return null;
+		}
+
+		// nginx only sends syslog over UDP, whatever the protocol is set to
+		const host = net.isIPv6(setting.meta.host) ? '[' + setting.meta.host + ']' : setting.meta.host;
+		return host + ':' + (setting.meta.port || 514);
+	},
+
+	/**
+	 * Starts or stops sending the backend's own logs
+	 *
+	 * @param {Object}  setting
+	 */
+	applyBackend: (setting) => {
+		const on = setting && setting.value === 'on' && setting.meta && setting.meta.host && setting.meta.backend !== false;
+
+		if (stream) {
+			loggers.setShipping(null);
+			stream.end();
+			stream = null;
+		}
+
+		if (on) {
+			stream = syslog.createStream({
+				host:     setting.meta.host,
+				port:     setting.meta.port || 514,
+				protocol: setting.meta.protocol
+			}, 'npm-backend');
+
+			loggers.setShipping(stream);
+			logger.info('Sending backend logs to ' + setting.meta.host);
+		}
+	},
+
+	/**
+	 * Called on startup
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return internalLogShipping.getSetting()
+			.then(internalLogShipping.applyBackend);
+	},
+
+	/**
+	 * Applies the setting after it's changed
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		internalLogShipping.applyBackend(setting);
+		return require('./host').regenerateConfigs();
+	}
+};
+
+module.exports = internalLogShipping;
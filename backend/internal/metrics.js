@@ -0,0 +1,113 @@
+const _              = require('lodash');
+const db             = require('../db');
+const queryStats     = require('../lib/query-stats');
+const readCache      = require('../lib/read-cache');
+const errorReport    = require('../lib/error-report');
+const denialStats    = require('../lib/denial-stats');
+const userModel      = require('../models/user');
+const internalLeader = require('./leader');
+
+const internalMetrics = {
+
+	/**
+	 * The database metrics and errors nothing was expecting, in the Prometheus text format
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getText: (access) => {
+		return access.can('system:metrics')
+			.then(() => {
+				const stats = queryStats.get(db);
+				const cache = readCache.getStats();
+
+				let lines = [
+					'# HELP npm_db_queries_total Database queries run',
+					'# TYPE npm_db_queries_total counter',
+					'npm_db_queries_total ' + stats.queries,
+					'# HELP npm_db_query_errors_total Database queries that failed',
+					'# TYPE npm_db_query_errors_total counter',
+					'npm_db_query_errors_total ' + stats.errors,
+					'# HELP npm_db_slow_queries_total Database queries slower than the slow query threshold',
+					'# TYPE npm_db_slow_queries_total counter',
+					'npm_db_slow_queries_total ' + stats.slow,
+					'# HELP npm_db_slow_query_threshold_seconds Queries taking longer are logged, 0 when none are',
+					'# TYPE npm_db_slow_query_threshold_seconds gauge',
+					'npm_db_slow_query_threshold_seconds ' + (stats.threshold / 1000),
+					'# HELP npm_db_query_duration_seconds How long database queries take',
+					'# TYPE npm_db_query_duration_seconds histogram'
+				];
+
+				stats.buckets.forEach((bucket) => {
+					lines.push('npm_db_query_duration_seconds_bucket{le="' + bucket.le + '"} ' + bucket.count);
+				});
+
+				lines = lines.concat([
+					'npm_db_query_duration_seconds_bucket{le="+Inf"} ' + stats.queries,
+					'npm_db_query_duration_seconds_sum ' + stats.sum,
+					'npm_db_query_duration_seconds_count ' + stats.queries,
+					'# HELP npm_db_pool_connections Connections of the database pool',
+					'# TYPE npm_db_pool_connections gauge',
+					'npm_db_pool_connections{state="used"} ' + stats.pool.used,
+					'npm_db_pool_connections{state="free"} ' + stats.pool.free,
+					'# HELP npm_db_pool_max_connections Most connections the database pool opens',
+					'# TYPE npm_db_pool_max_connections gauge',
+					'npm_db_pool_max_connections ' + stats.pool.max,
+					'# HELP npm_db_pool_pending_acquires Queries waiting for a connection',
+					'# TYPE npm_db_pool_pending_acquires gauge',
+					'npm_db_pool_pending_acquires ' + stats.pool.pending,
+					'# HELP npm_read_cache_requests_total Reads of host lists and counts, by whether the cache had them',
+					'# TYPE npm_read_cache_requests_total counter',
+					'npm_read_cache_requests_total{result="hit"} ' + cache.hits,
+					'npm_read_cache_requests_total{result="miss"} ' + cache.misses,
+					'# HELP npm_read_cache_entries Reads kept in the cache',
+					'# TYPE npm_read_cache_entries gauge',
+					'npm_read_cache_entries ' + cache.entries,
+					'# HELP npm_leader Whether this replica runs the background work shared by the replicas',
+					'# TYPE npm_leader gauge',
+					'npm_leader ' + (internalLeader.isLeader() ? 1 : 0),
+					'# HELP npm_unhandled_errors_total Errors nothing was expecting, ie: bugs answered with a 500',
+					'# TYPE npm_unhandled_errors_total counter',
+					'npm_unhandled_errors_total ' + errorReport.getCount(),
+					'# HELP npm_permission_denials_total Requests denied a permission',
+					'# TYPE npm_permission_denials_total counter'
+				]);
+
+				const totals = denialStats.getTotals();
+				Object.keys(totals).sort().forEach((permission) => {
+					lines.push('npm_permission_denials_total{permission="' + permission + '"} ' + totals[permission]);
+				});
+
+				return lines.join('\n') + '\n';
+			});
+	},
+
+	/**
+	 * The permissions requests were denied since the start, by user and route, with what the users would need
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getDenials: (access) => {
+		return access.can('system:metrics')
+			.then(() => {
+				const denials  = denialStats.get();
+				const user_ids = _.uniq(_.map(denials, 'user_id')).filter((id) => id > 0);
+
+				return userModel
+					.query()
+					.select('id', 'name', 'email')
+					.whereIn('id', user_ids)
+					.then((users) => {
+						return denials.map((denial) => {
+							const user = _.find(users, {id: denial.user_id});
+							return Object.assign(denial, {
+								user: user ? {name: user.name, email: user.email} : null
+							});
+						});
+					});
+			});
+	}
+};
+
+module.exports = internalMetrics;
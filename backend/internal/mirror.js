@@ -0,0 +1,42 @@
+const internalLoadBalancing = require('./load-balancing');
+
+const internalMirror = {
+
+	/**
+	 * What to write into the config of a proxy host for the custom locations that mirror their traffic.
+	 * A mirrored request is sent to an internal location, which drops it unless it was picked for the
+	 * percentage and otherwise forwards it to the shadow forward host. Its answer is thrown away.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}   ie: [{location: 0, uri: '/.npm-mirror-0', variable: 'proxy_host_1_mirror_0', percent: '10%', ...}]
+	 */
+	getOptions: (host) => {
+		let mirrors = [];
+
+		(host.locations || []).forEach((location, index) => {
+			const mirror = location.mirror;
+			if (!mirror || !mirror.forward_host) {
+				return;
+			}
+
+			const percentage = typeof mirror.percentage === 'number' ? mirror.percentage : 100;
+			if (percentage <= 0) {
+				return;
+			}
+
+			mirrors.push({
+				location:       index,
+				uri:            '/.npm-mirror-' + index,
+				// All of the traffic doesn't need picking
+				variable:       percentage < 100 ? 'proxy_host_' + host.id + '_mirror_' + index : null,
+				percent:        (+percentage.toFixed(2)) + '%',
+				forward_scheme: mirror.forward_scheme || location.forward_scheme,
+				address:        internalLoadBalancing.getAddress(mirror.forward_host, mirror.forward_port)
+			});
+		});
+
+		return mirrors;
+	}
+};
+
+module.exports = internalMirror;
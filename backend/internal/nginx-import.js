@@ -0,0 +1,402 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+// Directives NPM writes itself for every proxy host, so they're left out
+const DROPPED = {
+	server: [
+		'listen', 'server_name', 'http2', 'access_log', 'error_log',
+		'ssl_certificate', 'ssl_certificate_key', 'ssl_trusted_certificate', 'ssl_protocols', 'ssl_ciphers',
+		'ssl_prefer_server_ciphers', 'ssl_session_cache', 'ssl_session_timeout', 'ssl_session_tickets', 'ssl_stapling', 'ssl_stapling_verify'
+	],
+	location: ['proxy_pass', 'proxy_http_version']
+};
+
+// The headers NPM sets for the forward host
+const PROXY_HEADERS = ['host', 'x-real-ip', 'x-forwarded-for', 'x-forwarded-proto', 'x-forwarded-scheme', 'x-forwarded-host', 'upgrade', 'connection'];
+
+const PROXY_PASS = /^(https?):\/\/(\[[0-9a-f:.]+\]|[^/:$]+)(?::(\d+))?(\/.*)?$/i;
+
+const internalNginxImport = {
+
+	/**
+	 * @param   {String}  text
+	 * @returns {Array}   ie: [{type: 'word', value: 'listen', line: 1}, {type: ';', line: 1}]
+	 */
+	tokenize: (text) => {
+		let tokens = [];
+		let line   = 1;
+		let i      = 0;
+
+		while (i < text.length) {
+			const char = text[i];
+
+			if (char === '\n') {
+				line++;
+				i++;
+			} else if (/\s/.test(char)) {
+				i++;
+			} else if (char === '#') {
+				while (i < text.length && text[i] !== '\n') {
+					i++;
+				}
+			} else if (char === ';' || char === '{' || char === '}') {
+				tokens.push({type: char, line: line});
+				i++;
+			} else if (char === '"' || char === '\'') {
+				let value = '';
+				const start = line;
+				i++;
+				while (i < text.length && text[i] !== char) {
+					if (text[i] === '\\' && i + 1 < text.length) {
+						value += text[i + 1];
+						i += 2;
+						continue;
+					}
+					if (text[i] === '\n') {
+						line++;
+					}
+					value += text[i];
+					i++;
+				}
+				tokens.push({type: 'word', value: value, quoted: true, line: start});
+				i++;
+			} else {
+				let value = '';
+				while (i < text.length && !/[\s;{}]/.test(text[i])) {
+					value += text[i];
+					i++;
+				}
+				tokens.push({type: 'word', value: value, line: line});
+			}
+		}
+
+		return tokens;
+	},
+
+	/**
+	 * Turns the tokens into directives, with the ones in a block as its children
+	 *
+	 * @param   {Array}   tokens
+	 * @param   {String}  file
+	 * @returns {Array}   ie: [{name: 'server', args: [], line: 1, file: 'a.conf', block: [...]}]
+	 */
+	parse: (tokens, file) => {
+		let index = 0;
+
+		const parseBlock = (nested) => {
+			let directives = [];
+			let words      = [];
+
+			while (index < tokens.length) {
+				const token = tokens[index++];
+
+				if (token.type === 'word') {
+					words.push(token);
+				} else if (token.type === '}') {
+					if (!nested) {
+						throw new error.ValidationError(file + ':' + token.line + ' has a } without a {');
+					}
+					return directives;
+				} else if (!words.length) {
+					throw new error.ValidationError(file + ':' + token.line + ' has a ' + token.type + ' without a directive');
+				} else {
+					const directive = {
+						name:   words[0].value,
+						args:   words.slice(1).map((word) => word.value),
+						quoted: words.slice(1).map((word) => !!word.quoted),
+						line:   words[0].line,
+						file:   file
+					};
+
+					if (token.type === '{') {
+						directive.block = parseBlock(true);
+					}
+
+					directives.push(directive);
+					words = [];
+				}
+			}
+
+			if (nested || words.length) {
+				throw new error.ValidationError(file + ' ends before its last directive or block does');
+			}
+			return directives;
+		};
+
+		return parseBlock(false);
+	},
+
+	/**
+	 * @param   {Object}  directive
+	 * @param   {String}  [indent]
+	 * @returns {String}  the directive as it would be written in a config file
+	 */
+	render: (directive, indent = '') => {
+		const args = directive.args.map((arg, index) => {
+			return directive.quoted[index] || arg === '' || /[\s;{}#"']/.test(arg) ? '"' + arg.replace(/(["\\])/g, '\\$1') + '"' : arg;
+		});
+		const head = indent + [directive.name].concat(args).join(' ');
+
+		if (!directive.block) {
+			return head + ';';
+		}
+
+		return head + ' {\n' + directive.block.map((child) => internalNginxImport.render(child, indent + '    ')).join('\n') + '\n' + indent + '}';
+	},
+
+	/**
+	 * @param   {Array}  directives
+	 * @returns {Object} the server blocks and upstreams, in this block and any http block in it
+	 */
+	collect: (directives) => {
+		let found = {servers: [], upstreams: {}};
+
+		directives.forEach((directive) => {
+			if (directive.name === 'server' && directive.block) {
+				found.servers.push(directive);
+			} else if (directive.name === 'upstream' && directive.block && directive.args.length) {
+				found.upstreams[directive.args[0]] = directive;
+			} else if (directive.name === 'http' && directive.block) {
+				const nested = internalNginxImport.collect(directive.block);
+				found.servers   = found.servers.concat(nested.servers);
+				found.upstreams = _.assign(found.upstreams, nested.upstreams);
+			}
+		});
+
+		return found;
+	},
+
+	/**
+	 * @param   {String}  proxy_pass
+	 * @param   {Object}  upstreams
+	 * @returns {Object}  {forward_scheme, forward_host, forward_port, path}, or null when it isn't a plain address
+	 */
+	parseProxyPass: (proxy_pass, upstreams) => {
+		const match = (proxy_pass || '').match(PROXY_PASS);
+		if (!match) {
+			return null;
+		}
+
+		let host = match[2];
+		let port = match[3] ? parseInt(match[3], 10) : (match[1].toLowerCase() === 'https' ? 443 : 80);
+
+		// Only the first server of an upstream is kept
+		if (upstreams[host]) {
+			const server = _.find(upstreams[host].block, {name: 'server'});
+			const parts  = server ? server.args[0].match(/^(\[[0-9a-f:.]+\]|[^:]+)(?::(\d+))?$/i) : null;
+			if (!parts) {
+				return null;
+			}
+			host = parts[1];
+			port = parts[2] ? parseInt(parts[2], 10) : 80;
+		}
+
+		if (port < 1 || port > 65535) {
+			return null;
+		}
+
+		return {
+			forward_scheme: match[1].toLowerCase(),
+			forward_host:   host.replace(/^\[|\]$/g, ''),
+			forward_port:   port,
+			path:           match[4] || ''
+		};
+	},
+
+	/**
+	 * Sorts the directives of a location into the ones NPM writes itself, and the rest
+	 *
+	 * @param   {Array}  directives
+	 * @returns {Object} {websocket, kept}
+	 */
+	splitLocation: (directives) => {
+		let websocket = false;
+		let kept      = [];
+
+		directives.forEach((directive) => {
+			if (DROPPED.location.indexOf(directive.name) !== -1) {
+				return;
+			}
+
+			if (directive.name === 'proxy_set_header' && PROXY_HEADERS.indexOf((directive.args[0] || '').toLowerCase()) !== -1) {
+				if ((directive.args[0] || '').toLowerCase() === 'upgrade') {
+					websocket = true;
+				}
+				return;
+			}
+
+			kept.push(directive);
+		});
+
+		return {websocket: websocket, kept: kept};
+	},
+
+	/**
+	 * Converts a server block into a proxy host, with what couldn't be converted in its advanced config
+	 *
+	 * @param   {Object}  server
+	 * @param   {Object}  upstreams
+	 * @returns {Object}
+	 */
+	convertServer: (server, upstreams) => {
+		const directives   = server.block;
+		const domain_names = _.uniq(_.flatMap(_.filter(directives, {name: 'server_name'}), 'args')).filter((name) => name && name !== '_' && name.indexOf('~') !== 0);
+		const listens      = _.filter(directives, {name: 'listen'});
+		const ssl          = _.some(listens, (listen) => listen.args.indexOf('ssl') !== -1) || _.some(directives, {name: 'ssl_certificate'});
+
+		let result = {
+			file:         server.file,
+			line:         server.line,
+			domain_names: domain_names,
+			https:        false,
+			host:         null,
+			redirect:     null,
+			skipped:      null,
+			unconverted:  [],
+			notes:        [],
+			created_id:   null,
+			error:        null
+		};
+
+		// A server that only sends plain http over to https
+		const returns = _.filter(directives, {name: 'return'});
+		if (returns.length === 1 && directives.length === returns.length + _.filter(directives, (directive) => ['listen', 'server_name'].indexOf(directive.name) !== -1).length) {
+			if (/^30[178]$/.test(returns[0].args[0]) && /^https:\/\/\$(host|server_name)\$request_uri$/.test(returns[0].args[1] || '')) {
+				result.redirect = 'https';
+				return result;
+			}
+		}
+
+		if (!domain_names.length) {
+			result.skipped = 'It has no server_name a host can use';
+			return result;
+		}
+
+		const root = _.find(directives, (directive) => directive.name === 'location' && directive.block && directive.args.length === 1 && directive.args[0] === '/');
+		const pass = root ? _.find(root.block, {name: 'proxy_pass'}) : null;
+		const main = pass ? internalNginxImport.parseProxyPass(pass.args[0], upstreams) : null;
+
+		if (!main) {
+			result.skipped = pass ? 'The proxy_pass of location / isn\'t a plain address: ' + pass.args[0] : 'It has no location / with a proxy_pass';
+			return result;
+		}
+		if (main.path && main.path !== '/') {
+			result.skipped = 'The proxy_pass of location / goes to a path of the forward host: ' + pass.args[0];
+			return result;
+		}
+
+		let advanced  = [];
+		let locations = [];
+		let websocket = false;
+		let hsts      = null;
+
+		const place = (directive, placed_in) => {
+			result.unconverted.push({
+				directive: directive.block ? internalNginxImport.render(_.omit(directive, ['block'])).replace(/;$/, ' { ... }') : internalNginxImport.render(directive),
+				line:      directive.line,
+				placed_in: placed_in
+			});
+		};
+
+		directives.forEach((directive) => {
+			if (DROPPED.server.indexOf(directive.name) !== -1) {
+				return;
+			}
+
+			if (directive.name === 'add_header' && (directive.args[0] || '').toLowerCase() === 'strict-transport-security') {
+				hsts = {subdomains: /includesubdomains/i.test(directive.args[1] || '')};
+				return;
+			}
+
+			if (directive === root) {
+				const split = internalNginxImport.splitLocation(root.block);
+				websocket   = websocket || split.websocket;
+
+				// Proxy directives are inherited by the locations NPM writes
+				split.kept.forEach((child) => {
+					advanced.push(child);
+					place(child, 'advanced_config');
+				});
+				return;
+			}
+
+			if (directive.name === 'location' && directive.block && directive.args.length === 1) {
+				const location_pass = _.find(directive.block, {name: 'proxy_pass'});
+				const forward       = location_pass ? internalNginxImport.parseProxyPass(location_pass.args[0], upstreams) : null;
+
+				if (forward) {
+					const split = internalNginxImport.splitLocation(directive.block);
+					websocket   = websocket || split.websocket;
+
+					locations.push({
+						path:            directive.args[0],
+						forward_scheme:  forward.forward_scheme,
+						forward_host:    forward.forward_host,
+						forward_port:    forward.forward_port,
+						forward_path:    forward.path,
+						advanced_config: split.kept.map((child) => internalNginxImport.render(child)).join('\n')
+					});
+
+					split.kept.forEach((child) => place(child, 'location ' + directive.args[0]));
+					return;
+				}
+			}
+
+			advanced.push(directive);
+			place(directive, 'advanced_config');
+		});
+
+		result.host = {
+			domain_names:            domain_names,
+			forward_scheme:          main.forward_scheme,
+			forward_host:            main.forward_host,
+			forward_port:            main.forward_port,
+			allow_websocket_upgrade: websocket,
+			block_exploits:          false,
+			locations:               locations,
+			advanced_config:         advanced.map((directive) => internalNginxImport.render(directive)).join('\n'),
+			meta:                    {imported_from: server.file + ':' + server.line}
+		};
+
+		if (ssl) {
+			result.https                = true;
+			result.host.hsts_enabled    = !!hsts;
+			result.host.hsts_subdomains = !!hsts && hsts.subdomains;
+		}
+		if (upstreams[pass.args[0].replace(/^https?:\/\//i, '').split(/[/:]/)[0]]) {
+			result.notes.push('Only the first server of the upstream of location / is used');
+		}
+
+		return result;
+	},
+
+	/**
+	 * Converts the server blocks of nginx config files into proxy hosts
+	 *
+	 * @param   {Array}  files  [{file, text}]
+	 * @returns {Array}
+	 */
+	convert: (files) => {
+		// Upstreams can be in another file than the servers using them
+		const parsed    = files.map((item) => internalNginxImport.collect(internalNginxImport.parse(internalNginxImport.tokenize(item.text), item.file)));
+		const upstreams = _.assign.apply(_, [{}].concat(_.map(parsed, 'upstreams')));
+		const servers   = _.flatMap(parsed, 'servers').map((server) => internalNginxImport.convertServer(server, upstreams));
+
+		// The plain http servers that only redirect to https are part of the host of their names
+		const redirects = servers.filter((server) => server.redirect);
+		let results     = servers.filter((server) => !server.redirect);
+
+		redirects.forEach((redirect) => {
+			const host = _.find(results, (server) => server.host && _.intersection(server.domain_names, redirect.domain_names).length);
+			if (host) {
+				host.host.ssl_forced = true;
+			} else {
+				results.push(_.assign(redirect, {skipped: 'It only redirects to https'}));
+			}
+		});
+
+		return results.map((result) => _.omit(result, ['redirect']));
+	}
+};
+
+module.exports = internalNginxImport;
@@ -1,9 +1,50 @@
-const _      = require('lodash');
-const fs     = require('fs');
-const logger = require('../logger').nginx;
-const config = require('../lib/config');
-const utils  = require('../lib/utils');
-const error  = require('../lib/error');
+const _                     = require('lodash');
+const fs                    = require('fs');
+const path                  = require('path');
+const crypto                = require('crypto');
+const logger                = require('../logger').nginx;
+const config                = require('../lib/config');
+const utils                 = require('../lib/utils');
+const error                 = require('../lib/error');
+const helpers               = require('../lib/helpers');
+const tracing               = require('../lib/tracing');
+const accessListModel       = require('../models/access_list');
+const internalCompression   = require('./compression');
+const internalLogShipping   = require('./log-shipping');
+const internalUpstreamTls   = require('./upstream-tls');
+const internalUpstreamAuth  = require('./upstream-auth');
+const internalListen        = require('./listen');
+const internalHostPorts     = require('./host-ports');
+const internalCanonicalHost = require('./canonical-host');
+const internalTrafficSplit  = require('./traffic-split');
+const internalLoadBalancing = require('./load-balancing');
+const internalRedirectRules = require('./redirect-rules');
+const internalFallback      = require('./fallback');
+const internalMirror        = require('./mirror');
+const internalSubFilter     = require('./sub-filter');
+const internalUploads       = require('./uploads');
+const internalCorsPolicy    = require('./cors-policy');
+const internalHotlink       = require('./hotlink');
+const internalOptimizations = require('./optimizations');
+const internalExemptions    = require('./access-exemptions');
+const internalProtection    = require('./protection-presets');
+const internalServerHeader  = require('./server-header');
+const internalServedFiles   = require('./served-files');
+const internalActivity      = require('./activity');
+const internalAccessRequest = require('./access-request');
+const internalGuestLink     = require('./guest-link');
+const internalListPasswords = require('./access-list-password');
+const internalUnixSocket    = require('./unix-socket');
+const internalDocker        = require('./docker');
+const internalSshTunnel     = require('./ssh-tunnel');
+const internalPlugin        = require('./plugin');
+
+// The last config rendered for each file, with a hash of what it was rendered from. Templates only change
+// with an upgrade, so they're read into the hash but their includes aren't.
+let rendered = {};
+
+// Whether a config file was written or deleted since nginx was last reloaded
+let changed = true;
 
 const internalNginx = {
 
@@ -27,13 +68,18 @@ const internalNginx = {
 		return internalNginx.test()
 			.then(() => {
 				// Nginx is OK
-				// We're deleting this config regardless.
+				// The config is only written when it's different, so only the .err file is deleted first.
 				// Don't throw errors, as the file may not exist at all
-				// Delete the .err file too
-				return internalNginx.deleteConfig(host_type, host, false, true);
+				internalNginx.deleteFile(internalNginx.getConfigName(internalNginx.getFileFriendlyHostType(host_type), host.id) + '.err');
 			})
 			.then(() => {
-				return internalNginx.generateConfig(host_type, host);
+				return internalNginx.generateConfig(host_type, host)
+					.catch((err) => {
+						return internalNginx.deleteConfig(host_type, host)
+							.then(() => {
+								throw err;
+							});
+					});
 			})
 			.then(() => {
 				// Test nginx again and update meta with result
@@ -90,7 +136,10 @@ const internalNginx = {
 					});
 			})
 			.then(() => {
-				return internalNginx.reload();
+				return internalActivity.recordConfigure(host_type, host, combined_meta);
+			})
+			.then(() => {
+				return internalNginx.reloadIfChanged();
 			})
 			.then(() => {
 				return combined_meta;
@@ -98,6 +147,9 @@ const internalNginx = {
 	},
 
 	/**
+	 * Nginx commands aren't cancelled with the request, as a failed test marks the host as broken
+	 * and the configs written have to be reloaded
+	 *
 	 * @returns {Promise}
 	 */
 	test: () => {
@@ -105,17 +157,102 @@ const internalNginx = {
 			logger.info('Testing Nginx configuration');
 		}
 
-		return utils.exec('/usr/sbin/nginx -t -g "error_log off;"');
+		return tracing.trace('nginx test', {}, () => {
+			return utils.exec('/usr/sbin/nginx -t -g "error_log off;"', {signal: null});
+		});
+	},
+
+	/**
+	 * Tests nginx as if some config files were different, without touching the files nginx runs with.
+	 * A copy of nginx.conf includes the other files as they are and the given ones from a temporary folder.
+	 *
+	 * @param   {Object}  files  config file name => the text it would have, or null when it would be deleted
+	 * @returns {Promise}  resolves with null when nginx accepts it, or with what nginx complained about
+	 */
+	testWith: (files) => {
+		const id       = crypto.randomBytes(8).toString('hex');
+		const temp_dir = '/tmp/npm-dry-run-' + id;
+		// Next to nginx.conf, as its relative includes are relative to the main config
+		const main     = '/etc/nginx/npm-dry-run-' + id + '.conf';
+
+		return Promise.resolve()
+			.then(() => {
+				let text = fs.readFileSync('/etc/nginx/nginx.conf', {encoding: 'utf8'});
+
+				fs.mkdirSync(temp_dir);
+
+				_.forEach(_.groupBy(Object.keys(files), path.dirname), (names, dir) => {
+					const existing = fs.existsSync(dir) ? fs.readdirSync(dir).filter((name) => /\.conf$/.test(name)).sort().map((name) => dir + '/' + name) : [];
+					const includes = _.union(existing, names)
+						.filter((name) => files[name] !== null)
+						.map((name) => {
+							if (typeof files[name] !== 'string') {
+								return name;
+							}
+
+							const copy = temp_dir + '/' + path.basename(dir) + '-' + path.basename(name);
+							fs.writeFileSync(copy, files[name], {encoding: 'utf8'});
+							return copy;
+						});
+
+					text = text.replace('include ' + dir + '/*.conf;', includes.map((name) => 'include ' + name + ';').join('\n\t'));
+				});
+
+				fs.writeFileSync(main, text, {encoding: 'utf8'});
+
+				return utils.exec('/usr/sbin/nginx -t -c ' + main + ' -g "error_log off;"', {signal: null});
+			})
+			.then(() => {
+				return null;
+			})
+			.catch((err) => {
+				// The same docker-ism as in configure() isn't worth reporting
+				return err.message.split('\n')
+					.filter((line) => line.indexOf('/var/log/nginx/error.log') === -1 && line.indexOf(main) === -1)
+					.join('\n');
+			})
+			.then((result) => {
+				fs.rmSync(main, {force: true});
+				fs.rmSync(temp_dir, {recursive: true, force: true});
+				return result;
+			});
 	},
 
 	/**
 	 * @returns {Promise}
 	 */
 	reload: () => {
-		return internalNginx.test()
+		return tracing.trace('nginx reload', {}, () => {
+			return internalNginx.test()
+				.then(() => {
+					logger.info('Reloading Nginx');
+					// Before the reload, so configs written while it runs are still reloaded next time
+					changed = false;
+					return utils.exec('/usr/sbin/nginx -s reload', {signal: null})
+						.catch((err) => {
+							// nginx is still running the configs from before
+							changed = true;
+							throw err;
+						});
+				});
+		});
+	},
+
+	/**
+	 * Reloads nginx only when a config file was written or deleted since it was last reloaded.
+	 * Files that aren't configs, such as certificates, need reload() instead.
+	 *
+	 * @returns {Promise}  resolves with whether nginx was reloaded
+	 */
+	reloadIfChanged: () => {
+		if (!changed) {
+			logger.info('Nginx config unchanged, not reloading');
+			return Promise.resolve(false);
+		}
+
+		return internalNginx.reload()
 			.then(() => {
-				logger.info('Reloading Nginx');
-				return utils.exec('/usr/sbin/nginx -s reload');
+				return true;
 			});
 	},
 
@@ -126,9 +263,15 @@ const internalNginx = {
 	 */
 	getConfigName: (host_type, host_id) => {
 		if (host_type === 'default') {
-			return '/data/nginx/default_host/site.conf';
+			return config.getPath('nginx') + '/default_host/site.conf';
+		}
+		if (host_type === 'admin_host') {
+			return config.getPath('nginx') + '/admin_host/access.conf';
 		}
-		return '/data/nginx/' + internalNginx.getFileFriendlyHostType(host_type) + '/' + host_id + '.conf';
+		if (host_type === 'admin_listen') {
+			return config.getPath('nginx') + '/admin_listen.conf';
+		}
+		return config.getPath('nginx') + '/' + internalNginx.getFileFriendlyHostType(host_type) + '/' + host_id + '.conf';
 	},
 
 	/**
@@ -156,15 +299,23 @@ const internalNginx = {
 						{ssl_forced: host.ssl_forced}, {caching_enabled: host.caching_enabled}, {block_exploits: host.block_exploits},
 						{allow_websocket_upgrade: host.allow_websocket_upgrade}, {http2_support: host.http2_support},
 						{hsts_enabled: host.hsts_enabled}, {hsts_subdomains: host.hsts_subdomains}, {access_list: host.access_list},
-						{certificate: host.certificate}, host.locations[i]);
+						{certificate: host.certificate}, {https_redirect_port: host.https_redirect_port}, {upstream_auth: host.upstream_auth}, host.locations[i]);
+
+					// The path of a unix socket isn't one to forward to
+					locationCopy.forward_socket = internalUnixSocket.isSocket(locationCopy.forward_host);
 
-					if (locationCopy.forward_host.indexOf('/') > -1) {
+					if (!locationCopy.forward_socket && locationCopy.forward_host.indexOf('/') > -1) {
 						const splitted = locationCopy.forward_host.split('/');
 
 						locationCopy.forward_host = splitted.shift();
 						locationCopy.forward_path = `/${splitted.join('/')}`;
 					}
 
+					locationCopy.mirror     = _.find(host.mirrors, {location: i}) || null;
+					locationCopy.sub_filter = internalSubFilter.getOptions(host.locations[i]);
+					locationCopy.uploads    = internalUploads.getOptions(host.locations[i]);
+					locationCopy.cors       = host.cors_policies ? host.cors_policies.locations[i] : null;
+
 					// eslint-disable-next-line
 					renderedLocations += await renderEngine.parseAndRender(template, locationCopy);
 				}
@@ -177,24 +328,29 @@ const internalNginx = {
 	},
 
 	/**
+	 * @param   {Object}  host
+	 * @returns {Boolean}  whether the access list of the host is already there with its clients
+	 */
+	hasAccessList: (host) => {
+		return !!host.access_list && host.access_list.id === host.access_list_id && Array.isArray(host.access_list.clients);
+	},
+
+	/**
+	 * Renders the config for a host without writing it
+	 *
 	 * @param   {String}  host_type
 	 * @param   {Object}  host
-	 * @returns {Promise}
+	 * @returns {Promise}  resolves with the config text
 	 */
-	generateConfig: (host_type, host_row) => {
+	renderConfig: (host_type, host_row) => {
 		// Prevent modifying the original object:
 		let host             = JSON.parse(JSON.stringify(host_row));
 		const nice_host_type = internalNginx.getFileFriendlyHostType(host_type);
 
-		if (config.debug()) {
-			logger.info('Generating ' + nice_host_type + ' Config:', JSON.stringify(host, null, 2));
-		}
-
 		const renderEngine = utils.getRenderEngine();
 
 		return new Promise((resolve, reject) => {
 			let template = null;
-			let filename = internalNginx.getConfigName(nice_host_type, host.id);
 
 			try {
 				template = fs.readFileSync(__dirname + '/../templates/' + nice_host_type + '.conf', {encoding: 'utf8'});
@@ -203,9 +359,6 @@ const internalNginx = {
 				return;
 			}
 
-			let locationsPromise;
-			let origLocations;
-
 			// Manipulate the data a bit before sending it to the template
 			if (nice_host_type !== 'default') {
 				host.use_default_location = true;
@@ -214,53 +367,206 @@ const internalNginx = {
 				}
 			}
 
-			if (host.locations) {
-				//logger.info ('host.locations = ' + JSON.stringify(host.locations, null, 2));
-				origLocations    = [].concat(host.locations);
-				locationsPromise = internalNginx.renderLocations(host).then((renderedLocations) => {
-					host.locations = renderedLocations;
-				});
+			// Set the ports for the host, the locations need them to redirect to https
+			if (['proxy_host', 'redirection_host', 'dead_host', 'status_page'].indexOf(nice_host_type) !== -1) {
+				const ports              = internalHostPorts.getPorts(host);
+				host.http_ports          = ports.http;
+				host.https_ports         = ports.https;
+				host.https_redirect_port = ports.https.length && ports.https.indexOf(443) === -1 ? ports.https[0] : null;
+				host.redirect_rules      = internalRedirectRules.getOptions(host);
+
+				// Domain names are stored in their unicode form, nginx only knows the punycode one
+				host.domain_names = host.domain_names.map((domain_name) => helpers.toAsciiDomain(domain_name) || domain_name);
+				if (host.forward_domain_name) {
+					host.forward_domain_name = host.forward_domain_name.replace(/^[^/:]+/, (domain_name) => helpers.toAsciiDomain(domain_name) || domain_name);
+				}
+			}
+
+			// Serve one of www and apex, and redirect the other to it
+			if (nice_host_type === 'proxy_host') {
+				// Containers named as forward hosts are written with the address they were last seen with
+				internalDocker.apply(host);
+				internalSshTunnel.apply(host);
+
+				const canonical = internalCanonicalHost.getRedirects(host);
+				if (canonical) {
+					host.domain_names        = canonical.domain_names;
+					host.canonical_redirects = canonical.redirects;
+				}
 
+				host.traffic_split     = internalTrafficSplit.getOptions(host);
+				host.load_balancing    = internalLoadBalancing.getOptions(host);
+				host.fallback          = internalFallback.getOptions(host);
+				host.mirrors           = internalMirror.getOptions(host);
+				host.cors_policies     = internalCorsPolicy.getOptions(host);
+				host.cors_maps         = host.cors_policies.maps;
+				host.cors              = host.cors_policies.server;
+				host.hotlink           = internalHotlink.getOptions(host);
+				host.optimizations     = internalOptimizations.getOptions(host);
+				host.access_exemptions = internalExemptions.getOptions(host);
+				host.served_files      = internalServedFiles.getOptions(host);
+			}
+
+			if (host.locations) {
 				// Allow someone who is using / custom location path to use it, and skip the default / location
 				_.map(host.locations, (location) => {
 					if (location.path === '/') {
 						host.use_default_location = false;
 					}
 				});
-
-			} else {
-				locationsPromise = Promise.resolve();
 			}
 
 			// Set the IPv6 setting for the host
 			host.ipv6 = internalNginx.ipv6Enabled();
 
-			locationsPromise.then(() => {
-				renderEngine
-					.parseAndRender(template, host)
-					.then((config_text) => {
-						fs.writeFileSync(filename, config_text, {encoding: 'utf8'});
+			Promise.resolve()
+				.then(() => {
+					if (nice_host_type === 'stream') {
+						return Promise.all([
+							internalListen.getSetting(),
+							// Fetched here as streams are written from places that don't expand it
+							host.access_list_id && !internalNginx.hasAccessList(host) ? accessListModel
+								.query()
+								.where('id', host.access_list_id)
+								.andWhere('is_deleted', 0)
+								.withGraphFetched('[clients]')
+								.first() : null
+						])
+							.then(([listen, access_list]) => {
+								host.listen_addresses = internalListen.getAddresses(listen, host, host.ipv6);
+								host.access_list      = internalNginx.hasAccessList(host) ? host.access_list : (access_list || null);
+							});
+					}
 
-						if (config.debug()) {
-							logger.success('Wrote config:', filename, config_text);
-						}
+					if (nice_host_type === 'status_page') {
+						return Promise.all([
+							internalLogShipping.getSetting(),
+							internalListen.getSetting()
+						])
+							.then(([log_shipping, listen]) => {
+								host.log_shipping     = internalLogShipping.getNginxServer(log_shipping);
+								host.listen_addresses = internalListen.getAddresses(listen, host, host.ipv6);
+								host.backend_port     = config.getSetting('port');
+							});
+					}
 
-						// Restore locations array
-						host.locations = origLocations;
+					if (['proxy_host', 'redirection_host', 'dead_host'].indexOf(nice_host_type) !== -1) {
+						return Promise.all([
+							internalCompression.getSetting(),
+							internalLogShipping.getSetting(),
+							internalListen.getSetting(),
+							internalServerHeader.getSetting(),
+							internalPlugin.render(nice_host_type, host_row)
+						])
+							.then(([compression, log_shipping, listen, server_header, plugin_config]) => {
+								host.compression      = internalCompression.getOptions(compression, host);
+								host.server_header    = internalServerHeader.getOptions(server_header, host);
+								host.log_shipping     = internalLogShipping.getNginxServer(log_shipping);
+								host.listen_addresses = internalListen.getAddresses(listen, host, host.ipv6);
+								host.plugin_config    = plugin_config;
+
+								if (nice_host_type === 'proxy_host') {
+									return Promise.all([
+										internalUpstreamTls.getOptions(host),
+										internalUpstreamAuth.getOptions(host),
+										internalProtection.getSetting(),
+										internalAccessRequest.getSetting(),
+										internalGuestLink.getOptions(host),
+										internalListPasswords.getSetting()
+									])
+										.then(([upstream_tls, upstream_auth, protection, access_request, guest_links, access_password]) => {
+											host.upstream_tls       = upstream_tls;
+											host.upstream_auth      = upstream_auth;
+											host.protection_presets = internalProtection.getOptions(protection, host);
+											host.access_request     = internalAccessRequest.getOptions(access_request, host);
+											host.guest_links        = guest_links;
+											host.access_password    = internalListPasswords.getOptions(access_password, host);
+										});
+								}
+							});
+					}
+				})
+				.then(() => {
+					// Clients let in for a while are left out once they've expired, which also changes the key below
+					if (host.access_list && Array.isArray(host.access_list.clients)) {
+						host.access_list.clients = host.access_list.clients.filter((client) => !internalAccessRequest.isExpired(client));
+					}
 
-						resolve(true);
-					})
-					.catch((err) => {
-						if (config.debug()) {
-							logger.warn('Could not write ' + filename + ':', err.message);
-						}
+					// Everything the config is rendered from is in the host by now, the times it was saved aren't used
+					const filename = internalNginx.getConfigName(nice_host_type, host.id);
+					const key      = crypto.createHash('sha256').update(template + JSON.stringify(_.omit(host, ['created_on', 'modified_on']))).digest('hex');
 
-						reject(new error.ConfigurationError(err.message));
-					});
-			});
+					if (rendered[filename] && rendered[filename].key === key) {
+						return rendered[filename].text;
+					}
+
+					const locationsPromise = host.locations ? internalNginx.renderLocations(host) : Promise.resolve(undefined);
+
+					return locationsPromise
+						.then((renderedLocations) => {
+							if (typeof renderedLocations !== 'undefined') {
+								host.locations = renderedLocations;
+							}
+							return renderEngine.parseAndRender(template, host);
+						})
+						.then((text) => {
+							rendered[filename] = {key: key, text: text};
+							return text;
+						});
+				})
+				.then(resolve)
+				.catch((err) => {
+					reject(new error.ConfigurationError(err.message));
+				});
 		});
 	},
 
+	/**
+	 * Writes the config for a host, when it's different from the file that's there
+	 *
+	 * @param   {String}  host_type
+	 * @param   {Object}  host
+	 * @returns {Promise}  resolves with whether the file was written
+	 */
+	generateConfig: (host_type, host_row) => {
+		const nice_host_type = internalNginx.getFileFriendlyHostType(host_type);
+		const filename       = internalNginx.getConfigName(nice_host_type, host_row.id);
+
+		if (config.debug()) {
+			logger.info('Generating ' + nice_host_type + ' Config:', JSON.stringify(host_row, null, 2));
+		}
+
+		return internalNginx.renderConfig(host_type, host_row)
+			.then((config_text) => {
+				let existing = null;
+				try {
+					existing = fs.readFileSync(filename, {encoding: 'utf8'});
+				} catch (err) {
+					// Not written yet
+				}
+
+				if (existing === config_text) {
+					return false;
+				}
+
+				fs.writeFileSync(filename, config_text, {encoding: 'utf8'});
+				changed = true;
+
+				if (config.debug()) {
+					logger.success('Wrote config:', filename, config_text);
+				}
+
+				return true;
+			})
+			.catch((err) => {
+				if (config.debug()) {
+					logger.warn('Could not write ' + filename + ':', err.message);
+				}
+
+				throw (err instanceof error.ConfigurationError ? err : new error.ConfigurationError(err.message));
+			});
+	},
+
 	/**
 	 * This generates a temporary nginx config listening on port 80 for the domain names listed
 	 * in the certificate setup. It allows the letsencrypt acme challenge to be requested by letsencrypt
@@ -278,7 +584,7 @@ const internalNginx = {
 
 		return new Promise((resolve, reject) => {
 			let template = null;
-			let filename = '/data/nginx/temp/letsencrypt_' + certificate.id + '.conf';
+			let filename = config.getPath('nginx') + '/temp/letsencrypt_' + certificate.id + '.conf';
 
 			try {
 				template = fs.readFileSync(__dirname + '/../templates/letsencrypt-request.conf', {encoding: 'utf8'});
@@ -290,9 +596,12 @@ const internalNginx = {
 			certificate.ipv6 = internalNginx.ipv6Enabled();
 
 			renderEngine
-				.parseAndRender(template, certificate)
+				.parseAndRender(template, _.assign({}, certificate, {
+					domain_names: certificate.domain_names.map((domain_name) => helpers.toAsciiDomain(domain_name) || domain_name)
+				}))
 				.then((config_text) => {
 					fs.writeFileSync(filename, config_text, {encoding: 'utf8'});
+					changed = true;
 
 					if (config.debug()) {
 						logger.success('Wrote config:', filename, config_text);
@@ -319,6 +628,7 @@ const internalNginx = {
 		logger.debug('Deleting file: ' + filename);
 		try {
 			fs.unlinkSync(filename);
+			changed = true;
 		} catch (err) {
 			logger.debug('Could not delete file:', JSON.stringify(err, null, 2));
 		}
@@ -340,7 +650,7 @@ const internalNginx = {
 	 * @returns {Promise}
 	 */
 	deleteLetsEncryptRequestConfig: (certificate) => {
-		const config_file = '/data/nginx/temp/letsencrypt_' + certificate.id + '.conf';
+		const config_file = config.getPath('nginx') + '/temp/letsencrypt_' + certificate.id + '.conf';
 		return new Promise((resolve/*, reject*/) => {
 			internalNginx.deleteFile(config_file);
 			resolve();
@@ -375,6 +685,8 @@ const internalNginx = {
 		const config_file     = internalNginx.getConfigName(internalNginx.getFileFriendlyHostType(host_type), typeof host === 'undefined' ? 0 : host.id);
 		const config_file_err = config_file + '.err';
 
+		changed = true;
+
 		return new Promise((resolve/*, reject*/) => {
 			fs.unlink(config_file, () => {
 				// ignore result, continue
@@ -386,18 +698,68 @@ const internalNginx = {
 		});
 	},
 
+	/**
+	 * Writes the configs of many hosts, a few at a time. One that fails doesn't stop the others.
+	 *
+	 * @param   {Array}   items  [{host_type, host}]
+	 * @returns {Promise}  resolves with [{host_type, id, written, error}], in the order of the items
+	 */
+	generateConfigs: (items) => {
+		const concurrency = Math.max(1, config.getSetting('config_concurrency'));
+
+		let results = new Array(items.length);
+		let next    = 0;
+
+		// Each worker takes the next item when it's done with the one before
+		const work = () => {
+			if (next >= items.length) {
+				return Promise.resolve();
+			}
+
+			const index = next++;
+			const item  = items[index];
+
+			return internalNginx.generateConfig(item.host_type, item.host)
+				.then((written) => {
+					results[index] = {host_type: item.host_type, id: item.host.id, written: written, error: null};
+				})
+				.catch((err) => {
+					logger.error('Could not write the config of ' + item.host_type + ' #' + item.host.id + ': ' + err.message);
+					results[index] = {host_type: item.host_type, id: item.host.id, written: false, error: err.message};
+				})
+				.then(work);
+		};
+
+		let workers = [];
+		for (let i = 0; i < Math.min(concurrency, items.length); i++) {
+			workers.push(work());
+		}
+
+		return Promise.all(workers)
+			.then(() => {
+				return results;
+			});
+	},
+
 	/**
 	 * @param   {String}  host_type
 	 * @param   {Array}   hosts
-	 * @returns {Promise}
+	 * @returns {Promise}  resolves with whether each file was written, rejects when any of them couldn't be
 	 */
 	bulkGenerateConfigs: (host_type, hosts) => {
-		let promises = [];
-		hosts.map(function (host) {
-			promises.push(internalNginx.generateConfig(host_type, host));
-		});
+		return internalNginx.generateConfigs(hosts.map((host) => {
+			return {host_type: host_type, host: host};
+		}))
+			.then((results) => {
+				const failed = results.filter((result) => result.error);
+				if (failed.length) {
+					throw new error.ConfigurationError(failed.length + ' of ' + results.length + ' configs couldn\'t be written: ' + failed.map((result) => {
+						return result.host_type + ' #' + result.id + ': ' + result.error;
+					}).join('; '));
+				}
 
-		return Promise.all(promises);
+				return _.map(results, 'written');
+			});
 	},
 
 	/**
@@ -0,0 +1,813 @@
+const _                     = require('lodash');
+const os                    = require('os');
+const http                  = require('http');
+const https                 = require('https');
+const crypto                = require('crypto');
+const moment                = require('moment');
+const error                 = require('../lib/error');
+const logger                = require('../logger').global;
+const proxyAgent            = require('../lib/proxy-agent');
+const smtp                  = require('../lib/smtp');
+const notificationHeldModel = require('../models/notification_held');
+const settingModel          = require('../models/setting');
+const userModel             = require('../models/user');
+
+const HTTP_TIMEOUT = 15000;
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+// Digests are sent from this hour, in the timezone of the user, when they don't have one
+const DEFAULT_DIGEST_HOUR = 8;
+
+// Messages held back that still couldn't be sent after this many days are dropped
+const HELD_DAYS = 7;
+
+// The most messages written out in a digest, the rest are counted
+const DIGEST_LIMIT = 50;
+
+// What a channel can be sent, all of them when a channel doesn't have a list
+const EVENTS = ['health', 'deploy', 'renewal', 'usage', 'domain-expiry', 'ct-monitor', 'access-request', 'automation'];
+
+const SEVERITIES = ['critical', 'warning', 'info'];
+
+/**
+ * @param   {Array}   [list]
+ * @param   {String}  item
+ * @returns {Boolean}  whether the item is in the list, any is when there isn't one
+ */
+const matches = (list, item) => {
+	return !list || !list.length || list.indexOf(item) !== -1;
+};
+
+/**
+ * @param   {String}  [timezone]  ie: Asia/Shanghai, the backend's when it's left out
+ * @param   {Date}    [date]
+ * @returns {String}  ie: 2026-10-16 03:12
+ */
+const formatTime = (timezone, date) => {
+	// Sweden writes dates and times the ISO way
+	return new Intl.DateTimeFormat('sv-SE', {
+		timeZone:  timezone || undefined,
+		year:      'numeric',
+		month:     '2-digit',
+		day:       '2-digit',
+		hour:      '2-digit',
+		minute:    '2-digit',
+		hourCycle: 'h23'
+	}).format(date || new Date());
+};
+
+/**
+ * @param   {String}  time  ie: 22:30
+ * @returns {Number}  minutes into the day
+ */
+const toMinutes = (time) => {
+	const [hours, minutes] = time.split(':');
+	return parseInt(hours, 10) * 60 + parseInt(minutes, 10);
+};
+
+/**
+ * @param   {Object}  quiet_hours  {start, end}
+ * @param   {Number}  minutes      into the day
+ * @returns {Boolean}
+ */
+const isQuietAt = (quiet_hours, minutes) => {
+	const start = toMinutes(quiet_hours.start);
+	const end   = toMinutes(quiet_hours.end);
+
+	if (start === end) {
+		return false;
+	}
+	// ie: 22:00 to 07:00 goes over midnight
+	return start < end ? minutes >= start && minutes < end : minutes >= start || minutes < end;
+};
+
+/**
+ * DingTalk custom robots with signing on want the time in milliseconds and its HMAC in the url
+ *
+ * @param   {String}  url
+ * @param   {String}  [secret]
+ * @returns {String}
+ */
+const signDingTalk = (url, secret) => {
+	if (!secret) {
+		return url;
+	}
+
+	const timestamp = Date.now();
+	const sign      = crypto.createHmac('sha256', secret).update(timestamp + '\n' + secret).digest('base64');
+
+	return url + (url.indexOf('?') === -1 ? '?' : '&') + 'timestamp=' + timestamp + '&sign=' + encodeURIComponent(sign);
+};
+
+/**
+ * Feishu signs with the time in seconds and the secret as the key, over nothing
+ *
+ * @param   {String}  [secret]
+ * @returns {Object}
+ */
+const signFeishu = (secret) => {
+	if (!secret) {
+		return {};
+	}
+
+	const timestamp = Math.floor(Date.now() / 1000);
+	return {
+		timestamp: String(timestamp),
+		sign:      crypto.createHmac('sha256', timestamp + '\n' + secret).update('').digest('base64')
+	};
+};
+
+// Self hosted servers are often only reachable over http on the LAN, the bots of the chat apps aren't.
+// Email has its own way of sending instead of a request.
+const CHANNELS = {
+	dingtalk: {
+		https:   true,
+		request: (channel, text) => {
+			return {
+				url:  signDingTalk(channel.url, channel.secret),
+				body: {msgtype: 'text', text: {content: text}}
+			};
+		},
+		// These answer 200 when the message was refused, with the reason in the body
+		check: (body) => body.errcode === 0 ? null : body.errmsg || 'errcode ' + body.errcode
+	},
+	wecom: {
+		https:   true,
+		request: (channel, text) => {
+			return {
+				url:  channel.url,
+				body: {msgtype: 'text', text: {content: text}}
+			};
+		},
+		check: (body) => body.errcode === 0 ? null : body.errmsg || 'errcode ' + body.errcode
+	},
+	feishu: {
+		https:   true,
+		request: (channel, text) => {
+			return {
+				url:  channel.url,
+				body: _.assign(signFeishu(channel.secret), {msg_type: 'text', content: {text: text}})
+			};
+		},
+		check: (body) => {
+			const code = typeof body.code !== 'undefined' ? body.code : body.StatusCode;
+			return code === 0 ? null : body.msg || body.StatusMessage || 'code ' + code;
+		}
+	},
+	telegram: {
+		https:   false,
+		request: (channel, text) => {
+			return {
+				// A local Bot API server can be used instead of Telegram's
+				url:  (channel.url || 'https://api.telegram.org').replace(/\/+$/, '') + '/bot' + channel.token + '/sendMessage',
+				// Longer messages are refused
+				body: {chat_id: channel.chat_id, text: text.slice(0, 4096), disable_web_page_preview: true}
+			};
+		},
+		check: (body) => body.ok ? null : body.description || 'not ok'
+	},
+	gotify: {
+		https:   false,
+		request: (channel, text) => {
+			return {
+				url:     channel.url.replace(/\/+$/, '') + '/message',
+				headers: {'X-Gotify-Key': channel.token},
+				body:    {title: 'Nginx Proxy Manager', message: text, priority: 5}
+			};
+		},
+		check: null
+	},
+	ntfy: {
+		https:   false,
+		request: (channel, text) => {
+			return {
+				url:     channel.url,
+				headers: _.assign({Title: 'Nginx Proxy Manager'}, channel.token ? {Authorization: 'Bearer ' + channel.token} : {}),
+				body:    text
+			};
+		},
+		check: null
+	},
+	email: {
+		https: false,
+		send:  (channel, text) => {
+			return smtp.send({
+				host:     channel.host,
+				port:     channel.port,
+				security: channel.security || 'starttls',
+				username: channel.username,
+				password: channel.password,
+				from:     channel.from,
+				to:       channel.to,
+				subject:  text.split('\n')[0].slice(0, 200),
+				text:     text
+			})
+				.catch((err) => {
+					throw new Error(channel.name + ': ' + err.message);
+				});
+		}
+	}
+};
+
+// What each type needs besides a name
+const REQUIRED = {
+	dingtalk: ['url'],
+	wecom:    ['url'],
+	feishu:   ['url'],
+	telegram: ['token', 'chat_id'],
+	gotify:   ['url', 'token'],
+	ntfy:     ['url'],
+	email:    ['host', 'from', 'to']
+};
+
+const internalNotifications = {
+
+	EVENTS:     EVENTS,
+	SEVERITIES: SEVERITIES,
+
+	intervalTimeout:    1000 * 60, // 1 minute
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Held Notifications Timer initialized');
+		internalNotifications.interval = setInterval(internalNotifications.processHeld, internalNotifications.intervalTimeout);
+	},
+
+	/**
+	 * @param   {String}  [id]
+	 * @returns {Promise}
+	 */
+	getSetting: (id) => {
+		return settingModel
+			.query()
+			.where('id', id || 'notification-channels')
+			.first();
+	},
+
+	/**
+	 * The channels an event is sent to. With routing on only the routes it matches decide that,
+	 * otherwise every channel that wants the event gets it.
+	 *
+	 * @param   {Object}  setting   notification-channels
+	 * @param   {Object}  [routing] notification-routes
+	 * @param   {String}  event
+	 * @param   {String}  severity
+	 * @returns {Array}
+	 */
+	getChannels: (setting, routing, event, severity) => {
+		const channels = (setting.meta.channels || []).filter((channel) => channel.enabled !== false);
+
+		if (routing && routing.value === 'on') {
+			const names = _.uniq(_.flatMap((routing.meta.routes || []).filter((route) => {
+				return route.enabled !== false && matches(route.events, event) && matches(route.severities, severity);
+			}), 'channels'));
+
+			return channels.filter((channel) => names.indexOf(channel.name) !== -1);
+		}
+
+		return channels.filter((channel) => matches(channel.events, event));
+	},
+
+	/**
+	 * @param   {Object}  preferences  notification_preferences of a user
+	 * @param   {Date}    [date]
+	 * @returns {Boolean}  whether it's within their quiet hours, in their timezone
+	 */
+	isQuiet: (preferences, date) => {
+		if (!preferences.quiet_hours) {
+			return false;
+		}
+		return isQuietAt(preferences.quiet_hours, toMinutes(formatTime(preferences.timezone, date).split(' ')[1]));
+	},
+
+	/**
+	 * Critical messages skip the digest, and quiet hours too when the user lets them
+	 *
+	 * @param   {Object}  preferences  notification_preferences of a user
+	 * @param   {String}  severity
+	 * @returns {Boolean}  whether a message is held back instead of sent now
+	 */
+	isHeld: (preferences, severity) => {
+		const critical = severity === 'critical';
+
+		if (internalNotifications.isQuiet(preferences)) {
+			return !critical || !preferences.quiet_hours.critical;
+		}
+		return preferences.delivery === 'digest' && !critical;
+	},
+
+	/**
+	 * The users that are told through notification channels, with their own preferences
+	 *
+	 * @returns {Promise}
+	 */
+	getSubscribers: () => {
+		return userModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('is_disabled', 0)
+			.whereNotNull('notification_preferences')
+			.then((rows) => {
+				return rows.filter((user) => user.notification_preferences && (user.notification_preferences.channels || []).length);
+			});
+	},
+
+	/**
+	 * The notification_preferences of a user, when they're saved
+	 *
+	 * @param   {Object}  [preferences]
+	 * @returns {Promise}
+	 */
+	validatePreferences: (preferences) => {
+		if (!preferences) {
+			return Promise.resolve();
+		}
+
+		if (preferences.timezone) {
+			try {
+				formatTime(preferences.timezone);
+			} catch (err) {
+				return Promise.reject(new error.ValidationError('The timezone ' + preferences.timezone + ' isn\'t one there is, ie: Asia/Shanghai'));
+			}
+		}
+
+		if (preferences.delivery === 'digest' && preferences.quiet_hours) {
+			const hour = typeof preferences.digest_hour === 'number' ? preferences.digest_hour : DEFAULT_DIGEST_HOUR;
+
+			// The digest goes at the first run in its hour that isn't quiet
+			if (_.range(hour * 60, hour * 60 + 60).every((minutes) => isQuietAt(preferences.quiet_hours, minutes))) {
+				return Promise.reject(new error.ValidationError('The digest hour ' + hour + ' is in the quiet hours, so the digest would never be sent'));
+			}
+		}
+
+		return internalNotifications.getSetting()
+			.then((setting) => {
+				const names   = _.map(setting && setting.meta ? setting.meta.channels : [], 'name');
+				const unknown = _.difference(preferences.channels || [], names);
+
+				if (unknown.length) {
+					throw new error.ValidationError('There\'s no notification channel called ' + unknown.join(', '));
+				}
+			});
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		const channels = meta.channels || [];
+
+		for (let i = 0; i < channels.length; i++) {
+			const channel = channels[i];
+			const missing = REQUIRED[channel.type].filter((field) => !channel[field] || (Array.isArray(channel[field]) && !channel[field].length));
+			if (missing.length) {
+				return Promise.reject(new error.ValidationError(channel.name + ' needs ' + missing.join(' and ')));
+			}
+
+			if (channel.type === 'email' && channel.username && channel.security === 'none') {
+				return Promise.reject(new error.ValidationError(channel.name + ' would send its password unencrypted, use tls or starttls'));
+			}
+
+			if (channel.url) {
+				let url;
+				try {
+					url = new URL(channel.url);
+				} catch (err) {
+					return Promise.reject(new error.ValidationError('The url of ' + channel.name + ' isn\'t a url'));
+				}
+				if (url.protocol !== 'https:' && (CHANNELS[channel.type].https || url.protocol !== 'http:')) {
+					return Promise.reject(new error.ValidationError('The url of ' + channel.name + ' has to be https://' + (CHANNELS[channel.type].https ? '' : ' or http://')));
+				}
+			}
+
+			if (_.findIndex(channels, {name: channel.name}) !== i) {
+				return Promise.reject(new error.ValidationError('There\'s more than one channel called ' + channel.name));
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * @param   {Object}  channel
+	 * @param   {String}  text
+	 * @returns {Promise}
+	 */
+	deliver: (channel, text) => {
+		if (CHANNELS[channel.type].send) {
+			return CHANNELS[channel.type].send(channel, text);
+		}
+
+		const request = CHANNELS[channel.type].request(channel, text);
+
+		return new Promise((resolve, reject) => {
+			const body   = typeof request.body === 'string' ? request.body : JSON.stringify(request.body);
+			const secure = request.url.startsWith('https:');
+			const req    = (secure ? https : http).request(request.url, {
+				method:  'POST',
+				timeout: HTTP_TIMEOUT,
+				agent:   secure ? proxyAgent.forUrl(request.url) : undefined,
+				headers: _.assign({
+					'Content-Type':   typeof request.body === 'string' ? 'text/plain; charset=utf-8' : 'application/json; charset=utf-8',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager'
+				}, request.headers || {})
+			}, (res) => {
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					if (res.statusCode < 200 || res.statusCode >= 300) {
+						reject(new Error(channel.name + ' answered ' + res.statusCode));
+						return;
+					}
+
+					let refused = null;
+					if (CHANNELS[channel.type].check) {
+						try {
+							refused = CHANNELS[channel.type].check(JSON.parse(raw_data));
+						} catch (err) {
+							refused = 'the answer wasn\'t JSON';
+						}
+					}
+
+					if (refused) {
+						reject(new Error(channel.name + ' refused the message: ' + refused));
+						return;
+					}
+					resolve();
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error(channel.name + ' didn\'t answer within ' + (HTTP_TIMEOUT / 1000) + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	/**
+	 * Sends a message to the channels the event is for. This should never get in the way
+	 * of what it's about, so it doesn't reject.
+	 *
+	 * @param   {String}  event     one of EVENTS
+	 * @param   {String}  severity  one of SEVERITIES
+	 * @param   {String}  text
+	 * @returns {Promise}
+	 */
+	send: (event, severity, text) => {
+		return Promise.all([
+			internalNotifications.getSetting(),
+			internalNotifications.getSetting('notification-routes'),
+			internalNotifications.getSubscribers()
+		])
+			.then(([setting, routing, users]) => {
+				if (!setting || setting.value !== 'on') {
+					return;
+				}
+
+				const channels = internalNotifications.getChannels(setting, routing, event, severity);
+				const message  = '[Nginx Proxy Manager ' + os.hostname() + '] ' + text;
+
+				return Promise.all(channels.map((channel) => {
+					const subscribers = users.filter((user) => user.notification_preferences.channels.indexOf(channel.name) !== -1);
+					let now           = [];
+					let held          = [];
+
+					subscribers.forEach((user) => {
+						const preferences = user.notification_preferences;
+						if ((preferences.muted_events || []).indexOf(event) === -1) {
+							(internalNotifications.isHeld(preferences, severity) ? held : now).push(user);
+						}
+					});
+
+					let recipient = null;
+					if (channel.type === 'email') {
+						// The addresses of the channel get everything, its users get their own emails when they want them
+						const to  = _.uniq(_.difference(channel.to || [], _.map(subscribers, 'email')).concat(_.map(now, 'email')));
+						recipient = to.length ? _.assign({}, channel, {to: to}) : null;
+					} else if (!subscribers.length || now.length) {
+						// A chat its users share is sent it once, when any of them wants it now
+						recipient = channel;
+					}
+
+					return Promise.all(held.map((user) => {
+						return notificationHeldModel
+							.query()
+							.insert({
+								user_id:  user.id,
+								channel:  channel.name,
+								event:    event,
+								severity: severity,
+								text:     text
+							})
+							.catch((err) => {
+								logger.warn('Could not hold back a notification for ' + user.email + ': ' + err.message);
+							});
+					}).concat(recipient ? [
+						internalNotifications.deliver(recipient, message)
+							.catch((err) => {
+								logger.warn('Could not send a notification to ' + channel.name + ': ' + err.message);
+							})
+					] : []));
+				}));
+			})
+			.catch((err) => {
+				logger.warn('Could not send notifications: ' + err.message);
+			});
+	},
+
+	/**
+	 * One message with what was held back for a user, the oldest first
+	 *
+	 * @param   {Object}  user
+	 * @param   {Array}   rows
+	 * @returns {String}
+	 */
+	getDigest: (user, rows) => {
+		const timezone = user.notification_preferences.timezone;
+		const lines    = _.take(rows, DIGEST_LIMIT).map((row) => {
+			return formatTime(timezone, moment(row.created_on).toDate()) + ' ' + row.text;
+		});
+
+		if (rows.length > DIGEST_LIMIT) {
+			lines.push('and ' + (rows.length - DIGEST_LIMIT) + ' more');
+		}
+
+		return '[Nginx Proxy Manager ' + os.hostname() + '] ' + rows.length + ' notification' + (rows.length === 1 ? '' : 's') + ' for ' + user.name + ' since ' +
+			formatTime(timezone, moment(rows[0].created_on).toDate()) + '\n\n' + lines.join('\n');
+	},
+
+	/**
+	 * Held notifications that can't be sent anymore are dropped, saying so
+	 *
+	 * @param   {Array}   rows
+	 * @param   {String}  reason
+	 * @returns {Promise}
+	 */
+	dropHeld: (rows, reason) => {
+		_.forEach(_.groupBy(rows, 'channel'), (channel_rows, name) => {
+			logger.warn('Dropped ' + channel_rows.length + ' held notification' + (channel_rows.length === 1 ? '' : 's') + ' for ' + name + ' that were never sent, ' + reason);
+		});
+
+		return notificationHeldModel
+			.query()
+			.delete()
+			.whereIn('id', _.map(rows, 'id'));
+	},
+
+	/**
+	 * Sends what was held back for each user once their quiet hours are over, and their digest at its hour.
+	 * Digests have what came before that hour started, so one is sent a day. Critical messages held
+	 * in quiet hours don't wait for the digest.
+	 *
+	 * @returns {Promise}
+	 */
+	processHeld: () => {
+		if (internalNotifications.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalNotifications.intervalProcessing = true;
+
+		return notificationHeldModel
+			.query()
+			.where('created_on', '<', moment().subtract(HELD_DAYS, 'days').format(DATE_FORMAT))
+			.then((rows) => {
+				if (rows.length) {
+					return internalNotifications.dropHeld(rows, 'as they couldn\'t be sent for ' + HELD_DAYS + ' days');
+				}
+			})
+			.then(() => {
+				return Promise.all([
+					internalNotifications.getSetting(),
+					internalNotifications.getSubscribers(),
+					notificationHeldModel
+						.query()
+						.orderBy('id', 'ASC')
+				]);
+			})
+			.then(([setting, users, held]) => {
+				const channels = setting && setting.value === 'on' ? (setting.meta.channels || []).filter((channel) => channel.enabled !== false) : [];
+				let sequence   = Promise.resolve();
+
+				_.forEach(_.groupBy(held, (row) => row.user_id + ' ' + row.channel), (rows) => {
+					const channel = _.find(channels, {name: rows[0].channel});
+					const user    = _.find(users, {id: rows[0].user_id});
+
+					if (!channel || !user || user.notification_preferences.channels.indexOf(channel.name) === -1) {
+						sequence = sequence.then(() => internalNotifications.dropHeld(rows, 'as the channel, its user or notifications were turned off or removed'));
+						return;
+					}
+
+					const preferences = user.notification_preferences;
+					if (internalNotifications.isQuiet(preferences)) {
+						return;
+					}
+
+					if (preferences.delivery === 'digest') {
+						const now     = formatTime(preferences.timezone).split(' ')[1].split(':');
+						const hour    = typeof preferences.digest_hour === 'number' ? preferences.digest_hour : DEFAULT_DIGEST_HOUR;
+						const started = moment().subtract(parseInt(now[1], 10), 'minutes').startOf('minute');
+
+						rows = rows.filter((row) => row.severity === 'critical' || (parseInt(now[0], 10) === hour && moment(row.created_on).isBefore(started)));
+					}
+
+					if (!rows.length) {
+						return;
+					}
+
+					sequence = sequence
+						.then(() => {
+							return internalNotifications.deliver(channel.type === 'email' ? _.assign({}, channel, {to: [user.email]}) : channel, internalNotifications.getDigest(user, rows));
+						})
+						.then(() => {
+							return notificationHeldModel
+								.query()
+								.delete()
+								.whereIn('id', _.map(rows, 'id'));
+						})
+						.catch((err) => {
+							// Don't want to stop the train here, the next run tries again
+							logger.warn('Could not send held notifications to ' + channel.name + ': ' + err.message);
+						});
+				});
+
+				return sequence.then(() => true);
+			})
+			.then((result) => {
+				internalNotifications.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalNotifications.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * What's held back for each user, until their quiet hours are over or their digest is sent
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getHeld: (access) => {
+		return access.can('settings:get', 'notification-channels')
+			.then(() => {
+				return notificationHeldModel
+					.query()
+					.orderBy('id', 'ASC');
+			});
+	},
+
+	/**
+	 * Sends what email channels sent to the old addresses to the new one instead, so they
+	 * reach whoever the CA writes to
+	 *
+	 * @param   {Array}   old_emails
+	 * @param   {String}  email
+	 * @returns {Promise}  resolves with the names of the channels that changed
+	 */
+	replaceRecipients: (old_emails, email) => {
+		return internalNotifications.getSetting()
+			.then((setting) => {
+				let changed = [];
+
+				const channels = (setting && setting.meta ? setting.meta.channels || [] : []).map((channel) => {
+					if (channel.type !== 'email' || !_.intersection(channel.to || [], old_emails).length) {
+						return channel;
+					}
+
+					changed.push(channel.name);
+					return _.assign({}, channel, {to: _.uniq(_.difference(channel.to, old_emails).concat([email]))});
+				});
+
+				if (!changed.length) {
+					return changed;
+				}
+
+				return settingModel
+					.query()
+					.where('id', 'notification-channels')
+					.patch({meta: _.assign({}, setting.meta, {channels: channels})})
+					.then(() => {
+						logger.info('Notification channels ' + changed.join(', ') + ' now send to ' + email);
+						return changed;
+					});
+			});
+	},
+
+	/**
+	 * Routes can only send to channels there are
+	 *
+	 * @param   {Object}  meta  of notification-routes
+	 * @returns {Promise}
+	 */
+	validateRoutes: (meta) => {
+		return internalNotifications.getSetting()
+			.then((setting) => {
+				const names   = _.map(setting && setting.meta ? setting.meta.channels : [], 'name');
+				const unknown = _.difference(_.flatMap(meta.routes || [], 'channels'), names);
+
+				if (unknown.length) {
+					throw new error.ValidationError('There\'s no notification channel called ' + _.uniq(unknown).join(', '));
+				}
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getRoutes: (access) => {
+		return access.can('settings:get', 'notification-routes')
+			.then(() => {
+				return internalNotifications.getSetting('notification-routes');
+			})
+			.then((setting) => {
+				return {
+					enabled: setting.value === 'on',
+					routes:  setting.meta.routes || []
+				};
+			});
+	},
+
+	/**
+	 * Replaces the routes through the setting, so they're checked and logged the same way
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Boolean}  [data.enabled]
+	 * @param   {Array}    data.routes
+	 * @returns {Promise}
+	 */
+	updateRoutes: (access, data) => {
+		// Required here, as the settings require this module
+		const internalSetting = require('./setting');
+
+		let payload = {id: 'notification-routes', meta: {routes: data.routes}};
+		if (typeof data.enabled !== 'undefined') {
+			payload.value = data.enabled ? 'on' : 'off';
+		}
+
+		return internalSetting.update(access, payload)
+			.then(() => {
+				return internalNotifications.getRoutes(access);
+			});
+	},
+
+	/**
+	 * Sends a message to a channel straight away, so it can be tried before it's saved
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  channel
+	 * @returns {Promise}
+	 */
+	test: (access, channel) => {
+		return access.can('settings:update', 'notification-channels')
+			.then(() => {
+				return internalNotifications.validate({channels: [channel]});
+			})
+			.then(() => {
+				return internalNotifications.deliver(channel, '[Nginx Proxy Manager ' + os.hostname() + '] This is a test message')
+					.catch((err) => {
+						throw new error.ValidationError(err.message);
+					});
+			})
+			.then(() => {
+				return {sent: true};
+			});
+	},
+
+	/**
+	 * Sends a test message to a channel that's been saved
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @returns {Promise}
+	 */
+	testSaved: (access, data) => {
+		return access.can('settings:update', 'notification-channels')
+			.then(() => {
+				return internalNotifications.getSetting();
+			})
+			.then((setting) => {
+				const channel = _.find(setting && setting.meta ? setting.meta.channels : [], {name: data.name});
+				if (!channel) {
+					throw new error.ItemNotFoundError(data.name);
+				}
+
+				return internalNotifications.test(access, channel);
+			});
+	}
+};
+
+module.exports = internalNotifications;
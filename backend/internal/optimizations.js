@@ -0,0 +1,38 @@
+const _ = require('lodash');
+
+const internalOptimizations = {
+
+	/**
+	 * What to write into the config of a proxy host, or null to leave nginx's defaults.
+	 * The expiry of a response is picked from its Content-Type in a map, the first type that matches wins.
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}  ie: {variable: 'proxy_host_1_expires', expires: [{type: '~*^image/', expires: '30d'}], gzip_static: true, etag: true}
+	 */
+	getOptions: (host) => {
+		const optimizations = host.optimizations;
+		if (!optimizations || _.isEmpty(optimizations)) {
+			return null;
+		}
+
+		const expires = (optimizations.expires || []).reduce((rules, rule) => {
+			rule.types.forEach((type) => {
+				// image/* is any image, text/css only text/css with or without a charset
+				const regex = type.slice(-2) === '/*' ? '~*^' + _.escapeRegExp(type.slice(0, -1)) : '~*^' + _.escapeRegExp(type) + '(;|$)';
+				if (!_.find(rules, {type: regex})) {
+					rules.push({type: regex, expires: rule.expires});
+				}
+			});
+			return rules;
+		}, []);
+
+		return {
+			variable:    'proxy_host_' + host.id + '_expires',
+			expires:     expires,
+			gzip_static: !!optimizations.gzip_static,
+			etag:        optimizations.etag !== false
+		};
+	}
+};
+
+module.exports = internalOptimizations;
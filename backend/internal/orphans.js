@@ -0,0 +1,294 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const error                = require('../lib/error');
+const config               = require('../lib/config');
+const logger               = require('../logger').global;
+const certificateModel     = require('../models/certificate');
+const accessListModel      = require('../models/access_list');
+const acmeDnsModel         = require('../models/acme_dns_account');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const streamModel          = require('../models/stream');
+const statusPageModel      = require('../models/status_page');
+const internalAuditLog     = require('./audit-log');
+const internalAdminListen  = require('./admin-listen');
+const internalHostDefaults = require('./host-defaults');
+
+/**
+ * The folders config files are written to, with the table of what they're written for
+ */
+const CONFIG_DIRS = {
+	proxy_host:       proxyHostModel,
+	redirection_host: redirectionHostModel,
+	dead_host:        deadHostModel,
+	stream:           streamModel,
+	status_page:      statusPageModel
+};
+
+// ie: 12.conf, or 12.conf.err when nginx didn't accept it
+const CONFIG_FILE = /^(\d+)\.conf(\.err)?$/;
+
+/**
+ * @param   {Object}  model
+ * @param   {String}  column
+ * @returns {Promise}  resolves with the values of the column in use by rows that aren't deleted
+ */
+const getUsed = (model, column) => {
+	return model
+		.query()
+		.where('is_deleted', 0)
+		.andWhere(column, '>', 0)
+		.select(column)
+		.then((rows) => rows.map((row) => row[column]));
+};
+
+const internalOrphans = {
+
+	/**
+	 * Certificates no host or status page uses, that don't serve the admin interface and aren't the default for new hosts
+	 *
+	 * @returns {Promise}
+	 */
+	getCertificates: () => {
+		return Promise.all([
+			certificateModel.query().where('is_deleted', 0).orderBy('id', 'ASC'),
+			getUsed(proxyHostModel, 'certificate_id'),
+			getUsed(redirectionHostModel, 'certificate_id'),
+			getUsed(deadHostModel, 'certificate_id'),
+			getUsed(statusPageModel, 'certificate_id'),
+			internalAdminListen.getSetting(),
+			internalHostDefaults.getSetting()
+		])
+			.then(([certificates, proxy_hosts, redirection_hosts, dead_hosts, status_pages, admin_listen, host_defaults]) => {
+				let used = proxy_hosts.concat(redirection_hosts, dead_hosts, status_pages);
+
+				if (admin_listen && admin_listen.value === 'https' && admin_listen.meta) {
+					used.push(admin_listen.meta.certificate_id);
+				}
+				if (host_defaults && host_defaults.value === 'on' && host_defaults.meta && host_defaults.meta.certificate === 'existing') {
+					used.push(host_defaults.meta.certificate_id);
+				}
+
+				return certificates
+					.filter((certificate) => used.indexOf(certificate.id) === -1)
+					.map((certificate) => {
+						return {
+							id:           certificate.id,
+							nice_name:    certificate.nice_name,
+							provider:     certificate.provider,
+							domain_names: certificate.domain_names,
+							expires_on:   certificate.expires_on
+						};
+					});
+			});
+	},
+
+	/**
+	 * Access lists no proxy host or stream uses, and that aren't the default for new hosts
+	 *
+	 * @returns {Promise}
+	 */
+	getAccessLists: () => {
+		return Promise.all([
+			accessListModel.query().where('is_deleted', 0).orderBy('id', 'ASC'),
+			getUsed(proxyHostModel, 'access_list_id'),
+			getUsed(streamModel, 'access_list_id'),
+			internalHostDefaults.getSetting()
+		])
+			.then(([access_lists, proxy_hosts, streams, host_defaults]) => {
+				let used = proxy_hosts.concat(streams);
+
+				if (host_defaults && host_defaults.value === 'on' && host_defaults.meta && host_defaults.meta.access_list_id) {
+					used.push(host_defaults.meta.access_list_id);
+				}
+
+				return access_lists
+					.filter((access_list) => used.indexOf(access_list.id) === -1)
+					.map((access_list) => {
+						return {
+							id:         access_list.id,
+							name:       access_list.name,
+							created_on: access_list.created_on
+						};
+					});
+			});
+	},
+
+	/**
+	 * Credentials of the ACME DNS server that no certificate has in its DNS provider credentials
+	 *
+	 * @returns {Promise}
+	 */
+	getDnsAccounts: () => {
+		return Promise.all([
+			acmeDnsModel.query().orderBy('id', 'ASC'),
+			certificateModel.query().where('is_deleted', 0)
+		])
+			.then(([accounts, certificates]) => {
+				const credentials = certificates
+					.map((certificate) => (certificate.meta && certificate.meta.dns_provider_credentials) || '')
+					.join('\n');
+
+				return accounts
+					.filter((account) => credentials.indexOf(account.username) === -1)
+					.map((account) => {
+						return {
+							id:         account.id,
+							username:   account.username,
+							subdomain:  account.subdomain,
+							created_on: account.created_on
+						};
+					});
+			});
+	},
+
+	/**
+	 * Config files of hosts and streams that were deleted, or never were in this database
+	 *
+	 * @returns {Promise}
+	 */
+	getConfigFiles: () => {
+		let found    = [];
+		let sequence = Promise.resolve();
+
+		_.forEach(CONFIG_DIRS, (model, dir) => {
+			const path = config.getPath('nginx') + '/' + dir;
+
+			sequence = sequence
+				.then(() => {
+					const files = fs.existsSync(path) ? fs.readdirSync(path).filter((name) => CONFIG_FILE.test(name)) : [];
+					if (!files.length) {
+						return;
+					}
+
+					return model
+						.query()
+						.where('is_deleted', 0)
+						.select('id')
+						.then((rows) => {
+							const ids = rows.map((row) => row.id);
+
+							files.forEach((name) => {
+								const id = parseInt(name.match(CONFIG_FILE)[1], 10);
+								if (ids.indexOf(id) === -1) {
+									found.push({
+										file:      path + '/' + name,
+										host_type: dir,
+										object_id: id
+									});
+								}
+							});
+						});
+				});
+		});
+
+		return sequence.then(() => {
+			return found;
+		});
+	},
+
+	/**
+	 * Everything that's left over and can go
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	get: (access) => {
+		return access.can('system:orphans')
+			.then(() => {
+				return Promise.all([
+					internalOrphans.getCertificates(),
+					internalOrphans.getAccessLists(),
+					internalOrphans.getDnsAccounts(),
+					internalOrphans.getConfigFiles()
+				]);
+			})
+			.then(([certificates, access_lists, dns_accounts, config_files]) => {
+				return {
+					certificates: certificates,
+					access_lists: access_lists,
+					dns_accounts: dns_accounts,
+					config_files: config_files
+				};
+			});
+	},
+
+	/**
+	 * Removes the chosen items of the report. Each one has to still be in the report when this runs,
+	 * so something that became used since the report was read is refused rather than removed.
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Boolean}  data.confirm
+	 * @param   {Array}    [data.certificates]  ids
+	 * @param   {Array}    [data.access_lists]  ids
+	 * @param   {Array}    [data.dns_accounts]  ids
+	 * @param   {Array}    [data.config_files]  paths, as in the report
+	 * @returns {Promise}  resolves with what was removed
+	 */
+	cleanup: (access, data) => {
+		const internalCertificate = require('./certificate');
+		const internalAccessList  = require('./access-list');
+		const internalAcmeDns     = require('./acme-dns');
+		const internalNginx       = require('./nginx');
+
+		if (data.confirm !== true) {
+			return Promise.reject(new error.ValidationError('Removing orphaned items has to be confirmed'));
+		}
+
+		const chosen = {
+			certificates: _.uniq(data.certificates || []),
+			access_lists: _.uniq(data.access_lists || []),
+			dns_accounts: _.uniq(data.dns_accounts || []),
+			config_files: _.uniq(data.config_files || [])
+		};
+
+		return internalOrphans.get(access)
+			.then((report) => {
+				const missing = _.flatMap(chosen, (items, name) => {
+					const keys = report[name].map((item) => name === 'config_files' ? item.file : item.id);
+					return _.difference(items, keys).map((item) => name + ' ' + item);
+				});
+
+				if (missing.length) {
+					throw new error.ValidationError('Not orphaned anymore, or never were: ' + missing.join(', '));
+				}
+
+				return chosen.certificates.reduce((promise, id) => promise.then(() => internalCertificate.delete(access, {id: id})), Promise.resolve())
+					.then(() => {
+						return chosen.access_lists.reduce((promise, id) => promise.then(() => internalAccessList.delete(access, {id: id})), Promise.resolve());
+					})
+					.then(() => {
+						return chosen.dns_accounts.reduce((promise, id) => promise.then(() => internalAcmeDns.delete(access, {id: id})), Promise.resolve());
+					})
+					.then(() => {
+						if (!chosen.config_files.length) {
+							return;
+						}
+
+						chosen.config_files.forEach((file) => {
+							logger.info('Removing the orphaned config ' + file);
+							fs.unlinkSync(file);
+						});
+
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'config-file',
+							object_id:   0,
+							meta:        {
+								files: chosen.config_files
+							}
+						})
+							.then(() => {
+								return internalNginx.reload();
+							});
+					});
+			})
+			.then(() => {
+				return chosen;
+			});
+	}
+};
+
+module.exports = internalOrphans;
@@ -0,0 +1,105 @@
+const error        = require('../lib/error');
+const logger       = require('../logger').global;
+const proxyAgent   = require('../lib/proxy-agent');
+const settingModel = require('../models/setting');
+
+const PROTOCOLS = ['http:', 'socks5:', 'socks5h:'];
+
+// What certbot, lego and pip read the proxy from, the way the container was started with them
+const ENV_NAMES = ['HTTP_PROXY', 'HTTPS_PROXY', 'ALL_PROXY', 'NO_PROXY', 'http_proxy', 'https_proxy', 'all_proxy', 'no_proxy'];
+const ENV_START = ENV_NAMES.reduce((env, name) => {
+	env[name] = process.env[name];
+	return env;
+}, {});
+
+const internalOutboundProxy = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'outbound-proxy')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		let url;
+		try {
+			url = new URL(meta.url);
+		} catch (err) {
+			return Promise.reject(new error.ValidationError('The proxy isn\'t a url, ie: http://proxy.example.com:3128 or socks5://127.0.0.1:1080'));
+		}
+
+		if (PROTOCOLS.indexOf(url.protocol) === -1) {
+			return Promise.reject(new error.ValidationError('The proxy has to be http://, socks5:// or socks5h://'));
+		}
+		if (url.pathname.replace(/\/+$/, '') || url.search || url.hash) {
+			return Promise.reject(new error.ValidationError('The proxy is only a host and port, with a username and password when it needs them'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Puts the proxy in the environment the backend runs certbot, lego and pip with, so the ACME servers
+	 * and the APIs of DNS providers are reached through it, and keeps it for the backend's own requests
+	 *
+	 * @param {Object}  setting
+	 */
+	apply: (setting) => {
+		const on      = setting && setting.value === 'on' && setting.meta && setting.meta.url;
+		const current = on ? {url: setting.meta.url, no_proxy: setting.meta.no_proxy || []} : null;
+
+		proxyAgent.configure(current);
+
+		ENV_NAMES.forEach((name) => {
+			if (typeof ENV_START[name] === 'undefined') {
+				delete process.env[name];
+			} else {
+				process.env[name] = ENV_START[name];
+			}
+		});
+
+		if (on) {
+			const no_proxy = ['localhost', '127.0.0.1', '::1'].concat(current.no_proxy).join(',');
+
+			['HTTP_PROXY', 'HTTPS_PROXY', 'ALL_PROXY'].forEach((name) => {
+				process.env[name]               = current.url;
+				process.env[name.toLowerCase()] = current.url;
+			});
+			process.env.NO_PROXY = no_proxy;
+			process.env.no_proxy = no_proxy;
+
+			logger.info('Reaching ACME servers, DNS providers and Docker Hub through ' + new URL(current.url).host);
+		}
+	},
+
+	/**
+	 * Called on startup
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		return internalOutboundProxy.getSetting()
+			.then(internalOutboundProxy.apply);
+	},
+
+	/**
+	 * Applies the setting after it's changed
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		internalOutboundProxy.apply(setting);
+		return Promise.resolve();
+	}
+};
+
+module.exports = internalOutboundProxy;
@@ -0,0 +1,404 @@
+const _                    = require('lodash');
+const http                 = require('http');
+const https                = require('https');
+const crypto               = require('crypto');
+const logger               = require('../logger').global;
+const error                = require('../lib/error');
+const proxyAgent           = require('../lib/proxy-agent');
+const pluginModel          = require('../models/plugin');
+const internalAuditLog     = require('./audit-log');
+const internalUpstreamAuth = require('./upstream-auth');
+
+// The most a plugin can add to the config of a host
+const MAX_CONFIG_LENGTH = 65536;
+
+// How the last call to each plugin went on this replica, by id, {called_on, error}
+let status = {};
+
+function omissions () {
+	return ['is_deleted', 'secret', 'owner.is_deleted'];
+}
+
+/**
+ * Whether each brace of nginx config is closed after it's opened, so it stays inside the block it's put in
+ *
+ * @param   {String}  text
+ * @returns {Boolean}
+ */
+const isBalanced = (text) => {
+	let depth = 0;
+	for (const character of text) {
+		depth += character === '{' ? 1 : (character === '}' ? -1 : 0);
+		if (depth < 0) {
+			return false;
+		}
+	}
+	return depth === 0;
+};
+
+const internalPlugin = {
+
+	/**
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		if (typeof data.url !== 'undefined') {
+			let url;
+			try {
+				url = new URL(data.url);
+			} catch (err) {
+				return Promise.reject(new error.ValidationError('The url of the plugin isn\'t a url, ie: http://plugin:8080/hooks'));
+			}
+			if (['http:', 'https:'].indexOf(url.protocol) === -1) {
+				return Promise.reject(new error.ValidationError('The url of the plugin has to be http:// or https://'));
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {Object}  the row with how it was last called, and whether its requests are signed
+	 */
+	addStatus: (row) => {
+		row.signed = !!row.secret;
+		row.status = status[row.id] || {called_on: null, error: null};
+		return _.omit(row, omissions());
+	},
+
+	/**
+	 * A host as it's sent to plugins, without the credentials it has
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object}
+	 */
+	getHost: (host) => {
+		return _.omit(internalUpstreamAuth.mask(_.clone(host)), ['access_list']);
+	},
+
+	/**
+	 * Sends a hook to a plugin
+	 *
+	 * @param   {Object}  plugin
+	 * @param   {String}  hook
+	 * @param   {Object}  payload
+	 * @returns {Promise} resolves with what it answered, {} when it wasn't JSON
+	 */
+	send: (plugin, hook, payload) => {
+		return new Promise((resolve, reject) => {
+			const body    = JSON.stringify(_.assign({hook: hook, sent_on: new Date().toISOString()}, payload));
+			const secure  = plugin.url.startsWith('https:');
+			const headers = {
+				'Content-Type':   'application/json; charset=utf-8',
+				'Content-Length': Buffer.byteLength(body),
+				'User-Agent':     'nginx-proxy-manager',
+				'X-NPM-Hook':     hook
+			};
+
+			if (plugin.secret) {
+				headers['X-NPM-Signature'] = 'sha256=' + crypto.createHmac('sha256', plugin.secret).update(body).digest('hex');
+			}
+
+			const req = (secure ? https : http).request(plugin.url, {
+				method:  'POST',
+				timeout: plugin.timeout * 1000,
+				agent:   secure ? proxyAgent.forUrl(plugin.url) : undefined,
+				headers: headers
+			}, (res) => {
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					if (res.statusCode < 200 || res.statusCode >= 300) {
+						reject(new Error(plugin.name + ' answered ' + res.statusCode));
+						return;
+					}
+
+					let answer = {};
+					try {
+						answer = JSON.parse(raw_data) || {};
+					} catch (err) {
+						// Only the hooks that refuse or add config need an answer
+					}
+					resolve(answer);
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error(plugin.name + ' didn\'t answer within ' + plugin.timeout + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		})
+			.then((answer) => {
+				status[plugin.id] = {called_on: new Date().toISOString(), error: null};
+				return answer;
+			}, (err) => {
+				status[plugin.id] = {called_on: new Date().toISOString(), error: err.message};
+				logger.warn('Plugin ' + plugin.name + ' failed on ' + hook + ': ' + err.message);
+				throw err;
+			});
+	},
+
+	/**
+	 * @param   {String}  hook
+	 * @returns {Promise} resolves with the enabled plugins that asked for it, in the order they were added
+	 */
+	getPlugins: (hook) => {
+		return pluginModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('enabled', 1)
+			.orderBy('id', 'ASC')
+			.then((rows) => {
+				return rows.filter((row) => (row.hooks || []).indexOf(hook) !== -1);
+			});
+	},
+
+	/**
+	 * Asks the plugins for a hook that can refuse, one after the other. The first one answering
+	 * {"allow": false} stops it, and so does one that fails when it's set to deny on errors.
+	 *
+	 * @param   {String}  hook     host.saving or auth.login
+	 * @param   {Object}  payload
+	 * @returns {Promise} rejects with a ValidationError saying which plugin refused
+	 */
+	check: (hook, payload) => {
+		return internalPlugin.getPlugins(hook)
+			.then((plugins) => {
+				return plugins.reduce((sequence, plugin) => {
+					return sequence.then(() => {
+						return internalPlugin.send(plugin, hook, payload)
+							.then((answer) => {
+								if (answer.allow === false) {
+									throw new error.ValidationError(plugin.name + ' refused this' + (answer.message ? ': ' + answer.message : ''));
+								}
+							}, (err) => {
+								if (plugin.on_error === 'deny') {
+									throw new error.ValidationError(plugin.name + ' couldn\'t be asked, so this was refused: ' + err.message);
+								}
+							});
+					});
+				}, Promise.resolve());
+			});
+	},
+
+	/**
+	 * Tells the plugins for a hook what happened, without waiting for them
+	 *
+	 * @param   {String}  hook     host.saved or certificate.issued
+	 * @param   {Object}  payload
+	 */
+	notify: (hook, payload) => {
+		internalPlugin.getPlugins(hook)
+			.then((plugins) => {
+				return Promise.all(plugins.map((plugin) => internalPlugin.send(plugin, hook, payload).catch(() => null)));
+			})
+			.catch((err) => {
+				logger.warn('Could not call the plugins for ' + hook + ': ' + err.message);
+			});
+	},
+
+	/**
+	 * What the plugins add to the server block of a host, each answering {"config": "..."}.
+	 * Config whose braces don't match is left out, so a plugin can't break out of the block.
+	 *
+	 * @param   {String}  host_type  proxy_host, redirection_host or dead_host
+	 * @param   {Object}  host
+	 * @returns {Promise} resolves with the directives, null when there are none
+	 */
+	render: (host_type, host) => {
+		return internalPlugin.getPlugins('config.render')
+			.then((plugins) => {
+				return Promise.all(plugins.map((plugin) => {
+					return internalPlugin.send(plugin, 'config.render', {host_type: host_type, host: internalPlugin.getHost(host)})
+						.then((answer) => {
+							if (typeof answer.config !== 'string' || !answer.config.trim()) {
+								return null;
+							}
+
+							if (answer.config.length > MAX_CONFIG_LENGTH || !isBalanced(answer.config)) {
+								status[plugin.id] = {called_on: new Date().toISOString(), error: 'The config it answered with was left out, it\'s too long or its braces don\'t match'};
+								logger.warn('Left out the config ' + plugin.name + ' added to ' + host_type + ' #' + host.id);
+								return null;
+							}
+
+							return '  # Plugin: ' + plugin.name + '\n' + answer.config.trim();
+						}, () => null);
+				}));
+			})
+			.then((configs) => {
+				configs = configs.filter((item) => item !== null);
+				return configs.length ? configs.join('\n\n') : null;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  data.url
+	 * @param   {Array}   data.hooks
+	 * @param   {String}  [data.secret]
+	 * @param   {String}  [data.on_error]
+	 * @param   {Number}  [data.timeout]
+	 * @param   {Boolean} [data.enabled]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('plugins:create', data)
+			.then(() => {
+				return internalPlugin.validate(data);
+			})
+			.then(() => {
+				return pluginModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						enabled:       data.enabled !== false,
+						name:          data.name,
+						url:           data.url,
+						secret:        data.secret || '',
+						hooks:         _.uniq(data.hooks),
+						on_error:      data.on_error || 'allow',
+						timeout:       data.timeout || 5,
+						meta:          {}
+					});
+			})
+			.then((row) => {
+				row = internalPlugin.addStatus(row);
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'plugin',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		let patch = _.pick(data, ['enabled', 'name', 'url', 'secret', 'hooks', 'on_error', 'timeout']);
+		if (patch.hooks) {
+			patch.hooks = _.uniq(patch.hooks);
+		}
+
+		return access.can('plugins:update', data.id)
+			.then(() => {
+				return internalPlugin.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalPlugin.validate(data)
+					.then(() => {
+						return pluginModel
+							.query()
+							.patchAndFetchById(row.id, patch);
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'plugin',
+					object_id:   saved_row.id,
+					meta:        _.omit(data, ['secret'])
+				})
+					.then(() => {
+						return internalPlugin.addStatus(saved_row);
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('plugins:delete', data.id)
+			.then(() => {
+				return internalPlugin.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return pluginModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						delete status[row.id];
+
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'plugin',
+							object_id:   row.id,
+							meta:        _.omit(row, ['status'])
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('plugins:get', data.id)
+			.then(() => {
+				return pluginModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first();
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return internalPlugin.addStatus(row);
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('plugins:list')
+			.then(() => {
+				return pluginModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+			})
+			.then((rows) => {
+				return rows.map(internalPlugin.addStatus);
+			});
+	}
+};
+
+module.exports = internalPlugin;
@@ -0,0 +1,358 @@
+const _                      = require('lodash');
+const error                  = require('../lib/error');
+const utils                  = require('../lib/utils');
+const projectModel           = require('../models/project');
+const projectPermissionModel = require('../models/project_permission');
+const userModel              = require('../models/user');
+const internalAuditLog       = require('./audit-log');
+const internalTenant         = require('./tenant');
+
+/**
+ * Tables of the resources that can be put in a project
+ */
+const RESOURCE_TABLES = ['proxy_host', 'redirection_host', 'dead_host', 'stream', 'certificate', 'access_list'];
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalProject = {
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  [data.description]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('projects:create', data)
+			.then(() => {
+				return projectModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						name:          data.name,
+						description:   data.description || '',
+						meta:          data.meta || {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'project',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.description]
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		return access.can('projects:update', data.id)
+			.then(() => {
+				return internalProject.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.id !== data.id) {
+					// Sanity check that something crazy hasn't happened
+					throw new error.InternalValidationError('Project could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
+				}
+
+				return projectModel
+					.query()
+					.patchAndFetchById(row.id, _.pick(data, ['name', 'description', 'meta']))
+					.then(utils.omitRow(omissions()));
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'project',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return saved_row;
+					});
+			});
+	},
+
+	/**
+	 * Deleting a project leaves everything that was in it, outside of any project
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('projects:delete', data.id)
+			.then(() => {
+				return internalProject.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return projectModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						return projectPermissionModel
+							.query()
+							.delete()
+							.where('project_id', row.id);
+					})
+					.then(() => {
+						return Promise.all(RESOURCE_TABLES.map((table) => {
+							return projectModel
+								.knex()
+								.table(table)
+								.where('project_id', row.id)
+								.update({project_id: 0});
+						}));
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'project',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('projects:get', data.id)
+			.then((access_data) => {
+				return internalProject.getVisibleIds(access, access_data)
+					.then((project_ids) => {
+						let query = projectModel
+							.query()
+							.where('is_deleted', 0)
+							.andWhere('id', data.id)
+							.allowGraph('[owner,permissions]')
+							.first();
+
+						if (project_ids !== null) {
+							query.whereIn('id', project_ids);
+						}
+
+						if (typeof data.expand !== 'undefined' && data.expand !== null) {
+							query.withGraphFetched('[' + data.expand.join(', ') + ']');
+						}
+
+						return query.then(utils.omitRow(omissions()));
+					});
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Array}   [expand]
+	 * @param   {String}  [search_query]
+	 * @returns {Promise}
+	 */
+	getAll: (access, expand, search_query) => {
+		return access.can('projects:list')
+			.then((access_data) => {
+				return internalProject.getVisibleIds(access, access_data);
+			})
+			.then((project_ids) => {
+				let query = projectModel
+					.query()
+					.where('is_deleted', 0)
+					.allowGraph('[owner,permissions]')
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (project_ids !== null) {
+					query.whereIn('id', project_ids);
+				}
+
+				// Query is used for searching
+				if (typeof search_query === 'string' && search_query.length > 0) {
+					query.where(function () {
+						this.where('name', 'like', '%' + search_query + '%');
+					});
+				}
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * Replaces the users granted access to a project
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   data.user_ids
+	 * @returns {Promise}
+	 */
+	setPermissions: (access, data) => {
+		return access.can('projects:permissions', data.id)
+			.then(() => {
+				return internalProject.get(access, {id: data.id});
+			})
+			.then((row) => {
+				const user_ids = _.uniq(data.user_ids);
+
+				return userModel
+					.query()
+					.select('id')
+					.where('is_deleted', 0)
+					.whereIn('id', user_ids.length ? user_ids : [0])
+					.then((users) => {
+						if (users.length !== user_ids.length) {
+							throw new error.ValidationError('One or more of the users do not exist');
+						}
+
+						return projectPermissionModel
+							.query()
+							.delete()
+							.where('project_id', row.id);
+					})
+					.then(() => {
+						return Promise.all(user_ids.map((user_id) => {
+							return projectPermissionModel
+								.query()
+								.insert({
+									project_id: row.id,
+									user_id:    user_id
+								});
+						}));
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'updated',
+							object_type: 'project',
+							object_id:   row.id,
+							meta:        {
+								name:     row.name,
+								user_ids: user_ids
+							}
+						});
+					})
+					.then(() => {
+						return internalProject.get(access, {id: row.id, expand: ['permissions']});
+					});
+			});
+	},
+
+	/**
+	 * The projects a user can see, null when they can see everything
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  access_data
+	 * @returns {Promise}
+	 */
+	getVisibleIds: (access, access_data) => {
+		if (access_data.permission_visibility === 'all' || access_data.roles.indexOf('admin') !== -1) {
+			if (!access_data.tenant_id) {
+				return Promise.resolve(null);
+			}
+
+			// Everything in their tenant
+			return projectModel
+				.query()
+				.select('id')
+				.where('is_deleted', 0)
+				.whereIn('owner_user_id', internalTenant.getUserIdsQuery(access_data.tenant_id))
+				.then((rows) => {
+					return rows.map((row) => row.id);
+				});
+		}
+
+		const user_id = access.token.getUserId(1);
+
+		return Promise.all([
+			projectModel
+				.query()
+				.select('id')
+				.where('is_deleted', 0)
+				.andWhere('owner_user_id', user_id),
+			projectPermissionModel
+				.query()
+				.select('project_id')
+				.where('user_id', user_id)
+		])
+			.then(([owned, granted]) => {
+				return _.uniq(owned.map((row) => row.id).concat(granted.map((row) => row.project_id)));
+			});
+	},
+
+	/**
+	 * A where clause limiting a resource query to what the user can see: their own items,
+	 * and items in the projects they've been granted, all within their tenant.
+	 * Resolves with null when they can see everything.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  access_data
+	 * @param   {String}  [table]  to qualify the columns with, for queries with joins
+	 * @returns {Promise}
+	 */
+	getVisibilityFilter: (access, access_data, table) => {
+		const tenant = internalTenant.getFilter(access_data, table);
+
+		if (access_data.permission_visibility === 'all' || (tenant && access_data.roles.indexOf('admin') !== -1)) {
+			return Promise.resolve(tenant);
+		}
+
+		const user_id = access.token.getUserId(1);
+		const prefix  = table ? table + '.' : '';
+
+		return internalProject.getVisibleIds(access, access_data)
+			.then((project_ids) => {
+				return function () {
+					this.where(function () {
+						this.where(prefix + 'owner_user_id', user_id);
+						if (project_ids && project_ids.length) {
+							this.orWhereIn(prefix + 'project_id', project_ids);
+						}
+					});
+					if (tenant) {
+						this.andWhere(tenant);
+					}
+				};
+			});
+	}
+};
+
+module.exports = internalProject;
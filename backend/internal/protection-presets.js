@@ -0,0 +1,75 @@
+const settingModel = require('../models/setting');
+
+/**
+ * Paths scanners probe for, answered with a 404 before they reach the forward host.
+ * The keys are what the setting and hosts turn them on with.
+ */
+const PRESETS = {
+	'dotfiles': {
+		name:  'Hidden files',
+		// .well-known is needed for certificates among other things, and .npm- are internal locations
+		regex: '/\\.(?!well-known/|npm-)'
+	},
+	'admin-paths': {
+		name:  'Admin paths',
+		regex: '^/(wp-admin|wp-login\\.php|xmlrpc\\.php|phpmyadmin|pma|administrator|admin\\.php)(/|$)'
+	},
+	'backup-files': {
+		name:  'Backup files',
+		regex: '(\\.(sql|sql\\.gz|bak|old|orig|save|swp)|~)$'
+	},
+	'server-info': {
+		name:  'Server info pages',
+		regex: '^/(server-status|server-info|phpinfo\\.php|info\\.php)(/|$)'
+	}
+};
+
+const internalProtectionPresets = {
+
+	PRESETS: PRESETS,
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'protection-presets')
+			.first();
+	},
+
+	/**
+	 * What to write into the config of a proxy host. The presets of the host win over the
+	 * ones of the setting, an empty list turning them all off for it.
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  host
+	 * @returns {Array}   ie: [{name: 'Hidden files', regex: '/\\.(?!well-known/)'}]
+	 */
+	getOptions: (setting, host) => {
+		let keys = [];
+
+		if (Array.isArray(host.protection_presets)) {
+			keys = host.protection_presets;
+		} else if (setting && setting.value === 'on') {
+			keys = (setting.meta && setting.meta.presets) || [];
+		}
+
+		return keys.filter((key) => typeof PRESETS[key] !== 'undefined').map((key) => {
+			return PRESETS[key];
+		});
+	},
+
+	/**
+	 * Writes the config for every proxy host without its own presets again, after the setting has changed
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return require('./host').regenerateConfigs((host, host_type) => {
+			return host_type === 'proxy_host' && !Array.isArray(host.protection_presets);
+		});
+	}
+};
+
+module.exports = internalProtectionPresets;
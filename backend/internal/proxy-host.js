@@ -1,12 +1,46 @@
-const _                   = require('lodash');
-const error               = require('../lib/error');
-const utils               = require('../lib/utils');
-const proxyHostModel      = require('../models/proxy_host');
-const internalHost        = require('./host');
-const internalNginx       = require('./nginx');
-const internalAuditLog    = require('./audit-log');
-const internalCertificate = require('./certificate');
-const {castJsonIfNeed}    = require('../lib/helpers');
+const _                     = require('lodash');
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const readCache             = require('../lib/read-cache');
+const proxyHostModel        = require('../models/proxy_host');
+const internalHost          = require('./host');
+const internalHostDefaults  = require('./host-defaults');
+const internalAppPresets    = require('./app-presets');
+const internalNginx         = require('./nginx');
+const internalDrain         = require('./drain');
+const internalAuditLog      = require('./audit-log');
+const internalPlugin        = require('./plugin');
+const internalCertificate   = require('./certificate');
+const internalCertMatch     = require('./certificate-match');
+const internalAdminHost     = require('./admin-host');
+const internalProject       = require('./project');
+const internalQuota         = require('./quota');
+const internalTag           = require('./tag');
+const internalLock          = require('./lock');
+const internalProxyProtocol = require('./proxy-protocol');
+const internalListen        = require('./listen');
+const internalRedirectRules = require('./redirect-rules');
+const internalUpstreamTls   = require('./upstream-tls');
+const internalUpstreamAuth  = require('./upstream-auth');
+const internalCorsPolicy    = require('./cors-policy');
+const internalHotlink       = require('./hotlink');
+const internalUploads       = require('./uploads');
+const internalHostPorts     = require('./host-ports');
+const internalCanonicalHost = require('./canonical-host');
+const internalTrafficSplit  = require('./traffic-split');
+const internalUpstreamSets  = require('./upstream-sets');
+const internalLoadBalancing = require('./load-balancing');
+const internalFallback      = require('./fallback');
+const internalExemptions    = require('./access-exemptions');
+const internalServedFiles   = require('./served-files');
+const internalUnixSocket    = require('./unix-socket');
+const internalDocker        = require('./docker');
+const internalSshTunnel     = require('./ssh-tunnel');
+const internalView          = require('./view');
+const {castJsonIfNeed}      = require('../lib/helpers');
+
+// What lists are read from, a cached list is dropped when one of them is written to
+const LIST_TABLES = ['proxy_host', 'user', 'access_list', 'certificate', 'project', 'project_permission', 'user_permission'];
 
 function omissions () {
 	return ['is_deleted', 'owner.is_deleted'];
@@ -20,35 +54,101 @@ const internalProxyHost = {
 	 * @returns {Promise}
 	 */
 	create: (access, data) => {
-		let create_certificate = data.certificate_id === 'new';
-
-		if (create_certificate) {
-			delete data.certificate_id;
-		}
+		let create_certificate = false;
 
 		return access.can('proxy_hosts:create', data)
 			.then(() => {
-				// Get a list of the domain names and check each of them against existing records
-				let domain_name_check_promises = [];
+				return internalQuota.check(access, 'proxy_hosts');
+			})
+			.then(() => {
+				// The preset of the app goes before the defaults of every host
+				return internalAppPresets.apply(data);
+			})
+			.then(() => {
+				return internalHostDefaults.apply('proxy-host', data);
+			})
+			.then(() => {
+				// A certificate_id of auto becomes the certificate that covers the domain names, or new to request one
+				return internalCertMatch.apply(access, data, internalHost.normaliseDomainNames(data.domain_names));
+			})
+			.then(() => {
+				create_certificate = data.certificate_id === 'new';
 
-				data.domain_names.map(function (domain_name) {
-					domain_name_check_promises.push(internalHost.isHostnameTaken(domain_name));
-				});
+				if (create_certificate) {
+					delete data.certificate_id;
+				}
 
-				return Promise.all(domain_name_check_promises)
-					.then((check_results) => {
-						check_results.map(function (result) {
-							if (result.is_taken) {
-								throw new error.ValidationError(result.hostname + ' is already in use');
-							}
-						});
+				// Check each of the domain names against the existing records
+				data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+				return internalHost.assertDomainNamesAvailable(data.domain_names);
+			})
+			.then(() => {
+				return internalUpstreamTls.validate(data.upstream_tls);
+			})
+			.then(() => {
+				return internalUpstreamAuth.validate(data.upstream_auth)
+					.then(() => {
+						return internalUpstreamAuth.prepare(data);
 					});
 			})
+			.then(() => {
+				return internalCorsPolicy.validate(data);
+			})
+			.then(() => {
+				return internalHotlink.validate(data);
+			})
+			.then(() => {
+				return internalUploads.validate(data);
+			})
+			.then(() => {
+				return internalTrafficSplit.validate(data.traffic_split);
+			})
+			.then(() => {
+				return internalLoadBalancing.validate(data.load_balancing);
+			})
+			.then(() => {
+				return internalFallback.validate(data);
+			})
+			.then(() => {
+				return internalExemptions.validate(data);
+			})
+			.then(() => {
+				return internalServedFiles.validate(data);
+			})
+			.then(() => {
+				return internalUnixSocket.validate(data);
+			})
+			.then(() => {
+				return internalDocker.validate(data);
+			})
+			.then(() => {
+				return internalSshTunnel.validate(data);
+			})
+			.then(() => {
+				return internalHostPorts.validate(data, null, create_certificate);
+			})
+			.then(() => {
+				return internalCanonicalHost.validate(data);
+			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalRedirectRules.validate(data);
+			})
+			.then(() => {
+				return internalProxyProtocol.prepareCreate('proxy-host', data);
+			})
+			.then(() => {
+				return internalPlugin.check('host.saving', {object_type: 'proxy-host', action: 'created', host: internalPlugin.getHost(data)});
+			})
 			.then(() => {
 				// At this point the domains should have been checked
 				data.owner_user_id = access.token.getUserId(1);
 				data               = internalHost.cleanSslHstsData(data);
 
+				internalUpstreamSets.prepare(data);
+
 				// Fix for db field not having a default value
 				// for this optional field.
 				if (typeof data.advanced_config === 'undefined') {
@@ -85,8 +185,16 @@ const internalProxyHost = {
 				});
 			})
 			.then((row) => {
+				internalUpstreamTls.writeCaBundle(row);
+				internalFallback.writePage(row);
+				internalServedFiles.writeFiles(row);
+
 				// Configure nginx
 				return internalNginx.configure(proxyHostModel, 'proxy_host', row)
+					.then(() => {
+						// The tunnel it goes through is started now, rather than by the timer
+						return row.ssh_tunnel_id > 0 ? internalSshTunnel.sync() : null;
+					})
 					.then(() => {
 						return row;
 					});
@@ -100,11 +208,15 @@ const internalProxyHost = {
 					action:      'created',
 					object_type: 'proxy-host',
 					object_id:   row.id,
-					meta:        data
+					meta:        internalUpstreamAuth.mask(_.clone(data))
 				})
 					.then(() => {
 						return row;
 					});
+			})
+			.then((row) => {
+				internalPlugin.notify('host.saved', {object_type: 'proxy-host', action: 'created', host: internalPlugin.getHost(row)});
+				return row;
 			});
 	},
 
@@ -123,33 +235,69 @@ const internalProxyHost = {
 
 		return access.can('proxy_hosts:update', data.id)
 			.then((/*access_data*/) => {
-				// Get a list of the domain names and check each of them against existing records
-				let domain_name_check_promises = [];
-
+				// Check each of the domain names against the existing records
 				if (typeof data.domain_names !== 'undefined') {
-					data.domain_names.map(function (domain_name) {
-						domain_name_check_promises.push(internalHost.isHostnameTaken(domain_name, 'proxy', data.id));
-					});
-
-					return Promise.all(domain_name_check_promises)
-						.then((check_results) => {
-							check_results.map(function (result) {
-								if (result.is_taken) {
-									throw new error.ValidationError(result.hostname + ' is already in use');
-								}
-							});
-						});
+					data.domain_names = internalHost.normaliseDomainNames(data.domain_names);
+					return internalHost.assertDomainNamesAvailable(data.domain_names, 'proxy', data.id);
 				}
 			})
+			.then(() => {
+				return internalUpstreamTls.validate(data.upstream_tls);
+			})
+			.then(() => {
+				return internalUpstreamAuth.validate(data.upstream_auth)
+					.then(() => {
+						return internalUpstreamAuth.prepare(data, data.id);
+					});
+			})
+			.then(() => {
+				return internalCorsPolicy.validate(data);
+			})
+			.then(() => {
+				return internalHotlink.validate(data);
+			})
+			.then(() => {
+				return internalUploads.validate(data);
+			})
+			.then(() => {
+				return internalTrafficSplit.validate(data.traffic_split);
+			})
+			.then(() => {
+				return internalLoadBalancing.validate(data.load_balancing);
+			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalRedirectRules.validate(data);
+			})
 			.then(() => {
 				return internalProxyHost.get(access, {id: data.id});
 			})
+			.then((row) => {
+				return internalCertMatch.apply(access, data, data.domain_names || row.domain_names, row.meta)
+					.then(() => {
+						if (data.certificate_id === 'new') {
+							create_certificate = true;
+							delete data.certificate_id;
+						}
+						return row;
+					});
+			})
 			.then((row) => {
 				if (row.id !== data.id) {
 					// Sanity check that something crazy hasn't happened
 					throw new error.InternalValidationError('Proxy Host could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'updated');
+
+				return internalAdminHost.assertUpdateAllowed(row, data)
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
 				if (create_certificate) {
 					return internalCertificate.createQuickCertificate(access, {
 						domain_names: data.domain_names || row.domain_names,
@@ -166,6 +314,45 @@ const internalProxyHost = {
 					return row;
 				}
 			})
+			.then((row) => {
+				return internalHostPorts.validate(data, row, create_certificate)
+					.then(() => {
+						return internalCanonicalHost.validate(data, row);
+					})
+					.then(() => {
+						return internalFallback.validate(data, row);
+					})
+					.then(() => {
+						return internalExemptions.validate(data, row);
+					})
+					.then(() => {
+						return internalServedFiles.validate(data, row);
+					})
+					.then(() => {
+						return internalUnixSocket.validate(data, row);
+					})
+					.then(() => {
+						return internalDocker.validate(data);
+					})
+					.then(() => {
+						return internalSshTunnel.validate(_.assign({}, row, data));
+					})
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				return internalProxyProtocol.prepareUpdate('proxy-host', row, data)
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				return internalPlugin.check('host.saving', {object_type: 'proxy-host', action: 'updated', host: internalPlugin.getHost(_.assign({}, row, data))})
+					.then(() => {
+						return row;
+					});
+			})
 			.then((row) => {
 				// Add domain_names to the data in case it isn't there, so that the audit log renders correctly. The order is important here.
 				data = _.assign({}, {
@@ -173,6 +360,7 @@ const internalProxyHost = {
 				}, data);
 
 				data = internalHost.cleanSslHstsData(data, row);
+				internalUpstreamSets.prepare(data, row);
 
 				return proxyHostModel
 					.query()
@@ -185,7 +373,7 @@ const internalProxyHost = {
 							action:      'updated',
 							object_type: 'proxy-host',
 							object_id:   row.id,
-							meta:        data
+							meta:        internalUpstreamAuth.mask(_.clone(data))
 						})
 							.then(() => {
 								return saved_row;
@@ -198,6 +386,10 @@ const internalProxyHost = {
 					expand: ['owner', 'certificate', 'access_list.[clients,items]']
 				})
 					.then((row) => {
+						internalUpstreamTls.writeCaBundle(row);
+						internalFallback.writePage(row);
+						internalServedFiles.writeFiles(row);
+
 						if (!row.enabled) {
 							// No need to add nginx config if host is disabled
 							return row;
@@ -210,6 +402,63 @@ const internalProxyHost = {
 								return _.omit(row, omissions());
 							});
 					});
+			})
+			.then((row) => {
+				return internalProxyProtocol.sync(access, 'proxy-host', row, data)
+					.then(() => {
+						// The tunnel it goes through is started or changed now, rather than by the timer
+						return row.ssh_tunnel_id > 0 || typeof data.ssh_tunnel_id !== 'undefined' ? internalSshTunnel.sync() : null;
+					})
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				internalPlugin.notify('host.saved', {object_type: 'proxy-host', action: 'updated', host: internalPlugin.getHost(row)});
+				return row;
+			});
+	},
+
+	/**
+	 * Sets the CA bundle the certificate of the forward host is checked against, from an uploaded file
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Object}  data.files
+	 * @returns {Promise}
+	 */
+	uploadUpstreamCa: (access, data) => {
+		if (typeof data.files.ca_bundle === 'undefined') {
+			return Promise.reject(new error.ValidationError('CA bundle file was not provided'));
+		}
+
+		return internalProxyHost.get(access, {id: data.id})
+			.then((row) => {
+				return internalProxyHost.update(access, {
+					id:           data.id,
+					upstream_tls: _.assign({}, row.upstream_tls, {
+						ca_bundle: data.files.ca_bundle.data.toString()
+					})
+				});
+			});
+	},
+
+	/**
+	 * Changes the weight and other options of the traffic split of a host, without sending the rest of it
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	setTrafficSplit: (access, data) => {
+		return internalProxyHost.get(access, {id: data.id})
+			.then((row) => {
+				return internalProxyHost.update(access, {
+					id:            data.id,
+					traffic_split: internalTrafficSplit.merge(row, _.omit(data, ['id']))
+				});
 			});
 	},
 
@@ -228,6 +477,9 @@ const internalProxyHost = {
 
 		return access.can('proxy_hosts:get', data.id)
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = proxyHostModel
 					.query()
 					.where('is_deleted', 0)
@@ -235,8 +487,8 @@ const internalProxyHost = {
 					.allowGraph('[owner,access_list.[clients,items],certificate]')
 					.first();
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
@@ -250,6 +502,7 @@ const internalProxyHost = {
 					throw new error.ItemNotFoundError(data.id);
 				}
 				row = internalHost.cleanRowCertificateMeta(row);
+				row = internalUpstreamAuth.mask(row);
 				// Custom omissions
 				if (typeof data.omit !== 'undefined' && data.omit !== null) {
 					row = _.omit(row, data.omit);
@@ -262,6 +515,7 @@ const internalProxyHost = {
 	 * @param {Access}  access
 	 * @param {Object}  data
 	 * @param {Number}  data.id
+	 * @param {Number}  [data.drain]  seconds to answer with a 503 before the config is removed
 	 * @param {String}  [data.reason]
 	 * @returns {Promise}
 	 */
@@ -275,6 +529,14 @@ const internalProxyHost = {
 					throw new error.ItemNotFoundError(data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'deleted');
+
+				return internalAdminHost.assertNotAdminHost(row.id, 'deleted')
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
 				return proxyHostModel
 					.query()
 					.where('id', row.id)
@@ -282,11 +544,8 @@ const internalProxyHost = {
 						is_deleted: 1
 					})
 					.then(() => {
-						// Delete Nginx Config
-						return internalNginx.deleteConfig('proxy_host', row)
-							.then(() => {
-								return internalNginx.reload();
-							});
+						// Delete Nginx Config, after the drain period when there is one
+						return internalDrain.removeConfig('proxy_host', row, data.drain, 'deleted');
 					})
 					.then(() => {
 						// Add to audit log
@@ -325,14 +584,14 @@ const internalProxyHost = {
 					throw new error.ValidationError('Host is already enabled');
 				}
 
-				row.enabled = 1;
+				internalLock.assertUnlocked(row, 'enabled');
+
+				_.assign(row, {enabled: 1}, internalHost.getEnabledFields());
 
 				return proxyHostModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 1
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
 						// Configure nginx
 						return internalNginx.configure(proxyHostModel, 'proxy_host', row);
@@ -343,7 +602,7 @@ const internalProxyHost = {
 							action:      'enabled',
 							object_type: 'proxy-host',
 							object_id:   row.id,
-							meta:        _.omit(row, omissions())
+							meta:        _.assign(_.omit(row, omissions()), {reason: String(data.reason || '').trim()})
 						});
 					});
 			})
@@ -356,6 +615,7 @@ const internalProxyHost = {
 	 * @param {Access}  access
 	 * @param {Object}  data
 	 * @param {Number}  data.id
+	 * @param {Number}  [data.drain]  seconds to answer with a 503 before the config is removed
 	 * @param {String}  [data.reason]
 	 * @returns {Promise}
 	 */
@@ -371,20 +631,23 @@ const internalProxyHost = {
 					throw new error.ValidationError('Host is already disabled');
 				}
 
-				row.enabled = 0;
+				internalLock.assertUnlocked(row, 'disabled');
+
+				return internalAdminHost.assertNotAdminHost(row.id, 'disabled')
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				_.assign(row, {enabled: 0}, internalHost.getDisabledFields(access, data.reason));
 
 				return proxyHostModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 0
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
-						// Delete Nginx Config
-						return internalNginx.deleteConfig('proxy_host', row)
-							.then(() => {
-								return internalNginx.reload();
-							});
+						// Delete Nginx Config, after the drain period when there is one
+						return internalDrain.removeConfig('proxy_host', row, data.drain, 'disabled');
 					})
 					.then(() => {
 						// Add to audit log
@@ -404,29 +667,51 @@ const internalProxyHost = {
 	/**
 	 * All Hosts
 	 *
-	 * @param   {Access}  access
-	 * @param   {Array}   [expand]
-	 * @param   {String}  [search_query]
+	 * @param   {Access}    access
+	 * @param   {Array}     [expand]
+	 * @param   {String}    [search_query]
+	 * @param   {Object}    [filter]
+	 * @param   {Number}    [filter.project_id]
+	 * @param   {Array}     [filter.tags]
+	 * @param   {Boolean}   [filter.enabled]
+	 * @param   {Number}    [filter.expires_within_days]
+	 * @param   {Object}    [filter.sort]  {field, direction}
+	 * @param   {Function}  [each]  given the rows a batch at a time instead of resolving with them all
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, filter, each) => {
 		return access.can('proxy_hosts:list')
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = proxyHostModel
 					.query()
 					.where('is_deleted', 0)
 					.groupBy('id')
 					.allowGraph('[owner,access_list,certificate]')
-					.orderBy(castJsonIfNeed('domain_names'), 'ASC');
+					.orderBy(castJsonIfNeed('domain_names'), 'ASC')
+					.orderBy('id', 'ASC');
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
+				if (filter && typeof filter.project_id === 'number') {
+					query.andWhere('project_id', filter.project_id);
+				}
+
+				if (filter && filter.tags && filter.tags.length) {
+					internalTag.applyFilter(query, filter.tags);
+				}
+
+				internalView.applyFilter(query, 'proxy-host', filter);
+
 				// Query is used for searching
 				if (typeof search_query === 'string' && search_query.length > 0) {
 					query.where(function () {
 						this.where(castJsonIfNeed('domain_names'), 'like', `%${search_query}%`);
+						this.orWhere('notes', 'like', `%${search_query}%`);
 					});
 				}
 
@@ -434,14 +719,23 @@ const internalProxyHost = {
 					query.withGraphFetched('[' + expand.join(', ') + ']');
 				}
 
-				return query.then(utils.omitRows(omissions()));
-			})
-			.then((rows) => {
-				if (typeof expand !== 'undefined' && expand !== null && expand.indexOf('certificate') !== -1) {
-					return internalHost.cleanAllRowsCertificateMeta(rows);
+				const clean = (rows) => {
+					rows = utils.omitRows(omissions())(rows).map(internalUpstreamAuth.mask);
+
+					if (typeof expand !== 'undefined' && expand !== null && expand.indexOf('certificate') !== -1) {
+						return internalHost.cleanAllRowsCertificateMeta(rows);
+					}
+
+					return rows;
+				};
+
+				if (each) {
+					return utils.eachBatch(query, (rows) => each(clean(rows)));
 				}
 
-				return rows;
+				return readCache.get(JSON.stringify(['proxy-host', access.token.getUserId(0), expand, search_query, filter]), LIST_TABLES, () => {
+					return query.then(clean);
+				});
 			});
 	},
 
@@ -462,10 +756,12 @@ const internalProxyHost = {
 			query.andWhere('owner_user_id', user_id);
 		}
 
-		return query.first()
-			.then((row) => {
-				return parseInt(row.count, 10);
-			});
+		return readCache.get(JSON.stringify(['proxy-host-count', user_id, visibility]), ['proxy_host'], () => {
+			return query.first()
+				.then((row) => {
+					return parseInt(row.count, 10);
+				});
+		});
 	}
 };
 
@@ -0,0 +1,169 @@
+const _                    = require('lodash');
+const error                = require('../lib/error');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const internalAuditLog     = require('./audit-log');
+
+/**
+ * The host types that listen on ports 80 and 443
+ */
+const HOST_TYPES = {
+	'proxy-host':       {host_type: 'proxy_host', model: proxyHostModel},
+	'redirection-host': {host_type: 'redirection_host', model: redirectionHostModel},
+	'dead-host':        {host_type: 'dead_host', model: deadHostModel}
+};
+
+/**
+ * nginx turns on PROXY protocol for a port when any server listening on it asks for it,
+ * so every host on ports 80 and 443 has to agree. Changing it on one host changes it on all of them.
+ */
+const internalProxyProtocol = {
+
+	/**
+	 * Every host listening on ports 80 and 443, except the one given
+	 *
+	 * @param   {String}  [object_type]
+	 * @param   {Number}  [id]
+	 * @returns {Promise}
+	 */
+	getOtherHosts: (object_type, id) => {
+		return Promise.all(_.map(HOST_TYPES, (type, type_name) => {
+			let query = type.model
+				.query()
+				.where('is_deleted', 0);
+
+			if (type_name === object_type) {
+				query.andWhere('id', '!=', id);
+			}
+
+			return query.then((rows) => {
+				return rows.map((row) => {
+					return {object_type: type_name, row: row};
+				});
+			});
+		}))
+			.then(_.flatten);
+	},
+
+	/**
+	 * Gives a new host the same setting as the others, and rejects one that asks for something else
+	 *
+	 * @param   {String}  object_type
+	 * @param   {Object}  data         create payload
+	 * @returns {Promise}
+	 */
+	prepareCreate: (object_type, data) => {
+		return internalProxyProtocol.getOtherHosts()
+			.then((others) => {
+				if (!others.length) {
+					data.accept_proxy_protocol = !!data.accept_proxy_protocol;
+					return;
+				}
+
+				const current = others[0].row.accept_proxy_protocol;
+				if (typeof data.accept_proxy_protocol === 'undefined') {
+					data.accept_proxy_protocol = current;
+				} else if (data.accept_proxy_protocol !== current) {
+					throw new error.ValidationError('PROXY protocol is turned ' + (current ? 'on' : 'off') + ' for every host on ports 80 and 443. Change it on an existing host to change it for all of them.');
+				}
+			});
+	},
+
+	/**
+	 * Makes sure the other hosts can be changed along with this one
+	 *
+	 * @param   {String}  object_type
+	 * @param   {Object}  row          existing host
+	 * @param   {Object}  data         update payload
+	 * @returns {Promise}
+	 */
+	prepareUpdate: (object_type, row, data) => {
+		if (typeof data.accept_proxy_protocol === 'undefined' || data.accept_proxy_protocol === row.accept_proxy_protocol) {
+			return Promise.resolve();
+		}
+
+		return internalProxyProtocol.getOtherHosts(object_type, row.id)
+			.then((others) => {
+				const locked = others.filter((item) => item.row.locked);
+				if (locked.length) {
+					throw new error.ValidationError('PROXY protocol applies to every host on ports 80 and 443, and ' + locked.length + ' other host(s) are locked. Unlock them first.');
+				}
+			});
+	},
+
+	/**
+	 * Applies the setting of a host that's just been updated to every other host
+	 *
+	 * @param   {Access}   access
+	 * @param   {String}   object_type
+	 * @param   {Object}   row          the updated host
+	 * @param   {Object}   data         update payload
+	 * @returns {Promise}
+	 */
+	sync: (access, object_type, row, data) => {
+		if (typeof data.accept_proxy_protocol === 'undefined') {
+			return Promise.resolve();
+		}
+
+		const accept = !!row.accept_proxy_protocol;
+
+		return internalProxyProtocol.getOtherHosts(object_type, row.id)
+			.then((others) => {
+				const changed = others.filter((item) => item.row.accept_proxy_protocol !== accept);
+				if (!changed.length) {
+					return;
+				}
+
+				let sequence = Promise.resolve();
+
+				changed.forEach((item) => {
+					sequence = sequence
+						.then(() => {
+							return HOST_TYPES[item.object_type].model
+								.query()
+								.where('id', item.row.id)
+								.patch({accept_proxy_protocol: accept});
+						})
+						.then(() => {
+							// Add to audit log
+							return internalAuditLog.add(access, {
+								action:      'updated',
+								object_type: item.object_type,
+								object_id:   item.row.id,
+								meta:        {
+									accept_proxy_protocol: accept
+								}
+							});
+						});
+				});
+
+				return sequence.then(() => {
+					const ids = changed.map((item) => item.object_type + ':' + item.row.id);
+
+					return require('./host').regenerateConfigs((host, host_type) => {
+						return ids.indexOf(_.findKey(HOST_TYPES, {host_type: host_type}) + ':' + host.id) !== -1;
+					});
+				});
+			});
+	},
+
+	/**
+	 * Streams have a port each, so they only have to make sense on their own
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing stream
+	 * @returns {Promise}
+	 */
+	validateStream: (data, row) => {
+		const stream = _.assign({}, row || {}, data);
+
+		if ((stream.accept_proxy_protocol || stream.send_proxy_protocol) && !stream.tcp_forwarding) {
+			return Promise.reject(new error.ValidationError('PROXY protocol only works with TCP forwarding'));
+		}
+
+		return Promise.resolve();
+	}
+};
+
+module.exports = internalProxyProtocol;
@@ -0,0 +1,105 @@
+const error          = require('../lib/error');
+const settingModel   = require('../models/setting');
+const userModel      = require('../models/user');
+const internalTenant = require('./tenant');
+
+const internalQuota = {
+
+	/**
+	 * Refuses to create one more of a resource once the user, or their tenant, has its quota of them
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  type    ie: 'proxy_hosts', see internalTenant.resources
+	 * @returns {Promise}
+	 */
+	check: (access, type) => {
+		return internalTenant.checkQuota(access, type)
+			.then(() => {
+				return internalQuota.checkUser(access, type);
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {String}  type
+	 * @returns {Promise}
+	 */
+	checkUser: (access, type) => {
+		const user_id = access.token.getUserId(0);
+		if (!user_id || type === 'users') {
+			// Internal access, or not something users own
+			return Promise.resolve();
+		}
+
+		return internalQuota.getLimit(access, type)
+			.then((limit) => {
+				if (limit === null) {
+					return;
+				}
+
+				return internalQuota.getCount(user_id, type)
+					.then((count) => {
+						if (count >= limit) {
+							const label = internalTenant.resources[type].label;
+							throw new error.ValidationError('You have reached your quota of ' + limit + ' ' + label + ', delete one of them or ask an administrator to raise it');
+						}
+					});
+			});
+	},
+
+	/**
+	 * The quota of the user when they have their own, otherwise the one of their role
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  type
+	 * @returns {Promise}  resolves with null when there's no limit
+	 */
+	getLimit: (access, type) => {
+		return settingModel
+			.query()
+			.where('id', 'quotas')
+			.first()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return null;
+				}
+
+				return userModel
+					.query()
+					.where('id', access.token.getUserId(0))
+					.allowGraph('[permissions]')
+					.withGraphFetched('[permissions]')
+					.first()
+					.then((user) => {
+						const permissions = user && user.permissions;
+						if (permissions && permissions.quotas && typeof permissions.quotas[type] === 'number') {
+							return permissions.quotas[type];
+						}
+
+						const role   = user && user.roles.indexOf('admin') !== -1 ? 'admin' : 'user';
+						const quotas = (setting.meta || {})[role] || {};
+						return typeof quotas[type] === 'number' ? quotas[type] : null;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Number}  user_id
+	 * @param   {String}  type
+	 * @returns {Promise}
+	 */
+	getCount: (user_id, type) => {
+		return settingModel
+			.knex()
+			.table(internalTenant.resources[type].table)
+			.count('id as count')
+			.where('is_deleted', 0)
+			.andWhere('owner_user_id', user_id)
+			.first()
+			.then((row) => {
+				return parseInt(row.count, 10);
+			});
+	}
+};
+
+module.exports = internalQuota;
@@ -0,0 +1,134 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+// Checked against every rule so certificates can still be renewed
+const ACME_CHALLENGE_PATH = '/.well-known/acme-challenge/npm-check';
+
+// Characters that would end the nginx directive or string the rule is written into
+const UNSAFE = /[\s"';{}\\$]/;
+
+const MATCH_ORDER = ['exact', 'prefix', 'regex'];
+
+const internalRedirectRules = {
+
+	/**
+	 * @param   {String}  value
+	 * @returns {String}
+	 */
+	escapeRegex: (value) => {
+		return value.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+	},
+
+	/**
+	 * @param   {Object}  rule
+	 * @returns {RegExp}
+	 */
+	toRegExp: (rule) => {
+		switch (rule.match) {
+		case 'exact':
+			return new RegExp('^' + internalRedirectRules.escapeRegex(rule.source) + '$');
+		case 'prefix':
+			return new RegExp('^' + internalRedirectRules.escapeRegex(rule.source) + '(.*)$');
+		}
+		return new RegExp(rule.source);
+	},
+
+	/**
+	 * Checks the rules and puts them in the order nginx goes through them: exact paths first, then
+	 * prefixes from the longest, then regular expressions in the order they were given
+	 *
+	 * @param   {Object}  data  payload, with its redirect_rules sorted in place
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		if (!data.redirect_rules || !data.redirect_rules.length) {
+			return Promise.resolve();
+		}
+
+		let seen = [];
+
+		for (let i = 0; i < data.redirect_rules.length; i++) {
+			const rule   = data.redirect_rules[i];
+			const prefix = 'Redirect rule ' + (i + 1) + ': ';
+			let regexp   = null;
+
+			if (rule.match === 'regex' ? /["\s]/.test(rule.source) : UNSAFE.test(rule.source)) {
+				return Promise.reject(new error.ValidationError(prefix + (rule.match === 'regex'
+					? 'the regular expression can\'t have spaces or double quotes, use \\s and \\x22 instead'
+					: 'the path can\'t have spaces, quotes, semicolons, braces, backslashes or $')));
+			}
+
+			// Only the groups of a regular expression can be used in the target
+			if (UNSAFE.test(rule.target.replace(/\$[1-9]/g, '')) || (rule.match !== 'regex' && rule.target.indexOf('$') !== -1)) {
+				return Promise.reject(new error.ValidationError(prefix + 'the target can\'t have spaces, quotes, semicolons, braces or backslashes, and only $1 to $9 from a regular expression'));
+			}
+
+			try {
+				regexp = internalRedirectRules.toRegExp(rule);
+			} catch (err) {
+				return Promise.reject(new error.ValidationError(prefix + 'the regular expression is invalid: ' + err.message));
+			}
+
+			if (regexp.test(ACME_CHALLENGE_PATH)) {
+				return Promise.reject(new error.ValidationError(prefix + 'it can\'t redirect /.well-known/acme-challenge/, which is needed to renew certificates'));
+			}
+
+			const key = rule.match + ' ' + rule.source;
+			if (seen.indexOf(key) !== -1) {
+				return Promise.reject(new error.ValidationError(prefix + 'there\'s already a rule for ' + key));
+			}
+			seen.push(key);
+		}
+
+		// Stable, so regular expressions keep their order
+		data.redirect_rules = _.sortBy(data.redirect_rules.map((rule, index) => {
+			return {rule: rule, index: index};
+		}), [
+			(item) => MATCH_ORDER.indexOf(item.rule.match),
+			(item) => item.rule.match === 'prefix' ? -item.rule.source.length : 0,
+			'index'
+		]).map((item) => {
+			return item.rule;
+		});
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What to write into the config of a host
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}   ie: [{condition: '$uri = "/old"', status_code: 301, target: '/new$is_args$args'}]
+	 */
+	getOptions: (host) => {
+		return (host.redirect_rules || []).map((rule) => {
+			let condition = null;
+			let target    = rule.target;
+
+			switch (rule.match) {
+			case 'exact':
+				condition = '$uri = "' + rule.source + '"';
+				break;
+			case 'prefix':
+				// The rest of the path goes on the end of the target
+				condition = '$uri ~ "^' + internalRedirectRules.escapeRegex(rule.source) + '(.*)$"';
+				target    = target + '$1';
+				break;
+			default:
+				condition = '$uri ~ "' + rule.source + '"';
+			}
+
+			if (rule.preserve_query) {
+				target = target + '$is_args$args';
+			}
+
+			return {
+				condition:   condition,
+				status_code: rule.status_code || 301,
+				target:      target
+			};
+		});
+	}
+};
+
+module.exports = internalRedirectRules;
@@ -0,0 +1,144 @@
+const _                = require('lodash');
+const moment           = require('moment');
+const logger           = require('../logger').ssl;
+const settingModel     = require('../models/setting');
+const certificateModel = require('../models/certificate');
+const tokenModel       = require('../models/token');
+const internalAuditLog = require('./audit-log');
+const internalActivity = require('./activity');
+
+// What the setting falls back to, the same as the default setting
+const DEFAULTS = {
+	max_attempts: 5,
+	backoff:      [1, 6, 24],
+	on_give_up:   'stop'
+};
+
+const internalRenewalRetry = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'renewal-retry')
+			.first();
+	},
+
+	/**
+	 * The policy of the certificate, with the setting for what it doesn't set
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  certificate
+	 * @returns {Object}  the policy, or null when failed renewals are retried on every run
+	 */
+	getPolicy: (setting, certificate) => {
+		const enabled = !setting || setting.value === 'on';
+
+		if (!enabled && !certificate.renewal_retry) {
+			return null;
+		}
+
+		return _.assign({}, DEFAULTS, enabled && setting ? setting.meta : {}, certificate.renewal_retry || {});
+	},
+
+	/**
+	 * @param   {Object}  policy
+	 * @param   {Object}  certificate
+	 * @returns {Boolean} whether the renewal timer should try to renew the certificate now
+	 */
+	isDue: (policy, certificate) => {
+		const status = certificate.renewal_status;
+
+		if (!policy || !status) {
+			return true;
+		}
+
+		if (status.gave_up_on && !status.retry_on) {
+			return false;
+		}
+
+		return !status.retry_on || moment().isSameOrAfter(moment(status.retry_on));
+	},
+
+	/**
+	 * Counts a failed renewal and works out when to try again. Gives up once it's failed too many times.
+	 *
+	 * @param   {Object}  policy
+	 * @param   {Object}  certificate
+	 * @param   {Error}   err
+	 * @returns {Promise}
+	 */
+	recordFailure: (policy, certificate, err) => {
+		if (!policy) {
+			return Promise.resolve();
+		}
+
+		const status   = certificate.renewal_status || {};
+		const attempts = (status.attempts || 0) + 1;
+		const gave_up  = attempts >= policy.max_attempts;
+		const hours    = policy.backoff[Math.min(attempts, policy.backoff.length) - 1];
+
+		const renewal_status = {
+			attempts:        attempts,
+			last_attempt_on: moment().toISOString(),
+			last_error:      err.message,
+			retry_on:        gave_up && policy.on_give_up === 'stop' ? null : moment().add(hours, 'hours').toISOString(),
+			gave_up_on:      status.gave_up_on || (gave_up ? moment().toISOString() : null)
+		};
+
+		return certificateModel
+			.query()
+			.patch({renewal_status: renewal_status})
+			.where('id', certificate.id)
+			.then(() => {
+				if (renewal_status.gave_up_on && !status.gave_up_on) {
+					return internalRenewalRetry.alert(certificate, renewal_status);
+				}
+
+				if (renewal_status.retry_on) {
+					logger.warn('Retrying renewal of Cert #' + certificate.id + ' at ' + renewal_status.retry_on + ', attempt ' + attempts + ' failed');
+				}
+			});
+	},
+
+	/**
+	 * Forgets the failed renewals, after one that worked or when the policy changes
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Promise}
+	 */
+	reset: (certificate) => {
+		if (!certificate.renewal_status) {
+			return Promise.resolve();
+		}
+
+		return certificateModel
+			.query()
+			.patch({renewal_status: null})
+			.where('id', certificate.id);
+	},
+
+	/**
+	 * @param   {Object}  certificate
+	 * @param   {Object}  renewal_status
+	 * @returns {Promise}
+	 */
+	alert: (certificate, renewal_status) => {
+		const message = 'Gave up renewing Cert #' + certificate.id + ' after ' + renewal_status.attempts + ' failed attempts: ' + renewal_status.last_error;
+		logger.error(message);
+
+		return internalActivity.record('certificate', certificate.id, 'renewal', 'gave-up', false, renewal_status.last_error)
+			.then(() => {
+				return internalAuditLog.add({token: new tokenModel()}, {
+					action:      'alerted',
+					object_type: 'certificate',
+					object_id:   certificate.id,
+					meta:        _.assign({nice_name: certificate.nice_name, domain_names: certificate.domain_names}, renewal_status)
+				});
+			});
+	}
+};
+
+module.exports = internalRenewalRetry;
@@ -0,0 +1,433 @@
+const _                     = require('lodash');
+const moment                = require('moment');
+const logger                = require('../logger').schedule;
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const Access                = require('../lib/access');
+const scheduledChangeModel  = require('../models/scheduled_change');
+const settingModel          = require('../models/setting');
+const userModel             = require('../models/user');
+const internalAuditLog      = require('./audit-log');
+const internalChangeRequest = require('./change-request');
+const internalTenant        = require('./tenant');
+const internalToken         = require('./token');
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalScheduledChange = {
+
+	intervalTimeout:    1000 * 60, // 1 minute
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Scheduled Changes Timer initialized');
+		internalScheduledChange.interval = setInterval(internalScheduledChange.processQueue, internalScheduledChange.intervalTimeout);
+	},
+
+	/**
+	 * The maintenance window, or null when there isn't one
+	 *
+	 * @returns {Promise}  resolves with {days, start, end}
+	 */
+	getWindow: () => {
+		return settingModel
+			.query()
+			.where('id', 'maintenance-window')
+			.first()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on') {
+					return null;
+				}
+
+				const meta = setting.meta || {};
+				return {
+					days:  meta.days || [0, 1, 2, 3, 4, 5, 6],
+					start: meta.start || '00:00',
+					end:   meta.end || '00:00'
+				};
+			});
+	},
+
+	/**
+	 * Windows ending at or before they start go past midnight, ie: 22:00 to 02:00 on a Sunday
+	 * ends at 02:00 on Monday. The same start and end is the whole day.
+	 *
+	 * @param   {Object}  window
+	 * @param   {moment}  time
+	 * @returns {Boolean}
+	 */
+	isInWindow: (window, time) => {
+		const minutes = time.hours() * 60 + time.minutes();
+		const start   = internalScheduledChange.toMinutes(window.start);
+		const end     = internalScheduledChange.toMinutes(window.end);
+		const day     = time.day();
+
+		if (start < end) {
+			return window.days.indexOf(day) !== -1 && minutes >= start && minutes < end;
+		}
+
+		const previous_day = (day + 6) % 7;
+		return (window.days.indexOf(day) !== -1 && minutes >= start) || (window.days.indexOf(previous_day) !== -1 && minutes < end);
+	},
+
+	/**
+	 * @param   {Object}  window
+	 * @param   {moment}  from
+	 * @returns {moment}  from itself when it's in the window
+	 */
+	getNextWindow: (window, from) => {
+		if (internalScheduledChange.isInWindow(window, from)) {
+			return from.clone();
+		}
+
+		const start = internalScheduledChange.toMinutes(window.start);
+		for (let offset = 0; offset <= 7; offset++) {
+			const candidate = from.clone().startOf('day').add(offset, 'days').add(start, 'minutes');
+			if (candidate.isAfter(from) && window.days.indexOf(candidate.day()) !== -1) {
+				return candidate;
+			}
+		}
+		return null;
+	},
+
+	/**
+	 * @param   {String}  time  ie: '02:30'
+	 * @returns {Number}
+	 */
+	toMinutes: (time) => {
+		const parts = time.split(':');
+		return parseInt(parts[0], 10) * 60 + parseInt(parts[1], 10);
+	},
+
+	/**
+	 * When a change asked to be made at apply_at will be made
+	 *
+	 * @param   {String}  apply_at  a date and time, or 'window' for the next maintenance window
+	 * @returns {Promise}  resolves with a moment
+	 */
+	getApplyTime: (apply_at) => {
+		return internalScheduledChange.getWindow()
+			.then((window) => {
+				const now = moment();
+
+				if (apply_at === 'window') {
+					if (!window) {
+						throw new error.ValidationError('There\'s no maintenance window to apply the change in, set one with the maintenance-window setting');
+					}
+					return internalScheduledChange.getNextWindow(window, now);
+				}
+
+				const time = moment(apply_at, moment.ISO_8601, true);
+				if (!time.isValid()) {
+					throw new error.ValidationError('apply_at must be a date and time, like 2026-10-17T02:00:00Z, or window');
+				}
+				if (time.isBefore(now.clone().subtract(1, 'minute'))) {
+					throw new error.ValidationError('apply_at is in the past');
+				}
+				if (window && !internalScheduledChange.isInWindow(window, time.clone().local())) {
+					const next = internalScheduledChange.getNextWindow(window, time.clone().local());
+					throw new error.ValidationError('apply_at is outside of the maintenance window, the next one starts at ' + next.format());
+				}
+				return time.local();
+			});
+	},
+
+	/**
+	 * Stages the change to be made at apply_at, when the request asks for it
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  ie: 'proxy-host'
+	 * @param   {String}  action       'create', 'update' or 'delete'
+	 * @param   {Object}  params       of the request, with the id of the object for updates and deletes
+	 * @param   {Object}  body         of the request
+	 * @param   {String}  apply_at
+	 * @returns {Promise}  resolves with the scheduled change
+	 */
+	submit: (access, object_type, action, params, body, apply_at) => {
+		const type = internalChangeRequest.types[object_type];
+		let time   = null;
+
+		return internalScheduledChange.getApplyTime(apply_at)
+			.then((apply_time) => {
+				time = apply_time;
+				return internalChangeRequest.prepare(access, object_type, action, params, body);
+			})
+			.then((change) => {
+				// Checked now so nobody is surprised later, and again when it's made
+				return access.can(type.permission + ':' + action, change.object_id || body)
+					.then(() => {
+						return change;
+					});
+			})
+			.then((change) => {
+				return scheduledChangeModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						object_type:   object_type,
+						object_id:     change.object_id,
+						action:        action,
+						apply_at:      time.format(DATE_FORMAT),
+						status:        'pending',
+						payload:       change.payload,
+						meta:          {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'scheduled-change',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * Cancels a change that hasn't been made yet
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	cancel: (access, data) => {
+		return access.can('scheduled_changes:delete', data.id)
+			.then(() => {
+				return internalScheduledChange.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.status !== 'pending') {
+					throw new error.ValidationError('Scheduled change has already been ' + row.status);
+				}
+
+				return scheduledChangeModel
+					.query()
+					.patchAndFetchById(row.id, {status: 'cancelled'})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'cancelled',
+					object_type: 'scheduled-change',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('scheduled_changes:get', data.id)
+			.then((access_data) => {
+				let query = scheduledChangeModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.allowGraph('[owner]')
+					.first();
+
+				const filter = internalScheduledChange.getVisibilityFilter(access, access_data);
+				if (filter) {
+					query.andWhere(filter);
+				}
+
+				if (typeof data.expand !== 'undefined' && data.expand !== null) {
+					query.withGraphFetched('[' + data.expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * The queue, next to be made first
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  [filters]
+	 * @param   {String}  [filters.status]
+	 * @param   {Array}   [expand]
+	 * @returns {Promise}
+	 */
+	getAll: (access, filters, expand) => {
+		filters = filters || {};
+
+		return access.can('scheduled_changes:list')
+			.then((access_data) => {
+				let query = scheduledChangeModel
+					.query()
+					.where('is_deleted', 0)
+					.allowGraph('[owner]')
+					.orderBy('apply_at', 'ASC')
+					.orderBy('id', 'ASC');
+
+				const filter = internalScheduledChange.getVisibilityFilter(access, access_data);
+				if (filter) {
+					query.andWhere(filter);
+				}
+
+				if (filters.status) {
+					query.andWhere('status', filters.status);
+				}
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * Admins and users who can see everything see every scheduled change in their tenant,
+	 * everyone else only their own
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  access_data
+	 * @returns {Function|null}
+	 */
+	getVisibilityFilter: (access, access_data) => {
+		if (access_data.permission_visibility === 'all' || access_data.roles.indexOf('admin') !== -1) {
+			return internalTenant.getFilter(access_data);
+		}
+
+		const user_id = access.token.getUserId(1);
+		return function () {
+			this.where('owner_user_id', user_id);
+		};
+	},
+
+	/**
+	 * Triggered by a timer, makes the changes that are due, when in the maintenance window if there is one.
+	 * Each is made as the user who scheduled it, with what they're allowed to do by then.
+	 *
+	 * @returns {Promise}
+	 */
+	processQueue: () => {
+		if (internalScheduledChange.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalScheduledChange.intervalProcessing = true;
+
+		return internalScheduledChange.getWindow()
+			.then((window) => {
+				if (window && !internalScheduledChange.isInWindow(window, moment())) {
+					return [];
+				}
+
+				return scheduledChangeModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('status', 'pending')
+					.andWhere('apply_at', '<=', moment().format(DATE_FORMAT))
+					.orderBy('apply_at', 'ASC')
+					.orderBy('id', 'ASC');
+			})
+			.then((rows) => {
+				// One after the other, in the order they were scheduled
+				return rows.reduce((promise, row) => {
+					return promise.then(() => {
+						return internalScheduledChange.applyChange(row);
+					});
+				}, Promise.resolve());
+			})
+			.then(() => {
+				internalScheduledChange.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalScheduledChange.intervalProcessing = false;
+				return false;
+			});
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {Promise}
+	 */
+	applyChange: (row) => {
+		return userModel
+			.query()
+			.where('id', row.owner_user_id)
+			.andWhere('is_deleted', 0)
+			.andWhere('is_disabled', 0)
+			.first()
+			.then((user) => {
+				if (!user) {
+					throw new error.ValidationError('The user who scheduled it no longer exists or is disabled');
+				}
+				return internalToken.getTokenFromUser(user);
+			})
+			.then((token) => {
+				return internalChangeRequest.apply(new Access(token.token), row);
+			})
+			.then((result) => {
+				logger.success('Applied scheduled change #' + row.id + ': ' + row.action + ' ' + row.object_type + (row.object_id ? ' #' + row.object_id : ''));
+
+				let meta = _.assign({}, row.meta);
+				if (row.action === 'create') {
+					meta.result_id = result.id;
+				}
+				return internalScheduledChange.finish(row, 'applied', meta);
+			})
+			.catch((err) => {
+				logger.error('Scheduled change #' + row.id + ' failed: ' + err.message);
+				return internalScheduledChange.finish(row, 'failed', _.assign({}, row.meta, {error: err.message}));
+			});
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @param   {String}  status
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	finish: (row, status, meta) => {
+		return scheduledChangeModel
+			.query()
+			.patchAndFetchById(row.id, {
+				status: status,
+				meta:   meta
+			})
+			.then((saved_row) => {
+				// Add to audit log, as who scheduled it
+				return internalAuditLog.add(null, {
+					user_id:     row.owner_user_id,
+					action:      status,
+					object_type: 'scheduled-change',
+					object_id:   saved_row.id,
+					meta:        _.omit(saved_row, omissions())
+				});
+			});
+	}
+};
+
+module.exports = internalScheduledChange;
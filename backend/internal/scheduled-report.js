@@ -0,0 +1,609 @@
+const _                     = require('lodash');
+const os                    = require('os');
+const moment                = require('moment');
+const logger                = require('../logger').schedule;
+const error                 = require('../lib/error');
+const activityEventModel    = require('../models/activity_event');
+const auditLogModel         = require('../models/audit-log');
+const certificateModel      = require('../models/certificate');
+const deadHostModel         = require('../models/dead_host');
+const domainExpiryModel     = require('../models/domain_expiry');
+const hostAnalyticsModel    = require('../models/host_analytics');
+const hostUsageModel        = require('../models/host_usage');
+const proxyHostModel        = require('../models/proxy_host');
+const redirectionHostModel  = require('../models/redirection_host');
+const reportDeliveryModel   = require('../models/report_delivery');
+const settingModel          = require('../models/setting');
+const streamModel           = require('../models/stream');
+const tokenModel            = require('../models/token');
+const internalNotifications = require('./notifications');
+const internalSecurityAudit = require('./security-audit');
+
+const DATE_FORMAT = 'YYYY-MM-DD HH:mm:ss';
+
+const FREQUENCIES = ['weekly', 'monthly'];
+
+// What a report has, in this order, all of them when a recipient doesn't have a list
+const SECTIONS = ['hosts', 'renewals', 'expirations', 'traffic', 'security'];
+
+// Reports are sent from this hour, local time, when the setting doesn't have one
+const DEFAULT_HOUR = 8;
+
+// Certificates and domains expiring within this many days are in a report
+const EXPIRING_DAYS = 30;
+
+// A report that couldn't be sent is tried this many times, once each run
+const ATTEMPTS = 3;
+
+// The most of each list a report has
+const LIMIT = 10;
+
+const HOST_TYPES = {
+	'proxy-host':       proxyHostModel,
+	'redirection-host': redirectionHostModel,
+	'dead-host':        deadHostModel,
+	'stream':           streamModel
+};
+
+/**
+ * @param   {Object}  host
+ * @param   {String}  object_type
+ * @returns {String}
+ */
+const getHostName = (host, object_type) => {
+	return object_type === 'stream' ? 'port ' + host.incoming_port : host.domain_names.join(', ');
+};
+
+/**
+ * @param   {Number}  bytes
+ * @returns {String}
+ */
+const formatBytes = (bytes) => {
+	const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+
+	let unit = 0;
+	while (bytes >= 1024 && unit < units.length - 1) {
+		bytes /= 1024;
+		unit++;
+	}
+
+	return (unit ? bytes.toFixed(1) : bytes) + ' ' + units[unit];
+};
+
+const internalScheduledReport = {
+
+	FREQUENCIES: FREQUENCIES,
+	SECTIONS:    SECTIONS,
+
+	intervalTimeout:    1000 * 60 * 60, // 1 hour
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Scheduled Reports Timer initialized');
+		internalScheduledReport.interval = setInterval(internalScheduledReport.processReports, internalScheduledReport.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'scheduled-reports')
+			.first();
+	},
+
+	/**
+	 * The week or month before the one it is now, from Monday for weeks
+	 *
+	 * @param   {String}  frequency  weekly or monthly
+	 * @param   {Object}  [now]      moment
+	 * @returns {Object}  {key, from, to}, to being the start of the current one
+	 */
+	getPeriod: (frequency, now) => {
+		const unit = frequency === 'monthly' ? 'month' : 'isoWeek';
+		const to   = (now || moment()).clone().startOf(unit);
+		const from = to.clone().subtract(1, frequency === 'monthly' ? 'month' : 'week');
+
+		return {
+			key:  frequency === 'monthly' ? from.format('YYYY-MM') : from.format('GGGG-[W]WW'),
+			from: from,
+			to:   to
+		};
+	},
+
+	/**
+	 * Hosts created in the period, that are still there
+	 *
+	 * @param   {Object}  period
+	 * @returns {Promise}
+	 */
+	getNewHosts: (period) => {
+		return Promise.all(_.map(HOST_TYPES, (model, object_type) => {
+			return model
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('created_on', '>=', period.from.format(DATE_FORMAT))
+				.andWhere('created_on', '<', period.to.format(DATE_FORMAT))
+				.orderBy('created_on', 'ASC')
+				.then((rows) => {
+					return rows.map((row) => {
+						return {
+							object_type: object_type,
+							object_id:   row.id,
+							name:        getHostName(row, object_type),
+							created_on:  row.created_on
+						};
+					});
+				});
+		}))
+			.then((results) => {
+				const hosts = _.flatten(results);
+
+				return {
+					count: hosts.length,
+					hosts: _.take(hosts, LIMIT)
+				};
+			});
+	},
+
+	/**
+	 * Certificates renewed in the period, and the renewals given up on
+	 *
+	 * @param   {Object}  period
+	 * @returns {Promise}
+	 */
+	getRenewals: (period) => {
+		const from = period.from.format(DATE_FORMAT);
+		const to   = period.to.format(DATE_FORMAT);
+
+		return Promise.all([
+			auditLogModel
+				.query()
+				.where('action', 'renewed')
+				.andWhere('object_type', 'certificate')
+				.andWhere('created_on', '>=', from)
+				.andWhere('created_on', '<', to),
+			activityEventModel
+				.query()
+				.where('object_type', 'certificate')
+				.andWhere('source', 'renewal')
+				.andWhere('is_success', 0)
+				.andWhere('created_on', '>=', from)
+				.andWhere('created_on', '<', to)
+		])
+			.then(([renewed, failed]) => {
+				const ids = _.uniq(_.map(failed, 'object_id'));
+
+				return (ids.length ? certificateModel.query().whereIn('id', ids) : Promise.resolve([]))
+					.then((certificates) => {
+						return {
+							renewed: renewed.length,
+							failed:  certificates.map((certificate) => {
+								return {
+									certificate_id: certificate.id,
+									name:           certificate.nice_name || certificate.domain_names.join(', ')
+								};
+							})
+						};
+					});
+			});
+	},
+
+	/**
+	 * Certificates and domain registrations running out soon
+	 *
+	 * @returns {Promise}
+	 */
+	getExpirations: () => {
+		const now   = moment().format(DATE_FORMAT);
+		const until = moment().add(EXPIRING_DAYS, 'days').format(DATE_FORMAT);
+
+		return Promise.all([
+			certificateModel
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('expires_on', '<', until)
+				.orderBy('expires_on', 'ASC'),
+			domainExpiryModel
+				.query()
+				.whereNotNull('expires_on')
+				.andWhere('expires_on', '<', until)
+				.orderBy('expires_on', 'ASC')
+		])
+			.then(([certificates, domains]) => {
+				return {
+					days:              EXPIRING_DAYS,
+					certificate_count: certificates.length,
+					domain_count:      domains.length,
+					certificates:      _.take(certificates, LIMIT).map((certificate) => {
+						return {
+							certificate_id: certificate.id,
+							name:           certificate.nice_name || certificate.domain_names.join(', '),
+							// Renewals take care of these, unless they keep failing
+							provider:       certificate.provider,
+							expires_on:     certificate.expires_on,
+							is_expired:     moment(certificate.expires_on).isBefore(now)
+						};
+					}),
+					domains:           _.take(domains, LIMIT).map((domain) => {
+						return {
+							domain:     domain.domain,
+							expires_on: domain.expires_on,
+							is_expired: moment(domain.expires_on).isBefore(now)
+						};
+					})
+				};
+			});
+	},
+
+	/**
+	 * The hosts that served the most requests. Days of weeks are only counted with analytics on,
+	 * months come from the usage counts every host has.
+	 *
+	 * @param   {String}  frequency
+	 * @param   {Object}  period
+	 * @returns {Promise}
+	 */
+	getTraffic: (frequency, period) => {
+		let query;
+
+		if (frequency === 'monthly') {
+			query = hostUsageModel
+				.query()
+				.where('month', period.key);
+		} else {
+			query = hostAnalyticsModel
+				.query()
+				.where('kind', 'total')
+				.andWhere('day', '>=', period.from.format('YYYY-MM-DD'))
+				.andWhere('day', '<', period.to.format('YYYY-MM-DD'));
+		}
+
+		return query
+			.then((rows) => {
+				const totals = _.map(_.groupBy(rows, (row) => row.object_type + '|' + row.object_id), (group) => {
+					return {
+						object_type: group[0].object_type,
+						object_id:   group[0].object_id,
+						requests:    _.sumBy(group, 'requests'),
+						bytes:       _.sumBy(group, (row) => parseInt(row.bytes, 10))
+					};
+				});
+
+				const top = _.take(_.orderBy(totals, ['requests'], ['desc']), LIMIT);
+
+				return Promise.all(top.map((total) => {
+					return HOST_TYPES[total.object_type]
+						.query()
+						.where('id', total.object_id)
+						.first()
+						.then((host) => {
+							return _.assign(total, {name: host ? getHostName(host, total.object_type) : total.object_type + ' #' + total.object_id});
+						});
+				}))
+					.then((hosts) => {
+						return {
+							requests: _.sumBy(totals, 'requests'),
+							bytes:    _.sumBy(totals, 'bytes'),
+							hosts:    hosts
+						};
+					});
+			});
+	},
+
+	/**
+	 * What the security audit finds now
+	 *
+	 * @returns {Promise}
+	 */
+	getSecurity: () => {
+		return internalSecurityAudit.get({
+			can:   () => Promise.resolve({permission_visibility: 'all'}),
+			token: new tokenModel()
+		})
+			.then((audit) => {
+				return {
+					summary:  audit.summary,
+					findings: _.take(audit.findings, LIMIT).map((finding) => _.pick(finding, ['severity', 'title', 'detail']))
+				};
+			});
+	},
+
+	/**
+	 * @param   {String}  frequency
+	 * @param   {Array}   [sections]  all of them when there isn't a list
+	 * @param   {Object}  [now]       moment
+	 * @returns {Promise}
+	 */
+	build: (frequency, sections, now) => {
+		const period = internalScheduledReport.getPeriod(frequency, now);
+
+		sections = sections && sections.length ? sections : SECTIONS;
+
+		const getters = {
+			hosts:       () => internalScheduledReport.getNewHosts(period),
+			renewals:    () => internalScheduledReport.getRenewals(period),
+			expirations: () => internalScheduledReport.getExpirations(),
+			traffic:     () => internalScheduledReport.getTraffic(frequency, period),
+			security:    () => internalScheduledReport.getSecurity()
+		};
+
+		const included = SECTIONS.filter((section) => sections.indexOf(section) !== -1);
+
+		return Promise.all(included.map((section) => getters[section]()))
+			.then((results) => {
+				return _.assign({
+					frequency: frequency,
+					period:    period.key,
+					from:      period.from.format('YYYY-MM-DD'),
+					to:        period.to.clone().subtract(1, 'day').format('YYYY-MM-DD')
+				}, _.zipObject(included, results));
+			});
+	},
+
+	/**
+	 * The report as a message, the first line being the subject of an email
+	 *
+	 * @param   {Object}  report
+	 * @returns {String}
+	 */
+	format: (report) => {
+		let lines = [
+			'Nginx Proxy Manager ' + os.hostname() + ' ' + report.frequency + ' report, ' + report.from + ' to ' + report.to,
+			''
+		];
+
+		if (report.hosts) {
+			lines.push('New hosts: ' + report.hosts.count);
+			report.hosts.hosts.forEach((host) => {
+				lines.push('  ' + _.upperFirst(host.object_type.replace('-', ' ')) + ' #' + host.object_id + ' ' + host.name);
+			});
+			lines.push('');
+		}
+
+		if (report.renewals) {
+			lines.push('Certificates renewed: ' + report.renewals.renewed);
+			if (report.renewals.failed.length) {
+				lines.push('Renewals given up on: ' + report.renewals.failed.length);
+				report.renewals.failed.forEach((certificate) => {
+					lines.push('  Certificate #' + certificate.certificate_id + ' ' + certificate.name);
+				});
+			}
+			lines.push('');
+		}
+
+		if (report.expirations) {
+			lines.push('Expiring within ' + report.expirations.days + ' days: ' + report.expirations.certificate_count + ' certificates, ' + report.expirations.domain_count + ' domains');
+			report.expirations.certificates.forEach((certificate) => {
+				lines.push('  Certificate #' + certificate.certificate_id + ' ' + certificate.name + (certificate.is_expired ? ' expired on ' : ' expires on ') + certificate.expires_on);
+			});
+			report.expirations.domains.forEach((domain) => {
+				lines.push('  Domain ' + domain.domain + (domain.is_expired ? ' expired on ' : ' expires on ') + domain.expires_on);
+			});
+			lines.push('');
+		}
+
+		if (report.traffic) {
+			lines.push('Traffic: ' + report.traffic.requests + ' requests, ' + formatBytes(report.traffic.bytes));
+			report.traffic.hosts.forEach((host) => {
+				lines.push('  ' + host.name + ': ' + host.requests + ' requests, ' + formatBytes(host.bytes));
+			});
+			lines.push('');
+		}
+
+		if (report.security) {
+			lines.push('Security findings: ' + _.map(report.security.summary, (count, severity) => count + ' ' + severity).join(', '));
+			report.security.findings.forEach((finding) => {
+				lines.push('  [' + finding.severity + '] ' + finding.title);
+			});
+			lines.push('');
+		}
+
+		return lines.join('\n').trim();
+	},
+
+	/**
+	 * Recipients must be channels there are, and get each report once
+	 *
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		const recipients = meta.recipients || [];
+
+		return internalNotifications.getSetting()
+			.then((setting) => {
+				const names   = _.map(setting && setting.meta ? setting.meta.channels : [], 'name');
+				const unknown = _.difference(_.map(recipients, 'channel'), names);
+
+				if (unknown.length) {
+					throw new error.ValidationError('There\'s no notification channel called ' + _.uniq(unknown).join(', '));
+				}
+
+				recipients.forEach((recipient, index) => {
+					if (_.findIndex(recipients, {channel: recipient.channel, frequency: recipient.frequency}) !== index) {
+						throw new error.ValidationError(recipient.channel + ' has more than one ' + recipient.frequency + ' report');
+					}
+				});
+			});
+	},
+
+	/**
+	 * Sends a report to a recipient's channel, whether or not the setting is on
+	 *
+	 * @param   {Object}  channel
+	 * @param   {Object}  recipient
+	 * @param   {Object}  [now]      moment
+	 * @returns {Promise}
+	 */
+	deliver: (channel, recipient, now) => {
+		return internalScheduledReport.build(recipient.frequency, recipient.sections, now)
+			.then((report) => {
+				return internalNotifications.deliver(channel, internalScheduledReport.format(report))
+					.then(() => report);
+			});
+	},
+
+	/**
+	 * Claims the period of a recipient, so another replica or a later run doesn't send it too.
+	 * Resolves with the claim, or nothing when it's been sent or tried often enough.
+	 *
+	 * @param   {Object}  recipient
+	 * @param   {Object}  period
+	 * @returns {Promise}
+	 */
+	claim: (recipient, period) => {
+		const where = {
+			channel:   recipient.channel,
+			frequency: recipient.frequency,
+			period:    period.key
+		};
+
+		return reportDeliveryModel
+			.query()
+			.where(where)
+			.first()
+			.then((row) => {
+				if (row) {
+					return row.is_success || (row.meta.attempts || 0) >= ATTEMPTS ? null : row;
+				}
+
+				return reportDeliveryModel
+					.query()
+					.insertAndFetch(_.assign({}, where, {is_success: false, meta: {attempts: 0}}))
+					.catch(() => {
+						// The unique key, another replica got there first
+						return null;
+					});
+			});
+	},
+
+	/**
+	 * Triggered by a timer, this sends the reports of the week or month that's over
+	 * to the recipients that haven't had them yet
+	 *
+	 * @returns {Promise}
+	 */
+	processReports: () => {
+		if (internalScheduledReport.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalScheduledReport.intervalProcessing = true;
+
+		return Promise.all([
+			internalScheduledReport.getSetting(),
+			internalNotifications.getSetting()
+		])
+			.then(([setting, channels]) => {
+				const now = moment();
+
+				if (!setting || setting.value !== 'on' || now.hour() < (typeof setting.meta.hour === 'number' ? setting.meta.hour : DEFAULT_HOUR)) {
+					return false;
+				}
+
+				const recipients = (setting.meta.recipients || []).filter((recipient) => recipient.enabled !== false);
+				let sequence     = Promise.resolve();
+
+				recipients.forEach((recipient) => {
+					const channel = _.find(channels && channels.meta ? channels.meta.channels : [], {name: recipient.channel});
+					if (!channel) {
+						return;
+					}
+
+					const period = internalScheduledReport.getPeriod(recipient.frequency, now);
+
+					sequence = sequence
+						.then(() => {
+							return internalScheduledReport.claim(recipient, period);
+						})
+						.then((claim) => {
+							if (!claim) {
+								return;
+							}
+
+							logger.info('Sending the ' + recipient.frequency + ' report of ' + period.key + ' to ' + channel.name);
+
+							return internalScheduledReport.deliver(channel, recipient, now)
+								.then(() => {
+									return reportDeliveryModel
+										.query()
+										.patchAndFetchById(claim.id, {
+											is_success: true,
+											meta:       {attempts: (claim.meta.attempts || 0) + 1}
+										});
+								})
+								.catch((err) => {
+									// Don't want to stop the train here, the next run tries again
+									logger.error('Could not send the ' + recipient.frequency + ' report to ' + channel.name + ': ' + err.message);
+									return reportDeliveryModel
+										.query()
+										.patchAndFetchById(claim.id, {
+											meta: {attempts: (claim.meta.attempts || 0) + 1, error: err.message}
+										});
+								});
+						});
+				});
+
+				return sequence.then(() => true);
+			})
+			.then((result) => {
+				internalScheduledReport.intervalProcessing = false;
+				return result;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalScheduledReport.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * The report of the last week or month, as it would be sent
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  [data.frequency]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('reports:summary')
+			.then(() => {
+				return internalScheduledReport.build(data.frequency || 'weekly');
+			});
+	},
+
+	/**
+	 * Sends the report of the last week or month to a channel now, without it counting as sent
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.channel
+	 * @param   {String}  [data.frequency]
+	 * @param   {Array}   [data.sections]
+	 * @returns {Promise}
+	 */
+	send: (access, data) => {
+		return access.can('settings:update', 'scheduled-reports')
+			.then(() => {
+				return internalNotifications.getSetting();
+			})
+			.then((setting) => {
+				const channel = _.find(setting && setting.meta ? setting.meta.channels : [], {name: data.channel});
+				if (!channel) {
+					throw new error.ItemNotFoundError(data.channel);
+				}
+
+				return internalScheduledReport.deliver(channel, {frequency: data.frequency || 'weekly', sections: data.sections})
+					.catch((err) => {
+						throw new error.ValidationError(err.message);
+					});
+			})
+			.then((report) => {
+				return {sent: true, report: report};
+			});
+	}
+};
+
+module.exports = internalScheduledReport;
@@ -0,0 +1,83 @@
+const _       = require('lodash');
+const error   = require('../lib/error');
+const helpers = require('../lib/helpers');
+
+/**
+ * What can be searched for, and how each item is shown. Required when used, as these modules require a lot.
+ */
+const TYPES = {
+	'proxy-host': {
+		internal:    () => require('./proxy-host'),
+		name:        (row) => row.domain_names.join(', '),
+		description: (row) => row.forward_scheme + '://' + row.forward_host + ':' + row.forward_port
+	},
+	'redirection-host': {
+		internal:    () => require('./redirection-host'),
+		name:        (row) => row.domain_names.join(', '),
+		description: (row) => row.forward_scheme + '://' + row.forward_domain_name
+	},
+	'dead-host': {
+		internal:    () => require('./dead-host'),
+		name:        (row) => row.domain_names.join(', '),
+		description: () => '404'
+	},
+	'stream': {
+		internal:    () => require('./stream'),
+		name:        (row) => String(row.incoming_port),
+		description: (row) => row.forwarding_host + ':' + row.forwarding_port
+	},
+	'certificate': {
+		internal:    () => require('./certificate'),
+		name:        (row) => row.nice_name,
+		description: (row) => (row.domain_names || []).join(', ')
+	},
+	'user': {
+		internal:    () => require('./user'),
+		name:        (row) => row.name,
+		description: (row) => row.email
+	}
+};
+
+const DEFAULT_LIMIT = 5;
+
+const internalSearch = {
+
+	/**
+	 * Finds the items of each type matching the query, leaving out the types this user can't list
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.q
+	 * @param   {Number}  [data.limit]  of each type
+	 * @returns {Promise}  resolves with ie: [{object_type: 'proxy-host', object_id: 1, name: 'example.com', description: 'http://10.0.0.2:80'}]
+	 */
+	search: (access, data) => {
+		const limit = data.limit || DEFAULT_LIMIT;
+
+		// Domain names are stored in their unicode form
+		const query = data.q.indexOf('xn--') !== -1 ? helpers.toUnicodeDomain(data.q) || data.q : data.q;
+
+		return Promise.all(_.map(TYPES, (type, object_type) => {
+			return type.internal().getAll(access, null, query)
+				.then((rows) => {
+					return rows.slice(0, limit).map((row) => {
+						return {
+							object_type: object_type,
+							object_id:   row.id,
+							name:        type.name(row),
+							description: type.description(row)
+						};
+					});
+				})
+				.catch((err) => {
+					if (!(err instanceof error.PermissionError)) {
+						throw err;
+					}
+					return [];
+				});
+		}))
+			.then(_.flatten);
+	}
+};
+
+module.exports = internalSearch;
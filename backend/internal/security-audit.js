@@ -0,0 +1,265 @@
+const _                    = require('lodash');
+const config               = require('../lib/config');
+const userModel            = require('../models/user');
+const authModel            = require('../models/auth');
+const certificateModel     = require('../models/certificate');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const internalAdminListen  = require('./admin-listen');
+const internalAdminHost    = require('./admin-host');
+
+// What the first user is created with when nothing else is given
+const DEFAULT_EMAIL    = 'admin@example.com';
+const DEFAULT_PASSWORD = 'changeme';
+
+// Findings are reported in this order
+const SEVERITIES = ['critical', 'high', 'medium', 'low'];
+
+const HOST_TYPES = {
+	'proxy-host':       proxyHostModel,
+	'redirection-host': redirectionHostModel,
+	'dead-host':        deadHostModel
+};
+
+const internalSecurityAudit = {
+
+	/**
+	 * Admins that still sign in with the default password, or have the default email
+	 *
+	 * @returns {Promise}  resolves with findings
+	 */
+	checkCredentials: () => {
+		return userModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('is_disabled', 0)
+			.then((users) => {
+				users = users.filter((user) => user.roles.indexOf('admin') !== -1);
+
+				return Promise.all(users.map((user) => {
+					return authModel
+						.query()
+						.where('user_id', user.id)
+						.andWhere('type', 'password')
+						.first()
+						.then((auth) => {
+							return auth ? auth.verifyPassword(DEFAULT_PASSWORD) : false;
+						})
+						.then((is_default) => {
+							let findings = [];
+
+							if (is_default) {
+								findings.push({
+									id:          'default_password',
+									severity:    'critical',
+									title:       'An admin has the default password',
+									detail:      user.email + ' signs in with ' + DEFAULT_PASSWORD + ', which anyone can try',
+									hint:        'Sign in as ' + user.email + ' and change the password',
+									object_type: 'user',
+									object_id:   user.id
+								});
+							}
+
+							if (user.email.toLowerCase() === DEFAULT_EMAIL) {
+								findings.push({
+									id:          'default_email',
+									severity:    'medium',
+									title:       'An admin has the default email',
+									detail:      'Half of the default credentials are known, only the password keeps ' + DEFAULT_EMAIL + ' out',
+									hint:        'Change the email of the user to one of your own',
+									object_type: 'user',
+									object_id:   user.id
+								});
+							}
+
+							return findings;
+						});
+				}));
+			})
+			.then(_.flatten);
+	},
+
+	/**
+	 * How the admin port can be reached. It listens on every address, so only the admin-host setting keeps it
+	 * to this machine.
+	 *
+	 * @returns {Promise}  resolves with findings
+	 */
+	checkAdminPort: () => {
+		return Promise.all([
+			internalAdminListen.getSetting(),
+			internalAdminHost.getSetting()
+		])
+			.then(([admin_listen, admin_host]) => {
+				let findings = [];
+				const https  = admin_listen && admin_listen.value === 'https';
+
+				if (!(internalAdminHost.getAdminHostId(admin_host) && admin_host.meta.restrict_port)) {
+					findings.push({
+						id:       'admin_port_exposed',
+						severity: https ? 'medium' : 'high',
+						title:    'The admin interface and API can be reached by anyone',
+						detail:   'Port 81 listens on every address, and nothing limits who can use it',
+						hint:     'Serve the admin interface through a proxy host with the Admin Host setting and restrict the port, or only publish port 81 on 127.0.0.1'
+					});
+				}
+
+				if (!https && !internalAdminHost.getAdminHostId(admin_host)) {
+					findings.push({
+						id:       'admin_plain_http',
+						severity: 'medium',
+						title:    'The admin interface is served with plain http',
+						detail:   'Passwords and tokens for the API go over the network unencrypted',
+						hint:     'Serve port 81 with https using the Admin Listener setting, or through a proxy host with a certificate'
+					});
+				}
+
+				return findings;
+			});
+	},
+
+	/**
+	 * Enabled hosts that are served without TLS, or with TLS but still answer plain http
+	 *
+	 * @returns {Promise}  resolves with findings
+	 */
+	checkHosts: () => {
+		return Promise.all(_.map(HOST_TYPES, (model, object_type) => {
+			return model
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('enabled', 1)
+				.orderBy('id', 'ASC')
+				.then((hosts) => {
+					return hosts.map((host) => {
+						const name = host.domain_names.join(', ');
+
+						if (!host.certificate_id) {
+							return {
+								id:          'host_without_tls',
+								severity:    'medium',
+								title:       'A host is served without TLS',
+								detail:      name + ' only answers plain http',
+								hint:        'Choose a certificate for the host and turn on Force SSL',
+								object_type: object_type,
+								object_id:   host.id
+							};
+						}
+
+						if (!host.ssl_forced) {
+							return {
+								id:          'host_tls_not_forced',
+								severity:    'low',
+								title:       'A host with a certificate also answers plain http',
+								detail:      name + ' isn\'t redirected to https',
+								hint:        'Turn on Force SSL for the host',
+								object_type: object_type,
+								object_id:   host.id
+							};
+						}
+
+						return null;
+					});
+				});
+		}))
+			.then((findings) => _.compact(_.flatten(findings)));
+	},
+
+	/**
+	 * Certificates from the Let's Encrypt staging CA, which browsers don't trust, that are in use
+	 *
+	 * @returns {Promise}  resolves with findings
+	 */
+	checkStaging: () => {
+		let findings = [];
+
+		if (config.useLetsencryptStaging() && !config.debug()) {
+			findings.push({
+				id:       'staging_ca',
+				severity: 'high',
+				title:    'Certificates are requested from the staging CA',
+				detail:   'LE_STAGING is set, so new Let\'s Encrypt certificates won\'t be trusted by browsers',
+				hint:     'Remove LE_STAGING from the environment of the container'
+			});
+		}
+
+		if (config.getTestMode() && !config.debug()) {
+			findings.push({
+				id:       'test_mode',
+				severity: 'high',
+				title:    'Test mode is on',
+				detail:   'TEST_MODE is set, so new certificates are requested from ' + config.getTestMode().acme_server + ' and won\'t be trusted by browsers',
+				hint:     'Remove TEST_MODE from the environment of the container, it\'s only for integration tests'
+			});
+		}
+
+		return Promise.all([
+			certificateModel
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('provider', 'letsencrypt')
+				.orderBy('id', 'ASC'),
+			Promise.all(_.map(HOST_TYPES, (model) => {
+				return model
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('enabled', 1)
+					.andWhere('certificate_id', '>', 0)
+					.select('certificate_id');
+			})),
+			internalAdminListen.getSetting()
+		])
+			.then(([certificates, hosts, admin_listen]) => {
+				let used = _.flatten(hosts).map((host) => host.certificate_id);
+
+				if (admin_listen && admin_listen.value === 'https' && admin_listen.meta) {
+					used.push(admin_listen.meta.certificate_id);
+				}
+
+				certificates
+					.filter((certificate) => certificate.meta && certificate.meta.use_staging && used.indexOf(certificate.id) !== -1)
+					.forEach((certificate) => {
+						findings.push({
+							id:          'staging_certificate',
+							severity:    'high',
+							title:       'A certificate from the staging CA is in use',
+							detail:      certificate.domain_names.join(', ') + ' is served with a certificate browsers don\'t trust',
+							hint:        'Promote the certificate to production, or request a new one without staging',
+							object_type: 'certificate',
+							object_id:   certificate.id
+						});
+					});
+
+				return findings;
+			});
+	},
+
+	/**
+	 * Everything that's risky about this instance, the worst first
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	get: (access) => {
+		return access.can('system:security-audit')
+			.then(() => {
+				return Promise.all([
+					internalSecurityAudit.checkCredentials(),
+					internalSecurityAudit.checkAdminPort(),
+					internalSecurityAudit.checkStaging(),
+					internalSecurityAudit.checkHosts()
+				]);
+			})
+			.then((results) => {
+				const findings = _.sortBy(_.flatten(results), (finding) => SEVERITIES.indexOf(finding.severity));
+
+				return {
+					summary:  _.fromPairs(SEVERITIES.map((severity) => [severity, findings.filter((finding) => finding.severity === severity).length])),
+					findings: findings
+				};
+			});
+	}
+};
+
+module.exports = internalSecurityAudit;
@@ -0,0 +1,253 @@
+const _                 = require('lodash');
+const http              = require('http');
+const https             = require('https');
+const helpers           = require('../lib/helpers');
+const internalProxyHost = require('./proxy-host');
+const internalNginx     = require('./nginx');
+const internalTlsScan   = require('./tls-scan');
+const internalHostPorts = require('./host-ports');
+
+const TIMEOUT = 5000;
+
+// What conf.d/include/ssl-ciphers.conf turns on when the host doesn't set its own
+const DEFAULT_PROTOCOLS = ['TLSv1.2', 'TLSv1.3'];
+
+const DEPRECATED_PROTOCOLS = ['SSLv3', 'TLSv1', 'TLSv1.1'];
+
+/**
+ * The checklist, in the order it's reported. The weights add up to 100.
+ */
+const CHECKS = {
+	https: {
+		name:    'Served over https',
+		weight:  30,
+		setting: 'ssl_forced',
+		hint:    'Choose a certificate and turn on Force SSL, so plain http is redirected to https'
+	},
+	hsts: {
+		name:    'HSTS header',
+		weight:  20,
+		setting: 'hsts_enabled',
+		hint:    'Turn on Force SSL and HSTS, browsers then refuse plain http for the domain'
+	},
+	tls_versions: {
+		name:    'No deprecated TLS versions',
+		weight:  20,
+		setting: 'advanced_config',
+		hint:    'Remove ssl_protocols with TLSv1 or TLSv1.1 from the advanced config, TLSv1.2 and TLSv1.3 are the default'
+	},
+	csp: {
+		name:    'Content-Security-Policy header',
+		weight:  15,
+		setting: 'advanced_config',
+		hint:    'Add the header in the advanced config, ie: add_header Content-Security-Policy "default-src \'self\'" always;'
+	},
+	server_tokens: {
+		name:    'Server version hidden',
+		weight:  15,
+		setting: 'advanced_config',
+		hint:    'Remove server_tokens on from the advanced config, the version of nginx is hidden by default'
+	}
+};
+
+// For the checks that need TLS, when the host doesn't have a certificate
+const NO_CERTIFICATE = {
+	setting: 'certificate_id',
+	hint:    'Choose a certificate, the host isn\'t served over TLS at all'
+};
+
+const GRADES = [['A', 90], ['B', 80], ['C', 65], ['D', 50]];
+
+const internalSecurityReport = {
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Object}  {address, port, servername, secure}, or null when the host has no domain to request
+	 */
+	getTarget: (host) => {
+		const domain_name = _.find(host.domain_names, (domain_name) => domain_name.indexOf('*') === -1);
+		const servername  = domain_name ? helpers.toAsciiDomain(domain_name) : null;
+		const ports       = internalHostPorts.getPorts(host);
+		const secure      = !!host.certificate_id && ports.https.length > 0;
+
+		if (!servername || (!secure && !ports.http.length)) {
+			return null;
+		}
+
+		let port = secure ? ports.https[0] : ports.http[0];
+		if ((secure ? ports.https : ports.http).indexOf(secure ? 443 : 80) !== -1) {
+			port = secure ? 443 : 80;
+		}
+
+		return {
+			address:    servername,
+			port:       port,
+			servername: servername,
+			secure:     secure
+		};
+	},
+
+	/**
+	 * Requests / from the host and returns the headers of the answer
+	 *
+	 * @param   {Object}  target
+	 * @returns {Promise}  resolves with the headers, or null when there was no answer
+	 */
+	getHeaders: (target) => {
+		return new Promise((resolve) => {
+			const req = (target.secure ? https : http).request({
+				host:               target.address,
+				port:               target.port,
+				servername:         target.secure ? target.servername : undefined,
+				path:               '/',
+				method:             'GET',
+				headers:            {Host: target.servername},
+				rejectUnauthorized: false,
+				timeout:            TIMEOUT
+			}, (res) => {
+				res.resume();
+				resolve(res.headers);
+			});
+
+			req.on('timeout', () => {
+				req.destroy();
+				resolve(null);
+			});
+			req.on('error', () => {
+				resolve(null);
+			});
+			req.end();
+		});
+	},
+
+	/**
+	 * Requests the host the way a browser would, for the checks that depend on what's actually served
+	 *
+	 * @param   {Object}  target
+	 * @returns {Promise}  resolves with {headers, protocols}, either being null when it couldn't be found out
+	 */
+	probe: (target) => {
+		return Promise.all([
+			internalSecurityReport.getHeaders(target),
+			target.secure ? internalTlsScan.scanProtocols(target) : Promise.resolve(null)
+		])
+			.then(([headers, protocols]) => {
+				// Nothing answers on the port at all
+				if (protocols && !_.some(protocols, 'accepted')) {
+					protocols = null;
+				}
+
+				return {
+					headers:   headers,
+					protocols: protocols
+				};
+			});
+	},
+
+	/**
+	 * Runs the checklist on the rendered config, and on the probe when there is one. What the probe
+	 * saw wins, as the forward host can send headers of its own.
+	 *
+	 * @param   {Object}       host
+	 * @param   {String}       config  rendered
+	 * @param   {Object|null}  probe
+	 * @returns {Array}
+	 */
+	getChecks: (host, config, probe) => {
+		const headers   = probe && probe.headers ? probe.headers : null;
+		const protocols = probe && probe.protocols ? probe.protocols : null;
+		const secure    = !!host.certificate_id;
+
+		const hasHeader = (name) => new RegExp('^\\s*add_header\\s+' + name + '\\s', 'im').test(config);
+
+		const configured_protocols = config.match(/^\s*ssl_protocols\s+([^;]+);/m);
+		const enabled_protocols    = configured_protocols ? configured_protocols[1].trim().split(/\s+/) : DEFAULT_PROTOCOLS;
+
+		const results = {
+			https: {
+				config: secure && (host.ssl_forced === 1 || host.ssl_forced === true),
+				probe:  null
+			},
+			hsts: {
+				config: secure && hasHeader('Strict-Transport-Security'),
+				probe:  headers && secure ? typeof headers['strict-transport-security'] !== 'undefined' : null
+			},
+			tls_versions: {
+				config: secure && _.intersection(enabled_protocols, DEPRECATED_PROTOCOLS).length === 0,
+				probe:  protocols ? !_.some(protocols, (protocol) => protocol.accepted && DEPRECATED_PROTOCOLS.indexOf(protocol.protocol) !== -1) : null
+			},
+			csp: {
+				config: hasHeader('Content-Security-Policy'),
+				probe:  headers ? typeof headers['content-security-policy'] !== 'undefined' : null
+			},
+			server_tokens: {
+				config: !/^\s*server_tokens\s+(on|build)/m.test(config),
+				probe:  headers ? !/\/\d/.test(headers.server || '') : null
+			}
+		};
+
+		return Object.keys(CHECKS).map((id) => {
+			const result = results[id];
+			const passed = result.probe === null ? result.config : result.probe;
+			const remedy = !secure && ['hsts', 'tls_versions'].indexOf(id) !== -1 ? NO_CERTIFICATE : CHECKS[id];
+
+			return {
+				id:      id,
+				name:    CHECKS[id].name,
+				passed:  passed,
+				config:  result.config,
+				probe:   result.probe,
+				weight:  CHECKS[id].weight,
+				setting: passed ? null : remedy.setting,
+				hint:    passed ? null : remedy.hint
+			};
+		});
+	},
+
+	/**
+	 * @param   {Number}  score  out of 100
+	 * @returns {String}
+	 */
+	getGrade: (score) => {
+		const grade = _.find(GRADES, ([, minimum]) => score >= minimum);
+		return grade ? grade[0] : 'F';
+	},
+
+	/**
+	 * Grades the security of a proxy host from its rendered config and, unless turned off, a request to it
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Number}   data.id
+	 * @param   {Boolean}  [data.probe]
+	 * @returns {Promise}
+	 */
+	getReport: (access, data) => {
+		return internalProxyHost.get(access, {
+			id:     data.id,
+			expand: ['owner', 'certificate', 'access_list.[clients,items]']
+		})
+			.then((host) => {
+				const target = data.probe === false ? null : internalSecurityReport.getTarget(host);
+
+				return Promise.all([
+					internalNginx.renderConfig('proxy_host', host),
+					target ? internalSecurityReport.probe(target) : Promise.resolve(null)
+				])
+					.then(([config, probe]) => {
+						const checks = internalSecurityReport.getChecks(host, config, probe);
+						const score  = _.sumBy(_.filter(checks, 'passed'), 'weight');
+
+						return {
+							object_id: host.id,
+							grade:     internalSecurityReport.getGrade(score),
+							score:     score,
+							probed:    !!probe && (probe.headers !== null || probe.protocols !== null),
+							checks:    checks
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalSecurityReport;
@@ -0,0 +1,108 @@
+const fs     = require('fs');
+const error  = require('../lib/error');
+const config = require('../lib/config');
+
+const filesDir = config.getPath('served_files');
+
+/**
+ * The files a host can serve itself, by the key they're given with and the path they're served at
+ */
+const FILES = {
+	robots_txt:   '/robots.txt',
+	security_txt: '/.well-known/security.txt'
+};
+
+const internalServedFiles = {
+
+	/**
+	 * @param   {Number}  host_id
+	 * @param   {String}  key      ie: robots_txt
+	 * @returns {String}
+	 */
+	getFile: (host_id, key) => {
+		return filesDir + '/proxy-host-' + host_id + '-' + key.replace('_', '.');
+	},
+
+	/**
+	 * security.txt needs a Contact and an Expires field, Expires being in the future.
+	 * The paths can't be exempted from the access list as well, nginx can't have two locations for them.
+	 *
+	 * @see https://www.rfc-editor.org/rfc/rfc9116
+	 *
+	 * @param   {Object}  data   payload
+	 * @param   {Object}  [row]  existing host
+	 * @returns {Promise}
+	 */
+	validate: (data, row) => {
+		const served_files = typeof data.served_files !== 'undefined' ? data.served_files : (row ? row.served_files : null);
+		const exemptions   = typeof data.access_exemptions !== 'undefined' ? data.access_exemptions : (row ? row.access_exemptions : null);
+
+		if (!served_files) {
+			return Promise.resolve();
+		}
+
+		if (served_files.security_txt) {
+			const expires = served_files.security_txt.match(/^Expires:\s*(\S+)\s*$/mi);
+
+			if (!/^Contact:\s*\S+/mi.test(served_files.security_txt)) {
+				return Promise.reject(new error.ValidationError('security.txt needs at least one Contact field'));
+			}
+			if (!expires || isNaN(Date.parse(expires[1]))) {
+				return Promise.reject(new error.ValidationError('security.txt needs an Expires field with a date, ie: Expires: 2027-10-16T00:00:00.000Z'));
+			}
+			if (Date.parse(expires[1]) <= Date.now()) {
+				return Promise.reject(new error.ValidationError('The Expires field of security.txt is in the past'));
+			}
+		}
+
+		for (const key of Object.keys(FILES)) {
+			const exempted = (exemptions || []).some((exemption) => (exemption.match || 'exact') === 'exact' && exemption.path === FILES[key]);
+			if (served_files[key] && exempted) {
+				return Promise.reject(new error.ValidationError(FILES[key] + ' is served by the host, it can\'t be exempted from the access list as well'));
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Writes the files of a host for nginx to serve, and removes the ones it doesn't have any more
+	 *
+	 * @param {Object}  host
+	 */
+	writeFiles: (host) => {
+		const served_files = host.served_files || {};
+
+		Object.keys(FILES).forEach((key) => {
+			const file = internalServedFiles.getFile(host.id, key);
+
+			if (served_files[key]) {
+				if (!fs.existsSync(filesDir)) {
+					fs.mkdirSync(filesDir);
+				}
+				fs.writeFileSync(file, served_files[key].replace(/\r?\n?$/, '\n'), {encoding: 'utf8'});
+			} else if (fs.existsSync(file)) {
+				fs.unlinkSync(file);
+			}
+		});
+	},
+
+	/**
+	 * What to write into the config of a proxy host
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}   ie: [{path: '/robots.txt', file: '/data/served-files/proxy-host-1-robots.txt'}]
+	 */
+	getOptions: (host) => {
+		const served_files = host.served_files || {};
+
+		return Object.keys(FILES).filter((key) => !!served_files[key]).map((key) => {
+			return {
+				path: FILES[key],
+				file: internalServedFiles.getFile(host.id, key)
+			};
+		});
+	}
+};
+
+module.exports = internalServedFiles;
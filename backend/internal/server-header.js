@@ -0,0 +1,76 @@
+const _            = require('lodash');
+const settingModel = require('../models/setting');
+
+// Headers forward hosts use to give away what they run and which version, ie: X-Powered-By: PHP/8.1.2
+const VERSION_HEADERS = ['X-Powered-By', 'X-AspNet-Version', 'X-AspNetMvc-Version', 'X-Generator'];
+
+const internalServerHeader = {
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'server-header')
+			.first();
+	},
+
+	/**
+	 * What to write into a host's config. The host's own settings win over the global ones,
+	 * and with neither, nothing is written: nginx sends its name without the version.
+	 *
+	 * @param   {Object}  setting
+	 * @param   {Object}  host
+	 * @returns {Object|null}  ie: {clear: ['X-Powered-By'], set: [{name: 'Server', value: 'web'}]}
+	 */
+	getOptions: (setting, host) => {
+		let options = null;
+
+		if (setting && setting.value === 'custom') {
+			options = _.assign({}, setting.meta);
+		}
+
+		if (host.server_header && !_.isEmpty(host.server_header)) {
+			options = _.assign({}, options || {}, host.server_header);
+		}
+
+		if (options === null) {
+			return null;
+		}
+
+		let clear = options.hide_upstream_versions ? VERSION_HEADERS.slice() : [];
+		let set   = [];
+
+		// An empty value removes the header altogether
+		[['Server', options.server], ['X-Powered-By', options.powered_by]].forEach(([name, value]) => {
+			if (value === '') {
+				clear.push(name);
+			} else if (typeof value === 'string') {
+				set.push({name: name, value: value});
+			}
+		});
+
+		clear = _.difference(_.uniq(clear), _.map(set, 'name'));
+
+		if (!clear.length && !set.length) {
+			return null;
+		}
+
+		return {
+			clear: clear,
+			set:   set
+		};
+	},
+
+	/**
+	 * Writes the config for every enabled host without its own settings again, after the global setting has changed
+	 *
+	 * @returns {Promise}
+	 */
+	configure: () => {
+		return require('./host').regenerateConfigs((host) => _.isEmpty(host.server_header));
+	}
+};
+
+module.exports = internalServerHeader;
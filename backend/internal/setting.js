@@ -1,7 +1,32 @@
-const fs            = require('fs');
-const error         = require('../lib/error');
-const settingModel  = require('../models/setting');
-const internalNginx = require('./nginx');
+const fs                    = require('fs');
+const error                 = require('../lib/error');
+const config                = require('../lib/config');
+const apiValidator          = require('../lib/validator/api');
+const settingModel          = require('../models/setting');
+const internalNginx         = require('./nginx');
+const internalAdminHost     = require('./admin-host');
+const internalAdminListen   = require('./admin-listen');
+const internalCompression   = require('./compression');
+const internalLogShipping   = require('./log-shipping');
+const internalListen        = require('./listen');
+const internalAcmeDns       = require('./acme-dns');
+const internalProtection    = require('./protection-presets');
+const internalServerHeader  = require('./server-header');
+const internalHostDefaults  = require('./host-defaults');
+const internalDnsThrottle   = require('./dns-throttle');
+const internalBlocked       = require('./blocked-clients');
+const internalOutbound      = require('./outbound-proxy');
+const internalDnsResolvers  = require('./dns-resolvers');
+const internalNotifications = require('./notifications');
+const internalReports       = require('./scheduled-report');
+const internalAccessRequest = require('./access-request');
+const internalListPasswords = require('./access-list-password');
+const internalDocker        = require('./docker');
+const internalWireguard     = require('./wireguard');
+const internalTelemetry     = require('./telemetry');
+const cors                  = require('../lib/express/cors');
+const readOnly              = require('../lib/express/read-only');
+const lego                  = require('../lib/lego');
 
 const internalSetting = {
 
@@ -22,6 +47,9 @@ const internalSetting = {
 					throw new error.InternalValidationError('Setting could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
 
+				return internalSetting.validate(row, data);
+			})
+			.then(() => {
 				return settingModel
 					.query()
 					.where({id: data.id})
@@ -36,7 +64,7 @@ const internalSetting = {
 				if (row.id === 'default-site') {
 					// write the html if we need to
 					if (row.value === 'html') {
-						fs.writeFileSync('/data/nginx/default_www/index.html', row.meta.html, {encoding: 'utf8'});
+						fs.writeFileSync(config.getPath('nginx') + '/default_www/index.html', row.meta.html, {encoding: 'utf8'});
 					}
 
 					// Configure nginx
@@ -66,12 +94,161 @@ const internalSetting = {
 									throw new error.ValidationError('Could not reconfigure Nginx. Please check logs.');
 								});
 						});
+				} else if (row.id === 'admin-host') {
+					return internalAdminHost.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'admin-listen') {
+					return internalAdminListen.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'compression') {
+					return internalCompression.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'protection-presets') {
+					return internalProtection.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'access-requests') {
+					return internalAccessRequest.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'access-list-passwords') {
+					return internalListPasswords.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'docker') {
+					return internalDocker.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'wireguard') {
+					return internalWireguard.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'server-header') {
+					return internalServerHeader.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'log-shipping') {
+					return internalLogShipping.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'listen') {
+					return internalListen.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'acme-dns') {
+					return internalAcmeDns.configure()
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'blocked-clients') {
+					return internalBlocked.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'outbound-proxy') {
+					return internalOutbound.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'dns-resolvers') {
+					return internalDnsResolvers.configure(row)
+						.then(() => {
+							return row;
+						});
+				} else if (row.id === 'cors') {
+					cors.reset();
+					return row;
+				} else if (row.id === 'read-only') {
+					readOnly.reset();
+					return row;
 				} else {
 					return row;
 				}
 			});
 	},
 
+	/**
+	 * Each setting has its own payload schema, the api schema for the endpoint only
+	 * knows that the payload matches one of them.
+	 *
+	 * @param   {Object}  row   existing setting
+	 * @param   {Object}  data  update payload
+	 * @returns {Promise}
+	 */
+	validate: (row, data) => {
+		const payload = {};
+		if (typeof data.value !== 'undefined') {
+			payload.value = data.value;
+		}
+		if (typeof data.meta !== 'undefined') {
+			payload.meta = data.meta;
+		}
+
+		return apiValidator(require('../schema/components/settings/' + row.id + '.json'), payload)
+			.then(() => {
+				if (row.id === 'admin-host') {
+					return internalAdminHost.validate({
+						id:    row.id,
+						value: typeof data.value !== 'undefined' ? data.value : row.value,
+						meta:  typeof data.meta !== 'undefined' ? data.meta : row.meta
+					});
+				} else if (row.id === 'admin-listen') {
+					return internalAdminListen.validate({
+						id:    row.id,
+						value: typeof data.value !== 'undefined' ? data.value : row.value,
+						meta:  typeof data.meta !== 'undefined' ? data.meta : row.meta
+					});
+				} else if (row.id === 'listen' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'custom') {
+					return internalListen.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'acme-dns' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalAcmeDns.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'host-defaults' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalHostDefaults.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'blocked-clients') {
+					return internalBlocked.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'outbound-proxy' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalOutbound.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'dns-resolvers' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'custom') {
+					return internalDnsResolvers.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'wireguard' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalWireguard.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'telemetry' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalTelemetry.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'notification-channels') {
+					return internalNotifications.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'notification-routes') {
+					return internalNotifications.validateRoutes(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'scheduled-reports') {
+					return internalReports.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'access-requests' && (typeof data.value !== 'undefined' ? data.value : row.value) === 'on') {
+					return internalAccessRequest.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'dns-provider-limits') {
+					return internalDnsThrottle.validate(typeof data.meta !== 'undefined' ? data.meta : row.meta);
+				} else if (row.id === 'acme-client' && data.value === 'lego') {
+					return lego.isInstalled()
+						.then((installed) => {
+							if (!installed) {
+								throw new error.ValidationError('lego isn\'t installed in this image');
+							}
+						});
+				}
+			});
+	},
+
 	/**
 	 * @param  {Access}   access
 	 * @param  {Object}   data
@@ -125,7 +302,8 @@ const internalSetting = {
 			.then(() => {
 				return settingModel
 					.query()
-					.orderBy('description', 'ASC');
+					.orderBy('description', 'ASC')
+					.orderBy('id', 'ASC');
 			});
 	}
 };
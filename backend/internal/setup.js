@@ -0,0 +1,465 @@
+const fs                  = require('fs');
+const crypto              = require('crypto');
+const db                  = require('../db');
+const migrate             = require('../migrate');
+const config              = require('../lib/config');
+const error               = require('../lib/error');
+const bootstrap           = require('../lib/bootstrap');
+const logger              = require('../logger').setup;
+const userModel           = require('../models/user');
+const authModel           = require('../models/auth');
+const userPermissionModel = require('../models/user_permission');
+const internalToken       = require('./token');
+const internalSetting     = require('./setting');
+const internalProxyHost   = require('./proxy-host');
+const internalDeadHost    = require('./dead-host');
+
+const stateFile = '/data/setup.json';
+
+// In the order they're done, each needs the ones before it. Only the demo data can be skipped.
+const STEPS = ['database', 'keys', 'admin', 'settings', 'demo'];
+
+// Where the first start got to, kept in a file so a setup that was interrupted carries on from there
+let state = null;
+
+// The step being done, so two installers can't both create the first admin
+let running = null;
+
+/**
+ * @returns {Object}  every step waiting to be done
+ */
+const newState = () => {
+	let steps = {};
+	STEPS.forEach((step) => {
+		steps[step] = {status: 'pending', updated_on: null, error: null};
+	});
+
+	return {
+		started_on:   new Date().toISOString(),
+		completed_on: null,
+		steps:        steps
+	};
+};
+
+/**
+ * @returns {Object|null}  null when there isn't one, or it can't be read
+ */
+const readState = () => {
+	if (!fs.existsSync(stateFile)) {
+		return null;
+	}
+
+	try {
+		return JSON.parse(fs.readFileSync(stateFile, {encoding: 'utf8'}));
+	} catch (err) {
+		logger.warn('Could not read ' + stateFile + ', starting the setup again: ' + err.message);
+		return null;
+	}
+};
+
+/**
+ * Written to a temporary file first, so a crash can't leave half of it
+ */
+const writeState = () => {
+	const temp = stateFile + '.tmp';
+	const fd   = fs.openSync(temp, 'w', 0o600);
+	try {
+		fs.writeSync(fd, JSON.stringify(state, null, 2));
+		fs.fsyncSync(fd);
+	} finally {
+		fs.closeSync(fd);
+	}
+	fs.renameSync(temp, stateFile);
+};
+
+/**
+ * @param {String}  step
+ * @param {String}  status  done, skipped or failed
+ * @param {Error}   [err]
+ */
+const setStatus = (step, status, err) => {
+	state.steps[step] = {
+		status:     status,
+		updated_on: new Date().toISOString(),
+		error:      err ? err.message : null
+	};
+
+	if (STEPS.every((item) => ['done', 'skipped'].indexOf(state.steps[item].status) !== -1)) {
+		state.completed_on = state.steps[step].updated_on;
+		logger.info('Setup completed');
+	}
+
+	writeState();
+};
+
+/**
+ * The first admin can only be created by whoever has the setup token, when one was given
+ *
+ * @param   {String}  [given]
+ * @returns {Boolean}
+ */
+const isSetupToken = (given) => {
+	const token = internalSetup.getSetupToken();
+	if (token === null) {
+		return true;
+	}
+	if (typeof given !== 'string' || given.length !== token.length) {
+		return false;
+	}
+	return crypto.timingSafeEqual(Buffer.from(given), Buffer.from(token));
+};
+
+/**
+ * What each step does, given the payload of its request. The object they resolve with is added to the
+ * response, and isn't kept with the progress.
+ */
+const steps = {
+
+	/**
+	 * Checks the database can be queried and every migration has run
+	 *
+	 * @returns {Promise}
+	 */
+	database: () => {
+		return db.raw('SELECT 1')
+			.then(() => {
+				return db.migrate.list(migrate.MIGRATIONS);
+			})
+			.then((lists) => {
+				if (lists[1].length) {
+					throw new error.ValidationError(lists[1].length + ' database migrations haven\'t run, restart to run them');
+				}
+				return db.migrate.currentVersion();
+			})
+			.then((version) => {
+				return {
+					database: {
+						engine:  config.get('database').engine,
+						version: version
+					}
+				};
+			});
+	},
+
+	/**
+	 * Checks the JWT keys can sign and verify tokens, replacing them when asked to if they can't
+	 *
+	 * @param   {Object}   data
+	 * @param   {Boolean}  [data.regenerate]
+	 * @returns {Promise}
+	 */
+	keys: (data) => {
+		return Promise.resolve()
+			.then(() => {
+				if (config.getKeysError() !== null && data.regenerate) {
+					config.regenerateKeys();
+				}
+
+				const keys_error = config.getKeysError();
+				if (keys_error !== null) {
+					throw new error.KeysUnavailableError(keys_error + '. Send {"regenerate": true} to replace them');
+				}
+
+				const key = config.getSigningKey();
+				return {
+					keys: {
+						alg: key.alg,
+						kid: key.kid
+					}
+				};
+			});
+	},
+
+	/**
+	 * Creates the first admin, and signs them in to do the rest of the steps
+	 *
+	 * @param   {Object}  data
+	 * @param   {String}  data.email
+	 * @param   {String}  data.password
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.nickname]
+	 * @returns {Promise}
+	 */
+	admin: (data) => {
+		return userModel
+			.query()
+			.select('id')
+			.where('is_deleted', 0)
+			.first()
+			.then((row) => {
+				if (row) {
+					throw new error.ValidationError('There is already a user, sign in as them to carry on');
+				}
+
+				return internalSetup.createAdmin(data);
+			})
+			.then(() => {
+				return internalToken.getTokenFromEmail({
+					identity: data.email,
+					secret:   data.password
+				}, 'setup');
+			})
+			.then((token) => {
+				return {token: token};
+			});
+	},
+
+	/**
+	 * Changes settings from their defaults, one at a time as most of them reload nginx
+	 *
+	 * @param   {Object}  data
+	 * @param   {Object}  [data.settings]  by setting id, each with a value and/or meta
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	settings: (data, access) => {
+		const settings = data.settings || {};
+
+		return Object.keys(settings).reduce((promise, id) => {
+			return promise.then(() => {
+				return internalSetting.update(access, Object.assign({id: id}, settings[id]));
+			});
+		}, Promise.resolve())
+			.then(() => {
+				return {settings: Object.keys(settings)};
+			});
+	},
+
+	/**
+	 * Adds a few hosts to look around with, on .localhost names that can't clash with real ones
+	 *
+	 * @param   {Object}   data
+	 * @param   {Boolean}  data.create
+	 * @param   {Access}   access
+	 * @returns {Promise}
+	 */
+	demo: (data, access) => {
+		return internalProxyHost.create(access, {
+			domain_names:   ['demo.localhost'],
+			forward_scheme: 'http',
+			forward_host:   '127.0.0.1',
+			forward_port:   81,
+			meta:           {}
+		})
+			.then((proxy_host) => {
+				return internalDeadHost.create(access, {
+					domain_names: ['gone.demo.localhost'],
+					meta:         {}
+				})
+					.then((dead_host) => {
+						return {
+							demo: {
+								proxy_host_id: proxy_host.id,
+								dead_host_id:  dead_host.id
+							}
+						};
+					});
+			});
+	}
+};
+
+const internalSetup = {
+
+	STEPS: STEPS,
+
+	/**
+	 * @returns {Boolean}  whether the first admin is left for the setup wizard to create, rather than made at startup
+	 */
+	isWizard: () => {
+		const setup = bootstrap.get('setup') || {};
+		if (typeof process.env.SETUP_WIZARD !== 'undefined') {
+			return ['1', 'true', 'yes'].indexOf(process.env.SETUP_WIZARD.toLowerCase()) !== -1;
+		}
+		return setup.wizard === true;
+	},
+
+	/**
+	 * @returns {String|null}  what installers have to send in X-Setup-Token until there's an admin
+	 */
+	getSetupToken: () => {
+		const setup = bootstrap.get('setup') || {};
+		return process.env.SETUP_TOKEN || setup.token || null;
+	},
+
+	/**
+	 * Creates a user with every permission
+	 *
+	 * @param   {Object}  data
+	 * @param   {String}  data.email
+	 * @param   {String}  [data.password]  changeme when there isn't one
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.nickname]
+	 * @returns {Promise}
+	 */
+	createAdmin: (data) => {
+		return userModel
+			.query()
+			.insertAndFetch({
+				is_deleted: 0,
+				email:      data.email,
+				name:       data.name || 'Administrator',
+				nickname:   data.nickname || 'Admin',
+				avatar:     '',
+				roles:      ['admin'],
+			})
+			.then((user) => {
+				return authModel
+					.query()
+					.insert({
+						user_id: user.id,
+						type:    'password',
+						secret:  data.password || 'changeme',
+						meta:    {},
+					})
+					.then(() => {
+						return userPermissionModel.query().insert({
+							user_id:           user.id,
+							visibility:        'all',
+							proxy_hosts:       'manage',
+							redirection_hosts: 'manage',
+							dead_hosts:        'manage',
+							streams:           'manage',
+							access_lists:      'manage',
+							certificates:      'manage',
+						});
+					})
+					.then(() => {
+						return user;
+					});
+			});
+	},
+
+	/**
+	 * Reads the progress of the setup at startup. Instances that already have a user, or that were
+	 * set up without the wizard, have nothing left to do.
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		state = readState();
+
+		return userModel
+			.query()
+			.select('id')
+			.where('is_deleted', 0)
+			.first()
+			.then((row) => {
+				if (state === null) {
+					state = newState();
+				}
+
+				if (state.completed_on === null && (row || !internalSetup.isWizard())) {
+					STEPS.forEach((step) => {
+						if (state.steps[step].status !== 'done') {
+							state.steps[step] = {
+								status:     step === 'demo' ? 'skipped' : 'done',
+								updated_on: new Date().toISOString(),
+								error:      null
+							};
+						}
+					});
+					state.completed_on = new Date().toISOString();
+				}
+
+				writeState();
+
+				if (state.completed_on === null) {
+					logger.info('Setup is waiting at the ' + internalSetup.getState().next + ' step, see GET /api/setup');
+				}
+			});
+	},
+
+	/**
+	 * @returns {Object}
+	 */
+	getState: () => {
+		if (state === null) {
+			state = readState() || newState();
+		}
+
+		return {
+			complete:     state.completed_on !== null,
+			started_on:   state.started_on,
+			completed_on: state.completed_on,
+			next:         STEPS.find((step) => ['done', 'skipped'].indexOf(state.steps[step].status) === -1) || null,
+			steps:        STEPS.map((step) => {
+				return Object.assign({name: step}, state.steps[step]);
+			})
+		};
+	},
+
+	/**
+	 * Does one step of the setup. Until the admin is created they're open to anyone with the setup token,
+	 * after that only to admins.
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  step
+	 * @param   {Object}  data
+	 * @param   {String}  [setup_token]  from the X-Setup-Token header
+	 * @returns {Promise}
+	 */
+	run: (access, step, data, setup_token) => {
+		const current = internalSetup.getState();
+		const index   = STEPS.indexOf(step);
+		let started   = false;
+
+		return Promise.resolve()
+			.then(() => {
+				if (current.complete) {
+					throw new error.ValidationError('Setup is already complete');
+				}
+				if (index === -1) {
+					throw new error.ItemNotFoundError(step);
+				}
+				if (running !== null) {
+					throw new error.ValidationError('The ' + running + ' step is still running');
+				}
+
+				const waiting = STEPS.slice(0, index).find((item) => ['done', 'skipped'].indexOf(state.steps[item].status) === -1);
+				if (typeof waiting !== 'undefined') {
+					throw new error.ValidationError('The ' + waiting + ' step has to be done first');
+				}
+				if (step === 'admin' && state.steps.admin.status === 'done') {
+					throw new error.ValidationError('The admin was already created, sign in as them to carry on');
+				}
+
+				if (state.steps.admin.status === 'done') {
+					return access.can('system:setup');
+				}
+				if (!isSetupToken(setup_token)) {
+					throw new error.PermissionError('The X-Setup-Token header doesn\'t have the setup token');
+				}
+			})
+			.then(() => {
+				running = step;
+				started = true;
+
+				if (step === 'demo' && !data.create) {
+					setStatus(step, 'skipped');
+					return {};
+				}
+
+				return steps[step](data, access)
+					.then((result) => {
+						setStatus(step, 'done');
+						logger.info('Setup step done: ' + step);
+						return result;
+					}, (err) => {
+						setStatus(step, 'failed', err);
+						logger.warn('Setup step ' + step + ' failed: ' + err.message);
+						throw err;
+					});
+			})
+			.then((result) => {
+				running = null;
+				return Object.assign({setup: internalSetup.getState()}, result);
+			}, (err) => {
+				if (started) {
+					running = null;
+				}
+				throw err;
+			});
+	}
+};
+
+module.exports = internalSetup;
@@ -0,0 +1,577 @@
+const _                  = require('lodash');
+const fs                 = require('fs');
+const os                 = require('os');
+const net                = require('net');
+const path               = require('path');
+const crypto             = require('crypto');
+const childProcess       = require('child_process');
+const config             = require('../lib/config');
+const logger             = require('../logger').nginx;
+const error              = require('../lib/error');
+const utils              = require('../lib/utils');
+const sshTunnelModel     = require('../models/ssh_tunnel');
+const proxyHostModel     = require('../models/proxy_host');
+const internalAuditLog   = require('./audit-log');
+const internalUnixSocket = require('./unix-socket');
+const internalDocker     = require('./docker');
+
+// The key the private keys are encrypted with, beside the one of the deploy hooks
+const SECRET_FILE = config.getPath('ssh') + '/tunnels.key';
+
+// Outside the data volume, so the decrypted keys and the sockets go with the container
+const RUN_DIR = '/run/npm-ssh';
+
+// Seconds before a tunnel that closed is started again, doubling each time it closes soon after
+const RESTART_DELAY     = 5;
+const RESTART_DELAY_MAX = 300;
+
+// A tunnel up for this long starts its restarts over
+const STABLE_AFTER = 60000;
+
+const CIPHER = 'aes-256-gcm';
+
+// The ssh processes by tunnel id, {child, key, forwards, started_on, error, restarts, timer}
+let processes = {};
+
+function omissions () {
+	return ['is_deleted', 'private_key'];
+}
+
+const internalSshTunnel = {
+
+	intervalTimeout:    1000 * 30, // 30 seconds
+	interval:           null,
+	intervalProcessing: false,
+
+	/**
+	 * Every replica has its own nginx, so its own tunnels
+	 */
+	initTimer: () => {
+		logger.info('SSH Tunnel Timer initialized');
+		internalSshTunnel.interval = setInterval(internalSshTunnel.processTunnels, internalSshTunnel.intervalTimeout);
+		internalSshTunnel.processTunnels();
+	},
+
+	/**
+	 * Triggered by a timer, this starts and stops tunnels for the proxy hosts that were changed since
+	 *
+	 * @returns {Promise}
+	 */
+	processTunnels: () => {
+		if (internalSshTunnel.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalSshTunnel.intervalProcessing = true;
+
+		return internalSshTunnel.sync()
+			.then(() => {
+				internalSshTunnel.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalSshTunnel.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * @returns {Buffer}  made the first time it's needed
+	 */
+	getSecret: () => {
+		if (!fs.existsSync(SECRET_FILE)) {
+			fs.mkdirSync(path.dirname(SECRET_FILE), {recursive: true});
+			fs.writeFileSync(SECRET_FILE, crypto.randomBytes(32).toString('base64'), {mode: 0o600});
+		}
+		return Buffer.from(fs.readFileSync(SECRET_FILE, {encoding: 'utf8'}).trim(), 'base64');
+	},
+
+	/**
+	 * @param   {String}  text
+	 * @returns {String}  the iv, tag and ciphertext in base64
+	 */
+	encrypt: (text) => {
+		const iv     = crypto.randomBytes(12);
+		const cipher = crypto.createCipheriv(CIPHER, internalSshTunnel.getSecret(), iv);
+		const data   = Buffer.concat([cipher.update(text, 'utf8'), cipher.final()]);
+		return Buffer.concat([iv, cipher.getAuthTag(), data]).toString('base64');
+	},
+
+	/**
+	 * @param   {String}  value  from encrypt
+	 * @returns {String}
+	 */
+	decrypt: (value) => {
+		const buffer   = Buffer.from(value, 'base64');
+		const decipher = crypto.createDecipheriv(CIPHER, internalSshTunnel.getSecret(), buffer.subarray(0, 12));
+		decipher.setAuthTag(buffer.subarray(12, 28));
+		return Buffer.concat([decipher.update(buffer.subarray(28)), decipher.final()]).toString('utf8');
+	},
+
+	/**
+	 * @param   {Number}  host_id
+	 * @returns {String}  where the tunnel of a proxy host is reached, ie: /run/npm-ssh/proxy-host-1.sock
+	 */
+	getSocket: (host_id) => {
+		return RUN_DIR + '/proxy-host-' + host_id + '.sock';
+	},
+
+	/**
+	 * Points a proxy host going through a tunnel at the socket of its end of it, before its config is written
+	 *
+	 * @param   {Object}  host
+	 */
+	apply: (host) => {
+		if (host.ssh_tunnel_id > 0 && host.id) {
+			// Still the name the upstream's certificate has
+			host.tunnelled_host = host.forward_host;
+			host.forward_host   = 'unix:' + internalSshTunnel.getSocket(host.id);
+		}
+	},
+
+	/**
+	 * Checks the private key with ssh-keygen, which also gives its public key
+	 *
+	 * @param   {String}  private_key
+	 * @returns {Promise}  resolves with the public key
+	 */
+	checkPrivateKey: (private_key) => {
+		const dir  = fs.mkdtempSync(path.join(os.tmpdir(), 'npm-ssh-'));
+		const file = path.join(dir, 'key');
+		fs.writeFileSync(file, private_key.trim() + '\n', {mode: 0o600});
+
+		// An empty passphrase, as nothing could type one in
+		return utils.execFile('ssh-keygen', ['-y', '-P', '', '-f', file])
+			.then((public_key) => {
+				return public_key.trim();
+			}, (err) => {
+				throw new error.ValidationError('The private key can\'t be used, it needs to be one without a passphrase (' + (err.stderr || err.message).trim() + ')', err);
+			})
+			.finally(() => {
+				fs.rmSync(dir, {recursive: true, force: true});
+			});
+	},
+
+	/**
+	 * @param   {String}  host_key  ie: ssh-ed25519 AAAA...
+	 * @returns {Promise}
+	 */
+	validateHostKey: (host_key) => {
+		if (host_key && !/^(ssh|ecdsa|sk)-[a-z0-9@.-]+ [A-Za-z0-9+/]+={0,3}$/.test(host_key.trim())) {
+			return Promise.reject(new error.ValidationError('The host key needs to be a single key as in known_hosts, ie: ssh-ed25519 AAAA...'));
+		}
+		return Promise.resolve();
+	},
+
+	/**
+	 * A proxy host going through a tunnel forwards to a host the jump host can reach, not a socket or container of ours
+	 *
+	 * @param   {Object}  data  the host, with what it's updated with
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		if (!data.ssh_tunnel_id) {
+			return Promise.resolve();
+		}
+
+		if (internalUnixSocket.isSocket(data.forward_host) || internalDocker.isContainer(data.forward_host)) {
+			return Promise.reject(new error.ValidationError('A proxy host going through an SSH tunnel can\'t forward to a unix socket or container'));
+		}
+
+		return sshTunnelModel
+			.query()
+			.where('id', data.ssh_tunnel_id)
+			.andWhere('is_deleted', 0)
+			.first()
+			.then((row) => {
+				if (!row) {
+					throw new error.ValidationError('There\'s no SSH tunnel #' + data.ssh_tunnel_id);
+				}
+			});
+	},
+
+	/**
+	 * @param   {Number}  id
+	 * @returns {Object}  ie: {state: 'up', error: null, since: '...'}
+	 */
+	getStatus: (id) => {
+		const running = processes[id];
+		if (!running) {
+			// Nothing forwards through it on this replica
+			return {state: 'idle', error: null, since: null};
+		}
+
+		return {
+			state: running.child ? 'up' : 'restarting',
+			error: running.error,
+			since: running.started_on.toISOString()
+		};
+	},
+
+	/**
+	 * @param   {Object}  row
+	 * @returns {Object}
+	 */
+	addStatus: (row) => {
+		row.status = internalSshTunnel.getStatus(row.id);
+		return row;
+	},
+
+	/**
+	 * @param   {Object}  row       the tunnel row
+	 * @param   {Array}   forwards  [{socket, host, port}]
+	 * @returns {Array}
+	 */
+	getArgs: (row, forwards) => {
+		let args = [
+			'-N',
+			'-o', 'BatchMode=yes',
+			'-o', 'ExitOnForwardFailure=yes',
+			'-o', 'ServerAliveInterval=15',
+			'-o', 'ServerAliveCountMax=3',
+			'-o', 'StreamLocalBindUnlink=yes',
+			'-o', 'StreamLocalBindMask=0111',
+			'-o', 'StrictHostKeyChecking=' + (row.host_key ? 'yes' : 'accept-new'),
+			'-o', 'UserKnownHostsFile=' + RUN_DIR + '/tunnel-' + row.id + '.known_hosts',
+			'-i', RUN_DIR + '/tunnel-' + row.id + '.key',
+			'-p', String(row.port)
+		];
+
+		forwards.forEach((forward) => {
+			args.push('-L', forward.socket + ':' + (net.isIPv6(forward.host) ? '[' + forward.host + ']' : forward.host) + ':' + forward.port);
+		});
+
+		args.push(row.username + '@' + row.host);
+		return args;
+	},
+
+	/**
+	 * Starts ssh for a tunnel, and again whenever it closes until it's stopped
+	 *
+	 * @param   {Object}  row       the tunnel row
+	 * @param   {Array}   forwards  [{socket, host, port}]
+	 * @param   {String}  key       what it was started for, so it's only started again when that changes
+	 * @param   {Number}  [restarts]
+	 */
+	start: (row, forwards, key, restarts) => {
+		fs.mkdirSync(RUN_DIR, {recursive: true, mode: 0o700});
+		fs.writeFileSync(RUN_DIR + '/tunnel-' + row.id + '.key', internalSshTunnel.decrypt(row.private_key).trim() + '\n', {mode: 0o600});
+		if (row.host_key) {
+			const name = row.port === 22 ? row.host : '[' + row.host + ']:' + row.port;
+			fs.writeFileSync(RUN_DIR + '/tunnel-' + row.id + '.known_hosts', name + ' ' + row.host_key.trim() + '\n');
+		}
+
+		const running = {
+			child:      null,
+			key:        key,
+			forwards:   forwards,
+			started_on: new Date(),
+			error:      null,
+			restarts:   restarts || 0,
+			timer:      null
+		};
+		processes[row.id] = running;
+
+		let stderr  = '';
+		const child = childProcess.spawn('ssh', internalSshTunnel.getArgs(row, forwards), {stdio: ['ignore', 'ignore', 'pipe']});
+
+		running.child = child;
+		child.stderr.on('data', (data) => {
+			stderr = (stderr + data.toString()).slice(-2000);
+		});
+		child.on('error', (err) => {
+			stderr += err.message;
+		});
+		child.on('close', (code) => {
+			// Stopped, or started again for other hosts
+			if (processes[row.id] !== running) {
+				return;
+			}
+
+			const lines = stderr.trim().split('\n').filter((line) => line.length && line.indexOf('Warning: Permanently added') === -1);
+			if (Date.now() - running.started_on.getTime() > STABLE_AFTER) {
+				running.restarts = 0;
+			}
+
+			const delay   = Math.min(RESTART_DELAY * Math.pow(2, running.restarts), RESTART_DELAY_MAX);
+			running.child = null;
+			running.error = lines.length ? lines[lines.length - 1] : 'ssh exited with ' + code;
+			logger.warn('SSH Tunnel #' + row.id + ' to ' + row.host + ' closed: ' + running.error + ', starting it again in ' + delay + 's');
+
+			running.timer = setTimeout(() => {
+				if (processes[row.id] === running) {
+					internalSshTunnel.start(row, forwards, key, running.restarts + 1);
+				}
+			}, delay * 1000);
+		});
+
+		logger.info('SSH Tunnel #' + row.id + ' to ' + row.host + ' started for ' + forwards.length + ' proxy host(s)');
+	},
+
+	/**
+	 * @param   {Number}  id
+	 */
+	stop: (id) => {
+		const running = processes[id];
+		if (!running) {
+			return;
+		}
+
+		delete processes[id];
+		clearTimeout(running.timer);
+		if (running.child) {
+			running.child.kill();
+		}
+
+		fs.rmSync(RUN_DIR + '/tunnel-' + id + '.key', {force: true});
+		running.forwards.forEach((forward) => {
+			fs.rmSync(forward.socket, {force: true});
+		});
+		logger.info('SSH Tunnel #' + id + ' stopped');
+	},
+
+	/**
+	 * Runs a tunnel for each one the enabled proxy hosts go through, started again when it or its hosts changed
+	 *
+	 * @returns {Promise}
+	 */
+	sync: () => {
+		return Promise.all([
+			sshTunnelModel
+				.query()
+				.where('is_deleted', 0),
+			proxyHostModel
+				.query()
+				.select('id', 'forward_host', 'forward_port', 'ssh_tunnel_id')
+				.where('is_deleted', 0)
+				.andWhere('enabled', 1)
+				.andWhere('ssh_tunnel_id', '>', 0)
+		])
+			.then(([rows, hosts]) => {
+				const by_tunnel = _.groupBy(hosts, 'ssh_tunnel_id');
+
+				Object.keys(processes).forEach((id) => {
+					if (!_.find(rows, {id: parseInt(id, 10)}) || !by_tunnel[id]) {
+						internalSshTunnel.stop(id);
+					}
+				});
+
+				rows.forEach((row) => {
+					const forwards = _.sortBy(by_tunnel[row.id] || [], 'id').map((host) => {
+						return {
+							socket: internalSshTunnel.getSocket(host.id),
+							host:   host.forward_host,
+							port:   host.forward_port
+						};
+					});
+					if (!forwards.length) {
+						return;
+					}
+
+					const key = JSON.stringify([row.modified_on, forwards]);
+					if (processes[row.id] && processes[row.id].key === key) {
+						return;
+					}
+
+					internalSshTunnel.stop(row.id);
+					try {
+						internalSshTunnel.start(row, forwards, key);
+					} catch (err) {
+						logger.error('SSH Tunnel #' + row.id + ' could not be started: ' + err.message);
+					}
+				});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  data.host
+	 * @param   {Number}  [data.port]
+	 * @param   {String}  data.username
+	 * @param   {String}  data.private_key
+	 * @param   {String}  [data.host_key]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('ssh_tunnels:create', data)
+			.then(() => {
+				return internalSshTunnel.validateHostKey(data.host_key);
+			})
+			.then(() => {
+				return internalSshTunnel.checkPrivateKey(data.private_key);
+			})
+			.then((public_key) => {
+				return sshTunnelModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						name:          data.name,
+						host:          data.host,
+						port:          data.port || 22,
+						username:      data.username,
+						private_key:   internalSshTunnel.encrypt(data.private_key),
+						host_key:      (data.host_key || '').trim(),
+						meta:          {
+							public_key: public_key
+						}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'ssh-tunnel',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return internalSshTunnel.addStatus(row);
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.private_key]
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		let patch = _.pick(data, ['name', 'host', 'port', 'username']);
+
+		return access.can('ssh_tunnels:update', data.id)
+			.then(() => {
+				return internalSshTunnel.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalSshTunnel.validateHostKey(data.host_key)
+					.then(() => {
+						if (typeof data.host_key !== 'undefined') {
+							patch.host_key = data.host_key.trim();
+						}
+
+						if (typeof data.private_key === 'undefined') {
+							return;
+						}
+
+						return internalSshTunnel.checkPrivateKey(data.private_key)
+							.then((public_key) => {
+								patch.private_key = internalSshTunnel.encrypt(data.private_key);
+								patch.meta        = _.assign({}, row.meta, {public_key: public_key});
+							});
+					})
+					.then(() => {
+						return sshTunnelModel
+							.query()
+							.patchAndFetchById(row.id, patch)
+							.then(utils.omitRow(omissions()));
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'ssh-tunnel',
+					object_id:   saved_row.id,
+					meta:        _.omit(data, ['private_key'])
+				})
+					.then(internalSshTunnel.sync)
+					.then(() => {
+						return internalSshTunnel.addStatus(saved_row);
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('ssh_tunnels:delete', data.id)
+			.then(() => {
+				return internalSshTunnel.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return proxyHostModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('ssh_tunnel_id', row.id)
+					.then((hosts) => {
+						if (hosts.length) {
+							throw new error.ValidationError('SSH tunnel is used by ' + hosts.length + ' proxy host(s): ' + hosts.map((host) => '#' + host.id).join(', '));
+						}
+
+						return sshTunnelModel
+							.query()
+							.where('id', row.id)
+							.patch({
+								is_deleted: 1
+							});
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'ssh-tunnel',
+							object_id:   row.id,
+							meta:        _.omit(row, ['status'])
+						});
+					});
+			})
+			.then(internalSshTunnel.sync)
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('ssh_tunnels:get', data.id)
+			.then(() => {
+				return sshTunnelModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first()
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return internalSshTunnel.addStatus(row);
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		return access.can('ssh_tunnels:list')
+			.then(() => {
+				return sshTunnelModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC')
+					.then(utils.omitRows(omissions()));
+			})
+			.then((rows) => {
+				return rows.map(internalSshTunnel.addStatus);
+			});
+	}
+};
+
+module.exports = internalSshTunnel;
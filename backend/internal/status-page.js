@@ -0,0 +1,540 @@
+const _                    = require('lodash');
+const fs                   = require('fs');
+const moment               = require('moment');
+const error                = require('../lib/error');
+const utils                = require('../lib/utils');
+const logger               = require('../logger').nginx;
+const statusPageModel      = require('../models/status_page');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const certificateModel     = require('../models/certificate');
+const projectModel         = require('../models/project');
+const internalAuditLog     = require('./audit-log');
+const internalHost         = require('./host');
+const internalNginx        = require('./nginx');
+const internalDiagnose     = require('./diagnose');
+const internalWireguard    = require('./wireguard');
+
+const MODELS = {
+	proxy_host:       proxyHostModel,
+	redirection_host: redirectionHostModel,
+	dead_host:        deadHostModel
+};
+
+// Anyone can load a status page, so the hosts are only checked this often however many times it is
+const CHECK_SECONDS = 60;
+
+// A certificate expiring sooner than this shows as expiring
+const EXPIRING_DAYS = 14;
+
+let checked = {};
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalStatusPage = {
+
+	/**
+	 * @param   {Object}  data
+	 * @param   {Number}  [id]  of the page being updated
+	 * @returns {Promise}  resolves with the domain names in their unicode form
+	 */
+	validate: (data, id) => {
+		let domain_names;
+		try {
+			domain_names = internalHost.normaliseDomainNames(data.domain_names || []);
+		} catch (err) {
+			return Promise.reject(err);
+		}
+
+		return statusPageModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('slug', data.slug)
+			.andWhereNot('id', id || 0)
+			.first()
+			.then((existing) => {
+				if (existing) {
+					throw new error.ValidationError('Status page #' + existing.id + ' already has the address ' + data.slug);
+				}
+
+				if (data.project_id) {
+					return projectModel
+						.query()
+						.where('is_deleted', 0)
+						.andWhere('id', data.project_id)
+						.first()
+						.then((project) => {
+							if (!project) {
+								throw new error.ValidationError('Project #' + data.project_id + ' does not exist');
+							}
+						});
+				}
+
+				return Promise.all((data.hosts || []).map((item) => {
+					return MODELS[item.type]
+						.query()
+						.where('is_deleted', 0)
+						.andWhere('id', item.id)
+						.first()
+						.then((host) => {
+							if (!host) {
+								throw new error.ValidationError(item.type.replace('_', ' ') + ' #' + item.id + ' does not exist');
+							}
+						});
+				}));
+			})
+			.then(() => {
+				if (!data.certificate_id) {
+					return;
+				}
+
+				if (!domain_names.length) {
+					throw new error.ValidationError('A certificate can only be used with domain names');
+				}
+
+				return certificateModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.certificate_id)
+					.first()
+					.then((certificate) => {
+						if (!certificate) {
+							throw new error.ValidationError('Certificate #' + data.certificate_id + ' does not exist');
+						}
+					});
+			})
+			.then(() => {
+				return internalHost.assertDomainNamesAvailable(domain_names, 'status', id);
+			})
+			.then(() => {
+				return domain_names;
+			});
+	},
+
+	/**
+	 * Serves the page on its own domain names, or removes the config when it doesn't have any.
+	 * If nginx doesn't accept the config it's removed again.
+	 *
+	 * @param   {Object}  row
+	 * @returns {Promise}
+	 */
+	configure: (row) => {
+		if (!row.domain_names.length) {
+			return internalNginx.deleteConfig('status_page', row)
+				.then(internalNginx.reload);
+		}
+
+		return statusPageModel
+			.query()
+			.where('id', row.id)
+			.withGraphFetched('[certificate]')
+			.first()
+			.then((page) => {
+				return internalNginx.generateConfig('status_page', page);
+			})
+			.then(internalNginx.reload)
+			.catch((err) => {
+				logger.error('Could not configure status page #' + row.id + ': ' + err.message);
+
+				return internalNginx.deleteConfig('status_page', row)
+					.then(internalNginx.reload)
+					.then(() => {
+						throw new error.ValidationError('Could not reconfigure Nginx for the status page. Please check logs.');
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('status_pages:create', data)
+			.then(() => {
+				return internalStatusPage.validate(data);
+			})
+			.then((domain_names) => {
+				return statusPageModel
+					.query()
+					.insertAndFetch({
+						owner_user_id:  access.token.getUserId(1),
+						name:           data.name,
+						slug:           data.slug,
+						description:    data.description || '',
+						project_id:     data.project_id || 0,
+						hosts:          data.project_id ? [] : data.hosts || [],
+						domain_names:   domain_names,
+						certificate_id: data.certificate_id || 0,
+						ssl_forced:     !!data.certificate_id && !!data.ssl_forced,
+						meta:           {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				return internalStatusPage.configure(row)
+					.catch((err) => {
+						// The page is still there without its domain names
+						return statusPageModel
+							.query()
+							.patchAndFetchById(row.id, {domain_names: [], certificate_id: 0, ssl_forced: false})
+							.then(() => {
+								throw err;
+							});
+					})
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'status-page',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		let previous = null;
+
+		return access.can('status_pages:update', data.id)
+			.then(() => {
+				return internalStatusPage.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.id !== data.id) {
+					// Sanity check that something crazy hasn't happened
+					throw new error.InternalValidationError('Status page could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
+				}
+
+				previous = row;
+				return internalStatusPage.validate(_.assign({}, row, data), row.id);
+			})
+			.then((domain_names) => {
+				let changes = _.pick(data, ['name', 'slug', 'description', 'project_id', 'hosts', 'certificate_id', 'ssl_forced']);
+				if (typeof data.domain_names !== 'undefined') {
+					changes.domain_names = domain_names;
+				}
+				if (changes.project_id) {
+					changes.hosts = [];
+				}
+				if (typeof changes.ssl_forced !== 'undefined' || typeof changes.certificate_id !== 'undefined') {
+					changes.ssl_forced = !!(typeof changes.certificate_id !== 'undefined' ? changes.certificate_id : previous.certificate_id) &&
+						!!(typeof changes.ssl_forced !== 'undefined' ? changes.ssl_forced : previous.ssl_forced);
+				}
+
+				return statusPageModel
+					.query()
+					.patchAndFetchById(data.id, changes)
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				delete checked[row.id];
+
+				return internalStatusPage.configure(row)
+					.catch((err) => {
+						// The page goes back to how it was served
+						return statusPageModel
+							.query()
+							.patchAndFetchById(row.id, _.pick(previous, ['slug', 'domain_names', 'certificate_id', 'ssl_forced']))
+							.then((restored) => {
+								return internalStatusPage.configure(restored)
+									.catch(() => {});
+							})
+							.then(() => {
+								throw err;
+							});
+					})
+					.then(() => {
+						return row;
+					});
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'status-page',
+					object_id:   row.id,
+					meta:        data
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('status_pages:delete', data.id)
+			.then(() => {
+				return internalStatusPage.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return statusPageModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						delete checked[row.id];
+						return internalNginx.deleteConfig('status_page', row)
+							.then(internalNginx.reload);
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'status-page',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {Array}   [data.expand]
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('status_pages:get', data.id)
+			.then(() => {
+				let query = statusPageModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.allowGraph('[owner,certificate]')
+					.first();
+
+				if (typeof data.expand !== 'undefined' && data.expand !== null) {
+					query.withGraphFetched('[' + data.expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return row;
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Array}   [expand]
+	 * @returns {Promise}
+	 */
+	getAll: (access, expand) => {
+		return access.can('status_pages:list')
+			.then(() => {
+				let query = statusPageModel
+					.query()
+					.where('is_deleted', 0)
+					.allowGraph('[owner,certificate]')
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (typeof expand !== 'undefined' && expand !== null) {
+					query.withGraphFetched('[' + expand.join(', ') + ']');
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			});
+	},
+
+	/**
+	 * The hosts on a page with their certificates, the ones of its project or the ones picked for it
+	 *
+	 * @param   {Object}  page
+	 * @returns {Promise}  resolves with ie: [{type: 'proxy_host', name: 'example.com', host: {}}]
+	 */
+	getHosts: (page) => {
+		if (page.project_id) {
+			return Promise.all(_.map(MODELS, (model, type) => {
+				return model
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('project_id', page.project_id)
+					.withGraphFetched('[certificate]')
+					.orderBy('id', 'ASC')
+					.then((rows) => {
+						return rows.map((host) => {
+							return {type: type, name: host.domain_names[0], host: host};
+						});
+					});
+			}))
+				.then(_.flatten);
+		}
+
+		return Promise.all(page.hosts.map((item) => {
+			return MODELS[item.type]
+				.query()
+				.where('is_deleted', 0)
+				.andWhere('id', item.id)
+				.withGraphFetched('[certificate]')
+				.first()
+				.then((host) => {
+					return host ? {type: item.type, name: item.name || host.domain_names[0], host: host} : null;
+				});
+		}))
+			.then(_.compact);
+	},
+
+	/**
+	 * Up when nginx serves the host and, for a proxy host, its forward host takes connections
+	 *
+	 * @param   {String}  type
+	 * @param   {Object}  host
+	 * @returns {Promise}  resolves with up, down or disabled
+	 */
+	checkHost: (type, host) => {
+		if (!host.enabled) {
+			return Promise.resolve('disabled');
+		}
+		if (host.meta && host.meta.nginx_online === false) {
+			return Promise.resolve('down');
+		}
+		if (type !== 'proxy_host') {
+			return Promise.resolve('up');
+		}
+		if (internalWireguard.isDown(host)) {
+			return Promise.resolve('down');
+		}
+
+		return internalDiagnose.resolve(host.forward_host)
+			.then((resolved) => {
+				return internalDiagnose.connect(resolved.addresses[0], host.forward_port);
+			})
+			.then(() => 'up', () => 'down');
+	},
+
+	/**
+	 * @param   {Object}  [certificate]
+	 * @returns {Object|null}
+	 */
+	getCertificate: (certificate) => {
+		if (!certificate || !certificate.expires_on) {
+			return null;
+		}
+
+		const days_left = moment(certificate.expires_on).diff(moment(), 'days');
+
+		return {
+			expires_on: moment(certificate.expires_on).format('YYYY-MM-DD'),
+			days_left:  Math.max(days_left, 0),
+			status:     moment(certificate.expires_on).isBefore(moment()) ? 'expired' : (days_left < EXPIRING_DAYS ? 'expiring' : 'valid')
+		};
+	},
+
+	/**
+	 * What a page shows, without anything about where the hosts forward to
+	 *
+	 * @param   {Object}  page
+	 * @returns {Promise}
+	 */
+	check: (page) => {
+		return internalStatusPage.getHosts(page)
+			.then((items) => {
+				return Promise.all(items.map((item) => {
+					return internalStatusPage.checkHost(item.type, item.host)
+						.then((status) => {
+							return {
+								name:        item.name,
+								status:      status,
+								certificate: internalStatusPage.getCertificate(item.host.certificate)
+							};
+						});
+				}));
+			})
+			.then((hosts) => {
+				const enabled = hosts.filter((host) => host.status !== 'disabled');
+				const down    = enabled.filter((host) => host.status === 'down');
+
+				return {
+					name:        page.name,
+					description: page.description,
+					status:      !down.length ? 'up' : (down.length === enabled.length ? 'down' : 'degraded'),
+					checked_on:  moment().utc().format('YYYY-MM-DD HH:mm:ss'),
+					hosts:       hosts
+				};
+			});
+	},
+
+	/**
+	 * The status of a page for anyone, checked at most once a minute
+	 *
+	 * @param   {Object}  data
+	 * @param   {String}  data.slug
+	 * @returns {Promise}
+	 */
+	getPublic: (data) => {
+		return statusPageModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('slug', data.slug)
+			.first()
+			.then((page) => {
+				if (!page) {
+					throw new error.ItemNotFoundError(data.slug);
+				}
+
+				const cached = checked[page.id];
+				if (cached && cached.expires > Date.now()) {
+					return cached.result;
+				}
+
+				const result = internalStatusPage.check(page);
+				checked[page.id] = {expires: Date.now() + CHECK_SECONDS * 1000, result: result};
+
+				// A check that failed is tried again on the next visit
+				result.catch(() => {
+					delete checked[page.id];
+				});
+
+				return result;
+			});
+	},
+
+	/**
+	 * @param   {Object}  data
+	 * @param   {String}  data.slug
+	 * @returns {Promise}  resolves with the html of the page
+	 */
+	renderPublic: (data) => {
+		return internalStatusPage.getPublic(data)
+			.then((status) => {
+				const template = fs.readFileSync(__dirname + '/../templates/status_page.html', {encoding: 'utf8'});
+				return utils.getRenderEngine().parseAndRender(template, status);
+			});
+	}
+};
+
+module.exports = internalStatusPage;
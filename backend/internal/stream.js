@@ -1,10 +1,24 @@
-const _                = require('lodash');
-const error            = require('../lib/error');
-const utils            = require('../lib/utils');
-const streamModel      = require('../models/stream');
-const internalNginx    = require('./nginx');
-const internalAuditLog = require('./audit-log');
-const {castJsonIfNeed} = require('../lib/helpers');
+const _                     = require('lodash');
+const error                 = require('../lib/error');
+const utils                 = require('../lib/utils');
+const readCache             = require('../lib/read-cache');
+const streamModel           = require('../models/stream');
+const accessListModel       = require('../models/access_list');
+const internalNginx         = require('./nginx');
+const internalAuditLog      = require('./audit-log');
+const internalHost          = require('./host');
+const internalProject       = require('./project');
+const internalQuota         = require('./quota');
+const internalTag           = require('./tag');
+const internalLock          = require('./lock');
+const internalProxyProtocol = require('./proxy-protocol');
+const internalListen        = require('./listen');
+const internalHostPorts     = require('./host-ports');
+const internalView          = require('./view');
+const {castJsonIfNeed}      = require('../lib/helpers');
+
+// What lists are read from, a cached list is dropped when one of them is written to
+const LIST_TABLES = ['stream', 'user', 'access_list', 'access_list_client', 'project', 'project_permission', 'user_permission'];
 
 function omissions () {
 	return ['is_deleted'];
@@ -12,6 +26,34 @@ function omissions () {
 
 const internalStream = {
 
+	/**
+	 * Streams can't ask for a username and password, only the client rules of an access list apply to them.
+	 * Without any, the stream would refuse everyone.
+	 *
+	 * @param   {Object}  data
+	 * @returns {Promise}
+	 */
+	validateAccessList: (data) => {
+		if (!data.access_list_id) {
+			return Promise.resolve();
+		}
+
+		return accessListModel
+			.query()
+			.where('id', data.access_list_id)
+			.andWhere('is_deleted', 0)
+			.withGraphFetched('[clients]')
+			.first()
+			.then((access_list) => {
+				if (!access_list) {
+					throw new error.ValidationError('Access List #' + data.access_list_id + ' does not exist');
+				}
+				if (!access_list.clients.length) {
+					throw new error.ValidationError('The access list ' + access_list.name + ' has no client rules, which is all a stream can use');
+				}
+			});
+	},
+
 	/**
 	 * @param   {Access}  access
 	 * @param   {Object}  data
@@ -19,6 +61,21 @@ const internalStream = {
 	 */
 	create: (access, data) => {
 		return access.can('streams:create', data)
+			.then(() => {
+				return internalQuota.check(access, 'streams');
+			})
+			.then(() => {
+				return internalProxyProtocol.validateStream(data);
+			})
+			.then(() => {
+				return internalListen.validate(data.listen);
+			})
+			.then(() => {
+				return internalHostPorts.validateStream(data);
+			})
+			.then(() => {
+				return internalStream.validateAccessList(data);
+			})
 			.then((/*access_data*/) => {
 				// TODO: At this point the existing ports should have been checked
 				data.owner_user_id = access.token.getUserId(1);
@@ -71,9 +128,23 @@ const internalStream = {
 					throw new error.InternalValidationError('Stream could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
 				}
 
-				return streamModel
-					.query()
-					.patchAndFetchById(row.id, data)
+				internalLock.assertUnlocked(row, 'updated');
+
+				return internalProxyProtocol.validateStream(data, row)
+					.then(() => {
+						return internalListen.validate(data.listen);
+					})
+					.then(() => {
+						return internalHostPorts.validateStream(data, row);
+					})
+					.then(() => {
+						return internalStream.validateAccessList(data);
+					})
+					.then(() => {
+						return streamModel
+							.query()
+							.patchAndFetchById(row.id, data);
+					})
 					.then(utils.omitRow(omissions()))
 					.then((saved_row) => {
 						return internalNginx.configure(streamModel, 'stream', saved_row)
@@ -111,15 +182,18 @@ const internalStream = {
 
 		return access.can('streams:get', data.id)
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				let query = streamModel
 					.query()
 					.where('is_deleted', 0)
 					.andWhere('id', data.id)
-					.allowGraph('[owner]')
+					.allowGraph('[owner,access_list.[clients]]')
 					.first();
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (visibility) {
+					query.andWhere(visibility);
 				}
 
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
@@ -157,6 +231,8 @@ const internalStream = {
 					throw new error.ItemNotFoundError(data.id);
 				}
 
+				internalLock.assertUnlocked(row, 'deleted');
+
 				return streamModel
 					.query()
 					.where('id', row.id)
@@ -207,14 +283,14 @@ const internalStream = {
 					throw new error.ValidationError('Host is already enabled');
 				}
 
-				row.enabled = 1;
+				internalLock.assertUnlocked(row, 'enabled');
+
+				_.assign(row, {enabled: 1}, internalHost.getEnabledFields());
 
 				return streamModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 1
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
 						// Configure nginx
 						return internalNginx.configure(streamModel, 'stream', row);
@@ -225,7 +301,7 @@ const internalStream = {
 							action:      'enabled',
 							object_type: 'stream',
 							object_id:   row.id,
-							meta:        _.omit(row, omissions())
+							meta:        _.assign(_.omit(row, omissions()), {reason: String(data.reason || '').trim()})
 						});
 					});
 			})
@@ -253,14 +329,14 @@ const internalStream = {
 					throw new error.ValidationError('Host is already disabled');
 				}
 
-				row.enabled = 0;
+				internalLock.assertUnlocked(row, 'disabled');
+
+				_.assign(row, {enabled: 0}, internalHost.getDisabledFields(access, data.reason));
 
 				return streamModel
 					.query()
 					.where('id', row.id)
-					.patch({
-						enabled: 0
-					})
+					.patch(_.pick(row, ['enabled', 'disabled_reason', 'disabled_by_user_id', 'disabled_on']))
 					.then(() => {
 						// Delete Nginx Config
 						return internalNginx.deleteConfig('stream', row)
@@ -286,29 +362,50 @@ const internalStream = {
 	/**
 	 * All Streams
 	 *
-	 * @param   {Access}  access
-	 * @param   {Array}   [expand]
-	 * @param   {String}  [search_query]
+	 * @param   {Access}    access
+	 * @param   {Array}     [expand]
+	 * @param   {String}    [search_query]
+	 * @param   {Object}    [filter]
+	 * @param   {Number}    [filter.project_id]
+	 * @param   {Array}     [filter.tags]
+	 * @param   {Boolean}   [filter.enabled]
+	 * @param   {Object}    [filter.sort]  {field, direction}
+	 * @param   {Function}  [each]  given the rows a batch at a time instead of resolving with them all
 	 * @returns {Promise}
 	 */
-	getAll: (access, expand, search_query) => {
+	getAll: (access, expand, search_query, filter, each) => {
 		return access.can('streams:list')
 			.then((access_data) => {
+				return internalProject.getVisibilityFilter(access, access_data);
+			})
+			.then((visibility) => {
 				const query = streamModel
 					.query()
 					.where('is_deleted', 0)
 					.groupBy('id')
-					.allowGraph('[owner]')
-					.orderByRaw('CAST(incoming_port AS INTEGER) ASC');
+					.allowGraph('[owner,access_list.[clients]]')
+					.orderByRaw('CAST(incoming_port AS INTEGER) ASC')
+					.orderBy('id', 'ASC');
+
+				if (visibility) {
+					query.andWhere(visibility);
+				}
+
+				if (filter && typeof filter.project_id === 'number') {
+					query.andWhere('project_id', filter.project_id);
+				}
 
-				if (access_data.permission_visibility !== 'all') {
-					query.andWhere('owner_user_id', access.token.getUserId(1));
+				if (filter && filter.tags && filter.tags.length) {
+					internalTag.applyFilter(query, filter.tags);
 				}
 
+				internalView.applyFilter(query, 'stream', filter);
+
 				// Query is used for searching
 				if (typeof search_query === 'string' && search_query.length > 0) {
 					query.where(function () {
 						this.where(castJsonIfNeed('incoming_port'), 'like', `%${search_query}%`);
+						this.orWhere('notes', 'like', `%${search_query}%`);
 					});
 				}
 
@@ -316,7 +413,13 @@ const internalStream = {
 					query.withGraphFetched('[' + expand.join(', ') + ']');
 				}
 
-				return query.then(utils.omitRows(omissions()));
+				if (each) {
+					return utils.eachBatch(query, (rows) => each(utils.omitRows(omissions())(rows)));
+				}
+
+				return readCache.get(JSON.stringify(['stream', access.token.getUserId(0), expand, search_query, filter]), LIST_TABLES, () => {
+					return query.then(utils.omitRows(omissions()));
+				});
 			});
 	},
 
@@ -337,10 +440,12 @@ const internalStream = {
 			query.andWhere('owner_user_id', user_id);
 		}
 
-		return query.first()
-			.then((row) => {
-				return parseInt(row.count, 10);
-			});
+		return readCache.get(JSON.stringify(['stream-count', user_id, visibility]), ['stream'], () => {
+			return query.first()
+				.then((row) => {
+					return parseInt(row.count, 10);
+				});
+		});
 	}
 };
 
@@ -0,0 +1,44 @@
+const _ = require('lodash');
+
+// nginx always substitutes in html
+const DEFAULT_TYPE = 'text/html';
+
+/**
+ * Quotes a string for the config, nginx variables in it like $host are left to nginx
+ *
+ * @param   {String}  value
+ * @returns {String}
+ */
+const quote = (value) => {
+	return '"' + value.replace(/\\/g, '\\\\').replace(/"/g, '\\"') + '"';
+};
+
+const internalSubFilter = {
+
+	/**
+	 * What to write into the config of a custom location that rewrites the bodies of responses,
+	 * or null when it doesn't
+	 *
+	 * @param   {Object}  location
+	 * @returns {Object|null}  ie: {rules: [{find: '"http://app:8080"', replace: '"https://$host"'}], types: 'application/json', once: 'off'}
+	 */
+	getOptions: (location) => {
+		const sub_filter = location.sub_filter;
+		if (!sub_filter || !sub_filter.rules || !sub_filter.rules.length) {
+			return null;
+		}
+
+		return {
+			rules: sub_filter.rules.map((rule) => {
+				return {
+					find:    quote(rule.find),
+					replace: quote(rule.replace)
+				};
+			}),
+			types: _.without(_.uniq(sub_filter.types || []), DEFAULT_TYPE).join(' ') || null,
+			once:  sub_filter.once ? 'on' : 'off'
+		};
+	}
+};
+
+module.exports = internalSubFilter;
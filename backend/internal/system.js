@@ -0,0 +1,188 @@
+const _                = require('lodash');
+const loggers          = require('../logger');
+const logger           = loggers.global;
+const config           = require('../lib/config');
+const db               = require('../db');
+const migrate          = require('../migrate');
+const readCache        = require('../lib/read-cache');
+const internalAuditLog = require('./audit-log');
+
+let app    = null;
+let server = null;
+
+const internalSystem = {
+
+	/**
+	 * Starts the API on the port from the backend settings
+	 *
+	 * @param   {Object}  express_app
+	 * @returns {Promise}
+	 */
+	listen: (express_app) => {
+		app = express_app;
+
+		const port = config.getSetting('port');
+		return new Promise((resolve, reject) => {
+			const new_server = app.listen(port, () => {
+				logger.info('Backend PID ' + process.pid + ' listening on port ' + port + ' ...');
+				server = new_server;
+				resolve(server);
+			});
+			new_server.on('error', reject);
+		});
+	},
+
+	/**
+	 * @param {Function} callback
+	 */
+	close: (callback) => {
+		server.close(callback);
+	},
+
+	/**
+	 * Applies the backend settings again, without a restart
+	 *
+	 * @returns {Promise}  resolves with the settings that changed
+	 */
+	applySettings: () => {
+		const changed = config.reload();
+
+		if (changed.length) {
+			logger.info('Reloading backend settings: ' + changed.join(', '));
+		} else {
+			logger.info('Reloading backend settings: nothing changed');
+		}
+
+		if (changed.indexOf('log_level') !== -1) {
+			loggers.setLevel(config.getSetting('log_level'));
+		}
+
+		if (changed.indexOf('read_cache') !== -1) {
+			readCache.clear();
+		}
+
+		let sequence = Promise.resolve();
+
+		if (changed.indexOf('database_pool') !== -1) {
+			sequence = sequence.then(() => {
+				return db.reloadPool();
+			});
+		}
+
+		if (changed.indexOf('port') !== -1 && server) {
+			// The new port is listening before the old one closes, so the API is never down
+			sequence = sequence.then(() => {
+				const old_server = server;
+				return internalSystem.listen(app)
+					.then(() => {
+						old_server.close();
+					});
+			});
+		}
+
+		return sequence.then(() => {
+			return changed;
+		});
+	},
+
+	/**
+	 * Tokens signed with the old key keep working until the grace period is over,
+	 * so sessions don't all end at once
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  [data.algorithm]     RS256, ES256 or EdDSA
+	 * @param   {Number}  [data.grace_period]  in seconds
+	 * @returns {Promise}
+	 */
+	rotateJwtKeys: (access, data) => {
+		const grace_period = typeof data.grace_period !== 'undefined' ? data.grace_period : 86400;
+
+		return access.can('system:jwt')
+			.then(() => {
+				const previous_kid = config.getSigningKey().kid;
+				const keys         = config.rotateKeys(data.algorithm || 'RS256', grace_period);
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'rotated',
+					object_type: 'jwt-key',
+					object_id:   0,
+					meta:        {
+						alg:          keys.alg,
+						kid:          keys.kid,
+						previous_kid: previous_kid,
+						grace_period: grace_period
+					}
+				})
+					.then(() => {
+						return keys;
+					});
+			});
+	},
+
+	/**
+	 * The tracing settings, with the values of the headers hidden as they're usually keys
+	 *
+	 * @returns {Object}
+	 */
+	getTracing: () => {
+		const tracing = config.getSetting('tracing');
+		return Object.assign({}, tracing, {
+			headers: _.mapValues(tracing.headers, () => '********')
+		});
+	},
+
+	/**
+	 * The migrations that have run, newest first, the ones waiting for a restart and the snapshots taken before them
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getMigrations: (access) => {
+		return access.can('system:migrations')
+			.then(() => {
+				return migrate.getHistory();
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	reload: (access) => {
+		return access.can('system:reload')
+			.then(() => {
+				return internalSystem.applySettings();
+			})
+			.then((changed) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'reloaded',
+					object_type: 'system',
+					object_id:   0,
+					meta:        {
+						changed: changed
+					}
+				})
+					.then(() => {
+						return {
+							changed:  changed,
+							settings: {
+								log_level:          config.getSetting('log_level'),
+								port:               config.getSetting('port'),
+								database_pool:      config.getSetting('database_pool'),
+								slow_query_ms:      config.getSetting('slow_query_ms'),
+								config_concurrency: config.getSetting('config_concurrency'),
+								body_limits:        config.getSetting('body_limits'),
+								request_timeouts:   config.getSetting('request_timeouts'),
+								sentry_dsn:         config.getSetting('sentry_dsn'),
+								tracing:            internalSystem.getTracing()
+							}
+						};
+					});
+			});
+	}
+};
+
+module.exports = internalSystem;
@@ -0,0 +1,200 @@
+const _                = require('lodash');
+const error            = require('../lib/error');
+const {castJsonIfNeed} = require('../lib/helpers');
+const internalAuditLog = require('./audit-log');
+const internalLock     = require('./lock');
+
+/**
+ * The resources that can be tagged. Required when used, as these modules require this one.
+ */
+const TYPES = {
+	'proxy-host': {
+		permission: 'proxy_hosts',
+		internal:   () => require('./proxy-host'),
+		model:      () => require('../models/proxy_host')
+	},
+	'redirection-host': {
+		permission: 'redirection_hosts',
+		internal:   () => require('./redirection-host'),
+		model:      () => require('../models/redirection_host')
+	},
+	'dead-host': {
+		permission: 'dead_hosts',
+		internal:   () => require('./dead-host'),
+		model:      () => require('../models/dead_host')
+	},
+	'stream': {
+		permission: 'streams',
+		internal:   () => require('./stream'),
+		model:      () => require('../models/stream')
+	},
+	'certificate': {
+		permission: 'certificates',
+		internal:   () => require('./certificate'),
+		model:      () => require('../models/certificate')
+	}
+};
+
+const internalTag = {
+
+	/**
+	 * Limits a query to rows that have every one of the tags. A tag without a value,
+	 * eg "env", matches that key with any value as well.
+	 *
+	 * @param   {Object}  query
+	 * @param   {Array}   tags
+	 * @param   {String}  [table]  to qualify the column with, for queries with joins
+	 */
+	applyFilter: (query, tags, table) => {
+		const column = castJsonIfNeed((table ? table + '.' : '') + 'tags');
+
+		tags.forEach((tag) => {
+			query.where(function () {
+				this.where(column, 'like', '%' + JSON.stringify(tag) + '%');
+				if (tag.indexOf('=') === -1) {
+					this.orWhere(column, 'like', '%"' + tag + '=%');
+				}
+			});
+		});
+	},
+
+	/**
+	 * Every tag in use on the resources this user can see, with how many of each type have it
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getAll: (access) => {
+		let counts   = {};
+		let sequence = access.can('tags:list');
+
+		_.forEach(TYPES, (type, object_type) => {
+			sequence = sequence.then(() => {
+				return type.internal().getAll(access)
+					.then((rows) => {
+						rows.forEach((row) => {
+							(row.tags || []).forEach((tag) => {
+								counts[tag]              = counts[tag] || {};
+								counts[tag][object_type] = (counts[tag][object_type] || 0) + 1;
+							});
+						});
+					})
+					.catch((err) => {
+						// Types this user can't list are left out
+						if (!(err instanceof error.PermissionError)) {
+							throw err;
+						}
+					});
+			});
+		});
+
+		return sequence.then(() => {
+			return Object.keys(counts).sort().map((tag) => {
+				return {
+					tag:    tag,
+					counts: counts[tag]
+				};
+			});
+		});
+	},
+
+	/**
+	 * A key=value tag replaces any other value for that key, and removing a key
+	 * without a value removes it whatever its value.
+	 *
+	 * @param   {Array}  tags
+	 * @param   {Array}  add
+	 * @param   {Array}  remove
+	 * @returns {Array}
+	 */
+	merge: (tags, add, remove) => {
+		const key     = (tag) => tag.split('=')[0];
+		const matches = (tag, other) => tag === other || (other.indexOf('=') === -1 && key(tag) === other);
+
+		add.forEach((tag) => {
+			if (tag.indexOf('=') !== -1) {
+				tags = tags.filter((existing) => existing.indexOf('=') === -1 || key(existing) !== key(tag));
+			}
+			tags = tags.concat([tag]);
+		});
+
+		return _.uniq(tags.filter((tag) => !remove.some((other) => matches(tag, other)))).sort();
+	},
+
+	/**
+	 * Adds and removes tags on many items of one type at once. None are changed when any of
+	 * them is locked.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.object_type
+	 * @param   {Array}   data.ids
+	 * @param   {Array}   [data.add]
+	 * @param   {Array}   [data.remove]
+	 * @returns {Promise}
+	 */
+	bulkUpdate: (access, data) => {
+		const type = TYPES[data.object_type];
+		if (!type) {
+			return Promise.reject(new error.ValidationError('Items of type ' + data.object_type + ' can\'t be tagged'));
+		}
+
+		let rows     = [];
+		let updated  = [];
+		let sequence = Promise.resolve();
+
+		_.uniq(data.ids).forEach((id) => {
+			sequence = sequence
+				.then(() => {
+					return access.can(type.permission + ':update', id);
+				})
+				.then(() => {
+					return type.internal().get(access, {id: id});
+				})
+				.then((row) => {
+					rows.push(row);
+				});
+		});
+
+		sequence = sequence.then(() => {
+			// Before any is changed, so a locked one doesn't leave the rest half done
+			rows.forEach((row) => {
+				internalLock.assertUnlocked(row, 'updated');
+			});
+
+			return rows.reduce((rows_sequence, row) => {
+				return rows_sequence.then(() => {
+					const tags = internalTag.merge(row.tags || [], data.add || [], data.remove || []);
+					if (_.isEqual(tags, row.tags)) {
+						updated.push({id: row.id, tags: tags});
+						return;
+					}
+
+					return type.model()
+						.query()
+						.where('id', row.id)
+						.patch({tags: tags})
+						.then(() => {
+							updated.push({id: row.id, tags: tags});
+
+							// Add to audit log
+							return internalAuditLog.add(access, {
+								action:      'updated',
+								object_type: data.object_type,
+								object_id:   row.id,
+								meta:        {
+									tags: tags
+								}
+							});
+						});
+				});
+			}, Promise.resolve());
+		});
+
+		return sequence.then(() => {
+			return updated;
+		});
+	}
+};
+
+module.exports = internalTag;
@@ -0,0 +1,235 @@
+const https                = require('https');
+const logger               = require('../logger').global;
+const error                = require('../lib/error');
+const config               = require('../lib/config');
+const proxyAgent           = require('../lib/proxy-agent');
+const settingModel         = require('../models/setting');
+const proxyHostModel       = require('../models/proxy_host');
+const redirectionHostModel = require('../models/redirection_host');
+const deadHostModel        = require('../models/dead_host');
+const streamModel          = require('../models/stream');
+const certificateModel     = require('../models/certificate');
+const accessListModel      = require('../models/access_list');
+const userModel            = require('../models/user');
+const internalVersion      = require('./version');
+
+const TIMEOUT = 15000;
+
+// Changes when what's reported changes, so the reports can be told apart
+const REPORT_VERSION = 1;
+
+// What's counted, and the model it's counted from
+const COUNTS = {
+	proxy_hosts:       proxyHostModel,
+	redirection_hosts: redirectionHostModel,
+	dead_hosts:        deadHostModel,
+	streams:           streamModel,
+	certificates:      certificateModel,
+	access_lists:      accessListModel,
+	users:             userModel
+};
+
+// The last report this replica sent, {sent_on, error}
+let last_report = null;
+
+/**
+ * Counts are only reported as how many digits they have, so an instance can't be told apart by them
+ *
+ * @param   {Number}  count
+ * @returns {String}  ie: '0', '1-9', '10-99', '100-999' or '1000+'
+ */
+const getRange = (count) => {
+	if (!count) {
+		return '0';
+	}
+	if (count >= 1000) {
+		return '1000+';
+	}
+
+	const from = Math.pow(10, Math.floor(Math.log10(count)));
+	return from + '-' + (from * 10 - 1);
+};
+
+const internalTelemetry = {
+
+	intervalTimeout:    1000 * 60 * 60 * 24, // 1 day
+	interval:           null,
+	intervalProcessing: false,
+
+	initTimer: () => {
+		logger.info('Telemetry Timer initialized');
+		internalTelemetry.interval = setInterval(internalTelemetry.processReport, internalTelemetry.intervalTimeout);
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'telemetry')
+			.first();
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (config.isTelemetryDisabled()) {
+			return Promise.reject(new error.ValidationError('Telemetry is switched off for this instance with DISABLE_TELEMETRY or OFFLINE'));
+		}
+
+		let url;
+		try {
+			url = new URL(meta.url);
+		} catch (err) {
+			return Promise.reject(new error.ValidationError('The url the reports are sent to is missing, ie: https://telemetry.example.com/report'));
+		}
+		if (url.protocol !== 'https:') {
+			return Promise.reject(new error.ValidationError('The reports can only be sent to an https:// url'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What's sent, and nothing else: no names, addresses, domains or ids
+	 *
+	 * @returns {Promise}
+	 */
+	getReport: () => {
+		const names = Object.keys(COUNTS);
+
+		return Promise.all(names.map((name) => {
+			return COUNTS[name]
+				.query()
+				.where('is_deleted', 0)
+				.count('id as count')
+				.first()
+				.then((row) => parseInt(row.count, 10));
+		}))
+			.then((counts) => {
+				let ranges = {};
+				names.forEach((name, index) => {
+					ranges[name] = getRange(counts[index]);
+				});
+
+				return {
+					report:   REPORT_VERSION,
+					version:  internalVersion.getVersion(),
+					database: config.isSqlite() ? 'sqlite' : (config.isPostgres() ? 'postgres' : 'mysql'),
+					arch:     process.arch,
+					counts:   ranges
+				};
+			});
+	},
+
+	/**
+	 * @param   {String}  url
+	 * @param   {Object}  report
+	 * @returns {Promise}
+	 */
+	send: (url, report) => {
+		const body = JSON.stringify(report);
+
+		return new Promise((resolve, reject) => {
+			const req = https.request(url, {
+				method:  'POST',
+				timeout: TIMEOUT,
+				agent:   proxyAgent.forUrl(url),
+				headers: {
+					'Content-Type':   'application/json',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager/' + report.version
+				}
+			}, (res) => {
+				res.resume();
+				res.on('end', () => {
+					if (res.statusCode >= 200 && res.statusCode < 300) {
+						resolve();
+					} else {
+						reject(new Error(new URL(url).host + ' answered ' + res.statusCode));
+					}
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error(new URL(url).host + ' didn\'t answer'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	/**
+	 * Triggered by a timer, this sends the report once a day while the telemetry setting is on.
+	 * Nothing is sent when DISABLE_TELEMETRY or OFFLINE is set, whatever the setting is.
+	 *
+	 * @returns {Promise}
+	 */
+	processReport: () => {
+		if (internalTelemetry.intervalProcessing || config.isTelemetryDisabled()) {
+			return Promise.resolve(false);
+		}
+
+		internalTelemetry.intervalProcessing = true;
+
+		return internalTelemetry.getSetting()
+			.then((setting) => {
+				if (!setting || setting.value !== 'on' || !setting.meta.url) {
+					return false;
+				}
+
+				return internalTelemetry.getReport()
+					.then((report) => internalTelemetry.send(setting.meta.url, report))
+					.then(() => {
+						logger.info('Sent the anonymous usage report to ' + new URL(setting.meta.url).host);
+						last_report = {sent_on: new Date().toISOString(), error: null};
+						return true;
+					}, (err) => {
+						// Tried again the next day
+						logger.warn('Could not send the anonymous usage report: ' + err.message);
+						last_report = {sent_on: null, error: err.message};
+						return false;
+					});
+			})
+			.then((sent) => {
+				internalTelemetry.intervalProcessing = false;
+				return sent;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalTelemetry.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * Exactly what would be sent, whether or not it's being sent
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getPreview: (access) => {
+		return access.can('system:telemetry')
+			.then(() => {
+				return Promise.all([
+					internalTelemetry.getSetting(),
+					internalTelemetry.getReport()
+				]);
+			})
+			.then(([setting, report]) => {
+				const disabled = config.isTelemetryDisabled();
+
+				return {
+					enabled:     !disabled && !!setting && setting.value === 'on' && !!setting.meta.url,
+					disabled:    disabled,
+					url:         setting && setting.meta.url ? setting.meta.url : null,
+					report:      report,
+					last_report: last_report
+				};
+			});
+	}
+};
+
+module.exports = internalTelemetry;
@@ -0,0 +1,360 @@
+const _                = require('lodash');
+const error            = require('../lib/error');
+const utils            = require('../lib/utils');
+const tenantModel      = require('../models/tenant');
+const userModel        = require('../models/user');
+const internalAuditLog = require('./audit-log');
+
+/**
+ * What a tenant can have a quota of, and the table it's counted in. Everything but users
+ * belongs to the tenant of the user who owns it.
+ */
+const RESOURCES = {
+	proxy_hosts:       {table: 'proxy_host', label: 'proxy hosts'},
+	redirection_hosts: {table: 'redirection_host', label: 'redirection hosts'},
+	dead_hosts:        {table: 'dead_host', label: '404 hosts'},
+	streams:           {table: 'stream', label: 'streams'},
+	certificates:      {table: 'certificate', label: 'certificates'},
+	access_lists:      {table: 'access_list', label: 'access lists'},
+	users:             {table: 'user', label: 'users'}
+};
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalTenant = {
+
+	resources: RESOURCES,
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  [data.description]
+	 * @param   {Object}  [data.quotas]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('tenants:create', data)
+			.then(() => {
+				return tenantModel
+					.query()
+					.insertAndFetch({
+						name:        data.name,
+						description: data.description || '',
+						quotas:      data.quotas || {},
+						meta:        data.meta || {}
+					})
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'tenant',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return internalTenant.get(access, {id: row.id});
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.name]
+	 * @param   {String}  [data.description]
+	 * @param   {Object}  [data.quotas]
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		return access.can('tenants:update', data.id)
+			.then(() => {
+				return internalTenant.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.id !== data.id) {
+					// Sanity check that something crazy hasn't happened
+					throw new error.InternalValidationError('Tenant could not be updated, IDs do not match: ' + row.id + ' !== ' + data.id);
+				}
+
+				return tenantModel
+					.query()
+					.patchAndFetchById(row.id, _.pick(data, ['name', 'description', 'quotas', 'meta']));
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'tenant',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return internalTenant.get(access, {id: saved_row.id});
+					});
+			});
+	},
+
+	/**
+	 * Only empty tenants can be deleted. Their users would otherwise end up outside of any tenant,
+	 * which means seeing everything.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('tenants:delete', data.id)
+			.then(() => {
+				return internalTenant.get(access, {id: data.id});
+			})
+			.then((row) => {
+				if (row.usage.users) {
+					throw new error.ValidationError('Tenant still has ' + row.usage.users + ' user(s), delete or move them first');
+				}
+
+				return tenantModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'tenant',
+							object_id:   row.id,
+							meta:        _.omit(row, omissions())
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * Users in a tenant can only get their own
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('tenants:get', data.id)
+			.then((access_data) => {
+				if (access_data.tenant_id && access_data.tenant_id !== data.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+
+				return tenantModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.first()
+					.then(utils.omitRow(omissions()));
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+
+				return internalTenant.getUsage(row.id)
+					.then((usage) => {
+						row.usage = usage;
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * Every tenant with what it's using, the view across tenants for admins outside of any
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  [search_query]
+	 * @returns {Promise}
+	 */
+	getAll: (access, search_query) => {
+		return access.can('tenants:list')
+			.then(() => {
+				let query = tenantModel
+					.query()
+					.where('is_deleted', 0)
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				// Query is used for searching
+				if (typeof search_query === 'string' && search_query.length > 0) {
+					query.where(function () {
+						this.where('name', 'like', '%' + search_query + '%');
+					});
+				}
+
+				return query.then(utils.omitRows(omissions()));
+			})
+			.then((rows) => {
+				return internalTenant.getUsageOfAll(rows.map((row) => row.id))
+					.then((usage) => {
+						return rows.map((row) => {
+							row.usage = usage[row.id];
+							return row;
+						});
+					});
+			});
+	},
+
+	/**
+	 * How many of each resource a tenant has
+	 *
+	 * @param   {Number}  tenant_id
+	 * @param   {Array}   [types]  keys of RESOURCES, all of them by default
+	 * @returns {Promise}  resolves with {proxy_hosts: 3, ...}
+	 */
+	getUsage: (tenant_id, types) => {
+		types = types || Object.keys(RESOURCES);
+
+		return Promise.all(types.map((type) => {
+			let query = userModel
+				.knex()
+				.table(RESOURCES[type].table)
+				.count('id as count')
+				.where('is_deleted', 0);
+
+			if (type === 'users') {
+				query.andWhere('tenant_id', tenant_id);
+			} else {
+				query.whereIn('owner_user_id', internalTenant.getUserIdsQuery(tenant_id));
+			}
+
+			return query.first()
+				.then((row) => {
+					return parseInt(row.count, 10);
+				});
+		}))
+			.then((counts) => {
+				return _.zipObject(types, counts);
+			});
+	},
+
+	/**
+	 * The usage of many tenants, with one query for each resource instead of one for each resource of each tenant
+	 *
+	 * @param   {Array}    tenant_ids
+	 * @returns {Promise}  resolves with {1: {proxy_hosts: 3, ...}, ...}
+	 */
+	getUsageOfAll: (tenant_ids) => {
+		const types = Object.keys(RESOURCES);
+
+		let usage = {};
+		tenant_ids.forEach((tenant_id) => {
+			usage[tenant_id] = _.zipObject(types, types.map(() => 0));
+		});
+
+		if (!tenant_ids.length) {
+			return Promise.resolve(usage);
+		}
+
+		return Promise.all(types.map((type) => {
+			const table = RESOURCES[type].table;
+			let query   = userModel.knex().table(table);
+
+			if (type === 'users') {
+				query.select('tenant_id')
+					.count('id as count')
+					.where('is_deleted', 0)
+					.whereIn('tenant_id', tenant_ids)
+					.groupBy('tenant_id');
+			} else {
+				query.select('user.tenant_id as tenant_id')
+					.count(table + '.id as count')
+					.join('user', 'user.id', table + '.owner_user_id')
+					.where(table + '.is_deleted', 0)
+					.whereIn('user.tenant_id', tenant_ids)
+					.groupBy('user.tenant_id');
+			}
+
+			return query.then((rows) => {
+				rows.forEach((row) => {
+					usage[row.tenant_id][type] = parseInt(row.count, 10);
+				});
+			});
+		}))
+			.then(() => {
+				return usage;
+			});
+	},
+
+	/**
+	 * @param   {Number}  tenant_id
+	 * @returns {Object}  query for the ids of the users in the tenant
+	 */
+	getUserIdsQuery: (tenant_id) => {
+		return userModel
+			.knex()
+			.table('user')
+			.select('id')
+			.where('tenant_id', tenant_id);
+	},
+
+	/**
+	 * A where clause limiting a resource query to what's owned by users of the same tenant.
+	 * Null for users outside of any tenant, who see everything.
+	 *
+	 * @param   {Object}  access_data
+	 * @param   {String}  [table]  to qualify the columns with, for queries with joins
+	 * @returns {Function|null}
+	 */
+	getFilter: (access_data, table) => {
+		if (!access_data || !access_data.tenant_id) {
+			return null;
+		}
+
+		const prefix = table ? table + '.' : '';
+
+		return function () {
+			this.whereIn(prefix + 'owner_user_id', internalTenant.getUserIdsQuery(access_data.tenant_id));
+		};
+	},
+
+	/**
+	 * Refuses to create one more of a resource once the tenant of the user has its quota of them
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  type    key of RESOURCES
+	 * @returns {Promise}
+	 */
+	checkQuota: (access, type) => {
+		const tenant_id = access.getTenantId();
+		if (!tenant_id) {
+			return Promise.resolve();
+		}
+
+		return tenantModel
+			.query()
+			.where('id', tenant_id)
+			.first()
+			.then((tenant) => {
+				const limit = tenant && tenant.quotas ? tenant.quotas[type] : null;
+				if (typeof limit !== 'number') {
+					return;
+				}
+
+				return internalTenant.getUsage(tenant_id, [type])
+					.then((usage) => {
+						if (usage[type] >= limit) {
+							throw new error.ValidationError('The quota of ' + limit + ' ' + RESOURCES[type].label + ' for ' + tenant.name + ' has been reached');
+						}
+					});
+			});
+	}
+};
+
+module.exports = internalTenant;
@@ -0,0 +1,253 @@
+const _         = require('lodash');
+const dns       = require('dns');
+const http      = require('http');
+const dgram     = require('dgram');
+const logger    = require('../logger').certbot;
+const error     = require('../lib/error');
+const config    = require('../lib/config');
+const lego      = require('../lib/lego');
+const dnsPacket = require('../lib/dns-packet');
+
+// Challenges are only looked up once, so they shouldn't be cached
+const TTL = 1;
+
+// The most records added and removed that are kept to look at
+const HISTORY_SIZE = 100;
+
+/**
+ * @param   {String}  fqdn  ie: _acme-challenge.example.com.
+ * @returns {String}  ie: _acme-challenge.example.com
+ */
+const getName = (fqdn) => {
+	return (fqdn || '').toLowerCase().replace(/\.$/, '');
+};
+
+const internalTestMode = {
+
+	PROVIDER: lego.MOCK_DNS_PROVIDER,
+
+	udp:     null,
+	control: null,
+
+	// TXT records by name, only ever kept in memory
+	records: {},
+	history: [],
+
+	/**
+	 * Starts the mock DNS provider when in test mode
+	 *
+	 * @returns {Promise}
+	 */
+	init: () => {
+		const test_mode = config.getTestMode();
+		if (!test_mode) {
+			return Promise.resolve();
+		}
+
+		logger.warn('Test mode is on, certificates are requested from ' + test_mode.acme_server);
+
+		return Promise.all([
+			internalTestMode.startDns(test_mode.dns_port),
+			internalTestMode.startControl(test_mode.control_port)
+		])
+			.catch((err) => {
+				// Only the tests using the mock DNS provider need it
+				logger.error('Could not start the mock DNS provider: ' + err.message);
+			});
+	},
+
+	/**
+	 * @param   {Number}  port
+	 * @returns {Promise}
+	 */
+	startDns: (port) => {
+		const udp = dgram.createSocket('udp4');
+
+		udp.on('message', (message, remote) => {
+			internalTestMode.handle(message)
+				.then((response) => {
+					udp.send(response, remote.port, remote.address);
+				})
+				.catch(() => {});
+		});
+
+		return new Promise((resolve, reject) => {
+			udp.once('error', reject);
+			udp.bind(port, () => {
+				udp.removeListener('error', reject);
+				udp.on('error', (err) => logger.error('Mock DNS server: ' + err.message));
+				internalTestMode.udp = udp;
+				logger.info('Mock DNS server listening on port ' + port);
+				resolve();
+			});
+		});
+	},
+
+	/**
+	 * The endpoint of lego's httpreq provider, which POSTs {fqdn, value} to /present and /cleanup
+	 *
+	 * @param   {Number}  port
+	 * @returns {Promise}
+	 */
+	startControl: (port) => {
+		const control = http.createServer((req, res) => {
+			let body = '';
+
+			req.setEncoding('utf8');
+			req.on('data', (chunk) => {
+				body += chunk;
+			});
+			req.on('end', () => {
+				let data = null;
+				try {
+					data = JSON.parse(body);
+				} catch (err) {
+					data = null;
+				}
+
+				if (req.method !== 'POST' || ['/present', '/cleanup'].indexOf(req.url) === -1 || !data || !data.fqdn || typeof data.value !== 'string') {
+					res.writeHead(400);
+					res.end();
+					return;
+				}
+
+				if (req.url === '/present') {
+					internalTestMode.present(data.fqdn, data.value);
+				} else {
+					internalTestMode.cleanup(data.fqdn, data.value);
+				}
+
+				res.writeHead(200);
+				res.end();
+			});
+		});
+
+		return new Promise((resolve, reject) => {
+			control.once('error', reject);
+			control.listen(port, '127.0.0.1', () => {
+				control.removeListener('error', reject);
+				internalTestMode.control = control;
+				resolve();
+			});
+		});
+	},
+
+	/**
+	 * @param   {String}  fqdn
+	 * @param   {String}  value
+	 */
+	present: (fqdn, value) => {
+		const name = getName(fqdn);
+
+		internalTestMode.records[name] = _.uniq((internalTestMode.records[name] || []).concat([value]));
+		internalTestMode.addHistory('present', name, value);
+		logger.info('Mock DNS record added: ' + name + ' TXT ' + value);
+	},
+
+	/**
+	 * @param   {String}  fqdn
+	 * @param   {String}  value
+	 */
+	cleanup: (fqdn, value) => {
+		const name = getName(fqdn);
+
+		internalTestMode.records[name] = _.without(internalTestMode.records[name] || [], value);
+		if (!internalTestMode.records[name].length) {
+			delete internalTestMode.records[name];
+		}
+		internalTestMode.addHistory('cleanup', name, value);
+		logger.info('Mock DNS record removed: ' + name + ' TXT ' + value);
+	},
+
+	/**
+	 * @param   {String}  action  present or cleanup
+	 * @param   {String}  name
+	 * @param   {String}  value
+	 */
+	addHistory: (action, name, value) => {
+		internalTestMode.history.push({action: action, name: name, value: value, created_on: new Date().toISOString()});
+		internalTestMode.history = internalTestMode.history.slice(-HISTORY_SIZE);
+	},
+
+	/**
+	 * @param   {Buffer}  message
+	 * @returns {Promise}  resolves with the response
+	 */
+	handle: (message) => {
+		let query = null;
+
+		try {
+			query = dnsPacket.parse(message);
+		} catch (err) {
+			return Promise.reject(err);
+		}
+
+		if (query.questions.length !== 1 || query.questions[0].class !== 1) {
+			return Promise.resolve(dnsPacket.encode(query, {rcode: 'NOTIMP'}));
+		}
+
+		return internalTestMode.resolve(query.questions[0])
+			.then((response) => {
+				return dnsPacket.encode(query, response);
+			});
+	},
+
+	/**
+	 * TXT records are the ones presented. Addresses are looked up like the backend would, so the HTTP
+	 * challenge works with the same -dnsserver, ie: for the network aliases of the container.
+	 *
+	 * @param   {Object}  question  {name, type}
+	 * @returns {Promise}  resolves with the response for dnsPacket.encode()
+	 */
+	resolve: (question) => {
+		if (question.type === 'TXT') {
+			return Promise.resolve({
+				authoritative: true,
+				answers:       (internalTestMode.records[question.name] || []).map((value) => {
+					return {name: question.name, type: 'TXT', ttl: TTL, data: value};
+				})
+			});
+		}
+
+		if (question.type !== 'A' && question.type !== 'AAAA') {
+			return Promise.resolve({authoritative: true, answers: []});
+		}
+
+		return dns.promises[question.type === 'A' ? 'resolve4' : 'resolve6'](question.name)
+			.then((addresses) => {
+				return {
+					answers: addresses.map((address) => {
+						return {name: question.name, type: question.type, ttl: TTL, data: address};
+					})
+				};
+			})
+			.catch((err) => {
+				return {rcode: err.code === dns.NOTFOUND ? 'NXDOMAIN' : (err.code === dns.NODATA ? 'NOERROR' : 'SERVFAIL')};
+			});
+	},
+
+	/**
+	 * What the mock DNS provider has now and what was added and removed, so tests can check the challenge
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getDns: (access) => {
+		return access.can('system:test-mode')
+			.then(() => {
+				const test_mode = config.getTestMode();
+				if (!test_mode) {
+					throw new error.ItemNotFoundError('test-mode');
+				}
+
+				return {
+					acme_server: test_mode.acme_server,
+					dns_port:    test_mode.dns_port,
+					records:     internalTestMode.records,
+					history:     internalTestMode.history
+				};
+			});
+	}
+};
+
+module.exports = internalTestMode;
@@ -0,0 +1,350 @@
+const _                 = require('lodash');
+const tls               = require('tls');
+const https             = require('https');
+const error             = require('../lib/error');
+const helpers           = require('../lib/helpers');
+const internalHostPorts = require('./host-ports');
+
+const TIMEOUT = 5000;
+
+const PROTOCOLS = ['TLSv1', 'TLSv1.1', 'TLSv1.2', 'TLSv1.3'];
+
+const TLS13_CIPHERS = ['TLS_AES_128_GCM_SHA256', 'TLS_AES_256_GCM_SHA384', 'TLS_CHACHA20_POLY1305_SHA256'];
+
+// By OpenSSL name, anything before TLS 1.3 without forward secrecy or an AEAD mode shouldn't be offered any more
+const STRONG_CIPHER = /^(ECDHE|DHE)-.*(GCM|CHACHA20|CCM)/;
+
+// Half a year, the least that's accepted for the HSTS preload list is a year
+const HSTS_MIN_AGE = 180 * 24 * 60 * 60;
+
+const internalTlsScan = {
+
+	/**
+	 * Connects once with the given options
+	 *
+	 * @param   {Object}  target   {address, port, servername}
+	 * @param   {Object}  options  passed to tls.connect
+	 * @returns {Promise}  resolves with the socket details, or null when the handshake failed
+	 */
+	handshake: (target, options) => {
+		return new Promise((resolve) => {
+			let socket = null;
+
+			try {
+				socket = tls.connect(_.assign({
+					host:               target.address,
+					port:               target.port,
+					servername:         target.servername,
+					rejectUnauthorized: false,
+					timeout:            TIMEOUT
+				}, options), () => {
+					const result = {
+						protocol:           socket.getProtocol(),
+						cipher:             socket.getCipher(),
+						authorized:         socket.authorized,
+						authorizationError: socket.authorizationError ? socket.authorizationError.code || String(socket.authorizationError) : null,
+						certificate:        socket.getPeerCertificate(true)
+					};
+					socket.end();
+					resolve(result);
+				});
+			} catch (err) {
+				// The client can't make this kind of connection at all, ie: a protocol OpenSSL was built without
+				resolve(undefined);
+				return;
+			}
+
+			socket.on('timeout', () => {
+				socket.destroy();
+				resolve(null);
+			});
+			socket.on('error', () => {
+				resolve(null);
+			});
+		});
+	},
+
+	/**
+	 * Which protocol versions the host accepts. null means this server can't test it.
+	 *
+	 * @param   {Object}  target
+	 * @returns {Promise}
+	 */
+	scanProtocols: (target) => {
+		return Promise.all(PROTOCOLS.map((protocol) => {
+			return internalTlsScan.handshake(target, {
+				minVersion: protocol,
+				maxVersion: protocol,
+				// Old protocols are refused by the client itself unless its security level is lowered
+				ciphers:    'ALL:@SECLEVEL=0'
+			})
+				.then((result) => {
+					return {
+						protocol: protocol,
+						accepted: typeof result === 'undefined' ? null : !!result
+					};
+				});
+		}));
+	},
+
+	/**
+	 * The cipher suites the host accepts, trying each one on its own
+	 *
+	 * @param   {Object}  target
+	 * @param   {Array}   protocols  result of scanProtocols()
+	 * @returns {Promise}
+	 */
+	scanCiphers: (target, protocols) => {
+		const accepts = (protocol) => !!_.find(protocols, {protocol: protocol, accepted: true});
+		let tests     = [];
+
+		if (accepts('TLSv1.3')) {
+			tests = tests.concat(TLS13_CIPHERS.map((cipher) => {
+				return {protocol: 'TLSv1.3', name: cipher, options: {minVersion: 'TLSv1.3', maxVersion: 'TLSv1.3', ciphers: cipher}};
+			}));
+		}
+
+		const legacy = _.findLast(['TLSv1', 'TLSv1.1', 'TLSv1.2'], accepts);
+		if (legacy) {
+			tests = tests.concat(tls.getCiphers()
+				.filter((cipher) => cipher.indexOf('tls_') !== 0)
+				.map((cipher) => {
+					return {protocol: legacy, name: cipher.toUpperCase(), options: {minVersion: 'TLSv1', maxVersion: legacy, ciphers: cipher.toUpperCase() + ':@SECLEVEL=0'}};
+				}));
+		}
+
+		// A few at a time, so the host isn't flooded with connections
+		let accepted = [];
+		let sequence = Promise.resolve();
+
+		_.chunk(tests, 8).forEach((chunk) => {
+			sequence = sequence.then(() => {
+				return Promise.all(chunk.map((test) => {
+					return internalTlsScan.handshake(target, test.options)
+						.then((result) => {
+							if (result) {
+								accepted.push({
+									name:     result.cipher.standardName || test.name,
+									protocol: test.protocol,
+									weak:     test.protocol !== 'TLSv1.3' && !STRONG_CIPHER.test(test.name)
+								});
+							}
+						});
+				}));
+			});
+		});
+
+		return sequence.then(() => {
+			return _.uniqBy(accepted, 'name');
+		});
+	},
+
+	/**
+	 * @param   {Object}  certificate  with issuerCertificate links
+	 * @returns {Array}
+	 */
+	getChain: (certificate) => {
+		let chain = [];
+		let item  = certificate;
+
+		while (item && !_.isEmpty(item) && chain.length < 10) {
+			chain.push({
+				subject:     item.subject ? item.subject.CN || null : null,
+				issuer:      item.issuer ? item.issuer.CN || null : null,
+				valid_from:  item.valid_from ? new Date(item.valid_from).toISOString() : null,
+				valid_to:    item.valid_to ? new Date(item.valid_to).toISOString() : null,
+				fingerprint: item.fingerprint256 || null
+			});
+
+			// A self signed certificate is its own issuer
+			if (item.issuerCertificate === item) {
+				break;
+			}
+			item = item.issuerCertificate;
+		}
+
+		return chain;
+	},
+
+	/**
+	 * @param   {Object}  target
+	 * @returns {Promise}  the Strict-Transport-Security header, or null
+	 */
+	getHsts: (target) => {
+		return new Promise((resolve) => {
+			const req = https.request({
+				host:               target.address,
+				port:               target.port,
+				servername:         target.servername,
+				path:               '/',
+				method:             'GET',
+				headers:            {Host: target.servername},
+				rejectUnauthorized: false,
+				timeout:            TIMEOUT
+			}, (res) => {
+				res.resume();
+				resolve(res.headers['strict-transport-security'] || null);
+			});
+
+			req.on('timeout', () => {
+				req.destroy();
+				resolve(null);
+			});
+			req.on('error', () => {
+				resolve(null);
+			});
+			req.end();
+		});
+	},
+
+	/**
+	 * Connects to a host like a browser would and reports how its TLS is set up
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type  ie: 'proxy-host'
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @param   {String}  [data.domain_name]  one of the host's, the first one by default
+	 * @param   {String}  [data.address]      to connect to instead of what the domain resolves to
+	 * @returns {Promise}
+	 */
+	scan: (access, object_type, data) => {
+		const modules = {
+			'proxy-host':       './proxy-host',
+			'redirection-host': './redirection-host',
+			'dead-host':        './dead-host'
+		};
+
+		return require(modules[object_type]).get(access, {id: data.id})
+			.then((host) => {
+				if (!host.certificate_id) {
+					throw new error.ValidationError('This host doesn\'t have a certificate');
+				}
+
+				const domain_names = host.domain_names.filter((domain_name) => domain_name.indexOf('*') === -1);
+				const servername   = data.domain_name || domain_names[0];
+
+				if (!servername || domain_names.indexOf(servername) === -1) {
+					throw new error.ValidationError('Choose one of the domain names of the host to scan');
+				}
+
+				// Proxy hosts might not be on 443
+				const ports = internalHostPorts.getPorts(host);
+				if (!ports.https.length) {
+					throw new error.ValidationError('This host doesn\'t listen on any https ports');
+				}
+				const port = ports.https.indexOf(443) === -1 ? ports.https[0] : 443;
+
+				const target = {
+					address:    data.address || helpers.toAsciiDomain(servername),
+					port:       port,
+					servername: helpers.toAsciiDomain(servername)
+				};
+
+				return internalTlsScan.handshake(target, {})
+					.then((result) => {
+						if (!result) {
+							throw new error.ValidationError('Could not connect to ' + target.address + ':' + port + ' over TLS');
+						}
+
+						return Promise.all([
+							internalTlsScan.scanProtocols(target),
+							internalTlsScan.getHsts(target)
+						])
+							.then(([protocols, hsts]) => {
+								return internalTlsScan.scanCiphers(target, protocols)
+									.then((ciphers) => {
+										return internalTlsScan.getReport(target, result, protocols, ciphers, hsts);
+									});
+							});
+					});
+			});
+	},
+
+	/**
+	 * @param   {Object}       target
+	 * @param   {Object}       result     of the first handshake
+	 * @param   {Array}        protocols
+	 * @param   {Array}        ciphers
+	 * @param   {String|null}  hsts
+	 * @returns {Object}
+	 */
+	getReport: (target, result, protocols, ciphers, hsts) => {
+		const chain  = internalTlsScan.getChain(result.certificate);
+		let warnings = [];
+
+		const accepts = (protocol) => !!_.find(protocols, {protocol: protocol, accepted: true});
+
+		if (accepts('TLSv1') || accepts('TLSv1.1')) {
+			warnings.push('TLS 1.0 and 1.1 are deprecated and should be turned off');
+		}
+		if (!accepts('TLSv1.3')) {
+			warnings.push('TLS 1.3 isn\'t supported');
+		}
+		if (_.some(ciphers, 'weak')) {
+			warnings.push('Weak cipher suites are accepted: ' + _.map(_.filter(ciphers, 'weak'), 'name').join(', '));
+		}
+
+		switch (result.authorizationError) {
+		case null:
+			break;
+		case 'UNABLE_TO_VERIFY_LEAF_SIGNATURE':
+		case 'UNABLE_TO_GET_ISSUER_CERT_LOCALLY':
+			warnings.push('The certificate chain is incomplete, an intermediate certificate is missing');
+			break;
+		case 'DEPTH_ZERO_SELF_SIGNED_CERT':
+		case 'SELF_SIGNED_CERT_IN_CHAIN':
+			warnings.push('The certificate is self signed, browsers won\'t trust it');
+			break;
+		case 'CERT_HAS_EXPIRED':
+			warnings.push('The certificate has expired');
+			break;
+		default:
+			warnings.push('The certificate isn\'t trusted: ' + result.authorizationError);
+		}
+
+		if (result.certificate && result.certificate.subject && tls.checkServerIdentity(target.servername, result.certificate)) {
+			warnings.push('The certificate isn\'t valid for ' + target.servername);
+		}
+
+		if (chain.length && chain[0].valid_to && new Date(chain[0].valid_to).getTime() - Date.now() < 14 * 24 * 60 * 60 * 1000) {
+			warnings.push('The certificate expires within 14 days');
+		}
+
+		let max_age = null;
+		if (hsts) {
+			const match = hsts.match(/max-age=(\d+)/i);
+			max_age     = match ? parseInt(match[1], 10) : 0;
+			if (max_age < HSTS_MIN_AGE) {
+				warnings.push('HSTS max-age is shorter than 180 days');
+			}
+		} else {
+			warnings.push('HSTS isn\'t turned on');
+		}
+
+		return {
+			domain_name: target.servername,
+			address:     target.address,
+			port:        target.port,
+			protocol:    result.protocol,
+			cipher:      result.cipher ? result.cipher.standardName || result.cipher.name : null,
+			protocols:   protocols,
+			ciphers:     ciphers,
+			chain:       {
+				trusted:  result.authorized,
+				error:    result.authorizationError,
+				complete: ['UNABLE_TO_VERIFY_LEAF_SIGNATURE', 'UNABLE_TO_GET_ISSUER_CERT_LOCALLY'].indexOf(result.authorizationError) === -1,
+				items:    chain
+			},
+			hsts:        {
+				enabled:            !!hsts,
+				max_age:            max_age,
+				include_subdomains: !!hsts && /includeSubDomains/i.test(hsts),
+				preload:            !!hsts && /preload/i.test(hsts)
+			},
+			warnings:    warnings
+		};
+	}
+};
+
+module.exports = internalTlsScan;
@@ -1,9 +1,11 @@
-const _          = require('lodash');
-const error      = require('../lib/error');
-const userModel  = require('../models/user');
-const authModel  = require('../models/auth');
-const helpers    = require('../lib/helpers');
-const TokenModel = require('../models/token');
+const _                    = require('lodash');
+const error                = require('../lib/error');
+const userModel            = require('../models/user');
+const authModel            = require('../models/auth');
+const helpers              = require('../lib/helpers');
+const internalCapabilities = require('../lib/capabilities');
+const internalPlugin       = require('./plugin');
+const TokenModel           = require('../models/token');
 
 const ERROR_MESSAGE_INVALID_AUTH = 'Invalid email or password';
 
@@ -56,14 +58,25 @@ module.exports = {
 												throw new error.AuthError('Invalid expiry time: ' + data.expiry);
 											}
 
-											return Token.create({
-												iss:   issuer || 'api',
-												attrs: {
-													id: user.id
-												},
-												scope:     [data.scope],
-												expiresIn: data.expiry
-											})
+											// A plugin can refuse the login, ie: outside of office hours
+											return internalPlugin.check('auth.login', {user: {id: user.id, email: user.email, roles: user.roles}, scope: data.scope})
+												.catch((err) => {
+													throw new error.AuthError(err.message);
+												})
+												.then(() => {
+													return internalCapabilities.load(user.id);
+												})
+												.then((capabilities) => {
+													return Token.create({
+														iss:   issuer || 'api',
+														attrs: {
+															id: user.id
+														},
+														caps:      capabilities,
+														scope:     [data.scope],
+														expiresIn: data.expiry
+													});
+												})
 												.then((signed) => {
 													return {
 														token:   signed.token,
@@ -119,12 +132,22 @@ module.exports = {
 				}
 			}
 
-			return Token.create({
-				iss:       'api',
-				scope:     scope,
-				attrs:     token_attrs,
-				expiresIn: data.expiry
-			})
+			// Tokens that aren't for a user have no capabilities of their own
+			return Promise.resolve(token_attrs.id ? internalCapabilities.load(token_attrs.id) : null)
+				.then((capabilities) => {
+					let payload = {
+						iss:       'api',
+						scope:     scope,
+						attrs:     token_attrs,
+						expiresIn: data.expiry
+					};
+
+					if (capabilities) {
+						payload.caps = capabilities;
+					}
+
+					return Token.create(payload);
+				})
 				.then((signed) => {
 					return {
 						token:   signed.token,
@@ -145,14 +168,18 @@ module.exports = {
 		const Token  = new TokenModel();
 		const expiry = helpers.parseDatePeriod(expire);
 
-		return Token.create({
-			iss:   'api',
-			attrs: {
-				id: user.id
-			},
-			scope:     ['user'],
-			expiresIn: expire
-		})
+		return internalCapabilities.load(user.id)
+			.then((capabilities) => {
+				return Token.create({
+					iss:   'api',
+					attrs: {
+						id: user.id
+					},
+					caps:      capabilities,
+					scope:     ['user'],
+					expiresIn: expire
+				});
+			})
 			.then((signed) => {
 				return {
 					token:   signed.token,
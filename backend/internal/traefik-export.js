@@ -0,0 +1,445 @@
+const _                = require('lodash');
+const yaml             = require('js-yaml');
+const internalExport   = require('./export');
+const internalAuditLog = require('./audit-log');
+const internalStorage  = require('./certificate-storage');
+
+// The entry points of the Traefik static config the routers are for
+const ENTRY_POINTS = {http: 'web', https: 'websecure'};
+
+// Forward hosts are proxied to without checking their certificate, as nginx does by default
+const INSECURE_TRANSPORT = 'npm-insecure';
+
+/**
+ * Settings of a proxy host that Traefik has no equivalent for, and whether the host uses them
+ */
+const PROXY_HOST_SETTINGS = {
+	access_list_id:                    (host) => host.access_list_id > 0,
+	advanced_config:                   (host) => !!_.trim(host.advanced_config),
+	block_exploits:                    (host) => !!host.block_exploits,
+	caching_enabled:                   (host) => !!host.caching_enabled,
+	redirect_rules:                    (host) => !!(host.redirect_rules && host.redirect_rules.length),
+	canonical_host:                    (host) => !!host.canonical_host,
+	traffic_split:                     (host) => !!host.traffic_split,
+	upstream_sets:                     (host) => !!host.upstream_sets,
+	fallback:                          (host) => !!host.fallback,
+	served_files:                      (host) => !!host.served_files,
+	limits:                            (host) => !!host.limits,
+	compression:                       (host) => !!host.compression,
+	server_header:                     (host) => !!host.server_header,
+	ports:                             (host) => !!host.ports,
+	listen:                            (host) => !!host.listen,
+	accept_proxy_protocol:             (host) => !!host.accept_proxy_protocol,
+	cors:                              (host) => !!(host.cors && host.cors.origins && host.cors.origins.length),
+	hotlink:                           (host) => !!host.hotlink,
+	optimizations:                     (host) => !!host.optimizations,
+	'load_balancing.method':           (host) => !!(host.load_balancing && host.load_balancing.method && host.load_balancing.method !== 'round_robin'),
+	'load_balancing.session_affinity': (host) => !!(host.load_balancing && host.load_balancing.session_affinity),
+	'load_balancing.servers.backup':   (host) => !!(host.load_balancing && _.some(host.load_balancing.servers, 'backup'))
+};
+
+/**
+ * Settings of a redirection host that Traefik has no equivalent for, and whether the host uses them
+ */
+const REDIRECTION_HOST_SETTINGS = {
+	advanced_config:       (host) => !!_.trim(host.advanced_config),
+	block_exploits:        (host) => !!host.block_exploits,
+	redirect_rules:        (host) => !!(host.redirect_rules && host.redirect_rules.length),
+	forward_http_code:     (host) => [301, 302].indexOf(host.forward_http_code) === -1,
+	accept_proxy_protocol: (host) => !!host.accept_proxy_protocol,
+	listen:                (host) => !!host.listen
+};
+
+const internalTraefikExport = {
+
+	/**
+	 * @param   {Array}   domain_names
+	 * @param   {String}  [path]
+	 * @returns {String}
+	 */
+	getRule: (domain_names, path) => {
+		const hosts = domain_names.map((name) => {
+			if (name.indexOf('*.') === 0) {
+				return 'HostRegexp(`^[^.]+' + _.escapeRegExp(name.substring(1)) + '$`)';
+			}
+			return 'Host(`' + name + '`)';
+		});
+
+		if (!path) {
+			return hosts.join(' || ');
+		}
+		return (hosts.length > 1 ? '(' + hosts.join(' || ') + ')' : hosts[0]) + ' && PathPrefix(`' + path + '`)';
+	},
+
+	/**
+	 * @param   {Object}  target  {forward_scheme, forward_host, forward_port}
+	 * @returns {String}
+	 */
+	getUrl: (target) => {
+		const host = target.forward_host.indexOf(':') !== -1 ? '[' + target.forward_host + ']' : target.forward_host;
+		return (target.forward_scheme || 'http') + '://' + host + ':' + target.forward_port;
+	},
+
+	/**
+	 * Adds the routers of a host: on the http entry point, and on the https one when it has a certificate.
+	 * Forced SSL makes the http router redirect instead.
+	 *
+	 * @param   {Object}   config
+	 * @param   {String}   name
+	 * @param   {Object}   host
+	 * @param   {Object}   certificate   null when the host has none
+	 * @param   {Object}   router        {rule, service, middlewares}
+	 * @param   {Object}   options
+	 * @param   {Boolean}  [redirect]    false for locations, the redirect of the host covers them
+	 * @returns {Array}    the names of the routers
+	 */
+	addRouters: (config, name, host, certificate, router, options, redirect = true) => {
+		if (!certificate) {
+			config.http.routers[name] = _.assign({entryPoints: [ENTRY_POINTS.http]}, router);
+			return [name];
+		}
+
+		let tls = {};
+		if (certificate.provider === 'letsencrypt') {
+			tls.certResolver = options.cert_resolver;
+
+			const wildcards = certificate.domain_names.filter((domain) => domain.indexOf('*.') === 0);
+			if (wildcards.length) {
+				tls.domains = [{main: wildcards[0], sans: _.without(certificate.domain_names, wildcards[0])}];
+			}
+		} else {
+			const dir = internalStorage.getDirectory(certificate);
+			if (!_.find(config.tls.certificates, {certFile: dir + '/fullchain.pem'})) {
+				config.tls.certificates.push({certFile: dir + '/fullchain.pem', keyFile: dir + '/privkey.pem'});
+			}
+		}
+
+		config.http.routers[name + '-secure'] = _.assign({entryPoints: [ENTRY_POINTS.https]}, router, {tls: tls});
+
+		if (host.ssl_forced && !redirect) {
+			return [name + '-secure'];
+		} else if (host.ssl_forced) {
+			config.http.middlewares[name + '-redirect'] = {redirectScheme: {scheme: 'https', permanent: true}};
+			config.http.routers[name] = _.assign({entryPoints: [ENTRY_POINTS.http]}, router, {
+				service:     'noop@internal',
+				middlewares: [name + '-redirect']
+			});
+		} else {
+			config.http.routers[name] = _.assign({entryPoints: [ENTRY_POINTS.http]}, router);
+		}
+
+		return [name, name + '-secure'];
+	},
+
+	/**
+	 * @param   {Object}  config
+	 * @param   {String}  name
+	 * @param   {Array}   targets  [{forward_scheme, forward_host, forward_port, [weight]}]
+	 * @param   {Object}  [upstream_tls]
+	 */
+	addService: (config, name, targets, upstream_tls) => {
+		let load_balancer = {
+			servers: targets.map((target) => {
+				let server = {url: internalTraefikExport.getUrl(target)};
+				if (target.weight) {
+					server.weight = target.weight;
+				}
+				return server;
+			}),
+			passHostHeader: true
+		};
+
+		if (targets[0].forward_scheme === 'https' && !(upstream_tls && upstream_tls.verify)) {
+			config.http.serversTransports[INSECURE_TRANSPORT] = {insecureSkipVerify: true};
+			load_balancer.serversTransport = INSECURE_TRANSPORT;
+		}
+
+		config.http.services[name] = {loadBalancer: load_balancer};
+	},
+
+	/**
+	 * @param   {Object}  config
+	 * @param   {Object}  host
+	 * @param   {Object}  certificate
+	 * @param   {Object}  options
+	 * @returns {Object}  {object_type, object_id, domain_names, routers, services, unexported}
+	 */
+	addProxyHost: (config, host, certificate, options) => {
+		const name = 'npm-proxy-host-' + host.id;
+
+		let exported = {
+			object_type:  'proxy-host',
+			object_id:    host.id,
+			domain_names: host.domain_names,
+			routers:      [],
+			services:     [name],
+			unexported:   _.keys(_.pickBy(PROXY_HOST_SETTINGS, (used) => used(host)))
+		};
+
+		let middlewares = [];
+		if (certificate && host.hsts_enabled) {
+			config.http.middlewares[name + '-hsts'] = {headers: {stsSeconds: 63072000, stsIncludeSubdomains: !!host.hsts_subdomains}};
+			middlewares.push(name + '-hsts');
+		}
+
+		internalTraefikExport.addService(config, name, [host].concat(host.load_balancing ? host.load_balancing.servers.map((server) => {
+			return _.assign({forward_scheme: host.forward_scheme}, server);
+		}) : []), host.upstream_tls);
+
+		exported.routers = internalTraefikExport.addRouters(config, name, host, certificate, _.assign({
+			rule:    internalTraefikExport.getRule(host.domain_names),
+			service: name
+		}, middlewares.length ? {middlewares: middlewares} : {}), options);
+
+		(host.locations || []).forEach((location, index) => {
+			const location_name = name + '-location-' + (index + 1);
+
+			if (/^[~=^]/.test(location.path)) {
+				exported.unexported.push('locations[' + index + '].path');
+				return;
+			}
+			['advanced_config', 'mirror', 'limit_rate', 'sub_filter', 'cors', 'uploads'].forEach((setting) => {
+				if (location[setting] && _.trim(location[setting])) {
+					exported.unexported.push('locations[' + index + '].' + setting);
+				}
+			});
+
+			let location_middlewares = middlewares.slice();
+			if (location.forward_path) {
+				config.http.middlewares[location_name + '-strip'] = {stripPrefix: {prefixes: [location.path]}};
+				location_middlewares.push(location_name + '-strip');
+
+				if (location.forward_path !== '/') {
+					config.http.middlewares[location_name + '-prefix'] = {addPrefix: {prefix: location.forward_path.replace(/\/$/, '')}};
+					location_middlewares.push(location_name + '-prefix');
+				}
+			}
+
+			internalTraefikExport.addService(config, location_name, [location], host.upstream_tls);
+			exported.services.push(location_name);
+			exported.routers = exported.routers.concat(internalTraefikExport.addRouters(config, location_name, host, certificate, _.assign({
+				rule:    internalTraefikExport.getRule(host.domain_names, location.path),
+				service: location_name
+			}, location_middlewares.length ? {middlewares: location_middlewares} : {}), options, false));
+		});
+
+		return exported;
+	},
+
+	/**
+	 * @param   {Object}  config
+	 * @param   {Object}  host
+	 * @param   {Object}  certificate
+	 * @param   {Object}  options
+	 * @returns {Object}  {object_type, object_id, domain_names, routers, services, unexported}
+	 */
+	addRedirectionHost: (config, host, certificate, options) => {
+		const name = 'npm-redirection-host-' + host.id;
+
+		// The auto scheme keeps the one of the request
+		const scheme = host.forward_scheme === 'auto' ? '${1}' : host.forward_scheme;
+
+		config.http.middlewares[name] = {
+			redirectRegex: {
+				regex:       '^(https?)://[^/]+(.*)$',
+				replacement: scheme + '://' + host.forward_domain_name + (host.preserve_path ? '${2}' : ''),
+				permanent:   host.forward_http_code === 301
+			}
+		};
+
+		let middlewares = [name];
+		if (certificate && host.hsts_enabled) {
+			config.http.middlewares[name + '-hsts'] = {headers: {stsSeconds: 63072000, stsIncludeSubdomains: !!host.hsts_subdomains}};
+			middlewares.unshift(name + '-hsts');
+		}
+
+		return {
+			object_type:  'redirection-host',
+			object_id:    host.id,
+			domain_names: host.domain_names,
+			routers:      internalTraefikExport.addRouters(config, name, host, certificate, {
+				rule:        internalTraefikExport.getRule(host.domain_names),
+				service:     'noop@internal',
+				middlewares: middlewares
+			}, options),
+			services:     [],
+			unexported:   _.keys(_.pickBy(REDIRECTION_HOST_SETTINGS, (used) => used(host)))
+		};
+	},
+
+	/**
+	 * Flattens config into docker labels, ie: traefik.http.routers.app.entrypoints=websecure
+	 *
+	 * @param   {String}  prefix
+	 * @param   {*}       value
+	 * @returns {Array}
+	 */
+	toLabels: (prefix, value) => {
+		if (_.isArray(value)) {
+			if (_.every(value, (item) => !_.isObject(item))) {
+				return [prefix + '=' + value.join(',')];
+			}
+			return _.flatMap(value, (item, index) => internalTraefikExport.toLabels(prefix + '[' + index + ']', item));
+		}
+		if (_.isPlainObject(value)) {
+			if (_.isEmpty(value)) {
+				return [prefix + '=true'];
+			}
+			return _.flatMap(_.keys(value), (key) => internalTraefikExport.toLabels(prefix + '.' + key.toLowerCase(), value[key]));
+		}
+		return [prefix + '=' + value];
+	},
+
+	/**
+	 * The labels for the container a proxy host forwards to. Its services send traffic to the container,
+	 * on the port of the forward host, so locations forwarding to other hosts are left out.
+	 *
+	 * @param   {Object}  config
+	 * @param   {Object}  exported
+	 * @returns {Array}
+	 */
+	getLabels: (config, exported) => {
+		const getHost  = (service) => config.http.services[service].loadBalancer.servers[0].url.split('://')[1].replace(/:\d+$/, '');
+		const services = exported.services.filter((service) => getHost(service) === getHost(exported.services[0]));
+		const routers  = exported.routers.filter((router) => {
+			const service = config.http.routers[router].service;
+			return service === 'noop@internal' || services.indexOf(service) !== -1;
+		});
+
+		let labels = ['traefik.enable=true'];
+
+		routers.forEach((router) => {
+			labels = labels.concat(internalTraefikExport.toLabels('traefik.http.routers.' + router, config.http.routers[router]));
+		});
+
+		services.forEach((service) => {
+			const load_balancer = config.http.services[service].loadBalancer;
+			const url           = load_balancer.servers[0].url;
+			const prefix        = 'traefik.http.services.' + service + '.loadbalancer';
+
+			labels.push(prefix + '.server.port=' + url.split(':').pop());
+			labels.push(prefix + '.server.scheme=' + url.split(':')[0]);
+			if (load_balancer.serversTransport) {
+				labels.push(prefix + '.serverstransport=' + load_balancer.serversTransport + '@file');
+			}
+		});
+
+		_.uniq(_.flatMap(routers, (router) => config.http.routers[router].middlewares || [])).forEach((middleware) => {
+			labels = labels.concat(internalTraefikExport.toLabels('traefik.http.middlewares.' + middleware, config.http.middlewares[middleware]));
+		});
+
+		return labels;
+	},
+
+	/**
+	 * Traefik dynamic config for the enabled proxy and redirection hosts, docker labels for the proxy hosts,
+	 * and what couldn't be exported
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  [data.cert_resolver]  of the Traefik static config, for the hosts with a Let's Encrypt certificate
+	 * @returns {Promise}
+	 */
+	getConfig: (access, data) => {
+		const options = {cert_resolver: data.cert_resolver || 'letsencrypt'};
+
+		return access.can('system:export')
+			.then(() => {
+				return internalExport.getRows();
+			})
+			.then((rows) => {
+				let config = {
+					http: {routers: {}, services: {}, middlewares: {}, serversTransports: {}},
+					tls:  {certificates: []}
+				};
+
+				let exported   = [];
+				let unexported = [];
+
+				const getCertificate = (host) => {
+					return host.certificate_id > 0 ? _.find(rows.certificate, {id: host.certificate_id}) || null : null;
+				};
+
+				['proxy_host', 'redirection_host', 'dead_host', 'stream'].forEach((type) => {
+					rows[type].forEach((row) => {
+						const object_type = type.replace('_', '-');
+
+						// Dead hosts answer like Traefik does for unknown hosts, and streams need entry points of their own
+						if (type === 'dead_host' || type === 'stream' || !row.enabled) {
+							unexported.push({
+								object_type:  object_type,
+								object_id:    row.id,
+								domain_names: row.domain_names || [],
+								exported:     false,
+								settings:     row.enabled ? [] : ['enabled']
+							});
+							return;
+						}
+
+						const host = type === 'proxy_host'
+							? internalTraefikExport.addProxyHost(config, row, getCertificate(row), options)
+							: internalTraefikExport.addRedirectionHost(config, row, getCertificate(row), options);
+
+						exported.push(host);
+						if (host.unexported.length) {
+							unexported.push({
+								object_type:  host.object_type,
+								object_id:    host.object_id,
+								domain_names: host.domain_names,
+								exported:     true,
+								settings:     host.unexported
+							});
+						}
+					});
+				});
+
+				// Traefik doesn't like empty sections
+				config.http = _.omitBy(config.http, _.isEmpty);
+				if (!config.tls.certificates.length) {
+					delete config.tls;
+				}
+
+				return internalAuditLog.add(access, {
+					action:      'exported',
+					object_type: 'traefik',
+					object_id:   0,
+					meta:        {
+						hosts: exported.length
+					}
+				})
+					.then(() => {
+						return {
+							config: config,
+							labels: exported.filter((host) => host.object_type === 'proxy-host').map((host) => {
+								return {
+									object_type:  host.object_type,
+									object_id:    host.object_id,
+									domain_names: host.domain_names,
+									labels:       internalTraefikExport.getLabels(config, host)
+								};
+							}),
+							unexported: unexported
+						};
+					});
+			});
+	},
+
+	/**
+	 * The dynamic config as a file for the Traefik file provider, with what couldn't be exported in comments
+	 *
+	 * @param   {Object}  result  of getConfig
+	 * @returns {String}
+	 */
+	toYaml: (result) => {
+		const comments = result.unexported.map((item) => {
+			const name = '# ' + item.object_type + ' ' + item.object_id + (item.domain_names.length ? ' (' + item.domain_names.join(', ') + ')' : '');
+			return item.exported ? name + ' is exported without: ' + item.settings.join(', ') : name + ' isn\'t exported';
+		});
+
+		return ['# Exported from Nginx Proxy Manager on ' + new Date().toISOString()]
+			.concat(comments)
+			.join('\n') + '\n' + yaml.dump(result.config, {lineWidth: -1, noRefs: true});
+	}
+};
+
+module.exports = internalTraefikExport;
@@ -0,0 +1,313 @@
+const _     = require('lodash');
+const yaml  = require('js-yaml');
+const error = require('../lib/error');
+
+// The rules that are only hosts and a path prefix, ie: Host(`a.example.com`) && PathPrefix(`/api`)
+const RULE_PARTS = /^(\s|\(|\)|&&|\|\||Host\(\s*`[^`]+`(\s*,\s*`[^`]+`)*\s*\)|PathPrefix\(\s*`[^`]+`\s*\))*$/;
+
+const URL = /^(https?):\/\/(\[[0-9a-f:.]+\]|[^/:]+)(?::(\d+))?\/?$/i;
+
+const internalTraefikImport = {
+
+	/**
+	 * @param   {String}  text
+	 * @param   {String}  file
+	 * @returns {Object}
+	 */
+	load: (text, file) => {
+		if (/^\s*\[(http|tcp|tls)[\].]/m.test(text)) {
+			throw new error.ValidationError(file + ' is TOML, only YAML and JSON dynamic configs can be imported');
+		}
+
+		try {
+			return (text.trim().indexOf('{') === 0 ? JSON.parse(text) : yaml.load(text)) || {};
+		} catch (err) {
+			throw new error.ValidationError(file + ' couldn\'t be read: ' + err.message);
+		}
+	},
+
+	/**
+	 * Where a key is in the file, as parsed YAML and JSON don't keep track
+	 *
+	 * @param   {String}  text
+	 * @param   {String}  key
+	 * @returns {Number}
+	 */
+	findLine: (text, key) => {
+		const index = text.search(new RegExp('^\\s*["\']?' + _.escapeRegExp(key) + '["\']?\\s*:', 'm'));
+		return index === -1 ? 1 : text.substring(0, index).split('\n').length + (text[index] === '\n' ? 1 : 0);
+	},
+
+	/**
+	 * @param   {String}  rule
+	 * @returns {Object}  {hosts, path}, or null when the rule is more than hosts and a path prefix
+	 */
+	parseRule: (rule) => {
+		if (typeof rule !== 'string' || !RULE_PARTS.test(rule)) {
+			return null;
+		}
+
+		const hosts = _.flatMap(rule.match(/Host\([^)]*\)/g) || [], (part) => part.match(/`[^`]+`/g).map((name) => name.slice(1, -1).toLowerCase()));
+		const paths = (rule.match(/PathPrefix\(\s*`([^`]+)`/g) || []).map((part) => part.match(/`([^`]+)`/)[1]);
+
+		if (!hosts.length || paths.length > 1 || (paths.length && rule.indexOf('||') !== -1)) {
+			return null;
+		}
+
+		return {hosts: _.uniq(hosts).sort(), path: paths.length ? paths[0] : null};
+	},
+
+	/**
+	 * @param   {Object}  service
+	 * @returns {Object}  {forward_scheme, forward_host, forward_port, servers}, or null when it isn't a load balancer of urls
+	 */
+	parseService: (service) => {
+		const servers = service && service.loadBalancer && service.loadBalancer.servers;
+		const match   = servers && servers.length && typeof servers[0].url === 'string' ? servers[0].url.match(URL) : null;
+
+		if (!match) {
+			return null;
+		}
+
+		const scheme = match[1].toLowerCase();
+		return {
+			forward_scheme: scheme,
+			forward_host:   match[2].replace(/^\[|\]$/g, ''),
+			forward_port:   match[3] ? parseInt(match[3], 10) : (scheme === 'https' ? 443 : 80),
+			servers:        servers.length
+		};
+	},
+
+	/**
+	 * Translates a middleware into nginx and host settings, where it can be
+	 *
+	 * @param   {Object}  middleware
+	 * @returns {Object}  {lines, host, strip_prefixes, unsupported: [keys]}
+	 */
+	translateMiddleware: (middleware) => {
+		let translated = {lines: [], host: {}, strip_prefixes: [], unsupported: []};
+
+		_.forEach(middleware, (config, type) => {
+			config = config || {};
+
+			if (type === 'redirectScheme' && config.scheme === 'https') {
+				translated.host.ssl_forced = true;
+			} else if (type === 'headers') {
+				_.forEach(config, (value, key) => {
+					if (key === 'stsSeconds') {
+						translated.host.hsts_enabled = value > 0;
+					} else if (key === 'stsIncludeSubdomains') {
+						translated.host.hsts_subdomains = !!value;
+					} else if (key === 'customResponseHeaders' || key === 'customRequestHeaders') {
+						_.forEach(value, (header_value, name) => {
+							if (key === 'customRequestHeaders') {
+								translated.lines.push('proxy_set_header ' + name + ' "' + String(header_value).replace(/"/g, '\\"') + '";');
+							} else if (header_value === '') {
+								translated.lines.push('proxy_hide_header ' + name + ';');
+							} else {
+								translated.lines.push('add_header ' + name + ' "' + String(header_value).replace(/"/g, '\\"') + '" always;');
+							}
+						});
+					} else if (key !== 'stsPreload' && key !== 'forceSTSHeader') {
+						translated.unsupported.push('headers.' + key);
+					}
+				});
+			} else if (type === 'stripPrefix' && config.prefixes) {
+				translated.strip_prefixes = [].concat(config.prefixes);
+			} else if (type === 'compress') {
+				translated.lines.push('gzip on;');
+			} else if (type === 'buffering' && config.maxRequestBodyBytes) {
+				translated.lines.push('client_max_body_size ' + parseInt(config.maxRequestBodyBytes, 10) + ';');
+			} else {
+				translated.unsupported.push(type);
+			}
+		});
+
+		return translated;
+	},
+
+	/**
+	 * Converts the routers of a dynamic config into proxy hosts, one for each set of hosts
+	 *
+	 * @param   {Object}  item    {file, text}
+	 * @returns {Array}
+	 */
+	convertFile: (item) => {
+		const config      = internalTraefikImport.load(item.text, item.file);
+		const http        = config.http || {};
+		const services    = http.services || {};
+		const middlewares = http.middlewares || {};
+
+		let results = [];
+		let groups  = {};
+
+		const getResult = (domain_names, line) => {
+			const key = domain_names.join(',');
+			if (!groups[key]) {
+				groups[key] = {
+					result: {
+						file:         item.file,
+						line:         line,
+						domain_names: domain_names,
+						https:        false,
+						host:         null,
+						skipped:      null,
+						unconverted:  [],
+						notes:        [],
+						created_id:   null,
+						error:        null
+					},
+					main:      null,
+					locations: [],
+					advanced:  [],
+					settings:  {}
+				};
+				results.push(groups[key].result);
+			}
+			return groups[key];
+		};
+
+		_.forEach(http.routers || {}, (router, name) => {
+			const line    = internalTraefikImport.findLine(item.text, name);
+			const rule    = internalTraefikImport.parseRule(router.rule);
+			const service = services[String(router.service || '').replace(/@file$/, '')];
+			const forward = internalTraefikImport.parseService(service);
+
+			if (!rule) {
+				results.push({
+					file:         item.file,
+					line:         line,
+					domain_names: [],
+					https:        false,
+					host:         null,
+					skipped:      'The rule of router ' + name + ' is more than Host and PathPrefix: ' + router.rule,
+					unconverted:  [],
+					notes:        [],
+					created_id:   null,
+					error:        null
+				});
+				return;
+			}
+
+			const group = getResult(rule.hosts, line);
+
+			if (!forward) {
+				group.result.unconverted.push({directive: 'router ' + name + ' to service ' + router.service, line: line, placed_in: null});
+				group.result.notes.push('The service of router ' + name + ' isn\'t a load balancer of urls in this file');
+				return;
+			}
+			if (forward.servers > 1) {
+				group.result.notes.push('Only the first server of service ' + router.service + ' is used');
+			}
+
+			if (router.tls) {
+				group.result.https = true;
+			}
+
+			let lines          = [];
+			let strip_prefixes = [];
+
+			(router.middlewares || []).forEach((middleware_name) => {
+				const middleware = middlewares[middleware_name.replace(/@file$/, '')];
+
+				if (!middleware) {
+					group.result.unconverted.push({directive: 'middleware ' + middleware_name, line: line, placed_in: null});
+					return;
+				}
+
+				const translated = internalTraefikImport.translateMiddleware(middleware);
+				translated.unsupported.forEach((type) => {
+					group.result.unconverted.push({directive: 'middleware ' + middleware_name + ': ' + type, line: internalTraefikImport.findLine(item.text, middleware_name), placed_in: null});
+					if (type === 'basicAuth' || type === 'ipAllowList' || type === 'ipWhiteList') {
+						group.result.notes.push('Middleware ' + middleware_name + ' can be made into an access list');
+					}
+				});
+				translated.lines.forEach((translated_line) => {
+					group.result.unconverted.push({
+						directive: 'middleware ' + middleware_name + ': ' + translated_line,
+						line:      internalTraefikImport.findLine(item.text, middleware_name),
+						placed_in: rule.path ? 'location ' + rule.path : 'advanced_config'
+					});
+				});
+
+				lines          = lines.concat(translated.lines);
+				strip_prefixes = strip_prefixes.concat(translated.strip_prefixes);
+				_.assign(group.settings, translated.host);
+			});
+
+			if (!rule.path || rule.path === '/') {
+				if (group.main) {
+					group.result.notes.push('Router ' + name + ' is for the same hosts as another, only the first one is used');
+					return;
+				}
+				group.main     = forward;
+				group.advanced = group.advanced.concat(lines);
+			} else {
+				group.locations.push({
+					path:            rule.path,
+					forward_scheme:  forward.forward_scheme,
+					forward_host:    forward.forward_host,
+					forward_port:    forward.forward_port,
+					forward_path:    strip_prefixes.indexOf(rule.path) !== -1 ? '/' : '',
+					advanced_config: lines.join('\n')
+				});
+			}
+		});
+
+		_.forEach(groups, (group) => {
+			if (!group.main) {
+				group.result.skipped = 'None of its routers are for all paths';
+				return;
+			}
+
+			group.result.host = _.assign({
+				domain_names:            group.result.domain_names,
+				forward_scheme:          group.main.forward_scheme,
+				forward_host:            group.main.forward_host,
+				forward_port:            group.main.forward_port,
+				allow_websocket_upgrade: true,
+				block_exploits:          false,
+				locations:               group.locations,
+				advanced_config:         _.uniq(group.advanced).join('\n'),
+				meta:                    {imported_from: item.file + ':' + group.result.line}
+			}, group.result.https ? _.pick(group.settings, ['ssl_forced', 'hsts_enabled', 'hsts_subdomains']) : {});
+		});
+
+		_.forEach(config.tcp && config.tcp.routers, (router, name) => {
+			results.push({
+				file:         item.file,
+				line:         internalTraefikImport.findLine(item.text, name),
+				domain_names: [],
+				https:        false,
+				host:         null,
+				skipped:      'TCP router ' + name + ' can be made into a stream by hand',
+				unconverted:  [],
+				notes:        [],
+				created_id:   null,
+				error:        null
+			});
+		});
+
+		if (config.tls && config.tls.certificates && config.tls.certificates.length) {
+			results.forEach((result) => {
+				if (result.https) {
+					result.notes.push('The certificate files of the config aren\'t imported, upload them as a custom certificate if the host used one of them');
+				}
+			});
+		}
+
+		return results;
+	},
+
+	/**
+	 * Converts the routers of Traefik dynamic configs into proxy hosts
+	 *
+	 * @param   {Array}  files  [{file, text}]
+	 * @returns {Array}
+	 */
+	convert: (files) => {
+		return _.flatMap(files, internalTraefikImport.convertFile);
+	}
+};
+
+module.exports = internalTraefikImport;
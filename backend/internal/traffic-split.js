@@ -0,0 +1,117 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+const DEFAULTS = {
+	weight:   0,
+	split_by: 'request'
+};
+
+const internalTrafficSplit = {
+
+	/**
+	 * Headers and cookies are needed to split by them
+	 *
+	 * @param   {Object}  traffic_split  payload
+	 * @returns {Promise}
+	 */
+	validate: (traffic_split) => {
+		if (!traffic_split) {
+			return Promise.resolve();
+		}
+
+		const split_by = traffic_split.split_by || DEFAULTS.split_by;
+
+		if (['header', 'cookie'].indexOf(split_by) !== -1 && !traffic_split.key) {
+			return Promise.reject(new error.ValidationError('The name of the ' + split_by + ' to split by is needed as key'));
+		}
+
+		if (split_by === 'cookie' && traffic_split.key.indexOf('-') !== -1) {
+			return Promise.reject(new error.ValidationError('Cookies with a - in their name can\'t be split by'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * Changes the weight and other options of the split of a host, keeping the rest
+	 *
+	 * @param   {Object}  row      host
+	 * @param   {Object}  changes
+	 * @returns {Object}
+	 */
+	merge: (row, changes) => {
+		if (!row.traffic_split && !changes.canary) {
+			throw new error.ValidationError('Proxy Host #' + row.id + ' doesn\'t split its traffic, a canary is needed first');
+		}
+
+		let traffic_split = _.assign({}, row.traffic_split, changes);
+		if (['header', 'cookie'].indexOf(traffic_split.split_by) === -1) {
+			delete traffic_split.key;
+		}
+
+		return traffic_split;
+	},
+
+	/**
+	 * The nginx variable a request is split by. Requests without the header or cookie are split
+	 * one by one.
+	 *
+	 * @param   {Object}  traffic_split
+	 * @returns {String}
+	 */
+	getSplitKey: (traffic_split) => {
+		switch (traffic_split.split_by) {
+		case 'client':
+			return '$remote_addr';
+		case 'header':
+			return '$http_' + traffic_split.key.toLowerCase().replace(/-/g, '_');
+		case 'cookie':
+			return '$cookie_' + traffic_split.key;
+		}
+
+		return '$request_id';
+	},
+
+	/**
+	 * What to write into the config of a proxy host, or null when all the traffic goes to the forward host
+	 *
+	 * @param   {Object}  host
+	 * @returns {Object|null}
+	 */
+	getOptions: (host) => {
+		if (!host.traffic_split || !host.traffic_split.canary) {
+			return null;
+		}
+
+		const traffic_split = _.assign({}, DEFAULTS, host.traffic_split);
+		if (traffic_split.weight <= 0) {
+			return null;
+		}
+
+		const prefix = 'proxy_host_' + host.id;
+		const key    = internalTrafficSplit.getSplitKey(traffic_split);
+
+		// split_clients takes what's left with *, so all of the traffic to the canary is only *
+		let buckets = [];
+		if (traffic_split.weight >= 100) {
+			buckets.push({percent: '*', upstream: 'canary'});
+		} else {
+			buckets.push({percent: (+traffic_split.weight.toFixed(2)) + '%', upstream: 'canary'});
+			buckets.push({percent: '*', upstream: 'stable'});
+		}
+
+		return {
+			variable:     prefix + '_upstream',
+			key_variable: key === '$request_id' ? null : prefix + '_split_key',
+			key:          key,
+			buckets:      buckets,
+			canary:       {
+				forward_scheme: traffic_split.canary.forward_scheme || host.forward_scheme,
+				forward_host:   traffic_split.canary.forward_host,
+				forward_port:   traffic_split.canary.forward_port
+			}
+		};
+	}
+};
+
+module.exports = internalTrafficSplit;
@@ -0,0 +1,97 @@
+const _     = require('lodash');
+const fs    = require('fs');
+const error = require('../lib/error');
+
+const PREFIX = 'unix:';
+
+// An absolute path without what nginx would read as the end of it, a colon starting the uri
+const PATH = /^\/[A-Za-z0-9._@+\-/]+$/;
+
+const internalUnixSocket = {
+
+	/**
+	 * @param   {String}  forward_host
+	 * @returns {Boolean}
+	 */
+	isSocket: (forward_host) => {
+		return typeof forward_host === 'string' && forward_host.indexOf(PREFIX) === 0;
+	},
+
+	/**
+	 * @param   {String}  forward_host  ie: unix:/var/run/app.sock
+	 * @returns {String}  ie: /var/run/app.sock
+	 */
+	getPath: (forward_host) => {
+		return forward_host.substring(PREFIX.length);
+	},
+
+	/**
+	 * The forward hosts of a proxy host that can be unix sockets, the ones written into an upstream
+	 * or the proxy_pass of a custom location
+	 *
+	 * @param   {Object}  host
+	 * @returns {Array}
+	 */
+	getForwardHosts: (host) => {
+		let forward_hosts = [host.forward_host];
+
+		(host.locations || []).forEach((location) => {
+			forward_hosts.push(location.forward_host);
+		});
+		(host.load_balancing && host.load_balancing.servers ? host.load_balancing.servers : []).forEach((server) => {
+			forward_hosts.push(server.forward_host);
+		});
+		if (host.fallback && host.fallback.upstream) {
+			forward_hosts.push(host.fallback.upstream.forward_host);
+		}
+
+		return _.filter(forward_hosts, internalUnixSocket.isSocket);
+	},
+
+	/**
+	 * The forward hosts of a proxy host that are unix sockets need an absolute path, to a socket
+	 * nginx can reach in the container. Those the host already had aren't looked for again,
+	 * as an app that isn't running doesn't have its socket.
+	 *
+	 * @param   {Object}  data
+	 * @param   {Object}  [row]  the host before it's updated
+	 * @returns {Promise}
+	 */
+	validate: (data, row) => {
+		const existing = row ? internalUnixSocket.getForwardHosts(row) : [];
+
+		// Sent to by way of variables, which a unix socket can't be written into
+		const unsupported = [
+			data.traffic_split && data.traffic_split.canary ? data.traffic_split.canary.forward_host : null
+		].concat(_.map(data.locations || [], (location) => location.mirror ? location.mirror.forward_host : null));
+
+		if (_.find(unsupported, internalUnixSocket.isSocket)) {
+			return Promise.reject(new error.ValidationError('A canary or mirror can\'t be a unix socket'));
+		}
+
+		return Promise.all(internalUnixSocket.getForwardHosts(_.assign({}, row || {}, data)).map((forward_host) => {
+			const path = internalUnixSocket.getPath(forward_host);
+
+			if (!PATH.test(path)) {
+				return Promise.reject(new error.ValidationError(forward_host + ' isn\'t an absolute path to a unix socket'));
+			}
+			if (existing.indexOf(forward_host) !== -1) {
+				return Promise.resolve();
+			}
+
+			return new Promise((resolve, reject) => {
+				fs.stat(path, (err, stats) => {
+					if (err) {
+						reject(new error.ValidationError(path + ' doesn\'t exist, mount the directory of the socket into the container'));
+					} else if (!stats.isSocket()) {
+						reject(new error.ValidationError(path + ' isn\'t a unix socket'));
+					} else {
+						resolve();
+					}
+				});
+			});
+		}));
+	}
+};
+
+module.exports = internalUnixSocket;
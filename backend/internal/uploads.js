@@ -0,0 +1,53 @@
+const _     = require('lodash');
+const error = require('../lib/error');
+
+const DEFAULT_MAX_BODY_SIZE = '10g';
+const DEFAULT_TIMEOUT       = 3600;
+
+// What the preset writes, which nginx refuses to have twice in a location
+const DIRECTIVES = ['client_max_body_size', 'client_body_timeout', 'proxy_request_buffering', 'proxy_read_timeout', 'proxy_send_timeout', 'proxy_http_version'];
+
+const internalUploads = {
+
+	/**
+	 * A location with the uploads preset can't set the same directives in its advanced config
+	 *
+	 * @param   {Object}  data  payload
+	 * @returns {Promise}
+	 */
+	validate: (data) => {
+		for (const location of data.locations || []) {
+			if (!location.uploads) {
+				continue;
+			}
+
+			const found = _.filter(DIRECTIVES, (directive) => new RegExp('(^|[;{\\s])' + directive + '\\s').test(location.advanced_config || ''));
+			if (found.length) {
+				return Promise.reject(new error.ValidationError('The uploads of ' + location.path + ' already set ' + found.join(', ') + ', take them out of its advanced config'));
+			}
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * What to write into the config of a custom location for large uploads, streamed to the forward host as
+	 * they come in rather than buffered to disk first, or null when it doesn't have the preset
+	 *
+	 * @param   {Object}  location
+	 * @returns {Object|null}  ie: {max_body_size: '10g', timeout: 3600}
+	 */
+	getOptions: (location) => {
+		const uploads = location.uploads;
+		if (!uploads) {
+			return null;
+		}
+
+		return {
+			max_body_size: (uploads !== true && uploads.max_body_size) || DEFAULT_MAX_BODY_SIZE,
+			timeout:       (uploads !== true && uploads.timeout) || DEFAULT_TIMEOUT
+		};
+	}
+};
+
+module.exports = internalUploads;
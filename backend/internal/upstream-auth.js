@@ -0,0 +1,102 @@
+const _              = require('lodash');
+const error          = require('../lib/error');
+const proxyHostModel = require('../models/proxy_host');
+
+const internalUpstreamAuth = {
+
+	/**
+	 * @param   {Object}  upstream_auth  payload
+	 * @returns {Promise}
+	 */
+	validate: (upstream_auth) => {
+		if (!upstream_auth || _.isEmpty(upstream_auth)) {
+			return Promise.resolve();
+		}
+
+		// The first colon of the credentials ends the username
+		if (!upstream_auth.username || upstream_auth.username.indexOf(':') !== -1) {
+			return Promise.reject(new error.ValidationError('The upstream username is required and can\'t contain a colon'));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * The credentials the host has, with the password
+	 *
+	 * @param   {Number}  host_id
+	 * @returns {Promise}
+	 */
+	getStored: (host_id) => {
+		return proxyHostModel
+			.query()
+			.select('upstream_auth')
+			.where('id', host_id)
+			.first()
+			.then((row) => {
+				return row && row.upstream_auth && !_.isEmpty(row.upstream_auth) ? row.upstream_auth : null;
+			});
+	},
+
+	/**
+	 * An empty password keeps the one the host has, since it's never sent back to clients
+	 *
+	 * @param   {Object}  data       payload
+	 * @param   {Number}  [host_id]  when updating
+	 * @returns {Promise}
+	 */
+	prepare: (data, host_id) => {
+		const upstream_auth = data.upstream_auth;
+		if (!upstream_auth || _.isEmpty(upstream_auth) || upstream_auth.password) {
+			return Promise.resolve();
+		}
+
+		return (host_id ? internalUpstreamAuth.getStored(host_id) : Promise.resolve(null))
+			.then((stored) => {
+				if (!stored || !stored.password) {
+					throw new error.ValidationError('The upstream password is required');
+				}
+				data.upstream_auth = {username: upstream_auth.username, password: stored.password};
+			});
+	},
+
+	/**
+	 * Leaves the password out of a host that's sent to a client or the audit log
+	 *
+	 * @param   {Object}  row
+	 * @returns {Object}
+	 */
+	mask: (row) => {
+		if (row && row.upstream_auth && !_.isEmpty(row.upstream_auth)) {
+			row.upstream_auth = {username: row.upstream_auth.username, password: ''};
+		}
+		return row;
+	},
+
+	/**
+	 * What to write into the config of a proxy host, or null to pass the Authorization header
+	 * of the client, if the access list lets it through
+	 *
+	 * @param   {Object}  host
+	 * @returns {Promise}
+	 */
+	getOptions: (host) => {
+		const upstream_auth = host.upstream_auth;
+		if (!upstream_auth || !upstream_auth.username) {
+			return Promise.resolve(null);
+		}
+
+		// Hosts that were read for a client have had the password taken out
+		return (upstream_auth.password ? Promise.resolve(upstream_auth) : internalUpstreamAuth.getStored(host.id))
+			.then((stored) => {
+				if (!stored || !stored.password) {
+					return null;
+				}
+				return {
+					authorization: 'Basic ' + Buffer.from(upstream_auth.username + ':' + stored.password).toString('base64')
+				};
+			});
+	}
+};
+
+module.exports = internalUpstreamAuth;
@@ -0,0 +1,45 @@
+const _ = require('lodash');
+
+const internalUpstreamSets = {
+
+	/**
+	 * @param   {String}  set  'blue' or 'green'
+	 * @returns {String}
+	 */
+	getOther: (set) => {
+		return set === 'blue' ? 'green' : 'blue';
+	},
+
+	/**
+	 * The forward host of a host is the active set, so they're kept the same. Sets given replace the
+	 * forward host, and a forward host given on its own changes the active set.
+	 *
+	 * @param   {Object}  data   payload, changed in place
+	 * @param   {Object}  [row]  existing host
+	 */
+	prepare: (data, row) => {
+		let upstream_sets = typeof data.upstream_sets !== 'undefined' ? data.upstream_sets : (row ? row.upstream_sets : null);
+		if (!upstream_sets) {
+			return;
+		}
+
+		if (typeof data.upstream_sets === 'undefined') {
+			const changes = _.pick(data, ['forward_scheme', 'forward_host', 'forward_port']);
+			if (_.isEmpty(changes)) {
+				return;
+			}
+
+			upstream_sets                       = _.cloneDeep(upstream_sets);
+			upstream_sets[upstream_sets.active] = _.assign({}, upstream_sets[upstream_sets.active], changes);
+			data.upstream_sets                  = upstream_sets;
+			return;
+		}
+
+		const active        = upstream_sets[upstream_sets.active];
+		data.forward_scheme = active.forward_scheme || data.forward_scheme || (row ? row.forward_scheme : 'http');
+		data.forward_host   = active.forward_host;
+		data.forward_port   = active.forward_port;
+	}
+};
+
+module.exports = internalUpstreamSets;
@@ -0,0 +1,145 @@
+const _                    = require('lodash');
+const error                = require('../lib/error');
+const logger               = require('../logger').nginx;
+const internalProxyHost    = require('./proxy-host');
+const internalDiagnose     = require('./diagnose');
+const internalUpstreamSets = require('./upstream-sets');
+
+const SETS = ['blue', 'green'];
+
+const internalUpstreamSwitch = {
+
+	/**
+	 * Whether nginx would get an answer from the forward host, the same way the diagnosis does
+	 *
+	 * @param   {Object}  host
+	 * @param   {Object}  upstream  ie: {forward_scheme, forward_host, forward_port}
+	 * @param   {String}  path
+	 * @returns {Promise}  resolves with the results of each step, rejects at the first that fails
+	 */
+	check: (host, upstream, path) => {
+		const target = {
+			location: null,
+			scheme:   upstream.forward_scheme || host.forward_scheme,
+			host:     upstream.forward_host,
+			port:     upstream.forward_port,
+			path:     path
+		};
+
+		let checks  = [];
+		let address = null;
+
+		const run = (name, fn) => {
+			const started = Date.now();
+			return fn()
+				.then((detail) => {
+					checks.push({step: name, ok: true, time_ms: Date.now() - started, detail: detail, error: null, hint: null});
+				})
+				.catch((err) => {
+					throw new error.ValidationError('The ' + target.scheme + '://' + target.host + ':' + target.port + ' forward host failed its ' + name + ' check: ' + err.message);
+				});
+		};
+
+		return run('dns', () => {
+			return internalDiagnose.resolve(target.host)
+				.then((detail) => {
+					address = detail.addresses[0];
+					return detail;
+				});
+		})
+			.then(() => run('tcp', () => internalDiagnose.connect(address, target.port)))
+			.then(() => {
+				if (target.scheme === 'https') {
+					return run('tls', () => internalDiagnose.handshake(host, target, address));
+				}
+			})
+			.then(() => {
+				const headers = internalDiagnose.getHeaders(host, host.certificate_id ? 'https' : 'http');
+				return run('http', () => internalDiagnose.request(host, target, address, headers));
+			})
+			.then(() => {
+				return checks;
+			});
+	},
+
+	/**
+	 * Points a proxy host at its other set of forward hosts, after checking it answers. The host
+	 * is switched back when nginx doesn't take the new config.
+	 *
+	 * @param   {Access}   access
+	 * @param   {Object}   data
+	 * @param   {Number}   data.id
+	 * @param   {String}   [data.to]     'blue' or 'green', the one that isn't active by default
+	 * @param   {Boolean}  [data.check]  false to switch straight away, such as to roll back
+	 * @returns {Promise}
+	 */
+	switch: (access, data) => {
+		let host   = null;
+		let from   = null;
+		let to     = null;
+		let checks = [];
+
+		return internalProxyHost.get(access, {id: data.id})
+			.then((row) => {
+				host = row;
+
+				if (!host.upstream_sets) {
+					throw new error.ValidationError('Proxy Host #' + host.id + ' has no blue and green forward hosts to switch between');
+				}
+
+				from = host.upstream_sets.active;
+				to   = data.to || internalUpstreamSets.getOther(from);
+
+				if (SETS.indexOf(to) === -1) {
+					throw new error.ValidationError('Upstream set must be one of ' + SETS.join(', '));
+				}
+
+				if (to === from) {
+					throw new error.ValidationError('The ' + to + ' forward host is already active');
+				}
+
+				if (data.check === false) {
+					return;
+				}
+
+				return internalUpstreamSwitch.check(host, host.upstream_sets[to], host.upstream_sets.check_path || '/')
+					.then((results) => {
+						checks = results;
+					});
+			})
+			.then(() => {
+				return internalProxyHost.update(access, {
+					id:            host.id,
+					upstream_sets: _.assign({}, host.upstream_sets, {active: to})
+				});
+			})
+			.then((row) => {
+				if (!row.enabled || (row.meta && row.meta.nginx_online !== false)) {
+					return row;
+				}
+
+				// Put it back the way it was, so the host keeps serving
+				const nginx_err = row.meta.nginx_err;
+				logger.warn('Switching Proxy Host #' + host.id + ' to ' + to + ' failed, switching back to ' + from);
+
+				return internalProxyHost.update(access, {
+					id:            host.id,
+					upstream_sets: _.assign({}, host.upstream_sets, {active: from})
+				})
+					.then(() => {
+						throw new error.ConfigurationError('nginx didn\'t accept the ' + to + ' forward host, so ' + from + ' is still active: ' + nginx_err);
+					});
+			})
+			.then((row) => {
+				return {
+					object_id: row.id,
+					from:      from,
+					to:        to,
+					checks:    checks,
+					host:      row
+				};
+			});
+	}
+};
+
+module.exports = internalUpstreamSwitch;
@@ -0,0 +1,137 @@
+const _                = require('lodash');
+const fs               = require('fs');
+const crypto           = require('crypto');
+const config           = require('../lib/config');
+const error            = require('../lib/error');
+const certificateModel = require('../models/certificate');
+const internalStorage  = require('./certificate-storage');
+
+const caDir        = config.getPath('upstream_ca');
+const systemCaFile = '/etc/ssl/certs/ca-certificates.crt';
+
+const PEM_CERTIFICATE = /-----BEGIN CERTIFICATE-----[^-]+-----END CERTIFICATE-----/g;
+
+const internalUpstreamTls = {
+
+	/**
+	 * @param   {Number}  host_id
+	 * @returns {String}
+	 */
+	getCaFile: (host_id) => {
+		return caDir + '/proxy-host-' + host_id + '.pem';
+	},
+
+	/**
+	 * Where nginx reads a certificate and its key from
+	 *
+	 * @param   {Object}  certificate
+	 * @returns {Object}
+	 */
+	getCertificateFiles: (certificate) => {
+		const dir = internalStorage.getDirectory(certificate);
+
+		return {
+			certificate:     dir + '/fullchain.pem',
+			certificate_key: dir + '/privkey.pem'
+		};
+	},
+
+	/**
+	 * Checks the CA bundle is made of certificates, and that the client certificate exists
+	 *
+	 * @param   {Object}  upstream_tls  payload
+	 * @returns {Promise}
+	 */
+	validate: (upstream_tls) => {
+		if (!upstream_tls) {
+			return Promise.resolve();
+		}
+
+		if (upstream_tls.ca_bundle) {
+			const pems = upstream_tls.ca_bundle.match(PEM_CERTIFICATE);
+			if (!pems) {
+				return Promise.reject(new error.ValidationError('The CA bundle must contain PEM encoded certificates'));
+			}
+
+			try {
+				pems.forEach((pem) => {
+					new crypto.X509Certificate(pem);
+				});
+			} catch (err) {
+				return Promise.reject(new error.ValidationError('The CA bundle contains a certificate that can\'t be read: ' + err.message));
+			}
+		}
+
+		if (!upstream_tls.client_certificate_id) {
+			return Promise.resolve();
+		}
+
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('id', upstream_tls.client_certificate_id)
+			.first()
+			.then((certificate) => {
+				if (!certificate) {
+					throw new error.ValidationError('Client certificate #' + upstream_tls.client_certificate_id + ' doesn\'t exist');
+				}
+			});
+	},
+
+	/**
+	 * Writes the CA bundle of a host for nginx to read, or removes it when there isn't one
+	 *
+	 * @param {Object}  host
+	 */
+	writeCaBundle: (host) => {
+		const file = internalUpstreamTls.getCaFile(host.id);
+
+		if (host.upstream_tls && host.upstream_tls.ca_bundle) {
+			if (!fs.existsSync(caDir)) {
+				fs.mkdirSync(caDir);
+			}
+			fs.writeFileSync(file, host.upstream_tls.ca_bundle, {encoding: 'utf8'});
+		} else if (fs.existsSync(file)) {
+			fs.unlinkSync(file);
+		}
+	},
+
+	/**
+	 * What to write into the config of a proxy host, or null to leave nginx's defaults,
+	 * which don't verify the upstream certificate at all
+	 *
+	 * @param   {Object}  host
+	 * @returns {Promise}
+	 */
+	getOptions: (host) => {
+		const upstream_tls = host.upstream_tls;
+		if (!upstream_tls || _.isEmpty(upstream_tls)) {
+			return Promise.resolve(null);
+		}
+
+		let options = {
+			verify:              !!upstream_tls.verify,
+			trusted_certificate: upstream_tls.ca_bundle ? internalUpstreamTls.getCaFile(host.id) : systemCaFile,
+			server_name:         upstream_tls.server_name || null,
+			client_certificate:  null
+		};
+
+		if (!upstream_tls.client_certificate_id) {
+			return Promise.resolve(options);
+		}
+
+		return certificateModel
+			.query()
+			.where('is_deleted', 0)
+			.andWhere('id', upstream_tls.client_certificate_id)
+			.first()
+			.then((certificate) => {
+				if (certificate) {
+					options.client_certificate = internalUpstreamTls.getCertificateFiles(certificate);
+				}
+				return options;
+			});
+	}
+};
+
+module.exports = internalUpstreamTls;
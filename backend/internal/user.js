@@ -7,6 +7,8 @@ const authModel           = require('../models/auth');
 const gravatar            = require('gravatar');
 const internalToken       = require('./token');
 const internalAuditLog    = require('./audit-log');
+const internalTenant      = require('./tenant');
+const internalNotify      = require('./notifications');
 
 function omissions () {
 	return ['is_deleted'];
@@ -31,6 +33,12 @@ const internalUser = {
 		}
 
 		return access.can('users:create', data)
+			.then(() => {
+				return internalUser.checkTenant(access, data);
+			})
+			.then(() => {
+				return internalTenant.checkQuota(access, 'users');
+			})
 			.then(() => {
 				data.avatar = gravatar.url(data.email, {default: 'mm'});
 
@@ -96,6 +104,7 @@ const internalUser = {
 	 * @param  {Integer} data.id
 	 * @param  {String}  [data.email]
 	 * @param  {String}  [data.name]
+	 * @param  {Object}  [data.notification_preferences]
 	 * @return {Promise}
 	 */
 	update: (access, data) => {
@@ -104,6 +113,17 @@ const internalUser = {
 		}
 
 		return access.can('users:update', data.id)
+			.then(() => {
+				if (access.getTenantId()) {
+					// Users can't be moved out of the tenant by the tenant
+					delete data.tenant_id;
+				}
+
+				return internalUser.checkTenant(access, data);
+			})
+			.then(() => {
+				return internalNotify.validatePreferences(data.notification_preferences);
+			})
 			.then(() => {
 
 				// Make sure that the user being updated doesn't change their email to another user that is already using it
@@ -179,7 +199,7 @@ const internalUser = {
 		}
 
 		return access.can('users:get', data.id)
-			.then(() => {
+			.then((access_data) => {
 				let query = userModel
 					.query()
 					.where('is_deleted', 0)
@@ -187,6 +207,10 @@ const internalUser = {
 					.allowGraph('[permissions]')
 					.first();
 
+				if (access_data.tenant_id) {
+					query.andWhere('tenant_id', access_data.tenant_id);
+				}
+
 				if (typeof data.expand !== 'undefined' && data.expand !== null) {
 					query.withGraphFetched('[' + data.expand.join(', ') + ']');
 				}
@@ -205,6 +229,34 @@ const internalUser = {
 			});
 	},
 
+	/**
+	 * Users created by a tenant admin go in their tenant. Admins outside of any tenant
+	 * can put them in any tenant that exists.
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Integer} [data.tenant_id]
+	 * @returns {Promise}
+	 */
+	checkTenant: (access, data) => {
+		const tenant_id = access.getTenantId();
+		if (tenant_id) {
+			if (typeof data.id === 'undefined') {
+				data.tenant_id = tenant_id;
+			}
+			return Promise.resolve();
+		}
+
+		if (!data.tenant_id) {
+			return Promise.resolve();
+		}
+
+		return internalTenant.get(access, {id: data.tenant_id})
+			.catch(() => {
+				throw new error.ValidationError('Tenant #' + data.tenant_id + ' does not exist');
+			});
+	},
+
 	/**
 	 * Checks if an email address is available, but if a user_id is supplied, it will ignore checking
 	 * against that user.
@@ -281,13 +333,17 @@ const internalUser = {
 	 */
 	getCount: (access, search_query) => {
 		return access.can('users:list')
-			.then(() => {
+			.then((access_data) => {
 				let query = userModel
 					.query()
 					.count('id as count')
 					.where('is_deleted', 0)
 					.first();
 
+				if (access_data.tenant_id) {
+					query.andWhere('tenant_id', access_data.tenant_id);
+				}
+
 				// Query is used for searching
 				if (typeof search_query === 'string') {
 					query.where(function () {
@@ -313,13 +369,18 @@ const internalUser = {
 	 */
 	getAll: (access, expand, search_query) => {
 		return access.can('users:list')
-			.then(() => {
+			.then((access_data) => {
 				let query = userModel
 					.query()
 					.where('is_deleted', 0)
 					.groupBy('id')
 					.allowGraph('[permissions]')
-					.orderBy('name', 'ASC');
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (access_data.tenant_id) {
+					query.andWhere('tenant_id', access_data.tenant_id);
+				}
 
 				// Query is used for searching
 				if (typeof search_query === 'string') {
@@ -0,0 +1,187 @@
+const https      = require('https');
+const pjson      = require('../package.json');
+const config     = require('../lib/config');
+const utils      = require('../lib/utils');
+const proxyAgent = require('../lib/proxy-agent');
+const logger     = require('../logger').global;
+
+const TIMEOUT = 15000;
+
+// Releases are looked for this often at most, however often the page is opened
+const CHECK_INTERVAL = 1000 * 60 * 60 * 6;
+
+// The image of the zh fork, whose tags are its releases
+const IMAGE = process.env.UPGRADE_CHECK_IMAGE || 'alianhome/nginx-proxy-manager-zh';
+
+// What's bundled in the image, by the command that prints its version
+const COMPONENTS = {
+	nginx:   '/usr/sbin/nginx -v 2>&1',
+	certbot: '/opt/certbot/bin/certbot --version 2>&1',
+	lego:    'lego --version 2>&1',
+	acme_sh: 'acme.sh --version 2>&1'
+};
+
+// Read once, they don't change while the backend runs
+let components = null;
+
+// The last upgrade check, kept for CHECK_INTERVAL
+let last_check = null;
+
+/**
+ * @returns {String}  ie: '2.12.2'
+ */
+const getVersion = () => {
+	return process.env.NPM_BUILD_VERSION || pjson.version;
+};
+
+/**
+ * @param   {String}  a  ie: '2.12.2'
+ * @param   {String}  b
+ * @returns {Number}  above 0 when a is newer
+ */
+const compareVersions = (a, b) => {
+	const parts_a = a.split('.').map((part) => parseInt(part, 10));
+	const parts_b = b.split('.').map((part) => parseInt(part, 10));
+
+	for (let i = 0; i < 3; i++) {
+		if (parts_a[i] !== parts_b[i]) {
+			return parts_a[i] - parts_b[i];
+		}
+	}
+	return 0;
+};
+
+/**
+ * @returns {Promise}  resolves with the version of each component, null for ones that aren't installed
+ */
+const getComponents = () => {
+	if (components === null) {
+		const names = Object.keys(COMPONENTS);
+
+		components = Promise.all(names.map((name) => {
+			return utils.exec(COMPONENTS[name], {signal: null})
+				.then((output) => {
+					// ie: 'nginx version: openresty/1.21.4.3', 'certbot 2.11.0', 'lego version 4.21.0 linux/amd64' or the url of acme.sh and then 'v3.0.7'
+					const matches = output.match(/([a-z]+\/)?v?[0-9]+(\.[0-9]+)+/i);
+					return matches ? matches[0] : output.trim();
+				})
+				.catch(() => {
+					return null;
+				});
+		}))
+			.then((versions) => {
+				let result = {node: process.version};
+				names.forEach((name, index) => {
+					result[name] = versions[index];
+				});
+				return result;
+			});
+	}
+	return components;
+};
+
+/**
+ * @returns {Promise}  resolves with the newest version the image has a tag for
+ */
+const fetchLatest = () => {
+	return new Promise((resolve, reject) => {
+		const url = 'https://hub.docker.com/v2/repositories/' + IMAGE + '/tags?page_size=100&ordering=last_updated';
+
+		https.get(url, {timeout: TIMEOUT, agent: proxyAgent.forUrl(url), headers: {'User-Agent': 'nginx-proxy-manager/' + getVersion()}}, (res) => {
+			res.setEncoding('utf8');
+			let raw_data = '';
+			res.on('data', (chunk) => {
+				raw_data += chunk;
+			});
+
+			res.on('end', () => {
+				if (res.statusCode !== 200) {
+					reject(new Error('Docker Hub returned ' + res.statusCode));
+					return;
+				}
+
+				try {
+					const versions = (JSON.parse(raw_data).results || [])
+						.map((tag) => tag.name)
+						.filter((name) => /^[0-9]+\.[0-9]+\.[0-9]+$/.test(name))
+						.sort(compareVersions);
+
+					resolve(versions.length ? versions[versions.length - 1] : null);
+				} catch (err) {
+					reject(new Error('Docker Hub returned invalid JSON'));
+				}
+			});
+		})
+			.on('timeout', function () {
+				this.destroy(new Error('Docker Hub timed out'));
+			})
+			.on('error', (err) => {
+				reject(err);
+			});
+	});
+};
+
+/**
+ * @returns {Promise}  resolves with whether there's a newer image, and the error when it couldn't be found out
+ */
+const checkUpgrade = () => {
+	if (last_check !== null && Date.now() - new Date(last_check.checked_on).getTime() < CHECK_INTERVAL) {
+		return Promise.resolve(last_check);
+	}
+
+	return fetchLatest()
+		.then((latest) => {
+			return {
+				latest:    latest,
+				available: latest !== null && /^[0-9]+\.[0-9]+\.[0-9]+$/.test(getVersion()) && compareVersions(latest, getVersion()) > 0,
+				image:     latest !== null ? IMAGE + ':' + latest : null,
+				error:     null
+			};
+		}, (err) => {
+			logger.warn('Could not check for a newer version: ' + err.message);
+			return {
+				latest:    null,
+				available: null,
+				image:     null,
+				error:     err.message
+			};
+		})
+		.then((result) => {
+			last_check = Object.assign({checked_on: new Date().toISOString()}, result);
+			return last_check;
+		});
+};
+
+const internalVersion = {
+
+	getVersion: getVersion,
+
+	/**
+	 * What's running, and when asked, whether there's a newer image. The check is skipped when offline.
+	 *
+	 * @param   {Access}   access
+	 * @param   {Boolean}  [check]
+	 * @returns {Promise}
+	 */
+	get: (access, check) => {
+		return access.can('system:version')
+			.then(() => {
+				return Promise.all([
+					getComponents(),
+					check && !config.isOffline() ? checkUpgrade() : null
+				]);
+			})
+			.then(([versions, upgrade]) => {
+				return {
+					version:    getVersion(),
+					commit:     process.env.NPM_BUILD_COMMIT || null,
+					build_date: process.env.NPM_BUILD_DATE || null,
+					components: versions,
+					offline:    config.isOffline(),
+					upgrade:    upgrade
+				};
+			});
+	}
+};
+
+module.exports = internalVersion;
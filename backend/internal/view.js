@@ -0,0 +1,324 @@
+const _                = require('lodash');
+const moment           = require('moment');
+const error            = require('../lib/error');
+const {castJsonIfNeed} = require('../lib/helpers');
+const savedViewModel   = require('../models/saved_view');
+const certificateModel = require('../models/certificate');
+const internalAuditLog = require('./audit-log');
+
+/**
+ * The lists a view can be saved for, with what they can be sorted by and filtered on
+ */
+const TYPES = {
+	'proxy-host': {
+		sort:    ['domain_names', 'created_on', 'modified_on'],
+		enabled: true,
+		expires: true
+	},
+	'redirection-host': {
+		sort:    ['domain_names', 'created_on', 'modified_on'],
+		enabled: true,
+		expires: true
+	},
+	'dead-host': {
+		sort:    ['domain_names', 'created_on', 'modified_on'],
+		enabled: true,
+		expires: true
+	},
+	'stream': {
+		sort:    ['incoming_port', 'created_on', 'modified_on'],
+		enabled: true,
+		expires: false
+	},
+	'certificate': {
+		sort:    ['nice_name', 'expires_on', 'created_on', 'modified_on'],
+		enabled: false,
+		expires: true
+	}
+};
+
+function omissions () {
+	return ['is_deleted'];
+}
+
+const internalView = {
+
+	TYPES: TYPES,
+
+	/**
+	 * @param   {String}  object_type
+	 * @param   {Object}  [filter]
+	 * @param   {Object}  [sort]
+	 * @returns {Promise}
+	 */
+	validate: (object_type, filter, sort) => {
+		return Promise.resolve()
+			.then(() => {
+				const type = TYPES[object_type];
+
+				if (filter && typeof filter.enabled !== 'undefined' && !type.enabled) {
+					throw new error.ValidationError('A ' + object_type + ' view can\'t filter on enabled');
+				}
+				if (filter && typeof filter.expires_within_days !== 'undefined' && !type.expires) {
+					throw new error.ValidationError('A ' + object_type + ' view can\'t filter on when the certificate expires');
+				}
+				if (sort && sort.field && type.sort.indexOf(sort.field) === -1) {
+					throw new error.ValidationError('A ' + object_type + ' view can be sorted by ' + type.sort.join(', ') + ', not ' + sort.field);
+				}
+			});
+	},
+
+	/**
+	 * Adds what a view filters on and sorts by to the query of a list, after the project and tags
+	 *
+	 * @param   {Object}  query
+	 * @param   {String}  object_type
+	 * @param   {Object}  [filter]
+	 * @param   {Boolean} [filter.enabled]
+	 * @param   {Number}  [filter.expires_within_days]
+	 * @param   {Object}  [filter.sort]  {field, direction}
+	 */
+	applyFilter: (query, object_type, filter) => {
+		if (!filter) {
+			return;
+		}
+
+		if (typeof filter.enabled === 'boolean') {
+			query.andWhere('enabled', filter.enabled ? 1 : 0);
+		}
+
+		if (typeof filter.expires_within_days === 'number') {
+			const threshold = moment().add(filter.expires_within_days, 'days').format('YYYY-MM-DD HH:mm:ss');
+
+			if (object_type === 'certificate') {
+				query.andWhere('expires_on', '<', threshold);
+			} else {
+				// Hosts without a certificate don't expire
+				query.whereIn('certificate_id', certificateModel
+					.query()
+					.select('id')
+					.where('is_deleted', 0)
+					.andWhere('expires_on', '<', threshold));
+			}
+		}
+
+		if (filter.sort && filter.sort.field) {
+			const direction = filter.sort.direction === 'desc' ? 'DESC' : 'ASC';
+
+			query.clearOrder();
+			if (filter.sort.field === 'incoming_port') {
+				query.orderByRaw('CAST(incoming_port AS INTEGER) ' + direction);
+			} else {
+				query.orderBy(filter.sort.field === 'domain_names' ? castJsonIfNeed('domain_names') : filter.sort.field, direction);
+			}
+			query.orderBy('id', 'ASC');
+		}
+	},
+
+	/**
+	 * What to list with, from the view asked for and the query of the request. What's in the request
+	 * wins over the view, so a view can be narrowed down further.
+	 *
+	 * @param   {Access}  access
+	 * @param   {String}  object_type
+	 * @param   {Object}  data
+	 * @param   {Number}  [data.view]
+	 * @param   {Array}   [data.expand]
+	 * @param   {String}  [data.query]
+	 * @param   {Number}  [data.project_id]
+	 * @param   {Array}   [data.tag]
+	 * @returns {Promise}  resolves with {expand, query, filter}
+	 */
+	getListOptions: (access, object_type, data) => {
+		if (!data.view) {
+			return Promise.resolve({
+				expand: data.expand,
+				query:  data.query,
+				filter: {project_id: data.project_id, tags: data.tag}
+			});
+		}
+
+		return internalView.get(access, {id: data.view})
+			.then((view) => {
+				if (view.object_type !== object_type) {
+					throw new error.ValidationError('The view ' + view.name + ' is for the ' + view.object_type + ' list');
+				}
+
+				return {
+					expand: data.expand,
+					query:  data.query !== null ? data.query : (view.filter.query || null),
+					filter: {
+						project_id:          typeof data.project_id === 'number' ? data.project_id : view.filter.project_id,
+						tags:                data.tag || view.filter.tags || null,
+						enabled:             view.filter.enabled,
+						expires_within_days: view.filter.expires_within_days,
+						sort:                view.sort.field ? view.sort : null
+					}
+				};
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {String}  data.name
+	 * @param   {String}  data.object_type
+	 * @param   {Object}  [data.filter]
+	 * @param   {Object}  [data.sort]
+	 * @returns {Promise}
+	 */
+	create: (access, data) => {
+		return access.can('views:create', data)
+			.then(() => {
+				return internalView.validate(data.object_type, data.filter, data.sort);
+			})
+			.then(() => {
+				return savedViewModel
+					.query()
+					.insertAndFetch({
+						owner_user_id: access.token.getUserId(1),
+						name:          data.name,
+						object_type:   data.object_type,
+						filter:        data.filter || {},
+						sort:          data.sort || {},
+						meta:          {}
+					});
+			})
+			.then((row) => {
+				row = _.omit(row, omissions());
+
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'created',
+					object_type: 'view',
+					object_id:   row.id,
+					meta:        row
+				})
+					.then(() => {
+						return row;
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	update: (access, data) => {
+		const patch = _.pick(data, ['name', 'object_type', 'filter', 'sort']);
+
+		return access.can('views:update', data.id)
+			.then(() => {
+				return internalView.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return internalView.validate(patch.object_type || row.object_type, patch.filter || row.filter, patch.sort || row.sort)
+					.then(() => {
+						return savedViewModel
+							.query()
+							.patchAndFetchById(row.id, patch);
+					});
+			})
+			.then((saved_row) => {
+				// Add to audit log
+				return internalAuditLog.add(access, {
+					action:      'updated',
+					object_type: 'view',
+					object_id:   saved_row.id,
+					meta:        data
+				})
+					.then(() => {
+						return _.omit(saved_row, omissions());
+					});
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	delete: (access, data) => {
+		return access.can('views:delete', data.id)
+			.then(() => {
+				return internalView.get(access, {id: data.id});
+			})
+			.then((row) => {
+				return savedViewModel
+					.query()
+					.where('id', row.id)
+					.patch({
+						is_deleted: 1
+					})
+					.then(() => {
+						// Add to audit log
+						return internalAuditLog.add(access, {
+							action:      'deleted',
+							object_type: 'view',
+							object_id:   row.id,
+							meta:        row
+						});
+					});
+			})
+			.then(() => {
+				return true;
+			});
+	},
+
+	/**
+	 * Views belong to the user that saved them, nobody else sees them
+	 *
+	 * @param   {Access}  access
+	 * @param   {Object}  data
+	 * @param   {Number}  data.id
+	 * @returns {Promise}
+	 */
+	get: (access, data) => {
+		return access.can('views:get', data.id)
+			.then(() => {
+				return savedViewModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('id', data.id)
+					.andWhere('owner_user_id', access.token.getUserId(1))
+					.first();
+			})
+			.then((row) => {
+				if (!row || !row.id) {
+					throw new error.ItemNotFoundError(data.id);
+				}
+				return _.omit(row, omissions());
+			});
+	},
+
+	/**
+	 * @param   {Access}  access
+	 * @param   {String}  [object_type]  only the views for this list
+	 * @returns {Promise}
+	 */
+	getAll: (access, object_type) => {
+		return access.can('views:list')
+			.then(() => {
+				let query = savedViewModel
+					.query()
+					.where('is_deleted', 0)
+					.andWhere('owner_user_id', access.token.getUserId(1))
+					.orderBy('name', 'ASC')
+					.orderBy('id', 'ASC');
+
+				if (object_type) {
+					query.andWhere('object_type', object_type);
+				}
+
+				return query;
+			})
+			.then((rows) => {
+				return rows.map((row) => _.omit(row, omissions()));
+			});
+	}
+};
+
+module.exports = internalView;
@@ -0,0 +1,459 @@
+const _                = require('lodash');
+const fs               = require('fs');
+const net              = require('net');
+const path             = require('path');
+const crypto           = require('crypto');
+const config           = require('../lib/config');
+const logger           = require('../logger').nginx;
+const error            = require('../lib/error');
+const utils            = require('../lib/utils');
+const settingModel     = require('../models/setting');
+const proxyHostModel   = require('../models/proxy_host');
+const internalActivity = require('./activity');
+
+// The private key of the interface, made the first time it's turned on
+const KEY_FILE = config.getPath('wireguard') + '/private.key';
+
+// Peers are sent a keepalive at least every 2 minutes, so one without a handshake for longer is gone
+const HANDSHAKE_TIMEOUT = 180;
+
+// What the interface was last given, so a replica picks up a change saved on another
+let applied = null;
+
+// The status of each peer by public key, as last seen
+let states = {};
+
+// What comes before the 32 bytes of an X25519 private key in PKCS #8
+const PKCS8_PREFIX = Buffer.from('302e020100300506032b656e04220420', 'hex');
+
+/**
+ * @param   {String}  key  a base64 X25519 private key, as wg genkey makes it
+ * @returns {String}  its public key
+ */
+const getPublicKey = (key) => {
+	const private_key = crypto.createPrivateKey({
+		key:    Buffer.concat([PKCS8_PREFIX, Buffer.from(key, 'base64')]),
+		format: 'der',
+		type:   'pkcs8'
+	});
+	return Buffer.from(crypto.createPublicKey(private_key).export({format: 'jwk'}).x, 'base64url').toString('base64');
+};
+
+const internalWireguard = {
+
+	intervalTimeout:    1000 * 30, // 30 seconds
+	interval:           null,
+	intervalProcessing: false,
+
+	/**
+	 * Every replica has its own network namespace, so its own interface
+	 */
+	initTimer: () => {
+		logger.info('WireGuard Timer initialized');
+		internalWireguard.interval = setInterval(internalWireguard.processPeers, internalWireguard.intervalTimeout);
+		internalWireguard.processPeers();
+	},
+
+	/**
+	 * @returns {Promise}
+	 */
+	getSetting: () => {
+		return settingModel
+			.query()
+			.where('id', 'wireguard')
+			.first();
+	},
+
+	/**
+	 * @param   {String}   address  ie: 10.8.0.1/24
+	 * @returns {Boolean}
+	 */
+	isValidRange: (address) => {
+		const [ip, bits, extra] = String(address).split('/');
+		const version           = net.isIP(ip);
+
+		return !!version && typeof extra === 'undefined' && /^\d{1,3}$/.test(bits || '') && parseInt(bits, 10) <= (version === 6 ? 128 : 32);
+	},
+
+	/**
+	 * @param   {Object}  meta
+	 * @returns {Promise}
+	 */
+	validate: (meta) => {
+		if (!internalWireguard.isValidRange(meta.address)) {
+			return Promise.reject(new error.ValidationError('The address of the interface needs its range, ie: 10.8.0.1/24'));
+		}
+
+		const peers = meta.peers || [];
+		const names = _.filter(_.map(peers, 'name'), (name, index, all) => all.indexOf(name) !== index);
+		if (names.length) {
+			return Promise.reject(new error.ValidationError('Peers need different names: ' + _.uniq(names).join(', ')));
+		}
+		const keys = _.filter(_.map(peers, 'public_key'), (key, index, all) => all.indexOf(key) !== index);
+		if (keys.length) {
+			return Promise.reject(new error.ValidationError('A public key can only be given to one peer'));
+		}
+
+		const invalid = _.flatten(peers.map((peer) => peer.allowed_ips)).filter((range) => !internalWireguard.isValidRange(range));
+		if (invalid.length) {
+			return Promise.reject(new error.ValidationError('Not an IP range: ' + invalid.join(', ')));
+		}
+
+		return Promise.resolve();
+	},
+
+	/**
+	 * The private key of the interface, made the first time it's asked for
+	 *
+	 * @returns {String}
+	 */
+	getPrivateKey: () => {
+		if (!fs.existsSync(KEY_FILE)) {
+			const jwk = crypto.generateKeyPairSync('x25519').privateKey.export({format: 'jwk'});
+			fs.mkdirSync(path.dirname(KEY_FILE), {recursive: true});
+			fs.writeFileSync(KEY_FILE, Buffer.from(jwk.d, 'base64url').toString('base64') + '\n', {mode: 0o600});
+			logger.info('Made the WireGuard key');
+		}
+
+		return fs.readFileSync(KEY_FILE, {encoding: 'utf8'}).trim();
+	},
+
+	/**
+	 * @param   {Object}  meta  of the setting
+	 * @returns {String}  the config of the interface, as wg setconf reads it
+	 */
+	getConfig: (meta) => {
+		let lines = ['[Interface]', 'PrivateKey = ' + internalWireguard.getPrivateKey()];
+		if (meta.listen_port) {
+			lines.push('ListenPort = ' + meta.listen_port);
+		}
+
+		(meta.peers || []).forEach((peer) => {
+			lines.push('', '# ' + peer.name.replace(/[\r\n]+/g, ' '), '[Peer]', 'PublicKey = ' + peer.public_key);
+			if (peer.preshared_key) {
+				lines.push('PresharedKey = ' + peer.preshared_key);
+			}
+			if (peer.endpoint) {
+				lines.push('Endpoint = ' + peer.endpoint);
+			}
+			lines.push('AllowedIPs = ' + peer.allowed_ips.join(', '));
+			if (peer.persistent_keepalive) {
+				lines.push('PersistentKeepalive = ' + peer.persistent_keepalive);
+			}
+		});
+
+		return lines.join('\n') + '\n';
+	},
+
+	/**
+	 * @param   {String}  name  of the interface
+	 * @returns {Promise}  resolves with whether it's there
+	 */
+	exists: (name) => {
+		return utils.execFile('ip', ['link', 'show', 'dev', name])
+			.then(() => true, () => false);
+	},
+
+	/**
+	 * Makes the interface as the setting has it, or removes it when the setting is off. Peers that
+	 * didn't change keep their sessions.
+	 *
+	 * @param   {Object}  setting
+	 * @returns {Promise}
+	 */
+	configure: (setting) => {
+		const name  = (setting.meta && setting.meta.interface) || 'wg0';
+		const state = JSON.stringify([setting.value, setting.meta]);
+
+		// Not stopped with the request, as the interface would be left half set up, ie: without an address
+		const run = (cmd, args) => utils.execFile(cmd, args, {signal: null});
+
+		if (setting.value !== 'on') {
+			return internalWireguard.exists(name)
+				.then((exists) => {
+					if (exists) {
+						logger.info('Removing WireGuard interface ' + name);
+						return run('ip', ['link', 'del', 'dev', name]);
+					}
+				})
+				.then(() => {
+					applied = state;
+					states  = {};
+				});
+		}
+
+		const file = path.dirname(KEY_FILE) + '/' + name + '.conf';
+
+		return internalWireguard.exists(name)
+			.then((exists) => {
+				if (!exists) {
+					logger.info('Adding WireGuard interface ' + name);
+					return run('ip', ['link', 'add', 'dev', name, 'type', 'wireguard']);
+				}
+			})
+			.then(() => {
+				fs.writeFileSync(file, internalWireguard.getConfig(setting.meta), {encoding: 'utf8', mode: 0o600});
+				return run('wg', ['syncconf', name, file]);
+			})
+			.then(() => run('ip', ['address', 'flush', 'dev', name]))
+			.then(() => run('ip', ['address', 'add', setting.meta.address, 'dev', name]))
+			.then(() => run('ip', ['link', 'set', 'up', 'dev', name]))
+			.then(() => {
+				// The range of the interface is routed with its address, the rest of what the peers have needs routes of its own
+				const ranges = _.uniq(_.flatten((setting.meta.peers || []).map((peer) => peer.allowed_ips)));
+
+				return ranges.reduce((sequence, range) => {
+					return sequence.then(() => run('ip', ['route', 'replace', range, 'dev', name]));
+				}, Promise.resolve());
+			})
+			.then(() => {
+				applied = state;
+				states  = _.pick(states, _.map(setting.meta.peers || [], 'public_key'));
+			})
+			.catch((err) => {
+				throw new error.ValidationError('The WireGuard interface could not be set up, the container needs the NET_ADMIN capability: ' + (err.stderr || err.message).trim());
+			});
+	},
+
+	/**
+	 * What wg knows of the peers of the interface, by public key
+	 *
+	 * @param   {String}  name  of the interface
+	 * @returns {Promise}
+	 */
+	getPeers: (name) => {
+		return utils.execFile('wg', ['show', name, 'dump'])
+			.then((output) => {
+				let peers = {};
+				// The first line is the interface itself
+				output.split('\n').slice(1).forEach((line) => {
+					const fields = line.split('\t');
+					if (fields.length < 8) {
+						return;
+					}
+
+					const handshake = parseInt(fields[4], 10);
+					peers[fields[0]] = {
+						endpoint:         fields[2] === '(none)' ? null : fields[2],
+						latest_handshake: handshake ? new Date(handshake * 1000).toISOString() : null,
+						transfer_rx:      parseInt(fields[5], 10) || 0,
+						transfer_tx:      parseInt(fields[6], 10) || 0
+					};
+				});
+				return peers;
+			})
+			.catch((err) => {
+				logger.warn('WireGuard peers could not be read: ' + err.message);
+				return {};
+			});
+	},
+
+	/**
+	 * A peer is up with a recent handshake. One without a keepalive only has handshakes when there is traffic,
+	 * so without one it's idle rather than down.
+	 *
+	 * @param   {Object}  peer    from the setting
+	 * @param   {Object}  [seen]  from getPeers
+	 * @returns {String}  up, down or idle
+	 */
+	getState: (peer, seen) => {
+		const handshake = seen && seen.latest_handshake ? (Date.now() - new Date(seen.latest_handshake).getTime()) / 1000 : null;
+		if (handshake !== null && handshake < HANDSHAKE_TIMEOUT) {
+			return 'up';
+		}
+		return peer.persistent_keepalive ? 'down' : 'idle';
+	},
+
+	/**
+	 * The peer whose allowed IPs a forward host is in, only for addresses, as names could resolve anywhere
+	 *
+	 * @param   {Array}   peers         from the setting
+	 * @param   {String}  forward_host
+	 * @returns {Object|undefined}
+	 */
+	getPeer: (peers, forward_host) => {
+		const version = net.isIP(forward_host || '');
+		if (!version) {
+			return undefined;
+		}
+
+		return _.find(peers, (peer) => {
+			const list = new net.BlockList();
+			peer.allowed_ips.forEach((range) => {
+				const [ip, bits] = range.split('/');
+				if (net.isIP(ip) === version) {
+					list.addSubnet(ip, parseInt(bits, 10), version === 6 ? 'ipv6' : 'ipv4');
+				}
+			});
+			return list.check(forward_host, version === 6 ? 'ipv6' : 'ipv4');
+		});
+	},
+
+	/**
+	 * @param   {Object}  host
+	 * @returns {Array}   every forward host of a proxy host
+	 */
+	getForwardHosts: (host) => {
+		return _.compact([host.forward_host]
+			.concat((host.locations || []).map((location) => location.forward_host))
+			.concat((host.load_balancing && host.load_balancing.servers ? host.load_balancing.servers : []).map((server) => server.forward_host)));
+	},
+
+	/**
+	 * @param   {Array}   peers  from the setting
+	 * @param   {Array}   hosts  proxy host rows
+	 * @returns {Object}  the ids of the hosts forwarding through each peer, by public key
+	 */
+	getHostIds: (peers, hosts) => {
+		let ids = {};
+		hosts.forEach((host) => {
+			internalWireguard.getForwardHosts(host).forEach((forward_host) => {
+				const peer = internalWireguard.getPeer(peers, forward_host);
+				if (peer) {
+					ids[peer.public_key] = _.uniq((ids[peer.public_key] || []).concat([host.id]));
+				}
+			});
+		});
+		return ids;
+	},
+
+	/**
+	 * Whether a proxy host forwards through a peer that was last seen down, for its health
+	 *
+	 * @param   {Object}  host
+	 * @returns {Boolean}
+	 */
+	isDown: (host) => {
+		return _.some(internalWireguard.getForwardHosts(host), (forward_host) => {
+			return _.some(Object.keys(states), (key) => {
+				return states[key].state === 'down' && !!internalWireguard.getPeer([states[key].peer], forward_host);
+			});
+		});
+	},
+
+	/**
+	 * Triggered by a timer, this sets the interface up again when the setting was changed on another replica,
+	 * and records a peer going down or coming back on the hosts that forward through it
+	 *
+	 * @returns {Promise}
+	 */
+	processPeers: () => {
+		if (internalWireguard.intervalProcessing) {
+			return Promise.resolve(false);
+		}
+
+		internalWireguard.intervalProcessing = true;
+
+		let setting = null;
+
+		return internalWireguard.getSetting()
+			.then((row) => {
+				setting = row;
+				if (!setting || applied === JSON.stringify([setting.value, setting.meta])) {
+					return;
+				}
+				if (applied === null && setting.value !== 'on') {
+					// Nothing was set up that needs removing
+					applied = JSON.stringify([setting.value, setting.meta]);
+					return;
+				}
+				return internalWireguard.configure(setting);
+			})
+			.then(() => {
+				if (!setting || setting.value !== 'on') {
+					return;
+				}
+
+				const peers = setting.meta.peers || [];
+
+				return Promise.all([
+					internalWireguard.getPeers(setting.meta.interface || 'wg0'),
+					proxyHostModel
+						.query()
+						.where('is_deleted', 0)
+				])
+					.then(([seen, hosts]) => {
+						const ids = internalWireguard.getHostIds(peers, hosts);
+
+						return peers.reduce((sequence, peer) => {
+							const state = internalWireguard.getState(peer, seen[peer.public_key]);
+							const was   = states[peer.public_key] ? states[peer.public_key].state : null;
+
+							states[peer.public_key] = {peer: peer, state: state};
+
+							// Idle peers aren't known to be either, and what a peer was before a restart isn't known
+							if (!was || was === state || state === 'idle' || was === 'idle') {
+								return sequence;
+							}
+
+							logger[state === 'up' ? 'info' : 'warn']('WireGuard peer ' + peer.name + ' is ' + state);
+
+							return sequence.then(() => Promise.all((ids[peer.public_key] || []).map((id) => {
+								return internalActivity.record('proxy-host', id, 'health', state === 'up' ? 'tunnel-online' : 'tunnel-offline', state === 'up', 'WireGuard peer ' + peer.name);
+							})));
+						}, Promise.resolve());
+					});
+			})
+			.then(() => {
+				internalWireguard.intervalProcessing = false;
+				return true;
+			})
+			.catch((err) => {
+				logger.error(err.message);
+				internalWireguard.intervalProcessing = false;
+			});
+	},
+
+	/**
+	 * The interface and how each of its peers is doing
+	 *
+	 * @param   {Access}  access
+	 * @returns {Promise}
+	 */
+	getStatus: (access) => {
+		let setting = null;
+
+		return access.can('settings:get', 'wireguard')
+			.then(() => internalWireguard.getSetting())
+			.then((row) => {
+				setting = row;
+				if (setting.value !== 'on') {
+					return [{}, []];
+				}
+
+				return Promise.all([
+					internalWireguard.getPeers(setting.meta.interface || 'wg0'),
+					proxyHostModel
+						.query()
+						.where('is_deleted', 0)
+				]);
+			})
+			.then(([seen, hosts]) => {
+				const peers = setting.meta.peers || [];
+				const ids   = internalWireguard.getHostIds(peers, hosts);
+
+				return {
+					enabled:     setting.value === 'on',
+					interface:   setting.meta.interface || 'wg0',
+					public_key:  setting.value === 'on' ? getPublicKey(internalWireguard.getPrivateKey()) : null,
+					address:     setting.meta.address,
+					listen_port: setting.meta.listen_port || null,
+					peers:       peers.map((peer) => {
+						const found = seen[peer.public_key] || {};
+						return {
+							name:             peer.name,
+							public_key:       peer.public_key,
+							endpoint:         found.endpoint || peer.endpoint || null,
+							latest_handshake: found.latest_handshake || null,
+							transfer_rx:      found.transfer_rx || 0,
+							transfer_tx:      found.transfer_tx || 0,
+							status:           setting.value === 'on' ? internalWireguard.getState(peer, seen[peer.public_key]) : 'idle',
+							proxy_host_ids:   ids[peer.public_key] || []
+						};
+					})
+				};
+			});
+	}
+};
+
+module.exports = internalWireguard;
@@ -8,15 +8,86 @@
  *
  */
 
-const _              = require('lodash');
-const logger         = require('../logger').access;
-const Ajv            = require('ajv/dist/2020');
-const error          = require('./error');
-const userModel      = require('../models/user');
-const proxyHostModel = require('../models/proxy_host');
-const TokenModel     = require('../models/token');
-const roleSchema     = require('./access/roles.json');
-const permsSchema    = require('./access/permissions.json');
+const _                    = require('lodash');
+const logger               = require('../logger').access;
+const Ajv                  = require('ajv/dist/2020');
+const error                = require('./error');
+const internalCapabilities = require('./capabilities');
+const proxyHostModel       = require('../models/proxy_host');
+const TokenModel           = require('../models/token');
+const roleSchema           = require('./access/roles.json');
+const permsSchema          = require('./access/permissions.json');
+
+/**
+ * Names what each way of passing a permission needs, and which of those the actor doesn't have,
+ * so a denial can say why. Each property of an object is checked on its own.
+ *
+ * @param   {Object}  schema       of the permission, lib/access/*.json
+ * @param   {Array}   schemas      the roles, perms and objects schemas it refers to
+ * @param   {Object}  actor        what was checked against it
+ * @returns {Object}  ie: {requires: [['role:admin'], ['proxy_hosts:manage', 'role:user']], missing: ['proxy_hosts:manage']}
+ */
+const explainDenial = (schema, schemas, actor) => {
+	const ajv = new Ajv({strict: false, coerceTypes: true, schemas: schemas});
+
+	// ie: '^(manage)$' to 'manage'
+	const unpattern = (pattern) => {
+		return (pattern || '').replace(/^\^\(?|\)?\$$/g, '');
+	};
+
+	const label = (property, sub) => {
+		const ref = sub.$ref || '';
+		if (property === 'roles' && sub.items && sub.items.enum) {
+			return 'role:' + sub.items.enum.join('|');
+		}
+		if (property === 'data') {
+			return 'owner:' + ref.split('/').pop();
+		}
+		if (property.indexOf('permission_') === 0) {
+			return property.substring(11) + ':' + (ref ? ref.split('/').pop() : unpattern(sub.pattern));
+		}
+		if (sub.contains && sub.contains.pattern) {
+			return property + ':' + unpattern(sub.contains.pattern);
+		}
+		return property;
+	};
+
+	const alternatives = (schema.anyOf || [schema]).map((alternative) => {
+		if (alternative.$ref) {
+			return [{
+				name:   'role:' + alternative.$ref.split('/').pop(),
+				schema: alternative
+			}];
+		}
+
+		return Object.keys(alternative.properties || {}).map((property) => {
+			return {
+				name:   label(property, alternative.properties[property]),
+				schema: {
+					type:       'object',
+					required:   (alternative.required || []).indexOf(property) !== -1 ? [property] : [],
+					properties: {[property]: alternative.properties[property]}
+				}
+			};
+		});
+	});
+
+	// The closest is the one lacking the least, and of those the one the actor has the most of
+	let missing = null;
+	let met     = 0;
+	alternatives.forEach((requirements) => {
+		const unmet = requirements.filter((requirement) => !ajv.validate(requirement.schema, actor)).map((requirement) => requirement.name);
+		if (missing === null || unmet.length < missing.length || (unmet.length === missing.length && requirements.length - unmet.length > met)) {
+			missing = unmet;
+			met     = requirements.length - unmet.length;
+		}
+	});
+
+	return {
+		requires: alternatives.map((requirements) => _.map(requirements, 'name')),
+		missing:  missing || []
+	};
+};
 
 module.exports = function (token_string) {
 	let Token                 = new TokenModel();
@@ -26,6 +97,8 @@ module.exports = function (token_string) {
 	let allow_internal_access = false;
 	let user_roles            = [];
 	let permissions           = {};
+	let tenant_id             = 0;
+	let locale                = null;
 
 	/**
 	 * Loads the Token object from the token string
@@ -43,45 +116,39 @@ module.exports = function (token_string) {
 					.then((data) => {
 						token_data = data;
 
-						// At this point we need to load the user from the DB and make sure they:
-						// - exist (and not soft deleted)
-						// - still have the appropriate scopes for this token
-						// This is only required when the User ID is supplied or if the token scope has `user`
+						// At this point we need to make sure the user:
+						// - exists (and not soft deleted)
+						// - still has the appropriate scopes for this token
+						// This is only required when the User ID is supplied or if the token scope has `user`.
+						// The capabilities in the token are used as they are while they're the current ones,
+						// otherwise they're loaded from the DB.
 
 						if (token_data.attrs.id || (typeof token_data.scope !== 'undefined' && _.indexOf(token_data.scope, 'user') !== -1)) {
 							// Has token user id or token user scope
-							return userModel
-								.query()
-								.where('id', token_data.attrs.id)
-								.andWhere('is_deleted', 0)
-								.andWhere('is_disabled', 0)
-								.allowGraph('[permissions]')
-								.withGraphFetched('[permissions]')
-								.first()
-								.then((user) => {
-									if (user) {
-										// make sure user has all scopes of the token
-										// The `user` role is not added against the user row, so we have to just add it here to get past this check.
-										user.roles.push('user');
-
-										let is_ok = true;
-										_.forEach(token_data.scope, (scope_item) => {
-											if (_.indexOf(user.roles, scope_item) === -1) {
-												is_ok = false;
-											}
-										});
-
-										if (!is_ok) {
-											throw new error.AuthError('Invalid token scope for User');
-										} else {
-											initialised = true;
-											user_roles  = user.roles;
-											permissions = user.permissions;
-										}
+							const user_id = token_data.attrs.id;
 
-									} else {
+							return Promise.resolve()
+								.then(() => {
+									if (internalCapabilities.isCurrent(user_id, token_data.caps)) {
+										return token_data.caps;
+									}
+									return internalCapabilities.load(user_id);
+								})
+								.then((capabilities) => {
+									if (!capabilities) {
 										throw new error.AuthError('User cannot be loaded for Token');
 									}
+
+									// make sure user has all scopes of the token
+									if (_.difference(token_data.scope, capabilities.roles).length) {
+										throw new error.AuthError('Invalid token scope for User');
+									}
+
+									initialised = true;
+									user_roles  = capabilities.roles;
+									permissions = capabilities.permissions;
+									tenant_id   = capabilities.tenant_id;
+									locale      = capabilities.locale;
 								});
 						} else {
 							initialised = true;
@@ -234,6 +301,26 @@ module.exports = function (token_string) {
 
 		reloadObjects: this.loadObjects,
 
+		/**
+		 * The tenant of the user, 0 when they're outside of any and see everything.
+		 * Only known once the token has been checked with can()
+		 *
+		 * @returns {Number}
+		 */
+		getTenantId: () => {
+			return tenant_id;
+		},
+
+		/**
+		 * The language the user picked, null when they haven't.
+		 * Only known once the token has been checked with can()
+		 *
+		 * @returns {String|null}
+		 */
+		getLocale: () => {
+			return locale;
+		},
+
 		/**
 		 *
 		 * @param {String}  permission
@@ -261,7 +348,9 @@ module.exports = function (token_string) {
 										permission_dead_hosts:        permissions.dead_hosts,
 										permission_streams:           permissions.streams,
 										permission_access_lists:      permissions.access_lists,
-										permission_certificates:      permissions.certificates
+										permission_certificates:      permissions.certificates,
+										permission_change_requests:   permissions.change_requests,
+										tenant_id:                    tenant_id
 									}
 								};
 
@@ -291,6 +380,11 @@ module.exports = function (token_string) {
 								return ajv.validate('permissions', data_schema)
 									.then(() => {
 										return data_schema[permission];
+									}, (err) => {
+										if (err instanceof Ajv.ValidationError) {
+											err.denied = explainDenial(permissionSchema.properties[permission], [roleSchema, permsSchema, objectSchema], data_schema[permission]);
+										}
+										throw err;
 									});
 							});
 					})
@@ -299,7 +393,11 @@ module.exports = function (token_string) {
 						err.permission_data = data;
 						logger.error(permission, data, err.message);
 
-						throw new error.PermissionError('Permission Denied', err);
+						let denied = new error.PermissionError('Permission Denied', err);
+						if (err.denied) {
+							denied.denied = Object.assign({permission: permission}, err.denied);
+						}
+						throw denied;
 					});
 			}
 		}
@@ -0,0 +1,268 @@
+const crypto     = require('crypto');
+const https      = require('https');
+const error      = require('./error');
+const proxyAgent = require('./proxy-agent');
+const logger     = require('../logger').certbot;
+
+/**
+ * A small ACME (RFC 8555) client for the account operations that certbot doesn't
+ * support: registering additional accounts, key rollover and deactivation.
+ */
+const acme = {
+
+	/**
+	 * @param   {Buffer|String}  data
+	 * @returns {String}
+	 */
+	b64url: (data) => {
+		return Buffer.from(data).toString('base64')
+			.replace(/=+$/, '')
+			.replace(/\+/g, '-')
+			.replace(/\//g, '_');
+	},
+
+	/**
+	 * @param   {String}  url
+	 * @param   {Object}  [options]
+	 * @param   {String}  [options.method]
+	 * @param   {Object}  [options.body]
+	 * @returns {Promise}
+	 */
+	request: (url, options) => {
+		options = options || {};
+
+		return new Promise((resolve, reject) => {
+			const body = typeof options.body !== 'undefined' ? JSON.stringify(options.body) : null;
+			const req  = https.request(url, {
+				method:  options.method || 'GET',
+				timeout: 30000,
+				agent:   proxyAgent.forUrl(url),
+				headers: body === null ? {} : {
+					'Content-Type':   'application/jose+json',
+					'Content-Length': Buffer.byteLength(body)
+				}
+			}, (res) => {
+				res.setEncoding('utf8');
+				let raw_data = '';
+				res.on('data', (chunk) => {
+					raw_data += chunk;
+				});
+
+				res.on('end', () => {
+					let parsed = null;
+					try {
+						parsed = raw_data ? JSON.parse(raw_data) : null;
+					} catch (err) {
+						parsed = raw_data;
+					}
+
+					resolve({
+						status:  res.statusCode,
+						headers: res.headers,
+						body:    parsed
+					});
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('ACME request to ' + url + ' timed out'));
+			});
+			req.on('error', reject);
+
+			if (body !== null) {
+				req.write(body);
+			}
+			req.end();
+		});
+	},
+
+	/**
+	 * @param   {String}  server  Directory URL
+	 * @returns {Promise}
+	 */
+	getDirectory: (server) => {
+		return acme.request(server)
+			.then((res) => {
+				if (res.status !== 200 || !res.body || !res.body.newNonce) {
+					throw new error.ValidationError('Could not load the ACME directory from ' + server);
+				}
+				return res.body;
+			});
+	},
+
+	/**
+	 * @param   {Object}  directory
+	 * @returns {Promise}
+	 */
+	getNonce: (directory) => {
+		return acme.request(directory.newNonce, {method: 'HEAD'})
+			.then((res) => {
+				return res.headers['replay-nonce'];
+			});
+	},
+
+	/**
+	 * @param   {KeyObject}  key  private or public key
+	 * @returns {Object}
+	 */
+	jwk: (key) => {
+		const jwk = crypto.createPublicKey(key).export({format: 'jwk'});
+
+		// Members in the order required for the RFC 7638 thumbprint
+		if (jwk.kty === 'EC') {
+			return {crv: jwk.crv, kty: jwk.kty, x: jwk.x, y: jwk.y};
+		}
+		return {e: jwk.e, kty: jwk.kty, n: jwk.n};
+	},
+
+	/**
+	 * @param   {KeyObject}  key
+	 * @returns {String}
+	 */
+	alg: (key) => {
+		return key.asymmetricKeyType === 'ec' ? 'ES256' : 'RS256';
+	},
+
+	/**
+	 * @param   {Object}         header
+	 * @param   {Object|String}  payload  an empty string for POST-as-GET
+	 * @param   {KeyObject}      key
+	 * @returns {Object}
+	 */
+	sign: (header, payload, key) => {
+		const protected64 = acme.b64url(JSON.stringify(header));
+		const payload64   = payload === '' ? '' : acme.b64url(JSON.stringify(payload));
+		const input       = protected64 + '.' + payload64;
+
+		let signature;
+		if (header.alg === 'HS256') {
+			signature = crypto.createHmac('sha256', key).update(input).digest();
+		} else {
+			signature = crypto.sign('sha256', Buffer.from(input), {key: key, dsaEncoding: 'ieee-p1363'});
+		}
+
+		return {
+			protected: protected64,
+			payload:   payload64,
+			signature: acme.b64url(signature)
+		};
+	},
+
+	/**
+	 * Signs and posts a request, retrying once when the nonce was rejected
+	 *
+	 * @param   {Object}     directory
+	 * @param   {String}     url
+	 * @param   {Object}     payload
+	 * @param   {KeyObject}  key
+	 * @param   {String}     [kid]  Account URL, the JWK is embedded when omitted
+	 * @param   {Boolean}    [retried]
+	 * @returns {Promise}
+	 */
+	post: (directory, url, payload, key, kid, retried) => {
+		return acme.getNonce(directory)
+			.then((nonce) => {
+				const header = {alg: acme.alg(key), nonce: nonce, url: url};
+				if (kid) {
+					header.kid = kid;
+				} else {
+					header.jwk = acme.jwk(key);
+				}
+
+				return acme.request(url, {method: 'POST', body: acme.sign(header, payload, key)});
+			})
+			.then((res) => {
+				if (res.status === 400 && res.body && res.body.type === 'urn:ietf:params:acme:error:badNonce' && !retried) {
+					return acme.post(directory, url, payload, key, kid, true);
+				}
+
+				if (res.status >= 400) {
+					const detail = res.body && res.body.detail ? res.body.detail : 'HTTP ' + res.status;
+					logger.error('ACME request to ' + url + ' failed: ' + detail);
+					throw new error.ValidationError('ACME server error: ' + detail);
+				}
+
+				return res;
+			});
+	},
+
+	/**
+	 * @param   {String}     server
+	 * @param   {KeyObject}  key
+	 * @param   {Object}     data
+	 * @param   {String}     [data.email]
+	 * @param   {String}     [data.eab_kid]
+	 * @param   {String}     [data.eab_hmac_key]  base64url encoded
+	 * @returns {Promise}    resolves with the account URL and body
+	 */
+	newAccount: (server, key, data) => {
+		return acme.getDirectory(server)
+			.then((directory) => {
+				const payload = {termsOfServiceAgreed: true};
+
+				if (data.email) {
+					payload.contact = ['mailto:' + data.email];
+				}
+
+				if (data.eab_kid && data.eab_hmac_key) {
+					payload.externalAccountBinding = acme.sign({
+						alg: 'HS256',
+						kid: data.eab_kid,
+						url: directory.newAccount
+					}, acme.jwk(key), Buffer.from(data.eab_hmac_key, 'base64url'));
+				} else if (directory.meta && directory.meta.externalAccountRequired) {
+					throw new error.ValidationError('This CA requires External Account Binding credentials');
+				}
+
+				return acme.post(directory, directory.newAccount, payload, key);
+			})
+			.then((res) => {
+				return {
+					url:  res.headers.location,
+					body: res.body
+				};
+			});
+	},
+
+	/**
+	 * @param   {String}     server
+	 * @param   {String}     account_url
+	 * @param   {KeyObject}  old_key
+	 * @param   {KeyObject}  new_key
+	 * @returns {Promise}
+	 */
+	keyChange: (server, account_url, old_key, new_key) => {
+		return acme.getDirectory(server)
+			.then((directory) => {
+				const inner = acme.sign({
+					alg: acme.alg(new_key),
+					jwk: acme.jwk(new_key),
+					url: directory.keyChange
+				}, {
+					account: account_url,
+					oldKey:  acme.jwk(old_key)
+				}, new_key);
+
+				return acme.post(directory, directory.keyChange, inner, old_key, account_url);
+			});
+	},
+
+	/**
+	 * @param   {String}     server
+	 * @param   {String}     account_url
+	 * @param   {KeyObject}  key
+	 * @param   {Object}     payload  eg {status: 'deactivated'} or {contact: [...]}
+	 * @returns {Promise}
+	 */
+	updateAccount: (server, account_url, key, payload) => {
+		return acme.getDirectory(server)
+			.then((directory) => {
+				return acme.post(directory, account_url, payload, key, account_url);
+			})
+			.then((res) => {
+				return res.body;
+			});
+	}
+};
+
+module.exports = acme;
@@ -0,0 +1,69 @@
+const fs     = require('fs');
+const logger = require('../logger').global;
+
+const bootstrapFile = process.env.BOOTSTRAP_FILE || '/data/bootstrap.json';
+
+let data = null;
+
+/**
+ * What a new instance is set up with on its first start, for provisioning without the UI.
+ * Every part is optional, and the environment variables for the same thing win over the file.
+ *
+ * {
+ *   "database": "postgres://npm:secret@db:5432/npm",
+ *   "jwt":      {"private_key_file": "/run/secrets/jwt.pem"},
+ *   "admin":    {"email": "ops@example.com", "password": "...", "name": "Ops", "nickname": "Ops"},
+ *   "settings": {"default-site": {"value": "404"}},
+ *   "setup":    {"wizard": true, "token": "..."}
+ * }
+ */
+const load = () => {
+	data = {};
+	if (!fs.existsSync(bootstrapFile)) {
+		return;
+	}
+
+	try {
+		data = JSON.parse(fs.readFileSync(bootstrapFile, {encoding: 'utf8'}));
+	} catch (err) {
+		// Carrying on would set the instance up with defaults nobody asked for
+		logger.error('Could not read bootstrap file: ' + bootstrapFile + ': ' + err.message);
+		process.exit(1);
+	}
+
+	logger.info('Using bootstrap file: ' + bootstrapFile);
+};
+
+module.exports = {
+
+	/**
+	 * @param   {String}  key  'database', 'jwt', 'admin', 'settings' or 'setup'
+	 * @returns {*}       undefined when the file doesn't have it
+	 */
+	get: function (key) {
+		data === null && load();
+		return data[key];
+	},
+
+	/**
+	 * The PEM private key to sign tokens with, instead of generating one
+	 *
+	 * @returns {String|null}
+	 */
+	getPrivateKey: function () {
+		const jwt  = this.get('jwt') || {};
+		const file = process.env.JWT_PRIVATE_KEY_FILE || jwt.private_key_file || null;
+
+		if (file) {
+			return fs.readFileSync(file, {encoding: 'utf8'});
+		}
+		return jwt.private_key || null;
+	},
+
+	/**
+	 * @returns {String|null}  ie: 'mysql://npm:secret@db:3306/npm'
+	 */
+	getDatabaseDsn: function () {
+		return process.env.DB_DSN || this.get('database') || null;
+	}
+};
@@ -0,0 +1,98 @@
+const _         = require('lodash');
+const crypto    = require('crypto');
+const readCache = require('./read-cache');
+const userModel = require('../models/user');
+
+// What the capabilities are read from, a write to them by this replica makes every known version stale
+const TABLES = ['user', 'user_permission'];
+
+// A change saved on another replica isn't seen here, so a version is only trusted this long after it was read
+const TRUSTED_FOR = 1000 * 30;
+
+// The permissions of a user that access.can() checks
+const PERMISSIONS = ['visibility', 'proxy_hosts', 'redirection_hosts', 'dead_hosts', 'streams', 'access_lists', 'certificates', 'change_requests'];
+
+// The version last read from the database for each user id, {v, generation, expires}
+let known = {};
+
+const internalCapabilities = {
+
+	/**
+	 * What a user can do, as put in the tokens issued to them, with a version that changes with any of it
+	 *
+	 * @param   {Object}  user  with its permissions
+	 * @returns {Object}  ie: {v: '9f86d081884c7d65', roles: ['admin', 'user'], permissions: {visibility: 'all', ...}, tenant_id: 0, locale: null}
+	 */
+	fromUser: (user) => {
+		// The `user` role is not added against the user row
+		const capabilities = {
+			roles:       _.uniq((user.roles || []).concat('user')).sort(),
+			permissions: _.pick(user.permissions || {}, PERMISSIONS),
+			tenant_id:   user.tenant_id || 0,
+			locale:      user.locale || null
+		};
+
+		return Object.assign({
+			v: crypto.createHash('sha256').update(JSON.stringify(capabilities)).digest('hex').substring(0, 16)
+		}, capabilities);
+	},
+
+	/**
+	 * Reads the capabilities of a user from the database, and remembers their version
+	 *
+	 * @param   {Integer}  user_id
+	 * @returns {Promise}  null when the user is deleted or disabled
+	 */
+	load: (user_id) => {
+		const started = readCache.getGeneration(TABLES);
+
+		return userModel
+			.query()
+			.where('id', user_id)
+			.andWhere('is_deleted', 0)
+			.andWhere('is_disabled', 0)
+			.allowGraph('[permissions]')
+			.withGraphFetched('[permissions]')
+			.first()
+			.then((user) => {
+				if (!user) {
+					delete known[user_id];
+					return null;
+				}
+
+				const capabilities = internalCapabilities.fromUser(user);
+				known[user_id]     = {
+					v:          capabilities.v,
+					generation: started,
+					expires:    Date.now() + TRUSTED_FOR
+				};
+
+				return capabilities;
+			});
+	},
+
+	/**
+	 * Whether the capabilities in a token are still those of the user, without reading the database.
+	 * False when that isn't known, and they have to be loaded again.
+	 *
+	 * @param   {Integer}  user_id
+	 * @param   {Object}   [capabilities]  from the token
+	 * @returns {Boolean}
+	 */
+	isCurrent: (user_id, capabilities) => {
+		const entry = known[user_id];
+		if (!entry || !capabilities || capabilities.v !== entry.v) {
+			return false;
+		}
+
+		// Written to since it was read, or it was read while a write was running
+		if (entry.expires <= Date.now() || readCache.getGeneration(TABLES) !== entry.generation) {
+			delete known[user_id];
+			return false;
+		}
+
+		return true;
+	}
+};
+
+module.exports = internalCapabilities;
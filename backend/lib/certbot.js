@@ -73,6 +73,29 @@ const certbot = {
 				throw err;
 			});
 	},
+
+	/**
+	 * What certbot is doing, from a line it printed
+	 *
+	 * @param   {String}  line
+	 * @returns {Object}  ie: {phase: 'propagation', seconds: 120}, or null when the line doesn't say
+	 */
+	getPhase: function (line) {
+		if (/Requesting a certificate for|Renewing an existing certificate for/.test(line)) {
+			return {phase: 'order'};
+		}
+
+		const waiting = line.match(/Waiting (\d+) seconds for DNS changes to propagate/);
+		if (waiting) {
+			return {phase: 'propagation', seconds: parseInt(waiting[1], 10)};
+		}
+
+		if (/Successfully received certificate|all renewals succeeded/.test(line)) {
+			return {phase: 'download'};
+		}
+
+		return null;
+	},
 };
 
 module.exports = certbot;
@@ -1,13 +1,48 @@
-const fs      = require('fs');
-const NodeRSA = require('node-rsa');
-const logger  = require('../logger').global;
+const _         = require('lodash');
+const fs        = require('fs');
+const crypto    = require('crypto');
+const NodeRSA   = require('node-rsa');
+const bootstrap = require('./bootstrap');
+const error     = require('./error');
+const logger    = require('../logger').global;
 
-const keysFile         = '/data/keys.json';
+const keysFile         = process.env.JWT_KEYS_FILE || '/data/keys.json';
+const settingsFile     = process.env.BACKEND_SETTINGS_FILE || '/data/backend.json';
 const mysqlEngine      = 'mysql2';
 const postgresEngine   = 'pg';
 const sqliteClientName = 'sqlite3';
+const logLevels        = ['info', 'timer', 'debug', 'warn', 'error'];
+const jwtAlgorithms    = ['RS256', 'ES256', 'EdDSA'];
+
+// The JWT algorithm for each type of key
+const keyAlgorithms = {
+	rsa:     'RS256',
+	ec:      'ES256',
+	ed25519: 'EdDSA'
+};
+
+// Where certificates, generated configs and the rest of what's kept are. Only read at startup, the includes of nginx are written from them.
+const paths = {
+	nginx:        (process.env.NGINX_CONFIG_DIR || '/data/nginx').replace(/\/+$/, ''),
+	custom_ssl:   (process.env.CUSTOM_SSL_DIR || '/data/custom_ssl').replace(/\/+$/, ''),
+	letsencrypt:  (process.env.LETSENCRYPT_DIR || '/etc/letsencrypt').replace(/\/+$/, ''),
+	internal_ca:  (process.env.INTERNAL_CA_DIR || '/data/internal_ca').replace(/\/+$/, ''),
+	upstream_ca:  (process.env.UPSTREAM_CA_DIR || '/data/upstream_ca').replace(/\/+$/, ''),
+	access:       (process.env.ACCESS_DIR || '/data/access').replace(/\/+$/, ''),
+	logs:         (process.env.LOGS_DIR || '/data/logs').replace(/\/+$/, ''),
+	analytics:    (process.env.ANALYTICS_DIR || '/data/analytics').replace(/\/+$/, ''),
+	fallback:     (process.env.FALLBACK_DIR || '/data/fallback').replace(/\/+$/, ''),
+	served_files: (process.env.SERVED_FILES_DIR || '/data/served-files').replace(/\/+$/, ''),
+	deploy_hooks: (process.env.DEPLOY_HOOKS_DIR || '/data/deploy-hooks').replace(/\/+$/, ''),
+	ssh:          (process.env.SSH_DIR || '/data/ssh').replace(/\/+$/, ''),
+	wireguard:    (process.env.WIREGUARD_DIR || '/data/wireguard').replace(/\/+$/, '')
+};
 
 let instance = null;
+let settings = null;
+
+// Why the JWT keys can't be used, null when they can
+let keysError = null;
 
 // 1. Load from config file first (not recommended anymore)
 // 2. Use config env variables next
@@ -29,6 +64,24 @@ const configure = () => {
 		}
 	}
 
+	const dsn = bootstrap.getDatabaseDsn();
+	if (dsn) {
+		let database;
+		try {
+			database = parseDsn(dsn);
+		} catch (err) {
+			logger.error('Could not use the database DSN: ' + err.message);
+			process.exit(1);
+		}
+
+		logger.info('Using database DSN, ' + (database.knex ? 'Sqlite' : database.engine + ' on ' + database.host));
+		instance = {
+			database: database,
+			keys:     getKeys(),
+		};
+		return;
+	}
+
 	const envMysqlHost = process.env.DB_MYSQL_HOST || null;
 	const envMysqlUser = process.env.DB_MYSQL_USER || null;
 	const envMysqlName = process.env.DB_MYSQL_NAME || null;
@@ -72,49 +125,368 @@ const configure = () => {
 	const envSqliteFile = process.env.DB_SQLITE_FILE || '/data/database.sqlite';
 	logger.info(`Using Sqlite: ${envSqliteFile}`);
 	instance = {
-		database: {
-			engine: 'knex-native',
-			knex:   {
-				client:     sqliteClientName,
-				connection: {
-					filename: envSqliteFile
-				},
-				useNullAsDefault: true
-			}
+		database: sqliteDatabase(envSqliteFile),
+		keys:     getKeys(),
+	};
+};
+
+/**
+ * @param   {String}  filename
+ * @returns {Object}
+ */
+const sqliteDatabase = (filename) => {
+	return {
+		engine: 'knex-native',
+		knex:   {
+			client:     sqliteClientName,
+			connection: {
+				filename: filename
+			},
+			useNullAsDefault: true
+		}
+	};
+};
+
+/**
+ * @param   {String}  dsn  ie: 'mysql://npm:secret@db:3306/npm', 'postgres://...' or 'sqlite:///data/database.sqlite'
+ * @returns {Object}
+ */
+const parseDsn = (dsn) => {
+	const url    = new URL(dsn);
+	const scheme = url.protocol.replace(/:$/, '');
+
+	if (scheme === 'sqlite') {
+		return sqliteDatabase(decodeURIComponent(url.pathname));
+	}
+
+	const engines = {
+		mysql:      {engine: mysqlEngine, port: 3306},
+		mariadb:    {engine: mysqlEngine, port: 3306},
+		postgres:   {engine: postgresEngine, port: 5432},
+		postgresql: {engine: postgresEngine, port: 5432}
+	};
+
+	if (!engines[scheme]) {
+		throw new Error('Unknown database type "' + scheme + '"');
+	}
+
+	return {
+		engine:   engines[scheme].engine,
+		host:     url.hostname,
+		port:     parseInt(url.port, 10) || engines[scheme].port,
+		user:     decodeURIComponent(url.username),
+		password: decodeURIComponent(url.password),
+		name:     decodeURIComponent(url.pathname.substring(1)),
+	};
+};
+
+/**
+ * @param   {String}  [value]  ie: 'x-api-key=secret,x-team=ops', as OTEL_EXPORTER_OTLP_HEADERS is written
+ * @returns {Object}
+ */
+const parseHeaders = (value) => {
+	let headers = {};
+	(value || '').split(',').forEach((pair) => {
+		const index = pair.indexOf('=');
+		if (index > 0) {
+			headers[decodeURIComponent(pair.substring(0, index).trim())] = decodeURIComponent(pair.substring(index + 1).trim());
+		}
+	});
+	return headers;
+};
+
+/**
+ * The settings that can be changed without a restart. The settings file wins over the environment,
+ * as that's the only one of the two that can change while running.
+ */
+const loadSettings = () => {
+	let fileData = {};
+	if (fs.existsSync(settingsFile)) {
+		try {
+			fileData = JSON.parse(fs.readFileSync(settingsFile, {encoding: 'utf8'}));
+		} catch (err) {
+			logger.error('Could not read backend settings file: ' + settingsFile + ': ' + err.message);
+		}
+	}
+
+	const intOrNull = (value) => {
+		const num = parseInt(value, 10);
+		return isNaN(num) ? null : num;
+	};
+
+	const pool               = fileData.database_pool || {};
+	const body_limits        = fileData.body_limits || {};
+	const timeouts           = fileData.request_timeouts || {};
+	const timeout_default    = intOrNull(timeouts.default !== undefined ? timeouts.default : process.env.REQUEST_TIMEOUT);
+	const timeout_long       = intOrNull(timeouts.long !== undefined ? timeouts.long : process.env.REQUEST_TIMEOUT_LONG);
+	const slow_query_ms      = intOrNull(fileData.slow_query_ms !== undefined ? fileData.slow_query_ms : process.env.DB_SLOW_QUERY_MS);
+	const read_cache         = intOrNull(fileData.read_cache !== undefined ? fileData.read_cache : process.env.READ_CACHE_TTL);
+	const config_concurrency = intOrNull(fileData.config_concurrency !== undefined ? fileData.config_concurrency : process.env.NGINX_CONFIG_CONCURRENCY);
+	const tracing            = fileData.tracing || {};
+	const sample_ratio       = parseFloat(tracing.sample_ratio !== undefined ? tracing.sample_ratio : process.env.OTEL_TRACES_SAMPLER_ARG);
+
+	settings = {
+		log_level:     fileData.log_level || process.env.LOG_LEVEL || 'info',
+		port:          intOrNull(fileData.port) || intOrNull(process.env.BACKEND_PORT) || 3000,
+		database_pool: {
+			min:          intOrNull(pool.min !== undefined ? pool.min : process.env.DB_POOL_MIN),
+			max:          intOrNull(pool.max !== undefined ? pool.max : process.env.DB_POOL_MAX),
+			// In seconds
+			idle_timeout: intOrNull(pool.idle_timeout !== undefined ? pool.idle_timeout : process.env.DB_POOL_IDLE_TIMEOUT),
+			lifetime:     intOrNull(pool.lifetime !== undefined ? pool.lifetime : process.env.DB_POOL_LIFETIME)
+		},
+		// Queries taking longer are logged, 0 to log none
+		slow_query_ms: slow_query_ms === null ? 1000 : slow_query_ms,
+		// In seconds, how long lists of hosts and their counts are kept in memory, 0 to always read them
+		read_cache: read_cache > 0 ? read_cache : 0,
+		// Host configs written at the same time when they're all regenerated
+		config_concurrency: config_concurrency > 0 ? config_concurrency : 10,
+		// In bytes, for each group of routes in lib/express/body-limits
+		body_limits: {
+			default: intOrNull(body_limits.default) || 100 * 1024,
+			uploads: intOrNull(body_limits.uploads) || 1024 * 1024
+		},
+		// In seconds, for each group of routes in lib/express/request-timeout, 0 for none
+		request_timeouts: {
+			default: timeout_default === null ? 60 : timeout_default,
+			long:    timeout_long === null ? 900 : timeout_long
 		},
-		keys: getKeys(),
+		// Where errors nothing was expecting are reported, none when null
+		sentry_dsn: (fileData.sentry_dsn !== undefined ? fileData.sentry_dsn : process.env.SENTRY_DSN) || null,
+		// The OTLP collector traces are sent to, none when the endpoint is null
+		tracing: {
+			endpoint:     (tracing.endpoint !== undefined ? tracing.endpoint : process.env.OTEL_EXPORTER_OTLP_ENDPOINT) || null,
+			headers:      tracing.headers || parseHeaders(process.env.OTEL_EXPORTER_OTLP_HEADERS),
+			sample_ratio: isNaN(sample_ratio) ? 1 : Math.min(Math.max(sample_ratio, 0), 1)
+		}
 	};
+
+	if (logLevels.indexOf(settings.log_level) === -1) {
+		logger.warn('Unknown log level "' + settings.log_level + '", using info');
+		settings.log_level = 'info';
+	}
+
+	if (settings.tracing.endpoint !== null && !/^https?:\/\//.test(settings.tracing.endpoint)) {
+		logger.warn('The tracing endpoint isn\'t an http or https url, traces won\'t be sent');
+		settings.tracing.endpoint = null;
+	}
+
+	// Required here, the reporter reads the settings itself
+	if (settings.sentry_dsn !== null && require('./error-report').parseDsn(settings.sentry_dsn) === null) {
+		logger.warn('The Sentry DSN isn\'t valid, errors won\'t be reported');
+		settings.sentry_dsn = null;
+	}
+};
+
+/**
+ * Keys files from before rotation was possible only have the key pair
+ *
+ * @param   {Object}  keys
+ * @returns {Object}
+ */
+const normaliseKeys = (keys) => {
+	return Object.assign({}, keys, {
+		alg:      keys.alg || 'RS256',
+		kid:      keys.kid || getKeyId(keys.pub),
+		previous: keys.previous || []
+	});
 };
 
-const getKeys = () => {
+const getKeyId = (pub) => {
+	return crypto.createHash('sha256').update(pub).digest('hex').substring(0, 16);
+};
+
+/**
+ * Signs with the private key and verifies with the public one, to find broken keys before a token needs them
+ *
+ * @param   {Object}  keys
+ * @returns {String|null}  what's wrong with them, or null when nothing is
+ */
+const checkKeys = (keys) => {
+	try {
+		const key = crypto.createPrivateKey(keys.key);
+		const pub = crypto.createPublicKey(keys.pub);
+
+		if (keyAlgorithms[key.asymmetricKeyType] !== keys.alg) {
+			return 'the private key is ' + key.asymmetricKeyType + ', which can\'t sign ' + keys.alg + ' tokens';
+		}
+
+		const data   = Buffer.from(keys.kid);
+		const digest = keys.alg === 'EdDSA' ? null : 'sha256';
+		if (!crypto.verify(digest, data, pub, crypto.sign(digest, data, key))) {
+			return 'the public key isn\'t the one of the private key';
+		}
+	} catch (err) {
+		return err.message;
+	}
+	return null;
+};
+
+/**
+ * Reads the keys and checks them. Broken keys are replaced when JWT_KEYS_REGENERATE is set, which ends every
+ * session, otherwise they're left for an administrator to fix and tokens can't be used until they are.
+ *
+ * @param   {Boolean}  [regenerated]  when the broken ones were just replaced
+ * @returns {Object|null}  null when they can't be used
+ */
+const getKeys = (regenerated) => {
 	// Get keys from file
 	if (!fs.existsSync(keysFile)) {
 		generateKeys();
 	} else if (process.env.DEBUG) {
 		logger.info('Keys file exists OK');
 	}
+
+	let keys    = null;
+	let problem = null;
 	try {
-		return require(keysFile);
+		keys    = normaliseKeys(require(keysFile));
+		problem = checkKeys(keys);
 	} catch (err) {
-		logger.error('Could not read JWT key pair from config file: ' + keysFile, err);
-		process.exit(1);
+		problem = err.message;
 	}
+
+	if (problem !== null && !regenerated && ['1', 'true', 'yes'].indexOf((process.env.JWT_KEYS_REGENERATE || '').toLowerCase()) !== -1) {
+		logger.warn('The JWT keys in ' + keysFile + ' can\'t be used: ' + problem);
+		replaceKeys();
+		return getKeys(true);
+	}
+
+	if (problem !== null) {
+		keysError = 'The JWT keys in ' + keysFile + ' can\'t be used: ' + problem;
+		logger.error(keysError + '. Sign in is unavailable until they\'re fixed, or replaced with JWT_KEYS_REGENERATE=true');
+		return null;
+	}
+
+	keysError = null;
+	return keys;
 };
 
-const generateKeys = () => {
-	logger.info('Creating a new JWT key pair...');
-	// Now create the keys and save them in the config.
-	const key = new NodeRSA({ b: 2048 });
-	key.generateKeyPair();
-
-	const keys = {
-		key: key.exportKey('private').toString(),
-		pub: key.exportKey('public').toString(),
+/**
+ * Generates new keys in place of broken ones, keeping the old file beside them
+ */
+const replaceKeys = () => {
+	const broken = keysFile + '.broken-' + Date.now();
+	logger.warn('Replacing the JWT keys, the old file is kept as ' + broken);
+	fs.renameSync(keysFile, broken);
+	generateKeys();
+};
+
+/**
+ * @throws {KeysUnavailableError}  when the keys couldn't be read
+ */
+const assertKeys = () => {
+	instance === null && configure();
+	if (instance.keys === null) {
+		throw new error.KeysUnavailableError(keysError);
+	}
+};
+
+/**
+ * @param   {String}  [algorithm]  RS256, ES256 or EdDSA
+ * @returns {Object}
+ */
+const createKeyPair = (algorithm) => {
+	let keys = {alg: algorithm || 'RS256'};
+
+	if (jwtAlgorithms.indexOf(keys.alg) === -1) {
+		logger.warn('Unknown JWT algorithm "' + keys.alg + '", using RS256');
+		keys.alg = 'RS256';
+	}
+
+	if (keys.alg === 'EdDSA' || keys.alg === 'ES256') {
+		const encoding = {
+			publicKeyEncoding:  {type: 'spki', format: 'pem'},
+			privateKeyEncoding: {type: 'pkcs8', format: 'pem'}
+		};
+
+		const pair = keys.alg === 'EdDSA'
+			? crypto.generateKeyPairSync('ed25519', encoding)
+			: crypto.generateKeyPairSync('ec', Object.assign({namedCurve: 'P-256'}, encoding));
+
+		keys.key = pair.privateKey;
+		keys.pub = pair.publicKey;
+	} else {
+		const key = new NodeRSA({ b: 2048 });
+		key.generateKeyPair();
+
+		keys.key = key.exportKey('private').toString();
+		keys.pub = key.exportKey('public').toString();
+	}
+
+	keys.kid = getKeyId(keys.pub);
+	return keys;
+};
+
+/**
+ * Written to a temporary file that replaces the old one once it's on disk, so a crash can't leave half a file
+ *
+ * @param {Object} keys
+ */
+const writeKeys = (keys) => {
+	const temp = keysFile + '.tmp';
+	const fd   = fs.openSync(temp, 'w', 0o600);
+	try {
+		fs.writeSync(fd, JSON.stringify(keys, null, 2));
+		fs.fsyncSync(fd);
+	} finally {
+		fs.closeSync(fd);
+	}
+	fs.renameSync(temp, keysFile);
+
+	if (require.cache[require.resolve(keysFile)]) {
+		delete require.cache[require.resolve(keysFile)];
+	}
+};
+
+/**
+ * A key pair from the private key given to bootstrap with
+ *
+ * @param   {String}  pem
+ * @returns {Object}
+ */
+const importKeyPair = (pem) => {
+	const key = crypto.createPrivateKey(pem);
+	const alg = keyAlgorithms[key.asymmetricKeyType];
+
+	if (!alg || (alg === 'ES256' && key.asymmetricKeyDetails.namedCurve !== 'prime256v1')) {
+		throw new Error('Only RSA, P-256 and Ed25519 keys can sign tokens');
+	}
+
+	let keys = {
+		alg: alg,
+		key: key.export({type: alg === 'RS256' ? 'pkcs1' : 'pkcs8', format: 'pem'}).toString(),
+		pub: crypto.createPublicKey(key).export({type: 'spki', format: 'pem'}).toString()
 	};
 
+	keys.kid = getKeyId(keys.pub);
+	return keys;
+};
+
+const generateKeys = () => {
+	let keys;
+	let pem = null;
+
+	try {
+		pem = bootstrap.getPrivateKey();
+		if (pem) {
+			logger.info('Using the JWT private key from bootstrap...');
+			keys = importKeyPair(pem);
+		}
+	} catch (err) {
+		logger.error('Could not use the JWT private key from bootstrap: ' + err.message);
+		process.exit(1);
+	}
+
+	if (!pem) {
+		logger.info('Creating a new JWT key pair...');
+		// Now create the keys and save them in the config.
+		keys = createKeyPair(process.env.JWT_ALGORITHM);
+	}
+
 	// Write keys config
 	try {
-		fs.writeFileSync(keysFile, JSON.stringify(keys, null, 2));
+		writeKeys(keys);
 	} catch (err) {
 		logger.error('Could not write JWT key pair to config file: ' + keysFile + ': ' + err.message);
 		process.exit(1);
@@ -204,7 +576,7 @@ module.exports = {
 	 * @returns {string}
 	 */
 	getPublicKey: function () {
-		instance === null && configure();
+		assertKeys();
 		return instance.keys.pub;
 	},
 
@@ -214,10 +586,203 @@ module.exports = {
 	 * @returns {string}
 	 */
 	getPrivateKey: function () {
-		instance === null && configure();
+		assertKeys();
 		return instance.keys.key;
 	},
 
+	/**
+	 * The algorithm and id of the key that tokens are signed with
+	 *
+	 * @returns {Object}
+	 */
+	getSigningKey: function () {
+		assertKeys();
+		return {
+			alg: instance.keys.alg,
+			kid: instance.keys.kid,
+			key: instance.keys.key
+		};
+	},
+
+	/**
+	 * The current public key, and the previous ones still inside their grace period
+	 *
+	 * @returns {Array}
+	 */
+	getVerificationKeys: function () {
+		assertKeys();
+		const now = new Date().toISOString();
+
+		return [_.pick(instance.keys, ['alg', 'kid', 'pub'])].concat(instance.keys.previous.filter((item) => {
+			return item.expires_on > now;
+		}));
+	},
+
+	/**
+	 * Replaces the JWT key pair. The old public key keeps verifying tokens until the grace period is over.
+	 *
+	 * @param   {String}  algorithm     RS256, ES256 or EdDSA
+	 * @param   {Number}  grace_period  in seconds
+	 * @returns {Object}  the new key, without the private half
+	 */
+	rotateKeys: function (algorithm, grace_period) {
+		assertKeys();
+		const now  = new Date();
+		let keys   = createKeyPair(algorithm);
+
+		keys.previous = instance.keys.previous.filter((item) => {
+			return item.expires_on > now.toISOString();
+		});
+
+		if (grace_period > 0) {
+			keys.previous.unshift({
+				alg:        instance.keys.alg,
+				kid:        instance.keys.kid,
+				pub:        instance.keys.pub,
+				expires_on: new Date(now.getTime() + grace_period * 1000).toISOString()
+			});
+		}
+
+		writeKeys(keys);
+		instance.keys = keys;
+		logger.info('Rotated the JWT key pair, new key ' + keys.kid);
+
+		return _.pick(keys, ['alg', 'kid', 'pub', 'previous']);
+	},
+
+	/**
+	 * Replaces the JWT keys when they can't be used, as JWT_KEYS_REGENERATE does at startup. Ends every session.
+	 *
+	 * @returns {Boolean}  whether they were replaced
+	 */
+	regenerateKeys: function () {
+		instance === null && configure();
+		if (keysError === null) {
+			return false;
+		}
+
+		replaceKeys();
+		instance.keys = getKeys(true);
+		return true;
+	},
+
+	/**
+	 * @returns {String|null}  why the JWT keys can't be used, or null when they can
+	 */
+	getKeysError: function () {
+		instance === null && configure();
+		return keysError;
+	},
+
+	/**
+	 * Gets one of the settings that can be changed without a restart
+	 *
+	 * @param   {string}  key  ie: 'log_level', 'port', 'database_pool', 'slow_query_ms', 'read_cache', 'config_concurrency', 'body_limits', 'request_timeouts', 'sentry_dsn' or 'tracing'
+	 * @returns {*}
+	 */
+	getSetting: function (key) {
+		settings === null && loadSettings();
+		return settings[key];
+	},
+
+	/**
+	 * Reads the settings file and keys again
+	 *
+	 * @returns {Array}  the settings that changed, with 'keys' when the JWT keys did
+	 */
+	reload: function () {
+		instance === null && configure();
+		settings === null && loadSettings();
+
+		const before    = JSON.parse(JSON.stringify(settings));
+		const keys_pub  = instance.keys ? instance.keys.pub : null;
+		let changed     = [];
+
+		loadSettings();
+		Object.keys(settings).forEach((key) => {
+			if (JSON.stringify(before[key]) !== JSON.stringify(settings[key])) {
+				changed.push(key);
+			}
+		});
+
+		// Required files are cached, so read it again
+		delete require.cache[require.resolve(keysFile)];
+		instance.keys = getKeys();
+		if ((instance.keys ? instance.keys.pub : null) !== keys_pub) {
+			changed.push('keys');
+		}
+
+		return changed;
+	},
+
+	/**
+	 * Whether the instance can't, or mustn't, reach the internet for anything that's optional, ie: the upgrade check
+	 *
+	 * @returns {boolean}
+	 */
+	isOffline: function () {
+		return ['1', 'true', 'yes'].indexOf((process.env.OFFLINE || '').toLowerCase()) !== -1;
+	},
+
+	/**
+	 * Whether the telemetry setting is ignored, and can't be turned on, whatever an administrator does
+	 *
+	 * @returns {boolean}
+	 */
+	isTelemetryDisabled: function () {
+		return module.exports.isOffline() || ['1', 'true', 'yes'].indexOf((process.env.DISABLE_TELEMETRY || '').toLowerCase()) !== -1;
+	},
+
+	/**
+	 * @param   {string}  name  one of the keys of paths, ie: nginx, letsencrypt or logs
+	 * @returns {string}  ie: '/data/nginx', without a trailing slash
+	 */
+	getPath: function (name) {
+		return paths[name];
+	},
+
+	/**
+	 * @returns {Object}  every path, for the templates
+	 */
+	getPaths: function () {
+		return _.clone(paths);
+	},
+
+	/**
+	 * Whether replicas sharing the database elect one of them to run the background work
+	 *
+	 * @returns {boolean}
+	 */
+	useLeaderElection: function () {
+		return process.env.LEADER_ELECTION === 'true';
+	},
+
+	/**
+	 * The S3 compatible bucket certificates are kept in as well, so nodes sharing it all have them
+	 *
+	 * @returns {Object|null}  null when they're only kept on disk
+	 */
+	getCertStorage: function () {
+		if (!process.env.CERT_STORAGE_S3_BUCKET) {
+			return null;
+		}
+
+		const interval = parseInt(process.env.CERT_STORAGE_SYNC_INTERVAL, 10);
+
+		return {
+			endpoint:      (process.env.CERT_STORAGE_S3_ENDPOINT || 'https://s3.amazonaws.com').replace(/\/+$/, ''),
+			region:        process.env.CERT_STORAGE_S3_REGION || 'us-east-1',
+			bucket:        process.env.CERT_STORAGE_S3_BUCKET,
+			prefix:        (process.env.CERT_STORAGE_S3_PREFIX || 'npm').replace(/^\/+|\/+$/g, ''),
+			access_key:    process.env.CERT_STORAGE_S3_ACCESS_KEY || '',
+			secret_key:    process.env.CERT_STORAGE_S3_SECRET_KEY || '',
+			// In seconds, how often certificates another node changed are fetched. 0 to only fetch them at startup
+			sync_interval: isNaN(interval) ? 300 : Math.max(interval, 0),
+			// Whether this node renews certificates, the others fetch them once it has
+			renew:         process.env.CERT_STORAGE_RENEW !== 'false'
+		};
+	},
+
 	/**
 	 * @returns {boolean}
 	 */
@@ -225,6 +790,29 @@ module.exports = {
 		return !!process.env.LE_STAGING;
 	},
 
+	/**
+	 * Test mode, for integration tests of issuing, renewing and deploying certificates. They're requested from
+	 * a Pebble ACME server, and the mock DNS provider keeps the challenge records in memory and answers them.
+	 *
+	 * @returns {Object|null}  null when it's off
+	 */
+	getTestMode: function () {
+		if (process.env.TEST_MODE !== 'true') {
+			return null;
+		}
+
+		const dns_port     = parseInt(process.env.TEST_MODE_DNS_PORT, 10);
+		const control_port = parseInt(process.env.TEST_MODE_CONTROL_PORT, 10);
+
+		return {
+			acme_server:  process.env.TEST_MODE_ACME_SERVER || 'https://pebble:14000/dir',
+			// Where Pebble is told to look up the records with its -dnsserver option
+			dns_port:     isNaN(dns_port) ? 8053 : dns_port,
+			// Only on localhost, where lego's httpreq provider adds and removes the records
+			control_port: isNaN(control_port) ? 8054 : control_port
+		};
+	},
+
 	/**
 	 * @returns {string|null}
 	 */
@@ -232,6 +820,9 @@ module.exports = {
 		if (process.env.LE_SERVER) {
 			return process.env.LE_SERVER;
 		}
+		if (module.exports.getTestMode()) {
+			return module.exports.getTestMode().acme_server;
+		}
 		return null;
 	}
 };
@@ -0,0 +1,64 @@
+// Kinds of denial kept, past this many the one seen least recently is dropped
+const MAX_ENTRIES = 1000;
+
+// By user, route and permission, in the order they were last seen
+let entries = new Map();
+
+// By permission, since the start
+let totals = {};
+
+module.exports = {
+
+	/**
+	 * Counts a request that was denied a permission
+	 *
+	 * @param {Object}  denial
+	 * @param {Number}  denial.user_id     0 when there was no user
+	 * @param {String}  denial.route       ie: 'PUT /nginx/proxy-hosts/:id'
+	 * @param {String}  denial.permission  ie: 'proxy_hosts:update'
+	 * @param {Array}   denial.missing     what the user would need for it, ie: ['proxy_hosts:manage']
+	 */
+	record: (denial) => {
+		const key = [denial.user_id, denial.route, denial.permission].join(' ');
+		const now = new Date().toISOString();
+
+		let entry = entries.get(key);
+		if (typeof entry === 'undefined') {
+			entry = {
+				user_id:    denial.user_id,
+				route:      denial.route,
+				permission: denial.permission,
+				count:      0,
+				first_seen: now
+			};
+		}
+
+		entry.count++;
+		entry.missing   = denial.missing;
+		entry.last_seen = now;
+
+		entries.delete(key);
+		entries.set(key, entry);
+		if (entries.size > MAX_ENTRIES) {
+			entries.delete(entries.keys().next().value);
+		}
+
+		totals[denial.permission] = (totals[denial.permission] || 0) + 1;
+	},
+
+	/**
+	 * @returns {Array}  the denials by user, route and permission, the most frequent first
+	 */
+	get: () => {
+		return Array.from(entries.values())
+			.map((entry) => Object.assign({}, entry))
+			.sort((a, b) => b.count - a.count || (a.last_seen < b.last_seen ? 1 : -1));
+	},
+
+	/**
+	 * @returns {Object}  the count of denials of each permission
+	 */
+	getTotals: () => {
+		return Object.assign({}, totals);
+	}
+};
@@ -0,0 +1,359 @@
+/**
+ * Just enough of the DNS wire format (RFC 1035) to answer simple queries for a zone we're authoritative for,
+ * and to send TSIG signed updates (RFC 2136, RFC 8945) to one that isn't
+ */
+
+const crypto = require('crypto');
+
+const TYPES = {
+	A:    1,
+	NS:   2,
+	SOA:  6,
+	TXT:  16,
+	AAAA: 28,
+	TLSA: 52,
+	TSIG: 250,
+	ANY:  255
+};
+
+const CLASSES = {
+	IN:   1,
+	NONE: 254,
+	ANY:  255
+};
+
+const OPCODE_UPDATE = 5;
+
+// The names TSIG gives the HMACs, by the name crypto knows them by
+const TSIG_ALGORITHMS = {
+	'hmac-md5':    'hmac-md5.sig-alg.reg.int',
+	'hmac-sha1':   'hmac-sha1',
+	'hmac-sha224': 'hmac-sha224',
+	'hmac-sha256': 'hmac-sha256',
+	'hmac-sha384': 'hmac-sha384',
+	'hmac-sha512': 'hmac-sha512'
+};
+
+// Seconds the clocks of the server and ours may be apart
+const TSIG_FUDGE = 300;
+
+const RCODES = {
+	NOERROR:  0,
+	FORMERR:  1,
+	SERVFAIL: 2,
+	NXDOMAIN: 3,
+	NOTIMP:   4,
+	REFUSED:  5,
+	YXDOMAIN: 6,
+	YXRRSET:  7,
+	NXRRSET:  8,
+	NOTAUTH:  9,
+	NOTZONE:  10
+};
+
+/**
+ * @param   {Buffer}  buffer
+ * @param   {Number}  offset
+ * @returns {Object}  {name, offset} with the offset after the name
+ */
+const readName = (buffer, offset) => {
+	let labels = [];
+	let end    = null;
+	let jumps  = 0;
+
+	for (;;) {
+		if (offset >= buffer.length) {
+			throw new Error('Name runs past the end of the packet');
+		}
+
+		const length = buffer[offset];
+
+		if (length === 0) {
+			offset++;
+			break;
+		}
+
+		// A pointer to a name earlier in the packet
+		if ((length & 0xc0) === 0xc0) {
+			if (++jumps > 10) {
+				throw new Error('Too many name pointers');
+			}
+			if (end === null) {
+				end = offset + 2;
+			}
+			offset = buffer.readUInt16BE(offset) & 0x3fff;
+			continue;
+		}
+
+		labels.push(buffer.toString('ascii', offset + 1, offset + 1 + length));
+		offset += length + 1;
+	}
+
+	return {
+		name:   labels.join('.'),
+		offset: end === null ? offset : end
+	};
+};
+
+/**
+ * @param   {String}  name
+ * @returns {Buffer}
+ */
+const writeName = (name) => {
+	const labels = name.replace(/\.$/, '').split('.').filter((label) => label.length);
+	let parts    = [];
+
+	labels.forEach((label) => {
+		const data = Buffer.from(label, 'ascii');
+		parts.push(Buffer.from([data.length]), data);
+	});
+
+	parts.push(Buffer.from([0]));
+	return Buffer.concat(parts);
+};
+
+/**
+ * @param   {Object}  record  {type, data}
+ * @returns {Buffer}
+ */
+const writeData = (record) => {
+	switch (record.type) {
+	case 'A':
+		return Buffer.from(record.data.split('.').map((part) => parseInt(part, 10)));
+
+	case 'AAAA': {
+		// Expand "::" so there are 8 groups
+		let [head, tail] = record.data.split('::');
+		head             = head ? head.split(':') : [];
+		tail             = typeof tail === 'undefined' ? [] : (tail ? tail.split(':') : []);
+		const groups     = head.concat(new Array(8 - head.length - tail.length).fill('0'), tail);
+		const data       = Buffer.alloc(16);
+		groups.forEach((group, index) => {
+			data.writeUInt16BE(parseInt(group, 16), index * 2);
+		});
+		return data;
+	}
+
+	case 'NS':
+		return writeName(record.data);
+
+	case 'SOA': {
+		const numbers = Buffer.alloc(20);
+		numbers.writeUInt32BE(record.data.serial, 0);
+		numbers.writeUInt32BE(record.data.refresh, 4);
+		numbers.writeUInt32BE(record.data.retry, 8);
+		numbers.writeUInt32BE(record.data.expire, 12);
+		numbers.writeUInt32BE(record.data.minimum, 16);
+		return Buffer.concat([writeName(record.data.mname), writeName(record.data.rname), numbers]);
+	}
+
+	case 'TXT': {
+		// Split into strings of up to 255 bytes
+		const data = Buffer.from(record.data, 'utf8');
+		let parts  = [];
+		for (let i = 0; i < data.length || i === 0; i += 255) {
+			const chunk = data.subarray(i, i + 255);
+			parts.push(Buffer.from([chunk.length]), chunk);
+		}
+		return Buffer.concat(parts);
+	}
+
+	case 'TLSA': {
+		const fields = Buffer.from([record.data.usage, record.data.selector, record.data.matching_type]);
+		return Buffer.concat([fields, Buffer.from(record.data.data, 'hex')]);
+	}
+
+	default:
+		throw new Error('Can\'t write ' + record.type + ' records');
+	}
+};
+
+/**
+ * @param   {Number}  seconds
+ * @returns {Buffer}  the 48 bit time TSIG signs with
+ */
+const writeTime = (seconds) => {
+	const data = Buffer.alloc(6);
+	data.writeUInt16BE(Math.floor(seconds / 0x100000000), 0);
+	data.writeUInt32BE(seconds % 0x100000000, 2);
+	return data;
+};
+
+/**
+ * Signs a message, and gives the TSIG record to add to the end of it
+ *
+ * @param   {Buffer}  message  without the TSIG record
+ * @param   {Object}  key      {name, algorithm, secret}, the secret in base64
+ * @returns {Buffer}
+ */
+const writeTsig = (message, key) => {
+	// ie: HMAC-SHA512, as certbot's rfc2136 plugin has it
+	const hmac      = key.algorithm.toLowerCase().replace(/\.$/, '').replace(/\.sig-alg\.reg\.int$/, '');
+	const algorithm = TSIG_ALGORITHMS[hmac];
+	if (!algorithm) {
+		throw new Error('Can\'t sign with ' + key.algorithm);
+	}
+
+	const time     = writeTime(Math.floor(Date.now() / 1000));
+	const timers   = Buffer.concat([time, Buffer.from([TSIG_FUDGE >> 8, TSIG_FUDGE & 0xff])]);
+	const name     = writeName(key.name.toLowerCase());
+	const envelope = Buffer.alloc(6);
+	envelope.writeUInt16BE(CLASSES.ANY, 0);
+
+	// What's signed is the message, followed by the TSIG record without its MAC, with no error and no other data
+	const mac = crypto.createHmac(hmac.substring(5), Buffer.from(key.secret, 'base64'))
+		.update(Buffer.concat([message, name, envelope, writeName(algorithm), timers, Buffer.alloc(4)]))
+		.digest();
+
+	const size = Buffer.alloc(2);
+	size.writeUInt16BE(mac.length, 0);
+	const trailer = Buffer.alloc(6);
+	trailer.writeUInt16BE(message.readUInt16BE(0), 0);
+
+	const data   = Buffer.concat([writeName(algorithm), timers, size, mac, trailer]);
+	const header = Buffer.alloc(10);
+	header.writeUInt16BE(TYPES.TSIG, 0);
+	header.writeUInt16BE(CLASSES.ANY, 2);
+	header.writeUInt16BE(data.length, 8);
+	return Buffer.concat([name, header, data]);
+};
+
+/**
+ * @param   {Object}  record  {name, type, ttl, data, [class]}, without data for the updates deleting a set of records
+ * @returns {Buffer}
+ */
+const writeRecord = (record) => {
+	const data   = typeof record.data === 'undefined' ? Buffer.alloc(0) : writeData(record);
+	const header = Buffer.alloc(10);
+	header.writeUInt16BE(TYPES[record.type], 0);
+	header.writeUInt16BE(CLASSES[record.class || 'IN'], 2);
+	header.writeUInt32BE(record.ttl, 4);
+	header.writeUInt16BE(data.length, 8);
+	return Buffer.concat([writeName(record.name), header, data]);
+};
+
+module.exports = {
+
+	types:  TYPES,
+	rcodes: RCODES,
+
+	/**
+	 * @param   {Buffer}  buffer  a response
+	 * @returns {String}  ie: 'NOERROR', or the number when it's one we don't know
+	 */
+	getRcode: (buffer) => {
+		if (buffer.length < 12) {
+			throw new Error('Packet is shorter than a header');
+		}
+
+		const rcode = buffer.readUInt16BE(2) & 0x0f;
+		return Object.keys(RCODES).find((key) => RCODES[key] === rcode) || String(rcode);
+	},
+
+	/**
+	 * An update of a zone, signed with a TSIG key when there is one
+	 *
+	 * @param   {Object}  update
+	 * @param   {Number}  update.id
+	 * @param   {String}  update.zone     ie: example.com
+	 * @param   {Array}   update.records  [{name, type, ttl, data, [class]}], class ANY without data deletes the set of records
+	 * @param   {Object}  [update.key]    {name, algorithm, secret}
+	 * @returns {Buffer}
+	 */
+	encodeUpdate: (update) => {
+		const header = Buffer.alloc(12);
+		header.writeUInt16BE(update.id, 0);
+		header.writeUInt16BE(OPCODE_UPDATE << 11, 2);
+		header.writeUInt16BE(1, 4);
+		header.writeUInt16BE(update.records.length, 8);
+
+		const zone = Buffer.alloc(4);
+		zone.writeUInt16BE(TYPES.SOA, 0);
+		zone.writeUInt16BE(CLASSES.IN, 2);
+
+		const message = Buffer.concat([header, writeName(update.zone), zone].concat(update.records.map(writeRecord)));
+		if (!update.key) {
+			return message;
+		}
+
+		const signed = Buffer.concat([message, writeTsig(message, update.key)]);
+		signed.writeUInt16BE(1, 10);
+		return signed;
+	},
+
+	/**
+	 * @param   {Buffer}  buffer
+	 * @returns {Object}  {id, flags, questions: [{name, type, class}]}, type is the name when it's one we know
+	 */
+	parse: (buffer) => {
+		if (buffer.length < 12) {
+			throw new Error('Packet is shorter than a header');
+		}
+
+		const count   = buffer.readUInt16BE(4);
+		let questions = [];
+		let offset    = 12;
+
+		for (let i = 0; i < count; i++) {
+			const result = readName(buffer, offset);
+			offset       = result.offset;
+
+			if (offset + 4 > buffer.length) {
+				throw new Error('Question runs past the end of the packet');
+			}
+
+			const type = buffer.readUInt16BE(offset);
+			questions.push({
+				name:  result.name.toLowerCase(),
+				type:  Object.keys(TYPES).find((key) => TYPES[key] === type) || type,
+				class: buffer.readUInt16BE(offset + 2)
+			});
+			offset += 4;
+		}
+
+		return {
+			id:        buffer.readUInt16BE(0),
+			flags:     buffer.readUInt16BE(2),
+			questions: questions
+		};
+	},
+
+	/**
+	 * @param   {Object}   query                   as parsed
+	 * @param   {Object}   response
+	 * @param   {String}   [response.rcode]        ie: 'NXDOMAIN'
+	 * @param   {Boolean}  [response.authoritative]
+	 * @param   {Array}    [response.answers]      [{name, type, ttl, data}]
+	 * @param   {Array}    [response.authorities]
+	 * @param   {Array}    [response.additionals]
+	 * @returns {Buffer}
+	 */
+	encode: (query, response) => {
+		const answers     = response.answers || [];
+		const authorities = response.authorities || [];
+		const additionals = response.additionals || [];
+
+		// QR, the opcode and RD of the query, AA
+		let flags = 0x8000 | (query.flags & 0x7900) | (RCODES[response.rcode || 'NOERROR'] & 0x0f);
+		if (response.authoritative) {
+			flags |= 0x0400;
+		}
+
+		const header = Buffer.alloc(12);
+		header.writeUInt16BE(query.id, 0);
+		header.writeUInt16BE(flags, 2);
+		header.writeUInt16BE(query.questions.length, 4);
+		header.writeUInt16BE(answers.length, 6);
+		header.writeUInt16BE(authorities.length, 8);
+		header.writeUInt16BE(additionals.length, 10);
+
+		const questions = query.questions.map((question) => {
+			const fields = Buffer.alloc(4);
+			fields.writeUInt16BE(typeof question.type === 'number' ? question.type : TYPES[question.type], 0);
+			fields.writeUInt16BE(question.class, 2);
+			return Buffer.concat([writeName(question.name), fields]);
+		});
+
+		return Buffer.concat([header].concat(questions, answers.concat(authorities, additionals).map(writeRecord)));
+	}
+};
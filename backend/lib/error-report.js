@@ -0,0 +1,175 @@
+const http   = require('http');
+const https  = require('https');
+const crypto = require('crypto');
+const os     = require('os');
+const config = require('./config');
+const logger = require('../logger').express;
+
+const HTTP_TIMEOUT = 10000;
+
+let unhandled = 0;
+
+/**
+ * @param   {String}  dsn  ie: 'https://public_key@sentry.example.com/42'
+ * @returns {Object|null}  the store url and key, or null when the dsn isn't one
+ */
+const parseDsn = (dsn) => {
+	let url;
+	try {
+		url = new URL(dsn);
+	} catch (err) {
+		return null;
+	}
+
+	const project = url.pathname.replace(/\/+$/, '').split('/').pop();
+	if (['http:', 'https:'].indexOf(url.protocol) === -1 || !url.username || !/^[0-9]+$/.test(project)) {
+		return null;
+	}
+
+	const prefix = url.pathname.replace(/\/+$/, '').replace(/\/[0-9]+$/, '');
+	return {
+		url: url.protocol + '//' + url.host + prefix + '/api/' + project + '/store/',
+		key: decodeURIComponent(url.username)
+	};
+};
+
+/**
+ * The frames of a V8 stack, oldest first as Sentry wants them
+ *
+ * @param   {String}  stack
+ * @returns {Array}
+ */
+const getFrames = (stack) => {
+	return (stack || '').split('\n')
+		.map((line) => {
+			const matches = line.match(/^\s+at (?:(.+?) \()?(.+?):([0-9]+):([0-9]+)\)?$/);
+			if (matches === null) {
+				return null;
+			}
+			return {
+				function: matches[1] || '?',
+				filename: matches[2],
+				lineno:   parseInt(matches[3], 10),
+				colno:    parseInt(matches[4], 10),
+				in_app:   matches[2].indexOf('node_modules') === -1 && matches[2].indexOf('node:') !== 0
+			};
+		})
+		.filter((frame) => frame !== null)
+		.reverse();
+};
+
+/**
+ * @param   {Error}   err
+ * @param   {Object}  [context]
+ * @param   {String}  [context.request_id]
+ * @param   {Object}  [context.req]
+ * @returns {Object}
+ */
+const getEvent = (err, context) => {
+	let event = {
+		event_id:    crypto.randomBytes(16).toString('hex'),
+		timestamp:   new Date().toISOString(),
+		platform:    'node',
+		level:       'error',
+		logger:      'backend',
+		server_name: os.hostname(),
+		exception:   {
+			values: [{
+				type:       err.name || 'Error',
+				value:      err.message,
+				stacktrace: {
+					frames: getFrames(err.stack)
+				}
+			}]
+		},
+		tags: {}
+	};
+
+	if (context.request_id) {
+		event.tags.request_id = context.request_id;
+	}
+
+	// Only the method and path, the query and headers can have tokens in them
+	if (context.req) {
+		event.request = {
+			method: context.req.method,
+			url:    context.req.originalUrl.replace(/\?.*$/, '')
+		};
+	}
+
+	return event;
+};
+
+module.exports = {
+
+	/**
+	 * Counts an error nothing was expecting, logs its stack and sends it to Sentry when a DSN is set
+	 *
+	 * @param {Error}   err
+	 * @param {Object}  [context]
+	 * @param {String}  [context.request_id]
+	 * @param {Object}  [context.req]         the request it happened in
+	 */
+	unhandled: (err, context) => {
+		context = context || {};
+		unhandled++;
+
+		logger.error((context.request_id ? '[' + context.request_id + '] ' : '') + (err.stack || err.message || err));
+
+		const dsn = config.getSetting('sentry_dsn');
+		if (dsn) {
+			module.exports.send(dsn, getEvent(err, context))
+				.catch((send_err) => {
+					logger.warn('Could not report the error to Sentry: ' + send_err.message);
+				});
+		}
+	},
+
+	/**
+	 * @param   {String}  dsn
+	 * @param   {Object}  event
+	 * @returns {Promise}
+	 */
+	send: (dsn, event) => {
+		const target = parseDsn(dsn);
+		if (target === null) {
+			return Promise.reject(new Error('The Sentry DSN isn\'t valid'));
+		}
+
+		return new Promise((resolve, reject) => {
+			const body = JSON.stringify(event);
+			const req  = (target.url.indexOf('https:') === 0 ? https : http).request(target.url, {
+				method:  'POST',
+				timeout: HTTP_TIMEOUT,
+				headers: {
+					'Content-Type':   'application/json',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager',
+					'X-Sentry-Auth':  'Sentry sentry_version=7, sentry_client=nginx-proxy-manager/1.0, sentry_key=' + target.key
+				}
+			}, (res) => {
+				res.resume();
+				if (res.statusCode < 200 || res.statusCode >= 300) {
+					reject(new Error('Sentry answered ' + res.statusCode));
+					return;
+				}
+				resolve();
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('There was no answer within ' + (HTTP_TIMEOUT / 1000) + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	},
+
+	parseDsn: parseDsn,
+
+	/**
+	 * @returns {Number}  the errors nothing was expecting since the start
+	 */
+	getCount: () => {
+		return unhandled;
+	}
+};
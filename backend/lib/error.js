@@ -8,6 +8,7 @@ module.exports = {
 		this.name     = this.constructor.name;
 		this.previous = previous;
 		this.message  = 'Permission Denied';
+		this.reason   = 'permission_denied';
 		this.public   = true;
 		this.status   = 403;
 	},
@@ -66,6 +67,16 @@ module.exports = {
 		this.public   = false;
 	},
 
+	ReadOnlyError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.reason   = 'read_only';
+		this.public   = true;
+		this.status   = 403;
+	},
+
 	ValidationError: function (message, previous) {
 		Error.captureStackTrace(this, this.constructor);
 		this.name     = this.constructor.name;
@@ -75,6 +86,27 @@ module.exports = {
 		this.status   = 400;
 	},
 
+	DomainConflictError: function (message, conflict, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.conflict = conflict;
+		this.reason   = 'domain_conflict';
+		this.public   = true;
+		this.status   = 400;
+	},
+
+	SchemaValidationError: function (message, fields, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.fields   = fields;
+		this.public   = true;
+		this.status   = 422;
+	},
+
 	AssertionFailedError: function (message, previous) {
 		Error.captureStackTrace(this, this.constructor);
 		this.name     = this.constructor.name;
@@ -84,6 +116,83 @@ module.exports = {
 		this.status   = 400;
 	},
 
+	LengthRequiredError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.public   = true;
+		this.status   = 411;
+	},
+
+	PayloadTooLargeError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.public   = true;
+		this.status   = 413;
+	},
+
+	UnsupportedMediaTypeError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.public   = true;
+		this.status   = 415;
+	},
+
+	TooManyAttemptsError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.reason   = 'too_many_attempts';
+		this.public   = true;
+		this.status   = 429;
+	},
+
+	RequestTimeoutError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.reason   = 'timeout';
+		this.public   = true;
+		this.status   = 503;
+	},
+
+	CancelledError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = typeof message === 'string' ? message : 'The request was cancelled';
+		this.public   = true;
+		this.status   = 503;
+	},
+
+	KeysUnavailableError: function (message, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.reason   = 'keys_unavailable';
+		this.public   = true;
+		this.status   = 503;
+	},
+
+	AcmeError: function (message, problem, previous) {
+		Error.captureStackTrace(this, this.constructor);
+		this.name     = this.constructor.name;
+		this.previous = previous;
+		this.message  = message;
+		this.problem  = problem;
+		this.reason   = 'acme_error';
+		this.public   = true;
+		this.status   = 400;
+	},
+
 	CommandError: function (stdErr, code, previous) {
 		Error.captureStackTrace(this, this.constructor);
 		this.name     = this.constructor.name;
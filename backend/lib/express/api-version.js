@@ -0,0 +1,58 @@
+const error = require('../error');
+
+// The versions of the API. Paths without one are the first, as they were before there were versions.
+const VERSIONS = [1, 2];
+
+/**
+ * Routes that change in a later version. Requests to them in an earlier one are answered with
+ * Deprecation and Sunset headers, and a link to where they are in the version that changes them.
+ */
+const DEPRECATIONS = [
+	{
+		// Issuing and renewing while the request waits, up to 15 minutes, rather than as a job
+		method:     'POST',
+		path:       /^\/nginx\/certificates(\/[0-9]+\/renew)?$/,
+		applies:    (req) => req.query.async !== 'true' && req.query.async !== '1',
+		changed_in: 2,
+		deprecated: '2026-10-16T00:00:00Z',
+		sunset:     '2027-10-16T00:00:00Z'
+	}
+];
+
+/**
+ * Takes the version off the path, ie: /v2/nginx/certificates, so the routes are the same for each one.
+ * Routes that behave differently between versions check res.locals.api_version.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		const matches = req.url.match(/^\/v([0-9]+)(\/.*)?$/);
+		let version   = 1;
+
+		if (matches !== null) {
+			version = parseInt(matches[1], 10);
+			req.url = matches[2] || '/';
+
+			if (VERSIONS.indexOf(version) === -1) {
+				next(new error.ValidationError('There\'s no version ' + version + ' of the API, only ' + VERSIONS.join(' and ')));
+				return;
+			}
+		}
+
+		res.locals.api_version = version;
+		res.set('API-Version', String(version));
+
+		const deprecation = DEPRECATIONS.find((item) => {
+			return version < item.changed_in && req.method === item.method && item.path.test(req.path) && item.applies(req);
+		});
+
+		if (deprecation) {
+			res.set({
+				Deprecation: '@' + Math.floor(new Date(deprecation.deprecated).getTime() / 1000),
+				Sunset:      new Date(deprecation.sunset).toUTCString(),
+				Link:        '</api/v' + deprecation.changed_in + req.path + '>; rel="successor-version"'
+			});
+		}
+
+		next();
+	};
+};
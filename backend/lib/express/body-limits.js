@@ -0,0 +1,58 @@
+const config = require('../config');
+const error  = require('../error');
+
+/**
+ * Groups of routes by the size and type of body they take. The first group with a matching path is used.
+ */
+const GROUPS = [
+	{
+		name:  'uploads',
+		path:  /^\/nginx\/(certificates\/(validate|[0-9]+\/upload)|proxy-hosts\/[0-9]+\/upstream-ca)$/,
+		types: ['multipart/form-data']
+	},
+	{
+		// Config files are uploaded, or a path to them given as JSON
+		name:  'uploads',
+		path:  /^\/system\/import\/(nginx|caddy|traefik)$/,
+		types: ['multipart/form-data', 'application/json']
+	},
+	{
+		name:  'default',
+		path:  /.*/,
+		types: ['application/json', 'application/x-www-form-urlencoded']
+	}
+];
+
+/**
+ * Rejects request bodies that are too big or of a type the route doesn't take, using the
+ * headers alone, so nothing has read the body into memory yet. The limits are in the backend settings.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		const has_length = typeof req.headers['content-length'] !== 'undefined';
+
+		// Without a length there's no knowing how big the body is until it's been read
+		if (!has_length && typeof req.headers['transfer-encoding'] !== 'undefined') {
+			next(new error.LengthRequiredError('Request bodies must have a Content-Length'));
+			return;
+		}
+
+		const length = has_length ? parseInt(req.headers['content-length'], 10) : 0;
+		if (!length) {
+			next();
+			return;
+		}
+
+		const group = GROUPS.find((item) => item.path.test(req.path));
+		const limit = config.getSetting('body_limits')[group.name];
+		const type  = (req.headers['content-type'] || '').split(';')[0].trim().toLowerCase();
+
+		if (length > limit) {
+			next(new error.PayloadTooLargeError('Request body is ' + length + ' bytes, the limit is ' + limit));
+		} else if (group.types.indexOf(type) === -1) {
+			next(new error.UnsupportedMediaTypeError('Content-Type must be ' + group.types.join(' or ')));
+		} else {
+			next();
+		}
+	};
+};
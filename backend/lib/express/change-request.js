@@ -0,0 +1,24 @@
+const internalChangeRequest = require('../../internal/change-request');
+
+/**
+ * Turns the change into a change request and answers with it, when the changes
+ * of the user need to be approved. Otherwise the route makes the change as usual.
+ *
+ * @param   {String}  object_type  ie: 'proxy-host'
+ * @param   {String}  action       'create', 'update' or 'delete'
+ * @returns {Function}
+ */
+module.exports = (object_type, action) => {
+	return function (req, res, next) {
+		internalChangeRequest.submit(res.locals.access, object_type, action, req.params, req.body)
+			.then((change_request) => {
+				if (change_request) {
+					res.status(202)
+						.send(change_request);
+				} else {
+					next();
+				}
+			})
+			.catch(next);
+	};
+};
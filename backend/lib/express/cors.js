@@ -1,16 +1,85 @@
-module.exports = function (req, res, next) {
-	if (req.headers.origin) {
-		res.set({
-			'Access-Control-Allow-Origin':      req.headers.origin,
-			'Access-Control-Allow-Credentials': true,
-			'Access-Control-Allow-Methods':     'OPTIONS, GET, POST',
-			'Access-Control-Allow-Headers':     'Content-Type, Cache-Control, Pragma, Expires, Authorization, X-Dataset-Total, X-Dataset-Offset, X-Dataset-Limit',
-			'Access-Control-Max-Age':           5 * 60,
-			'Access-Control-Expose-Headers':    'X-Dataset-Total, X-Dataset-Offset, X-Dataset-Limit'
-		});
-		next();
-	} else {
+const settingModel = require('../../models/setting');
+
+const EXPOSE_HEADERS = 'X-Dataset-Total, X-Dataset-Offset, X-Dataset-Limit, X-Request-Id, API-Version, Deprecation, Sunset, Link';
+
+/**
+ * Used when the CORS setting is "default", which allows any origin
+ */
+const DEFAULTS = {
+	origins:     ['*'],
+	methods:     ['OPTIONS', 'GET', 'POST'],
+	headers:     ['Content-Type', 'Cache-Control', 'Pragma', 'Expires', 'Authorization', 'X-Dataset-Total', 'X-Dataset-Offset', 'X-Dataset-Limit'],
+	credentials: true,
+	max_age:     5 * 60
+};
+
+let cors = null;
+
+/**
+ * The CORS setting, read once and kept until it's updated
+ *
+ * @returns {Promise}
+ */
+const getCors = () => {
+	if (cors === null) {
+		cors = settingModel
+			.query()
+			.where('id', 'cors')
+			.first()
+			.then((row) => {
+				if (!row || row.value !== 'custom') {
+					return DEFAULTS;
+				}
+				return Object.assign({}, DEFAULTS, {origins: [], credentials: false}, row.meta);
+			})
+			.catch((err) => {
+				cors = null;
+				throw err;
+			});
+	}
+	return cors;
+};
+
+const middleware = function (req, res, next) {
+	const origin = req.headers.origin;
+	if (!origin) {
 		// No origin
 		next();
+		return;
 	}
+
+	getCors()
+		.then((settings) => {
+			const any = settings.origins.indexOf('*') !== -1;
+			if (!any && settings.origins.map((item) => item.toLowerCase()).indexOf(origin.toLowerCase()) === -1) {
+				// Browsers will refuse the response without the headers
+				return;
+			}
+
+			res.vary('Origin');
+			res.set({
+				'Access-Control-Allow-Origin':   any && !settings.credentials ? '*' : origin,
+				'Access-Control-Allow-Methods':  settings.methods.join(', '),
+				'Access-Control-Allow-Headers':  settings.headers.join(', '),
+				'Access-Control-Max-Age':        settings.max_age,
+				'Access-Control-Expose-Headers': EXPOSE_HEADERS
+			});
+
+			if (settings.credentials) {
+				res.set('Access-Control-Allow-Credentials', true);
+			}
+		})
+		.then(() => {
+			next();
+		})
+		.catch(next);
+};
+
+/**
+ * Reads the setting again on the next request
+ */
+middleware.reset = () => {
+	cors = null;
 };
+
+module.exports = middleware;
@@ -0,0 +1,25 @@
+const internalDryRun = require('../../internal/dry-run');
+
+/**
+ * Tries the change out and answers with what it would do, when the request has a
+ * dry_run query parameter. Otherwise the route makes the change as usual.
+ *
+ * @param   {String}  object_type  ie: 'proxy-host'
+ * @param   {String}  action       'create', 'update' or 'delete'
+ * @returns {Function}
+ */
+module.exports = (object_type, action) => {
+	return function (req, res, next) {
+		if (req.query.dry_run !== 'true' && req.query.dry_run !== '1') {
+			next();
+			return;
+		}
+
+		internalDryRun.run(res.locals.access, object_type, action, req.params, req.body)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	};
+};
@@ -0,0 +1,66 @@
+const error = require('../error');
+
+const TYPE = 'application/x-ndjson';
+
+module.exports = {
+
+	/**
+	 * @param   {Object}   req
+	 * @returns {Boolean}  whether the client asked for JSON lines instead of an array
+	 */
+	wanted: function (req) {
+		return req.accepts(['application/json', TYPE]) === TYPE;
+	},
+
+	/**
+	 * Sends rows as they're fetched, one JSON object a line, waiting for the client to take each batch.
+	 * Nothing is sent before the first batch, so an error until then is an error response as usual.
+	 * An error after that ends the response early.
+	 *
+	 * @param   {Object}    res
+	 * @param   {Function}  produce  given the function to write each batch of rows with, returns a Promise
+	 * @returns {Promise}
+	 */
+	send: function (res, produce) {
+		let closed = false;
+		res.on('close', () => {
+			closed = true;
+		});
+
+		const start = () => {
+			if (!res.headersSent) {
+				res.status(200)
+					.type(TYPE);
+			}
+		};
+
+		const write = (rows) => {
+			if (closed) {
+				// Stops fetching the rest
+				throw new error.InternalError('The client closed the connection');
+			}
+
+			start();
+			if (res.write(rows.map((row) => JSON.stringify(row) + '\n').join(''))) {
+				return Promise.resolve();
+			}
+
+			return new Promise((resolve) => {
+				res.once('drain', resolve);
+				res.once('close', resolve);
+			});
+		};
+
+		return produce(write)
+			.then(() => {
+				start();
+				res.end();
+			})
+			.catch((err) => {
+				if (!res.headersSent) {
+					throw err;
+				}
+				res.destroy(err);
+			});
+	}
+};
@@ -1,5 +1,19 @@
+const config = require('../config');
+const error  = require('../error');
+
+// Routes that work without the JWT keys, ie: the health check, which reports them, the setup, which can replace them,
+// the public status pages, the page clients kept out by an access list ask for access on and where its users change their passwords
+const KEYLESS = /^\/((schema|setup|status|access-requests\/portal|access-list-passwords)(\/.*)?)?$/;
+
 module.exports = function () {
 	return function (req, res, next) {
+		// Rather than every request failing in its own way, those needing a token say why they can't have one
+		const keys_error = config.getKeysError();
+		if (keys_error !== null && !KEYLESS.test(req.path)) {
+			next(new error.KeysUnavailableError(keys_error));
+			return;
+		}
+
 		if (req.headers.authorization) {
 			let parts = req.headers.authorization.split(' ');
 
@@ -1,11 +1,19 @@
-let _ = require('lodash');
+let _     = require('lodash');
+let error = require('../error');
 
-module.exports = function (default_sort, default_offset, default_limit, max_limit) {
+/**
+ * @param {Array}   sortable          the fields that can be sorted by, which are the only ones that ever reach a query
+ * @param {String}  default_sort
+ * @param {Number}  [default_offset]
+ * @param {Number}  [default_limit]
+ * @param {Number}  [max_limit]
+ */
+module.exports = function (sortable, default_sort, default_offset, default_limit, max_limit) {
 
 	/**
 	 * This will setup the req query params with filtered data and defaults
 	 *
-	 * sort    will be an array of fields and their direction
+	 * sort    will be an array of fields and their direction, ending with id so pages never overlap or skip a row
 	 * offset  will be an int, defaulting to zero if no other default supplied
 	 * limit   will be an int, defaulting to 50 if no other default supplied, and limited to the max if that was supplied
 	 *
@@ -13,40 +21,51 @@ module.exports = function (default_sort, default_offset, default_limit, max_limi
 
 	return function (req, res, next) {
 
-		req.query.offset = typeof req.query.limit === 'undefined' ? default_offset || 0 : parseInt(req.query.offset, 10);
+		req.query.offset = typeof req.query.offset === 'undefined' ? default_offset || 0 : parseInt(req.query.offset, 10);
 		req.query.limit  = typeof req.query.limit === 'undefined' ? default_limit || 50 : parseInt(req.query.limit, 10);
 
+		if (isNaN(req.query.offset) || req.query.offset < 0 || isNaN(req.query.limit) || req.query.limit < 1) {
+			next(new error.ValidationError('offset and limit must be positive numbers'));
+			return;
+		}
+
 		if (max_limit && req.query.limit > max_limit) {
 			req.query.limit = max_limit;
 		}
 
 		// Sorting
-		let sort       = typeof req.query.sort === 'undefined' ? default_sort : req.query.sort;
-		let myRegexp   = /.*\.(asc|desc)$/ig;
+		let sort       = typeof req.query.sort === 'string' ? req.query.sort : default_sort;
 		let sort_array = [];
 
 		sort = sort.split(',');
-		_.map(sort, function (val) {
-			let matches = myRegexp.exec(val);
-
-			if (matches !== null) {
-				let dir = matches[1];
-				sort_array.push({
-					field: val.substr(0, val.length - (dir.length + 1)),
-					dir:   dir.toLowerCase()
-				});
-			} else {
-				sort_array.push({
-					field: val,
-					dir:   'asc'
-				});
+		for (let val of sort) {
+			let matches = val.match(/^(.*)\.(asc|desc)$/i);
+			let field   = matches !== null ? matches[1] : val;
+
+			if (sortable.indexOf(field) === -1 && field !== 'id') {
+				next(new error.ValidationError('Can\'t sort by ' + field + ', only by ' + sortable.concat(['id']).join(', ')));
+				return;
 			}
-		});
+
+			sort_array.push({
+				field: field,
+				dir:   matches !== null ? matches[2].toLowerCase() : 'asc'
+			});
+		}
+
+		// Rows with the same values would come back in any order, so the id decides between them
+		if (!_.find(sort_array, {field: 'id'})) {
+			sort_array.push({
+				field: 'id',
+				dir:   'asc'
+			});
+		}
 
 		// Sort will now be in this format:
 		// [
 		//    { field: 'field1', dir: 'asc' },
-		//    { field: 'field2', dir: 'desc' }
+		//    { field: 'field2', dir: 'desc' },
+		//    { field: 'id', dir: 'asc' }
 		// ]
 
 		req.query.sort = sort_array;
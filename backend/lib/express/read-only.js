@@ -0,0 +1,116 @@
+const settingModel = require('../../models/setting');
+const readCache    = require('../read-cache');
+const error        = require('../error');
+
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+/**
+ * Requests that are let through while read only, because they don't change anything. Turning
+ * read only mode off is one of them, unless it's forced on by the environment.
+ */
+const ALLOWED = [
+	{method: 'POST', path: /^\/tokens$/},
+	{method: 'POST', path: /^\/tools\/dns-check$/},
+	{method: 'POST', path: /^\/nginx\/certificates\/validate$/},
+	{method: 'POST', path: /^\/nginx\/(proxy|redirection|dead)-hosts\/[0-9]+\/tls-scan$/},
+	{method: 'POST', path: /^\/nginx\/proxy-hosts\/[0-9]+\/diagnose$/},
+	{method: 'PUT', path: /^\/settings\/read-only$/, unless_forced: true}
+];
+
+// Creating, updating and deleting these can be tried out with dry_run, see lib/express/dry-run
+const DRY_RUN = /^\/nginx\/(proxy-hosts|redirection-hosts|dead-hosts|streams|access-lists|certificates)(\/[0-9]+)?$/;
+
+const DEFAULT_MESSAGE = 'This instance is read only, nothing can be changed';
+
+// What the setting is read from, a write to it by this replica makes it stale
+const TABLES = ['setting'];
+
+// A change saved on another replica isn't seen here, so the setting is only trusted this long after it was read
+const TRUSTED_FOR = 1000 * 5;
+
+// The setting last read, {promise, generation, expires}
+let setting = null;
+
+/**
+ * @returns {Boolean}
+ */
+const isForced = () => {
+	if (typeof process.env.READ_ONLY !== 'undefined') {
+		const val = process.env.READ_ONLY.toLowerCase();
+		return val === 'on' || val === 'true' || val === '1' || val === 'yes';
+	}
+	return false;
+};
+
+/**
+ * The read only setting, read again once it's been written to or isn't trusted anymore
+ *
+ * @returns {Promise}  resolves with {enabled, message}
+ */
+const getSetting = () => {
+	if (setting !== null && (setting.expires <= Date.now() || readCache.getGeneration(TABLES) !== setting.generation)) {
+		setting = null;
+	}
+
+	if (setting === null) {
+		const current = {
+			generation: readCache.getGeneration(TABLES),
+			expires:    Date.now() + TRUSTED_FOR
+		};
+
+		current.promise = settingModel
+			.query()
+			.where('id', 'read-only')
+			.first()
+			.then((row) => {
+				const meta = row && row.meta ? row.meta : {};
+				return {
+					enabled: isForced() || (!!row && row.value === 'on'),
+					message: meta.message || DEFAULT_MESSAGE
+				};
+			})
+			.catch((err) => {
+				if (setting === current) {
+					setting = null;
+				}
+				throw err;
+			});
+
+		setting = current;
+	}
+	return setting.promise;
+};
+
+const middleware = function (req, res, next) {
+	if (SAFE_METHODS.indexOf(req.method) !== -1) {
+		next();
+		return;
+	}
+
+	// Dry runs don't change anything either, on the routes that have them
+	if ((req.query.dry_run === 'true' || req.query.dry_run === '1') && DRY_RUN.test(req.path)) {
+		next();
+		return;
+	}
+
+	const allowed = ALLOWED.find((item) => item.method === req.method && item.path.test(req.path));
+	if (allowed && !(allowed.unless_forced && isForced())) {
+		next();
+		return;
+	}
+
+	getSetting()
+		.then((current) => {
+			next(current.enabled ? new error.ReadOnlyError(current.message) : undefined);
+		})
+		.catch(next);
+};
+
+/**
+ * Reads the setting again on the next request
+ */
+middleware.reset = () => {
+	setting = null;
+};
+
+module.exports = middleware;
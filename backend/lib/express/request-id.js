@@ -0,0 +1,15 @@
+const crypto = require('crypto');
+
+/**
+ * Gives every request an id, sent back in the X-Request-Id header and with errors, so a report from a
+ * user can be found in the logs. An id from the proxy in front is kept, ie: nginx's $request_id.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		const given = req.get('x-request-id');
+
+		res.locals.request_id = given && /^[A-Za-z0-9._-]{1,64}$/.test(given) ? given : crypto.randomUUID();
+		res.set('X-Request-Id', res.locals.request_id);
+		next();
+	};
+};
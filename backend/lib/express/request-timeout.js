@@ -0,0 +1,73 @@
+const config         = require('../config');
+const error          = require('../error');
+const requestContext = require('../request-context');
+
+/**
+ * Groups of routes by how long they can take. The first group with a matching path is used.
+ */
+const GROUPS = [
+	{
+		// Live events and waiting for a job to change end by themselves
+		name: null,
+		path: /^\/(events|jobs)(\/|$)/
+	},
+	{
+		name: 'long',
+		path: /^\/(nginx\/(certificates|export|lint|drift)|system|tools|settings)(\/|$)|^\/nginx\/proxy-hosts\/[0-9]+\/(tls-scan|diagnose|security-report)$/
+	},
+	{
+		name: 'default',
+		path: /.*/
+	}
+];
+
+// Requests that are posted but only check something, and are cancelled like reads
+const CHECKS = /^\/(tools\/dns-check|nginx\/proxy-hosts\/[0-9]+\/(tls-scan|diagnose)|nginx\/certificates\/(test-http|validate))$/;
+
+/**
+ * Answers requests that take longer than the timeout of their group with an error, the timeouts are in the
+ * backend settings. Requests that only read or check also get an abort signal that everything done for them
+ * follows, ie: commands and queries. It's aborted when they time out, when the client goes away before the
+ * response is sent, or when the server stops. Requests that change something carry on to the end, so nothing
+ * is left half done.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		const group       = GROUPS.find((item) => item.path.test(req.path));
+		const cancellable = req.method === 'GET' || req.method === 'HEAD' || CHECKS.test(req.path);
+		const controller  = new AbortController();
+
+		// Lists sent as JSON lines take as long as there are rows to send
+		const name    = group.name === 'default' && req.accepts(['application/json', 'application/x-ndjson']) === 'application/x-ndjson' ? 'long' : group.name;
+		const seconds = name ? config.getSetting('request_timeouts')[name] : 0;
+
+		let timer = null;
+		if (seconds) {
+			timer = setTimeout(() => {
+				if (cancellable) {
+					controller.abort('The request took longer than ' + seconds + ' seconds');
+				}
+				if (!res.headersSent) {
+					next(new error.RequestTimeoutError('The request took longer than ' + seconds + ' seconds'));
+				}
+			}, seconds * 1000);
+		}
+
+		res.on('close', () => {
+			clearTimeout(timer);
+			requestContext.finish(controller);
+
+			if (cancellable && !res.writableFinished) {
+				controller.abort('The client closed the connection');
+			}
+		});
+
+		if (!cancellable) {
+			next();
+			return;
+		}
+
+		res.locals.abort_controller = controller;
+		requestContext.run(controller, next);
+	};
+};
@@ -0,0 +1,24 @@
+const requestContext = require('../request-context');
+const tracing        = require('../tracing');
+
+/**
+ * Runs the rest of the request in its abort signal and span again once the body is read. The parsers carry on
+ * from the events of the socket, which know nothing of the request they're for.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		let fn = next;
+
+		if (res.locals.span) {
+			const inner = fn;
+			fn          = () => tracing.run(res.locals.span, inner);
+		}
+
+		if (res.locals.abort_controller) {
+			const inner = fn;
+			fn          = () => requestContext.enter(res.locals.abort_controller, inner);
+		}
+
+		fn();
+	};
+};
@@ -0,0 +1,25 @@
+const internalScheduledChange = require('../../internal/scheduled-change');
+
+/**
+ * Stages the change to be made later and answers with it, when the request has an
+ * apply_at query parameter. Otherwise the route makes the change as usual.
+ *
+ * @param   {String}  object_type  ie: 'proxy-host'
+ * @param   {String}  action       'create', 'update' or 'delete'
+ * @returns {Function}
+ */
+module.exports = (object_type, action) => {
+	return function (req, res, next) {
+		if (typeof req.query.apply_at !== 'string' || !req.query.apply_at) {
+			next();
+			return;
+		}
+
+		internalScheduledChange.submit(res.locals.access, object_type, action, req.params, req.body, req.query.apply_at)
+			.then((scheduled_change) => {
+				res.status(202)
+					.send(scheduled_change);
+			})
+			.catch(next);
+	};
+};
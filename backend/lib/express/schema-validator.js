@@ -0,0 +1,62 @@
+const _            = require('lodash');
+const schema       = require('../../schema');
+const apiValidator = require('../validator/api');
+
+let routes = null;
+
+/**
+ * Turns the paths in the API spec into patterns, with fixed paths like /nginx/certificates/validate
+ * ahead of ones with parameters like /nginx/certificates/{certID}
+ *
+ * @param   {Object}  compiled
+ * @returns {Array}
+ */
+const getRoutes = (compiled) => {
+	return _.sortBy(Object.keys(compiled.paths).map((path) => {
+		return {
+			path:    path,
+			params:  (path.match(/\{[^}]+\}/g) || []).length,
+			pattern: new RegExp('^' + path.replace(/[.*+?^$()|[\]\\]/g, '\\$&').replace(/\{[^}]+\}/g, '[^/]+') + '$')
+		};
+	}), 'params');
+};
+
+/**
+ * Validates JSON request bodies against the API spec for every route that has a schema for them,
+ * so none of them can take unknown or mistyped fields. Failures are a 422 with an entry for each field.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		const method = req.method.toLowerCase();
+		if (['post', 'put', 'patch'].indexOf(method) === -1 || !req.is('application/json')) {
+			next();
+			return;
+		}
+
+		schema.getCompiledSchema()
+			.then((compiled) => {
+				if (routes === null) {
+					routes = getRoutes(compiled);
+				}
+
+				const route = routes.find((item) => item.pattern.test(req.path));
+				if (!route) {
+					return;
+				}
+
+				const validation_schema = schema.getValidationSchema(route.path, method);
+				if (validation_schema === null) {
+					return;
+				}
+
+				return apiValidator(validation_schema, req.body)
+					.then((payload) => {
+						req.body = payload;
+					});
+			})
+			.then(() => {
+				next();
+			})
+			.catch(next);
+	};
+};
@@ -0,0 +1,40 @@
+const tracing = require('../tracing');
+const helpers = require('../helpers');
+
+/**
+ * Traces each request when there's a collector for it, with everything done for it as spans under its own.
+ * A traceparent header from the proxy or client in front makes it part of their trace.
+ */
+module.exports = function () {
+	return function (req, res, next) {
+		if (!tracing.enabled()) {
+			next();
+			return;
+		}
+
+		const span = tracing.startSpan(req.method, {
+			'http.request.method': req.method,
+			'url.path':            req.originalUrl.replace(/\?.*$/, ''),
+			'request.id':          res.locals.request_id
+		}, {
+			kind:   tracing.KIND.server,
+			parent: req.get('traceparent')
+		});
+
+		const end = () => {
+			const route = helpers.getRoutePath(req);
+			span.setName(req.method + ' ' + route);
+			span.setAttributes({
+				'http.route':                route,
+				'http.response.status_code': res.statusCode
+			});
+			span.end(res.statusCode >= 500 ? new Error('Answered ' + res.statusCode) : undefined);
+		};
+
+		res.on('finish', end);
+		res.on('close', end);
+
+		res.locals.span = span;
+		tracing.run(span, next);
+	};
+};
@@ -1,9 +1,36 @@
+const net          = require('net');
+const url          = require('url');
 const moment       = require('moment');
 const {isPostgres} = require('./config');
 const {ref}        = require('objection');
 
+const multiLabelSuffixes = [
+	'com.cn', 'net.cn', 'org.cn', 'gov.cn', 'edu.cn', 'ac.cn',
+	'com.hk', 'com.tw', 'org.tw', 'idv.tw',
+	'co.uk', 'org.uk', 'me.uk', 'ac.uk',
+	'com.au', 'net.au', 'org.au',
+	'co.jp', 'ne.jp', 'or.jp',
+	'co.kr', 'co.nz', 'co.za', 'co.in', 'com.br', 'com.sg', 'com.my',
+	'duckdns.org', 'dynu.net', 'ddns.net'
+];
+
 module.exports = {
 
+	/**
+	 * The path of a request with its ids and API version left out, ie: '/nginx/proxy-hosts/:id'. Express has
+	 * forgotten the route it matched by the time an error or the end of the response is handled.
+	 *
+	 * @param   {Object}  req
+	 * @returns {String}
+	 */
+	getRoutePath: function (req) {
+		return req.originalUrl
+			.replace(/\?.*$/, '')
+			.replace(/^\/v[0-9]+(?=\/|$)/, '')
+			.replace(/\/[0-9]+(?=\/|$)/g, '/:id')
+			.replace(/(.)\/$/, '$1');
+	},
+
 	/**
 	 * Takes an expression such as 30d and returns a moment object of that date in future
 	 *
@@ -49,6 +76,57 @@ module.exports = {
 		return obj;
 	},
 
+	/**
+	 * The registered domain for a hostname, eg www.example.com.cn => example.com.cn
+	 * This only knows the common multi label public suffixes, not the full Public Suffix List.
+	 *
+	 * @param   {String}  hostname
+	 * @returns {String}
+	 */
+	getRegisteredDomain: function (hostname) {
+		const labels = hostname.toLowerCase().replace(/^\*\./, '').replace(/\.$/, '').split('.');
+		if (labels.length <= 2) {
+			return labels.join('.');
+		}
+
+		const suffix = labels.slice(-2).join('.');
+		const count  = multiLabelSuffixes.indexOf(suffix) !== -1 ? 3 : 2;
+		return labels.slice(-count).join('.');
+	},
+
+	/**
+	 * The punycode form of a domain name, for nginx, certbot and DNS, eg 例子.中国 => xn--fsqu00a.xn--fiqs8s
+	 * A leading wildcard label and IP addresses are kept as they are.
+	 *
+	 * @param   {String}  domain_name
+	 * @returns {String}  empty when it can't be converted
+	 */
+	toAsciiDomain: function (domain_name) {
+		if (net.isIP(domain_name)) {
+			return domain_name;
+		}
+
+		const wildcard = domain_name.indexOf('*.') === 0;
+		const ascii    = url.domainToASCII(wildcard ? domain_name.substring(2) : domain_name);
+		return ascii && wildcard ? '*.' + ascii : ascii;
+	},
+
+	/**
+	 * The form of a domain name to store and show, eg xn--fsqu00a.xn--fiqs8s => 例子.中国
+	 *
+	 * @param   {String}  domain_name
+	 * @returns {String}  empty when it can't be converted
+	 */
+	toUnicodeDomain: function (domain_name) {
+		if (net.isIP(domain_name)) {
+			return domain_name;
+		}
+
+		const wildcard = domain_name.indexOf('*.') === 0;
+		const unicode  = url.domainToUnicode(wildcard ? domain_name.substring(2) : domain_name);
+		return unicode && wildcard ? '*.' + unicode : unicode;
+	},
+
 	/**
 	 * Casts a column to json if using postgres
 	 *
@@ -0,0 +1,85 @@
+// The languages a user can pick, English being what the messages are written in
+const LOCALES = ['en', 'zh-CN', 'zh-TW'];
+
+const HOST_TYPES = {
+	'zh-CN': {
+		'proxy host':       '代理主机',
+		'redirection host': '重定向主机',
+		'dead host':        '404 主机',
+		'stream':           '数据流',
+		'status page':      '状态页'
+	},
+	'zh-TW': {
+		'proxy host':       '代理主機',
+		'redirection host': '重新導向主機',
+		'dead host':        '404 主機',
+		'stream':           '資料流',
+		'status page':      '狀態頁'
+	}
+};
+
+/**
+ * Messages of the errors anyone can run into, by what they look like in English.
+ * Anything not here is sent as it is.
+ */
+const MESSAGES = [
+	{
+		match:   /^Permission Denied$/,
+		'zh-CN': '没有权限',
+		'zh-TW': '沒有權限'
+	},
+	{
+		match:   /^Item Not Found - (.+)$/,
+		'zh-CN': '找不到项目 - $1',
+		'zh-TW': '找不到項目 - $1'
+	},
+	{
+		match:   /^Internal Error$/,
+		'zh-CN': '内部错误',
+		'zh-TW': '內部錯誤'
+	},
+	{
+		match:   /^Host is already enabled$/,
+		'zh-CN': '主机已经启用',
+		'zh-TW': '主機已經啟用'
+	},
+	{
+		match:   /^Host is already disabled$/,
+		'zh-CN': '主机已经禁用',
+		'zh-TW': '主機已經停用'
+	},
+	{
+		match:   /^Email address already in use - (.+)$/,
+		'zh-CN': '邮箱地址已被使用 - $1',
+		'zh-TW': '電子郵件地址已被使用 - $1'
+	},
+	{
+		match:   /^(.+) is already in use by (proxy host|redirection host|dead host|stream|status page) #(\d+)$/,
+		'zh-CN': (_match, domain, type, id) => domain + ' 已被' + HOST_TYPES['zh-CN'][type] + ' #' + id + ' 使用',
+		'zh-TW': (_match, domain, type, id) => domain + ' 已被' + HOST_TYPES['zh-TW'][type] + ' #' + id + ' 使用'
+	},
+	{
+		match:   /^You have reached your quota of (\d+) (.+), delete one of them or ask an administrator to raise it$/,
+		'zh-CN': '已达到 $2 的配额 $1，请删除一些或联系管理员提高配额',
+		'zh-TW': '已達到 $2 的配額 $1，請刪除一些或聯絡管理員提高配額'
+	}
+];
+
+module.exports = {
+
+	LOCALES: LOCALES,
+
+	/**
+	 * @param   {String}  message  in English
+	 * @param   {String}  [locale]
+	 * @returns {String}  the message in the language, or as it is when there isn't a translation
+	 */
+	translate: (message, locale) => {
+		if (!locale || locale === 'en' || typeof message !== 'string') {
+			return message;
+		}
+
+		const found = MESSAGES.find((item) => item.match.test(message) && typeof item[locale] !== 'undefined');
+		return found ? message.replace(found.match, found[locale]) : message;
+	}
+};
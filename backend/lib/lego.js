@@ -0,0 +1,318 @@
+const fs           = require('fs');
+const path         = require('path');
+const config       = require('./config');
+const error        = require('./error');
+const utils        = require('./utils');
+const helpers      = require('./helpers');
+const logger       = require('../logger').ssl;
+const dnsProviders = require('../global/lego-dns-providers.json');
+
+const LE_STAGING = 'https://acme-staging-v02.api.letsencrypt.org/directory';
+
+const legoCommand = 'lego';
+
+// The same webroot certbot uses, served by conf.d/include/letsencrypt-acme-challenge.conf
+const webroot = '/data/letsencrypt-acme-challenge';
+
+// The DNS provider of test mode, internal/test-mode answers lego's httpreq provider for it
+const MOCK_DNS_PROVIDER = 'mock';
+
+// The CA Pebble's own certificate is signed by, installed in the image
+const PEBBLE_CA = '/etc/ssl/certs/pebble.minica.pem';
+
+/**
+ * @param   {String}  dns_provider  the certbot plugin, ie: cloudflare
+ * @returns {Object|undefined}  {provider, env}
+ */
+const getDnsProvider = (dns_provider) => {
+	if (dns_provider === MOCK_DNS_PROVIDER && config.getTestMode()) {
+		return {provider: 'httpreq', env: {}};
+	}
+	return dnsProviders[dns_provider];
+};
+
+/**
+ * An ACME client to request certificates with instead of certbot, chosen in the acme-client setting.
+ * It's run without a shell and the DNS credentials are given to it in its environment, so it works on
+ * images without bash or python. Certificates are copied to where certbot puts them, so nginx and the
+ * rest of the certificate code don't need to know which one got it.
+ */
+const lego = {
+
+	MOCK_DNS_PROVIDER: MOCK_DNS_PROVIDER,
+
+	/**
+	 * @returns {Promise}  resolves with whether lego can be run
+	 */
+	isInstalled: () => {
+		return utils.execFile(legoCommand, ['--version'])
+			.then(() => true, () => false);
+	},
+
+	/**
+	 * @returns {String}  where lego keeps its accounts and the certificates it was given
+	 */
+	getPath: () => {
+		return config.getPath('letsencrypt') + '/lego';
+	},
+
+	/**
+	 * @param   {String}  dns_provider  the certbot plugin, ie: cloudflare
+	 * @returns {Boolean}  whether lego has a provider for it
+	 */
+	hasDnsProvider: (dns_provider) => {
+		return typeof getDnsProvider(dns_provider) !== 'undefined';
+	},
+
+	/**
+	 * Lego can't use the ACME accounts certbot keeps, so certificates on one of those stay with certbot
+	 *
+	 * @param {Object}  certificate  the certificate row
+	 */
+	assertSupported: (certificate) => {
+		if (certificate.meta && certificate.meta.acme_account_id) {
+			throw new error.ValidationError('Certificates on an ACME account can only be requested with certbot, set the acme-client setting to certbot');
+		}
+		if (certificate.meta && certificate.meta.dns_challenge && certificate.meta.dns_provider === MOCK_DNS_PROVIDER && !config.getTestMode()) {
+			throw new error.ValidationError('The mock DNS provider can only be used in test mode');
+		}
+		if (certificate.meta && certificate.meta.dns_challenge && !lego.hasDnsProvider(certificate.meta.dns_provider)) {
+			throw new error.ValidationError('The ' + certificate.meta.dns_provider + ' DNS provider can only be used with certbot');
+		}
+	},
+
+	/**
+	 * Reads the credentials the certbot plugin would have, ie: "dns_cloudflare_api_token = abc",
+	 * into the environment variables the lego provider reads them from
+	 *
+	 * @param   {String}  dns_provider
+	 * @param   {String}  credentials
+	 * @returns {Object}
+	 */
+	getDnsEnv: (dns_provider, credentials) => {
+		if (dns_provider === MOCK_DNS_PROVIDER && config.getTestMode()) {
+			return {HTTPREQ_ENDPOINT: 'http://127.0.0.1:' + config.getTestMode().control_port};
+		}
+
+		const names = dnsProviders[dns_provider].env;
+		let env     = {};
+
+		(credentials || '').split('\n').forEach((line) => {
+			const found = line.trim().match(/^([A-Za-z0-9_-]+)\s*=\s*(.*)$/);
+			if (!found || typeof names[found[1]] === 'undefined') {
+				return;
+			}
+			env[names[found[1]]] = found[2].replace(/^(["'])(.*)\1$/, '$2');
+		});
+
+		return env;
+	},
+
+	/**
+	 * The options before the command, the same for every command on a certificate
+	 *
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Array}
+	 */
+	getArgs: (certificate) => {
+		let server = config.useLetsencryptServer();
+		if (certificate.meta.use_staging || (server === null && config.useLetsencryptStaging())) {
+			server = LE_STAGING;
+		}
+
+		let args = ['--path', lego.getPath(), '--email', certificate.meta.letsencrypt_email, '--accept-tos', '--key-type', 'ec384'];
+		if (server !== null) {
+			args.push('--server', server);
+		}
+
+		if (certificate.meta.dns_challenge) {
+			args.push('--dns', getDnsProvider(certificate.meta.dns_provider).provider);
+			if (typeof certificate.meta.propagation_seconds !== 'undefined') {
+				args.push('--dns.propagation-wait', certificate.meta.propagation_seconds + 's');
+			} else if (certificate.meta.dns_provider === MOCK_DNS_PROVIDER) {
+				// Only the mock DNS server has the records, the name servers of the domains can't be checked for them
+				args.push('--dns.propagation-wait', '1s');
+			}
+		} else {
+			args.push('--http', '--http.webroot', webroot);
+		}
+
+		certificate.domain_names.forEach((domain) => {
+			args.push('--domains', helpers.toAsciiDomain(domain));
+		});
+
+		return args;
+	},
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Array}  ie: ['--preferred-chain', 'ISRG Root X1']
+	 */
+	getChainArgs: (certificate) => {
+		if (!certificate.meta || !certificate.meta.preferred_chain) {
+			return [];
+		}
+		return ['--preferred-chain', certificate.meta.preferred_chain];
+	},
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {String}  where lego writes the certificate, named after the first domain
+	 */
+	getCertificateFile: (certificate) => {
+		const name = helpers.toAsciiDomain(certificate.domain_names[0]).replace(/\*/g, '_');
+		return path.join(lego.getPath(), 'certificates', name);
+	},
+
+	/**
+	 * Copies what lego was given to the live directory of the certificate, as certbot would have
+	 *
+	 * @param {Object}  certificate  the certificate row
+	 */
+	install: (certificate) => {
+		const from = lego.getCertificateFile(certificate);
+		const dir  = config.getPath('letsencrypt') + '/live/npm-' + certificate.id;
+
+		const fullchain = fs.readFileSync(from + '.crt', {encoding: 'utf8'});
+		const issuer    = fs.readFileSync(from + '.issuer.crt', {encoding: 'utf8'});
+		// The leaf is the first in the chain lego writes
+		const cert      = fullchain.substring(0, fullchain.indexOf('-----END CERTIFICATE-----') + 25) + '\n';
+
+		fs.mkdirSync(dir, {recursive: true});
+		fs.writeFileSync(path.join(dir, 'fullchain.pem'), fullchain);
+		fs.writeFileSync(path.join(dir, 'cert.pem'), cert);
+		fs.writeFileSync(path.join(dir, 'chain.pem'), issuer);
+		fs.writeFileSync(path.join(dir, 'privkey.pem'), fs.readFileSync(from + '.key'), {mode: 0o600});
+	},
+
+	/**
+	 * What went wrong, from what lego printed before it gave up. Problems the CA or the DNS provider
+	 * reported become an AcmeError saying which domain it was and why, anything else a CommandError.
+	 *
+	 * @param   {Error}  err  from utils.execFile
+	 * @returns {Error}
+	 */
+	getError: (err) => {
+		if (err instanceof error.CancelledError) {
+			return err;
+		}
+
+		const lines = (err.stderr || err.message || '').split('\n')
+			.map((line) => line.replace(/^\d{4}\/\d{2}\/\d{2} \d{2}:\d{2}:\d{2} /, '').trim())
+			.filter((line) => line.length && !/^\[(INFO|WARN)\]/.test(line));
+
+		for (const line of lines) {
+			const domain = line.match(/^\[([^\]]+)\]/);
+
+			const acme = line.match(/urn:ietf:params:acme:error:(\w+) :: (.+)$/);
+			if (acme) {
+				return new error.AcmeError(acme[2], {type: acme[1], domain: domain ? domain[1] : null, detail: acme[2]}, err);
+			}
+
+			const dns = line.match(/error presenting token: (.+)$/);
+			if (dns) {
+				return new error.AcmeError('The DNS provider refused the challenge record: ' + dns[1], {type: 'dns', domain: domain ? domain[1] : null, detail: dns[1]}, err);
+			}
+
+			const credentials = line.match(/some credentials information are missing: (.+)$/);
+			if (credentials) {
+				return new error.AcmeError('The DNS provider credentials are missing ' + credentials[1], {type: 'dns', domain: null, detail: line}, err);
+			}
+		}
+
+		return new error.CommandError(lines.length ? lines[lines.length - 1] : 'lego failed', err.code, err);
+	},
+
+	/**
+	 * @param   {Object}    certificate  the certificate row
+	 * @param   {Array}     args         the command and its options, after the ones of getArgs
+	 * @param   {Function}  [onOutput]
+	 * @returns {Promise}
+	 */
+	run: (certificate, args, onOutput) => {
+		args = lego.getArgs(certificate).concat(args);
+		logger.info('Command:', legoCommand + ' ' + args.join(' '));
+
+		let env = Object.assign({}, process.env);
+		if (certificate.meta.dns_challenge) {
+			env = Object.assign(env, lego.getDnsEnv(certificate.meta.dns_provider, certificate.meta.dns_provider_credentials));
+		}
+		if (config.getTestMode() && !env.LEGO_CA_CERTIFICATES) {
+			env.LEGO_CA_CERTIFICATES = PEBBLE_CA;
+		}
+
+		return utils.execFile(legoCommand, args, {env: env, onOutput: onOutput})
+			.catch((err) => {
+				throw lego.getError(err);
+			});
+	},
+
+	/**
+	 * @param   {Object}    certificate  the certificate row
+	 * @param   {Function}  [onOutput]
+	 * @returns {Promise}
+	 */
+	request: (certificate, onOutput) => {
+		return Promise.resolve()
+			.then(() => {
+				lego.assertSupported(certificate);
+				return lego.run(certificate, ['run'].concat(lego.getChainArgs(certificate)), onOutput);
+			})
+			.then((result) => {
+				lego.install(certificate);
+				return result;
+			});
+	},
+
+	/**
+	 * Renews whenever it's asked to, like certbot's --force-renewal
+	 *
+	 * @param   {Object}    certificate  the certificate row
+	 * @param   {Function}  [onOutput]
+	 * @param   {Boolean}   [reuse_key]  keep the key in the certificates directory, rather than making a new one
+	 * @returns {Promise}
+	 */
+	renew: (certificate, onOutput, reuse_key) => {
+		return lego.run(certificate, ['renew', '--days', '999', '--no-random-sleep'].concat(reuse_key ? ['--reuse-key'] : [], lego.getChainArgs(certificate)), onOutput)
+			.then((result) => {
+				lego.install(certificate);
+				return result;
+			});
+	},
+
+	/**
+	 * @param   {Object}  certificate  the certificate row
+	 * @returns {Promise}
+	 */
+	revoke: (certificate) => {
+		return lego.run(certificate, ['revoke'])
+			.then((result) => {
+				fs.rmSync(config.getPath('letsencrypt') + '/live/npm-' + certificate.id, {recursive: true, force: true});
+				return result;
+			});
+	},
+
+	/**
+	 * The phase of a request or renewal, from a line lego logged
+	 *
+	 * @param   {String}  line
+	 * @returns {Object|null}  ie: {phase: 'order'}
+	 */
+	getPhase: (line) => {
+		if (/acme: Obtaining bundled SAN certificate|acme: Trying renewal with/.test(line)) {
+			return {phase: 'order'};
+		}
+		if (/acme: Waiting for DNS record propagation/.test(line)) {
+			return {phase: 'propagation'};
+		}
+		if (/acme: Trying to solve (HTTP|DNS)-01/.test(line)) {
+			return {phase: 'validation'};
+		}
+		if (/Server responded with a certificate/.test(line)) {
+			return {phase: 'download'};
+		}
+		return null;
+	}
+};
+
+module.exports = lego;
@@ -0,0 +1,97 @@
+const config = require('./config');
+const logger = require('../logger').database;
+
+// Upper bounds of the query duration histogram, in seconds
+const BUCKETS = [0.005, 0.01, 0.05, 0.1, 0.5, 1, 5];
+
+// Long queries are cut short in the log, ie: inserts of certificate files
+const MAX_SQL_LENGTH = 500;
+
+let started = {};
+let stats   = {
+	queries: 0,
+	errors:  0,
+	slow:    0,
+	sum:     0,
+	buckets: BUCKETS.map(() => 0)
+};
+
+/**
+ * @param {Object}  query     of the knex query event
+ * @param {Boolean} is_error
+ */
+const finish = (query, is_error) => {
+	const start = started[query.__knexQueryUid];
+	if (typeof start === 'undefined') {
+		return;
+	}
+	delete started[query.__knexQueryUid];
+
+	const ms = Number(process.hrtime.bigint() - start) / 1e6;
+
+	stats.queries++;
+	stats.sum += ms / 1000;
+	BUCKETS.forEach((bound, index) => {
+		if (ms / 1000 <= bound) {
+			stats.buckets[index]++;
+		}
+	});
+
+	if (is_error) {
+		stats.errors++;
+	}
+
+	const threshold = config.getSetting('slow_query_ms');
+	if (threshold > 0 && ms >= threshold) {
+		stats.slow++;
+
+		// Bindings are left out, they can be passwords and keys
+		const sql = query.sql.length > MAX_SQL_LENGTH ? query.sql.substring(0, MAX_SQL_LENGTH) + '...' : query.sql;
+		logger.warn('Slow query, ' + Math.round(ms) + 'ms: ' + sql);
+	}
+};
+
+module.exports = {
+
+	/**
+	 * Times every query of a knex instance
+	 *
+	 * @param {Object} db
+	 */
+	attach: (db) => {
+		db.on('query', (query) => {
+			started[query.__knexQueryUid] = process.hrtime.bigint();
+		});
+		db.on('query-response', (_, query) => {
+			finish(query, false);
+		});
+		db.on('query-error', (_, query) => {
+			finish(query, true);
+		});
+	},
+
+	/**
+	 * @param   {Object}  db
+	 * @returns {Object}  the query counts and durations, and the connections of the pool
+	 */
+	get: (db) => {
+		const pool = db.client.pool;
+
+		return {
+			queries:   stats.queries,
+			errors:    stats.errors,
+			slow:      stats.slow,
+			sum:       stats.sum,
+			buckets:   BUCKETS.map((bound, index) => {
+				return {le: bound, count: stats.buckets[index]};
+			}),
+			threshold: config.getSetting('slow_query_ms'),
+			pool:      {
+				used:    pool ? pool.numUsed() : 0,
+				free:    pool ? pool.numFree() : 0,
+				pending: pool ? pool.numPendingAcquires() : 0,
+				max:     pool ? pool.max : 0
+			}
+		};
+	}
+};
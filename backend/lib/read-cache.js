@@ -0,0 +1,132 @@
+const _      = require('lodash');
+const config = require('./config');
+
+// The table a write goes to, ie: insert into `proxy_host` ...
+const WRITE = /^\s*(?:insert\s+(?:or\s+\w+\s+)?into|update|delete\s+from)\s+[`"[]?(\w+)/i;
+
+// Writes that can touch any table, and the end of a transaction, when what it wrote is seen by others
+const WRITE_ANY = /^\s*(?:commit|replace|alter|drop|truncate|create)\b/i;
+
+let entries     = {};
+let generations = {};
+let generation  = 0;
+let stats       = {
+	hits:          0,
+	misses:        0,
+	invalidations: 0
+};
+
+/**
+ * Drops every entry that was read from the table, and the entries whose reads are still running
+ * are not kept when they finish
+ *
+ * @param {String|null}  table  null for all of them
+ */
+const invalidate = (table) => {
+	generation++;
+	stats.invalidations++;
+
+	if (table === null) {
+		entries          = {};
+		generations['*'] = generation;
+		return;
+	}
+
+	generations[table] = generation;
+	_.forEach(entries, (entry, key) => {
+		if (entry.tables.indexOf(table) !== -1) {
+			delete entries[key];
+		}
+	});
+};
+
+/**
+ * @param   {Array}   tables
+ * @returns {Number}  the last time any of the tables was written to
+ */
+const getGeneration = (tables) => {
+	return _.max(tables.concat('*').map((table) => generations[table] || 0));
+};
+
+module.exports = {
+
+	/**
+	 * Drops cached reads whenever a knex instance writes to the tables they were read from
+	 *
+	 * @param {Object} db
+	 */
+	attach: (db) => {
+		db.on('query-response', (_response, query) => {
+			if (typeof query.sql !== 'string') {
+				return;
+			}
+
+			const match = query.sql.match(WRITE);
+			if (match) {
+				invalidate(match[1]);
+			} else if (WRITE_ANY.test(query.sql)) {
+				invalidate(null);
+			}
+		});
+	},
+
+	/**
+	 * Returns what was read before with the same key, when it's newer than the read_cache setting and none of
+	 * the tables were written to since. Otherwise reads it and keeps it. With a read_cache of 0 it always reads.
+	 *
+	 * @param   {String}    key     of everything the result depends on besides the tables, ie: the user and the query
+	 * @param   {Array}     tables  the result is read from
+	 * @param   {Function}  read    returns a Promise
+	 * @returns {Promise}
+	 */
+	get: (key, tables, read) => {
+		const ttl = config.getSetting('read_cache');
+		if (!ttl) {
+			return read();
+		}
+
+		const entry = entries[key];
+		if (entry && entry.expires > Date.now()) {
+			stats.hits++;
+			return Promise.resolve(_.cloneDeep(entry.value));
+		}
+
+		stats.misses++;
+		const started = generation;
+
+		return read()
+			.then((value) => {
+				// A write that finished while reading could have been missed
+				if (getGeneration(tables) <= started) {
+					entries[key] = {
+						tables:  tables,
+						expires: Date.now() + ttl * 1000,
+						value:   _.cloneDeep(value)
+					};
+				}
+				return value;
+			});
+	},
+
+	/**
+	 * @param   {Array}   tables
+	 * @returns {Number}  the last time any of the tables was written to by this replica, 0 when never
+	 */
+	getGeneration: (tables) => {
+		return getGeneration(tables);
+	},
+
+	/**
+	 * Drops everything, ie: when the setting changes
+	 */
+	clear: () => {
+		invalidate(null);
+	},
+
+	/**
+	 * @returns {Object}  how many reads were answered from the cache and how many entries it has
+	 */
+	getStats: () => {
+		return Object.assign({entries: _.size(entries)}, stats);
+	}
+};
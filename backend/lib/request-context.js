@@ -0,0 +1,80 @@
+const { AsyncLocalStorage } = require('async_hooks');
+const error                 = require('./error');
+
+// The abort signal of the request the running code is working for, followed through every promise and callback
+const storage = new AsyncLocalStorage();
+
+// Requests that haven't finished, to cancel them when the server stops
+let active = new Set();
+
+module.exports = {
+
+	/**
+	 * Runs the function, and everything it starts, for a request that can be cancelled
+	 *
+	 * @param   {AbortController}  controller
+	 * @param   {Function}         fn
+	 * @returns {*}
+	 */
+	run: function (controller, fn) {
+		active.add(controller);
+		return storage.run(controller.signal, fn);
+	},
+
+	/**
+	 * Runs the function for a request that run() was already called for, ie: once its body has been read
+	 *
+	 * @param   {AbortController}  controller
+	 * @param   {Function}         fn
+	 * @returns {*}
+	 */
+	enter: function (controller, fn) {
+		return storage.run(controller.signal, fn);
+	},
+
+	/**
+	 * @param {AbortController} controller
+	 */
+	finish: function (controller) {
+		active.delete(controller);
+	},
+
+	/**
+	 * Runs work that has to carry on after the request is gone, ie: a background job
+	 *
+	 * @param   {Function}  fn
+	 * @returns {*}
+	 */
+	detach: function (fn) {
+		return storage.exit(fn);
+	},
+
+	/**
+	 * @returns {AbortSignal|null}  of the request the code is running for, or null outside of one
+	 */
+	signal: function () {
+		return storage.getStore() || null;
+	},
+
+	/**
+	 * Throws when the request the code is running for was cancelled, before starting on more of it
+	 */
+	assertActive: function () {
+		const signal = storage.getStore();
+		if (signal && signal.aborted) {
+			throw new error.CancelledError(signal.reason);
+		}
+	},
+
+	/**
+	 * Cancels every request that's still going, when the server stops
+	 *
+	 * @param {String} reason
+	 */
+	abortAll: function (reason) {
+		active.forEach((controller) => {
+			controller.abort(reason);
+		});
+		active.clear();
+	}
+};
@@ -0,0 +1,162 @@
+const childProcess = require('child_process');
+
+/**
+ * Runs commands for lib/utils, which adds the logging, tracing and errors around them. Another
+ * runner can be put in its place with use(), so the modules that run certbot, lego or nginx can be
+ * tried without them being installed.
+ *
+ * A runner has:
+ *   exec(cmd, options, onOutput)   resolves with {stdout, stderr}, rejects with the error of
+ *                                  child_process, with the stderr added to it
+ *   execFile(file, args, options, onOutput)
+ *                                  resolves with the stdout, rejects like exec
+ */
+const system = {
+
+	/**
+	 * @param   {String}    cmd
+	 * @param   {Object}    options     for child_process.exec
+	 * @param   {Function}  [onOutput]  called with each chunk of stdout and stderr as it comes
+	 * @returns {Promise}
+	 */
+	exec: (cmd, options, onOutput) => {
+		return new Promise((resolve, reject) => {
+			let child;
+			try {
+				child = childProcess.exec(cmd, options, (err, stdout, stderr) => {
+					if (err) {
+						err.stderr = stderr;
+						reject(err);
+					} else {
+						resolve({stdout, stderr});
+					}
+				});
+			} catch (err) {
+				reject(err);
+				return;
+			}
+
+			child.on('error', (err) => {
+				// An abort or a failed exit is answered by the callback above
+				if (err.name === 'AbortError') {
+					return;
+				}
+				reject(err);
+			});
+
+			if (onOutput) {
+				child.stdout.on('data', (data) => onOutput(data.toString()));
+				child.stderr.on('data', (data) => onOutput(data.toString()));
+			}
+		});
+	},
+
+	/**
+	 * @param   {String}    file
+	 * @param   {Array}     args
+	 * @param   {Object}    options     for child_process.execFile
+	 * @param   {Function}  [onOutput]  called with each chunk of stdout and stderr as it comes
+	 * @returns {Promise}
+	 */
+	execFile: (file, args, options, onOutput) => {
+		return new Promise((resolve, reject) => {
+			const child = childProcess.execFile(file, args, options, (err, stdout, stderr) => {
+				if (err) {
+					err.stderr = stderr;
+					reject(err);
+				} else {
+					resolve(stdout);
+				}
+			});
+
+			if (onOutput) {
+				child.stdout.on('data', (data) => onOutput(data.toString()));
+				child.stderr.on('data', (data) => onOutput(data.toString()));
+			}
+		});
+	}
+};
+
+let current = system;
+
+module.exports = {
+
+	system: system,
+
+	/**
+	 * @returns {Object}  the runner commands go through
+	 */
+	get: () => {
+		return current;
+	},
+
+	/**
+	 * @param {Object|null}  runner  null to go back to running them on the system
+	 */
+	use: (runner) => {
+		current = runner || system;
+	},
+
+	/**
+	 * A runner that doesn't run anything. Each command is answered by the first handler whose pattern
+	 * matches it, and what was run is kept in `calls`. Commands nothing matches fail as if they weren't found.
+	 *
+	 * @example
+	 *   const mock = runner.createMock([
+	 *       [/^\/usr\/sbin\/nginx -t/, ''],
+	 *       [/^certbot certonly/, {code: 1, stderr: 'Too many requests'}],
+	 *       [/^lego /, (cmd) => 'Server responded with a certificate.']
+	 *   ]);
+	 *   runner.use(mock);
+	 *
+	 * @param   {Array}  handlers  of [RegExp, answer], the answer being the stdout, {stdout, stderr, code}
+	 *                             or a function given the command that returns either
+	 * @returns {Object}
+	 */
+	createMock: (handlers) => {
+		const mock = {
+			calls: [],
+
+			exec: (cmd, options, onOutput) => {
+				mock.calls.push(cmd);
+
+				return Promise.resolve()
+					.then(() => {
+						const handler = handlers.find((item) => item[0].test(cmd));
+						let answer    = handler ? handler[1] : {code: 127, stderr: '/bin/sh: ' + cmd.split(' ')[0] + ': not found'};
+						if (typeof answer === 'function') {
+							answer = answer(cmd, options);
+						}
+						if (typeof answer === 'string') {
+							answer = {stdout: answer};
+						}
+						return answer;
+					})
+					.then((answer) => {
+						const stdout = answer.stdout || '';
+						const stderr = answer.stderr || '';
+
+						if (onOutput) {
+							stdout && onOutput(stdout);
+							stderr && onOutput(stderr);
+						}
+
+						if (answer.code) {
+							let err    = new Error('Command failed: ' + cmd + '\n' + stderr);
+							err.code   = answer.code;
+							err.stderr = stderr;
+							throw err;
+						}
+						return {stdout, stderr};
+					});
+			},
+
+			execFile: (file, args, options, onOutput) => {
+				return mock.exec([file].concat(args || []).join(' '), options, onOutput)
+					.then((result) => result.stdout);
+			}
+		};
+
+		return mock;
+	}
+};
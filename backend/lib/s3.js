@@ -0,0 +1,196 @@
+const http   = require('http');
+const https  = require('https');
+const crypto = require('crypto');
+
+// Requests to the bucket give up after this long, in ms
+const TIMEOUT = 30000;
+
+/**
+ * @param   {String|Buffer}  data
+ * @returns {String}
+ */
+const sha256 = (data) => {
+	return crypto.createHash('sha256').update(data).digest('hex');
+};
+
+/**
+ * @param   {Buffer|String}  key
+ * @param   {String}         data
+ * @returns {Buffer}
+ */
+const hmac = (key, data) => {
+	return crypto.createHmac('sha256', key).update(data).digest();
+};
+
+/**
+ * Encodes as S3 expects, which is stricter than encodeURIComponent
+ *
+ * @param   {String}  value
+ * @returns {String}
+ */
+const encode = (value) => {
+	return encodeURIComponent(value).replace(/[!'()*]/g, (c) => '%' + c.charCodeAt(0).toString(16).toUpperCase());
+};
+
+/**
+ * @param   {String}  xml
+ * @param   {String}  tag
+ * @returns {Array}   the contents of each of the tags, with the entities in them decoded
+ */
+const getTags = (xml, tag) => {
+	const entities = {'&quot;': '"', '&apos;': '\'', '&lt;': '<', '&gt;': '>', '&amp;': '&'};
+	const found    = xml.match(new RegExp('<' + tag + '>([\\s\\S]*?)</' + tag + '>', 'g')) || [];
+
+	return found.map((item) => {
+		return item.substring(tag.length + 2, item.length - tag.length - 3).replace(/&(quot|apos|lt|gt|amp);/g, (entity) => entities[entity]);
+	});
+};
+
+/**
+ * A client for the few S3 calls the certificate storage needs, signed with AWS Signature Version 4 and
+ * using path style urls so it works with MinIO, Ceph, R2 and the like as well as AWS.
+ *
+ * @param   {Object}  options
+ * @param   {String}  options.endpoint    ie: 'https://s3.eu-west-1.amazonaws.com'
+ * @param   {String}  options.region
+ * @param   {String}  options.bucket
+ * @param   {String}  options.access_key
+ * @param   {String}  options.secret_key
+ * @returns {Object}
+ */
+const createClient = (options) => {
+	const endpoint = new URL(options.endpoint);
+
+	/**
+	 * @param   {String}  method
+	 * @param   {String}  key     of the object, empty for the bucket
+	 * @param   {Object}  [query]
+	 * @param   {Buffer}  [body]
+	 * @returns {Promise}  resolves with {status, headers, body}
+	 */
+	const request = (method, key, query, body) => {
+		body = body || Buffer.alloc(0);
+
+		const now       = new Date().toISOString().replace(/[-:]/g, '').replace(/\.\d{3}/, '');
+		const date      = now.substring(0, 8);
+		const scope     = date + '/' + options.region + '/s3/aws4_request';
+		const path      = '/' + encode(options.bucket) + (key ? '/' + key.split('/').map(encode).join('/') : '');
+		const querystr  = Object.keys(query || {}).sort().map((name) => encode(name) + '=' + encode(query[name])).join('&');
+		const hash      = sha256(body);
+		const headers   = {
+			'host':                 endpoint.host,
+			'x-amz-content-sha256': hash,
+			'x-amz-date':           now
+		};
+		const signed    = Object.keys(headers).sort().join(';');
+		const canonical = [
+			method,
+			path,
+			querystr,
+			Object.keys(headers).sort().map((name) => name + ':' + headers[name] + '\n').join(''),
+			signed,
+			hash
+		].join('\n');
+
+		const signingKey = hmac(hmac(hmac(hmac('AWS4' + options.secret_key, date), options.region), 's3'), 'aws4_request');
+		const signature  = hmac(signingKey, ['AWS4-HMAC-SHA256', now, scope, sha256(canonical)].join('\n')).toString('hex');
+
+		headers['authorization']  = 'AWS4-HMAC-SHA256 Credential=' + options.access_key + '/' + scope + ', SignedHeaders=' + signed + ', Signature=' + signature;
+		headers['content-length'] = body.length;
+
+		return new Promise((resolve, reject) => {
+			const req = (endpoint.protocol === 'http:' ? http : https).request({
+				method:   method,
+				hostname: endpoint.hostname,
+				port:     endpoint.port || undefined,
+				path:     path + (querystr ? '?' + querystr : ''),
+				headers:  headers,
+				timeout:  TIMEOUT
+			}, (res) => {
+				let chunks = [];
+				res.on('data', (chunk) => chunks.push(chunk));
+				res.on('end', () => {
+					const result = {status: res.statusCode, headers: res.headers, body: Buffer.concat(chunks)};
+					if (res.statusCode >= 300 && !(res.statusCode === 404 && method !== 'PUT')) {
+						const message = getTags(result.body.toString(), 'Message')[0] || 'HTTP ' + res.statusCode;
+						reject(new Error('S3 ' + method + ' ' + (key || options.bucket) + ' failed: ' + message));
+						return;
+					}
+					resolve(result);
+				});
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('S3 ' + method + ' ' + (key || options.bucket) + ' timed out'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		});
+	};
+
+	return {
+
+		/**
+		 * @param   {String}         key
+		 * @param   {Buffer|String}  body
+		 * @returns {Promise}
+		 */
+		put: (key, body) => {
+			return request('PUT', key, null, Buffer.from(body));
+		},
+
+		/**
+		 * @param   {String}  key
+		 * @returns {Promise}  resolves with the contents, or null when there's no such object
+		 */
+		get: (key) => {
+			return request('GET', key)
+				.then((result) => {
+					return result.status === 404 ? null : result.body;
+				});
+		},
+
+		/**
+		 * @param   {String}  key
+		 * @returns {Promise}
+		 */
+		delete: (key) => {
+			return request('DELETE', key);
+		},
+
+		/**
+		 * @param   {String}  prefix
+		 * @returns {Promise}  resolves with each object, ie: {key: 'npm/certificates/npm-1/fullchain.pem', etag: '...'}
+		 */
+		list: (prefix) => {
+			let objects = [];
+
+			const next = (token) => {
+				let query = {'list-type': '2', 'prefix': prefix};
+				if (token) {
+					query['continuation-token'] = token;
+				}
+
+				return request('GET', '', query)
+					.then((result) => {
+						const xml = result.body.toString();
+						getTags(xml, 'Contents').forEach((item) => {
+							objects.push({
+								key:  getTags(item, 'Key')[0],
+								etag: (getTags(item, 'ETag')[0] || '').replace(/"/g, '')
+							});
+						});
+
+						const token = getTags(xml, 'NextContinuationToken')[0];
+						return token ? next(token) : objects;
+					});
+			};
+
+			return next(null);
+		}
+	};
+};
+
+module.exports = {
+	createClient: createClient
+};
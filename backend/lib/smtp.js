@@ -0,0 +1,315 @@
+const net    = require('net');
+const tls    = require('tls');
+const os     = require('os');
+const crypto = require('crypto');
+
+// A mail server that doesn't answer for this long, in ms, is given up on
+const TIMEOUT = 30000;
+
+// The port of each way of securing the connection, when the channel doesn't have one
+const PORTS = {
+	tls:      465,
+	starttls: 587,
+	none:     25
+};
+
+/**
+ * @param   {String}  address  ie: "Nginx Proxy Manager <npm@example.com>"
+ * @returns {String}  npm@example.com
+ */
+const getAddress = (address) => {
+	const match = /<([^>]+)>/.exec(address);
+	return (match ? match[1] : address).trim();
+};
+
+/**
+ * A header value with anything that isn't ASCII in it, as RFC 2047 wants it
+ *
+ * @param   {String}  value
+ * @returns {String}
+ */
+const encodeHeader = (value) => {
+	// eslint-disable-next-line no-control-regex
+	return /^[\x20-\x7e]*$/.test(value) ? value : '=?UTF-8?B?' + Buffer.from(value, 'utf8').toString('base64') + '?=';
+};
+
+/**
+ * Reads the replies of the server off a socket, one at a time. A reply can be more than one line,
+ * the last of them having a space after the code instead of a dash.
+ *
+ * @param   {Socket}  socket
+ * @returns {Object}
+ */
+const reader = (socket) => {
+	let buffer  = '';
+	let lines   = [];
+	let replies = [];
+	let waiting = null;
+	let failure = null;
+
+	const flush = () => {
+		if (!waiting || (!failure && !replies.length)) {
+			return;
+		}
+
+		const callbacks = waiting;
+		waiting         = null;
+
+		if (replies.length) {
+			callbacks.resolve(replies.shift());
+		} else {
+			callbacks.reject(failure);
+		}
+	};
+
+	const onData = (chunk) => {
+		buffer += chunk;
+
+		let index;
+		while ((index = buffer.indexOf('\n')) !== -1) {
+			const line = buffer.slice(0, index).replace(/\r$/, '');
+			buffer     = buffer.slice(index + 1);
+
+			lines.push(line.slice(4));
+			if (line.charAt(3) !== '-') {
+				replies.push({code: parseInt(line.slice(0, 3), 10), text: lines.join('\n')});
+				lines = [];
+			}
+		}
+		flush();
+	};
+
+	const onError = (err) => {
+		failure = err;
+		flush();
+	};
+
+	const onClose = () => {
+		failure = failure || new Error('The mail server closed the connection');
+		flush();
+	};
+
+	socket.on('data', onData);
+	socket.on('error', onError);
+	socket.on('close', onClose);
+
+	return {
+		read: () => {
+			return new Promise((resolve, reject) => {
+				waiting = {resolve: resolve, reject: reject};
+				flush();
+			});
+		},
+
+		// Before the socket is handed to TLS for STARTTLS
+		detach: () => {
+			socket.removeListener('data', onData);
+			socket.removeListener('error', onError);
+			socket.removeListener('close', onClose);
+		}
+	};
+};
+
+/**
+ * @param   {Socket}  socket
+ * @param   {String}  host
+ */
+const setIdleTimeout = (socket, host) => {
+	socket.setTimeout(TIMEOUT, () => {
+		socket.destroy(new Error('The mail server ' + host + ' didn\'t answer within ' + (TIMEOUT / 1000) + ' seconds'));
+	});
+};
+
+/**
+ * @param   {Object}  options
+ * @returns {Promise}  resolves with the socket once it's connected, and encrypted when it's to be from the start
+ */
+const connect = (options) => {
+	return new Promise((resolve, reject) => {
+		const port   = options.port || PORTS[options.security];
+		const secure = options.security === 'tls';
+		const socket = secure
+			? tls.connect({host: options.host, port: port, servername: net.isIP(options.host) ? undefined : options.host})
+			: net.connect({host: options.host, port: port});
+
+		setIdleTimeout(socket, options.host);
+		socket.setEncoding('utf8');
+		socket.once('error', reject);
+		socket.once(secure ? 'secureConnect' : 'connect', () => {
+			socket.removeListener('error', reject);
+			resolve(socket);
+		});
+	});
+};
+
+/**
+ * @param   {Socket}  socket
+ * @param   {String}  host
+ * @returns {Promise}  resolves with the socket encrypted
+ */
+const upgrade = (socket, host) => {
+	return new Promise((resolve, reject) => {
+		// The encrypted socket keeps its own time from here on
+		socket.setTimeout(0);
+
+		const secured = tls.connect({socket: socket, servername: net.isIP(host) ? undefined : host});
+
+		setIdleTimeout(secured, host);
+		secured.setEncoding('utf8');
+		secured.once('error', reject);
+		secured.once('secureConnect', () => {
+			secured.removeListener('error', reject);
+			resolve(secured);
+		});
+	});
+};
+
+/**
+ * @param   {Object}  options
+ * @returns {String}  the message, headers and all, for after DATA
+ */
+const getMessage = (options) => {
+	const headers = [
+		'From: ' + options.from,
+		'To: ' + options.to.join(', '),
+		'Subject: ' + encodeHeader(options.subject),
+		'Date: ' + new Date().toUTCString().replace('GMT', '+0000'),
+		'Message-ID: <' + crypto.randomBytes(12).toString('hex') + '@' + os.hostname() + '>',
+		'MIME-Version: 1.0',
+		'Content-Type: text/plain; charset=utf-8',
+		// Base64 keeps the lines short, and none of them can be the dot that ends the message
+		'Content-Transfer-Encoding: base64'
+	];
+
+	const body = Buffer.from(options.text.replace(/\r?\n/g, '\r\n'), 'utf8').toString('base64').replace(/.{76}/g, '$&\r\n');
+	return headers.join('\r\n') + '\r\n\r\n' + body;
+};
+
+module.exports = {
+
+	PORTS: PORTS,
+
+	getAddress: getAddress,
+
+	/**
+	 * Sends a plain text email. Just enough of SMTP for a relay or the submission port of a mail provider,
+	 * which is all notifications need.
+	 *
+	 * @param   {Object}  options
+	 * @param   {String}  options.host
+	 * @param   {Number}  [options.port]      from the security when there isn't one
+	 * @param   {String}  options.security    tls, starttls or none
+	 * @param   {String}  [options.username]
+	 * @param   {String}  [options.password]
+	 * @param   {String}  options.from
+	 * @param   {Array}   options.to
+	 * @param   {String}  options.subject
+	 * @param   {String}  options.text
+	 * @returns {Promise}
+	 */
+	send: (options) => {
+		const hostname = os.hostname();
+
+		let socket       = null;
+		let conversation = null;
+
+		/**
+		 * @param   {String|null}  line      nothing to only read, ie: the greeting
+		 * @param   {Array}        expected  the codes that mean it went well
+		 * @param   {String}       [name]    what was sent, for the error, the command when there isn't one
+		 * @returns {Promise}      resolves with the reply
+		 */
+		const command = (line, expected, name) => {
+			if (line !== null) {
+				socket.write(line + '\r\n');
+			}
+
+			return conversation.read()
+				.then((reply) => {
+					if (expected.indexOf(reply.code) === -1) {
+						// Only the command, as the line of AUTH has the password in it
+						throw new Error('The mail server answered ' + (name || (line ? line.split(' ')[0] : 'the connection')) + ' with ' + reply.code + ' ' + reply.text.replace(/\n/g, ' '));
+					}
+					return reply;
+				});
+		};
+
+		return connect(options)
+			.then((connected) => {
+				socket       = connected;
+				conversation = reader(socket);
+				return command(null, [220]);
+			})
+			.then(() => {
+				return command('EHLO ' + hostname, [250]);
+			})
+			.then((reply) => {
+				if (options.security !== 'starttls') {
+					return reply;
+				}
+
+				if (!/^STARTTLS\b/im.test(reply.text)) {
+					throw new Error('The mail server ' + options.host + ' doesn\'t offer STARTTLS');
+				}
+
+				return command('STARTTLS', [220])
+					.then(() => {
+						conversation.detach();
+						return upgrade(socket, options.host);
+					})
+					.then((secured) => {
+						socket       = secured;
+						conversation = reader(socket);
+						return command('EHLO ' + hostname, [250]);
+					});
+			})
+			.then((reply) => {
+				if (!options.username) {
+					return;
+				}
+
+				// PLAIN unless the server only has LOGIN
+				const mechanisms = (/^AUTH[ =](.*)$/im.exec(reply.text) || ['', ''])[1].toUpperCase().split(/\s+/);
+				if (mechanisms.indexOf('PLAIN') === -1 && mechanisms.indexOf('LOGIN') !== -1) {
+					return command('AUTH LOGIN', [334])
+						.then(() => {
+							return command(Buffer.from(options.username, 'utf8').toString('base64'), [334], 'the username');
+						})
+						.then(() => {
+							return command(Buffer.from(options.password || '', 'utf8').toString('base64'), [235], 'the password');
+						});
+				}
+
+				return command('AUTH PLAIN ' + Buffer.from('\0' + options.username + '\0' + (options.password || ''), 'utf8').toString('base64'), [235]);
+			})
+			.then(() => {
+				return command('MAIL FROM:<' + getAddress(options.from) + '>', [250]);
+			})
+			.then(() => {
+				let sequence = Promise.resolve();
+
+				options.to.forEach((address) => {
+					sequence = sequence.then(() => {
+						return command('RCPT TO:<' + getAddress(address) + '>', [250, 251]);
+					});
+				});
+
+				return sequence;
+			})
+			.then(() => {
+				return command('DATA', [354]);
+			})
+			.then(() => {
+				return command(getMessage(options) + '\r\n.', [250], 'the message');
+			})
+			.then(() => {
+				socket.end('QUIT\r\n');
+			})
+			.catch((err) => {
+				if (socket) {
+					socket.destroy();
+				}
+				throw err;
+			});
+	}
+};
@@ -0,0 +1,152 @@
+const archiver = require('archiver');
+
+/**
+ * Cells starting with these are run as a formula by spreadsheet apps, so a domain or note
+ * someone typed can't run one on whoever opens the export
+ */
+const FORMULA = /^[=+\-@\t\r]/;
+
+/**
+ * @param   {*}  value
+ * @returns {String}
+ */
+const toText = (value) => {
+	if (value === null || typeof value === 'undefined') {
+		return '';
+	}
+	if (Array.isArray(value)) {
+		return value.join(', ');
+	}
+	if (value instanceof Date) {
+		return value.toISOString();
+	}
+	return String(value);
+};
+
+/**
+ * @param   {String}  text
+ * @returns {String}
+ */
+const escapeXml = (text) => {
+	return text
+		.replace(/&/g, '&amp;')
+		.replace(/</g, '&lt;')
+		.replace(/>/g, '&gt;')
+		.replace(/"/g, '&quot;')
+		// Control characters aren't allowed in XML at all
+		.replace(/[\x00-\x08\x0B\x0C\x0E-\x1F]/g, ''); // eslint-disable-line no-control-regex
+};
+
+/**
+ * @param   {Number}  index  from 0
+ * @returns {String}  the letters of the column, ie: AA
+ */
+const getColumnName = (index) => {
+	let name = '';
+	for (index++; index > 0; index = Math.floor((index - 1) / 26)) {
+		name = String.fromCharCode(65 + ((index - 1) % 26)) + name;
+	}
+	return name;
+};
+
+const spreadsheet = {
+
+	/**
+	 * @param   {*}  value
+	 * @returns {String}  the text of a CSV cell, with a quote in front of what would be a formula
+	 */
+	getCell: (value) => {
+		const text = toText(value);
+		return typeof value !== 'number' && FORMULA.test(text) ? '\'' + text : text;
+	},
+
+	/**
+	 * RFC 4180 CSV, with a BOM so Excel reads it as UTF-8
+	 *
+	 * @param   {Array}  columns  ie: [{name: 'domain_names', title: 'Domains'}]
+	 * @param   {Array}  rows     objects with a value for each column name
+	 * @returns {String}
+	 */
+	toCsv: (columns, rows) => {
+		const line = (values) => {
+			return values.map((value) => {
+				const text = spreadsheet.getCell(value);
+				return /[",\r\n]/.test(text) ? '"' + text.replace(/"/g, '""') + '"' : text;
+			}).join(',');
+		};
+
+		return '\ufeff' + [line(columns.map((column) => column.title))]
+			.concat(rows.map((row) => line(columns.map((column) => row[column.name]))))
+			.join('\r\n') + '\r\n';
+	},
+
+	/**
+	 * An Office Open XML workbook with one sheet, written without a spreadsheet library.
+	 * Text is kept inline in the cells, so there's no shared strings table to build.
+	 *
+	 * @param   {String}  sheet_name
+	 * @param   {Array}   columns  ie: [{name: 'domain_names', title: 'Domains'}]
+	 * @param   {Array}   rows
+	 * @returns {Promise} resolves with a Buffer of the .xlsx file
+	 */
+	toXlsx: (sheet_name, columns, rows) => {
+		const cell = (value, ref) => {
+			if (typeof value === 'number' && isFinite(value)) {
+				return '<c r="' + ref + '"><v>' + value + '</v></c>';
+			}
+			// An inline string is only ever text, so what would be a formula in a CSV is kept as it is
+			return '<c r="' + ref + '" t="inlineStr"><is><t xml:space="preserve">' + escapeXml(toText(value)) + '</t></is></c>';
+		};
+
+		const sheet_rows = [columns.map((column) => column.title)]
+			.concat(rows.map((row) => columns.map((column) => row[column.name])))
+			.map((values, row_index) => {
+				return '<row r="' + (row_index + 1) + '">' + values.map((value, column_index) => cell(value, getColumnName(column_index) + (row_index + 1))).join('') + '</row>';
+			});
+
+		const files = {
+			'[Content_Types].xml': '<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\n' +
+				'<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">' +
+				'<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>' +
+				'<Default Extension="xml" ContentType="application/xml"/>' +
+				'<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>' +
+				'<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>' +
+				'</Types>',
+			'_rels/.rels': '<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\n' +
+				'<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">' +
+				'<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>' +
+				'</Relationships>',
+			'xl/workbook.xml': '<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\n' +
+				'<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">' +
+				'<sheets><sheet name="' + escapeXml(sheet_name.substring(0, 31)) + '" sheetId="1" r:id="rId1"/></sheets>' +
+				'</workbook>',
+			'xl/_rels/workbook.xml.rels': '<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\n' +
+				'<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">' +
+				'<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>' +
+				'</Relationships>',
+			'xl/worksheets/sheet1.xml': '<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\n' +
+				'<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">' +
+				// The header row stays in view when scrolling
+				'<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>' +
+				'<sheetData>' + sheet_rows.join('') + '</sheetData>' +
+				'</worksheet>'
+		};
+
+		return new Promise((resolve, reject) => {
+			const archive = archiver('zip', {zlib: {level: 9}});
+			let chunks    = [];
+
+			archive.on('data', (chunk) => chunks.push(chunk));
+			archive.on('end', () => resolve(Buffer.concat(chunks)));
+			archive.on('error', reject);
+
+			Object.keys(files).forEach((name) => {
+				archive.append(files[name], {name: name});
+			});
+
+			archive.finalize();
+		});
+	}
+};
+
+module.exports = spreadsheet;
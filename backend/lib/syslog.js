@@ -0,0 +1,122 @@
+const dgram      = require('dgram');
+const net        = require('net');
+const os         = require('os');
+const {Writable} = require('stream');
+
+// local0
+const FACILITY = 16;
+
+const SEVERITIES = {
+	error: 3,
+	warn:  4,
+	info:  6,
+	debug: 7
+};
+
+module.exports = {
+
+	/**
+	 * An RFC 5424 message
+	 *
+	 * @param   {String}  app_name
+	 * @param   {String}  severity  ie: 'info', 'error'
+	 * @param   {String}  message
+	 * @returns {String}
+	 */
+	format: (app_name, severity, message) => {
+		const pri = FACILITY * 8 + (typeof SEVERITIES[severity] !== 'undefined' ? SEVERITIES[severity] : SEVERITIES.info);
+		return '<' + pri + '>1 ' + new Date().toISOString() + ' ' + os.hostname() + ' ' + app_name + ' - - - ' + message;
+	},
+
+	/**
+	 * Sends messages to a syslog server, one datagram each over UDP,
+	 * or newline separated over a single TCP connection.
+	 *
+	 * @param   {Object}  server
+	 * @param   {String}  server.host
+	 * @param   {Number}  server.port
+	 * @param   {String}  [server.protocol]  'udp' or 'tcp'
+	 * @param   {Array}   messages           already formatted
+	 * @returns {Promise}
+	 */
+	send: (server, messages) => {
+		if (!messages.length) {
+			return Promise.resolve();
+		}
+
+		return new Promise((resolve, reject) => {
+			if (server.protocol === 'tcp') {
+				const socket = net.createConnection({host: server.host, port: server.port}, () => {
+					socket.end(messages.join('\n') + '\n');
+				});
+				socket.setTimeout(10000, () => {
+					socket.destroy(new Error('Timed out sending to syslog at ' + server.host + ':' + server.port));
+				});
+				socket.on('error', reject);
+				socket.on('close', (had_error) => {
+					if (!had_error) {
+						resolve();
+					}
+				});
+				return;
+			}
+
+			const socket = dgram.createSocket(net.isIPv6(server.host) ? 'udp6' : 'udp4');
+			let sequence = Promise.resolve();
+
+			messages.forEach((message) => {
+				sequence = sequence.then(() => {
+					return new Promise((sent, failed) => {
+						socket.send(message, server.port, server.host, (err) => {
+							if (err) {
+								failed(err);
+							} else {
+								sent();
+							}
+						});
+					});
+				});
+			});
+
+			sequence
+				.then(resolve, reject)
+				.then(() => {
+					socket.close();
+				});
+		});
+	},
+
+	/**
+	 * A stream that sends each line written to it to a syslog server, in order.
+	 * Failures are dropped, as there's nowhere left to log them.
+	 *
+	 * @param   {Object}  server    see send()
+	 * @param   {String}  app_name
+	 * @returns {Writable}
+	 */
+	createStream: (server, app_name) => {
+		const self   = module.exports;
+		let sequence = Promise.resolve();
+
+		return new Writable({
+			write: (chunk, encoding, callback) => {
+				// eslint-disable-next-line no-control-regex
+				const messages = chunk.toString().replace(/\u001b\[[0-9;]*m/g, '')
+					.split('\n')
+					.filter((line) => line.trim().length)
+					.map((line) => {
+						const severity = /\berror\b/i.test(line) ? 'error' : (/\bwarn(ing)?\b/i.test(line) ? 'warn' : 'info');
+						return self.format(app_name, severity, line);
+					});
+
+				sequence = sequence
+					.then(() => {
+						return self.send(server, messages);
+					})
+					.catch(() => {});
+
+				callback();
+			}
+		});
+	}
+};
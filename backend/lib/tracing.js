@@ -0,0 +1,342 @@
+const http                  = require('http');
+const https                 = require('https');
+const crypto                = require('crypto');
+const os                    = require('os');
+const { AsyncLocalStorage } = require('async_hooks');
+const config                = require('./config');
+const logger                = require('../logger').global;
+
+const HTTP_TIMEOUT = 10000;
+
+// Ended spans are sent this often, or sooner when this many are waiting
+const FLUSH_INTERVAL = 5000;
+const FLUSH_SIZE     = 512;
+
+// Spans past this many waiting are dropped, when the collector can't keep up
+const MAX_QUEUED = 4096;
+
+// Long queries are cut short, ie: inserts of certificate files
+const MAX_STATEMENT_LENGTH = 500;
+
+// OTLP span kinds and status codes
+const KIND   = {internal: 1, server: 2, client: 3};
+const STATUS = {ok: 1, error: 2};
+
+// The span the running code is part of, followed through every promise and callback
+const storage = new AsyncLocalStorage();
+
+let queue   = [];
+let timer   = null;
+let queries = {};
+
+/**
+ * @param   {Object}  attributes
+ * @returns {Array}   in the OTLP format
+ */
+const toAttributes = (attributes) => {
+	return Object.keys(attributes)
+		.filter((key) => attributes[key] !== null && typeof attributes[key] !== 'undefined')
+		.map((key) => {
+			const value = attributes[key];
+			if (typeof value === 'number' && Number.isInteger(value)) {
+				return {key: key, value: {intValue: String(value)}};
+			}
+			if (typeof value === 'number') {
+				return {key: key, value: {doubleValue: value}};
+			}
+			if (typeof value === 'boolean') {
+				return {key: key, value: {boolValue: value}};
+			}
+			return {key: key, value: {stringValue: String(value)}};
+		});
+};
+
+// The monotonic clock is read for spans, starting from the time of day when the backend started
+const epoch = BigInt(Date.now()) * 1000000n - process.hrtime.bigint();
+
+/**
+ * @returns {String}  nanoseconds since the epoch, too big for a number
+ */
+const now = () => {
+	return (epoch + process.hrtime.bigint()).toString();
+};
+
+/**
+ * @param {Object}  span
+ */
+const enqueue = (span) => {
+	if (queue.length >= MAX_QUEUED) {
+		return;
+	}
+	queue.push(span);
+
+	if (queue.length >= FLUSH_SIZE) {
+		tracing.flush();
+	} else if (timer === null) {
+		timer = setTimeout(tracing.flush, FLUSH_INTERVAL);
+		timer.unref();
+	}
+};
+
+/**
+ * A span that records nothing, for when tracing is off
+ */
+const noopSpan = {
+	sampled:       false,
+	setName:       () => {},
+	setAttributes: () => {},
+	addEvent:      () => {},
+	end:           () => {}
+};
+
+const tracing = {
+
+	KIND: KIND,
+
+	/**
+	 * @returns {Boolean}  whether there's a collector to send spans to
+	 */
+	enabled: () => {
+		return config.getSetting('tracing').endpoint !== null;
+	},
+
+	/**
+	 * Starts a span, as a child of the one the code is running in, or of the remote parent that's given
+	 *
+	 * @param   {String}  name
+	 * @param   {Object}  [attributes]
+	 * @param   {Object}  [options]
+	 * @param   {Number}  [options.kind]    one of KIND, internal by default
+	 * @param   {String}  [options.parent]  a W3C traceparent header, for a span started by a request
+	 * @returns {Object}
+	 */
+	startSpan: (name, attributes, options) => {
+		options = options || {};
+		if (!tracing.enabled()) {
+			return noopSpan;
+		}
+
+		let parent = storage.getStore();
+		if (!parent && options.parent) {
+			const matches = options.parent.match(/^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$/);
+			if (matches !== null) {
+				parent = {
+					trace_id: matches[1],
+					span_id:  matches[2],
+					sampled:  (parseInt(matches[3], 16) & 1) === 1
+				};
+			}
+		}
+
+		// Whether a trace is kept is decided once, at its start
+		const sampled = parent ? parent.sampled : Math.random() < config.getSetting('tracing').sample_ratio;
+		if (!sampled) {
+			// Still run in, so the spans under it aren't sampled either
+			return Object.assign({}, noopSpan, {
+				trace_id: parent ? parent.trace_id : null,
+				span_id:  parent ? parent.span_id : null
+			});
+		}
+
+		let data = {
+			traceId:           parent ? parent.trace_id : crypto.randomBytes(16).toString('hex'),
+			spanId:            crypto.randomBytes(8).toString('hex'),
+			parentSpanId:      parent ? parent.span_id : undefined,
+			name:              name,
+			kind:              options.kind || KIND.internal,
+			startTimeUnixNano: now(),
+			attributes:        attributes || {},
+			events:            []
+		};
+
+		let ended = false;
+
+		return {
+			sampled:  true,
+			trace_id: data.traceId,
+			span_id:  data.spanId,
+
+			/**
+			 * @param {String}  new_name
+			 */
+			setName: (new_name) => {
+				data.name = new_name;
+			},
+
+			/**
+			 * @param {Object}  more
+			 */
+			setAttributes: (more) => {
+				Object.assign(data.attributes, more);
+			},
+
+			/**
+			 * @param {String}  event_name  ie: a phase of issuing a certificate
+			 * @param {Object}  [event_attributes]
+			 */
+			addEvent: (event_name, event_attributes) => {
+				data.events.push({
+					timeUnixNano: now(),
+					name:         event_name,
+					attributes:   toAttributes(event_attributes || {})
+				});
+			},
+
+			/**
+			 * @param {Error}  [err]  when what the span covers failed
+			 */
+			end: (err) => {
+				if (ended) {
+					return;
+				}
+				ended = true;
+
+				data.endTimeUnixNano = now();
+				data.attributes      = toAttributes(data.attributes);
+				data.status          = err ? {code: STATUS.error, message: err.message} : {code: STATUS.ok};
+				enqueue(data);
+			}
+		};
+	},
+
+	/**
+	 * Runs the function, and everything it starts, as part of the span
+	 *
+	 * @param   {Object}    span
+	 * @param   {Function}  fn
+	 * @returns {*}
+	 */
+	run: (span, fn) => {
+		if (span === noopSpan) {
+			return fn();
+		}
+		return storage.run(span, fn);
+	},
+
+	/**
+	 * Runs the function in a span that ends when the promise it returns settles
+	 *
+	 * @param   {String}    name
+	 * @param   {Object}    attributes
+	 * @param   {Function}  fn          called with the span, returning a promise
+	 * @returns {Promise}
+	 */
+	trace: (name, attributes, fn) => {
+		const span = tracing.startSpan(name, attributes);
+
+		return tracing.run(span, () => {
+			return Promise.resolve()
+				.then(() => {
+					return fn(span);
+				})
+				.then((result) => {
+					span.end();
+					return result;
+				}, (err) => {
+					span.end(err);
+					throw err;
+				});
+		});
+	},
+
+	/**
+	 * Times every query of a knex instance, in the span that ran it
+	 *
+	 * @param {Object} db
+	 */
+	attachDb: (db) => {
+		db.on('query', (query) => {
+			if (!storage.getStore()) {
+				return;
+			}
+
+			// Bindings are left out, they can be passwords and keys
+			queries[query.__knexQueryUid] = tracing.startSpan(query.method || 'query', {
+				'db.system':    db.client.config.client,
+				'db.statement': query.sql.length > MAX_STATEMENT_LENGTH ? query.sql.substring(0, MAX_STATEMENT_LENGTH) + '...' : query.sql
+			}, {kind: KIND.client});
+		});
+
+		const finish = (query, err) => {
+			const span = queries[query.__knexQueryUid];
+			if (typeof span !== 'undefined') {
+				delete queries[query.__knexQueryUid];
+				span.end(err);
+			}
+		};
+
+		db.on('query-response', (_, query) => {
+			finish(query);
+		});
+		db.on('query-error', (err, query) => {
+			finish(query, err);
+		});
+	},
+
+	/**
+	 * Sends the spans that have ended to the collector
+	 *
+	 * @returns {Promise}
+	 */
+	flush: () => {
+		if (timer !== null) {
+			clearTimeout(timer);
+			timer = null;
+		}
+
+		const setting = config.getSetting('tracing');
+		const spans   = queue;
+		queue         = [];
+
+		if (!spans.length || setting.endpoint === null) {
+			return Promise.resolve();
+		}
+
+		const body = JSON.stringify({
+			resourceSpans: [{
+				resource: {
+					attributes: toAttributes({
+						'service.name': 'nginx-proxy-manager',
+						'host.name':    os.hostname()
+					})
+				},
+				scopeSpans: [{
+					scope: {name: 'nginx-proxy-manager'},
+					spans: spans
+				}]
+			}]
+		});
+
+		const url = setting.endpoint.replace(/\/+$/, '').replace(/\/v1\/traces$/, '') + '/v1/traces';
+
+		return new Promise((resolve, reject) => {
+			const req = (url.indexOf('https:') === 0 ? https : http).request(url, {
+				method:  'POST',
+				timeout: HTTP_TIMEOUT,
+				headers: Object.assign({}, setting.headers, {
+					'Content-Type':   'application/json',
+					'Content-Length': Buffer.byteLength(body),
+					'User-Agent':     'nginx-proxy-manager'
+				})
+			}, (res) => {
+				res.resume();
+				if (res.statusCode < 200 || res.statusCode >= 300) {
+					reject(new Error(url + ' answered ' + res.statusCode));
+					return;
+				}
+				resolve();
+			});
+
+			req.on('timeout', () => {
+				req.destroy(new Error('There was no answer within ' + (HTTP_TIMEOUT / 1000) + ' seconds'));
+			});
+			req.on('error', reject);
+			req.end(body);
+		})
+			.catch((err) => {
+				logger.warn('Could not send ' + spans.length + ' spans: ' + err.message);
+			});
+	}
+};
+
+module.exports = tracing;
@@ -1,48 +1,93 @@
-const _          = require('lodash');
-const exec       = require('child_process').exec;
-const execFile   = require('child_process').execFile;
-const { Liquid } = require('liquidjs');
-const logger     = require('../logger').global;
-const error      = require('./error');
+const _              = require('lodash');
+const path           = require('path');
+const { Liquid }     = require('liquidjs');
+const logger         = require('../logger').global;
+const error          = require('./error');
+const config         = require('./config');
+const requestContext = require('./request-context');
+const runner         = require('./runner');
+const tracing        = require('./tracing');
+
+/**
+ * The signal a command is killed with, that of the request it's run for unless it's given
+ *
+ * @param   {AbortSignal|null}  [signal]  null for a command that must finish once it's started
+ * @returns {AbortSignal|undefined}
+ */
+const getSignal = (signal) => {
+	if (signal === null) {
+		return undefined;
+	}
+	return signal || requestContext.signal() || undefined;
+};
+
+/**
+ * Runs a command in a span named after the program, its arguments are left out as they can be secrets
+ *
+ * @param   {String}    cmd  ie: '/usr/sbin/nginx -s reload' or 'certbot'
+ * @param   {Function}  fn   returning the promise of the command
+ * @returns {Promise}
+ */
+const traceCommand = (cmd, fn) => {
+	const program = path.basename(cmd.trim().split(/\s+/)[0]);
+	return tracing.trace('exec ' + program, {'process.executable.name': program}, fn);
+};
 
 module.exports = {
 
+	/**
+	 * @param   {String}            cmd
+	 * @param   {Object}            [options]           for child_process.exec
+	 * @param   {Function}          [options.onOutput]  called with each chunk of stdout and stderr as it comes
+	 * @param   {AbortSignal|null}  [options.signal]    kills the command, the request's by default
+	 * @returns {Promise}
+	 */
 	exec: async function(cmd, options = {}) {
 		logger.debug('CMD:', cmd);
+		if (options.signal !== null) {
+			requestContext.assertActive();
+		}
 
-		const { stdout, stderr } = await new Promise((resolve, reject) => {
-			const child = exec(cmd, options, (isError, stdout, stderr) => {
-				if (isError) {
-					reject(new error.CommandError(stderr, isError));
-				} else {
-					resolve({ stdout, stderr });
-				}
-			});
+		const onOutput = options.onOutput;
+		options        = _.assign(_.omit(options, ['onOutput', 'signal']), {signal: getSignal(options.signal)});
 
-			child.on('error', (e) => {
-				reject(new error.CommandError(stderr, 1, e));
-			});
-		});
+		const { stdout } = await traceCommand(cmd, () => runner.get().exec(cmd, options, onOutput)
+			.catch((err) => {
+				if (err.name === 'AbortError') {
+					throw new error.CancelledError(options.signal.reason, err);
+				}
+				if (typeof err.stderr === 'string') {
+					throw new error.CommandError(err.stderr, err);
+				}
+				// It couldn't be started
+				throw new error.CommandError(err.message, 1, err);
+			}));
 		return stdout;
 	},
 
 	/**
-	 * @param   {String} cmd
-	 * @param   {Array}  args
+	 * Runs a program without a shell, so the arguments don't need quoting
+	 *
+	 * @param   {String}    cmd
+	 * @param   {Array}     args
+	 * @param   {Object}            [options]
+	 * @param   {Object}            [options.env]       for the program, instead of the backend's
+	 * @param   {Function}          [options.onOutput]  called with each chunk of stdout and stderr as it comes
+	 * @param   {AbortSignal|null}  [options.signal]    kills the program, the request's by default
 	 * @returns {Promise}
 	 */
-	execFile: function (cmd, args) {
+	execFile: function (cmd, args, options = {}) {
 		// logger.debug('CMD: ' + cmd + ' ' + (args ? args.join(' ') : ''));
+		const signal = getSignal(options.signal);
 
-		return new Promise((resolve, reject) => {
-			execFile(cmd, args, function (err, stdout, /*stderr*/) {
-				if (err && typeof err === 'object') {
-					reject(err);
-				} else {
-					resolve(stdout.trim());
+		return traceCommand(cmd, () => runner.get().execFile(cmd, args, _.assign(_.omit(options, ['onOutput', 'signal']), {signal: signal}), options.onOutput)
+			.then((stdout) => stdout.trim())
+			.catch((err) => {
+				if (err.name === 'AbortError') {
+					throw new error.CancelledError(signal.reason, err);
 				}
-			});
-		});
+				throw err;
+			}));
 	},
 
 	/**
@@ -61,6 +106,49 @@ module.exports = {
 		};
 	},
 
+	/**
+	 * Fetches the rows of a query a batch at a time, so they aren't all in memory at once.
+	 * The ids are read first and each batch is fetched by id, so rows added or moved in the order
+	 * while it's going on can't be sent twice or push another row out of its batch.
+	 *
+	 * @param   {Object}    query  objection query builder
+	 * @param   {Function}  each   given each batch of rows, can return a Promise to wait for
+	 * @param   {Number}    [size]
+	 * @returns {Promise}
+	 */
+	eachBatch: function (query, each, size) {
+		size = size || 500;
+
+		const id = query.modelClass().tableName + '.id';
+
+		return query
+			.clone()
+			.clearWithGraph()
+			.clearSelect()
+			.select(id)
+			.orderBy(id, 'ASC')
+			.then((rows) => {
+				const batches = _.chunk(_.map(rows, 'id'), size);
+
+				let sequence = Promise.resolve();
+				batches.forEach((ids) => {
+					sequence = sequence
+						.then(() => {
+							return query
+								.clone()
+								.whereIn(id, ids);
+						})
+						.then((batch) => {
+							// In the order of the ids, leaving out any deleted since
+							const by_id = _.keyBy(batch, 'id');
+							return each(_.compact(ids.map((row_id) => by_id[row_id])));
+						});
+				});
+
+				return sequence;
+			});
+	},
+
 	/**
 	 * Used in objection query builder
 	 *
@@ -85,7 +173,9 @@ module.exports = {
 	 */
 	getRenderEngine: function () {
 		const renderEngine = new Liquid({
-			root: __dirname + '/../templates/'
+			root:    __dirname + '/../templates/',
+			// Where certificates, the generated configs and logs are, ie: {{ paths.letsencrypt }}
+			globals: {paths: config.getPaths()}
 		});
 
 		/**
@@ -93,9 +183,18 @@ module.exports = {
 		 *
 		 * directive  string
 		 * address    string
+		 *
+		 * A hostname is written as the addresses it resolved to, kept in meta.resolved
 		 */
 		renderEngine.registerFilter('nginxAccessRule', (v) => {
 			if (typeof v.directive !== 'undefined' && typeof v.address !== 'undefined' && v.directive && v.address) {
+				if (/^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}$/.test(v.address)) {
+					const resolved = (v.meta && v.meta.resolved) || [];
+					if (!resolved.length) {
+						return `# ${v.address} hasn't resolved`;
+					}
+					return resolved.map((address) => `${v.directive} ${address}; # ${v.address}`).join('\n    ');
+				}
 				return `${v.directive} ${v.address};`;
 			}
 			return '';
@@ -9,6 +9,28 @@ const ajv = new Ajv({
 	coerceTypes:     true,
 });
 
+/**
+ * One entry for each field with a problem, with the field as a dotted path, ie: meta.dns_provider
+ *
+ * @param   {Array}  errors  from ajv
+ * @returns {Array}
+ */
+function getFields (errors) {
+	return errors.map((err) => {
+		let field = err.instancePath.split('/').slice(1);
+		if (typeof err.params.missingProperty !== 'undefined') {
+			field.push(err.params.missingProperty);
+		} else if (typeof err.params.additionalProperty !== 'undefined') {
+			field.push(err.params.additionalProperty);
+		}
+
+		return {
+			field:   field.join('.'),
+			message: err.params.additionalProperty ? 'is not allowed' : err.message
+		};
+	});
+}
+
 /**
  * @param {Object} schema
  * @param {Object} payload
@@ -33,7 +55,7 @@ function apiValidator (schema, payload/*, description*/) {
 			resolve(payload);
 		} else {
 			let message = ajv.errorsText(validate.errors);
-			let err     = new error.ValidationError(message);
+			let err     = new error.SchemaValidationError(message, getFields(validate.errors));
 			err.debug   = [validate.errors, payload];
 			reject(err);
 		}
@@ -1,14 +1,43 @@
 const {Signale} = require('signale');
 
-module.exports = {
-	global:    new Signale({scope: 'Global   '}),
-	migrate:   new Signale({scope: 'Migrate  '}),
-	express:   new Signale({scope: 'Express  '}),
-	access:    new Signale({scope: 'Access   '}),
-	nginx:     new Signale({scope: 'Nginx    '}),
-	ssl:       new Signale({scope: 'SSL      '}),
-	certbot:   new Signale({scope: 'Certbot  '}),
-	import:    new Signale({scope: 'Importer '}),
-	setup:     new Signale({scope: 'Setup    '}),
-	ip_ranges: new Signale({scope: 'IP Ranges'})
+const loggers = {
+	global:     new Signale({scope: 'Global   '}),
+	migrate:    new Signale({scope: 'Migrate  '}),
+	express:    new Signale({scope: 'Express  '}),
+	access:     new Signale({scope: 'Access   '}),
+	nginx:      new Signale({scope: 'Nginx    '}),
+	ssl:        new Signale({scope: 'SSL      '}),
+	certbot:    new Signale({scope: 'Certbot  '}),
+	import:     new Signale({scope: 'Importer '}),
+	setup:      new Signale({scope: 'Setup    '}),
+	ip_ranges:  new Signale({scope: 'IP Ranges'}),
+	ct_monitor: new Signale({scope: 'CT Logs  '}),
+	domains:    new Signale({scope: 'Domains  '}),
+	schedule:   new Signale({scope: 'Schedule '}),
+	database:   new Signale({scope: 'Database '})
 };
+
+module.exports = Object.assign({}, loggers, {
+
+	/**
+	 * Changes the level of every logger. Signale has no setter for this, so it's set on each instance.
+	 *
+	 * @param {String} level  ie: 'info' to show everything, 'warn' for warnings and errors only
+	 */
+	setLevel: (level) => {
+		Object.keys(loggers).forEach((name) => {
+			loggers[name]._logLevel = level;
+		});
+	},
+
+	/**
+	 * Copies the output of every logger to another stream as well as stdout, or stops when none is given
+	 *
+	 * @param {Writable}  [stream]
+	 */
+	setShipping: (stream) => {
+		Object.keys(loggers).forEach((name) => {
+			loggers[name]._stream = stream ? [process.stdout, stream] : process.stdout;
+		});
+	}
+});
@@ -1,15 +1,254 @@
+const fs     = require('fs');
+const path   = require('path');
 const db     = require('./db');
+const config = require('./lib/config');
 const logger = require('./logger').migrate;
 
+const BACKUP_DIR = process.env.DB_BACKUP_DIR || '/data/backups';
+
+// The snapshots kept, the oldest are removed after a new one
+const KEEP_SNAPSHOTS = 5;
+
+// Rows in each insert of a snapshot
+const SNAPSHOT_CHUNK = 100;
+
+const MIGRATIONS = {
+	tableName: 'migrations',
+	directory: 'migrations',
+	// Each migration runs in a transaction, so one that fails is undone. MySQL commits changes to tables
+	// as it makes them, so there the snapshot is what to go back to.
+	disableTransactions: false
+};
+
+// The migrations the last snapshot was taken before, so a start that's retried after one fails doesn't take another
+let snapshot_for = null;
+
+/**
+ * @returns {Promise}  resolves with the names of the tables
+ */
+const getTables = () => {
+	if (config.isSqlite()) {
+		return db.raw('SELECT name FROM sqlite_master WHERE type = \'table\' AND name NOT LIKE \'sqlite_%\'')
+			.then((rows) => rows.map((row) => row.name));
+	}
+	if (config.isPostgres()) {
+		return db.raw('SELECT tablename AS name FROM pg_tables WHERE schemaname = current_schema()')
+			.then((result) => result.rows.map((row) => row.name));
+	}
+	return db.raw('SELECT table_name AS name FROM information_schema.tables WHERE table_schema = DATABASE()')
+		.then((result) => result[0].map((row) => row.name || row.NAME));
+};
+
+/**
+ * Writes the rows of every table as SQL for the engine, to be loaded into the schema of the version
+ * it was taken at. Sqlite is copied whole instead.
+ *
+ * @param   {String}  file
+ * @returns {Promise}
+ */
+const writeSqlSnapshot = (file) => {
+	const fd = fs.openSync(file, 'w', 0o600);
+
+	const write = (sql) => {
+		fs.writeSync(fd, sql + ';\n');
+	};
+
+	if (config.isMysql()) {
+		write('SET FOREIGN_KEY_CHECKS = 0');
+	}
+
+	return getTables()
+		.then((tables) => {
+			return tables.reduce((promise, table) => {
+				return promise
+					.then(() => {
+						return db.select('*').from(table);
+					})
+					.then((rows) => {
+						write(db(table).del().toString());
+
+						// JSON columns come back parsed from some engines, and arrays would otherwise be written as SQL arrays
+						rows = rows.map((row) => {
+							let copy = {};
+							Object.keys(row).forEach((column) => {
+								const value  = row[column];
+								copy[column] = value !== null && typeof value === 'object' && !(value instanceof Date) && !Buffer.isBuffer(value) ? JSON.stringify(value) : value;
+							});
+							return copy;
+						});
+
+						for (let i = 0; i < rows.length; i += SNAPSHOT_CHUNK) {
+							write(db(table).insert(rows.slice(i, i + SNAPSHOT_CHUNK)).toString());
+						}
+
+						if (config.isPostgres() && rows.length && typeof rows[0].id !== 'undefined') {
+							// Ids were given, so the sequence wouldn't otherwise know about them
+							write(db.raw('SELECT setval(pg_get_serial_sequence(?, \'id\'), (SELECT MAX(id) FROM ??))', [table, table]).toString());
+						}
+					});
+			}, Promise.resolve());
+		})
+		.finally(() => {
+			fs.closeSync(fd);
+		});
+};
+
+/**
+ * Removes all but the newest snapshots
+ */
+const pruneSnapshots = () => {
+	fs.readdirSync(BACKUP_DIR)
+		.filter((name) => name.indexOf('database-') === 0)
+		.sort()
+		.reverse()
+		.slice(KEEP_SNAPSHOTS)
+		.forEach((name) => {
+			fs.unlinkSync(path.join(BACKUP_DIR, name));
+			logger.info('Removed old database snapshot ' + name);
+		});
+};
+
+/**
+ * Copies the database before it's migrated, named after the time and the version it was at
+ *
+ * @param   {String}  version  ie: '20261016235500'
+ * @returns {Promise}  resolves with the file
+ */
+const snapshot = (version) => {
+	fs.mkdirSync(BACKUP_DIR, {recursive: true, mode: 0o700});
+
+	const stamp = new Date().toISOString().replace(/[-:]/g, '').replace('T', '-').substring(0, 15);
+	const file  = path.join(BACKUP_DIR, 'database-' + stamp + '-' + version + (config.isSqlite() ? '.sqlite' : '.sql'));
+
+	// VACUUM INTO makes a consistent copy, even of a database that's being written to
+	const written = config.isSqlite() ? db.raw('VACUUM INTO ?', [file]) : writeSqlSnapshot(file);
+
+	return written
+		.then(() => {
+			pruneSnapshots();
+			return file;
+		})
+		.catch((err) => {
+			if (fs.existsSync(file)) {
+				fs.unlinkSync(file);
+			}
+			throw err;
+		});
+};
+
 module.exports = {
+
+	MIGRATIONS: MIGRATIONS,
+
+	BACKUP_DIR: BACKUP_DIR,
+
+	/**
+	 * Runs the migrations that haven't been. A database that has some already is copied first,
+	 * unless DB_MIGRATION_SNAPSHOT is false, and isn't migrated when it can't be.
+	 *
+	 * @returns {Promise}
+	 */
 	latest: function () {
 		return db.migrate.currentVersion()
 			.then((version) => {
 				logger.info('Current database version:', version);
-				return db.migrate.latest({
-					tableName: 'migrations',
-					directory: 'migrations'
-				});
+
+				return db.migrate.list(MIGRATIONS)
+					.then(([completed, pending]) => {
+						if (!pending.length || !completed.length) {
+							// Nothing to do, or a new database with nothing in it to lose
+							return;
+						}
+
+						const names = pending.map((item) => item.file).join(',');
+						if (['0', 'false', 'no'].indexOf((process.env.DB_MIGRATION_SNAPSHOT || '').toLowerCase()) !== -1 || snapshot_for === names) {
+							return;
+						}
+
+						logger.info('Taking a snapshot of the database before ' + pending.length + ' migrations...');
+						return snapshot(version)
+							.then((file) => {
+								snapshot_for = names;
+								logger.info('Database snapshot written to ' + file);
+							})
+							.catch((err) => {
+								throw new Error('Could not take a snapshot of the database, so it wasn\'t migrated: ' + err.message + '. Set DB_MIGRATION_SNAPSHOT=false to migrate without one');
+							});
+					});
+			})
+			.then(() => {
+				if (config.isMysql()) {
+					logger.info('MySQL can\'t undo changes to tables, a migration that fails part way may need the snapshot');
+				}
+				return db.migrate.latest(MIGRATIONS);
+			});
+	},
+
+	/**
+	 * @returns {Promise}  resolves with the migrations that have run, the ones waiting and the snapshots
+	 */
+	getHistory: function () {
+		return Promise.all([
+			db.migrate.currentVersion(),
+			db.migrate.list(MIGRATIONS),
+			db.select('name', 'batch', 'migration_time').from(MIGRATIONS.tableName).orderBy('id', 'DESC')
+		])
+			.then(([version, [, pending], rows]) => {
+				const snapshots = fs.existsSync(BACKUP_DIR) ? fs.readdirSync(BACKUP_DIR).filter((name) => name.indexOf('database-') === 0).sort().reverse() : [];
+
+				return {
+					version:       version,
+					transactional: !config.isMysql(),
+					applied:       rows.map((row) => {
+						return {
+							name:        row.name,
+							batch:       row.batch,
+							migrated_on: new Date(row.migration_time).toISOString()
+						};
+					}),
+					pending:   pending.map((item) => item.file),
+					snapshots: snapshots.map((name) => {
+						const stat = fs.statSync(path.join(BACKUP_DIR, name));
+						return {
+							file:       path.join(BACKUP_DIR, name),
+							size:       stat.size,
+							created_on: stat.mtime.toISOString()
+						};
+					})
+				};
 			});
+	},
+
+	/**
+	 * Undoes the migrations after the version, newest first, for going back to an older image
+	 *
+	 * @param   {String}  version  ie: '20261016235500', the version of the older image
+	 * @returns {Promise}  resolves with the names of the migrations that were undone
+	 */
+	rollback: function (version) {
+		let undone = [];
+
+		const next = () => {
+			return db.migrate.currentVersion()
+				.then((current) => {
+					if (current === 'none' || current <= version) {
+						return undone;
+					}
+
+					return db.migrate.down(MIGRATIONS)
+						.then(([, names]) => {
+							if (!names.length) {
+								return undone;
+							}
+							undone = undone.concat(names);
+							names.forEach((name) => {
+								logger.info('Undone ' + name);
+							});
+							return next();
+						});
+				});
+		};
+
+		return next();
 	}
 };
@@ -0,0 +1,50 @@
+const migrate_name = 'ct_log_entry';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('ct_log_entry', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('domain').notNull();
+		table.string('ct_id').notNull();
+		table.string('serial_number').notNull();
+		table.string('issuer_name').notNull();
+		table.string('common_name').notNull();
+		table.dateTime('not_before').notNull();
+		table.dateTime('not_after').notNull();
+		table.integer('is_known').notNull().unsigned().defaultTo(0);
+		table.json('meta').notNull();
+		table.unique(['domain', 'ct_id']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] ct_log_entry Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('ct_log_entry')
+		.then(() => {
+			logger.info('[' + migrate_name + '] ct_log_entry Table dropped');
+		});
+};
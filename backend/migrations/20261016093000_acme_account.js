@@ -0,0 +1,48 @@
+const migrate_name = 'acme_account';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('acme_account', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('email').notNull();
+		table.string('server').notNull();
+		table.string('account_id').notNull();
+		table.string('account_url').notNull();
+		table.string('status').notNull();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_account Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('acme_account')
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_account Table dropped');
+		});
+};
@@ -0,0 +1,48 @@
+const migrate_name = 'acme_order';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('acme_order', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('certificate_id').notNull().unsigned();
+		table.string('server').notNull();
+		table.string('account').notNull();
+		table.string('action').notNull();
+		table.string('names_hash').notNull();
+		table.integer('is_success').notNull().unsigned().defaultTo(0);
+		table.json('meta').notNull();
+		table.index(['server', 'created_on']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_order Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('acme_order')
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_order Table dropped');
+		});
+};
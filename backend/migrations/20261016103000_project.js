@@ -0,0 +1,70 @@
+const migrate_name = 'project';
+const logger       = require('../logger').migrate;
+
+const resource_tables = ['proxy_host', 'redirection_host', 'dead_host', 'stream', 'certificate', 'access_list'];
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('project', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('description').notNull().defaultTo('');
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] project Table created');
+
+			return knex.schema.createTable('project_permission', (table) => {
+				table.increments().primary();
+				table.dateTime('created_on').notNull();
+				table.dateTime('modified_on').notNull();
+				table.integer('project_id').notNull().unsigned();
+				table.integer('user_id').notNull().unsigned();
+				table.unique(['project_id', 'user_id']);
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] project_permission Table created');
+
+			let sequence = Promise.resolve();
+			resource_tables.forEach((table_name) => {
+				sequence = sequence
+					.then(() => {
+						return knex.schema.table(table_name, function (table) {
+							table.integer('project_id').notNull().unsigned().defaultTo(0);
+						});
+					})
+					.then(() => {
+						logger.info('[' + migrate_name + '] ' + table_name + ' Table altered');
+					});
+			});
+
+			return sequence;
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex, Promise) {
+	logger.warn('[' + migrate_name + '] You can\'t migrate down this one.');
+	return Promise.resolve(true);
+};
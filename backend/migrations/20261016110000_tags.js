@@ -0,0 +1,47 @@
+const migrate_name = 'tags';
+const logger       = require('../logger').migrate;
+
+const tables = ['proxy_host', 'redirection_host', 'dead_host', 'stream', 'certificate'];
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	let sequence = Promise.resolve();
+	tables.forEach((table_name) => {
+		sequence = sequence
+			.then(() => {
+				return knex.schema.table(table_name, function (table) {
+					table.json('tags');
+				});
+			})
+			.then(() => {
+				return knex(table_name).update({tags: '[]'});
+			})
+			.then(() => {
+				logger.info('[' + migrate_name + '] ' + table_name + ' Table altered');
+			});
+	});
+
+	return sequence;
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex, Promise) {
+	logger.warn('[' + migrate_name + '] You can\'t migrate down this one.');
+	return Promise.resolve(true);
+};
@@ -0,0 +1,47 @@
+const migrate_name = 'proxy_protocol';
+const logger       = require('../logger').migrate;
+
+const tables = ['proxy_host', 'redirection_host', 'dead_host', 'stream'];
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	let sequence = Promise.resolve();
+	tables.forEach((table_name) => {
+		sequence = sequence
+			.then(() => {
+				return knex.schema.table(table_name, function (table) {
+					table.integer('accept_proxy_protocol').notNull().unsigned().defaultTo(0);
+					if (table_name === 'stream') {
+						table.integer('send_proxy_protocol').notNull().unsigned().defaultTo(0);
+					}
+				});
+			})
+			.then(() => {
+				logger.info('[' + migrate_name + '] ' + table_name + ' Table altered');
+			});
+	});
+
+	return sequence;
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex, Promise) {
+	logger.warn('[' + migrate_name + '] You can\'t migrate down this one.');
+	return Promise.resolve(true);
+};
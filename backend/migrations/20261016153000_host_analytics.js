@@ -0,0 +1,48 @@
+const migrate_name = 'host_analytics';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('host_analytics', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('object_type').notNull();
+		table.integer('object_id').notNull().unsigned();
+		table.string('day', 10).notNull();
+		table.string('kind', 20).notNull();
+		table.string('value').notNull().defaultTo('');
+		table.integer('requests').notNull().unsigned().defaultTo(0);
+		table.bigInteger('bytes').notNull().unsigned().defaultTo(0);
+		table.unique(['object_type', 'object_id', 'day', 'kind', 'value']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] host_analytics Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('host_analytics')
+		.then(() => {
+			logger.info('[' + migrate_name + '] host_analytics Table dropped');
+		});
+};
@@ -0,0 +1,47 @@
+const migrate_name = 'domain_expiry';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('domain_expiry', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('domain').notNull().unique();
+		table.dateTime('expires_on').nullable();
+		table.string('registrar').nullable();
+		table.string('source').nullable();
+		table.dateTime('checked_on').nullable();
+		table.string('error').nullable();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] domain_expiry Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('domain_expiry')
+		.then(() => {
+			logger.info('[' + migrate_name + '] domain_expiry Table dropped');
+		});
+};
@@ -0,0 +1,46 @@
+const migrate_name = 'acme_dns_account';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('acme_dns_account', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.string('username').notNull().unique();
+		table.string('password').notNull();
+		table.string('subdomain').notNull().unique();
+		table.json('allow_from').notNull();
+		table.json('txt').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_dns_account Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('acme_dns_account')
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_dns_account Table dropped');
+		});
+};
@@ -0,0 +1,57 @@
+const migrate_name = 'tenant';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('tenant', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('description').notNull().defaultTo('');
+		table.json('quotas').notNull();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] tenant Table created');
+
+			// Users outside of any tenant, 0, are the ones that see everything
+			return knex.schema.table('user', function (table) {
+				table.integer('tenant_id').notNull().unsigned().defaultTo(0);
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] user Table altered');
+
+			// ACME accounts outside of any tenant are shared by all of them
+			return knex.schema.table('acme_account', function (table) {
+				table.integer('tenant_id').notNull().unsigned().defaultTo(0);
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_account Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex, Promise) {
+	logger.warn('[' + migrate_name + '] You can\'t migrate down this one.');
+	return Promise.resolve(true);
+};
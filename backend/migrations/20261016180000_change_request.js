@@ -0,0 +1,53 @@
+const migrate_name = 'change_request';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('change_request', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('reviewer_user_id').notNull().unsigned().defaultTo(0);
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('object_type').notNull();
+		table.integer('object_id').notNull().unsigned().defaultTo(0);
+		table.string('action').notNull();
+		table.string('status').notNull().defaultTo('pending');
+		table.json('payload').notNull();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] change_request Table created');
+
+			// none, propose or approve
+			return knex.schema.table('user_permission', function (table) {
+				table.string('change_requests').notNull().defaultTo('none');
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] user_permission Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex, Promise) {
+	logger.warn('[' + migrate_name + '] You can\'t migrate down this one.');
+	return Promise.resolve(true);
+};
@@ -0,0 +1,49 @@
+const migrate_name = 'scheduled_change';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('scheduled_change', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('object_type').notNull();
+		table.integer('object_id').notNull().unsigned().defaultTo(0);
+		table.string('action').notNull();
+		table.dateTime('apply_at').notNull();
+		table.string('status').notNull().defaultTo('pending');
+		table.json('payload').notNull();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] scheduled_change Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('scheduled_change')
+		.then(() => {
+			logger.info('[' + migrate_name + '] scheduled_change Table dropped');
+		});
+};
@@ -0,0 +1,40 @@
+const migrate_name = 'certificate_deploy_hooks';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.table('certificate', (table) => {
+		table.json('deploy_hooks').nullable();
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] certificate Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('certificate', (table) => {
+		table.dropColumn('deploy_hooks');
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] certificate Table altered');
+		});
+};
@@ -0,0 +1,47 @@
+const migrate_name = 'activity_event';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('activity_event', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('object_type').notNull();
+		table.integer('object_id').notNull().unsigned();
+		table.string('source').notNull();
+		table.string('event').notNull();
+		table.integer('is_success').notNull().unsigned().defaultTo(0);
+		table.json('meta').notNull();
+		table.index(['object_type', 'object_id', 'created_on']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] activity_event Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('activity_event')
+		.then(() => {
+			logger.info('[' + migrate_name + '] activity_event Table dropped');
+		});
+};
@@ -0,0 +1,42 @@
+const migrate_name = 'renewal_retry';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.table('certificate', (table) => {
+		table.json('renewal_retry').nullable();
+		table.json('renewal_status').nullable();
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] certificate Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('certificate', (table) => {
+		table.dropColumn('renewal_retry');
+		table.dropColumn('renewal_status');
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] certificate Table altered');
+		});
+};
@@ -0,0 +1,41 @@
+const migrate_name = 'user_features';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	// Null for the experimental features of the features setting
+	return knex.schema.table('user_permission', (table) => {
+		table.json('features').nullable();
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] user_permission Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('user_permission', (table) => {
+		table.dropColumn('features');
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] user_permission Table altered');
+		});
+};
@@ -0,0 +1,68 @@
+const migrate_name = 'host_usage';
+const logger       = require('../logger').migrate;
+
+const HOST_TABLES = ['proxy_host', 'redirection_host', 'dead_host'];
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('host_usage', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('object_type').notNull();
+		table.integer('object_id').notNull().unsigned();
+		table.string('month', 7).notNull();
+		table.integer('requests').notNull().unsigned().defaultTo(0);
+		table.bigInteger('bytes').notNull().unsigned().defaultTo(0);
+		table.json('alerted').nullable();
+		table.unique(['object_type', 'object_id', 'month']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] host_usage Table created');
+
+			return Promise.all(HOST_TABLES.map((name) => {
+				return knex.schema.table(name, (table) => {
+					table.json('usage_limits').nullable();
+				})
+					.then(() => {
+						logger.info('[' + migrate_name + '] ' + name + ' Table altered');
+					});
+			}));
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return Promise.all(HOST_TABLES.map((name) => {
+		return knex.schema.table(name, (table) => {
+			table.dropColumn('usage_limits');
+		})
+			.then(() => {
+				logger.info('[' + migrate_name + '] ' + name + ' Table altered');
+			});
+	}))
+		.then(() => {
+			return knex.schema.dropTable('host_usage');
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] host_usage Table dropped');
+		});
+};
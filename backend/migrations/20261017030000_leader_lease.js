@@ -0,0 +1,43 @@
+const migrate_name = 'leader_lease';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('leader_lease', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('name').notNull().unique();
+		table.string('holder').notNull();
+		table.dateTime('expires_on').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] leader_lease Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('leader_lease')
+		.then(() => {
+			logger.info('[' + migrate_name + '] leader_lease Table dropped');
+		});
+};
@@ -0,0 +1,40 @@
+const migrate_name = 'user_locale';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.table('user', (table) => {
+		table.string('locale', 10).nullable();
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] user Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object} knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('user', (table) => {
+		table.dropColumn('locale');
+	})
+		.then(function () {
+			logger.info('[' + migrate_name + '] user Table altered');
+		});
+};
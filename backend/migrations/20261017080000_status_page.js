@@ -0,0 +1,52 @@
+const migrate_name = 'status_page';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('status_page', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('slug').notNull();
+		table.string('description').notNull().defaultTo('');
+		// The hosts of a project, or the ones picked when it's 0
+		table.integer('project_id').notNull().unsigned().defaultTo(0);
+		table.json('hosts').notNull();
+		table.json('domain_names').notNull();
+		table.integer('certificate_id').notNull().unsigned().defaultTo(0);
+		table.integer('ssl_forced').notNull().unsigned().defaultTo(0);
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] status_page Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('status_page')
+		.then(() => {
+			logger.info('[' + migrate_name + '] status_page Table dropped');
+		});
+};
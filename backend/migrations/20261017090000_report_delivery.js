@@ -0,0 +1,47 @@
+const migrate_name = 'report_delivery';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('report_delivery', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.string('channel').notNull();
+		table.string('frequency', 20).notNull();
+		// The week or month the report is about, ie: 2026-W42 or 2026-10
+		table.string('period', 10).notNull();
+		table.integer('is_success').notNull().unsigned().defaultTo(0);
+		table.json('meta').notNull();
+		table.unique(['channel', 'frequency', 'period']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] report_delivery Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('report_delivery')
+		.then(() => {
+			logger.info('[' + migrate_name + '] report_delivery Table dropped');
+		});
+};
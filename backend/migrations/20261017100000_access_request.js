@@ -0,0 +1,52 @@
+const migrate_name = 'access_request';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('access_request', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		// The host it was asked on, and the access list keeping the address out of it
+		table.integer('proxy_host_id').notNull().unsigned();
+		table.integer('access_list_id').notNull().unsigned();
+		table.string('address').notNull();
+		table.string('name').notNull().defaultTo('');
+		table.string('reason', 500).notNull().defaultTo('');
+		table.string('status').notNull().defaultTo('pending');
+		table.integer('reviewer_user_id').notNull().unsigned().defaultTo(0);
+		// Until when the address is allowed once approved, null for good
+		table.dateTime('expires_on').nullable();
+		table.json('meta').notNull();
+		table.index(['address', 'status']);
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] access_request Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('access_request')
+		.then(() => {
+			logger.info('[' + migrate_name + '] access_request Table dropped');
+		});
+};
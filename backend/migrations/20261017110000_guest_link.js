@@ -0,0 +1,48 @@
+const migrate_name = 'guest_link';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('guest_link', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('proxy_host_id').notNull().unsigned();
+		table.string('name').notNull().defaultTo('');
+		// Signed when the link is made, kept as nginx only compares it
+		table.string('token').notNull().defaultTo('');
+		table.dateTime('expires_on').notNull();
+		table.json('meta').notNull();
+		table.index('proxy_host_id');
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] guest_link Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('guest_link')
+		.then(() => {
+			logger.info('[' + migrate_name + '] guest_link Table dropped');
+		});
+};
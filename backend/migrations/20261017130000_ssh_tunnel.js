@@ -0,0 +1,62 @@
+const migrate_name = 'ssh_tunnel';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('ssh_tunnel', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('host').notNull();
+		table.integer('port').notNull().unsigned().defaultTo(22);
+		table.string('username').notNull();
+		// Encrypted with the key in /data/ssh, so a copy of the database doesn't give it away
+		table.text('private_key').notNull();
+		table.text('host_key').notNull();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] ssh_tunnel Table created');
+
+			return knex.schema.table('proxy_host', function (proxy_host) {
+				proxy_host.integer('ssh_tunnel_id').notNull().unsigned().defaultTo(0);
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] proxy_host Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('proxy_host', function (proxy_host) {
+		proxy_host.dropColumn('ssh_tunnel_id');
+	})
+		.then(() => {
+			return knex.schema.dropTable('ssh_tunnel');
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] ssh_tunnel Table dropped');
+		});
+};
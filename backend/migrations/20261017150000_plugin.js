@@ -0,0 +1,51 @@
+const migrate_name = 'plugin';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('plugin', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('owner_user_id').notNull().unsigned();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.integer('enabled').notNull().unsigned().defaultTo(1);
+		table.string('name').notNull();
+		table.string('url', 500).notNull();
+		// The key the requests are signed with, never returned
+		table.string('secret').notNull().defaultTo('');
+		table.json('hooks').notNull();
+		table.string('on_error').notNull().defaultTo('allow');
+		table.integer('timeout').notNull().unsigned().defaultTo(5);
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] plugin Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('plugin')
+		.then(() => {
+			logger.info('[' + migrate_name + '] plugin Table dropped');
+		});
+};
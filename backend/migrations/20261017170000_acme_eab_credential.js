@@ -0,0 +1,49 @@
+const migrate_name = 'acme_eab_credential';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('acme_eab_credential', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		table.integer('is_deleted').notNull().unsigned().defaultTo(0);
+		table.integer('tenant_id').notNull().unsigned().defaultTo(0);
+		table.string('name').notNull();
+		table.string('server').notNull();
+		table.string('kid').notNull();
+		// Base64url encoded, never returned
+		table.string('hmac_key', 500).notNull();
+		table.dateTime('expires_on').nullable();
+		table.json('meta').notNull();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_eab_credential Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('acme_eab_credential')
+		.then(() => {
+			logger.info('[' + migrate_name + '] acme_eab_credential Table dropped');
+		});
+};
@@ -0,0 +1,46 @@
+const migrate_name = 'notification_held';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	return knex.schema.createTable('notification_held', (table) => {
+		table.increments().primary();
+		table.dateTime('created_on').notNull();
+		table.dateTime('modified_on').notNull();
+		// The name of the notification channel it's for
+		table.string('channel', 100).notNull();
+		table.string('event', 50).notNull();
+		table.string('severity', 20).notNull();
+		table.text('text').notNull();
+		table.index('channel');
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] notification_held Table created');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.dropTable('notification_held')
+		.then(() => {
+			logger.info('[' + migrate_name + '] notification_held Table dropped');
+		});
+};
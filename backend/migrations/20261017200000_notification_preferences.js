@@ -0,0 +1,56 @@
+const migrate_name = 'notification_preferences';
+const logger       = require('../logger').migrate;
+
+/**
+ * Migrate
+ *
+ * @see http://knexjs.org/#Schema
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.up = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Up...');
+
+	// Null for users who aren't told through notification channels
+	return knex.schema.table('user', (table) => {
+		table.json('notification_preferences').nullable();
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] user Table altered');
+
+			// Held for a user now, rather than for the whole channel
+			return knex.schema.table('notification_held', (table) => {
+				table.integer('user_id').notNull().unsigned().defaultTo(0);
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] notification_held Table altered');
+		});
+};
+
+/**
+ * Undo Migrate
+ *
+ * @param   {Object}  knex
+ * @param   {Promise} Promise
+ * @returns {Promise}
+ */
+exports.down = function (knex/*, Promise*/) {
+	logger.info('[' + migrate_name + '] Migrating Down...');
+
+	return knex.schema.table('notification_held', (table) => {
+		table.dropColumn('user_id');
+	})
+		.then(() => {
+			logger.info('[' + migrate_name + '] notification_held Table altered');
+
+			return knex.schema.table('user', (table) => {
+				table.dropColumn('notification_preferences');
+			});
+		})
+		.then(() => {
+			logger.info('[' + migrate_name + '] user Table altered');
+		});
+};
@@ -0,0 +1,79 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db         = require('../db');
+const Model      = require('objection').Model;
+const User       = require('./user');
+const ProxyHost  = require('./proxy_host');
+const AccessList = require('./access_list');
+const now        = require('./now_helper');
+
+Model.knex(db);
+
+class AccessRequest extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	static get name () {
+		return 'AccessRequest';
+	}
+
+	static get tableName () {
+		return 'access_request';
+	}
+
+	static get jsonAttributes () {
+		return ['meta'];
+	}
+
+	static get relationMappings () {
+		return {
+			proxy_host: {
+				relation:   Model.HasOneRelation,
+				modelClass: ProxyHost,
+				join:       {
+					from: 'access_request.proxy_host_id',
+					to:   'proxy_host.id'
+				},
+				modify: function (qb) {
+					qb.where('proxy_host.is_deleted', 0);
+				}
+			},
+			access_list: {
+				relation:   Model.HasOneRelation,
+				modelClass: AccessList,
+				join:       {
+					from: 'access_request.access_list_id',
+					to:   'access_list.id'
+				},
+				modify: function (qb) {
+					qb.where('access_list.is_deleted', 0);
+				}
+			},
+			reviewer: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'access_request.reviewer_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			}
+		};
+	}
+}
+
+module.exports = AccessRequest;
@@ -0,0 +1,41 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db    = require('../db');
+const Model = require('objection').Model;
+const now   = require('./now_helper');
+
+Model.knex(db);
+
+class AcmeDnsAccount extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		if (typeof this.allow_from === 'undefined') {
+			this.allow_from = [];
+		}
+
+		if (typeof this.txt === 'undefined') {
+			this.txt = [];
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	static get name () {
+		return 'AcmeDnsAccount';
+	}
+
+	static get tableName () {
+		return 'acme_dns_account';
+	}
+
+	static get jsonAttributes () {
+		return ['allow_from', 'txt'];
+	}
+}
+
+module.exports = AcmeDnsAccount;
@@ -0,0 +1,53 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db      = require('../db');
+const helpers = require('../lib/helpers');
+const Model   = require('objection').Model;
+const now     = require('./now_helper');
+
+Model.knex(db);
+
+const boolFields = [
+	'is_deleted',
+];
+
+class AcmeEabCredential extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	$parseDatabaseJson(json) {
+		json = super.$parseDatabaseJson(json);
+		return helpers.convertIntFieldsToBool(json, boolFields);
+	}
+
+	$formatDatabaseJson(json) {
+		json = helpers.convertBoolFieldsToInt(json, boolFields);
+		return super.$formatDatabaseJson(json);
+	}
+
+	static get name () {
+		return 'AcmeEabCredential';
+	}
+
+	static get tableName () {
+		return 'acme_eab_credential';
+	}
+
+	static get jsonAttributes () {
+		return ['meta'];
+	}
+}
+
+module.exports = AcmeEabCredential;
@@ -0,0 +1,53 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db      = require('../db');
+const helpers = require('../lib/helpers');
+const Model   = require('objection').Model;
+const now     = require('./now_helper');
+
+Model.knex(db);
+
+const boolFields = [
+	'is_success',
+];
+
+class AcmeOrder extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	$parseDatabaseJson(json) {
+		json = super.$parseDatabaseJson(json);
+		return helpers.convertIntFieldsToBool(json, boolFields);
+	}
+
+	$formatDatabaseJson(json) {
+		json = helpers.convertBoolFieldsToInt(json, boolFields);
+		return super.$formatDatabaseJson(json);
+	}
+
+	static get name () {
+		return 'AcmeOrder';
+	}
+
+	static get tableName () {
+		return 'acme_order';
+	}
+
+	static get jsonAttributes () {
+		return ['meta'];
+	}
+}
+
+module.exports = AcmeOrder;
@@ -0,0 +1,81 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db      = require('../db');
+const helpers = require('../lib/helpers');
+const Model   = require('objection').Model;
+const User    = require('./user');
+const now     = require('./now_helper');
+
+Model.knex(db);
+
+const boolFields = [
+	'is_deleted',
+	'enabled',
+];
+
+class AutomationRule extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for trigger
+		if (typeof this.trigger === 'undefined') {
+			this.trigger = {};
+		}
+
+		// Default for actions
+		if (typeof this.actions === 'undefined') {
+			this.actions = [];
+		}
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	$parseDatabaseJson(json) {
+		json = super.$parseDatabaseJson(json);
+		return helpers.convertIntFieldsToBool(json, boolFields);
+	}
+
+	$formatDatabaseJson(json) {
+		json = helpers.convertBoolFieldsToInt(json, boolFields);
+		return super.$formatDatabaseJson(json);
+	}
+
+	static get name () {
+		return 'AutomationRule';
+	}
+
+	static get tableName () {
+		return 'automation_rule';
+	}
+
+	static get jsonAttributes () {
+		return ['trigger', 'actions', 'meta'];
+	}
+
+	static get relationMappings () {
+		return {
+			owner: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'automation_rule.owner_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			}
+		};
+	}
+}
+
+module.exports = AutomationRule;
@@ -11,6 +11,7 @@ Model.knex(db);
 
 const boolFields = [
 	'is_deleted',
+	'locked',
 ];
 
 class Certificate extends Model {
@@ -33,6 +34,11 @@ class Certificate extends Model {
 			this.meta = {};
 		}
 
+		// Default for tags
+		if (typeof this.tags === 'undefined') {
+			this.tags = [];
+		}
+
 		this.domain_names.sort();
 	}
 
@@ -43,6 +49,11 @@ class Certificate extends Model {
 		if (typeof this.domain_names !== 'undefined') {
 			this.domain_names.sort();
 		}
+
+		// Sort tags
+		if (typeof this.tags !== 'undefined') {
+			this.tags.sort();
+		}
 	}
 
 	$parseDatabaseJson(json) {
@@ -64,7 +75,7 @@ class Certificate extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['domain_names', 'meta'];
+		return ['domain_names', 'meta', 'tags', 'deploy_hooks', 'renewal_retry', 'renewal_status'];
 	}
 
 	static get relationMappings () {
@@ -0,0 +1,86 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db      = require('../db');
+const helpers = require('../lib/helpers');
+const Model   = require('objection').Model;
+const User    = require('./user');
+const now     = require('./now_helper');
+
+Model.knex(db);
+
+const boolFields = [
+	'is_deleted',
+];
+
+class ChangeRequest extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for payload
+		if (typeof this.payload === 'undefined') {
+			this.payload = {};
+		}
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	$parseDatabaseJson(json) {
+		json = super.$parseDatabaseJson(json);
+		return helpers.convertIntFieldsToBool(json, boolFields);
+	}
+
+	$formatDatabaseJson(json) {
+		json = helpers.convertBoolFieldsToInt(json, boolFields);
+		return super.$formatDatabaseJson(json);
+	}
+
+	static get name () {
+		return 'ChangeRequest';
+	}
+
+	static get tableName () {
+		return 'change_request';
+	}
+
+	static get jsonAttributes () {
+		return ['payload', 'meta'];
+	}
+
+	static get relationMappings () {
+		return {
+			owner: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'change_request.owner_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			},
+			reviewer: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'change_request.reviewer_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			}
+		};
+	}
+}
+
+module.exports = ChangeRequest;
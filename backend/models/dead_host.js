@@ -13,6 +13,8 @@ Model.knex(db);
 const boolFields = [
 	'is_deleted',
 	'enabled',
+	'locked',
+	'accept_proxy_protocol',
 ];
 
 class DeadHost extends Model {
@@ -30,6 +32,11 @@ class DeadHost extends Model {
 			this.meta = {};
 		}
 
+		// Default for tags
+		if (typeof this.tags === 'undefined') {
+			this.tags = [];
+		}
+
 		this.domain_names.sort();
 	}
 
@@ -40,6 +47,11 @@ class DeadHost extends Model {
 		if (typeof this.domain_names !== 'undefined') {
 			this.domain_names.sort();
 		}
+
+		// Sort tags
+		if (typeof this.tags !== 'undefined') {
+			this.tags.sort();
+		}
 	}
 
 	$parseDatabaseJson(json) {
@@ -61,7 +73,7 @@ class DeadHost extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['domain_names', 'meta'];
+		return ['domain_names', 'meta', 'tags', 'compression', 'server_header', 'listen', 'redirect_rules', 'usage_limits'];
 	}
 
 	static get relationMappings () {
@@ -0,0 +1,67 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db        = require('../db');
+const Model     = require('objection').Model;
+const User      = require('./user');
+const ProxyHost = require('./proxy_host');
+const now       = require('./now_helper');
+
+Model.knex(db);
+
+class GuestLink extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+	}
+
+	static get name () {
+		return 'GuestLink';
+	}
+
+	static get tableName () {
+		return 'guest_link';
+	}
+
+	static get jsonAttributes () {
+		return ['meta'];
+	}
+
+	static get relationMappings () {
+		return {
+			owner: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'guest_link.owner_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			},
+			proxy_host: {
+				relation:   Model.HasOneRelation,
+				modelClass: ProxyHost,
+				join:       {
+					from: 'guest_link.proxy_host_id',
+					to:   'proxy_host.id'
+				},
+				modify: function (qb) {
+					qb.where('proxy_host.is_deleted', 0);
+				}
+			}
+		};
+	}
+}
+
+module.exports = GuestLink;
@@ -21,6 +21,8 @@ const boolFields = [
 	'enabled',
 	'hsts_enabled',
 	'hsts_subdomains',
+	'locked',
+	'accept_proxy_protocol',
 ];
 
 class ProxyHost extends Model {
@@ -38,6 +40,11 @@ class ProxyHost extends Model {
 			this.meta = {};
 		}
 
+		// Default for tags
+		if (typeof this.tags === 'undefined') {
+			this.tags = [];
+		}
+
 		this.domain_names.sort();
 	}
 
@@ -48,6 +55,11 @@ class ProxyHost extends Model {
 		if (typeof this.domain_names !== 'undefined') {
 			this.domain_names.sort();
 		}
+
+		// Sort tags
+		if (typeof this.tags !== 'undefined') {
+			this.tags.sort();
+		}
 	}
 
 	$parseDatabaseJson(json) {
@@ -69,7 +81,7 @@ class ProxyHost extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['domain_names', 'meta', 'locations'];
+		return ['domain_names', 'meta', 'locations', 'tags', 'compression', 'server_header', 'upstream_tls', 'upstream_auth', 'cors', 'hotlink', 'optimizations', 'listen', 'ports', 'traffic_split', 'upstream_sets', 'load_balancing', 'keepalive', 'redirect_rules', 'fallback', 'limits', 'access_exemptions', 'protection_presets', 'served_files', 'usage_limits'];
 	}
 
 	static get relationMappings () {
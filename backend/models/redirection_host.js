@@ -20,6 +20,8 @@ const boolFields = [
 	'hsts_enabled',
 	'hsts_subdomains',
 	'http2_support',
+	'locked',
+	'accept_proxy_protocol',
 ];
 
 class RedirectionHost extends Model {
@@ -37,6 +39,11 @@ class RedirectionHost extends Model {
 			this.meta = {};
 		}
 
+		// Default for tags
+		if (typeof this.tags === 'undefined') {
+			this.tags = [];
+		}
+
 		this.domain_names.sort();
 	}
 
@@ -47,6 +54,11 @@ class RedirectionHost extends Model {
 		if (typeof this.domain_names !== 'undefined') {
 			this.domain_names.sort();
 		}
+
+		// Sort tags
+		if (typeof this.tags !== 'undefined') {
+			this.tags.sort();
+		}
 	}
 
 	$parseDatabaseJson(json) {
@@ -68,7 +80,7 @@ class RedirectionHost extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['domain_names', 'meta'];
+		return ['domain_names', 'meta', 'tags', 'compression', 'server_header', 'listen', 'redirect_rules', 'usage_limits'];
 	}
 
 	static get relationMappings () {
@@ -0,0 +1,100 @@
+// Objection Docs:
+// http://vincit.github.io/objection.js/
+
+const db          = require('../db');
+const helpers     = require('../lib/helpers');
+const Model       = require('objection').Model;
+const User        = require('./user');
+const Certificate = require('./certificate');
+const now         = require('./now_helper');
+
+Model.knex(db);
+
+const boolFields = [
+	'is_deleted',
+	'ssl_forced',
+];
+
+class StatusPage extends Model {
+	$beforeInsert () {
+		this.created_on  = now();
+		this.modified_on = now();
+
+		// Default for hosts
+		if (typeof this.hosts === 'undefined') {
+			this.hosts = [];
+		}
+
+		// Default for domain_names
+		if (typeof this.domain_names === 'undefined') {
+			this.domain_names = [];
+		}
+
+		// Default for meta
+		if (typeof this.meta === 'undefined') {
+			this.meta = {};
+		}
+
+		this.domain_names.sort();
+	}
+
+	$beforeUpdate () {
+		this.modified_on = now();
+
+		// Sort domain_names
+		if (typeof this.domain_names !== 'undefined') {
+			this.domain_names.sort();
+		}
+	}
+
+	$parseDatabaseJson(json) {
+		json = super.$parseDatabaseJson(json);
+		return helpers.convertIntFieldsToBool(json, boolFields);
+	}
+
+	$formatDatabaseJson(json) {
+		json = helpers.convertBoolFieldsToInt(json, boolFields);
+		return super.$formatDatabaseJson(json);
+	}
+
+	static get name () {
+		return 'StatusPage';
+	}
+
+	static get tableName () {
+		return 'status_page';
+	}
+
+	static get jsonAttributes () {
+		return ['hosts', 'domain_names', 'meta'];
+	}
+
+	static get relationMappings () {
+		return {
+			owner: {
+				relation:   Model.HasOneRelation,
+				modelClass: User,
+				join:       {
+					from: 'status_page.owner_user_id',
+					to:   'user.id'
+				},
+				modify: function (qb) {
+					qb.where('user.is_deleted', 0);
+				}
+			},
+			certificate: {
+				relation:   Model.HasOneRelation,
+				modelClass: Certificate,
+				join:       {
+					from: 'status_page.certificate_id',
+					to:   'certificate.id'
+				},
+				modify: function (qb) {
+					qb.where('certificate.is_deleted', 0);
+				}
+			}
+		};
+	}
+}
+
+module.exports = StatusPage;
@@ -1,11 +1,12 @@
 // Objection Docs:
 // http://vincit.github.io/objection.js/
 
-const db      = require('../db');
-const helpers = require('../lib/helpers');
-const Model   = require('objection').Model;
-const User    = require('./user');
-const now     = require('./now_helper');
+const db         = require('../db');
+const helpers    = require('../lib/helpers');
+const Model      = require('objection').Model;
+const User       = require('./user');
+const AccessList = require('./access_list');
+const now        = require('./now_helper');
 
 Model.knex(db);
 
@@ -13,6 +14,9 @@ const boolFields = [
 	'is_deleted',
 	'tcp_forwarding',
 	'udp_forwarding',
+	'locked',
+	'accept_proxy_protocol',
+	'send_proxy_protocol',
 ];
 
 class Stream extends Model {
@@ -24,10 +28,20 @@ class Stream extends Model {
 		if (typeof this.meta === 'undefined') {
 			this.meta = {};
 		}
+
+		// Default for tags
+		if (typeof this.tags === 'undefined') {
+			this.tags = [];
+		}
 	}
 
 	$beforeUpdate () {
 		this.modified_on = now();
+
+		// Sort tags
+		if (typeof this.tags !== 'undefined') {
+			this.tags.sort();
+		}
 	}
 
 	$parseDatabaseJson(json) {
@@ -49,7 +63,7 @@ class Stream extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['meta'];
+		return ['meta', 'tags', 'listen', 'tuning'];
 	}
 
 	static get relationMappings () {
@@ -64,6 +78,17 @@ class Stream extends Model {
 				modify: function (qb) {
 					qb.where('user.is_deleted', 0);
 				}
+			},
+			access_list: {
+				relation:   Model.HasOneRelation,
+				modelClass: AccessList,
+				join:       {
+					from: 'stream.access_list_id',
+					to:   'access_list.id'
+				},
+				modify: function (qb) {
+					qb.where('access_list.is_deleted', 0);
+				}
 			}
 		};
 	}
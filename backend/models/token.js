@@ -3,13 +3,70 @@
  and then has abilities after that.
  */
 
-const _      = require('lodash');
-const jwt    = require('jsonwebtoken');
-const crypto = require('crypto');
-const config = require('../lib/config');
-const error  = require('../lib/error');
-const logger = require('../logger').global;
-const ALGO   = 'RS256';
+const _       = require('lodash');
+const jwt     = require('jsonwebtoken');
+const crypto  = require('crypto');
+const config  = require('../lib/config');
+const error   = require('../lib/error');
+const helpers = require('../lib/helpers');
+
+/**
+ * Parsed public keys by key id, so each request doesn't parse the PEM again
+ */
+let publicKeys = {};
+
+/**
+ * @param   {Object}  item  from config.getVerificationKeys()
+ * @returns {KeyObject}
+ */
+const getPublicKey = (item) => {
+	if (typeof publicKeys[item.kid] === 'undefined') {
+		publicKeys[item.kid] = crypto.createPublicKey(item.pub);
+	}
+	return publicKeys[item.kid];
+};
+
+/**
+ * jsonwebtoken can't do Ed25519, so those tokens are signed and verified here
+ */
+const eddsa = {
+
+	/**
+	 * @param   {Object}  payload
+	 * @param   {Object}  signing_key
+	 * @param   {String}  expires_in   ie: '1d'
+	 * @returns {String}
+	 */
+	sign: (payload, signing_key, expires_in) => {
+		const encode = (obj) => Buffer.from(JSON.stringify(obj)).toString('base64url');
+		const iat    = Math.floor(Date.now() / 1000);
+		const expiry = helpers.parseDatePeriod(expires_in);
+
+		const data = encode({alg: 'EdDSA', typ: 'JWT', kid: signing_key.kid}) + '.' +
+			encode(Object.assign({iat: iat}, payload, expiry ? {exp: Math.floor(expiry.valueOf() / 1000)} : {}));
+
+		return data + '.' + crypto.sign(null, Buffer.from(data), signing_key.key).toString('base64url');
+	},
+
+	/**
+	 * @param   {String}     token
+	 * @param   {KeyObject}  pub
+	 * @returns {Object}     the payload
+	 */
+	verify: (token, pub) => {
+		const parts = token.split('.');
+		if (parts.length !== 3 || !crypto.verify(null, Buffer.from(parts[0] + '.' + parts[1]), pub, Buffer.from(parts[2], 'base64url'))) {
+			throw new jwt.JsonWebTokenError('invalid signature');
+		}
+
+		const payload = JSON.parse(Buffer.from(parts[1], 'base64url').toString());
+		if (typeof payload.exp !== 'undefined' && payload.exp <= Math.floor(Date.now() / 1000)) {
+			throw new jwt.TokenExpiredError('jwt expired', new Date(payload.exp * 1000));
+		}
+
+		return payload;
+	}
+};
 
 module.exports = function () {
 
@@ -21,21 +78,32 @@ module.exports = function () {
 		 * @returns {Promise}
 		 */
 		create: (payload) => {
-			if (!config.getPrivateKey()) {
-				logger.error('Private key is empty!');
-			}
-			// sign with RSA SHA256
-			const options = {
-				algorithm: ALGO,
-				expiresIn: payload.expiresIn || '1d'
-			};
+			const signing_key = config.getSigningKey();
 
 			payload.jti = crypto.randomBytes(12)
 				.toString('base64')
 				.substring(-8);
 
+			if (signing_key.alg === 'EdDSA') {
+				return new Promise((resolve) => {
+					const token = eddsa.sign(payload, signing_key, payload.expiresIn || '1d');
+					token_data  = payload;
+					resolve({
+						token:   token,
+						payload: payload
+					});
+				});
+			}
+
+			// RS256 or ES256
+			const options = {
+				algorithm: signing_key.alg,
+				expiresIn: payload.expiresIn || '1d',
+				keyid:     signing_key.kid
+			};
+
 			return new Promise((resolve, reject) => {
-				jwt.sign(payload, config.getPrivateKey(), options, (err, token) => {
+				jwt.sign(payload, signing_key.key, options, (err, token) => {
 					if (err) {
 						reject(err);
 					} else {
@@ -54,43 +122,69 @@ module.exports = function () {
 		 * @returns {Promise}
 		 */
 		load: function (token) {
-			if (!config.getPublicKey()) {
-				logger.error('Public key is empty!');
-			}
 			return new Promise((resolve, reject) => {
 				try {
 					if (!token || token === null || token === 'null') {
 						reject(new error.AuthError('Empty token'));
 					} else {
-						jwt.verify(token, config.getPublicKey(), {ignoreExpiration: false, algorithms: [ALGO]}, (err, result) => {
-							if (err) {
-
-								if (err.name === 'TokenExpiredError') {
-									reject(new error.AuthError('Token has expired', err));
-								} else {
-									reject(err);
-								}
-
-							} else {
-								token_data = result;
-
-								// Hack: some tokens out in the wild have a scope of 'all' instead of 'user'.
-								// For 30 days at least, we need to replace 'all' with user.
-								if ((typeof token_data.scope !== 'undefined' && _.indexOf(token_data.scope, 'all') !== -1)) {
-									token_data.scope = ['user'];
-								}
-
-								resolve(token_data);
-							}
-						});
+						const result = self.verify(token);
+						token_data   = result;
+
+						// Hack: some tokens out in the wild have a scope of 'all' instead of 'user'.
+						// For 30 days at least, we need to replace 'all' with user.
+						if ((typeof token_data.scope !== 'undefined' && _.indexOf(token_data.scope, 'all') !== -1)) {
+							token_data.scope = ['user'];
+						}
+
+						resolve(token_data);
 					}
 				} catch (err) {
-					reject(err);
+					if (err.name === 'TokenExpiredError') {
+						reject(new error.AuthError('Token has expired', err));
+					} else {
+						reject(err);
+					}
 				}
 			});
 
 		},
 
+		/**
+		 * Verifies with the key the token was signed with, which may be one that was
+		 * rotated out recently. Tokens from before rotation have no key id.
+		 *
+		 * @param   {String}  token
+		 * @returns {Object}  the payload
+		 */
+		verify: function (token) {
+			const decoded = jwt.decode(token, {complete: true});
+			if (!decoded || !decoded.header) {
+				throw new jwt.JsonWebTokenError('jwt malformed');
+			}
+
+			const keys = config.getVerificationKeys().filter((item) => {
+				return decoded.header.kid ? item.kid === decoded.header.kid : item.alg === decoded.header.alg;
+			});
+
+			if (!keys.length) {
+				throw new error.AuthError('Token was signed with a key that is no longer valid');
+			}
+
+			let last_err = null;
+			for (const item of keys) {
+				try {
+					if (item.alg === 'EdDSA') {
+						return eddsa.verify(token, getPublicKey(item));
+					}
+					return jwt.verify(token, getPublicKey(item), {ignoreExpiration: false, algorithms: [item.alg]});
+				} catch (err) {
+					last_err = err;
+				}
+			}
+
+			throw last_err;
+		},
+
 		/**
 		 * Does the token have the specified scope?
 		 *
@@ -48,7 +48,7 @@ class User extends Model {
 	}
 
 	static get jsonAttributes () {
-		return ['roles'];
+		return ['roles', 'notification_preferences'];
 	}
 
 	static get relationMappings () {
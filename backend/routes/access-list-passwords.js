@@ -0,0 +1,66 @@
+const express                    = require('express');
+const validator                  = require('../lib/validator');
+const apiValidator               = require('../lib/validator/api');
+const internalAccessListPassword = require('../internal/access-list-password');
+const schema                     = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * Where users of an access list change their own password, without a token.
+ * Hosts pass it on from /.npm/password, with the address of the client.
+ *
+ * /api/access-list-passwords/123
+ */
+router
+	.route('/:host_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * POST /api/access-list-passwords/123
+	 *
+	 * With the username and current password as basic auth
+	 */
+	.post((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			host_id: req.params.host_id
+		})
+			.then((data) => {
+				return apiValidator(schema.getValidationSchema('/access-list-passwords/{hostID}', 'post'), req.body)
+					.then((payload) => {
+						return internalAccessListPassword.change({
+							host_id:       parseInt(data.host_id, 10),
+							address:       req.ip,
+							authorization: req.get('authorization'),
+							password:      payload.password
+						});
+					});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch((err) => {
+				// So a browser asks for the username and current password
+				if (err instanceof Error && err.status === 401) {
+					res.set('WWW-Authenticate', 'Basic realm="Authorization required", charset="UTF-8"');
+				}
+				next(err);
+			});
+	});
+
+module.exports = router;
@@ -0,0 +1,276 @@
+const express               = require('express');
+const validator             = require('../lib/validator');
+const error                 = require('../lib/error');
+const jwtdecode             = require('../lib/express/jwt-decode');
+const apiValidator          = require('../lib/validator/api');
+const internalAccessRequest = require('../internal/access-request');
+const schema                = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+const hostSchema = {
+	required:             ['host_id'],
+	additionalProperties: false,
+	properties:           {
+		host_id: {
+			$ref: 'common#/properties/id'
+		}
+	}
+};
+
+/**
+ * Whether the client wants JSON back rather than the page, ie: a script rather than a browser
+ *
+ * @param   {Object}  req
+ * @returns {Boolean}
+ */
+const wantsJson = (req) => {
+	return !!req.is('application/json') || req.accepts(['html', 'json']) === 'json';
+};
+
+/**
+ * The page clients kept out by an access list ask for access on, without a token.
+ * Hosts send their 403s here, with the address of the client. req.ip is only that when the request
+ * comes from our own nginx (trust proxy in app.js), anything else is the peer's, so it can't be made up.
+ *
+ * /api/access-requests/portal/123
+ */
+router
+	.route('/portal/:host_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /api/access-requests/portal/123
+	 */
+	.get((req, res, next) => {
+		validator(hostSchema, {host_id: req.params.host_id})
+			.then((data) => {
+				if (wantsJson(req)) {
+					return internalAccessRequest.getPortal(parseInt(data.host_id, 10))
+						.then(([setting, host]) => {
+							res.status(200)
+								.send({
+									domain_names: host.domain_names,
+									address:      req.ip,
+									provider:     setting.meta.provider,
+									site_key:     setting.meta.site_key
+								});
+						});
+				}
+
+				return internalAccessRequest.renderPortal({host_id: parseInt(data.host_id, 10), address: req.ip})
+					.then((html) => {
+						res.status(200)
+							.set('Content-Type', 'text/html; charset=utf-8')
+							.send(html);
+					});
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/access-requests/portal/123
+	 *
+	 * Files a request for access for the address of the client, from the page or as JSON
+	 */
+	.post((req, res, next) => {
+		const json = wantsJson(req);
+		let data   = null;
+
+		validator(hostSchema, {host_id: req.params.host_id})
+			.then((params) => {
+				data = {
+					host_id:    parseInt(params.host_id, 10),
+					address:    req.ip,
+					name:       String(req.body.name || '').trim().slice(0, 100),
+					reason:     String(req.body.reason || '').trim().slice(0, 500),
+					captcha:    internalAccessRequest.getCaptchaResponse(req.body),
+					user_agent: req.get('user-agent')
+				};
+
+				return internalAccessRequest.submit(data);
+			})
+			.then((result) => {
+				if (json) {
+					res.status(201)
+						.send(result);
+					return;
+				}
+
+				return internalAccessRequest.renderPortal(data, {request: result})
+					.then((html) => {
+						res.status(201)
+							.set('Content-Type', 'text/html; charset=utf-8')
+							.send(html);
+					});
+			})
+			.catch((err) => {
+				// The page says what went wrong itself, so it can be tried again
+				if (json || data === null || !(err instanceof error.ValidationError)) {
+					next(err);
+					return;
+				}
+
+				internalAccessRequest.renderPortal(data, {error: err.message})
+					.then((html) => {
+						res.status(400)
+							.set('Content-Type', 'text/html; charset=utf-8')
+							.send(html);
+					})
+					.catch(next);
+			});
+	});
+
+/**
+ * /api/access-requests
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/access-requests
+	 *
+	 * Retrieve all access requests
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				},
+				status: {
+					type: 'string',
+					enum: ['pending', 'approved', 'rejected']
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			status: (typeof req.query.status === 'string' ? req.query.status : undefined)
+		})
+			.then((data) => {
+				return internalAccessRequest.getAll(res.locals.access, {status: data.status}, data.expand);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific access request
+ *
+ * /api/access-requests/123
+ */
+router
+	.route('/:access_request_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/access-requests/123
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['access_request_id'],
+			additionalProperties: false,
+			properties:           {
+				access_request_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			access_request_id: req.params.access_request_id,
+			expand:            (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalAccessRequest.get(res.locals.access, {
+					id:     parseInt(data.access_request_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	});
+
+/**
+ * Let the address of an access request in
+ *
+ * /api/access-requests/123/approve
+ */
+router
+	.route('/:access_request_id/approve')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/access-requests/123/approve
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/access-requests/{accessRequestID}/approve', 'post'), req.body)
+			.then((payload) => {
+				return internalAccessRequest.approve(res.locals.access, {
+					id:      parseInt(req.params.access_request_id, 10),
+					hours:   payload.hours,
+					comment: payload.comment
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Reject an access request
+ *
+ * /api/access-requests/123/reject
+ */
+router
+	.route('/:access_request_id/reject')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/access-requests/123/reject
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/access-requests/{accessRequestID}/reject', 'post'), req.body)
+			.then((payload) => {
+				return internalAccessRequest.reject(res.locals.access, {
+					id:      parseInt(req.params.access_request_id, 10),
+					comment: payload.comment
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,135 @@
+const express         = require('express');
+const validator       = require('../lib/validator');
+const jwtdecode       = require('../lib/express/jwt-decode');
+const apiValidator    = require('../lib/validator/api');
+const internalAcmeDns = require('../internal/acme-dns');
+const schema          = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/acme-dns/register
+ */
+router
+	.route('/register')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/acme-dns/register
+	 *
+	 * Create credentials for a challenge record, like acme-dns does
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/acme-dns/register', 'post'), req.body || {})
+			.then((payload) => {
+				return internalAcmeDns.register(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/acme-dns/update
+ *
+ * Called by acme-dns clients with the credentials from /register, not an API token
+ */
+router
+	.route('/update')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * POST /api/acme-dns/update
+	 *
+	 * Set the challenge
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/acme-dns/update', 'post'), req.body)
+			.then((payload) => {
+				// The peer, X-Forwarded-For is only taken from our own nginx (trust proxy in app.js) so allow_from can't be got around
+				return internalAcmeDns.update({
+					username: req.get('X-Api-User'),
+					password: req.get('X-Api-Key'),
+					address:  req.ip
+				}, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/acme-dns/accounts
+ */
+router
+	.route('/accounts')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/acme-dns/accounts
+	 *
+	 * Retrieve all registrations, without their passwords
+	 */
+	.get((req, res, next) => {
+		internalAcmeDns.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/acme-dns/accounts/123
+ */
+router
+	.route('/accounts/:account_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * DELETE /api/acme-dns/accounts/123
+	 *
+	 * Remove a registration, its record stops resolving
+	 */
+	.delete((req, res, next) => {
+		validator({
+			required:             ['account_id'],
+			additionalProperties: false,
+			properties:           {
+				account_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			account_id: req.params.account_id
+		})
+			.then((data) => {
+				return internalAcmeDns.delete(res.locals.access, {id: parseInt(data.account_id, 10)});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -1,6 +1,7 @@
 const express          = require('express');
 const validator        = require('../lib/validator');
 const jwtdecode        = require('../lib/express/jwt-decode');
+const jsonLines        = require('../lib/express/json-lines');
 const internalAuditLog = require('../internal/audit-log');
 
 let router = express.Router({
@@ -22,7 +23,7 @@ router
 	/**
 	 * GET /api/audit-log
 	 *
-	 * Retrieve all logs
+	 * Retrieve the last 100 logs, or all of them as JSON lines
 	 */
 	.get((req, res, next) => {
 		validator({
@@ -40,11 +41,18 @@ router
 			query:  (typeof req.query.query === 'string' ? req.query.query : null)
 		})
 			.then((data) => {
-				return internalAuditLog.getAll(res.locals.access, data.expand, data.query);
-			})
-			.then((rows) => {
-				res.status(200)
-					.send(rows);
+				// All of the log as JSON lines, or the last 100 entries
+				if (jsonLines.wanted(req)) {
+					return jsonLines.send(res, (write) => {
+						return internalAuditLog.getAll(res.locals.access, data.expand, data.query, write);
+					});
+				}
+
+				return internalAuditLog.getAll(res.locals.access, data.expand, data.query)
+					.then((rows) => {
+						res.status(200)
+							.send(rows);
+					});
 			})
 			.catch(next);
 	});
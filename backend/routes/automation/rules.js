@@ -0,0 +1,128 @@
+const express            = require('express');
+const validator          = require('../../lib/validator');
+const jwtdecode          = require('../../lib/express/jwt-decode');
+const apiValidator       = require('../../lib/validator/api');
+const internalAutomation = require('../../internal/automation');
+const schema             = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/automation/rules
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/automation/rules
+	 *
+	 * Retrieve all automation rules
+	 */
+	.get((req, res, next) => {
+		internalAutomation.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/automation/rules
+	 *
+	 * Add an automation rule
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/automation/rules', 'post'), req.body)
+			.then((payload) => {
+				return internalAutomation.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific automation rule
+ *
+ * /api/automation/rules/123
+ */
+router
+	.route('/:rule_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/automation/rules/123
+	 *
+	 * Retrieve a specific automation rule
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['rule_id'],
+			additionalProperties: false,
+			properties:           {
+				rule_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			rule_id: req.params.rule_id
+		})
+			.then((data) => {
+				return internalAutomation.get(res.locals.access, {
+					id: parseInt(data.rule_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/automation/rules/123
+	 *
+	 * Update an existing automation rule
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/automation/rules/{ruleID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.rule_id, 10);
+				return internalAutomation.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/automation/rules/123
+	 *
+	 * Delete an existing automation rule
+	 */
+	.delete((req, res, next) => {
+		internalAutomation.delete(res.locals.access, {id: parseInt(req.params.rule_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
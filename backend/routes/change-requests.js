@@ -0,0 +1,168 @@
+const express               = require('express');
+const validator             = require('../lib/validator');
+const jwtdecode             = require('../lib/express/jwt-decode');
+const apiValidator          = require('../lib/validator/api');
+const internalChangeRequest = require('../internal/change-request');
+const schema                = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/change-requests
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/change-requests
+	 *
+	 * Retrieve all change requests the user can see
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				},
+				status: {
+					type: 'string',
+					enum: ['pending', 'approved', 'rejected']
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			status: (typeof req.query.status === 'string' ? req.query.status : undefined)
+		})
+			.then((data) => {
+				return internalChangeRequest.getAll(res.locals.access, {status: data.status}, data.expand);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific change request
+ *
+ * /api/change-requests/123
+ */
+router
+	.route('/:change_request_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/change-requests/123
+	 *
+	 * Retrieve a specific change request, with what it would change
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['change_request_id'],
+			additionalProperties: false,
+			properties:           {
+				change_request_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			change_request_id: req.params.change_request_id,
+			expand:            (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalChangeRequest.get(res.locals.access, {
+					id:     parseInt(data.change_request_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				return internalChangeRequest.getDiff(res.locals.access, row)
+					.then((diff) => {
+						row.diff = diff;
+						return row;
+					});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	});
+
+/**
+ * Apply a change request
+ *
+ * /api/change-requests/123/approve
+ */
+router
+	.route('/:change_request_id/approve')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/change-requests/123/approve
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/change-requests/{changeRequestID}/approve', 'post'), req.body)
+			.then((payload) => {
+				return internalChangeRequest.approve(res.locals.access, {
+					id:      parseInt(req.params.change_request_id, 10),
+					comment: payload.comment
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Reject a change request
+ *
+ * /api/change-requests/123/reject
+ */
+router
+	.route('/:change_request_id/reject')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/change-requests/123/reject
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/change-requests/{changeRequestID}/reject', 'post'), req.body)
+			.then((payload) => {
+				return internalChangeRequest.reject(res.locals.access, {
+					id:      parseInt(req.params.change_request_id, 10),
+					comment: payload.comment
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,60 @@
+const express             = require('express');
+const validator           = require('../lib/validator');
+const jwtdecode           = require('../lib/express/jwt-decode');
+const apiValidator        = require('../lib/validator/api');
+const internalDnsProvider = require('../internal/dns-provider');
+const schema              = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * Renew every certificate issued with a DNS provider
+ *
+ * /api/dns-providers/cloudflare/reissue-all
+ */
+router
+	.route('/:provider_id/reissue-all')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/dns-providers/cloudflare/reissue-all
+	 *
+	 * Answers straight away with the jobs renewing them, see /api/jobs
+	 */
+	.post((req, res, next) => {
+		validator({
+			required:             ['provider_id'],
+			additionalProperties: false,
+			properties:           {
+				provider_id: {
+					type:    'string',
+					pattern: '^[a-z0-9_-]+$'
+				}
+			}
+		}, {
+			provider_id: req.params.provider_id
+		})
+			.then((data) => {
+				return apiValidator(schema.getValidationSchema('/dns-providers/{providerID}/reissue-all', 'post'), req.body || {})
+					.then((payload) => {
+						return internalDnsProvider.reissueAll(res.locals.access, {
+							provider:    data.provider_id,
+							credentials: payload.credentials
+						});
+					});
+			})
+			.then((result) => {
+				res.status(202)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,74 @@
+const express        = require('express');
+const jwtdecode      = require('../lib/express/jwt-decode');
+const internalEvents = require('../internal/events');
+
+// Comments sent now and then, so proxies in between don't close a quiet stream
+const HEARTBEAT_INTERVAL = 15000;
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/events/sse
+ */
+router
+	.route('/sse')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all((req, res, next) => {
+		// EventSource can't send an Authorization header
+		if (!res.locals.token && typeof req.query.token === 'string' && req.query.token) {
+			res.locals.token = req.query.token;
+		}
+		next();
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/events/sse
+	 *
+	 * The audit log and activity of hosts and certificates as Server-Sent Events,
+	 * carrying on after Last-Event-ID when a client reconnects
+	 */
+	.get((req, res, next) => {
+		const last_event_id = req.get('Last-Event-ID') || (typeof req.query.last_event_id === 'string' ? req.query.last_event_id : null);
+
+		const write = (text) => {
+			res.write(text);
+			// Sent straight away, instead of when the compression middleware has enough of it
+			if (typeof res.flush === 'function') {
+				res.flush();
+			}
+		};
+
+		internalEvents.subscribe(res.locals.access, last_event_id, (event) => {
+			write('id: ' + event.id + '\nevent: ' + event.event + '\ndata: ' + JSON.stringify(event.data) + '\n\n');
+		})
+			.then((stop) => {
+				res.status(200);
+				res.set({
+					'Content-Type':      'text/event-stream; charset=utf-8',
+					'Cache-Control':     'no-cache',
+					'Connection':        'keep-alive',
+					'X-Accel-Buffering': 'no'
+				});
+				res.flushHeaders();
+				write('retry: 3000\n\n');
+
+				const heartbeat = setInterval(() => {
+					write(': ping\n\n');
+				}, HEARTBEAT_INTERVAL);
+
+				req.on('close', () => {
+					clearInterval(heartbeat);
+					stop();
+				});
+			})
+			.catch(next);
+	});
+
+module.exports = router;
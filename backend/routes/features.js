@@ -0,0 +1,35 @@
+const express          = require('express');
+const jwtdecode        = require('../lib/express/jwt-decode');
+const internalFeatures = require('../internal/features');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/features
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/features
+	 *
+	 * The experimental features, whether this build supports them and whether they're on for the user
+	 */
+	.get((req, res, next) => {
+		internalFeatures.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
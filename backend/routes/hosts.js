@@ -0,0 +1,82 @@
+const express           = require('express');
+const validator         = require('../lib/validator');
+const jwtdecode         = require('../lib/express/jwt-decode');
+const apiValidator      = require('../lib/validator/api');
+const internalHostFull  = require('../internal/host-full');
+const internalInventory = require('../internal/inventory');
+const schema            = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/hosts/full
+ */
+router
+	.route('/full')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/hosts/full
+	 *
+	 * Create a host with a new certificate and access list for it, all or nothing
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/hosts/full', 'post'), req.body)
+			.then((payload) => {
+				req.setTimeout(900000); // 15 minutes timeout
+				return internalHostFull.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/hosts/export
+ */
+router
+	.route('/export')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/hosts/export
+	 *
+	 * Download a spreadsheet of the hosts and streams the user can see
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				format: {
+					type: 'string',
+					enum: ['csv', 'xlsx']
+				}
+			}
+		}, {
+			format: (typeof req.query.format === 'string' ? req.query.format : 'csv')
+		})
+			.then((data) => {
+				return internalInventory.export(res.locals.access, {of: 'hosts', format: data.format});
+			})
+			.then((result) => {
+				res.status(200)
+					.attachment(result.filename)
+					.type(result.content_type)
+					.send(result.body);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,61 @@
+const express      = require('express');
+const validator    = require('../lib/validator');
+const jwtdecode    = require('../lib/express/jwt-decode');
+const internalJobs = require('../internal/jobs');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * Specific job
+ *
+ * /api/jobs/abc123
+ */
+router
+	.route('/:job_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/jobs/abc123?wait=30
+	 *
+	 * The phase and progress of a job, waiting up to ?wait= seconds for it to change
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['job_id'],
+			additionalProperties: false,
+			properties:           {
+				job_id: {
+					type:    'string',
+					pattern: '^[0-9a-f]{32}$'
+				},
+				wait: {
+					type:    'integer',
+					minimum: 0,
+					maximum: 60
+				}
+			}
+		}, {
+			job_id: req.params.job_id,
+			wait:   (typeof req.query.wait === 'string' ? parseInt(req.query.wait, 10) : undefined)
+		})
+			.then((data) => {
+				return internalJobs.get(res.locals.access, {
+					id:   data.job_id,
+					wait: data.wait
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
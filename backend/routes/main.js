@@ -1,6 +1,8 @@
-const express = require('express');
-const pjson   = require('../package.json');
-const error   = require('../lib/error');
+const express       = require('express');
+const pjson         = require('../package.json');
+const config        = require('../lib/config');
+const error         = require('../lib/error');
+const internalSetup = require('../internal/setup');
 
 let router = express.Router({
 	caseSensitive: true,
@@ -13,30 +15,73 @@ let router = express.Router({
  * GET /api
  */
 router.get('/', (req, res/*, next*/) => {
-	let version = pjson.version.split('-').shift().split('.');
+	let version    = pjson.version.split('-').shift().split('.');
+	let keys_error = config.getKeysError();
 
-	res.status(200).send({
-		status:  'OK',
+	let payload = {
+		status:  keys_error === null ? 'OK' : 'DEGRADED',
+		setup:   internalSetup.getState().complete ? 'complete' : 'pending',
 		version: {
 			major:    parseInt(version.shift(), 10),
 			minor:    parseInt(version.shift(), 10),
 			revision: parseInt(version.shift(), 10)
 		}
-	});
+	};
+
+	if (keys_error !== null) {
+		payload.problems = [keys_error];
+	}
+
+	res.status(200).send(payload);
 });
 
 router.use('/schema', require('./schema'));
+router.use('/setup', require('./setup'));
 router.use('/tokens', require('./tokens'));
 router.use('/users', require('./users'));
 router.use('/audit-log', require('./audit-log'));
+router.use('/change-requests', require('./change-requests'));
+router.use('/access-requests', require('./access-requests'));
+router.use('/access-list-passwords', require('./access-list-passwords'));
 router.use('/reports', require('./reports'));
+router.use('/scheduled-changes', require('./scheduled-changes'));
+router.use('/events', require('./events'));
+router.use('/jobs', require('./jobs'));
+router.use('/metrics', require('./metrics'));
+router.use('/search', require('./search'));
+router.use('/features', require('./features'));
+router.use('/notifications', require('./notifications'));
+router.use('/presets', require('./presets'));
+router.use('/status', require('./status'));
 router.use('/settings', require('./settings'));
+router.use('/tags', require('./tags'));
+router.use('/system', require('./system'));
+router.use('/tools', require('./tools'));
+router.use('/acme-dns', require('./acme-dns'));
+router.use('/dns-providers', require('./dns-providers'));
+router.use('/tenants', require('./tenants'));
+router.use('/wireguard', require('./wireguard'));
+router.use('/plugins', require('./plugins'));
+router.use('/automation/rules', require('./automation/rules'));
+router.use('/views', require('./views'));
+router.use('/hosts', require('./hosts'));
 router.use('/nginx/proxy-hosts', require('./nginx/proxy_hosts'));
 router.use('/nginx/redirection-hosts', require('./nginx/redirection_hosts'));
 router.use('/nginx/dead-hosts', require('./nginx/dead_hosts'));
 router.use('/nginx/streams', require('./nginx/streams'));
 router.use('/nginx/access-lists', require('./nginx/access_lists'));
 router.use('/nginx/certificates', require('./nginx/certificates'));
+router.use('/nginx/acme-accounts', require('./nginx/acme_accounts'));
+router.use('/nginx/acme-eab-credentials', require('./nginx/acme_eab_credentials'));
+router.use('/nginx/projects', require('./nginx/projects'));
+router.use('/nginx/ssh-tunnels', require('./nginx/ssh_tunnels'));
+router.use('/nginx/status-pages', require('./nginx/status_pages'));
+router.use('/nginx/blueprints', require('./nginx/blueprints'));
+router.use('/nginx/host-defaults', require('./nginx/host_defaults'));
+router.use('/nginx/export', require('./nginx/export'));
+router.use('/nginx/drift', require('./nginx/drift'));
+router.use('/nginx/lint', require('./nginx/lint'));
+router.use('/nginx/connections', require('./nginx/connections'));
 
 /**
  * API 404 for all other routes
@@ -0,0 +1,60 @@
+const express         = require('express');
+const jwtdecode       = require('../lib/express/jwt-decode');
+const internalMetrics = require('../internal/metrics');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/metrics
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/metrics
+	 *
+	 * Database and error metrics for Prometheus
+	 */
+	.get((req, res, next) => {
+		internalMetrics.getText(res.locals.access)
+			.then((text) => {
+				res.status(200)
+					.type('text/plain; version=0.0.4')
+					.send(text);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/metrics/denials
+ */
+router
+	.route('/denials')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/metrics/denials
+	 *
+	 * Permissions requests were denied, by user and route
+	 */
+	.get((req, res, next) => {
+		internalMetrics.getDenials(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -1,6 +1,7 @@
 const express            = require('express');
 const validator          = require('../../lib/validator');
 const jwtdecode          = require('../../lib/express/jwt-decode');
+const dryRun             = require('../../lib/express/dry-run');
 const apiValidator       = require('../../lib/validator/api');
 const internalAccessList = require('../../internal/access-list');
 const schema             = require('../../schema');
@@ -35,14 +36,25 @@ router
 				},
 				query: {
 					$ref: 'common#/properties/query'
+				},
+				project_id: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/project_id'
+						}
+					]
 				}
 			}
 		}, {
-			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
-			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:      (typeof req.query.query === 'string' ? req.query.query : null),
+			project_id: (typeof req.query.project_id === 'string' ? parseInt(req.query.project_id, 10) : null)
 		})
 			.then((data) => {
-				return internalAccessList.getAll(res.locals.access, data.expand, data.query);
+				return internalAccessList.getAll(res.locals.access, data.expand, data.query, {project_id: data.project_id});
 			})
 			.then((rows) => {
 				res.status(200)
@@ -56,7 +68,7 @@ router
 	 *
 	 * Create a new access-list
 	 */
-	.post((req, res, next) => {
+	.post(dryRun('access-list', 'create'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/access-lists', 'post'), req.body)
 			.then((payload) => {
 				return internalAccessList.create(res.locals.access, payload);
@@ -119,7 +131,7 @@ router
 	 *
 	 * Update and existing access-list
 	 */
-	.put((req, res, next) => {
+	.put(dryRun('access-list', 'update'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/access-lists/{listID}', 'put'), req.body)
 			.then((payload) => {
 				payload.id = parseInt(req.params.list_id, 10);
@@ -137,7 +149,7 @@ router
 	 *
 	 * Delete and existing access-list
 	 */
-	.delete((req, res, next) => {
+	.delete(dryRun('access-list', 'delete'), (req, res, next) => {
 		internalAccessList.delete(res.locals.access, {id: parseInt(req.params.list_id, 10)})
 			.then((result) => {
 				res.status(200)
@@ -0,0 +1,254 @@
+const express             = require('express');
+const validator           = require('../../lib/validator');
+const jwtdecode           = require('../../lib/express/jwt-decode');
+const apiValidator        = require('../../lib/validator/api');
+const internalAcmeAccount = require('../../internal/acme-account');
+const internalActivity    = require('../../internal/activity');
+const schema              = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/acme-accounts
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/acme-accounts
+	 *
+	 * Retrieve all ACME accounts
+	 */
+	.get((req, res, next) => {
+		internalAcmeAccount.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/acme-accounts
+	 *
+	 * Register a new ACME account
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/acme-accounts', 'post'), req.body)
+			.then((payload) => {
+				return internalAcmeAccount.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * The contact of the accounts certbot registered by itself
+ *
+ * /api/nginx/acme-accounts/contact
+ */
+router
+	.route('/contact')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/acme-accounts/contact
+	 *
+	 * Change it with each CA, and for the certificates and notification channels using the old one
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/acme-accounts/contact', 'put'), req.body)
+			.then((payload) => {
+				return internalAcmeAccount.updateContact(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific ACME account
+ *
+ * /api/nginx/acme-accounts/123
+ */
+router
+	.route('/:account_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/acme-accounts/123
+	 *
+	 * Retrieve a specific ACME account
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['account_id'],
+			additionalProperties: false,
+			properties:           {
+				account_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			account_id: req.params.account_id
+		})
+			.then((data) => {
+				return internalAcmeAccount.get(res.locals.access, {
+					id: parseInt(data.account_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/acme-accounts/123
+	 *
+	 * Update an existing ACME account
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/acme-accounts/{accountID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.account_id, 10);
+				return internalAcmeAccount.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/acme-accounts/123
+	 *
+	 * Delete an existing ACME account
+	 */
+	.delete((req, res, next) => {
+		internalAcmeAccount.delete(res.locals.access, {id: parseInt(req.params.account_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Rotate the account key
+ *
+ * /api/nginx/acme-accounts/123/rotate-key
+ */
+router
+	.route('/:account_id/rotate-key')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/acme-accounts/123/rotate-key
+	 */
+	.post((req, res, next) => {
+		internalAcmeAccount.rotateKey(res.locals.access, {id: parseInt(req.params.account_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Deactivate the account with the CA
+ *
+ * /api/nginx/acme-accounts/123/deactivate
+ */
+router
+	.route('/:account_id/deactivate')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/acme-accounts/123/deactivate
+	 */
+	.post((req, res, next) => {
+		internalAcmeAccount.deactivate(res.locals.access, {id: parseInt(req.params.account_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Activity of an ACME account
+ *
+ * /api/nginx/acme-accounts/123/activity
+ */
+router
+	.route('/:account_id/activity')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/acme-accounts/123/activity
+	 *
+	 * Its audit log, ACME orders, config results and health changes in one timeline
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['account_id'],
+			additionalProperties: false,
+			properties:           {
+				account_id: {
+					$ref: 'common#/properties/id'
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 500
+				}
+			}
+		}, {
+			account_id: req.params.account_id,
+			limit:      (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalActivity.getFeed(res.locals.access, 'acme-account', {
+					id:    parseInt(data.account_id, 10),
+					limit: data.limit
+				});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
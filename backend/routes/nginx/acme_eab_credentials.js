@@ -0,0 +1,128 @@
+const express         = require('express');
+const validator       = require('../../lib/validator');
+const jwtdecode       = require('../../lib/express/jwt-decode');
+const apiValidator    = require('../../lib/validator/api');
+const internalAcmeEab = require('../../internal/acme-eab-credential');
+const schema          = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/acme-eab-credentials
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/acme-eab-credentials
+	 *
+	 * Retrieve all ACME EAB credentials
+	 */
+	.get((req, res, next) => {
+		internalAcmeEab.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/acme-eab-credentials
+	 *
+	 * Store ACME EAB credentials
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/acme-eab-credentials', 'post'), req.body)
+			.then((payload) => {
+				return internalAcmeEab.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific ACME EAB credentials
+ *
+ * /api/nginx/acme-eab-credentials/123
+ */
+router
+	.route('/:credential_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/acme-eab-credentials/123
+	 *
+	 * Retrieve specific ACME EAB credentials
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['credential_id'],
+			additionalProperties: false,
+			properties:           {
+				credential_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			credential_id: req.params.credential_id
+		})
+			.then((data) => {
+				return internalAcmeEab.get(res.locals.access, {
+					id: parseInt(data.credential_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/acme-eab-credentials/123
+	 *
+	 * Update existing ACME EAB credentials
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/acme-eab-credentials/{credentialID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.credential_id, 10);
+				return internalAcmeEab.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/acme-eab-credentials/123
+	 *
+	 * Delete existing ACME EAB credentials
+	 */
+	.delete((req, res, next) => {
+		internalAcmeEab.delete(res.locals.access, {id: parseInt(req.params.credential_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,198 @@
+const express               = require('express');
+const validator             = require('../../lib/validator');
+const jwtdecode             = require('../../lib/express/jwt-decode');
+const apiValidator          = require('../../lib/validator/api');
+const internalBlueprint     = require('../../internal/blueprint');
+const internalProxyHost     = require('../../internal/proxy-host');
+const internalChangeRequest = require('../../internal/change-request');
+const schema                = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/blueprints
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/blueprints
+	 *
+	 * Retrieve all blueprints
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				},
+				query: {
+					$ref: 'common#/properties/query'
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+		})
+			.then((data) => {
+				return internalBlueprint.getAll(res.locals.access, data.expand, data.query);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/blueprints
+	 *
+	 * Create a new blueprint
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/blueprints', 'post'), req.body)
+			.then((payload) => {
+				return internalBlueprint.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific blueprint
+ *
+ * /api/nginx/blueprints/123
+ */
+router
+	.route('/:blueprint_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/blueprints/123
+	 *
+	 * Retrieve a specific blueprint
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['blueprint_id'],
+			additionalProperties: false,
+			properties:           {
+				blueprint_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			blueprint_id: req.params.blueprint_id,
+			expand:       (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalBlueprint.get(res.locals.access, {
+					id:     parseInt(data.blueprint_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/blueprints/123
+	 *
+	 * Update an existing blueprint
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/blueprints/{blueprintID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.blueprint_id, 10);
+				return internalBlueprint.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/blueprints/123
+	 *
+	 * Delete an existing blueprint
+	 */
+	.delete((req, res, next) => {
+		internalBlueprint.delete(res.locals.access, {id: parseInt(req.params.blueprint_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Hosts made from a blueprint
+ *
+ * /api/nginx/blueprints/123/hosts
+ */
+router
+	.route('/:blueprint_id/hosts')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/blueprints/123/hosts
+	 *
+	 * Create a new proxy host from a blueprint. It's proposed as a change request
+	 * instead when the user's changes need approval, like any other new proxy host.
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/blueprints/{blueprintID}/hosts', 'post'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.blueprint_id, 10);
+				return internalBlueprint.getHost(res.locals.access, payload);
+			})
+			.then((host) => {
+				// The placeholders could have been filled in with anything
+				return apiValidator(schema.getValidationSchema('/nginx/proxy-hosts', 'post'), host);
+			})
+			.then((host) => {
+				return internalChangeRequest.submit(res.locals.access, 'proxy-host', 'create', {}, host)
+					.then((change_request) => {
+						if (change_request) {
+							res.status(202)
+								.send(change_request);
+							return;
+						}
+
+						return internalProxyHost.create(res.locals.access, host)
+							.then((result) => {
+								res.status(201)
+									.send(result);
+							});
+					});
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -1,10 +1,25 @@
-const express             = require('express');
-const error               = require('../../lib/error');
-const validator           = require('../../lib/validator');
-const jwtdecode           = require('../../lib/express/jwt-decode');
-const apiValidator        = require('../../lib/validator/api');
-const internalCertificate = require('../../internal/certificate');
-const schema              = require('../../schema');
+const express               = require('express');
+const error                 = require('../../lib/error');
+const validator             = require('../../lib/validator');
+const jwtdecode             = require('../../lib/express/jwt-decode');
+const dryRun                = require('../../lib/express/dry-run');
+const schedule              = require('../../lib/express/schedule');
+const changeRequest         = require('../../lib/express/change-request');
+const apiValidator          = require('../../lib/validator/api');
+const internalCertificate   = require('../../internal/certificate');
+const internalView          = require('../../internal/view');
+const internalCa            = require('../../internal/ca');
+const internalCtMonitor     = require('../../internal/ct-monitor');
+const internalAcmeRateLimit = require('../../internal/acme-rate-limit');
+const internalLock          = require('../../internal/lock');
+const internalActivity      = require('../../internal/activity');
+const internalJobs          = require('../../internal/jobs');
+const internalCertOptimize  = require('../../internal/certificate-optimize');
+const internalCertPins      = require('../../internal/certificate-pins');
+const internalCertTlsa      = require('../../internal/certificate-tlsa');
+const internalChangeRequest = require('../../internal/change-request');
+const internalInventory     = require('../../internal/inventory');
+const schema                = require('../../schema');
 
 let router = express.Router({
 	caseSensitive: true,
@@ -12,6 +27,18 @@ let router = express.Router({
 	mergeParams:   true
 });
 
+/**
+ * Whether the client asked for a job to follow instead of waiting for certbot, which is
+ * always the case from version 2 of the API
+ *
+ * @param   {Object}  req
+ * @param   {Object}  res
+ * @returns {Boolean}
+ */
+const isAsync = (req, res) => {
+	return res.locals.api_version >= 2 || req.query.async === 'true' || req.query.async === '1';
+};
+
 /**
  * /api/nginx/certificates
  */
@@ -36,14 +63,50 @@ router
 				},
 				query: {
 					$ref: 'common#/properties/query'
+				},
+				project_id: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/project_id'
+						}
+					]
+				},
+				tag: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/tags'
+						}
+					]
+				},
+				view: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/id'
+						}
+					]
 				}
 			}
 		}, {
-			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
-			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:      (typeof req.query.query === 'string' ? req.query.query : null),
+			project_id: (typeof req.query.project_id === 'string' ? parseInt(req.query.project_id, 10) : null),
+			tag:        (typeof req.query.tag === 'string' ? req.query.tag.split(',') : null),
+			view:       (typeof req.query.view === 'string' ? parseInt(req.query.view, 10) : null)
 		})
 			.then((data) => {
-				return internalCertificate.getAll(res.locals.access, data.expand, data.query);
+				return internalView.getListOptions(res.locals.access, 'certificate', data);
+			})
+			.then((list) => {
+				return internalCertificate.getAll(res.locals.access, list.expand, list.query, list.filter);
 			})
 			.then((rows) => {
 				res.status(200)
@@ -55,17 +118,25 @@ router
 	/**
 	 * POST /api/nginx/certificates
 	 *
-	 * Create a new certificate
+	 * Create a new certificate. With ?async=true, or in version 2, the answer is a job to follow it with instead.
 	 */
-	.post((req, res, next) => {
+	.post(dryRun('certificate', 'create'), changeRequest('certificate', 'create'), schedule('certificate', 'create'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/certificates', 'post'), req.body)
 			.then((payload) => {
+				if (isAsync(req, res)) {
+					res.status(202)
+						.send(internalJobs.start(res.locals.access, 'certificate-create', (progress) => {
+							return internalCertificate.create(res.locals.access, payload, progress);
+						}));
+					return;
+				}
+
 				req.setTimeout(900000); // 15 minutes timeout
-				return internalCertificate.create(res.locals.access, payload);
-			})
-			.then((result) => {
-				res.status(201)
-					.send(result);
+				return internalCertificate.create(res.locals.access, payload)
+					.then((result) => {
+						res.status(201)
+							.send(result);
+					});
 			})
 			.catch(next);
 	});
@@ -101,6 +172,300 @@ router
 			.catch(next);
 	});
 
+/**
+ * Certificates issued by the internal CA
+ *
+ * /api/nginx/certificates/internal
+ */
+router
+	.route('/internal')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/internal
+	 *
+	 * Issue a new certificate from the internal CA
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/internal', 'post'), req.body)
+			.then((payload) => {
+				payload.provider = 'internal';
+				return internalCertificate.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * The internal CA
+ *
+ * /api/nginx/certificates/internal/ca
+ */
+router
+	.route('/internal/ca')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/internal/ca
+	 */
+	.get((req, res, next) => {
+		internalCa.getInfo(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/certificates/internal/ca
+	 *
+	 * Generate a new CA or import an existing one
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/internal/ca', 'post'), req.body)
+			.then((payload) => {
+				return internalCa.setup(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Download the internal root CA, for installing in browsers and devices
+ *
+ * /api/nginx/certificates/internal/ca/download
+ */
+router
+	.route('/internal/ca/download')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/internal/ca/download
+	 */
+	.get((req, res, next) => {
+		internalCa.getRootFile(res.locals.access)
+			.then((file) => {
+				res.status(200)
+					.download(file, 'npm-root-ca.crt');
+			})
+			.catch(next);
+	});
+
+/**
+ * Remaining Let's Encrypt rate limit budget
+ *
+ * /api/nginx/certificates/rate-limits
+ */
+router
+	.route('/rate-limits')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/rate-limits
+	 */
+	.get((req, res, next) => {
+		internalAcmeRateLimit.getStatus(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Wildcard certificates that could replace others
+ *
+ * /api/nginx/certificates/optimize
+ */
+router
+	.route('/optimize')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/optimize
+	 */
+	.get((req, res, next) => {
+		internalCertOptimize.getSuggestions(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/certificates/optimize
+	 *
+	 * Carry out the suggestion for a domain, as a job to follow
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/optimize', 'post'), req.body)
+			.then((payload) => {
+				return internalChangeRequest.isRequired(res.locals.access)
+					.then((required) => {
+						if (required) {
+							throw new error.PermissionError('Changes need to be approved, so the hosts can\'t be moved in one go');
+						}
+						return internalCertOptimize.getSuggestion(res.locals.access, payload.domain);
+					})
+					.then((suggestion) => {
+						res.status(202)
+							.send(internalJobs.start(res.locals.access, 'certificate-optimize', (progress) => {
+								return internalCertOptimize.apply(res.locals.access, suggestion, payload, progress);
+							}));
+					});
+			})
+			.catch(next);
+	});
+
+/**
+ * Targets of deploy hooks
+ *
+ * /api/nginx/certificates/deploy-targets
+ */
+router
+	.route('/deploy-targets')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/deploy-targets
+	 */
+	.get((req, res, next) => {
+		internalCertificate.getDeployTargets(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Certificate Transparency log entries for managed domains
+ *
+ * /api/nginx/certificates/transparency
+ */
+router
+	.route('/transparency')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/transparency
+	 *
+	 * Retrieve the CT log entries seen so far
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				unknown: {
+					type: 'boolean'
+				}
+			}
+		}, {
+			unknown: (typeof req.query.unknown === 'string' ? req.query.unknown : false)
+		})
+			.then((data) => {
+				return internalCtMonitor.getAll(res.locals.access, data);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/nginx/certificates/export
+ */
+router
+	.route('/export')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/export
+	 *
+	 * Download a spreadsheet of the certificates the user can see
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				format: {
+					type: 'string',
+					enum: ['csv', 'xlsx']
+				}
+			}
+		}, {
+			format: (typeof req.query.format === 'string' ? req.query.format : 'csv')
+		})
+			.then((data) => {
+				return internalInventory.export(res.locals.access, {of: 'certificates', format: data.format});
+			})
+			.then((result) => {
+				res.status(200)
+					.attachment(result.filename)
+					.type(result.content_type)
+					.send(result.body);
+			})
+			.catch(next);
+	});
+
+/**
+ * Check the Certificate Transparency logs now
+ *
+ * /api/nginx/certificates/transparency/check
+ */
+router
+	.route('/transparency/check')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/transparency/check
+	 */
+	.post((req, res, next) => {
+		req.setTimeout(900000); // 15 minutes timeout
+		internalCtMonitor.check(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(!!result);
+			})
+			.catch(next);
+	});
+
 /**
  * Specific certificate
  *
@@ -152,7 +517,7 @@ router
 	 *
 	 * Update and existing certificate
 	 */
-	.delete((req, res, next) => {
+	.delete(dryRun('certificate', 'delete'), changeRequest('certificate', 'delete'), schedule('certificate', 'delete'), (req, res, next) => {
 		internalCertificate.delete(res.locals.access, {id: parseInt(req.params.certificate_id, 10)})
 			.then((result) => {
 				res.status(200)
@@ -210,11 +575,167 @@ router
 	/**
 	 * POST /api/nginx/certificates/123/renew
 	 *
-	 * Renew certificate
+	 * Renew certificate. With ?async=true, or in version 2, the answer is a job to follow it with instead.
 	 */
 	.post((req, res, next) => {
+		const data = {
+			id: parseInt(req.params.certificate_id, 10)
+		};
+
+		if (isAsync(req, res)) {
+			res.status(202)
+				.send(internalJobs.start(res.locals.access, 'certificate-renew', (progress) => {
+					return internalCertificate.renew(res.locals.access, data, progress);
+				}));
+			return;
+		}
+
 		req.setTimeout(900000); // 15 minutes timeout
-		internalCertificate.renew(res.locals.access, {
+		internalCertificate.renew(res.locals.access, data)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Promote staging LE Certs to production
+ *
+ * /api/nginx/certificates/123/promote
+ */
+router
+	.route('/:certificate_id/promote')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/123/promote
+	 *
+	 * Reissue a staging certificate from the production CA
+	 */
+	.post((req, res, next) => {
+		req.setTimeout(900000); // 15 minutes timeout
+		internalCertificate.promote(res.locals.access, {
+			id: parseInt(req.params.certificate_id, 10)
+		})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Deploy hooks of a certificate
+ *
+ * /api/nginx/certificates/123/deploy-hooks
+ */
+router
+	.route('/:certificate_id/deploy-hooks')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/certificates/123/deploy-hooks
+	 *
+	 * Replace the deploy hooks of a certificate
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/{certID}/deploy-hooks', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.certificate_id, 10);
+				return internalCertificate.setDeployHooks(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Renewal retry policy of a certificate
+ *
+ * /api/nginx/certificates/123/renewal-retry
+ */
+router
+	.route('/:certificate_id/renewal-retry')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/certificates/123/renewal-retry
+	 *
+	 * Replace the renewal retry policy of a certificate
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/{certID}/renewal-retry', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.certificate_id, 10);
+				return internalCertificate.setRenewalRetry(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Keep or rotate the key of a certificate on renewal
+ *
+ * /api/nginx/certificates/123/reuse-key
+ */
+router
+	.route('/:certificate_id/reuse-key')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/certificates/123/reuse-key
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/{certID}/reuse-key', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.certificate_id, 10);
+				return internalCertificate.setReuseKey(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Deploy a certificate
+ *
+ * /api/nginx/certificates/123/deploy
+ */
+router
+	.route('/:certificate_id/deploy')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/123/deploy
+	 *
+	 * Run the deploy hooks of a certificate now
+	 */
+	.post((req, res, next) => {
+		req.setTimeout(900000); // 15 minutes timeout
+		internalCertificate.deploy(res.locals.access, {
 			id: parseInt(req.params.certificate_id, 10)
 		})
 			.then((result) => {
@@ -252,6 +773,111 @@ router
 			.catch(next);
 	});
 
+/**
+ * SPKI pins of a certificate, for apps that pin them
+ *
+ * /api/nginx/certificates/123/pins
+ */
+router
+	.route('/:certificate_id/pins')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/123/pins
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['certificate_id'],
+			additionalProperties: false,
+			properties:           {
+				certificate_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			certificate_id: req.params.certificate_id
+		})
+			.then((data) => {
+				return internalCertPins.getPins(res.locals.access, {id: parseInt(data.certificate_id, 10)});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * DANE TLSA records of a certificate
+ *
+ * /api/nginx/certificates/123/tlsa
+ */
+router
+	.route('/:certificate_id/tlsa')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/123/tlsa
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['certificate_id'],
+			additionalProperties: false,
+			properties:           {
+				certificate_id: {
+					$ref: 'common#/properties/id'
+				},
+				port: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 65535
+				},
+				protocol: {
+					type: 'string',
+					enum: ['tcp', 'udp', 'sctp']
+				}
+			}
+		}, {
+			certificate_id: req.params.certificate_id,
+			port:           (typeof req.query.port === 'string' ? parseInt(req.query.port, 10) : undefined),
+			protocol:       (typeof req.query.protocol === 'string' ? req.query.protocol : undefined)
+		})
+			.then((data) => {
+				return internalCertTlsa.get(res.locals.access, {
+					id:       parseInt(data.certificate_id, 10),
+					port:     data.port,
+					protocol: data.protocol
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/certificates/123/tlsa
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/certificates/{certID}/tlsa', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.certificate_id, 10);
+				return internalCertificate.setTlsa(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
 /**
  * Validate Certs before saving
  *
@@ -285,4 +911,100 @@ router
 		}
 	});
 
+/**
+ * Lock certificate
+ *
+ * /api/nginx/certificates/123/lock
+ */
+router
+	.route('/:certificate_id/lock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/123/lock
+	 */
+	.post((req, res, next) => {
+		internalLock.lock(res.locals.access, 'certificate', {id: parseInt(req.params.certificate_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Unlock certificate
+ *
+ * /api/nginx/certificates/123/unlock
+ */
+router
+	.route('/:certificate_id/unlock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/certificates/123/unlock
+	 */
+	.post((req, res, next) => {
+		internalLock.unlock(res.locals.access, 'certificate', {id: parseInt(req.params.certificate_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Activity of a certificate
+ *
+ * /api/nginx/certificates/123/activity
+ */
+router
+	.route('/:certificate_id/activity')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/certificates/123/activity
+	 *
+	 * Its audit log, ACME orders, config results and health changes in one timeline
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['certificate_id'],
+			additionalProperties: false,
+			properties:           {
+				certificate_id: {
+					$ref: 'common#/properties/id'
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 500
+				}
+			}
+		}, {
+			certificate_id: req.params.certificate_id,
+			limit:          (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalActivity.getFeed(res.locals.access, 'certificate', {
+					id:    parseInt(data.certificate_id, 10),
+					limit: data.limit
+				});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
 module.exports = router;
@@ -0,0 +1,85 @@
+const express             = require('express');
+const validator           = require('../../lib/validator');
+const jwtdecode           = require('../../lib/express/jwt-decode');
+const apiValidator        = require('../../lib/validator/api');
+const internalConnections = require('../../internal/connections');
+const schema              = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/connections
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/connections
+	 *
+	 * Open connections of nginx, and the hosts and clients recent requests were for
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				seconds: {
+					type:    'integer',
+					minimum: 5,
+					maximum: 900
+				},
+				clients: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 100
+				}
+			}
+		}, {
+			seconds: (typeof req.query.seconds === 'string' ? parseInt(req.query.seconds, 10) : undefined),
+			clients: (typeof req.query.clients === 'string' ? parseInt(req.query.clients, 10) : undefined)
+		})
+			.then((data) => {
+				return internalConnections.get(res.locals.access, data);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/nginx/connections/block
+ */
+router
+	.route('/block')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/connections/block
+	 *
+	 * Refuse a client on every host
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/connections/block', 'post'), req.body)
+			.then((payload) => {
+				return internalConnections.block(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
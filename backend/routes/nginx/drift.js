@@ -0,0 +1,91 @@
+const express       = require('express');
+const jwtdecode     = require('../../lib/express/jwt-decode');
+const apiValidator  = require('../../lib/validator/api');
+const internalDrift = require('../../internal/drift');
+const schema        = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/drift
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/drift
+	 *
+	 * Retrieve the config files that differ from what would be written for them
+	 */
+	.get((_, res, next) => {
+		internalDrift.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/nginx/drift/rerender
+ */
+router
+	.route('/rerender')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/drift/rerender
+	 *
+	 * Overwrite a config file with what would be written for it
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/drift/rerender', 'post'), req.body)
+			.then((payload) => {
+				return internalDrift.rerender(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/nginx/drift/adopt
+ */
+router
+	.route('/adopt')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/drift/adopt
+	 *
+	 * Move lines added to a config file by hand into the advanced config of the host
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/drift/adopt', 'post'), req.body)
+			.then((payload) => {
+				return internalDrift.adopt(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,55 @@
+const express        = require('express');
+const validator      = require('../../lib/validator');
+const jwtdecode      = require('../../lib/express/jwt-decode');
+const internalExport = require('../../internal/export');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/export
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/export
+	 *
+	 * Download the generated nginx configuration and the files it refers to
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				redact_keys: {
+					type: 'boolean'
+				}
+			}
+		}, {
+			redact_keys: (typeof req.query.redact_keys === 'string' ? req.query.redact_keys : false)
+		})
+			.then((data) => {
+				return internalExport.getNginxBundle(res.locals.access, data, () => {
+					res.status(200)
+						.attachment('npm-export-' + Date.now() + '.tar.gz');
+					return res;
+				});
+			})
+			.catch((err) => {
+				// Part of the bundle was sent already
+				if (res.headersSent) {
+					res.destroy(err);
+					return;
+				}
+				next(err);
+			});
+	});
+
+module.exports = router;
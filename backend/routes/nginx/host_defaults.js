@@ -0,0 +1,35 @@
+const express              = require('express');
+const jwtdecode            = require('../../lib/express/jwt-decode');
+const internalHostDefaults = require('../../internal/host-defaults');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/host-defaults
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/host-defaults
+	 *
+	 * What new hosts of each type start with
+	 */
+	.get((_, res, next) => {
+		internalHostDefaults.getAll(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,35 @@
+const express      = require('express');
+const jwtdecode    = require('../../lib/express/jwt-decode');
+const internalLint = require('../../internal/lint');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/lint
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/lint
+	 *
+	 * Check the generated config files for mistakes nginx's own test doesn't report
+	 */
+	.get((_, res, next) => {
+		internalLint.lint(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,179 @@
+const express         = require('express');
+const validator       = require('../../lib/validator');
+const jwtdecode       = require('../../lib/express/jwt-decode');
+const apiValidator    = require('../../lib/validator/api');
+const internalProject = require('../../internal/project');
+const schema          = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/projects
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/projects
+	 *
+	 * Retrieve all projects
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				},
+				query: {
+					$ref: 'common#/properties/query'
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+		})
+			.then((data) => {
+				return internalProject.getAll(res.locals.access, data.expand, data.query);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/projects
+	 *
+	 * Create a new project
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/projects', 'post'), req.body)
+			.then((payload) => {
+				return internalProject.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific project
+ *
+ * /api/nginx/projects/123
+ */
+router
+	.route('/:project_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/projects/123
+	 *
+	 * Retrieve a specific project
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['project_id'],
+			additionalProperties: false,
+			properties:           {
+				project_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			project_id: req.params.project_id,
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalProject.get(res.locals.access, {
+					id:     parseInt(data.project_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/projects/123
+	 *
+	 * Update an existing project
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/projects/{projectID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.project_id, 10);
+				return internalProject.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/projects/123
+	 *
+	 * Delete an existing project
+	 */
+	.delete((req, res, next) => {
+		internalProject.delete(res.locals.access, {id: parseInt(req.params.project_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Users granted access to a project
+ *
+ * /api/nginx/projects/123/permissions
+ */
+router
+	.route('/:project_id/permissions')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/projects/123/permissions
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/projects/{projectID}/permissions', 'put'), req.body)
+			.then((payload) => {
+				return internalProject.setPermissions(res.locals.access, {
+					id:       parseInt(req.params.project_id, 10),
+					user_ids: payload.user_ids
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -1,9 +1,24 @@
-const express           = require('express');
-const validator         = require('../../lib/validator');
-const jwtdecode         = require('../../lib/express/jwt-decode');
-const apiValidator      = require('../../lib/validator/api');
-const internalProxyHost = require('../../internal/proxy-host');
-const schema            = require('../../schema');
+const express                = require('express');
+const validator              = require('../../lib/validator');
+const jwtdecode              = require('../../lib/express/jwt-decode');
+const dryRun                 = require('../../lib/express/dry-run');
+const jsonLines              = require('../../lib/express/json-lines');
+const schedule               = require('../../lib/express/schedule');
+const changeRequest          = require('../../lib/express/change-request');
+const apiValidator           = require('../../lib/validator/api');
+const internalProxyHost      = require('../../internal/proxy-host');
+const internalView           = require('../../internal/view');
+const internalLock           = require('../../internal/lock');
+const internalAnalytics      = require('../../internal/analytics');
+const internalHostUsage      = require('../../internal/host-usage');
+const internalLatency        = require('../../internal/latency');
+const internalTlsScan        = require('../../internal/tls-scan');
+const internalDiagnose       = require('../../internal/diagnose');
+const internalUpstreamSwitch = require('../../internal/upstream-switch');
+const internalSecurityReport = require('../../internal/security-report');
+const internalActivity       = require('../../internal/activity');
+const internalGuestLink      = require('../../internal/guest-link');
+const schema                 = require('../../schema');
 
 let router = express.Router({
 	caseSensitive: true,
@@ -35,18 +50,60 @@ router
 				},
 				query: {
 					$ref: 'common#/properties/query'
+				},
+				project_id: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/project_id'
+						}
+					]
+				},
+				tag: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/tags'
+						}
+					]
+				},
+				view: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/id'
+						}
+					]
 				}
 			}
 		}, {
-			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
-			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:      (typeof req.query.query === 'string' ? req.query.query : null),
+			project_id: (typeof req.query.project_id === 'string' ? parseInt(req.query.project_id, 10) : null),
+			tag:        (typeof req.query.tag === 'string' ? req.query.tag.split(',') : null),
+			view:       (typeof req.query.view === 'string' ? parseInt(req.query.view, 10) : null)
 		})
 			.then((data) => {
-				return internalProxyHost.getAll(res.locals.access, data.expand, data.query);
+				return internalView.getListOptions(res.locals.access, 'proxy-host', data);
 			})
-			.then((rows) => {
-				res.status(200)
-					.send(rows);
+			.then((list) => {
+				if (jsonLines.wanted(req)) {
+					return jsonLines.send(res, (write) => {
+						return internalProxyHost.getAll(res.locals.access, list.expand, list.query, list.filter, write);
+					});
+				}
+
+				return internalProxyHost.getAll(res.locals.access, list.expand, list.query, list.filter)
+					.then((rows) => {
+						res.status(200)
+							.send(rows);
+					});
 			})
 			.catch(next);
 	})
@@ -56,7 +113,7 @@ router
 	 *
 	 * Create a new proxy-host
 	 */
-	.post((req, res, next) => {
+	.post(dryRun('proxy-host', 'create'), changeRequest('proxy-host', 'create'), schedule('proxy-host', 'create'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts', 'post'), req.body)
 			.then((payload) => {
 				return internalProxyHost.create(res.locals.access, payload);
@@ -119,7 +176,7 @@ router
 	 *
 	 * Update and existing proxy-host
 	 */
-	.put((req, res, next) => {
+	.put(dryRun('proxy-host', 'update'), changeRequest('proxy-host', 'update'), schedule('proxy-host', 'update'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}', 'put'), req.body)
 			.then((payload) => {
 				payload.id = parseInt(req.params.host_id, 10);
@@ -137,8 +194,23 @@ router
 	 *
 	 * Update and existing proxy-host
 	 */
-	.delete((req, res, next) => {
-		internalProxyHost.delete(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+	.delete(dryRun('proxy-host', 'delete'), changeRequest('proxy-host', 'delete'), schedule('proxy-host', 'delete'), (req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				drain: {
+					$ref: 'common#/properties/drain'
+				}
+			}
+		}, {
+			drain: (typeof req.query.drain === 'string' ? parseInt(req.query.drain, 10) : undefined)
+		})
+			.then((data) => {
+				return internalProxyHost.delete(res.locals.access, {
+					id:    parseInt(req.params.host_id, 10),
+					drain: data.drain
+				});
+			})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -162,7 +234,10 @@ router
 	 * POST /api/nginx/proxy-hosts/123/enable
 	 */
 	.post((req, res, next) => {
-		internalProxyHost.enable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		internalProxyHost.enable(res.locals.access, {
+			id:     parseInt(req.params.host_id, 10),
+			reason: req.body.reason
+		})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -186,7 +261,567 @@ router
 	 * POST /api/nginx/proxy-hosts/123/disable
 	 */
 	.post((req, res, next) => {
-		internalProxyHost.disable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		validator({
+			additionalProperties: false,
+			properties:           {
+				drain: {
+					$ref: 'common#/properties/drain'
+				}
+			}
+		}, {
+			drain: (typeof req.query.drain === 'string' ? parseInt(req.query.drain, 10) : undefined)
+		})
+			.then((data) => {
+				return internalProxyHost.disable(res.locals.access, {
+					id:     parseInt(req.params.host_id, 10),
+					drain:  data.drain,
+					reason: req.body.reason
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Lock proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/lock
+ */
+router
+	.route('/:host_id/lock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/lock
+	 */
+	.post((req, res, next) => {
+		internalLock.lock(res.locals.access, 'proxy-host', {id: parseInt(req.params.host_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Unlock proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/unlock
+ */
+router
+	.route('/:host_id/unlock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/unlock
+	 */
+	.post((req, res, next) => {
+		internalLock.unlock(res.locals.access, 'proxy-host', {id: parseInt(req.params.host_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Upload the CA bundle for the forward host
+ *
+ * /api/nginx/proxy-hosts/123/upstream-ca
+ */
+router
+	.route('/:host_id/upstream-ca')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/upstream-ca
+	 */
+	.post((req, res, next) => {
+		if (!req.files) {
+			res.status(400)
+				.send({error: 'No files were uploaded'});
+		} else {
+			internalProxyHost.uploadUpstreamCa(res.locals.access, {
+				id:    parseInt(req.params.host_id, 10),
+				files: req.files
+			})
+				.then((result) => {
+					res.status(200)
+						.send(result);
+				})
+				.catch(next);
+		}
+	});
+
+/**
+ * Analytics of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/analytics
+ */
+router
+	.route('/:host_id/analytics')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/analytics
+	 *
+	 * Top clients, countries, networks, bots and referrers of the host
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				days: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 365
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 100
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			days:    (typeof req.query.days === 'string' ? parseInt(req.query.days, 10) : undefined),
+			limit:   (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalAnalytics.getReport(res.locals.access, 'proxy-host', {
+					id:    parseInt(data.host_id, 10),
+					days:  data.days,
+					limit: data.limit
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Usage of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/usage
+ */
+router
+	.route('/:host_id/usage')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/usage
+	 *
+	 * Requests and bytes the host served each month, and the limits it reached
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				months: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 36
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			months:  (typeof req.query.months === 'string' ? parseInt(req.query.months, 10) : undefined)
+		})
+			.then((data) => {
+				return internalHostUsage.getUsage(res.locals.access, 'proxy-host', {
+					id:     parseInt(data.host_id, 10),
+					months: data.months
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Latency of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/latency
+ */
+router
+	.route('/:host_id/latency')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/latency
+	 *
+	 * Percentiles of the time taken by requests to the host, overall and per location
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				minutes: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 10080
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			minutes: (typeof req.query.minutes === 'string' ? parseInt(req.query.minutes, 10) : undefined)
+		})
+			.then((data) => {
+				return internalLatency.getReport(res.locals.access, {
+					id:      parseInt(data.host_id, 10),
+					minutes: data.minutes
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * TLS scan of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/tls-scan
+ */
+router
+	.route('/:host_id/tls-scan')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/tls-scan
+	 *
+	 * Connects to the host and reports on its protocols, ciphers, certificate chain and HSTS
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}/tls-scan', 'post'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.host_id, 10);
+				return internalTlsScan.scan(res.locals.access, 'proxy-host', payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Troubleshoot the connection to the forward host of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/diagnose
+ */
+router
+	.route('/:host_id/diagnose')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/diagnose
+	 *
+	 * Resolves, connects to and requests from the forward host like nginx would, one step at a time
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}/diagnose', 'post'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.host_id, 10);
+				return internalDiagnose.diagnose(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Security report of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/security-report
+ */
+router
+	.route('/:host_id/security-report')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/security-report
+	 *
+	 * Grades the host against a checklist, from its config and a request to it
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				probe: {
+					type: 'boolean'
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			probe:   (typeof req.query.probe === 'string' ? req.query.probe !== 'false' && req.query.probe !== '0' : undefined)
+		})
+			.then((data) => {
+				return internalSecurityReport.getReport(res.locals.access, {
+					id:    parseInt(data.host_id, 10),
+					probe: data.probe
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Traffic split of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/traffic-split
+ */
+router
+	.route('/:host_id/traffic-split')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * PUT /api/nginx/proxy-hosts/123/traffic-split
+	 *
+	 * Changes the weight of the canary, or how the traffic is split, keeping the rest of the host
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}/traffic-split', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.host_id, 10);
+				return internalProxyHost.setTrafficSplit(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Blue/green switch of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/switch-upstream
+ */
+router
+	.route('/:host_id/switch-upstream')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/switch-upstream
+	 *
+	 * Points the host at its other forward host, once it answers
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}/switch-upstream', 'post'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.host_id, 10);
+				return internalUpstreamSwitch.switch(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Activity of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/activity
+ */
+router
+	.route('/:host_id/activity')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/activity
+	 *
+	 * Its audit log, ACME orders, config results and health changes in one timeline
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 500
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			limit:   (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalActivity.getFeed(res.locals.access, 'proxy-host', {
+					id:    parseInt(data.host_id, 10),
+					limit: data.limit
+				});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * Guest links of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/guest-links
+ */
+router
+	.route('/:host_id/guest-links')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/proxy-hosts/123/guest-links
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			host_id: req.params.host_id
+		})
+			.then((data) => {
+				return internalGuestLink.getAll(res.locals.access, {host_id: parseInt(data.host_id, 10)});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/proxy-hosts/123/guest-links
+	 *
+	 * A link that gets past the access list of the host until it expires
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/proxy-hosts/{hostID}/guest-links', 'post'), req.body)
+			.then((payload) => {
+				payload.host_id = parseInt(req.params.host_id, 10);
+				return internalGuestLink.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific guest link of a proxy-host
+ *
+ * /api/nginx/proxy-hosts/123/guest-links/2
+ */
+router
+	.route('/:host_id/guest-links/:link_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * DELETE /api/nginx/proxy-hosts/123/guest-links/2
+	 */
+	.delete((req, res, next) => {
+		validator({
+			required:             ['host_id', 'link_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				link_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			link_id: req.params.link_id
+		})
+			.then((data) => {
+				return internalGuestLink.delete(res.locals.access, {
+					host_id: parseInt(data.host_id, 10),
+					id:      parseInt(data.link_id, 10)
+				});
+			})
 			.then((result) => {
 				res.status(200)
 					.send(result);
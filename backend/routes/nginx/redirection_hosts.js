@@ -1,8 +1,18 @@
 const express                 = require('express');
 const validator               = require('../../lib/validator');
 const jwtdecode               = require('../../lib/express/jwt-decode');
+const dryRun                  = require('../../lib/express/dry-run');
+const jsonLines               = require('../../lib/express/json-lines');
+const schedule                = require('../../lib/express/schedule');
+const changeRequest           = require('../../lib/express/change-request');
 const apiValidator            = require('../../lib/validator/api');
 const internalRedirectionHost = require('../../internal/redirection-host');
+const internalView            = require('../../internal/view');
+const internalLock            = require('../../internal/lock');
+const internalAnalytics       = require('../../internal/analytics');
+const internalHostUsage       = require('../../internal/host-usage');
+const internalTlsScan         = require('../../internal/tls-scan');
+const internalActivity        = require('../../internal/activity');
 const schema                  = require('../../schema');
 
 let router = express.Router({
@@ -35,18 +45,60 @@ router
 				},
 				query: {
 					$ref: 'common#/properties/query'
+				},
+				project_id: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/project_id'
+						}
+					]
+				},
+				tag: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/tags'
+						}
+					]
+				},
+				view: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/id'
+						}
+					]
 				}
 			}
 		}, {
-			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
-			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:      (typeof req.query.query === 'string' ? req.query.query : null),
+			project_id: (typeof req.query.project_id === 'string' ? parseInt(req.query.project_id, 10) : null),
+			tag:        (typeof req.query.tag === 'string' ? req.query.tag.split(',') : null),
+			view:       (typeof req.query.view === 'string' ? parseInt(req.query.view, 10) : null)
 		})
 			.then((data) => {
-				return internalRedirectionHost.getAll(res.locals.access, data.expand, data.query);
+				return internalView.getListOptions(res.locals.access, 'redirection-host', data);
 			})
-			.then((rows) => {
-				res.status(200)
-					.send(rows);
+			.then((list) => {
+				if (jsonLines.wanted(req)) {
+					return jsonLines.send(res, (write) => {
+						return internalRedirectionHost.getAll(res.locals.access, list.expand, list.query, list.filter, write);
+					});
+				}
+
+				return internalRedirectionHost.getAll(res.locals.access, list.expand, list.query, list.filter)
+					.then((rows) => {
+						res.status(200)
+							.send(rows);
+					});
 			})
 			.catch(next);
 	})
@@ -56,7 +108,7 @@ router
 	 *
 	 * Create a new redirection-host
 	 */
-	.post((req, res, next) => {
+	.post(dryRun('redirection-host', 'create'), changeRequest('redirection-host', 'create'), schedule('redirection-host', 'create'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/redirection-hosts', 'post'), req.body)
 			.then((payload) => {
 				return internalRedirectionHost.create(res.locals.access, payload);
@@ -119,7 +171,7 @@ router
 	 *
 	 * Update and existing redirection-host
 	 */
-	.put((req, res, next) => {
+	.put(dryRun('redirection-host', 'update'), changeRequest('redirection-host', 'update'), schedule('redirection-host', 'update'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/redirection-hosts/{hostID}', 'put'), req.body)
 			.then((payload) => {
 				payload.id = parseInt(req.params.host_id, 10);
@@ -137,8 +189,23 @@ router
 	 *
 	 * Update and existing redirection-host
 	 */
-	.delete((req, res, next) => {
-		internalRedirectionHost.delete(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+	.delete(dryRun('redirection-host', 'delete'), changeRequest('redirection-host', 'delete'), schedule('redirection-host', 'delete'), (req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				drain: {
+					$ref: 'common#/properties/drain'
+				}
+			}
+		}, {
+			drain: (typeof req.query.drain === 'string' ? parseInt(req.query.drain, 10) : undefined)
+		})
+			.then((data) => {
+				return internalRedirectionHost.delete(res.locals.access, {
+					id:    parseInt(req.params.host_id, 10),
+					drain: data.drain
+				});
+			})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -162,7 +229,10 @@ router
 	 * POST /api/nginx/redirection-hosts/123/enable
 	 */
 	.post((req, res, next) => {
-		internalRedirectionHost.enable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		internalRedirectionHost.enable(res.locals.access, {
+			id:     parseInt(req.params.host_id, 10),
+			reason: req.body.reason
+		})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -186,7 +256,204 @@ router
 	 * POST /api/nginx/redirection-hosts/123/disable
 	 */
 	.post((req, res, next) => {
-		internalRedirectionHost.disable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		validator({
+			additionalProperties: false,
+			properties:           {
+				drain: {
+					$ref: 'common#/properties/drain'
+				}
+			}
+		}, {
+			drain: (typeof req.query.drain === 'string' ? parseInt(req.query.drain, 10) : undefined)
+		})
+			.then((data) => {
+				return internalRedirectionHost.disable(res.locals.access, {
+					id:     parseInt(req.params.host_id, 10),
+					drain:  data.drain,
+					reason: req.body.reason
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Lock redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/lock
+ */
+router
+	.route('/:host_id/lock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/redirection-hosts/123/lock
+	 */
+	.post((req, res, next) => {
+		internalLock.lock(res.locals.access, 'redirection-host', {id: parseInt(req.params.host_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Unlock redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/unlock
+ */
+router
+	.route('/:host_id/unlock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/redirection-hosts/123/unlock
+	 */
+	.post((req, res, next) => {
+		internalLock.unlock(res.locals.access, 'redirection-host', {id: parseInt(req.params.host_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Analytics of a redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/analytics
+ */
+router
+	.route('/:host_id/analytics')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/redirection-hosts/123/analytics
+	 *
+	 * Top clients, countries, networks, bots and referrers of the host
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				days: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 365
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 100
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			days:    (typeof req.query.days === 'string' ? parseInt(req.query.days, 10) : undefined),
+			limit:   (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalAnalytics.getReport(res.locals.access, 'redirection-host', {
+					id:    parseInt(data.host_id, 10),
+					days:  data.days,
+					limit: data.limit
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Usage of a redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/usage
+ */
+router
+	.route('/:host_id/usage')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/redirection-hosts/123/usage
+	 *
+	 * Requests and bytes the host served each month, and the limits it reached
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				months: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 36
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			months:  (typeof req.query.months === 'string' ? parseInt(req.query.months, 10) : undefined)
+		})
+			.then((data) => {
+				return internalHostUsage.getUsage(res.locals.access, 'redirection-host', {
+					id:     parseInt(data.host_id, 10),
+					months: data.months
+				});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * TLS scan of a redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/tls-scan
+ */
+router
+	.route('/:host_id/tls-scan')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/redirection-hosts/123/tls-scan
+	 *
+	 * Connects to the host and reports on its protocols, ciphers, certificate chain and HSTS
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/redirection-hosts/{hostID}/tls-scan', 'post'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.host_id, 10);
+				return internalTlsScan.scan(res.locals.access, 'redirection-host', payload);
+			})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -194,4 +461,52 @@ router
 			.catch(next);
 	});
 
+/**
+ * Activity of a redirection-host
+ *
+ * /api/nginx/redirection-hosts/123/activity
+ */
+router
+	.route('/:host_id/activity')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/redirection-hosts/123/activity
+	 *
+	 * Its audit log, ACME orders, config results and health changes in one timeline
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['host_id'],
+			additionalProperties: false,
+			properties:           {
+				host_id: {
+					$ref: 'common#/properties/id'
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 500
+				}
+			}
+		}, {
+			host_id: req.params.host_id,
+			limit:   (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalActivity.getFeed(res.locals.access, 'redirection-host', {
+					id:    parseInt(data.host_id, 10),
+					limit: data.limit
+				});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
 module.exports = router;
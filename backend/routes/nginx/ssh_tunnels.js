@@ -0,0 +1,128 @@
+const express           = require('express');
+const validator         = require('../../lib/validator');
+const jwtdecode         = require('../../lib/express/jwt-decode');
+const apiValidator      = require('../../lib/validator/api');
+const internalSshTunnel = require('../../internal/ssh-tunnel');
+const schema            = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/ssh-tunnels
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/ssh-tunnels
+	 *
+	 * Retrieve all SSH tunnels
+	 */
+	.get((req, res, next) => {
+		internalSshTunnel.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/ssh-tunnels
+	 *
+	 * Add an SSH jump host
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/ssh-tunnels', 'post'), req.body)
+			.then((payload) => {
+				return internalSshTunnel.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific SSH tunnel
+ *
+ * /api/nginx/ssh-tunnels/123
+ */
+router
+	.route('/:tunnel_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/ssh-tunnels/123
+	 *
+	 * Retrieve a specific SSH tunnel
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['tunnel_id'],
+			additionalProperties: false,
+			properties:           {
+				tunnel_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			tunnel_id: req.params.tunnel_id
+		})
+			.then((data) => {
+				return internalSshTunnel.get(res.locals.access, {
+					id: parseInt(data.tunnel_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/ssh-tunnels/123
+	 *
+	 * Update an existing SSH tunnel
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/ssh-tunnels/{tunnelID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.tunnel_id, 10);
+				return internalSshTunnel.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/ssh-tunnels/123
+	 *
+	 * Delete an existing SSH tunnel
+	 */
+	.delete((req, res, next) => {
+		internalSshTunnel.delete(res.locals.access, {id: parseInt(req.params.tunnel_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
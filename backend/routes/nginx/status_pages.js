@@ -0,0 +1,145 @@
+const express            = require('express');
+const validator          = require('../../lib/validator');
+const jwtdecode          = require('../../lib/express/jwt-decode');
+const apiValidator       = require('../../lib/validator/api');
+const internalStatusPage = require('../../internal/status-page');
+const schema             = require('../../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/nginx/status-pages
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/status-pages
+	 *
+	 * Retrieve all status pages
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalStatusPage.getAll(res.locals.access, data.expand);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/nginx/status-pages
+	 *
+	 * Create a new status page
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/status-pages', 'post'), req.body)
+			.then((payload) => {
+				return internalStatusPage.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific status page
+ *
+ * /api/nginx/status-pages/123
+ */
+router
+	.route('/:status_page_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/status-pages/123
+	 *
+	 * Retrieve a specific status page
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['status_page_id'],
+			additionalProperties: false,
+			properties:           {
+				status_page_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			status_page_id: req.params.status_page_id,
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalStatusPage.get(res.locals.access, {
+					id:     parseInt(data.status_page_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/nginx/status-pages/123
+	 *
+	 * Update an existing status page
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/nginx/status-pages/{statusPageID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.status_page_id, 10);
+				return internalStatusPage.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/nginx/status-pages/123
+	 *
+	 * Delete an existing status page
+	 */
+	.delete((req, res, next) => {
+		internalStatusPage.delete(res.locals.access, {id: parseInt(req.params.status_page_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -1,9 +1,14 @@
-const express        = require('express');
-const validator      = require('../../lib/validator');
-const jwtdecode      = require('../../lib/express/jwt-decode');
-const apiValidator   = require('../../lib/validator/api');
-const internalStream = require('../../internal/stream');
-const schema         = require('../../schema');
+const express          = require('express');
+const validator        = require('../../lib/validator');
+const jwtdecode        = require('../../lib/express/jwt-decode');
+const dryRun           = require('../../lib/express/dry-run');
+const jsonLines        = require('../../lib/express/json-lines');
+const apiValidator     = require('../../lib/validator/api');
+const internalStream   = require('../../internal/stream');
+const internalView     = require('../../internal/view');
+const internalLock     = require('../../internal/lock');
+const internalActivity = require('../../internal/activity');
+const schema           = require('../../schema');
 
 let router = express.Router({
 	caseSensitive: true,
@@ -35,18 +40,60 @@ router
 				},
 				query: {
 					$ref: 'common#/properties/query'
+				},
+				project_id: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/project_id'
+						}
+					]
+				},
+				tag: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/tags'
+						}
+					]
+				},
+				view: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							$ref: 'common#/properties/id'
+						}
+					]
 				}
 			}
 		}, {
-			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
-			query:  (typeof req.query.query === 'string' ? req.query.query : null)
+			expand:     (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			query:      (typeof req.query.query === 'string' ? req.query.query : null),
+			project_id: (typeof req.query.project_id === 'string' ? parseInt(req.query.project_id, 10) : null),
+			tag:        (typeof req.query.tag === 'string' ? req.query.tag.split(',') : null),
+			view:       (typeof req.query.view === 'string' ? parseInt(req.query.view, 10) : null)
 		})
 			.then((data) => {
-				return internalStream.getAll(res.locals.access, data.expand, data.query);
+				return internalView.getListOptions(res.locals.access, 'stream', data);
 			})
-			.then((rows) => {
-				res.status(200)
-					.send(rows);
+			.then((list) => {
+				if (jsonLines.wanted(req)) {
+					return jsonLines.send(res, (write) => {
+						return internalStream.getAll(res.locals.access, list.expand, list.query, list.filter, write);
+					});
+				}
+
+				return internalStream.getAll(res.locals.access, list.expand, list.query, list.filter)
+					.then((rows) => {
+						res.status(200)
+							.send(rows);
+					});
 			})
 			.catch(next);
 	})
@@ -56,7 +103,7 @@ router
 	 *
 	 * Create a new stream
 	 */
-	.post((req, res, next) => {
+	.post(dryRun('stream', 'create'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/streams', 'post'), req.body)
 			.then((payload) => {
 				return internalStream.create(res.locals.access, payload);
@@ -119,7 +166,7 @@ router
 	 *
 	 * Update and existing stream
 	 */
-	.put((req, res, next) => {
+	.put(dryRun('stream', 'update'), (req, res, next) => {
 		apiValidator(schema.getValidationSchema('/nginx/streams/{streamID}', 'put'), req.body)
 			.then((payload) => {
 				payload.id = parseInt(req.params.stream_id, 10);
@@ -137,7 +184,7 @@ router
 	 *
 	 * Update and existing stream
 	 */
-	.delete((req, res, next) => {
+	.delete(dryRun('stream', 'delete'), (req, res, next) => {
 		internalStream.delete(res.locals.access, {id: parseInt(req.params.stream_id, 10)})
 			.then((result) => {
 				res.status(200)
@@ -162,7 +209,10 @@ router
 	 * POST /api/nginx/streams/123/enable
 	 */
 	.post((req, res, next) => {
-		internalStream.enable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		internalStream.enable(res.locals.access, {
+			id:     parseInt(req.params.host_id, 10),
+			reason: req.body.reason
+		})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -186,7 +236,58 @@ router
 	 * POST /api/nginx/streams/123/disable
 	 */
 	.post((req, res, next) => {
-		internalStream.disable(res.locals.access, {id: parseInt(req.params.host_id, 10)})
+		internalStream.disable(res.locals.access, {
+			id:     parseInt(req.params.host_id, 10),
+			reason: req.body.reason
+		})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Lock stream
+ *
+ * /api/nginx/streams/123/lock
+ */
+router
+	.route('/:host_id/lock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/streams/123/lock
+	 */
+	.post((req, res, next) => {
+		internalLock.lock(res.locals.access, 'stream', {id: parseInt(req.params.host_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Unlock stream
+ *
+ * /api/nginx/streams/123/unlock
+ */
+router
+	.route('/:host_id/unlock')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/nginx/streams/123/unlock
+	 */
+	.post((req, res, next) => {
+		internalLock.unlock(res.locals.access, 'stream', {id: parseInt(req.params.host_id, 10)})
 			.then((result) => {
 				res.status(200)
 					.send(result);
@@ -194,4 +295,52 @@ router
 			.catch(next);
 	});
 
+/**
+ * Activity of a stream
+ *
+ * /api/nginx/streams/123/activity
+ */
+router
+	.route('/:stream_id/activity')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/nginx/streams/123/activity
+	 *
+	 * Its audit log, ACME orders, config results and health changes in one timeline
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['stream_id'],
+			additionalProperties: false,
+			properties:           {
+				stream_id: {
+					$ref: 'common#/properties/id'
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 500
+				}
+			}
+		}, {
+			stream_id: req.params.stream_id,
+			limit:     (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalActivity.getFeed(res.locals.access, 'stream', {
+					id:    parseInt(data.stream_id, 10),
+					limit: data.limit
+				});
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
 module.exports = router;
@@ -0,0 +1,78 @@
+const express               = require('express');
+const jwtdecode             = require('../lib/express/jwt-decode');
+const apiValidator          = require('../lib/validator/api');
+const internalNotifications = require('../internal/notifications');
+const schema                = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/notifications/held
+ */
+router
+	.route('/held')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/notifications/held
+	 *
+	 * What's held back for digests and quiet hours
+	 */
+	.get((_, res, next) => {
+		internalNotifications.getHeld(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/notifications/routes
+ */
+router
+	.route('/routes')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/notifications/routes
+	 *
+	 * Which notification channels events go to
+	 */
+	.get((_, res, next) => {
+		internalNotifications.getRoutes(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/notifications/routes
+	 *
+	 * Replace the routes
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/notifications/routes', 'put'), req.body)
+			.then((payload) => {
+				return internalNotifications.updateRoutes(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,128 @@
+const express        = require('express');
+const validator      = require('../lib/validator');
+const jwtdecode      = require('../lib/express/jwt-decode');
+const apiValidator   = require('../lib/validator/api');
+const internalPlugin = require('../internal/plugin');
+const schema         = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/plugins
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/plugins
+	 *
+	 * Retrieve all plugins
+	 */
+	.get((req, res, next) => {
+		internalPlugin.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/plugins
+	 *
+	 * Add a plugin
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/plugins', 'post'), req.body)
+			.then((payload) => {
+				return internalPlugin.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific plugin
+ *
+ * /api/plugins/123
+ */
+router
+	.route('/:plugin_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/plugins/123
+	 *
+	 * Retrieve a specific plugin
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['plugin_id'],
+			additionalProperties: false,
+			properties:           {
+				plugin_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			plugin_id: req.params.plugin_id
+		})
+			.then((data) => {
+				return internalPlugin.get(res.locals.access, {
+					id: parseInt(data.plugin_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/plugins/123
+	 *
+	 * Update an existing plugin
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/plugins/{pluginID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.plugin_id, 10);
+				return internalPlugin.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/plugins/123
+	 *
+	 * Delete an existing plugin
+	 */
+	.delete((req, res, next) => {
+		internalPlugin.delete(res.locals.access, {id: parseInt(req.params.plugin_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,35 @@
+const express            = require('express');
+const jwtdecode          = require('../lib/express/jwt-decode');
+const internalAppPresets = require('../internal/app-presets');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/presets
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/presets
+	 *
+	 * The presets of popular self-hosted apps proxy hosts can be created with
+	 */
+	.get((req, res, next) => {
+		internalAppPresets.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
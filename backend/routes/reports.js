@@ -1,6 +1,11 @@
-const express        = require('express');
-const jwtdecode      = require('../lib/express/jwt-decode');
-const internalReport = require('../internal/report');
+const express                 = require('express');
+const validator               = require('../lib/validator');
+const jwtdecode               = require('../lib/express/jwt-decode');
+const apiValidator            = require('../lib/validator/api');
+const internalReport          = require('../internal/report');
+const internalDomainExpiry    = require('../internal/domain-expiry');
+const internalScheduledReport = require('../internal/scheduled-report');
+const schema                  = require('../schema');
 
 let router = express.Router({
 	caseSensitive: true,
@@ -26,4 +31,102 @@ router
 			.catch(next);
 	});
 
+router
+	.route('/domains')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /reports/domains
+	 *
+	 * When the registration of each domain used by a host expires
+	 */
+	.get(jwtdecode(), (_, res, next) => {
+		internalDomainExpiry.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+router
+	.route('/domains/check')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * POST /reports/domains/check
+	 *
+	 * Look up the expiry dates now
+	 */
+	.post(jwtdecode(), (req, res, next) => {
+		req.setTimeout(900000); // 15 minutes timeout
+		internalDomainExpiry.check(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(!!result);
+			})
+			.catch(next);
+	});
+
+router
+	.route('/summary')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /reports/summary
+	 *
+	 * The report of the last week or month, as it's sent to the recipients
+	 */
+	.get(jwtdecode(), (req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				frequency: {
+					type: 'string',
+					enum: internalScheduledReport.FREQUENCIES
+				}
+			}
+		}, {
+			frequency: (typeof req.query.frequency === 'string' ? req.query.frequency : undefined)
+		})
+			.then((data) => {
+				return internalScheduledReport.get(res.locals.access, data);
+			})
+			.then((report) => {
+				res.status(200)
+					.send(report);
+			})
+			.catch(next);
+	});
+
+router
+	.route('/summary/send')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * POST /reports/summary/send
+	 *
+	 * Send the report of the last week or month to a notification channel now
+	 */
+	.post(jwtdecode(), (req, res, next) => {
+		req.setTimeout(120000); // 2 minutes timeout
+		apiValidator(schema.getValidationSchema('/reports/summary/send', 'post'), req.body)
+			.then((payload) => {
+				return internalScheduledReport.send(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
 module.exports = router;
@@ -0,0 +1,113 @@
+const express                 = require('express');
+const validator               = require('../lib/validator');
+const jwtdecode               = require('../lib/express/jwt-decode');
+const internalScheduledChange = require('../internal/scheduled-change');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/scheduled-changes
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/scheduled-changes
+	 *
+	 * The queue of changes the user can see, next to be made first
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				expand: {
+					$ref: 'common#/properties/expand'
+				},
+				status: {
+					type: 'string',
+					enum: ['pending', 'applied', 'failed', 'cancelled']
+				}
+			}
+		}, {
+			expand: (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null),
+			status: (typeof req.query.status === 'string' ? req.query.status : undefined)
+		})
+			.then((data) => {
+				return internalScheduledChange.getAll(res.locals.access, {status: data.status}, data.expand);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific scheduled change
+ *
+ * /api/scheduled-changes/123
+ */
+router
+	.route('/:scheduled_change_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/scheduled-changes/123
+	 *
+	 * Retrieve a specific scheduled change
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['scheduled_change_id'],
+			additionalProperties: false,
+			properties:           {
+				scheduled_change_id: {
+					$ref: 'common#/properties/id'
+				},
+				expand: {
+					$ref: 'common#/properties/expand'
+				}
+			}
+		}, {
+			scheduled_change_id: req.params.scheduled_change_id,
+			expand:              (typeof req.query.expand === 'string' ? req.query.expand.split(',') : null)
+		})
+			.then((data) => {
+				return internalScheduledChange.get(res.locals.access, {
+					id:     parseInt(data.scheduled_change_id, 10),
+					expand: data.expand
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/scheduled-changes/123
+	 *
+	 * Cancel a change that hasn't been made yet
+	 */
+	.delete((req, res, next) => {
+		internalScheduledChange.cancel(res.locals.access, {id: parseInt(req.params.scheduled_change_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
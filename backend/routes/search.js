@@ -0,0 +1,57 @@
+const express        = require('express');
+const validator      = require('../lib/validator');
+const jwtdecode      = require('../lib/express/jwt-decode');
+const internalSearch = require('../internal/search');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/search
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/search?q=
+	 *
+	 * Hosts, certificates, streams and users matching the query, for a quick switcher
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['q'],
+			additionalProperties: false,
+			properties:           {
+				q: {
+					type:      'string',
+					minLength: 1,
+					maxLength: 255
+				},
+				limit: {
+					type:    'integer',
+					minimum: 1,
+					maximum: 50
+				}
+			}
+		}, {
+			q:     (typeof req.query.q === 'string' ? req.query.q.trim() : undefined),
+			limit: (typeof req.query.limit === 'string' ? parseInt(req.query.limit, 10) : undefined)
+		})
+			.then((data) => {
+				return internalSearch.search(res.locals.access, data);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
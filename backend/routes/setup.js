@@ -0,0 +1,65 @@
+const express       = require('express');
+const jwtdecode     = require('../lib/express/jwt-decode');
+const apiValidator  = require('../lib/validator/api');
+const error         = require('../lib/error');
+const internalSetup = require('../internal/setup');
+const schema        = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/setup
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /api/setup
+	 *
+	 * How far the setup has got, so an interrupted one can carry on from where it was
+	 */
+	.get((_, res) => {
+		res.status(200)
+			.send(internalSetup.getState());
+	});
+
+/**
+ * /api/setup/:step
+ */
+router
+	.route('/:step')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/setup/:step
+	 *
+	 * Do one step of the setup: database, keys, admin, settings or demo
+	 */
+	.post((req, res, next) => {
+		if (internalSetup.STEPS.indexOf(req.params.step) === -1) {
+			next(new error.ItemNotFoundError(req.params.step));
+			return;
+		}
+
+		apiValidator(schema.getValidationSchema('/setup/' + req.params.step, 'post'), req.body || {})
+			.then((payload) => {
+				return internalSetup.run(res.locals.access, req.params.step, payload, req.get('x-setup-token'));
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
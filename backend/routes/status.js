@@ -0,0 +1,75 @@
+const express            = require('express');
+const validator          = require('../lib/validator');
+const internalStatusPage = require('../internal/status-page');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+const slugSchema = {
+	required:             ['slug'],
+	additionalProperties: false,
+	properties:           {
+		slug: {
+			type:    'string',
+			pattern: '^[a-z0-9][a-z0-9-]{0,63}$'
+		}
+	}
+};
+
+/**
+ * Public status of a status page, without a token
+ *
+ * /api/status/example
+ */
+router
+	.route('/:slug')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /api/status/example
+	 */
+	.get((req, res, next) => {
+		validator(slugSchema, {slug: req.params.slug})
+			.then((data) => {
+				return internalStatusPage.getPublic(data);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/status/example/page
+ */
+router
+	.route('/:slug/page')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+
+	/**
+	 * GET /api/status/example/page
+	 *
+	 * The status as a page for browsers
+	 */
+	.get((req, res, next) => {
+		validator(slugSchema, {slug: req.params.slug})
+			.then((data) => {
+				return internalStatusPage.renderPublic(data);
+			})
+			.then((html) => {
+				res.status(200)
+					.set('Content-Type', 'text/html; charset=utf-8')
+					.send(html);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
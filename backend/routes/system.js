@@ -0,0 +1,438 @@
+const express               = require('express');
+const validator             = require('../lib/validator');
+const jwtdecode             = require('../lib/express/jwt-decode');
+const apiValidator          = require('../lib/validator/api');
+const internalSystem        = require('../internal/system');
+const internalVersion       = require('../internal/version');
+const internalLogRotation   = require('../internal/log-rotation');
+const internalImport        = require('../internal/import');
+const internalTraefikExport = require('../internal/traefik-export');
+const internalOrphans       = require('../internal/orphans');
+const internalSecurityAudit = require('../internal/security-audit');
+const internalNotifications = require('../internal/notifications');
+const internalTelemetry     = require('../internal/telemetry');
+const internalTestMode      = require('../internal/test-mode');
+const schema                = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/system/version
+ */
+router
+	.route('/version')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/version
+	 *
+	 * What's running, and with ?check=true whether there's a newer image
+	 */
+	.get((req, res, next) => {
+		internalVersion.get(res.locals.access, req.query.check === 'true' || req.query.check === '1')
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/migrations
+ */
+router
+	.route('/migrations')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/migrations
+	 *
+	 * The history of the database schema, and the snapshots to go back to
+	 */
+	.get((_, res, next) => {
+		internalSystem.getMigrations(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/reload
+ */
+router
+	.route('/reload')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/reload
+	 *
+	 * Apply the backend settings again without a restart
+	 */
+	.post((_, res, next) => {
+		internalSystem.reload(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/jwt/rotate
+ */
+router
+	.route('/jwt/rotate')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/jwt/rotate
+	 *
+	 * Replace the key pair tokens are signed with
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/system/jwt/rotate', 'post'), req.body)
+			.then((payload) => {
+				return internalSystem.rotateJwtKeys(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/logs/usage
+ */
+router
+	.route('/logs/usage')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/logs/usage
+	 *
+	 * Disk space used by the logs of each host
+	 */
+	.get((_, res, next) => {
+		internalLogRotation.getUsage(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/notifications/test
+ */
+router
+	.route('/notifications/test')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/notifications/test
+	 *
+	 * Send a test message to a notification channel
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/system/notifications/test', 'post'), req.body)
+			.then((payload) => {
+				return internalNotifications.test(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/notifications/channels/Ops/test
+ */
+router
+	.route('/notifications/channels/:channel_name/test')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/notifications/channels/Ops/test
+	 *
+	 * Send a test message to a saved notification channel
+	 */
+	.post((req, res, next) => {
+		validator({
+			required:             ['channel_name'],
+			additionalProperties: false,
+			properties:           {
+				channel_name: {
+					type:      'string',
+					minLength: 1,
+					maxLength: 100
+				}
+			}
+		}, {
+			channel_name: req.params.channel_name
+		})
+			.then((data) => {
+				return internalNotifications.testSaved(res.locals.access, {name: data.channel_name});
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/orphans
+ */
+router
+	.route('/orphans')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/orphans
+	 *
+	 * Certificates, access lists, ACME DNS credentials and config files nothing uses anymore
+	 */
+	.get((_, res, next) => {
+		internalOrphans.get(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/orphans/cleanup
+ */
+router
+	.route('/orphans/cleanup')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/orphans/cleanup
+	 *
+	 * Remove the chosen orphans
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/system/orphans/cleanup', 'post'), req.body)
+			.then((payload) => {
+				return internalOrphans.cleanup(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/security-audit
+ */
+router
+	.route('/security-audit')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/security-audit
+	 *
+	 * Default credentials, an exposed admin port, hosts without TLS and staging certificates, the worst first
+	 */
+	.get((_, res, next) => {
+		internalSecurityAudit.get(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/telemetry/preview
+ */
+router
+	.route('/telemetry/preview')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/telemetry/preview
+	 *
+	 * Exactly what the anonymous usage report sends, and whether it's being sent
+	 */
+	.get((_, res, next) => {
+		internalTelemetry.getPreview(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/test-mode/dns
+ */
+router
+	.route('/test-mode/dns')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/test-mode/dns
+	 *
+	 * The records the mock DNS provider has, and the ones added and removed, only in test mode
+	 */
+	.get((_, res, next) => {
+		internalTestMode.getDns(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/import/nginx
+ * /api/system/import/caddy
+ * /api/system/import/traefik
+ */
+router
+	.route('/import/:format(nginx|caddy|traefik)')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/system/import/:format
+	 *
+	 * Convert the sites of uploaded config files, or the ones at a path, into proxy hosts
+	 */
+	.post((req, res, next) => {
+		const validation = schema.getValidationSchema('/system/import/' + req.params.format, 'post');
+		let payload;
+
+		if (req.files) {
+			// Multipart form fields are strings
+			let fields = {create: req.body.create === 'true' || req.body.create === '1'};
+			if (req.body.path) {
+				fields.path = req.body.path;
+			}
+			if (req.body.letsencrypt_email) {
+				fields.letsencrypt_email = req.body.letsencrypt_email;
+			}
+
+			payload = apiValidator(validation, fields)
+				.then((data) => {
+					data.files = [].concat(req.files.config || []).map((file) => {
+						return {file: file.name, text: file.data.toString()};
+					});
+					return data;
+				});
+		} else {
+			payload = apiValidator(validation, req.body);
+		}
+
+		payload
+			.then((data) => {
+				return internalImport.import(res.locals.access, req.params.format, data);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/system/export/traefik
+ */
+router
+	.route('/export/traefik')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/system/export/traefik
+	 *
+	 * Traefik dynamic config and docker labels equivalent to the hosts
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				format: {
+					type: 'string',
+					enum: ['json', 'yaml']
+				},
+				cert_resolver: {
+					type:      'string',
+					minLength: 1,
+					maxLength: 100
+				}
+			}
+		}, {
+			format:        (typeof req.query.format === 'string' ? req.query.format : 'json'),
+			cert_resolver: (typeof req.query.cert_resolver === 'string' ? req.query.cert_resolver : undefined)
+		})
+			.then((data) => {
+				return internalTraefikExport.getConfig(res.locals.access, data)
+					.then((result) => {
+						if (data.format === 'yaml') {
+							res.status(200)
+								.attachment('npm-traefik.yml')
+								.type('text/yaml')
+								.send(internalTraefikExport.toYaml(result));
+						} else {
+							res.status(200)
+								.send(result);
+						}
+					});
+			})
+			.catch(next);
+	});
+
+module.exports = router;
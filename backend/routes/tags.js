@@ -0,0 +1,64 @@
+const express      = require('express');
+const jwtdecode    = require('../lib/express/jwt-decode');
+const apiValidator = require('../lib/validator/api');
+const internalTag  = require('../internal/tag');
+const schema       = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/tags
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/tags
+	 *
+	 * Retrieve every tag in use
+	 */
+	.get((_, res, next) => {
+		internalTag.getAll(res.locals.access)
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	});
+
+/**
+ * /api/tags/bulk
+ */
+router
+	.route('/bulk')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/tags/bulk
+	 *
+	 * Add and remove tags on many items at once
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/tags/bulk', 'post'), req.body)
+			.then((payload) => {
+				return internalTag.bulkUpdate(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,140 @@
+const express        = require('express');
+const validator      = require('../lib/validator');
+const jwtdecode      = require('../lib/express/jwt-decode');
+const apiValidator   = require('../lib/validator/api');
+const internalTenant = require('../internal/tenant');
+const schema         = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/tenants
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/tenants
+	 *
+	 * Retrieve all tenants
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				query: {
+					$ref: 'common#/properties/query'
+				}
+			}
+		}, {
+			query: (typeof req.query.query === 'string' ? req.query.query : null)
+		})
+			.then((data) => {
+				return internalTenant.getAll(res.locals.access, data.query);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/tenants
+	 *
+	 * Create a new tenant
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/tenants', 'post'), req.body)
+			.then((payload) => {
+				return internalTenant.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific tenant
+ *
+ * /api/tenants/123
+ */
+router
+	.route('/:tenant_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/tenants/123
+	 *
+	 * Retrieve a specific tenant
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['tenant_id'],
+			additionalProperties: false,
+			properties:           {
+				tenant_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			tenant_id: req.params.tenant_id
+		})
+			.then((data) => {
+				return internalTenant.get(res.locals.access, {
+					id: parseInt(data.tenant_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/tenants/123
+	 *
+	 * Update an existing tenant
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/tenants/{tenantID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.tenant_id, 10);
+				return internalTenant.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/tenants/123
+	 *
+	 * Delete an existing tenant
+	 */
+	.delete((req, res, next) => {
+		internalTenant.delete(res.locals.access, {id: parseInt(req.params.tenant_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,40 @@
+const express          = require('express');
+const jwtdecode        = require('../lib/express/jwt-decode');
+const apiValidator     = require('../lib/validator/api');
+const internalDnsCheck = require('../internal/dns-check');
+const schema           = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/tools/dns-check
+ */
+router
+	.route('/dns-check')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * POST /api/tools/dns-check
+	 *
+	 * Whether a record has reached the public resolvers, ie: a DNS-01 challenge
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/tools/dns-check', 'post'), req.body)
+			.then((payload) => {
+				return internalDnsCheck.run(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
@@ -0,0 +1,148 @@
+const express      = require('express');
+const validator    = require('../lib/validator');
+const jwtdecode    = require('../lib/express/jwt-decode');
+const apiValidator = require('../lib/validator/api');
+const internalView = require('../internal/view');
+const schema       = require('../schema');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/views
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/views
+	 *
+	 * Retrieve all of your saved views
+	 */
+	.get((req, res, next) => {
+		validator({
+			additionalProperties: false,
+			properties:           {
+				object_type: {
+					anyOf: [
+						{
+							type: 'null'
+						},
+						{
+							type: 'string',
+							enum: Object.keys(internalView.TYPES)
+						}
+					]
+				}
+			}
+		}, {
+			object_type: (typeof req.query.object_type === 'string' ? req.query.object_type : null)
+		})
+			.then((data) => {
+				return internalView.getAll(res.locals.access, data.object_type);
+			})
+			.then((rows) => {
+				res.status(200)
+					.send(rows);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * POST /api/views
+	 *
+	 * Save a view
+	 */
+	.post((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/views', 'post'), req.body)
+			.then((payload) => {
+				return internalView.create(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(201)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+/**
+ * Specific view
+ *
+ * /api/views/123
+ */
+router
+	.route('/:view_id')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/views/123
+	 *
+	 * Retrieve a specific view
+	 */
+	.get((req, res, next) => {
+		validator({
+			required:             ['view_id'],
+			additionalProperties: false,
+			properties:           {
+				view_id: {
+					$ref: 'common#/properties/id'
+				}
+			}
+		}, {
+			view_id: req.params.view_id
+		})
+			.then((data) => {
+				return internalView.get(res.locals.access, {
+					id: parseInt(data.view_id, 10)
+				});
+			})
+			.then((row) => {
+				res.status(200)
+					.send(row);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * PUT /api/views/123
+	 *
+	 * Update an existing view
+	 */
+	.put((req, res, next) => {
+		apiValidator(schema.getValidationSchema('/views/{viewID}', 'put'), req.body)
+			.then((payload) => {
+				payload.id = parseInt(req.params.view_id, 10);
+				return internalView.update(res.locals.access, payload);
+			})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	})
+
+	/**
+	 * DELETE /api/views/123
+	 *
+	 * Delete an existing view
+	 */
+	.delete((req, res, next) => {
+		internalView.delete(res.locals.access, {id: parseInt(req.params.view_id, 10)})
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
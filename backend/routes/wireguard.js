@@ -0,0 +1,35 @@
+const express           = require('express');
+const jwtdecode         = require('../lib/express/jwt-decode');
+const internalWireguard = require('../internal/wireguard');
+
+let router = express.Router({
+	caseSensitive: true,
+	strict:        true,
+	mergeParams:   true
+});
+
+/**
+ * /api/wireguard
+ */
+router
+	.route('/')
+	.options((_, res) => {
+		res.sendStatus(204);
+	})
+	.all(jwtdecode())
+
+	/**
+	 * GET /api/wireguard
+	 *
+	 * The interface of the wireguard setting, and how each of its peers is doing
+	 */
+	.get((req, res, next) => {
+		internalWireguard.getStatus(res.locals.access)
+			.then((result) => {
+				res.status(200)
+					.send(result);
+			})
+			.catch(next);
+	});
+
+module.exports = router;
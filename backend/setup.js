@@ -2,13 +2,20 @@ const config              = require('./lib/config');
 const logger              = require('./logger').setup;
 const certificateModel    = require('./models/certificate');
 const userModel           = require('./models/user');
-const userPermissionModel = require('./models/user_permission');
 const utils               = require('./lib/utils');
-const authModel           = require('./models/auth');
 const settingModel        = require('./models/setting');
 const certbot             = require('./lib/certbot');
+const internalAdminHost   = require('./internal/admin-host');
+const internalAdminListen = require('./internal/admin-listen');
+const internalSetting     = require('./internal/setting');
+const internalSetup       = require('./internal/setup');
+const internalCertStorage = require('./internal/certificate-storage');
+const bootstrap           = require('./lib/bootstrap');
+const Access              = require('./lib/access');
+
 /**
- * Creates a default admin users if one doesn't already exist in the database
+ * Creates a default admin users if one doesn't already exist in the database,
+ * unless it's left for the setup wizard
  *
  * @returns {Promise}
  */
@@ -20,46 +27,25 @@ const setupDefaultUser = () => {
 		.first()
 		.then((row) => {
 			if (!row || !row.id) {
+				if (internalSetup.isWizard()) {
+					logger.info('Leaving the first admin for the setup wizard');
+					return;
+				}
+
 				// Create a new user and set password
-				const email    = process.env.INITIAL_ADMIN_EMAIL || 'admin@example.com';
-				const password = process.env.INITIAL_ADMIN_PASSWORD || 'changeme';
-
-				logger.info('Creating a new user: ' + email + ' with password: ' + password);
-
-				const data = {
-					is_deleted: 0,
-					email:      email,
-					name:       'Administrator',
-					nickname:   'Admin',
-					avatar:     '',
-					roles:      ['admin'],
-				};
-
-				return userModel
-					.query()
-					.insertAndFetch(data)
-					.then((user) => {
-						return authModel
-							.query()
-							.insert({
-								user_id: user.id,
-								type:    'password',
-								secret:  password,
-								meta:    {},
-							})
-							.then(() => {
-								return userPermissionModel.query().insert({
-									user_id:           user.id,
-									visibility:        'all',
-									proxy_hosts:       'manage',
-									redirection_hosts: 'manage',
-									dead_hosts:        'manage',
-									streams:           'manage',
-									access_lists:      'manage',
-									certificates:      'manage',
-								});
-							});
-					})
+				const admin    = bootstrap.get('admin') || {};
+				const email    = process.env.INITIAL_ADMIN_EMAIL || admin.email || 'admin@example.com';
+				const password = process.env.INITIAL_ADMIN_PASSWORD || admin.password || null;
+
+				// A password that was given doesn't belong in the logs
+				logger.info('Creating a new user: ' + email + (password ? '' : ' with password: changeme'));
+
+				return internalSetup.createAdmin({
+					email:    email,
+					password: password,
+					name:     process.env.INITIAL_ADMIN_NAME || admin.name,
+					nickname: admin.nickname
+				})
 					.then(() => {
 						logger.info('Initial admin setup completed');
 					});
@@ -69,35 +55,341 @@ const setupDefaultUser = () => {
 		});
 };
 
+/**
+ * Settings that must exist in the database, inserted with these values when missing
+ */
+const defaultSettings = [
+	{
+		id:          'default-site',
+		name:        'Default Site',
+		description: 'What to show when Nginx is hit with an unknown Host',
+		value:       'congratulations',
+		meta:        {},
+	},
+	{
+		id:          'admin-host',
+		name:        'Admin Host',
+		description: 'Serve the admin interface through one of your Proxy Hosts',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'admin-listen',
+		name:        'Admin Listener',
+		description: 'Serve the admin interface on its own port with https and HTTP/2',
+		value:       'http',
+		meta:        {},
+	},
+	{
+		id:          'ct-monitor',
+		name:        'Certificate Transparency Monitor',
+		description: 'Watch public CT logs for certificates issued for your domains by someone else',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'domain-expiry',
+		name:        'Domain Expiry Monitor',
+		description: 'Look up when the registration of your domains runs out and warn before it does',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'acme-dns',
+		name:        'ACME DNS Server',
+		description: 'Answer DNS challenges for a delegated zone, for DNS providers without an API',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'cors',
+		name:        'CORS',
+		description: 'Which other sites can call the API from a browser',
+		value:       'default',
+		meta:        {},
+	},
+	{
+		id:          'compression',
+		name:        'Compression',
+		description: 'How responses from hosts are compressed, unless a host has its own settings',
+		value:       'default',
+		meta:        {},
+	},
+	{
+		id:          'listen',
+		name:        'Listen Addresses',
+		description: 'Which addresses hosts and streams listen on, unless they have their own settings',
+		value:       'default',
+		meta:        {},
+	},
+	{
+		id:          'analytics',
+		name:        'Analytics',
+		description: 'Top clients, countries and referrers of each host, from its access log',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'read-only',
+		name:        'Read Only',
+		description: 'Refuse every change through the API, for demo instances and audits',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'log-rotation',
+		name:        'Log Rotation',
+		description: 'Rotate host logs by size and remove old ones, on top of the weekly logrotate',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'log-shipping',
+		name:        'Log Shipping',
+		description: 'Send host and backend logs to a syslog server',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'quotas',
+		name:        'Quotas',
+		description: 'Limit how many hosts, streams and certificates each user can have',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'change-requests',
+		name:        'Change Requests',
+		description: 'Changes to hosts and certificates have to be approved before they\'re made',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'maintenance-window',
+		name:        'Maintenance Window',
+		description: 'Scheduled changes are only made during this window',
+		value:       'off',
+		meta:        {},
+	},
+	{
+		id:          'protection-presets',
+		name:        'Protection Presets',
+		description: 'Paths scanners probe for, blocked on proxy hosts unless they have their own presets',
+		value:       'on',
+		meta:        {presets: ['dotfiles']},
+	},
+	{
+		id:          'server-header',
+		name:        'Server Header',
+		description: 'The Server and X-Powered-By headers hosts send, unless they have their own settings',
+		value:       'default',
+		meta:        {},
+	},
+	{
+		id:          'host-defaults',
+		name:        'Host Defaults',
+		description: 'Certificate, security and timeout settings new hosts start with, unless they are given',
+		value:       'off',
+		meta:        {certificate: 'none'},
+	},
+	{
+		id:          'renewal-retry',
+		name:        'Renewal Retries',
+		description: 'How often the renewal of a certificate is retried after it fails, and when to give up',
+		value:       'on',
+		meta:        {max_attempts: 5, backoff: [1, 6, 24], on_give_up: 'stop'},
+	},
+	{
+		id:          'dns-provider-limits',
+		name:        'DNS Provider Limits',
+		description: 'How many certificates are requested from each DNS provider at a time, and how often',
+		value:       'on',
+		meta:        {limits: [{provider: 'dnspod', concurrency: 1, interval: 30, per_hour: 30}]},
+	},
+	{
+		id:          'features',
+		name:        'Feature Flags',
+		description: 'Experimental features, turned on for everyone here or for some users in their permissions',
+		value:       'default',
+		meta:        {},
+	},
+	{
+		id:          'acme-client',
+		name:        'ACME Client',
+		description: 'What Let\'s Encrypt certificates are requested with, certbot or lego',
+		value:       'certbot',
+		meta:        {},
+	},
+	{
+		id:          'blocked-clients',
+		name:        'Blocked Clients',
+		description: 'Addresses every host drops the connection of, without a response',
+		value:       'off',
+		meta:        {clients: []},
+	},
+	{
+		id:          'outbound-proxy',
+		name:        'Outbound Proxy',
+		description: 'The http or SOCKS5 proxy ACME servers, DNS providers and Docker Hub are reached through',
+		value:       'off',
+		meta:        {url: '', no_proxy: []},
+	},
+	{
+		id:          'dns-resolvers',
+		name:        'DNS Resolvers',
+		description: 'The DNS servers nginx and the backend resolve host names with, instead of the container\'s',
+		value:       'system',
+		meta:        {servers: [], valid: 10},
+	},
+	{
+		id:          'notification-channels',
+		name:        'Notification Channels',
+		description: 'The chat bots and push servers told about hosts going offline, failed renewals and deploys and other alerts',
+		value:       'off',
+		meta:        {channels: []},
+	},
+	{
+		id:          'notification-routes',
+		name:        'Notification Routes',
+		description: 'Which notification channels each event goes to, instead of every channel that wants it',
+		value:       'off',
+		meta:        {routes: []},
+	},
+	{
+		id:          'scheduled-reports',
+		name:        'Scheduled Reports',
+		description: 'A summary of new hosts, renewals, upcoming expirations, traffic and security findings sent every week or month',
+		value:       'off',
+		meta:        {hour: 8, recipients: []},
+	},
+	{
+		id:          'access-requests',
+		name:        'Access Requests',
+		description: 'Clients kept out by an access list can ask for access, behind a captcha, for an administrator to approve',
+		value:       'off',
+		meta:        {provider: 'turnstile', site_key: '', secret: '', hours: 24},
+	},
+	{
+		id:          'access-list-passwords',
+		name:        'Access List Passwords',
+		description: 'Users of an access list can change their own password, with the one they have now',
+		value:       'off',
+		meta:        {min_length: 8},
+	},
+	{
+		id:          'docker',
+		name:        'Docker',
+		description: 'Proxy hosts can forward to containers by name, with their address looked up through the Docker socket',
+		value:       'off',
+		meta:        {socket: '/var/run/docker.sock', network: ''},
+	},
+	{
+		id:          'certificate-policy',
+		name:        'Certificate Policy',
+		description: 'Custom certificates with a weak key or signature, or valid for too long, are refused',
+		value:       'off',
+		meta:        {
+			min_rsa_bits:         2048,
+			min_ec_bits:          256,
+			signature_algorithms: ['sha256WithRSAEncryption', 'sha384WithRSAEncryption', 'sha512WithRSAEncryption', 'rsassaPss', 'ecdsa-with-SHA256', 'ecdsa-with-SHA384', 'ecdsa-with-SHA512', 'ED25519', 'ED448'],
+			max_validity_days:    398,
+		},
+	},
+	{
+		id:          'wireguard',
+		name:        'WireGuard',
+		description: 'A WireGuard interface with peers in other networks, so proxy hosts can forward to upstreams behind them',
+		value:       'off',
+		meta:        {interface: 'wg0', address: '10.8.0.1/24', listen_port: 51820, peers: []},
+	},
+	{
+		id:          'telemetry',
+		name:        'Telemetry',
+		description: 'Send the maintainers of the fork an anonymous report of how many hosts and certificates there are, once a day',
+		value:       'off',
+		meta:        {url: ''},
+	},
+];
+
 /**
  * Creates default settings if they don't already exist in the database
  *
- * @returns {Promise}
+ * @returns {Promise}  resolves with the ids of the settings that were added
  */
 const setupDefaultSettings = () => {
-	return settingModel
-		.query()
-		.select('id')
-		.where({id: 'default-site'})
-		.first()
-		.then((row) => {
-			if (!row || !row.id) {
-				settingModel
-					.query()
-					.insert({
-						id:          'default-site',
-						name:        'Default Site',
-						description: 'What to show when Nginx is hit with an unknown Host',
-						value:       'congratulations',
-						meta:        {},
+	let added = [];
+
+	return Promise.all(defaultSettings.map((setting) => {
+		return settingModel
+			.query()
+			.select('id')
+			.where({id: setting.id})
+			.first()
+			.then((row) => {
+				if (!row || !row.id) {
+					return settingModel
+						.query()
+						.insert(setting)
+						.then(() => {
+							added.push(setting.id);
+							logger.info('Default setting added: ' + setting.id);
+						});
+				}
+				if (config.debug()) {
+					logger.info('Default setting setup not required: ' + setting.id);
+				}
+			});
+	}))
+		.then(() => {
+			return added;
+		});
+};
+
+/**
+ * Applies the settings from the bootstrap file, only to the ones that were just added
+ * so changes made since the first start aren't undone on every restart
+ *
+ * @param   {Array}  added  setting ids
+ * @returns {Promise}
+ */
+const setupBootstrapSettings = (added) => {
+	const settings = bootstrap.get('settings') || {};
+	const ids      = Object.keys(settings).filter((id) => added.indexOf(id) !== -1);
+
+	Object.keys(settings).forEach((id) => {
+		if (!defaultSettings.find((setting) => setting.id === id)) {
+			logger.warn('Unknown setting in bootstrap file: ' + id);
+		}
+	});
+
+	if (!ids.length) {
+		return Promise.resolve();
+	}
+
+	const access = new Access(null);
+
+	return access.load(true)
+		.then(() => {
+			// One at a time, most of them reload nginx
+			return ids.reduce((promise, id) => {
+				return promise
+					.then(() => {
+						let data = {id: id};
+						if (typeof settings[id].value !== 'undefined') {
+							data.value = settings[id].value;
+						}
+						if (typeof settings[id].meta !== 'undefined') {
+							data.meta = settings[id].meta;
+						}
+						return internalSetting.update(access, data);
 					})
 					.then(() => {
-						logger.info('Default settings added');
+						logger.info('Bootstrap setting applied: ' + id);
+					})
+					.catch((err) => {
+						logger.warn('Could not apply bootstrap setting ' + id + ': ' + err.message);
 					});
-			}
-			if (config.debug()) {
-				logger.info('Default setting setup not required');
-			}
+			}, Promise.resolve());
 		});
 };
 
@@ -117,16 +409,17 @@ const setupCertbotPlugins = () => {
 				let promises = [];
 
 				certificates.map(function (certificate) {
-					if (certificate.meta && certificate.meta.dns_challenge === true) {
+					// Lego has its DNS providers built in and is given the credentials when it's run
+					if (certificate.meta && certificate.meta.dns_challenge === true && certificate.meta.acme_client !== 'lego') {
 						if (plugins.indexOf(certificate.meta.dns_provider) === -1) {
 							plugins.push(certificate.meta.dns_provider);
 						}
 
 						// Make sure credentials file exists
-						const credentials_loc = '/etc/letsencrypt/credentials/credentials-' + certificate.id;
+						const credentials_loc = config.getPath('letsencrypt') + '/credentials/credentials-' + certificate.id;
 						// Escape single quotes and backslashes
 						const escapedCredentials = certificate.meta.dns_provider_credentials.replaceAll('\'', '\\\'').replaceAll('\\', '\\\\');
-						const credentials_cmd    = '[ -f \'' + credentials_loc + '\' ] || { mkdir -p /etc/letsencrypt/credentials 2> /dev/null; echo \'' + escapedCredentials + '\' > \'' + credentials_loc + '\' && chmod 600 \'' + credentials_loc + '\'; }';
+						const credentials_cmd    = '[ -f \'' + credentials_loc + '\' ] || { mkdir -p \'' + config.getPath('letsencrypt') + '/credentials\' 2> /dev/null; echo \'' + escapedCredentials + '\' > \'' + credentials_loc + '\' && chmod 600 \'' + credentials_loc + '\'; }';
 						promises.push(utils.exec(credentials_cmd));
 					}
 				});
@@ -144,6 +437,25 @@ const setupCertbotPlugins = () => {
 		});
 };
 
+/**
+ * Fetches the certificates in the bucket before nginx is given configs that use them.
+ * When the bucket can't be reached the copies already here are used.
+ *
+ * @returns {Promise}
+ */
+const setupCertificateStorage = () => {
+	if (config.getCertStorage() === null) {
+		return Promise.resolve();
+	}
+
+	return internalCertStorage.pull()
+		.then((changed) => {
+			logger.info('Fetched ' + changed.length + ' certificates from the bucket');
+		})
+		.catch((err) => {
+			logger.warn('Could not fetch certificates from the bucket, using the ones here: ' + err.message);
+		});
+};
 
 /**
  * Starts a timer to call run the logrotation binary every two days
@@ -168,6 +480,11 @@ const setupLogrotation = () => {
 module.exports = function () {
 	return setupDefaultUser()
 		.then(setupDefaultSettings)
+		.then(setupBootstrapSettings)
+		.then(internalSetup.init)
 		.then(setupCertbotPlugins)
+		.then(setupCertificateStorage)
+		.then(internalAdminHost.bootstrap)
+		.then(internalAdminListen.bootstrap)
 		.then(setupLogrotation);
 };
@@ -9,24 +9,51 @@ log_info 'Checking paths ...'
 if [ ! -d '/data' ]; then
 	log_fatal '/data is not mounted! Check your docker configuration.'
 fi
-# Ensure /etc/letsencrypt is mounted
-if [ ! -d '/etc/letsencrypt' ]; then
-	log_fatal '/etc/letsencrypt is not mounted! Check your docker configuration.'
+
+# Where the backend keeps certificates and the configs it generates, see lib/config.js
+NGINX_CONFIG_DIR="${NGINX_CONFIG_DIR:-/data/nginx}"
+NGINX_CONFIG_DIR="${NGINX_CONFIG_DIR%/}"
+CUSTOM_SSL_DIR="${CUSTOM_SSL_DIR:-/data/custom_ssl}"
+CUSTOM_SSL_DIR="${CUSTOM_SSL_DIR%/}"
+LETSENCRYPT_DIR="${LETSENCRYPT_DIR:-/etc/letsencrypt}"
+LETSENCRYPT_DIR="${LETSENCRYPT_DIR%/}"
+INTERNAL_CA_DIR="${INTERNAL_CA_DIR:-/data/internal_ca}"
+INTERNAL_CA_DIR="${INTERNAL_CA_DIR%/}"
+ACCESS_DIR="${ACCESS_DIR:-/data/access}"
+ACCESS_DIR="${ACCESS_DIR%/}"
+LOGS_DIR="${LOGS_DIR:-/data/logs}"
+LOGS_DIR="${LOGS_DIR%/}"
+
+# Created when they're first needed, by the backend or whoever puts files in them
+UPSTREAM_CA_DIR="${UPSTREAM_CA_DIR:-/data/upstream_ca}"
+ANALYTICS_DIR="${ANALYTICS_DIR:-/data/analytics}"
+FALLBACK_DIR="${FALLBACK_DIR:-/data/fallback}"
+SERVED_FILES_DIR="${SERVED_FILES_DIR:-/data/served-files}"
+DEPLOY_HOOKS_DIR="${DEPLOY_HOOKS_DIR:-/data/deploy-hooks}"
+SSH_DIR="${SSH_DIR:-/data/ssh}"
+WIREGUARD_DIR="${WIREGUARD_DIR:-/data/wireguard}"
+
+# Ensure the letsencrypt folder is mounted
+if [ ! -d "$LETSENCRYPT_DIR" ]; then
+	log_fatal "$LETSENCRYPT_DIR is not mounted! Check your docker configuration."
 fi
 
 # Create required folders
 mkdir -p \
-	/data/nginx \
-	/data/custom_ssl \
-	/data/logs \
-	/data/access \
-	/data/nginx/default_host \
-	/data/nginx/default_www \
-	/data/nginx/proxy_host \
-	/data/nginx/redirection_host \
-	/data/nginx/stream \
-	/data/nginx/dead_host \
-	/data/nginx/temp \
+	"$NGINX_CONFIG_DIR" \
+	"$CUSTOM_SSL_DIR" \
+	"$INTERNAL_CA_DIR" \
+	"$LOGS_DIR" \
+	"$ACCESS_DIR" \
+	"$NGINX_CONFIG_DIR/default_host" \
+	"$NGINX_CONFIG_DIR/default_www" \
+	"$NGINX_CONFIG_DIR/admin_host" \
+	"$NGINX_CONFIG_DIR/proxy_host" \
+	"$NGINX_CONFIG_DIR/redirection_host" \
+	"$NGINX_CONFIG_DIR/stream" \
+	"$NGINX_CONFIG_DIR/dead_host" \
+	"$NGINX_CONFIG_DIR/status_page" \
+	"$NGINX_CONFIG_DIR/temp" \
 	/data/letsencrypt-acme-challenge \
 	/run/nginx \
 	/tmp/nginx/body \
@@ -35,6 +62,26 @@ mkdir -p \
 	/var/lib/nginx/cache/private \
 	/var/cache/nginx/proxy_temp
 
+# The admin interface listens with plain http until the backend writes the admin-listen setting
+if [ ! -f "$NGINX_CONFIG_DIR/admin_listen.conf" ]; then
+	printf 'listen 81 default;\nlisten [::]:81 default;\n' > "$NGINX_CONFIG_DIR/admin_listen.conf"
+fi
+
+# Included in nginx.conf, the backend writes the addresses in the blocked-clients setting to it
+touch "$NGINX_CONFIG_DIR/blocked_clients.conf"
+
+# nginx.conf includes the generated configs from /data/nginx, point it at the folder they're written to instead
+if [ "$NGINX_CONFIG_DIR" != '/data/nginx' ]; then
+	log_info "Generated configs are in $NGINX_CONFIG_DIR"
+	sed -i "s#/data/nginx/#$NGINX_CONFIG_DIR/#g" /etc/nginx/nginx.conf /etc/nginx/conf.d/production.conf
+fi
+
+# The same for the logs nginx and logrotate write to outside of the hosts
+if [ "$LOGS_DIR" != '/data/logs' ]; then
+	log_info "Logs are in $LOGS_DIR"
+	sed -i "s#/data/logs/#$LOGS_DIR/#g" /etc/nginx/nginx.conf /etc/nginx/conf.d/default.conf /etc/nginx/conf.d/include/log.conf /etc/logrotate.d/nginx-proxy-manager
+fi
+
 touch /var/log/nginx/error.log || true
 chmod 777 /var/log/nginx/error.log || true
 chmod -R 777 /var/cache/nginx || true
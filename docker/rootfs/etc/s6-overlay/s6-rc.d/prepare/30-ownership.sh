@@ -10,7 +10,16 @@ chown root /tmp/nginx
 
 # npm user and group
 chown -R "$PUID:$PGID" /data
-chown -R "$PUID:$PGID" /etc/letsencrypt
+chown -R "$PUID:$PGID" "$LETSENCRYPT_DIR"
+# Outside of /data when they were moved
+chown -R "$PUID:$PGID" "$NGINX_CONFIG_DIR"
+chown -R "$PUID:$PGID" "$CUSTOM_SSL_DIR"
+for dir in "$INTERNAL_CA_DIR" "$ACCESS_DIR" "$LOGS_DIR" "$UPSTREAM_CA_DIR" "$ANALYTICS_DIR" "$FALLBACK_DIR" "$SERVED_FILES_DIR" "$DEPLOY_HOOKS_DIR" "$SSH_DIR" "$WIREGUARD_DIR"; do
+	case "$dir" in
+		/data/*) ;;
+		*) if [ -d "$dir" ]; then chown -R "$PUID:$PGID" "$dir"; fi ;;
+	esac
+done
 chown -R "$PUID:$PGID" /run/nginx
 chown -R "$PUID:$PGID" /tmp/nginx
 chown -R "$PUID:$PGID" /var/cache/nginx
@@ -36,4 +36,4 @@ process_folder () {
 }
 
 process_folder /etc/nginx/conf.d
-process_folder /data/nginx
+process_folder "$NGINX_CONFIG_DIR"
@@ -84,6 +84,12 @@ function fetch(verb, path, data, options) {
                 if (typeof xhr.responseJSON !== 'undefined' && typeof xhr.responseJSON.error !== 'undefined' && typeof xhr.responseJSON.error.message !== 'undefined') {
                     error_thrown = xhr.responseJSON.error.message;
                     code         = xhr.responseJSON.error.code || 500;
+
+                    // Says what's missing, so a denial can be put right without reading the logs
+                    let denied = xhr.responseJSON.error.denied;
+                    if (denied && denied.missing.length) {
+                        error_thrown += ' - ' + denied.permission + ' needs ' + denied.missing.join(', ');
+                    }
                 }
 
                 reject(new ApiError(error_thrown, xhr.responseText, code));
@@ -106,6 +112,32 @@ function makeExpansionString(expand) {
     return items.join(',');
 }
 
+/**
+ * Waits for a job to finish, telling onProgress about it each time it changes
+ *
+ * @param   {Object}    job
+ * @param   {Function}  [onProgress]  called with the job
+ * @returns {Promise}   resolves with the result of the job
+ */
+function followJob(job, onProgress) {
+    if (onProgress) {
+        onProgress(job);
+    }
+
+    if (job.status === 'succeeded') {
+        return Promise.resolve(job.result);
+    }
+
+    if (job.status === 'failed') {
+        return Promise.reject(new ApiError(job.error.message, JSON.stringify({error: job.error}), job.error.code));
+    }
+
+    return fetch('get', 'jobs/' + job.id + '?wait=30', undefined, {timeout: 60000})
+        .then(function (next) {
+            return followJob(next, onProgress);
+        });
+}
+
 /**
  * @param   {String}   path
  * @param   {Array}    [expand]
@@ -585,6 +617,17 @@ module.exports = {
             }
         },
 
+        HostDefaults: {
+            /**
+             * What new hosts of each type start with
+             *
+             * @returns {Promise}
+             */
+            get: function () {
+                return fetch('get', 'nginx/host-defaults');
+            }
+        },
+
         AccessLists: {
             /**
              * @param   {Array}    [expand]
@@ -633,9 +676,17 @@ module.exports = {
             },
 
             /**
-             * @param {Object}  data
+             * @param {Object}    data
+             * @param {Function}  [onProgress]  called with the job issuing the certificate as it goes
              */
-            create: function (data) {
+            create: function (data, onProgress) {
+                if (onProgress) {
+                    return fetch('post', 'nginx/certificates?async=true', data)
+                        .then(function (result) {
+                            // Changes needing approval are still answered with the change request
+                            return typeof result.phase === 'string' ? followJob(result, onProgress) : result;
+                        });
+                }
 
                 const timeout = 180000 + (data && data.meta && data.meta.propagation_seconds ? Number(data.meta.propagation_seconds) * 1000 : 0);
                 return fetch('post', 'nginx/certificates', data, {timeout});
@@ -678,11 +729,19 @@ module.exports = {
             },
 
             /**
-             * @param   {Number}  id
+             * @param   {Number}    id
+             * @param   {Function}  [onProgress]  called with the job renewing the certificate as it goes
              * @returns {Promise}
              */
-            renew: function (id, timeout = 180000) {
-                return fetch('post', 'nginx/certificates/' + id + '/renew', undefined, {timeout});
+            renew: function (id, onProgress) {
+                if (onProgress) {
+                    return fetch('post', 'nginx/certificates/' + id + '/renew?async=true')
+                        .then(function (job) {
+                            return followJob(job, onProgress);
+                        });
+                }
+
+                return fetch('post', 'nginx/certificates/' + id + '/renew', undefined, {timeout: 180000});
             },
 
             /**
@@ -726,6 +785,16 @@ module.exports = {
         }
     },
 
+    Features: {
+
+        /**
+         * @returns {Promise}
+         */
+        getAll: function () {
+            return fetch('get', 'features');
+        }
+    },
+
     Settings: {
 
         /**
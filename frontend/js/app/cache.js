@@ -1,9 +1,11 @@
 const UserModel = require('../models/user');
 
 let cache = {
-    User:    new UserModel.Model(),
-    locale:  'zh',
-    version: null
+    User:     new UserModel.Model(),
+    locale:   'zh',
+    version:  null,
+    // Ids of the experimental features that are on for the user, views only show what's here
+    features: []
 };
 
 module.exports = cache;
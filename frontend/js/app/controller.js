@@ -122,8 +122,20 @@ module.exports = {
      */
     showNginxProxyForm: function (model) {
         if (Cache.User.isAdmin() || Cache.User.canManage('proxy_hosts')) {
-            require(['./main', './nginx/proxy/form'], function (App, View) {
-                App.UI.showModalDialog(new View({model: model}));
+            require(['./main', './nginx/proxy/form', '../models/proxy-host'], function (App, View, HostModel) {
+                if (model) {
+                    App.UI.showModalDialog(new View({model: model}));
+                    return;
+                }
+
+                // New hosts start with the defaults from the settings
+                App.Api.Nginx.HostDefaults.get()
+                    .catch(function () {
+                        return {};
+                    })
+                    .then(function (defaults) {
+                        App.UI.showModalDialog(new View({model: new HostModel.Model(defaults['proxy-host'] || {})}));
+                    });
             });
         }
     },
@@ -162,8 +174,20 @@ module.exports = {
      */
     showNginxRedirectionForm: function (model) {
         if (Cache.User.isAdmin() || Cache.User.canManage('redirection_hosts')) {
-            require(['./main', './nginx/redirection/form'], function (App, View) {
-                App.UI.showModalDialog(new View({model: model}));
+            require(['./main', './nginx/redirection/form', '../models/redirection-host'], function (App, View, HostModel) {
+                if (model) {
+                    App.UI.showModalDialog(new View({model: model}));
+                    return;
+                }
+
+                // New hosts start with the defaults from the settings
+                App.Api.Nginx.HostDefaults.get()
+                    .catch(function () {
+                        return {};
+                    })
+                    .then(function (defaults) {
+                        App.UI.showModalDialog(new View({model: new HostModel.Model(defaults['redirection-host'] || {})}));
+                    });
             });
         }
     },
@@ -242,8 +266,20 @@ module.exports = {
      */
     showNginxDeadForm: function (model) {
         if (Cache.User.isAdmin() || Cache.User.canManage('dead_hosts')) {
-            require(['./main', './nginx/dead/form'], function (App, View) {
-                App.UI.showModalDialog(new View({model: model}));
+            require(['./main', './nginx/dead/form', '../models/dead-host'], function (App, View, HostModel) {
+                if (model) {
+                    App.UI.showModalDialog(new View({model: model}));
+                    return;
+                }
+
+                // New hosts start with the defaults from the settings
+                App.Api.Nginx.HostDefaults.get()
+                    .catch(function () {
+                        return {};
+                    })
+                    .then(function (defaults) {
+                        App.UI.showModalDialog(new View({model: new HostModel.Model(defaults['dead-host'] || {})}));
+                    });
             });
         }
     },
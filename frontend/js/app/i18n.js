@@ -1,4 +1,4 @@
-const Cache    = ('./cache');
+const Cache    = require('./cache');
 const messages = require('../i18n/messages.json');
 
 /**
@@ -21,3 +21,13 @@ module.exports = function (namespace, key, data) {
 
     return '(MISSING: ' + namespace + '/' + key + ')';
 };
+
+/**
+ * The messages a user's language is shown with, there are only English and Chinese ones
+ *
+ * @param   {String}  [locale]  of the user, ie: zh-TW
+ * @returns {String}
+ */
+module.exports.getLocale = function (locale) {
+    return locale === 'en' ? 'en' : 'zh';
+};
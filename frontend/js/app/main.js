@@ -97,7 +97,12 @@ const App = Mn.Application.extend({
         return Api.Users.getById('me', ['permissions'])
             .then(response => {
                 Cache.User.set(response);
+                Cache.locale = i18n.getLocale(response.locale);
                 Tokens.setCurrentName(response.nickname || response.name);
+                return Api.Features.getAll();
+            })
+            .then(features => {
+                Cache.features = features.filter(feature => feature.enabled).map(feature => feature.id);
             });
     },
 
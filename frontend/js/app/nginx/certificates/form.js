@@ -26,6 +26,8 @@ module.exports = Mn.View.extend({
     ui: {
         form:                                 'form',
         loader_content:                       '.loader-content',
+        job_phase:                            '.job-phase',
+        job_progress:                         '.job-progress',
         non_loader_content:                   '.non-loader-content',
         le_error_info:                        '#le-error-info',
         domain_names:                         'input[name="domain_names"]',
@@ -177,7 +179,16 @@ module.exports = Mn.View.extend({
                 }
             })
                 .then(() => {
-                    return App.Api.Nginx.Certificates.create(data);
+                    if (data.provider !== 'letsencrypt') {
+                        return App.Api.Nginx.Certificates.create(data);
+                    }
+
+                    // Shows how far along certbot is, instead of only a spinner
+                    this.ui.job_progress.show();
+                    return App.Api.Nginx.Certificates.create(data, job => {
+                        this.ui.job_phase.text(i18n('ssl', 'phase-' + job.phase));
+                        this.ui.job_progress.find('.progress-bar').css('width', job.progress + '%');
+                    });
                 })
                 .then(result => {
                     this.model.set(result);
@@ -277,6 +288,7 @@ module.exports = Mn.View.extend({
         this.ui.dns_challenge_content.hide();
         this.ui.credentials_file_content.hide();
         this.ui.loader_content.hide();
+        this.ui.job_progress.hide();
         this.ui.le_error_info.hide();
         if (this.ui.domain_names[0]) {
             const domainNames = this.ui.domain_names[0].value.split(',');
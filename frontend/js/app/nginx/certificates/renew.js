@@ -7,15 +7,20 @@ module.exports = Mn.View.extend({
     className: 'modal-dialog',
 
     ui: {
-        waiting: '.waiting',
-        error:   '.error',
-        close:   'button.cancel'
+        waiting:      '.waiting',
+        job_phase:    '.job-phase',
+        progress_bar: '.progress-bar',
+        error:        '.error',
+        close:        'button.cancel'
     },
 
     onRender: function () {
         this.ui.error.hide();
 
-        App.Api.Nginx.Certificates.renew(this.model.get('id'))
+        App.Api.Nginx.Certificates.renew(this.model.get('id'), (job) => {
+            this.ui.job_phase.text(App.i18n('ssl', 'phase-' + job.phase));
+            this.ui.progress_bar.css('width', job.progress + '%');
+        })
             .then((result) => {
                 this.model.set(result);
                 setTimeout(() => {
@@ -1,7 +1,9 @@
-const Mn          = require('backbone.marionette');
-const App         = require('../../main');
-const StreamModel = require('../../../models/stream');
-const template    = require('./form.ejs');
+const Mn                     = require('backbone.marionette');
+const App                    = require('../../main');
+const StreamModel            = require('../../../models/stream');
+const template               = require('./form.ejs');
+const accessListItemTemplate = require('../proxy/access-list-item.ejs');
+const Helpers                = require('../../../lib/helpers');
 
 require('jquery-serializejson');
 require('jquery-mask-plugin');
@@ -15,6 +17,7 @@ module.exports = Mn.View.extend({
         form:       'form',
         forwarding_host: 'input[name="forwarding_host"]',
         type_error: '.forward-type-error',
+        access_list_select: 'select[name="access_list_id"]',
         buttons:    '.modal-footer button',
         switches:   '.custom-switch-input',
         cancel:     'button.cancel',
@@ -47,6 +50,7 @@ module.exports = Mn.View.extend({
             data.forwarding_port = parseInt(data.forwarding_port, 10);
             data.tcp_forwarding  = !!data.tcp_forwarding;
             data.udp_forwarding  = !!data.udp_forwarding;
+            data.access_list_id  = parseInt(data.access_list_id, 10) || 0;
 
             let method = App.Api.Nginx.Streams.create;
             let is_new = true;
@@ -76,6 +80,40 @@ module.exports = Mn.View.extend({
         }
     },
 
+    onRender: function () {
+        let view = this;
+
+        // Access Lists, streams can only use the ones with client rules
+        this.ui.access_list_select.selectize({
+            valueField:       'id',
+            labelField:       'name',
+            searchField:      ['name'],
+            create:           false,
+            preload:          true,
+            allowEmptyOption: true,
+            render:           {
+                option: function (item) {
+                    item.i18n         = App.i18n;
+                    item.formatDbDate = Helpers.formatDbDate;
+                    return accessListItemTemplate(item);
+                }
+            },
+            load:             function (query, callback) {
+                App.Api.Nginx.AccessLists.getAll(['items', 'clients'])
+                    .then(rows => {
+                        callback(rows.filter(row => row.clients && row.clients.length));
+                    })
+                    .catch(err => {
+                        console.error(err);
+                        callback();
+                    });
+            },
+            onLoad:           function () {
+                view.ui.access_list_select[0].selectize.setValue(view.model.get('access_list_id'));
+            }
+        });
+    },
+
     initialize: function (options) {
         if (typeof options.model === 'undefined' || !options.model) {
             this.model = new StreamModel.Model();
@@ -42,6 +42,7 @@ module.exports = Mn.View.extend({
             }
 
             data.is_disabled = typeof data.is_disabled !== 'undefined' ? !!data.is_disabled : false;
+            data.locale      = data.locale || null;
             this.ui.buttons.prop('disabled', true).addClass('btn-disabled');
             let method = App.Api.Users.create;
 
@@ -54,7 +55,14 @@ module.exports = Mn.View.extend({
             method(data)
                 .then(result => {
                     if (result.id === App.Cache.User.get('id')) {
+                        let locale_changed = App.i18n.getLocale(result.locale) !== App.Cache.locale;
                         App.Cache.User.set(result);
+
+                        // The interface is rendered in the new language from the start
+                        if (locale_changed) {
+                            window.location.reload();
+                            return;
+                        }
                     }
 
                     if (view.model.get('id') !== App.Cache.User.get('id')) {
@@ -14,9 +14,11 @@ const model = Backbone.Model.extend({
             tcp_forwarding:  true,
             udp_forwarding:  false,
             enabled:         true,
+            access_list_id:  0,
             meta:            {},
             // The following are expansions:
-            owner:           null
+            owner:           null,
+            access_list:     null
         };
     }
 });
@@ -11,6 +11,7 @@ const model = Backbone.Model.extend({
             nickname:    '',
             email:       '',
             is_disabled: false,
+            locale:      null,
             roles:       [],
             permissions: null
         };
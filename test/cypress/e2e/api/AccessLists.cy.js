@@ -0,0 +1,151 @@
+/// <reference types="cypress" />
+
+describe('Access Lists endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to allow a hostname in an access list', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/access-lists',
+			data:  {
+				name:        'Home',
+				satisfy_any: false,
+				pass_auth:   false,
+				items:       [],
+				clients:     [
+					{
+						directive: 'allow',
+						address:   'localhost.localdomain',
+					},
+					{
+						directive: 'allow',
+						address:   '10.0.0.0/8',
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/access-lists', data);
+			expect(data.clients.map((client) => client.address)).to.include('localhost.localdomain');
+		});
+	});
+
+	it('Should not be able to allow an invalid hostname', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/access-lists',
+			data:          {
+				name:    'Broken',
+				items:   [],
+				clients: [
+					{
+						directive: 'allow',
+						address:   'home_router',
+					},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to restrict a stream with an access list', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/access-lists',
+			data:  {
+				name:    'Database clients',
+				items:   [],
+				clients: [
+					{
+						directive: 'allow',
+						address:   '10.0.0.0/8',
+					},
+				],
+			},
+		}).then((list) => {
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/nginx/streams',
+				data:  {
+					incoming_port:   15432,
+					forwarding_host: '127.0.0.1',
+					forwarding_port: 5432,
+					tcp_forwarding:  true,
+					udp_forwarding:  false,
+					access_list_id:  list.id,
+				},
+			}).then((stream) => {
+				cy.validateSwaggerSchema('post', 201, '/nginx/streams', stream);
+				expect(stream.access_list_id).to.equal(list.id);
+
+				// The stream can't use a list without client rules
+				cy.task('backendApiPut', {
+					token:         token,
+					path:          '/api/nginx/access-lists/' + list.id,
+					data:          {
+						clients: [],
+					},
+					returnOnError: true,
+				}).then((data) => {
+					expect(data.error.code).to.equal(400);
+				});
+
+				cy.task('backendApiDelete', {
+					token: token,
+					path:  '/api/nginx/streams/' + stream.id,
+				});
+			});
+		});
+	});
+
+	it('Should not be able to restrict a stream with a list without client rules', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/access-lists',
+			data:  {
+				name:  'Password only',
+				items: [
+					{
+						username: 'admin',
+						password: 'changeme',
+					},
+				],
+			},
+		}).then((list) => {
+			cy.task('backendApiPost', {
+				token:         token,
+				path:          '/api/nginx/streams',
+				data:          {
+					incoming_port:   15433,
+					forwarding_host: '127.0.0.1',
+					forwarding_port: 5432,
+					tcp_forwarding:  true,
+					access_list_id:  list.id,
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+		});
+	});
+
+	it('Should not be able to change a password while access list passwords are off', function() {
+		cy.task('backendApiPost', {
+			path:          '/api/access-list-passwords/1',
+			data:          {
+				password: 'a-new-password',
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
+});
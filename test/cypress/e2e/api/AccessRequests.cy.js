@@ -0,0 +1,56 @@
+/// <reference types="cypress" />
+
+describe('Access Requests endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to get the access requests', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/access-requests?status=pending',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/access-requests', data);
+			expect(data).to.be.an('array');
+		});
+	});
+
+	it('Should reject turning access requests on without captcha keys', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/access-requests',
+			data:  {
+				value: 'on',
+				meta:  {provider: 'turnstile', site_key: '', secret: '', hours: 24}
+			},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should not have a portal while access requests are off', function() {
+		cy.task('backendApiGet', {
+			path:          '/api/access-requests/portal/1',
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
+	it('Should not be able to approve an access request that does not exist', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/access-requests/999999/approve',
+			data:          {hours: 1},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
+});
@@ -0,0 +1,103 @@
+/// <reference types="cypress" />
+
+describe('Automation rules endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to add, change and remove an automation rule', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/automation/rules',
+			data:  {
+				name:    'Fail over shop',
+				trigger: {
+					source:      'health',
+					event:       'offline',
+					object_type: 'proxy-host',
+					for_minutes: 10,
+				},
+				actions: [
+					{type: 'switch_upstream'},
+					{type: 'notify', severity: 'critical'},
+				],
+				enabled: false,
+			},
+		}).then((rule) => {
+			cy.validateSwaggerSchema('post', 201, '/automation/rules', rule);
+			expect(rule.trigger.for_minutes).to.be.equal(10);
+			expect(rule.actions).to.have.lengthOf(2);
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/automation/rules/' + rule.id,
+				data:  {
+					actions: [
+						{type: 'webhook', url: 'https://hooks.example.com/npm'},
+					],
+				},
+			}).then((data) => {
+				cy.validateSwaggerSchema('put', 200, '/automation/rules/{ruleID}', data);
+				expect(data.actions[0].type).to.be.equal('webhook');
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/automation/rules',
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/automation/rules', data);
+			});
+
+			cy.task('backendApiDelete', {
+				token: token,
+				path:  '/api/automation/rules/' + rule.id,
+			}).then((data) => {
+				cy.validateSwaggerSchema('delete', 200, '/automation/rules/{ruleID}', data);
+				expect(data).to.be.equal(true);
+			});
+		});
+	});
+
+	it('Should not add a rule that acts on a host without knowing which', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/automation/rules',
+			data:          {
+				name:    'Disable anything',
+				trigger: {
+					source: 'renewal',
+				},
+				actions: [
+					{type: 'disable_host'},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should not add a rule that waits on a state without the event', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/automation/rules',
+			data:          {
+				name:    'Waiting',
+				trigger: {
+					source:      'health',
+					for_minutes: 5,
+				},
+				actions: [
+					{type: 'notify'},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+});
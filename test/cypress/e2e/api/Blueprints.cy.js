@@ -0,0 +1,81 @@
+/// <reference types="cypress" />
+
+describe('Blueprints endpoints', () => {
+	let token;
+	let blueprintId;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to create a blueprint', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/blueprints',
+			data:  {
+				name:        'Websocket app',
+				description: 'Created by the API tests',
+				host:        {
+					allow_websocket_upgrade: true,
+					block_exploits:          true,
+					advanced_config:         'proxy_set_header X-Site {{domain_name}};'
+				}
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/blueprints', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.greaterThan(0);
+			expect(data.host.block_exploits).to.be.equal(true);
+			blueprintId = data.id;
+		});
+	});
+
+	it('Should not be able to put the domain names in a blueprint', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/blueprints',
+			data:  {
+				name: 'Cypress',
+				host: {
+					domain_names: ['blueprint.example.com']
+				}
+			},
+			returnOnError: true
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 422, '/nginx/blueprints', data);
+			expect(data.error.code).to.equal(422);
+		});
+	});
+
+	it('Should be able to create a proxy host from the blueprint', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/blueprints/' + blueprintId + '/hosts',
+			data:  {
+				domain_names: ['blueprint.example.com'],
+				forward_host: '1.1.1.1',
+				forward_port: 80
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/blueprints/{blueprintID}/hosts', data);
+			expect(data.domain_names).to.deep.equal(['blueprint.example.com']);
+			expect(data.allow_websocket_upgrade).to.be.equal(true);
+			expect(data.block_exploits).to.be.equal(true);
+			expect(data.advanced_config).to.be.equal('proxy_set_header X-Site blueprint.example.com;');
+			expect(data.meta.blueprint_id).to.be.equal(blueprintId);
+		});
+	});
+
+	it('Should be able to delete the blueprint', function() {
+		cy.task('backendApiDelete', {
+			token: token,
+			path:  '/api/nginx/blueprints/' + blueprintId,
+		}).then((data) => {
+			cy.validateSwaggerSchema('delete', 200, '/nginx/blueprints/{blueprintID}', data);
+			expect(data).to.be.equal(true);
+		});
+	});
+
+});
@@ -25,6 +25,43 @@ describe('Certificates endpoints', () => {
 		});
 	});
 
+	it('Should refuse certificates the policy does not allow', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/certificate-policy',
+			data:  {
+				value: 'on',
+				meta:  {
+					max_validity_days: 90,
+				},
+			},
+		}).then((setting) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', setting);
+
+			// The test certificate is valid for longer than that
+			cy.task('backendApiPostFiles', {
+				token:         token,
+				path:          '/api/nginx/certificates/validate',
+				files:         {
+					certificate:     'test.example.com.pem',
+					certificate_key: 'test.example.com-key.pem',
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+				expect(data.error.message).to.contain('days');
+
+				cy.task('backendApiPut', {
+					token: token,
+					path:  '/api/settings/certificate-policy',
+					data:  {
+						value: 'off',
+					},
+				});
+			});
+		});
+	});
+
 	it('Custom certificate lifecycle', function() {
 		// Create custom cert
 		cy.task('backendApiPost', {
@@ -73,6 +110,231 @@ describe('Certificates endpoints', () => {
 		});
 	});
 
+	it('Should be able to get the pins of a certificate', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider:  'other',
+				nice_name: 'Pinned Certificate',
+			},
+		}).then((data) => {
+			cy.task('backendApiPostFiles', {
+				token: token,
+				path:  `/api/nginx/certificates/${data.id}/upload`,
+				files: {
+					certificate:     'test.example.com.pem',
+					certificate_key: 'test.example.com-key.pem',
+				},
+			}).then(() => {
+				cy.task('backendApiGet', {
+					token: token,
+					path:  `/api/nginx/certificates/${data.id}/pins`,
+				}).then((pins) => {
+					cy.validateSwaggerSchema('get', 200, '/nginx/certificates/{certID}/pins', pins);
+					expect(pins.current.sha256).to.have.length(44);
+					// Custom certificates aren't renewed, so they have no next key
+					expect(pins.next).to.equal(null);
+
+					cy.task('backendApiDelete', {
+						token: token,
+						path:  `/api/nginx/certificates/${data.id}`,
+					});
+				});
+			});
+		});
+	});
+
+	it('Should be able to get the TLSA records of a certificate', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider:  'other',
+				nice_name: 'DANE Certificate',
+			},
+		}).then((data) => {
+			cy.task('backendApiPostFiles', {
+				token: token,
+				path:  `/api/nginx/certificates/${data.id}/upload`,
+				files: {
+					certificate:     'test.example.com.pem',
+					certificate_key: 'test.example.com-key.pem',
+				},
+			}).then(() => {
+				cy.task('backendApiGet', {
+					token: token,
+					path:  `/api/nginx/certificates/${data.id}/tlsa?port=25`,
+				}).then((tlsa) => {
+					cy.validateSwaggerSchema('get', 200, '/nginx/certificates/{certID}/tlsa', tlsa);
+					tlsa.records.forEach((record) => {
+						expect(record.name).to.match(/^_25\._tcp\./);
+						expect(record.data).to.have.length(64);
+					});
+
+					// Custom certificates have no DNS provider to publish them through
+					cy.task('backendApiPut', {
+						token:         token,
+						path:          `/api/nginx/certificates/${data.id}/tlsa`,
+						data:          {
+							tlsa: [{port: 25, protocol: 'tcp'}],
+						},
+						returnOnError: true,
+					}).then((result) => {
+						expect(result.error.code).to.equal(400);
+
+						cy.task('backendApiDelete', {
+							token: token,
+							path:  `/api/nginx/certificates/${data.id}`,
+						});
+					});
+				});
+			});
+		});
+	});
+
+	it('Should not keep the key of a custom certificate', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider:  'other',
+				nice_name: 'Reused Key Certificate',
+			},
+		}).then((data) => {
+			// Only renewed certificates have a key to keep
+			cy.task('backendApiPut', {
+				token:         token,
+				path:          `/api/nginx/certificates/${data.id}/reuse-key`,
+				data:          {
+					reuse_key: true,
+				},
+				returnOnError: true,
+			}).then((result) => {
+				expect(result.error.code).to.equal(400);
+
+				cy.task('backendApiDelete', {
+					token: token,
+					path:  `/api/nginx/certificates/${data.id}`,
+				});
+			});
+		});
+	});
+
+	it('Should be able to deploy a certificate to a mounted path', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider: 'other',
+				nice_name: 'Deployed Certificate',
+			},
+		}).then((data) => {
+			const id = data.id;
+
+			cy.task('backendApiPostFiles', {
+				token: token,
+				path:  `/api/nginx/certificates/${id}/upload`,
+				files:  {
+					certificate: 'test.example.com.pem',
+					certificate_key: 'test.example.com-key.pem',
+				},
+			}).then(() => {
+				cy.task('backendApiPut', {
+					token: token,
+					path:  `/api/nginx/certificates/${id}/deploy-hooks`,
+					data:  {
+						deploy_hooks: [
+							{
+								type: 'path',
+								path: '/mnt/cypress/certs',
+							},
+						],
+					},
+				}).then((data) => {
+					cy.validateSwaggerSchema('put', 200, '/nginx/certificates/{certID}/deploy-hooks', data);
+					expect(data.deploy_hooks).to.have.length(1);
+
+					cy.task('backendApiPost', {
+						token: token,
+						path:  `/api/nginx/certificates/${id}/deploy`,
+					}).then((data) => {
+						cy.validateSwaggerSchema('post', 200, '/nginx/certificates/{certID}/deploy', data);
+						expect(data[0]).to.have.property('success', true);
+					});
+				});
+			});
+		});
+	});
+
+	it('Should not be able to deploy a certificate outside of a mount', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider: 'other',
+				nice_name: 'Misplaced Certificate',
+			},
+		}).then((data) => {
+			cy.task('backendApiPut', {
+				token:         token,
+				path:          `/api/nginx/certificates/${data.id}/deploy-hooks`,
+				data:          {
+					deploy_hooks: [
+						{
+							type: 'path',
+							path: '/mnt/../etc/nginx',
+						},
+					],
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+		});
+	});
+
+	it('Should be able to get the targets certificates can be deployed to', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/certificates/deploy-targets',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/certificates/deploy-targets', data);
+			expect(data).to.have.property('proxmox');
+			expect(data.proxmox).to.not.have.property('action');
+		});
+	});
+
+	it('Should not be able to deploy to a target without its options', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				provider: 'other',
+				nice_name: 'Proxmox Certificate',
+			},
+		}).then((data) => {
+			cy.task('backendApiPut', {
+				token:         token,
+				path:          `/api/nginx/certificates/${data.id}/deploy-hooks`,
+				data:          {
+					deploy_hooks: [
+						{
+							type:    'target',
+							target:  'proxmox',
+							options: {
+								host: 'pve.lan',
+							},
+						},
+					],
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+		});
+	});
+
 	it('Request Certificate - CVE-2024-46256/CVE-2024-46257', function() {
 		cy.task('backendApiPost', {
 			token: token,
@@ -88,12 +350,186 @@ describe('Certificates endpoints', () => {
 			},
 			returnOnError: true,
 		}).then((data) => {
-			cy.validateSwaggerSchema('post', 400, '/nginx/certificates', data);
+			cy.validateSwaggerSchema('post', 422, '/nginx/certificates', data);
 			expect(data).to.have.property('error');
 			expect(data.error).to.have.property('message');
 			expect(data.error).to.have.property('code');
-			expect(data.error.code).to.equal(400);
+			expect(data.error.code).to.equal(422);
 			expect(data.error.message).to.contain('data/domain_names/0 must match pattern');
+			expect(data.error.fields[0].field).to.equal('domain_names.0');
+		});
+	});
+
+	it('Should be able to check the propagation of a DNS record', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/tools/dns-check',
+			data:  {
+				name: '_acme-challenge.example.com',
+				type: 'TXT'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/tools/dns-check', data);
+			expect(data).to.have.property('status');
+			expect(data.resolvers.map((item) => item.resolver)).to.include('AliDNS');
+		});
+	});
+
+	it('Should be able to follow the creation of a certificate as a job', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates?async=true',
+			data:  {
+				provider:  'other',
+				nice_name: 'Test Job Certificate'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 202, '/nginx/certificates', data);
+			expect(data).to.have.property('id');
+			expect(data.type).to.equal('certificate-create');
+
+			// The job answers as soon as it moves on, which might not be the end of it yet
+			const follow = (job) => {
+				return cy.task('backendApiGet', {
+					token: token,
+					path:  `/api/jobs/${job.id}?wait=10`
+				}).then((data) => {
+					cy.validateSwaggerSchema('get', 200, '/jobs/{jobID}', data);
+					return data.status === 'running' ? follow(data) : data;
+				});
+			};
+
+			follow(data).then((data) => {
+				expect(data.status).to.equal('succeeded');
+				expect(data.progress).to.equal(100);
+				expect(data.result).to.have.property('id');
+
+				cy.task('backendApiDelete', {
+					token: token,
+					path:  `/api/nginx/certificates/${data.result.id}`
+				}).then((data) => {
+					expect(data).to.be.equal(true);
+				});
+			});
+		});
+	});
+
+	it('Should be able to get suggestions for wildcard certificates', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/certificates/optimize'
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/certificates/optimize', data);
+			expect(data).to.be.an('array');
+		});
+	});
+
+	it('Should not be able to carry out a suggestion there is none for', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/certificates/optimize',
+			data:          {
+				domain: 'nothing-to-optimize.example.com'
+			},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
+	it('Should list certificates without a query for each one', function() {
+		cy.getQueryCount(token).then((before) => {
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/certificates?expand=owner',
+			}).then(() => {
+				cy.getQueryCount(token).then((after) => {
+					// The token's user, the rows, one query for each expansion, and the metrics themselves
+					expect(after - before).to.be.lessThan(12);
+				});
+			});
+		});
+	});
+
+	it('Should be able to change the contact of the accounts certbot registered', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/acme-accounts/contact',
+			data:  {
+				email: 'certificates@example.com'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/acme-accounts/contact', data);
+			expect(data.email).to.be.equal('certificates@example.com');
+		});
+
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/acme-accounts/contact',
+			data:          {},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to store EAB credentials without returning the HMAC key', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/acme-eab-credentials',
+			data:  {
+				name:       'Sectigo OV',
+				server:     'https://acme.sectigo.com/v2/OV',
+				kid:        'kid-1',
+				hmac_key:   'c2VjcmV0LXNlY3JldC1zZWNyZXQta2V5',
+				expires_on: '2099-01-31T00:00:00Z'
+			}
+		}).then((credentials) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/acme-eab-credentials', credentials);
+			expect(credentials).to.not.have.property('hmac_key');
+			expect(credentials.status).to.be.equal('valid');
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/nginx/acme-eab-credentials/' + credentials.id,
+				data:  {
+					name: 'Sectigo OV 2099'
+				}
+			}).then((data) => {
+				cy.validateSwaggerSchema('put', 200, '/nginx/acme-eab-credentials/{credentialID}', data);
+				expect(data.name).to.be.equal('Sectigo OV 2099');
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/acme-eab-credentials'
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/nginx/acme-eab-credentials', data);
+			});
+
+			cy.task('backendApiDelete', {
+				token: token,
+				path:  '/api/nginx/acme-eab-credentials/' + credentials.id
+			}).then((data) => {
+				cy.validateSwaggerSchema('delete', 200, '/nginx/acme-eab-credentials/{credentialID}', data);
+				expect(data).to.be.equal(true);
+			});
+		});
+	});
+
+	it('Should not store EAB credentials with a key that is too short', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/acme-eab-credentials',
+			data:          {
+				name:     'Short',
+				server:   'https://acme.sectigo.com/v2/OV',
+				kid:      'kid-2',
+				hmac_key: 'c2VjcmV0'
+			},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
 		});
 	});
 });
@@ -0,0 +1,60 @@
+/// <reference types="cypress" />
+
+describe('Change Requests endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to turn change requests on', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/change-requests',
+			data:  {
+				value: 'on',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('on');
+		});
+	});
+
+	it('Changes by admins are made without approval', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/dead-hosts',
+			data:  {
+				domain_names: ['change-requests.example.com'],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/dead-hosts', data);
+			expect(data).to.have.property('id');
+			expect(data.domain_names).to.deep.equal(['change-requests.example.com']);
+		});
+	});
+
+	it('Should be able to get the pending change requests', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/change-requests?status=pending',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/change-requests', data);
+			expect(data).to.be.an('array');
+		});
+	});
+
+	it('Should be able to turn change requests off', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/change-requests',
+			data:  {
+				value: 'off',
+			},
+		}).then((data) => {
+			expect(data.value).to.equal('off');
+		});
+	});
+});
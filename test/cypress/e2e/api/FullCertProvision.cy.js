@@ -59,4 +59,43 @@ describe('Full Certificate Provisions', () => {
 		});
 	});
 
+	it('Should be able to renew every certificate issued with Powerdns', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/dns-providers/powerdns/reissue-all',
+			data:  {
+				credentials: 'dns_powerdns_api_url = http://ns1.pdns:8081\r\ndns_powerdns_api_key = npm'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 202, '/dns-providers/{providerID}/reissue-all', data);
+			expect(data.provider).to.be.equal('powerdns');
+			expect(data.jobs.length).to.be.greaterThan(0);
+
+			const follow = (job) => {
+				return cy.task('backendApiGet', {
+					token: token,
+					path:  `/api/jobs/${job.id}?wait=30`
+				}).then((data) => {
+					return data.status === 'running' ? follow(data) : data;
+				});
+			};
+
+			follow(data.jobs[data.jobs.length - 1]).then((job) => {
+				expect(job.status).to.equal('succeeded');
+				expect(job.type).to.equal('certificate-renew');
+			});
+		});
+	});
+
+	it('Should not renew the certificates of a DNS provider there is no plugin for', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/dns-providers/not-a-provider/reissue-all',
+			data:          {},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
 });
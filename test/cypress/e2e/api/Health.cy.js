@@ -17,4 +17,90 @@ describe('Basic API checks', () => {
 			expect(data.openapi).to.be.equal('3.1.0');
 		});
 	});
+
+	it('Should send a request id with responses and errors', function () {
+		cy.request({
+			url:              '/api/nginx/proxy-hosts',
+			failOnStatusCode: false
+		}).then((response) => {
+			expect(response.status).to.be.equal(403);
+			expect(response.headers['x-request-id']).to.match(/^[0-9a-f-]{36}$/);
+			expect(response.body.error.request_id).to.be.equal(response.headers['x-request-id']);
+		});
+
+		cy.request({
+			url:     '/api/',
+			headers: {
+				'X-Request-Id': 'from-the-proxy.1'
+			}
+		}).then((response) => {
+			expect(response.headers['x-request-id']).to.be.equal('from-the-proxy.1');
+		});
+	});
+
+	it('Should serve each version of the API and say what is deprecated', function () {
+		cy.request('/api/v2/').then((response) => {
+			expect(response.headers['api-version']).to.be.equal('2');
+			expect(response.body.status).to.be.equal('OK');
+		});
+
+		cy.request({
+			url:              '/api/v9/',
+			failOnStatusCode: false
+		}).then((response) => {
+			expect(response.status).to.be.equal(400);
+		});
+
+		cy.getToken().then((token) => {
+			cy.request({
+				method:           'POST',
+				url:              '/api/v1/nginx/certificates',
+				headers:          {
+					Authorization: 'Bearer ' + token
+				},
+				body:             {},
+				failOnStatusCode: false
+			}).then((response) => {
+				expect(response.headers['api-version']).to.be.equal('1');
+				expect(response.headers.deprecation).to.match(/^@[0-9]+$/);
+				expect(response.headers.sunset).to.contain('GMT');
+				expect(response.headers.link).to.contain('</api/v2/nginx/certificates>; rel="successor-version"');
+			});
+
+			cy.request({
+				method:           'POST',
+				url:              '/api/v2/nginx/certificates',
+				headers:          {
+					Authorization: 'Bearer ' + token
+				},
+				body:             {},
+				failOnStatusCode: false
+			}).then((response) => {
+				expect(response.headers).to.not.have.property('deprecation');
+			});
+		});
+	});
+
+	it('Should say the setup is complete and not run its steps again', function () {
+		cy.task('backendApiGet', {
+			path: '/api/setup',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/setup', data);
+			expect(data.complete).to.be.equal(true);
+			expect(data.next).to.be.equal(null);
+		});
+
+		cy.request({
+			method:           'POST',
+			url:              '/api/setup/admin',
+			body:             {
+				email:    'second-admin@example.com',
+				password: 'changeme-again'
+			},
+			failOnStatusCode: false
+		}).then((response) => {
+			expect(response.status).to.be.equal(400);
+			expect(response.body.error.message).to.be.equal('Setup is already complete');
+		});
+	});
 });
@@ -0,0 +1,101 @@
+/// <reference types="cypress" />
+
+describe('Plugins endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to add, change and remove a plugin', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/plugins',
+			data:  {
+				name:    'Audit trail',
+				url:     'http://plugin.example.com:8080/hooks',
+				secret:  'a-long-enough-secret',
+				hooks:   ['host.saved', 'certificate.issued'],
+				enabled: false,
+			},
+		}).then((plugin) => {
+			cy.validateSwaggerSchema('post', 201, '/plugins', plugin);
+			expect(plugin.signed).to.be.equal(true);
+			expect(plugin).to.not.have.property('secret');
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/plugins/' + plugin.id,
+				data:  {
+					hooks: ['host.saved'],
+				},
+			}).then((data) => {
+				cy.validateSwaggerSchema('put', 200, '/plugins/{pluginID}', data);
+				expect(data.hooks).to.deep.equal(['host.saved']);
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/plugins',
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/plugins', data);
+			});
+
+			cy.task('backendApiDelete', {
+				token: token,
+				path:  '/api/plugins/' + plugin.id,
+			}).then((data) => {
+				cy.validateSwaggerSchema('delete', 200, '/plugins/{pluginID}', data);
+				expect(data).to.be.equal(true);
+			});
+		});
+	});
+
+	it('Should not add a plugin for a hook that does not exist', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/plugins',
+			data:          {
+				name:  'Unknown',
+				url:   'http://plugin.example.com:8080/hooks',
+				hooks: ['host.deleted'],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('A plugin that denies on errors should refuse saves it can not be asked about', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/plugins',
+			data:  {
+				name:     'Change freeze',
+				url:      'http://127.0.0.1:9/hooks',
+				hooks:    ['host.saving'],
+				on_error: 'deny',
+				timeout:  1,
+			},
+		}).then((plugin) => {
+			cy.task('backendApiPost', {
+				token:         token,
+				path:          '/api/nginx/dead-hosts',
+				data:          {
+					domain_names: ['frozen.example.com'],
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+				expect(data.error.message).to.contain('Change freeze');
+			});
+
+			cy.task('backendApiDelete', {
+				token: token,
+				path:  '/api/plugins/' + plugin.id,
+			});
+		});
+	});
+});
@@ -0,0 +1,83 @@
+/// <reference types="cypress" />
+
+describe('Projects endpoints', () => {
+	let token;
+	let projectId;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to create a project', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/projects',
+			data:  {
+				name:        'Cypress',
+				description: 'Created by the API tests'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/projects', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.greaterThan(0);
+			projectId = data.id;
+		});
+	});
+
+	it('Should reject a project with unknown fields', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/projects',
+			data:  {
+				name:  'Cypress',
+				color: 'red'
+			},
+			returnOnError: true
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 422, '/nginx/projects', data);
+			expect(data.error.code).to.equal(422);
+			expect(data.error.fields).to.deep.include({field: 'color', message: 'is not allowed'});
+		});
+	});
+
+	it('Should be able to add a proxy host to the project', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['project.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				project_id:     projectId
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data).to.have.property('project_id', projectId);
+		});
+	});
+
+	it('Should filter proxy hosts by project', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts?project_id=' + projectId,
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts', data);
+			expect(data.length).to.be.equal(1);
+			expect(data[0].domain_names).to.deep.equal(['project.example.com']);
+		});
+	});
+
+	it('Should be able to delete the project', function() {
+		cy.task('backendApiDelete', {
+			token: token,
+			path:  '/api/nginx/projects/' + projectId,
+		}).then((data) => {
+			cy.validateSwaggerSchema('delete', 200, '/nginx/projects/{projectID}', data);
+			expect(data).to.be.equal(true);
+		});
+	});
+
+});
@@ -45,4 +45,1420 @@ describe('Proxy Hosts endpoints', () => {
 		});
 	});
 
+	it('Should be able to create a https host that verifies the upstream certificate', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['upstream-tls.example.com'],
+				forward_scheme: 'https',
+				forward_host:   '1.1.1.1',
+				forward_port:   443,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				upstream_tls: {
+					verify:      true,
+					server_name: 'one.one.one.one'
+				},
+				advanced_config:         '',
+				locations:               [],
+				block_exploits:          false,
+				caching_enabled:         false,
+				allow_websocket_upgrade: false,
+				http2_support:           false,
+				hsts_enabled:            false,
+				hsts_subdomains:         false,
+				ssl_forced:              false
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data).to.have.property('upstream_tls');
+			expect(data.upstream_tls.verify).to.be.equal(true);
+			expect(data.upstream_tls.server_name).to.be.equal('one.one.one.one');
+		});
+	});
+
+	it('Should keep the upstream password to itself', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['upstream-auth.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				upstream_auth: {
+					username: 'legacy',
+					password: 'secret'
+				},
+				advanced_config:         '',
+				locations:               [],
+				block_exploits:          false,
+				caching_enabled:         false,
+				allow_websocket_upgrade: false,
+				http2_support:           false,
+				hsts_enabled:            false,
+				hsts_subdomains:         false,
+				ssl_forced:              false
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data.upstream_auth.username).to.be.equal('legacy');
+			expect(data.upstream_auth.password).to.be.equal('');
+
+			// An empty password keeps the one that's set
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts/' + data.id,
+				data:  {
+					upstream_auth: {
+						username: 'legacy',
+						password: ''
+					}
+				}
+			}).then((data) => {
+				cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+				expect(data.upstream_auth.username).to.be.equal('legacy');
+				expect(data.upstream_auth.password).to.be.equal('');
+			});
+		});
+	});
+
+	it('Should be able to create a host on a custom port', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['custom-port.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				ports: {
+					http: [80, 8080]
+				},
+				advanced_config:         '',
+				locations:               [],
+				block_exploits:          false,
+				caching_enabled:         false,
+				allow_websocket_upgrade: false,
+				http2_support:           false,
+				hsts_enabled:            false,
+				hsts_subdomains:         false,
+				ssl_forced:              false
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data).to.have.property('ports');
+			expect(data.ports.http).to.deep.equal([80, 8080]);
+		});
+	});
+
+	it('Should not be able to listen on the admin port', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['admin-port.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				ports: {
+					http: [81]
+				}
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+			expect(data.error.message).to.contain('admin interface');
+		});
+	});
+
+	it('Should not be able to forward to a unix socket that is not there', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['unix-socket.example.com'],
+				forward_scheme: 'http',
+				forward_host:   'unix:/var/run/missing-app.sock',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				}
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+			expect(data.error.message).to.contain('mount the directory of the socket');
+		});
+	});
+
+	it('Should not be able to forward to a unix socket without an absolute path', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['unix-socket.example.com'],
+				forward_scheme: 'http',
+				forward_host:   'unix:app.sock',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				}
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+			expect(data.error.message).to.contain('absolute path');
+		});
+	});
+
+	it('Should not be able to forward to a container without the Docker integration', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['docker-container.example.com'],
+				forward_scheme: 'http',
+				forward_host:   'docker:app',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				}
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+			expect(data.error.message).to.contain('Docker integration');
+		});
+	});
+
+	it('Should be able to redirect www to the apex domain', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['canonical.example.com', 'www.canonical.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				canonical_host:          'apex',
+				advanced_config:         '',
+				locations:               [],
+				block_exploits:          false,
+				caching_enabled:         false,
+				allow_websocket_upgrade: false,
+				http2_support:           false,
+				hsts_enabled:            false,
+				hsts_subdomains:         false,
+				ssl_forced:              false
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data).to.have.property('canonical_host', 'apex');
+			expect(data.domain_names).to.have.length(2);
+		});
+	});
+
+	it('Should be able to get the analytics of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/analytics?days=7',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/analytics', data);
+			expect(data).to.have.property('object_id', 1);
+			expect(data).to.have.property('clients');
+			expect(data).to.have.property('countries');
+			expect(data).to.have.property('asns');
+			expect(data).to.have.property('bots');
+			expect(data).to.have.property('crawlers');
+			expect(data).to.have.property('referrers');
+		});
+	});
+
+	it('Should be able to give a host usage limits', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				usage_limits: {
+					requests: 1000000,
+					bytes:    107374182400,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.usage_limits).to.have.property('requests', 1000000);
+		});
+	});
+
+	it('Should be able to get the usage of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/usage?months=3',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/usage', data);
+			expect(data).to.have.property('object_id', 1);
+			expect(data.usage_limits).to.have.property('bytes', 107374182400);
+			expect(data.months).to.have.length(3);
+			expect(data.months[0].month).to.equal(data.month);
+		});
+	});
+
+	it('Should not be able to set an empty usage limit', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				usage_limits: {},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to get the latency of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/latency?minutes=60',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/latency', data);
+			expect(data).to.have.property('object_id', 1);
+			expect(data).to.have.property('requests');
+			expect(data).to.have.property('locations');
+		});
+	});
+
+	it('Should be able to get the security report of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/security-report?probe=false',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/security-report', data);
+			expect(data).to.have.property('object_id', 1);
+			expect(data).to.have.property('probed', false);
+			expect(data.checks).to.have.length(5);
+			const https = data.checks.find((check) => check.id === 'https');
+			expect(https).to.have.property('passed', false);
+			expect(https).to.have.property('setting', 'ssl_forced');
+		});
+	});
+
+	it('Should not be able to scan the TLS of a host without a certificate', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1/tls-scan',
+			data:          {},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to get the guest links of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/guest-links',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/guest-links', data);
+			expect(data).to.have.length(0);
+		});
+	});
+
+	it('Should not be able to make a guest link for a host without an access list', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1/guest-links',
+			data:          {
+				name:  'Cypress',
+				hours: 2,
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to diagnose the forward host of a host', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/diagnose',
+			data:  {
+				path: '/',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts/{hostID}/diagnose', data);
+			expect(data).to.have.property('object_id', 1);
+			expect(data).to.have.property('steps');
+			expect(data.steps[0]).to.have.property('step', 'dns');
+		});
+	});
+
+	it('Should be able to split the traffic of a host with a canary', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				traffic_split: {
+					canary: {
+						forward_host: '1.1.1.2',
+						forward_port: 80,
+					},
+					weight:   10,
+					split_by: 'request',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.traffic_split).to.have.property('weight', 10);
+		});
+	});
+
+	it('Should be able to change the weight of the canary', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/traffic-split',
+			data:  {
+				weight: 50,
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}/traffic-split', data);
+			expect(data.traffic_split).to.have.property('weight', 50);
+			expect(data.traffic_split.canary).to.have.property('forward_host', '1.1.1.2');
+		});
+	});
+
+	it('Should not be able to split by a header without its name', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1/traffic-split',
+			data:          {
+				split_by: 'header',
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to give a host blue and green forward hosts', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				upstream_sets: {
+					active: 'blue',
+					blue:   {
+						forward_host: '1.1.1.1',
+						forward_port: 80,
+					},
+					green: {
+						forward_host: '1.1.1.3',
+						forward_port: 80,
+					},
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.upstream_sets).to.have.property('active', 'blue');
+			expect(data).to.have.property('forward_host', '1.1.1.1');
+		});
+	});
+
+	it('Should be able to switch a host to its green forward host', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/switch-upstream',
+			data:  {
+				check: false,
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts/{hostID}/switch-upstream', data);
+			expect(data).to.have.property('from', 'blue');
+			expect(data).to.have.property('to', 'green');
+			expect(data.host).to.have.property('forward_host', '1.1.1.3');
+		});
+	});
+
+	it('Should be able to roll a host back to its blue forward host', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/switch-upstream',
+			data:  {
+				to:    'blue',
+				check: false,
+			},
+		}).then((data) => {
+			expect(data).to.have.property('to', 'blue');
+			expect(data.host).to.have.property('forward_host', '1.1.1.1');
+		});
+	});
+
+	it('Should be able to load balance a host with sticky sessions', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				load_balancing: {
+					servers: [
+						{
+							forward_host: '1.1.1.4',
+							forward_port: 80,
+							weight:       2,
+						},
+					],
+					session_affinity: {
+						type:           'cookie',
+						cookie_name:    'npm_affinity',
+						cookie_max_age: 3600,
+					},
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.load_balancing.servers).to.have.length(1);
+			expect(data.load_balancing.session_affinity).to.have.property('type', 'cookie');
+		});
+	});
+
+	it('Should not be able to use backup servers with session affinity', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				load_balancing: {
+					servers: [
+						{
+							forward_host: '1.1.1.4',
+							forward_port: 80,
+							backup:       true,
+						},
+					],
+					session_affinity: {
+						type: 'ip_hash',
+					},
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to add redirect rules to a host', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				redirect_rules: [
+					{
+						match:  'regex',
+						source: '^/products/([0-9]+)$',
+						target: '/shop/item/$1',
+					},
+					{
+						match:          'prefix',
+						source:         '/blog/',
+						target:         'https://blog.example.com/',
+						preserve_query: true,
+					},
+					{
+						match:       'exact',
+						source:      '/about-us',
+						target:      '/about',
+						status_code: 308,
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.redirect_rules.map((rule) => rule.match)).to.deep.equal(['exact', 'prefix', 'regex']);
+		});
+	});
+
+	it('Should not be able to redirect the ACME challenge', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				redirect_rules: [
+					{
+						match:  'prefix',
+						source: '/',
+						target: 'https://example.com/',
+					},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should not be able to use a fallback forward host with session affinity', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				fallback: {
+					upstream: {
+						forward_host: 'app-standby',
+						forward_port: 8080,
+					},
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to give a host a fallback forward host and page', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				load_balancing: null,
+				fallback:       {
+					upstream: {
+						forward_host: 'app-standby',
+						forward_port: 8080,
+					},
+					page: '<html><body><h1>Back soon</h1></body></html>',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.fallback.upstream).to.have.property('forward_host', 'app-standby');
+		});
+	});
+
+	it('Should be able to mirror the requests of a custom location', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				locations: [
+					{
+						path:           '/api',
+						forward_scheme: 'http',
+						forward_host:   'api',
+						forward_port:   8080,
+						mirror:         {
+							forward_host: 'api-next',
+							forward_port: 8080,
+							percentage:   10,
+						},
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.locations[0].mirror).to.have.property('percentage', 10);
+		});
+	});
+
+	it('Should be able to replace strings in the responses of a custom location', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				locations: [
+					{
+						path:           '/legacy',
+						forward_scheme: 'http',
+						forward_host:   'legacy',
+						forward_port:   8080,
+						sub_filter:     {
+							rules: [
+								{
+									find:    'http://legacy:8080',
+									replace: 'https://$host/legacy',
+								},
+							],
+							types: ['application/javascript'],
+						},
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.locations[0].sub_filter.rules[0]).to.have.property('replace', 'https://$host/legacy');
+		});
+	});
+
+	it('Should be able to stream large uploads to a custom location', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				locations: [
+					{
+						path:           '/upload',
+						forward_scheme: 'http',
+						forward_host:   'storage',
+						forward_port:   9000,
+						uploads:        {
+							max_body_size: '50g',
+						},
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.locations[0].uploads).to.have.property('max_body_size', '50g');
+		});
+	});
+
+	it('Should not be able to set what the uploads preset does twice', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				locations: [
+					{
+						path:            '/upload',
+						forward_scheme:  'http',
+						forward_host:    'storage',
+						forward_port:    9000,
+						advanced_config: 'client_max_body_size 1g;',
+						uploads:         true,
+					},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to give a host a CORS policy', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				cors: {
+					origins:     ['https://app.example.com', 'https://*.example.org'],
+					methods:     ['GET', 'POST'],
+					max_age:     3600,
+					credentials: true,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.cors.origins).to.have.length(2);
+		});
+	});
+
+	it('Should not be able to allow credentials from any origin', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				cors: {
+					origins:     ['*'],
+					credentials: true,
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to protect the files of a host from hotlinking', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				hotlink: {
+					referers:     ['*.example.com', '~\\.google\\.'],
+					allow_empty:  false,
+					extensions:   ['jpg', 'png', 'mp4'],
+					redirect_url: 'https://static.example.net/hotlink.png',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.hotlink.extensions).to.have.length(3);
+		});
+	});
+
+	it('Should not be able to send hotlinked requests in a directive of their own', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				hotlink: {
+					referers: ['example.com; return 200'],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to set the expiry of responses by content type', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				optimizations: {
+					expires: [
+						{
+							types:   ['image/*', 'text/css'],
+							expires: '30d',
+						},
+					],
+					gzip_static: true,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.optimizations.expires[0]).to.have.property('expires', '30d');
+		});
+	});
+
+	it('Should be able to limit the body size and bandwidth of a host', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				limits: {
+					client_max_body_size: '100m',
+					limit_rate:           '2m',
+					limit_rate_after:     '10m',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.limits).to.have.property('limit_rate', '2m');
+		});
+	});
+
+	it('Should not be able to use a bandwidth limit in gigabytes', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				limits: {
+					limit_rate: '1g',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to exempt health checks from the access list of a host', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				access_exemptions: [
+					{
+						path: '/healthz',
+					},
+					{
+						path:    '/metrics',
+						match:   'prefix',
+						clients: ['10.0.0.0/8'],
+					},
+				],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.access_exemptions).to.have.length(2);
+		});
+	});
+
+	it('Should not be able to exempt the path of a custom location', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				access_exemptions: [
+					{
+						path:  '/api',
+						match: 'prefix',
+					},
+				],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to opt a host out of the protection presets', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				protection_presets: ['dotfiles'],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.protection_presets).to.deep.equal(['dotfiles']);
+		});
+	});
+
+	it('Should not be able to use a protection preset that doesn\'t exist', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1',
+			data:          {
+				protection_presets: ['everything'],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to serve robots.txt and security.txt from a host', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				served_files: {
+					robots_txt:   'User-agent: *\nDisallow: /\n',
+					security_txt: 'Contact: mailto:security@example.com\nExpires: 2099-01-01T00:00:00.000Z\n',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.served_files.robots_txt).to.contain('Disallow: /');
+		});
+	});
+
+	it('Should not be able to serve a security.txt without an Expires field', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				served_files: {
+					security_txt: 'Contact: mailto:security@example.com\n',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 400, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.error.message).to.contain('Expires');
+		});
+	});
+
+	it('Should be able to keep connections to the forward host open', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1',
+			data:  {
+				keepalive: {
+					enabled:     true,
+					connections: 32,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/nginx/proxy-hosts/{hostID}', data);
+			expect(data.keepalive).to.have.property('enabled', true);
+			expect(data.keepalive).to.have.property('connections', 32);
+		});
+	});
+
+	it('Should be able to disable a host with a drain period', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/disable?drain=2',
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts/{hostID}/disable', data);
+			expect(data).to.be.equal(true);
+		});
+
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/enable',
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts/{hostID}/enable', data);
+			expect(data).to.be.equal(true);
+		});
+	});
+
+	it('Should not be able to drain a host for more than an hour', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts/1/disable?drain=7200',
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+		});
+	});
+
+	it('Should be able to lint the generated config', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/lint',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/lint', data);
+			expect(data.files).to.be.greaterThan(0);
+			expect(data.problems.filter((problem) => problem.level === 'error')).to.have.length(0);
+		});
+	});
+
+	it('Should be able to get the current connections', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/connections?seconds=300',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/connections', data);
+			expect(data.seconds).to.be.equal(300);
+			expect(data.nginx).to.have.property('active');
+		});
+	});
+
+	it('Should be able to block a client', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/connections/block',
+			data:  {
+				address: '203.0.113.7',
+				note:    'Cypress'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/connections/block', data);
+			expect(data.value).to.be.equal('on');
+			expect(data.meta.clients.map((client) => client.address)).to.contain('203.0.113.7');
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/connections',
+			}).then((connections) => {
+				expect(connections.blocked).to.contain('203.0.113.7');
+			});
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/settings/blocked-clients',
+				data:  {
+					value: 'off',
+					meta:  {clients: []}
+				}
+			}).then((setting) => {
+				expect(setting.value).to.be.equal('off');
+			});
+		});
+	});
+
+	it('Should not be able to block something that is not an address', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/connections/block',
+			data:          {
+				address: '203.0.113'
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+		});
+	});
+
+	it('Should not be able to pick a certificate when none covers the host and none can be requested', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts',
+			data:          {
+				domain_names:   ['uncovered.example.org'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				certificate_id: 'auto'
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+			expect(data.error.message).to.contain('No certificate covers uncovered.example.org');
+		});
+	});
+
+	it('Should not be able to use a domain name of another host', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts',
+			data:          {
+				domain_names:   ['test.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+			expect(data.error.reason).to.be.equal('domain_conflict');
+			expect(data.error.conflict.domain_name).to.be.equal('test.example.com');
+			expect(data.error.conflict.object_type).to.be.equal('proxy-host');
+			expect(data.error.conflict.object_id).to.be.equal(1);
+		});
+	});
+
+	it('Should be able to create a host with a Chinese domain name', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['例子.中国'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data.domain_names).to.deep.equal(['例子.中国']);
+		});
+
+		// The punycode form is the same domain name
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts',
+			data:          {
+				domain_names:   ['xn--fsqu00a.xn--fiqs8s'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+			expect(data.error.reason).to.be.equal('domain_conflict');
+			expect(data.error.conflict.domain_name).to.be.equal('例子.中国');
+		});
+	});
+
+	it('Should not be able to use an invalid domain name', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/proxy-hosts',
+			data:          {
+				domain_names:   ['-invalid.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.be.equal(400);
+		});
+	});
+
+	it('Should be able to find a host by its domain name', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/search?q=test.example&limit=10',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/search', data);
+			const result = data.find((item) => item.object_type === 'proxy-host' && item.object_id === 1);
+			expect(result).to.not.be.undefined;
+			expect(result.name).to.contain('test.example.com');
+		});
+	});
+
+	it('Should be able to get the activity of a host', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts/1/activity?limit=100',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts/{hostID}/activity', data);
+			expect(data.find((item) => item.source === 'audit' && item.event === 'created')).to.not.be.undefined;
+			expect(data.find((item) => item.source === 'nginx' && item.event === 'config')).to.not.be.undefined;
+		});
+	});
+
+	it('Should be able to create a proxy host with the preset of an app', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/presets',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/presets', data);
+			expect(data.map((preset) => preset.id)).to.include('jellyfin');
+		});
+
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['jellyfin.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   8096,
+				block_exploits: false,
+				app_preset:     'jellyfin'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+			expect(data.allow_websocket_upgrade).to.be.equal(true);
+			expect(data.block_exploits).to.be.equal(false);
+			expect(data.limits.client_max_body_size).to.be.equal('20m');
+			expect(data.advanced_config).to.be.equal('proxy_buffering off;');
+			expect(data.meta.app_preset).to.be.equal('jellyfin');
+		});
+	});
+
+	it('Should list proxy hosts without a query for each one', function() {
+		cy.getQueryCount(token).then((before) => {
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts?expand=owner,access_list,certificate',
+			}).then(() => {
+				cy.getQueryCount(token).then((after) => {
+					// The token's user, the rows, one query for each expansion, and the metrics themselves
+					expect(after - before).to.be.lessThan(15);
+				});
+			});
+		});
+	});
+
+	it('Should list proxy hosts as JSON lines', function() {
+		cy.request({
+			url:     '/api/nginx/proxy-hosts',
+			headers: {
+				Accept:        'application/x-ndjson',
+				Authorization: 'Bearer ' + token
+			}
+		}).then((response) => {
+			expect(response.status).to.be.equal(200);
+			expect(response.headers['content-type']).to.contain('application/x-ndjson');
+
+			const lines = response.body.split('\n').filter((line) => line.length);
+			expect(lines.length).to.be.greaterThan(0);
+			lines.forEach((line) => {
+				const row = JSON.parse(line);
+				expect(row).to.have.property('id');
+				expect(row).to.have.property('domain_names');
+			});
+		});
+	});
+
+	it('Should export the hosts as a spreadsheet', function() {
+		cy.request({
+			url:     '/api/hosts/export?format=csv',
+			headers: {
+				Authorization: 'Bearer ' + token
+			}
+		}).then((response) => {
+			expect(response.status).to.be.equal(200);
+			expect(response.headers['content-type']).to.contain('text/csv');
+			expect(response.headers['content-disposition']).to.contain('attachment');
+
+			const lines = response.body.replace(/^\ufeff/, '').split('\r\n');
+			expect(lines[0]).to.be.equal('Type,ID,Domains,Upstream,Enabled,Certificate,Certificate Expires,Owner,Tags,Created,Modified');
+			expect(lines.filter((line) => line.indexOf('proxy-host,') === 0).length).to.be.greaterThan(0);
+		});
+
+		cy.request({
+			url:              '/api/hosts/export?format=pdf',
+			headers:          {
+				Authorization: 'Bearer ' + token
+			},
+			failOnStatusCode: false
+		}).then((response) => {
+			expect(response.status).to.be.equal(400);
+		});
+	});
+
+	it('Should be able to try out a host without it being created', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts?dry_run=true',
+			data:  {
+				domain_names:   ['dry-run.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				certificate_id: 0,
+				meta:           {},
+				locations:      [],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts', data);
+			expect(data.dry_run).to.equal(true);
+			expect(data.result.id).to.equal(0);
+			expect(data.files[0].status).to.equal('created');
+			expect(data.files[0].config).to.contain('dry-run.example.com');
+			expect(data.nginx.online).to.equal(true);
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts',
+			}).then((hosts) => {
+				expect(hosts.filter((host) => host.domain_names.indexOf('dry-run.example.com') !== -1)).to.have.length(0);
+			});
+		});
+	});
+
+	it('Should be able to create a host with its access list in one go', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/hosts/full',
+			data:  {
+				host: {
+					domain_names:   ['full.example.com'],
+					forward_scheme: 'http',
+					forward_host:   '1.1.1.1',
+					forward_port:   80,
+					meta:           {},
+					locations:      [],
+				},
+				access_list: {
+					name:    'Full host',
+					clients: [
+						{
+							address:   '192.168.1.0/24',
+							directive: 'allow',
+						},
+					],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/hosts/full', data);
+			expect(data.certificate).to.equal(null);
+			expect(data.host.access_list_id).to.equal(data.access_list.id);
+		});
+	});
+
+	it('Should not leave anything behind when a part of a full host is wrong', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/hosts/full',
+			data:  {
+				object_type: 'redirection-host',
+				host:        {
+					domain_names:        ['full-redirect.example.com'],
+					forward_scheme:      'https',
+					forward_domain_name: 'example.com',
+					forward_http_code:   301,
+				},
+				access_list: {
+					name: 'Full redirect',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/access-lists',
+			}).then((lists) => {
+				expect(lists.filter((list) => list.name === 'Full redirect')).to.have.length(0);
+			});
+		});
+	});
+
+	it('Should not bulk tag a locked host', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/proxy-hosts',
+			data:  {
+				domain_names:   ['locked-tags.example.com'],
+				forward_scheme: 'http',
+				forward_host:   '1.1.1.1',
+				forward_port:   80,
+				access_list_id: '0',
+				certificate_id: 0,
+				meta:           {
+					letsencrypt_agree: false,
+					dns_challenge:     false
+				},
+				advanced_config: '',
+				locations:       [],
+				ssl_forced:      false
+			}
+		}).then((host) => {
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts/' + host.id + '/lock',
+				data:  {}
+			}).then((data) => {
+				cy.validateSwaggerSchema('post', 200, '/nginx/proxy-hosts/{hostID}/lock', data);
+				expect(data).to.be.equal(true);
+			});
+
+			cy.task('backendApiPost', {
+				token:         token,
+				path:          '/api/tags/bulk',
+				data:          {
+					object_type: 'proxy-host',
+					ids:         [host.id],
+					add:         ['env=prod']
+				},
+				returnOnError: true
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts/' + host.id
+			}).then((data) => {
+				expect(data.tags || []).to.not.include('env=prod');
+			});
+
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts/' + host.id + '/unlock',
+				data:  {}
+			}).then(() => {
+				cy.task('backendApiPost', {
+					token: token,
+					path:  '/api/tags/bulk',
+					data:  {
+						object_type: 'proxy-host',
+						ids:         [host.id],
+						add:         ['env=prod']
+					}
+				}).then((data) => {
+					cy.validateSwaggerSchema('post', 200, '/tags/bulk', data);
+					expect(data[0].tags).to.include('env=prod');
+				});
+			});
+		});
+	});
 });
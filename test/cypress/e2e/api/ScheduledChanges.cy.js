@@ -0,0 +1,62 @@
+/// <reference types="cypress" />
+
+describe('Scheduled Changes endpoints', () => {
+	let token;
+	let scheduledChangeID;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to schedule a change', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/dead-hosts?apply_at=2099-01-01T02:00:00Z',
+			data:  {
+				domain_names: ['scheduled-changes.example.com'],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 202, '/nginx/dead-hosts', data);
+			expect(data).to.have.property('id');
+			expect(data).to.have.property('status', 'pending');
+			expect(data).to.have.property('action', 'create');
+			scheduledChangeID = data.id;
+		});
+	});
+
+	it('Should be able to get the queue', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/scheduled-changes?status=pending',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/scheduled-changes', data);
+			expect(data).to.be.an('array');
+			expect(data.map((row) => row.id)).to.include(scheduledChangeID);
+		});
+	});
+
+	it('Should be able to cancel a scheduled change', function() {
+		cy.task('backendApiDelete', {
+			token: token,
+			path:  '/api/scheduled-changes/' + scheduledChangeID,
+		}).then((data) => {
+			cy.validateSwaggerSchema('delete', 200, '/scheduled-changes/{scheduledChangeID}', data);
+			expect(data).to.have.property('status', 'cancelled');
+		});
+	});
+
+	it('Should not be able to schedule a change in the past', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/dead-hosts?apply_at=2000-01-01T02:00:00Z',
+			data:          {
+				domain_names: ['scheduled-changes.example.com'],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+});
@@ -121,4 +121,1154 @@ describe('Settings endpoints', () => {
 			expect(data.meta.html).to.be.equal('<p>hello world</p>');
 		});
 	});
+
+	it('Get admin-host setting', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/settings/admin-host',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('admin-host');
+		});
+	});
+
+	it('Admin Host off', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/admin-host',
+			data: {
+				value: 'off',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('admin-host');
+			expect(data).to.have.property('value');
+			expect(data.value).to.be.equal('off');
+		});
+	});
+
+	it('Admin Listener https needs a certificate', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/admin-listen',
+			data: {
+				value: 'https',
+				meta:  {
+					http2: true,
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Admin Listener http', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/admin-listen',
+			data: {
+				value: 'http',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('admin-listen');
+			expect(data.value).to.be.equal('http');
+		});
+	});
+
+	it('CORS custom', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/cors',
+			data: {
+				value: 'custom',
+				meta:  {
+					origins:     ['https://dashboard.example.com'],
+					credentials: true,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('cors');
+			expect(data).to.have.property('value');
+			expect(data.value).to.be.equal('custom');
+			expect(data.meta.origins).to.deep.equal(['https://dashboard.example.com']);
+		});
+	});
+
+	it('CORS origin must be a URL', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/cors',
+			data: {
+				value: 'custom',
+				meta:  {
+					origins: ['dashboard.example.com/path'],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 422, '/settings/{settingID}', data);
+			expect(data.error.code).to.equal(422);
+		});
+	});
+
+	it('CORS default', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/cors',
+			data: {
+				value: 'default',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.be.equal('default');
+		});
+	});
+
+	it('Compression custom', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/compression',
+			data: {
+				value: 'custom',
+				meta:  {
+					gzip:       true,
+					level:      6,
+					min_length: 512,
+					types:      ['text/css', 'application/json'],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('compression');
+			expect(data).to.have.property('value');
+			expect(data.value).to.be.equal('custom');
+			expect(data.meta.level).to.be.equal(6);
+		});
+	});
+
+	it('Compression default', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/compression',
+			data: {
+				value: 'default',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.be.equal('default');
+		});
+	});
+
+	it('Server header custom', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/server-header',
+			data: {
+				value: 'custom',
+				meta:  {
+					server:                 'web',
+					powered_by:             '',
+					hide_upstream_versions: true,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('server-header');
+			expect(data.value).to.be.equal('custom');
+			expect(data.meta.server).to.be.equal('web');
+		});
+	});
+
+	it('Server header with a quote is rejected', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/server-header',
+			data: {
+				value: 'custom',
+				meta:  {
+					server: 'web"; return 200',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 422, '/settings/{settingID}', data);
+			expect(data.error.code).to.equal(422);
+		});
+	});
+
+	it('Server header default', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/server-header',
+			data: {
+				value: 'default',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.be.equal('default');
+		});
+	});
+
+	it('Log rotation on', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/log-rotation',
+			data: {
+				value: 'on',
+				meta:  {
+					max_size: 1048576,
+					keep:     2,
+					compress: true,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('log-rotation');
+			expect(data.value).to.be.equal('on');
+			expect(data.meta.keep).to.be.equal(2);
+		});
+	});
+
+	it('Log usage', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/logs/usage',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/logs/usage', data);
+			expect(data).to.have.property('total');
+			expect(data).to.have.property('items');
+		});
+	});
+
+	it('Log shipping off', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/log-shipping',
+			data: {
+				value: 'off',
+				meta:  {
+					host: '127.0.0.1',
+					port: 514,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('log-shipping');
+			expect(data.value).to.be.equal('off');
+		});
+	});
+
+	it('Listen IPv4 only', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/listen',
+			data: {
+				value: 'custom',
+				meta:  {
+					mode: 'ipv4',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('listen');
+			expect(data.value).to.be.equal('custom');
+			expect(data.meta.mode).to.be.equal('ipv4');
+		});
+	});
+
+	it('Listen default', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/listen',
+			data: {
+				value: 'default',
+				meta:  {},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.be.equal('default');
+		});
+	});
+
+	it('Analytics on', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/analytics',
+			data: {
+				value: 'on',
+				meta:  {
+					anonymize: 'hash',
+					retention: 14,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.equal('analytics');
+			expect(data.value).to.be.equal('on');
+			expect(data.meta.anonymize).to.be.equal('hash');
+		});
+	});
+
+	it('Domain expiry on', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/domain-expiry',
+			data: {
+				value: 'on',
+				meta:  {
+					days: 45,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('domain-expiry');
+			expect(data.value).to.be.equal('on');
+			expect(data.meta.days).to.be.equal(45);
+		});
+	});
+
+	it('Domain expiry dates', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/reports/domains',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/reports/domains', data);
+			expect(data).to.be.an('array');
+		});
+	});
+
+	it('ACME DNS on', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/acme-dns',
+			data: {
+				value: 'on',
+				meta:  {
+					domain:  'acme.example.com',
+					address: '127.0.0.1',
+					port:    5353,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('acme-dns');
+			expect(data.value).to.be.equal('on');
+		});
+	});
+
+	it('ACME DNS register', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/acme-dns/register',
+			data:  {
+				allowfrom: ['127.0.0.0/8'],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/acme-dns/register', data);
+			expect(data).to.have.property('password');
+			expect(data.fulldomain).to.be.equal(data.subdomain + '.acme.example.com');
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/acme-dns/accounts',
+			}).then((accounts) => {
+				cy.validateSwaggerSchema('get', 200, '/acme-dns/accounts', accounts);
+				expect(accounts.map((account) => account.username)).to.include(data.username);
+				expect(accounts[0]).not.to.have.property('password');
+			});
+		});
+	});
+	it('Read only mode refuses changes', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/read-only',
+			data:  {
+				value: 'on',
+				meta:  {
+					message: 'Demo',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('read-only');
+			expect(data.value).to.be.equal('on');
+
+			cy.task('backendApiPost', {
+				token:         token,
+				path:          '/api/nginx/dead-hosts',
+				data:          {
+					domain_names: ['readonly.example.com'],
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(403);
+				expect(data.error.reason).to.equal('read_only');
+				expect(data.error.message).to.equal('Demo');
+
+				cy.task('backendApiPut', {
+					token: token,
+					path:  '/api/settings/read-only',
+					data:  {
+						value: 'off',
+					},
+				}).then((data) => {
+					expect(data.value).to.be.equal('off');
+				});
+			});
+		});
+	});
+
+	it('Quotas per role', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/quotas',
+			data: {
+				value: 'on',
+				meta:  {
+					user: {
+						streams: 2
+					}
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('on');
+			expect(data.meta.user.streams).to.equal(2);
+		});
+	});
+
+	it('Quotas off', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/quotas',
+			data: {
+				value: 'off',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('off');
+		});
+	});
+
+	it('Should be able to choose the protection presets', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/protection-presets',
+			data:  {
+				value: 'on',
+				meta:  {
+					presets: ['dotfiles', 'server-info'],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.meta.presets).to.deep.equal(['dotfiles', 'server-info']);
+		});
+	});
+
+	it('Should be able to set defaults for new hosts', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/host-defaults',
+			data:  {
+				value: 'on',
+				meta:  {
+					certificate:        'none',
+					block_exploits:     true,
+					http2_support:      true,
+					proxy_read_timeout: 300,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('on');
+		});
+
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/nginx/host-defaults',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/nginx/host-defaults', data);
+			expect(data['proxy-host'].block_exploits).to.be.equal(true);
+			expect(data['proxy-host'].limits.proxy_read_timeout).to.be.equal(300);
+			expect(data['dead-host']).to.not.have.property('block_exploits');
+			expect(data['dead-host'].http2_support).to.be.equal(true);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/host-defaults',
+			data:  {
+				value: 'off',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('off');
+		});
+	});
+
+	it('Should not be able to default to a certificate without choosing one', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/host-defaults',
+			data:          {
+				value: 'on',
+				meta:  {
+					certificate: 'existing',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to set the retry policy of failed renewals', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/renewal-retry',
+			data:  {
+				value: 'on',
+				meta:  {
+					max_attempts: 3,
+					backoff:      [2, 12],
+					on_give_up:   'retry',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.meta.max_attempts).to.equal(3);
+			expect(data.meta.backoff).to.deep.equal([2, 12]);
+		});
+	});
+
+	it('Should not be able to retry renewals without a backoff', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/renewal-retry',
+			data:          {
+				meta: {
+					backoff: [],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to limit requests to a DNS provider', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/dns-provider-limits',
+			data:  {
+				value: 'on',
+				meta:  {
+					limits: [
+						{
+							provider:    'dnspod',
+							concurrency: 1,
+							interval:    60,
+							per_hour:    20,
+						},
+					],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.meta.limits[0].provider).to.equal('dnspod');
+			expect(data.meta.limits[0].per_hour).to.equal(20);
+		});
+	});
+
+	it('Should not be able to limit an unknown DNS provider', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/dns-provider-limits',
+			data:          {
+				meta: {
+					limits: [
+						{
+							provider: 'nonexistent',
+						},
+					],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to request certificates with lego', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/acme-client',
+			data:  {
+				value: 'lego',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('lego');
+		});
+	});
+
+	it('Should be able to request certificates with certbot', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/acme-client',
+			data:  {
+				value: 'certbot',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.value).to.equal('certbot');
+		});
+	});
+
+	it('Should be able to convert nginx server blocks into proxy hosts', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/system/import/nginx',
+			data:  {
+				config: 'server {\n    listen 80;\n    server_name import.example.com;\n    client_max_body_size 100m;\n    location / {\n        proxy_pass http://10.0.0.5:3000;\n        proxy_set_header Upgrade $http_upgrade;\n    }\n}\n',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/system/import/nginx', data);
+			expect(data.length).to.equal(1);
+			expect(data[0].host.forward_host).to.equal('10.0.0.5');
+			expect(data[0].host.forward_port).to.equal(3000);
+			expect(data[0].host.allow_websocket_upgrade).to.equal(true);
+			expect(data[0].host.advanced_config).to.equal('client_max_body_size 100m;');
+			expect(data[0].unconverted[0].placed_in).to.equal('advanced_config');
+			expect(data[0].created_id).to.equal(null);
+		});
+	});
+
+	it('Should not be able to import nginx config files from outside of a mount', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/system/import/nginx',
+			data:          {
+				path: '/etc/nginx/nginx.conf',
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to convert Caddyfile sites into proxy hosts', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/system/import/caddy',
+			data:  {
+				config:            'import.example.com {\n    reverse_proxy 10.0.0.5:3000\n    handle_path /api/* {\n        reverse_proxy 10.0.0.7:9000\n    }\n}\n',
+				letsencrypt_email: 'admin@example.com',
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 200, '/system/import/caddy', data);
+			expect(data.length).to.equal(1);
+			expect(data[0].https).to.equal(true);
+			expect(data[0].host.forward_host).to.equal('10.0.0.5');
+			expect(data[0].host.certificate_id).to.equal('new');
+			expect(data[0].host.locations[0].forward_host).to.equal('10.0.0.7');
+			expect(data[0].created_id).to.equal(null);
+		});
+	});
+
+	it('Should be able to export hosts as Traefik dynamic config', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/export/traefik?cert_resolver=le',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/export/traefik', data);
+			expect(data).to.have.property('config');
+			expect(data.labels).to.be.an('array');
+			expect(data.unexported).to.be.an('array');
+		});
+	});
+
+	it('Should only turn on experimental features this build supports', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/features',
+			data:  {
+				value: 'custom',
+				meta:  {
+					flags: {
+						clustering: true,
+					},
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.meta.flags.clustering).to.equal(true);
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/features',
+			}).then((features) => {
+				cy.validateSwaggerSchema('get', 200, '/features', features);
+				const clustering = features.find((feature) => feature.id === 'clustering');
+				expect(clustering.supported).to.equal(false);
+				expect(clustering.enabled).to.equal(false);
+				expect(clustering.source).to.equal('unsupported');
+			});
+		});
+	});
+
+	it('Should say which version is running and what it bundles', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/version',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/version', data);
+			expect(data.components.node).to.match(/^v[0-9]+/);
+			expect(data.upgrade).to.equal(null);
+		});
+	});
+
+	it('Should list the database migrations that have run', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/migrations',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/migrations', data);
+			expect(data.pending).to.deep.equal([]);
+			expect(data.applied[data.applied.length - 1].name).to.equal('20180618015850_initial.js');
+		});
+	});
+
+	it('Should list an access list nothing uses as an orphan, and remove it once confirmed', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/access-lists',
+			data:  {
+				name:        'Unused',
+				satisfy_any: false,
+				pass_auth:   false,
+				items:       [],
+				clients:     [
+					{
+						directive: 'allow',
+						address:   '10.0.0.0/8',
+					},
+				],
+			},
+		}).then((access_list) => {
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/system/orphans',
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/system/orphans', data);
+				expect(data.access_lists.map((item) => item.id)).to.include(access_list.id);
+
+				cy.task('backendApiPost', {
+					token:         token,
+					path:          '/api/system/orphans/cleanup',
+					data:          {
+						confirm:      false,
+						access_lists: [access_list.id],
+					},
+					returnOnError: true,
+				}).then((result) => {
+					expect(result.error.code).to.equal(400);
+				});
+
+				cy.task('backendApiPost', {
+					token: token,
+					path:  '/api/system/orphans/cleanup',
+					data:  {
+						confirm:      true,
+						access_lists: [access_list.id],
+					},
+				}).then((result) => {
+					cy.validateSwaggerSchema('post', 200, '/system/orphans/cleanup', result);
+					expect(result.access_lists).to.deep.equal([access_list.id]);
+				});
+			});
+		});
+	});
+
+	it('Should list what is risky about the install, the worst first', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/security-audit',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/security-audit', data);
+			const severities = ['critical', 'high', 'medium', 'low'];
+			const order      = data.findings.map((finding) => severities.indexOf(finding.severity));
+			expect(order).to.deep.equal(order.slice().sort());
+			expect(data.summary.critical + data.summary.high + data.summary.medium + data.summary.low).to.equal(data.findings.length);
+		});
+	});
+
+	it('Outbound proxy has to be an http or SOCKS5 proxy', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/outbound-proxy',
+			data:  {
+				value: 'on',
+				meta:  {
+					url: 'ftp://proxy.example.com:21',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/outbound-proxy',
+			data:  {
+				value: 'off',
+				meta:  {
+					url:      'socks5://127.0.0.1:1080',
+					no_proxy: ['internal.example.com'],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('outbound-proxy');
+			expect(data.value).to.be.equal('off');
+		});
+	});
+
+	it('DNS resolvers have to be addresses', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/dns-resolvers',
+			data:  {
+				value: 'custom',
+				meta:  {
+					servers: ['https://dns.example.com/dns-query'],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/dns-resolvers',
+			data:  {
+				value: 'system',
+				meta:  {
+					servers: ['127.0.0.11'],
+					valid:   30,
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('dns-resolvers');
+			expect(data.value).to.be.equal('system');
+		});
+	});
+
+	it('Notification channels have to be https webhooks', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/notification-channels',
+			data:  {
+				value: 'on',
+				meta:  {
+					channels: [{name: 'Ops', type: 'dingtalk', url: 'http://oapi.dingtalk.com/robot/send?access_token=test'}],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/notification-channels',
+			data:  {
+				value: 'off',
+				meta:  {
+					channels: [
+						{name: 'Ops', type: 'dingtalk', url: 'https://oapi.dingtalk.com/robot/send?access_token=test', secret: 'SECtest'},
+						{name: 'Certs', type: 'feishu', url: 'https://open.feishu.cn/open-apis/bot/v2/hook/test', events: ['renewal', 'deploy']},
+					],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.id).to.be.equal('notification-channels');
+			expect(data.meta.channels).to.have.length(2);
+		});
+	});
+
+	it('Telegram channels need a chat and saved channels are tested by name', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/notification-channels',
+			data:  {
+				meta: {
+					channels: [{name: 'Phone', type: 'telegram', token: '123456:test'}],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/system/notifications/channels/Nowhere/test',
+			data:          {},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(404);
+		});
+	});
+
+	it('Notification routes can only send to channels there are', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/notifications/routes',
+			data:  {
+				routes: [{events: ['renewal'], channels: ['Nowhere']}],
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/notifications/routes',
+			data:  {
+				enabled: true,
+				routes:  [{name: 'Failed renewals', events: ['renewal'], severities: ['critical'], channels: ['Ops']}],
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/notifications/routes', data);
+			expect(data.enabled).to.be.equal(true);
+			expect(data.routes).to.have.length(1);
+		});
+
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/notifications/routes',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/notifications/routes', data);
+			expect(data.routes[0].channels).to.deep.equal(['Ops']);
+		});
+	});
+
+	it('Users can have quiet hours and a digest in their timezone', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/notification-channels',
+			data:  {
+				value: 'off',
+				meta:  {
+					channels: [
+						{name: 'Ops', type: 'dingtalk', url: 'https://oapi.dingtalk.com/robot/send?access_token=test', secret: 'SECtest'},
+						{name: 'Certs', type: 'feishu', url: 'https://open.feishu.cn/open-apis/bot/v2/hook/test', events: ['renewal', 'deploy']},
+					],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+		});
+
+		const invalid = [
+			{channels: ['Ops'], timezone: 'Mars/Olympus_Mons'},
+			{channels: ['Ops'], delivery: 'digest', digest_hour: 6, quiet_hours: {start: '22:00', end: '07:00'}},
+			{channels: ['Nowhere']},
+		];
+
+		invalid.forEach((preferences) => {
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/users/me',
+				data:  {
+					notification_preferences: preferences,
+				},
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/users/me',
+			data:  {
+				notification_preferences: {
+					channels:     ['Ops'],
+					timezone:     'Asia/Shanghai',
+					quiet_hours:  {start: '22:00', end: '07:00', critical: true},
+					delivery:     'digest',
+					digest_hour:  9,
+					muted_events: ['usage'],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/users/{userID}', data);
+			expect(data.notification_preferences.delivery).to.be.equal('digest');
+			expect(data.notification_preferences.quiet_hours.start).to.be.equal('22:00');
+		});
+
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/notifications/held',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/notifications/held', data);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/users/me',
+			data:  {
+				notification_preferences: null,
+			},
+		}).then((data) => {
+			expect(data.notification_preferences).to.be.equal(null);
+		});
+	});
+
+	it('Email channels can\'t send a password unencrypted', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/notification-channels',
+			data:  {
+				meta: {
+					channels: [{name: 'Ops mail', type: 'email', host: 'smtp.example.com', security: 'none', username: 'npm', password: 'secret', from: 'npm@example.com', to: ['ops@example.com']}],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Scheduled reports go to channels there are, and the last one can be seen', function() {
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/scheduled-reports',
+			data:  {
+				meta: {
+					recipients: [{channel: 'Nowhere', frequency: 'weekly'}],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token: token,
+			path:  '/api/settings/scheduled-reports',
+			data:  {
+				meta: {
+					recipients: [{channel: 'Ops', frequency: 'weekly', sections: ['renewals', 'expirations']}],
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('put', 200, '/settings/{settingID}', data);
+			expect(data.meta.recipients).to.have.length(1);
+		});
+
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/reports/summary?frequency=monthly',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/reports/summary', data);
+			expect(data.frequency).to.be.equal('monthly');
+			expect(data).to.have.property('renewals');
+			expect(data).to.have.property('security');
+		});
+	});
+
+	it('WireGuard peers need different keys and ranges of addresses', function() {
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/wireguard',
+			data:          {
+				value: 'on',
+				meta:  {
+					address: '10.8.0.1/24',
+					peers:   [
+						{name: 'Office', public_key: 'xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=', allowed_ips: ['192.168.10.0/24']},
+						{name: 'Lab', public_key: 'xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=', allowed_ips: ['192.168.20.0/24']},
+					],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/wireguard',
+			data:          {
+				value: 'on',
+				meta:  {
+					address: '10.8.0.1/24',
+					peers:   [
+						{name: 'Office', public_key: 'xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=', allowed_ips: ['office.example.com']},
+					],
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/wireguard',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/wireguard', data);
+			expect(data.enabled).to.be.equal(false);
+		});
+	});
+
+	it('Telemetry is off until it is turned on, and can be previewed', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/system/telemetry/preview',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/system/telemetry/preview', data);
+			expect(data.enabled).to.be.equal(false);
+			expect(data.report.counts.proxy_hosts).to.match(/^(0|1-9|10-99|100-999|1000\+)$/);
+		});
+
+		cy.task('backendApiPut', {
+			token:         token,
+			path:          '/api/settings/telemetry',
+			data:          {
+				value: 'on',
+				meta:  {
+					url: 'http://telemetry.example.com/report',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
 });
@@ -0,0 +1,67 @@
+/// <reference types="cypress" />
+
+describe('Status Pages endpoints', () => {
+	let token;
+	let statusPageId;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to create a status page', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/status-pages',
+			data:  {
+				name:        'Cypress Status',
+				slug:        'cypress',
+				description: 'Created by the API tests',
+				hosts:       []
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/status-pages', data);
+			expect(data).to.have.property('id');
+			expect(data.id).to.be.greaterThan(0);
+			expect(data).to.have.property('slug', 'cypress');
+			statusPageId = data.id;
+		});
+	});
+
+	it('Should reject a status page with a slug in use', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/status-pages',
+			data:  {
+				name:  'Cypress Status',
+				slug:  'cypress',
+				hosts: []
+			},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should be able to see the status page without logging in', function() {
+		cy.task('backendApiGet', {
+			path: '/api/status/cypress',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/status/{slug}', data);
+			expect(data).to.have.property('name', 'Cypress Status');
+			expect(data.hosts.length).to.be.equal(0);
+		});
+	});
+
+	it('Should be able to delete the status page', function() {
+		cy.task('backendApiDelete', {
+			token: token,
+			path:  '/api/nginx/status-pages/' + statusPageId,
+		}).then((data) => {
+			cy.validateSwaggerSchema('delete', 200, '/nginx/status-pages/{statusPageID}', data);
+			expect(data).to.be.equal(true);
+		});
+	});
+
+});
@@ -0,0 +1,119 @@
+/// <reference types="cypress" />
+
+describe('Streams endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to tune the timeouts and buffers of a stream', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/streams',
+			data:  {
+				incoming_port:   15182,
+				forwarding_host: '127.0.0.1',
+				forwarding_port: 51820,
+				tcp_forwarding:  false,
+				udp_forwarding:  true,
+				tuning:          {
+					proxy_timeout:     180,
+					proxy_buffer_size: '64k',
+					rcvbuf:            '1m',
+				},
+			},
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/streams', data);
+			expect(data.tuning.proxy_timeout).to.equal(180);
+			expect(data.meta.nginx_online).to.equal(true);
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/nginx/streams/' + data.id,
+				data:  {
+					tuning: null,
+				},
+			}).then((stream) => {
+				cy.validateSwaggerSchema('put', 200, '/nginx/streams/{streamID}', stream);
+				expect(stream.tuning).to.equal(null);
+
+				cy.task('backendApiDelete', {
+					token: token,
+					path:  '/api/nginx/streams/' + data.id,
+				});
+			});
+		});
+	});
+
+	it('Should not be able to give a buffer size nginx does not understand', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/streams',
+			data:          {
+				incoming_port:   15183,
+				forwarding_host: '127.0.0.1',
+				forwarding_port: 51820,
+				udp_forwarding:  true,
+				tuning:          {
+					proxy_buffer_size: '64 kb',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+
+	it('Should keep why a stream was disabled until it is enabled', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/streams',
+			data:  {
+				incoming_port:   15184,
+				forwarding_host: '127.0.0.1',
+				forwarding_port: 51820,
+				udp_forwarding:  true,
+			},
+		}).then((data) => {
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/nginx/streams/' + data.id + '/disable',
+				data:  {
+					reason: 'Client contract ended',
+				},
+			}).then(() => {
+				cy.task('backendApiGet', {
+					token: token,
+					path:  '/api/nginx/streams/' + data.id,
+				}).then((stream) => {
+					cy.validateSwaggerSchema('get', 200, '/nginx/streams/{streamID}', stream);
+					expect(stream.enabled).to.not.be.ok;
+					expect(stream.disabled_reason).to.equal('Client contract ended');
+					expect(stream.disabled_by_user_id).to.be.greaterThan(0);
+
+					cy.task('backendApiPost', {
+						token: token,
+						path:  '/api/nginx/streams/' + data.id + '/enable',
+					}).then(() => {
+						cy.task('backendApiGet', {
+							token: token,
+							path:  '/api/nginx/streams/' + data.id,
+						}).then((enabled) => {
+							expect(enabled.disabled_reason).to.equal('');
+							expect(enabled.disabled_on).to.equal(null);
+
+							cy.task('backendApiDelete', {
+								token: token,
+								path:  '/api/nginx/streams/' + data.id,
+							});
+						});
+					});
+				});
+			});
+		});
+	});
+
+});
@@ -0,0 +1,83 @@
+/// <reference types="cypress" />
+
+describe('Tenants endpoints', () => {
+	let token;
+	let tenantId;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to create a tenant', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/tenants',
+			data:  {
+				name:   'Cypress',
+				quotas: {
+					proxy_hosts: 1,
+					users:       2
+				}
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/tenants', data);
+			expect(data).to.have.property('id');
+			expect(data.quotas.proxy_hosts).to.equal(1);
+			expect(data.usage.users).to.equal(0);
+			tenantId = data.id;
+		});
+	});
+
+	it('Should reject a negative quota', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/tenants',
+			data:  {
+				name:   'Cypress',
+				quotas: {
+					streams: -1
+				}
+			},
+			returnOnError: true
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 422, '/tenants', data);
+			expect(data.error.code).to.equal(422);
+		});
+	});
+
+	it('Should be able to get all tenants', function() {
+		cy.task('backendApiGet', {
+			token: token,
+			path:  '/api/tenants',
+		}).then((data) => {
+			cy.validateSwaggerSchema('get', 200, '/tenants', data);
+			expect(data.map((tenant) => tenant.id)).to.include(tenantId);
+		});
+	});
+
+	it('Should be able to delete an empty tenant', function() {
+		cy.task('backendApiDelete', {
+			token: token,
+			path:  '/api/tenants/' + tenantId,
+		}).then((data) => {
+			cy.validateSwaggerSchema('delete', 200, '/tenants/{tenantID}', data);
+			expect(data).to.equal(true);
+		});
+	});
+
+	it('Should list tenants without a query for each one', function() {
+		cy.getQueryCount(token).then((before) => {
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/tenants',
+			}).then(() => {
+				cy.getQueryCount(token).then((after) => {
+					// The token's user, the rows, one query for each resource they have a quota of, and the metrics themselves
+					expect(after - before).to.be.lessThan(20);
+				});
+			});
+		});
+	});
+});
@@ -0,0 +1,91 @@
+/// <reference types="cypress" />
+
+describe('Test mode', () => {
+	let token;
+	let testMode = null;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+
+			// Only when the stack was started with docker-compose.ci.pebble.yml
+			cy.task('backendApiGet', {
+				token:         token,
+				path:          '/api/system/test-mode/dns',
+				returnOnError: true
+			}).then((data) => {
+				testMode = data.error ? null : data;
+			});
+		});
+	});
+
+	it('Should be able to get the mock DNS records', function() {
+		if (!testMode) {
+			this.skip();
+		}
+
+		cy.validateSwaggerSchema('get', 200, '/system/test-mode/dns', testMode);
+		expect(testMode.acme_server).to.be.a('string');
+	});
+
+	it('Should be able to create a certificate with the mock DNS provider', function() {
+		if (!testMode) {
+			this.skip();
+		}
+
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/nginx/certificates',
+			data:  {
+				domain_names: [
+					'website3.example.com'
+				],
+				meta: {
+					letsencrypt_email: 'admin@example.com',
+					letsencrypt_agree: true,
+					dns_challenge:     true,
+					dns_provider:      'mock'
+				},
+				provider: 'letsencrypt'
+			}
+		}).then((data) => {
+			cy.validateSwaggerSchema('post', 201, '/nginx/certificates', data);
+			expect(data.meta.dns_provider).to.be.equal('mock');
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/system/test-mode/dns'
+			}).then((dns) => {
+				const history = dns.history.filter((row) => row.name === '_acme-challenge.website3.example.com');
+				expect(history.map((row) => row.action)).to.include.members(['present', 'cleanup']);
+				expect(dns.records).to.not.have.property('_acme-challenge.website3.example.com');
+			});
+		});
+	});
+
+	it('Should not create a certificate with the mock DNS provider outside of test mode', function() {
+		if (testMode) {
+			this.skip();
+		}
+
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/nginx/certificates',
+			data:          {
+				domain_names: [
+					'website3.example.com'
+				],
+				meta: {
+					letsencrypt_email: 'admin@example.com',
+					letsencrypt_agree: true,
+					dns_challenge:     true,
+					dns_provider:      'mock'
+				},
+				provider: 'letsencrypt'
+			},
+			returnOnError: true
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+});
@@ -45,4 +45,144 @@ describe('Users endpoints', () => {
 		});
 	});
 
+	it('Should say which permission a user is missing when denied', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/users',
+			data:  {
+				name:     'Viewer',
+				nickname: 'viewer',
+				email:    'viewer-' + Date.now() + '@example.com',
+				roles:    []
+			}
+		}).then((user) => {
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/users/' + user.id + '/permissions',
+				data:  {
+					visibility:  'all',
+					proxy_hosts: 'view'
+				}
+			});
+
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/users/' + user.id + '/login'
+			}).then((login) => {
+				cy.task('backendApiPost', {
+					token:         login.token,
+					path:          '/api/nginx/proxy-hosts',
+					data:          {
+						domain_names: ['denied.example.com'],
+						forward_host: '1.1.1.1',
+						forward_port: 80
+					},
+					returnOnError: true
+				}).then((data) => {
+					expect(data.error.code).to.equal(403);
+					expect(data.error.reason).to.equal('permission_denied');
+					expect(data.error.denied.permission).to.equal('proxy_hosts:create');
+					expect(data.error.denied.missing).to.deep.equal(['proxy_hosts:manage']);
+				});
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/metrics/denials'
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/metrics/denials', data);
+				const denial = data.find((item) => item.user_id === user.id);
+				expect(denial.route).to.equal('POST /nginx/proxy-hosts');
+				expect(denial.count).to.equal(1);
+			});
+		});
+	});
+
+	it('Should follow permissions changed after a token was issued', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/users',
+			data:  {
+				name:     'Editor',
+				nickname: 'editor',
+				email:    'editor-' + Date.now() + '@example.com',
+				roles:    []
+			}
+		}).then((user) => {
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/users/' + user.id + '/permissions',
+				data:  {
+					visibility:  'all',
+					proxy_hosts: 'view'
+				}
+			});
+
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/users/' + user.id + '/login'
+			}).then((login) => {
+				const payload = JSON.parse(Cypress.Buffer.from(login.token.split('.')[1], 'base64').toString());
+				expect(payload.caps.permissions.proxy_hosts).to.equal('view');
+
+				cy.task('backendApiPut', {
+					token: token,
+					path:  '/api/users/' + user.id + '/permissions',
+					data:  {
+						visibility:  'all',
+						proxy_hosts: 'manage'
+					}
+				});
+
+				cy.task('backendApiPost', {
+					token: login.token,
+					path:  '/api/nginx/proxy-hosts',
+					data:  {
+						domain_names: ['editor-' + Date.now() + '.example.com'],
+						forward_host: '1.1.1.1',
+						forward_port: 80
+					}
+				}).then((data) => {
+					cy.validateSwaggerSchema('post', 201, '/nginx/proxy-hosts', data);
+				});
+			});
+		});
+	});
+
+	it('Should answer a user in their language', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/users',
+			data:  {
+				name:     'Reader',
+				nickname: 'reader',
+				email:    'reader-' + Date.now() + '@example.com',
+				roles:    [],
+				locale:   'zh-CN'
+			}
+		}).then((user) => {
+			cy.validateSwaggerSchema('post', 201, '/users', user);
+			expect(user.locale).to.equal('zh-CN');
+
+			cy.task('backendApiPost', {
+				token: token,
+				path:  '/api/users/' + user.id + '/login'
+			}).then((login) => {
+				cy.task('backendApiPost', {
+					token:         login.token,
+					path:          '/api/nginx/proxy-hosts',
+					data:          {
+						domain_names: ['reader.example.com'],
+						forward_host: '1.1.1.1',
+						forward_port: 80
+					},
+					returnOnError: true
+				}).then((data) => {
+					expect(data.error.code).to.equal(403);
+					expect(data.error.message).to.equal('没有权限');
+				});
+			});
+		});
+	});
+
 });
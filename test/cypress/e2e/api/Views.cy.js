@@ -0,0 +1,124 @@
+/// <reference types="cypress" />
+
+describe('Saved views endpoints', () => {
+	let token;
+
+	before(() => {
+		cy.getToken().then((tok) => {
+			token = tok;
+		});
+	});
+
+	it('Should be able to save a view and list hosts with it', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/views',
+			data:  {
+				name:        'Enabled hosts, newest first',
+				object_type: 'proxy-host',
+				filter:      {
+					enabled: true,
+				},
+				sort: {
+					field:     'created_on',
+					direction: 'desc',
+				},
+			},
+		}).then((view) => {
+			cy.validateSwaggerSchema('post', 201, '/views', view);
+			expect(view.filter.enabled).to.be.equal(true);
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/proxy-hosts?view=' + view.id,
+			}).then((rows) => {
+				cy.validateSwaggerSchema('get', 200, '/nginx/proxy-hosts', rows);
+				rows.forEach((row) => {
+					expect(row.enabled).to.be.equal(true);
+				});
+				for (let i = 1; i < rows.length; i++) {
+					expect(new Date(rows[i - 1].created_on).getTime()).to.be.at.least(new Date(rows[i].created_on).getTime());
+				}
+			});
+
+			cy.task('backendApiPut', {
+				token: token,
+				path:  '/api/views/' + view.id,
+				data:  {
+					name: 'Enabled hosts',
+				},
+			}).then((data) => {
+				cy.validateSwaggerSchema('put', 200, '/views/{viewID}', data);
+				expect(data.name).to.be.equal('Enabled hosts');
+			});
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/views?object_type=proxy-host',
+			}).then((data) => {
+				cy.validateSwaggerSchema('get', 200, '/views', data);
+				expect(data.filter((row) => row.id === view.id)).to.have.lengthOf(1);
+			});
+
+			// A view is only for the list it was saved for
+			cy.task('backendApiGet', {
+				token:         token,
+				path:          '/api/nginx/certificates?view=' + view.id,
+				returnOnError: true,
+			}).then((data) => {
+				expect(data.error.code).to.equal(400);
+			});
+
+			cy.task('backendApiDelete', {
+				token: token,
+				path:  '/api/views/' + view.id,
+			}).then((data) => {
+				cy.validateSwaggerSchema('delete', 200, '/views/{viewID}', data);
+				expect(data).to.be.equal(true);
+			});
+		});
+	});
+
+	it('Should be able to save a view of certificates expiring soon', function() {
+		cy.task('backendApiPost', {
+			token: token,
+			path:  '/api/views',
+			data:  {
+				name:        'Expiring soon',
+				object_type: 'certificate',
+				filter:      {
+					expires_within_days: 30,
+				},
+				sort: {
+					field: 'expires_on',
+				},
+			},
+		}).then((view) => {
+			cy.validateSwaggerSchema('post', 201, '/views', view);
+
+			cy.task('backendApiGet', {
+				token: token,
+				path:  '/api/nginx/certificates?view=' + view.id,
+			}).then((rows) => {
+				cy.validateSwaggerSchema('get', 200, '/nginx/certificates', rows);
+			});
+		});
+	});
+
+	it('Should not save a view sorted by something the list does not have', function() {
+		cy.task('backendApiPost', {
+			token:         token,
+			path:          '/api/views',
+			data:          {
+				name:        'Streams by name',
+				object_type: 'stream',
+				sort:        {
+					field: 'nice_name',
+				},
+			},
+			returnOnError: true,
+		}).then((data) => {
+			expect(data.error.code).to.equal(400);
+		});
+	});
+});
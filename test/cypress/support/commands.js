@@ -53,6 +53,20 @@ Cypress.Commands.add('getToken', () => {
 	});
 });
 
+/**
+ * The number of database queries the backend has run, from its metrics
+ *
+ * @param {string}  token
+ */
+Cypress.Commands.add('getQueryCount', (token) => {
+	cy.task('backendApiGet', {
+		token: token,
+		path:  '/api/metrics'
+	}).then((text) => {
+		cy.wrap(parseInt(text.match(/^npm_db_queries_total (\d+)$/m)[1], 10));
+	});
+});
+
 // TODO: copied from v3, is this usable?
 Cypress.Commands.add('waitForCertificateStatus', (token, certID, expected, timeout = 60) => {
 	cy.log(`Waiting for certificate (${certID}) status (${expected}) timeout (${timeout})`);